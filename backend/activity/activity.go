@@ -0,0 +1,170 @@
+// Package activity folds a document's change events into the feed shown
+// to its collaborators: edits, comments, joins and renames, with runs of
+// the same thing by the same user close together shown as one item.
+package activity
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"backend/events"
+)
+
+// Kinds of item in the feed
+const (
+	KindEdits    = "edits"
+	KindComments = "comments"
+	KindJoins    = "joins"
+	KindRenamed  = "renamed"
+)
+
+// Window is the longest span of time one item folds entries over
+const Window = 5 * time.Minute
+
+// Event types the feed shows, and the kind of item each makes
+var kinds = map[string]string{
+	events.TypeDocumentUpdated: KindEdits,
+	events.TypeCommentAdded:    KindComments,
+	events.TypeUserJoined:      KindJoins,
+	events.TypeMetadataUpdated: KindRenamed,
+	events.TypeUserRenamed:     KindRenamed,
+}
+
+// Entry is a change event numbered by Seq, the audit entry it was recorded
+// as
+type Entry struct {
+	Seq   int64
+	Event events.Event
+}
+
+// Item is a run of Count events of one kind by one actor between From and
+// To, entries FirstSeq to LastSeq. Edits give the revisions they made; a
+// rename gives the new name in Details, under title for the document and
+// userName for the user.
+type Item struct {
+	Kind     string            `json:"kind"`
+	UserID   string            `json:"userId,omitempty"`
+	UserName string            `json:"userName,omitempty"`
+	Count    int               `json:"count"`
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	FirstSeq int64             `json:"firstSeq"`
+	LastSeq  int64             `json:"lastSeq"`
+	Revision *Revisions        `json:"revisions,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	Summary  string            `json:"summary"`
+}
+
+// Revisions is the range of revisions a run of edits made
+type Revisions struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// Folder builds items from entries given newest first, a page at a time
+type Folder struct {
+	items []Item
+}
+
+// Add folds entries, newest first and older than any added before, into
+// the feed. Events whose payload doesn't decode are left out.
+func (folder *Folder) Add(entries []Entry) {
+	for _, entry := range entries {
+		event := entry.Event
+		kind, shown := kinds[event.Type]
+		if !shown {
+			continue
+		}
+		var payload struct {
+			Revision int64  `json:"revision"`
+			Title    string `json:"title"`
+			UserName string `json:"user_name"`
+		}
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			continue
+		}
+		if last := len(folder.items) - 1; last >= 0 && folds(folder.items[last], kind, event) {
+			item := &folder.items[last]
+			item.Count++
+			item.From, item.FirstSeq = event.OccurredAt, entry.Seq
+			if item.Revision != nil && payload.Revision > 0 {
+				item.Revision.From = payload.Revision
+			}
+			continue
+		}
+		item := Item{
+			Kind:     kind,
+			UserID:   event.Actor,
+			Count:    1,
+			From:     event.OccurredAt,
+			To:       event.OccurredAt,
+			FirstSeq: entry.Seq,
+			LastSeq:  entry.Seq,
+		}
+		switch event.Type {
+		case events.TypeDocumentUpdated:
+			item.Revision = &Revisions{From: payload.Revision, To: payload.Revision}
+		case events.TypeMetadataUpdated:
+			item.Details = map[string]string{"title": payload.Title}
+		case events.TypeUserRenamed:
+			item.Details = map[string]string{"userName": payload.UserName}
+		}
+		folder.items = append(folder.items, item)
+	}
+}
+
+// folds reports whether event, older than the item, extends it. Renames
+// are each their own item.
+func folds(item Item, kind string, event events.Event) bool {
+	return item.Kind == kind && kind != KindRenamed && item.UserID == event.Actor && item.To.Sub(event.OccurredAt) <= Window
+}
+
+// Len is how many items there are so far. All but the last are complete;
+// the last may still fold older entries.
+func (folder *Folder) Len() int {
+	return len(folder.items)
+}
+
+// Items returns the items so far, newest first, with their summaries
+// naming users by name
+func (folder *Folder) Items(name func(userID string) string) []Item {
+	items := make([]Item, len(folder.items))
+	for i, item := range folder.items {
+		item.UserName = name(item.UserID)
+		item.Summary = summarize(item)
+		items[i] = item
+	}
+	return items
+}
+
+func summarize(item Item) string {
+	who := item.UserName
+	if who == "" {
+		who = "Someone"
+	}
+	switch item.Kind {
+	case KindEdits:
+		return fmt.Sprintf("%s made %s", who, plural(item.Count, "edit"))
+	case KindComments:
+		return fmt.Sprintf("%s left %s", who, plural(item.Count, "comment"))
+	case KindJoins:
+		if item.Count == 1 {
+			return who + " joined"
+		}
+		return fmt.Sprintf("%s joined %d times", who, item.Count)
+	case KindRenamed:
+		if title, ok := item.Details["title"]; ok {
+			return fmt.Sprintf("%s renamed the document to %q", who, title)
+		}
+		return fmt.Sprintf("%s is now called %q", who, item.Details["userName"])
+	}
+	return who
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
@@ -0,0 +1,192 @@
+// Package analysis runs the text of documents that have it enabled through
+// an external language service, such as LanguageTool, once nobody has
+// edited them for a while, and keeps the annotations it finds
+package analysis
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/document"
+)
+
+// Annotation is something the service found in a range of the plain text:
+// a spelling, grammar or style issue described by Message, with the
+// replacements it suggests, best first
+type Annotation struct {
+	Range        document.Range `json:"range"`
+	Message      string         `json:"message"`
+	Rule         string         `json:"rule,omitempty"`
+	Category     string         `json:"category,omitempty"`
+	Replacements []string       `json:"replacements,omitempty"`
+}
+
+// Report is the outcome of analysing a document at a revision
+type Report struct {
+	DocID       string       `json:"docId"`
+	Revision    int64        `json:"revision"`
+	AnalyzedAt  time.Time    `json:"analyzedAt"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// Analyzer is a language service. Ranges of the annotations it returns are
+// in runes of text.
+type Analyzer interface {
+	// Analyze annotates text written in language, a BCP 47 tag or empty
+	// when unknown
+	Analyze(ctx context.Context, text string, language string) ([]Annotation, error)
+}
+
+// Pipeline analyses every loaded document with analysis enabled once it
+// has been idle for a while, and keeps the latest report of each. A nil
+// Pipeline analyses nothing.
+type Pipeline struct {
+	documents *document.Registry
+	analyzer  Analyzer
+	idle      time.Duration
+	timeout   time.Duration
+	logger    *slog.Logger
+
+	mutex    sync.Mutex
+	reports  map[string]Report
+	analyzed map[string]int64
+	notify   func(Report)
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewPipeline analyses documents idle for idle, giving the analyzer up to
+// timeout for each
+func NewPipeline(documents *document.Registry, analyzer Analyzer, idle time.Duration, timeout time.Duration, logger *slog.Logger) *Pipeline {
+	return &Pipeline{
+		documents: documents,
+		analyzer:  analyzer,
+		idle:      idle,
+		timeout:   timeout,
+		logger:    logger,
+		reports:   make(map[string]Report),
+		analyzed:  make(map[string]int64),
+		done:      make(chan struct{}),
+	}
+}
+
+// OnReport registers fn to be called with every report whose annotations
+// differ from the previous one
+func (pipeline *Pipeline) OnReport(fn func(Report)) {
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+	pipeline.notify = fn
+}
+
+// Report returns the latest report of a document
+func (pipeline *Pipeline) Report(docID string) (Report, bool) {
+	if pipeline == nil {
+		return Report{}, false
+	}
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+	report, ok := pipeline.reports[docID]
+	return report, ok
+}
+
+// Forget drops the report of a document that is no longer loaded or no
+// longer analysed
+func (pipeline *Pipeline) Forget(docID string) {
+	if pipeline == nil {
+		return
+	}
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+	delete(pipeline.reports, docID)
+	delete(pipeline.analyzed, docID)
+}
+
+// Run analyses idle documents until Close is called
+func (pipeline *Pipeline) Run() {
+	ticker := time.NewTicker(max(pipeline.idle/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pipeline.AnalyzeIdle()
+		case <-pipeline.done:
+			return
+		}
+	}
+}
+
+// Close stops a running pipeline
+func (pipeline *Pipeline) Close() {
+	if pipeline != nil {
+		pipeline.once.Do(func() { close(pipeline.done) })
+	}
+}
+
+// AnalyzeIdle analyses every loaded document with analysis enabled that
+// changed since its last analysis and has been idle since
+func (pipeline *Pipeline) AnalyzeIdle() {
+	for _, doc := range pipeline.documents.All() {
+		select {
+		case <-pipeline.done:
+			return
+		default:
+		}
+		if !doc.Permissions().Analysis || time.Since(doc.UpdatedAt()) < pipeline.idle {
+			continue
+		}
+		pipeline.mutex.Lock()
+		analyzed, ok := pipeline.analyzed[doc.ID]
+		pipeline.mutex.Unlock()
+		if ok && analyzed == doc.Revision() {
+			continue
+		}
+		pipeline.Analyze(doc)
+	}
+}
+
+// Analyze analyses a document's current text and records the report. A
+// revision the service failed on isn't tried again until the next edit.
+func (pipeline *Pipeline) Analyze(doc *document.Document) {
+	content, revision := doc.Contents()
+	text := content.Text()
+
+	var annotations []Annotation
+	if strings.TrimSpace(text) != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), pipeline.timeout)
+		defer cancel()
+
+		var err error
+		annotations, err = pipeline.analyzer.Analyze(ctx, text, doc.Metadata().Language)
+		if err != nil {
+			pipeline.logger.Warn("Could not analyse document", "doc_id", doc.ID, "revision", revision, "error", err)
+			pipeline.mutex.Lock()
+			pipeline.analyzed[doc.ID] = revision
+			pipeline.mutex.Unlock()
+			return
+		}
+	}
+	report := Report{DocID: doc.ID, Revision: revision, AnalyzedAt: time.Now(), Annotations: []Annotation{}}
+	report.Annotations = append(report.Annotations, annotations...)
+
+	pipeline.mutex.Lock()
+	previous, analyzed := pipeline.reports[doc.ID]
+	pipeline.reports[doc.ID] = report
+	pipeline.analyzed[doc.ID] = revision
+	notify := pipeline.notify
+	pipeline.mutex.Unlock()
+
+	if notify != nil && (!analyzed || !slices.EqualFunc(previous.Annotations, report.Annotations, Annotation.equal)) {
+		notify(report)
+	}
+}
+
+func (annotation Annotation) equal(other Annotation) bool {
+	return annotation.Range == other.Range && annotation.Message == other.Message &&
+		annotation.Rule == other.Rule && slices.Equal(annotation.Replacements, other.Replacements)
+}
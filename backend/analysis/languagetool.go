@@ -0,0 +1,109 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf16"
+
+	"backend/document"
+)
+
+// Most replacements kept of each match
+const maxReplacements = 5
+
+// LanguageTool checks text with the /v2/check endpoint of a LanguageTool
+// server, self-hosted or the public API
+type LanguageTool struct {
+	url    string
+	client *http.Client
+}
+
+// NewLanguageTool talks to the LanguageTool server at baseURL, such as
+// "http://localhost:8010"
+func NewLanguageTool(baseURL string) *LanguageTool {
+	return &LanguageTool{url: strings.TrimSuffix(baseURL, "/") + "/v2/check", client: &http.Client{}}
+}
+
+type languageToolResponse struct {
+	Matches []struct {
+		Message      string `json:"message"`
+		Offset       int    `json:"offset"`
+		Length       int    `json:"length"`
+		Replacements []struct {
+			Value string `json:"value"`
+		} `json:"replacements"`
+		Rule struct {
+			ID       string `json:"id"`
+			Category struct {
+				ID string `json:"id"`
+			} `json:"category"`
+		} `json:"rule"`
+	} `json:"matches"`
+}
+
+func (tool *LanguageTool) Analyze(ctx context.Context, text string, language string) ([]Annotation, error) {
+	if language == "" {
+		language = "auto"
+	}
+	form := url.Values{"text": {text}, "language": {language}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tool.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := tool.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("languagetool request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("languagetool answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result languageToolResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding languagetool response: %w", err)
+	}
+
+	// LanguageTool counts UTF-16 code units, documents count runes
+	runes := runeOffsets(text)
+	annotations := make([]Annotation, 0, len(result.Matches))
+	for _, match := range result.Matches {
+		start, end := match.Offset, match.Offset+match.Length
+		if start < 0 || start > end || end >= len(runes) {
+			continue
+		}
+		annotation := Annotation{
+			Range:    document.Range{Start: runes[start], End: runes[end]},
+			Message:  match.Message,
+			Rule:     match.Rule.ID,
+			Category: match.Rule.Category.ID,
+		}
+		for _, replacement := range match.Replacements[:min(len(match.Replacements), maxReplacements)] {
+			annotation.Replacements = append(annotation.Replacements, replacement.Value)
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations, nil
+}
+
+// runeOffsets maps each UTF-16 offset into text, up to and including its
+// length, to the rune offset it falls in
+func runeOffsets(text string) []int {
+	offsets := make([]int, 0, len(text)+1)
+	i := 0
+	for _, r := range text {
+		for range utf16.RuneLen(r) {
+			offsets = append(offsets, i)
+		}
+		i++
+	}
+	return append(offsets, i)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/document"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Activity items returned per page, unless the limit query parameter asks
+// for fewer or more
+const (
+	defaultActivityPage = 20
+	maxActivityPage     = 100
+)
+
+// GetActivity returns a page of a document's activity feed, newest first,
+// to anyone signed in. The next page starts before the entry numbered
+// next, passed back as the before query parameter. since, the latest of an
+// earlier answer, asks only for what happened after it.
+func (handler *Handler) GetActivity(c *gin.Context) {
+	if _, ok := handler.session(c); !ok {
+		return
+	}
+	limit := defaultActivityPage
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxActivityPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number from 1 to " + strconv.Itoa(maxActivityPage)})
+			return
+		}
+		limit = parsed
+	}
+	var before, since int64
+	for name, cursor := range map[string]*int64{"before": &before, "since": &since} {
+		if value := c.Query(name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a positive entry number"})
+				return
+			}
+			*cursor = parsed
+		}
+	}
+
+	docID := c.Param("id")
+	if _, err := handler.Manager.Documents.Lookup(docID); err != nil {
+		if errors.Is(err, document.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+			return
+		}
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	page, err := handler.Manager.Activity(ctx, docID, before, since, limit)
+	if err != nil {
+		handler.Manager.Logger.Error("Could not read activity", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "activity unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"backend/chat"
+	"backend/document"
+	"backend/rbac"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterAdminRoutes adds the admin and moderation endpoints, each guarded
+// by the scope it needs. Tokens scoped to a tenant only see and moderate
+// that tenant's rooms, clients and documents, and are refused the
+// endpoints that reach across tenants.
+func (handler *Handler) RegisterAdminRoutes(router gin.IRouter, authorizer *rbac.Authorizer) {
+	admin := router.Group("/api/admin")
+	admin.GET("/rooms", authorizer.Require(rbac.ScopeAdminRead), handler.ListRooms)
+	admin.GET("/clients", authorizer.Require(rbac.ScopeAdminRead), handler.ListClients)
+	admin.GET("/documents", authorizer.Require(rbac.ScopeAdminRead), handler.ListDocuments)
+	admin.POST("/rooms/:docId/notice", authorizer.Require(rbac.ScopeModeration), handler.SendNotice)
+	admin.DELETE("/connections/:connId", authorizer.Require(rbac.ScopeModeration), handler.DisconnectClient)
+	admin.GET("/users/:userId/documents", authorizer.Require(rbac.ScopeImpersonate), refuseTenantScoped, handler.ImpersonateDocuments)
+	admin.GET("/users/:userId/impersonate", authorizer.Require(rbac.ScopeImpersonate), refuseTenantScoped, handler.ImpersonateRoom)
+	admin.POST("/compaction", authorizer.Require(rbac.ScopeMaintenance), refuseTenantScoped, handler.Compact)
+	admin.POST("/rooms/:docId/migrate", authorizer.Require(rbac.ScopeMaintenance), refuseTenantScoped, handler.MigrateRoom)
+	admin.POST("/rooms/:docId/handoff", authorizer.Require(rbac.ScopeMaintenance), refuseTenantScoped, handler.ReceiveRoom)
+	admin.POST("/drain", authorizer.Require(rbac.ScopeMaintenance), refuseTenantScoped, handler.Drain)
+	admin.GET("/backups", authorizer.Require(rbac.ScopeAdminRead), refuseTenantScoped, handler.ListBackups)
+	admin.POST("/backups", authorizer.Require(rbac.ScopeMaintenance), refuseTenantScoped, handler.CreateBackup)
+	admin.POST("/backups/:backupId/restore", authorizer.Require(rbac.ScopeMaintenance), refuseTenantScoped, handler.RestoreBackup)
+	admin.POST("/keys/rotate", authorizer.Require(rbac.ScopeMaintenance), refuseTenantScoped, handler.RotateKeys)
+	admin.GET("/webhooks/deliveries", authorizer.Require(rbac.ScopeAdminRead), refuseTenantScoped, handler.ListWebhookDeliveries)
+}
+
+// refuseTenantScoped rejects tokens scoped to a tenant from endpoints that
+// act on every tenant at once
+func refuseTenantScoped(c *gin.Context) {
+	if principal := rbac.PrincipalFrom(c); principal.Tenant != "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "tokens scoped to a tenant can't use this endpoint"})
+		return
+	}
+	c.Next()
+}
+
+// outsideTenant reports whether tenant is out of reach of the principal
+// authorized for the request
+func outsideTenant(c *gin.Context, tenant string) bool {
+	principal := rbac.PrincipalFrom(c)
+	return principal.Tenant != "" && principal.Tenant != tenant
+}
+
+// ListRooms lists the rooms on this node with their connected clients
+func (handler *Handler) ListRooms(c *gin.Context) {
+	rooms := slices.DeleteFunc(handler.Manager.RoomSummaries(), func(room socket.RoomSummary) bool {
+		return outsideTenant(c, room.Tenant)
+	})
+	c.JSON(http.StatusOK, gin.H{"rooms": rooms})
+}
+
+// ListClients lists every client connected to this node with its connect
+// time and message count
+func (handler *Handler) ListClients(c *gin.Context) {
+	clients := slices.DeleteFunc(handler.Manager.ClientSummaries(), func(client socket.ClientSummary) bool {
+		return outsideTenant(c, client.Tenant)
+	})
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// ListDocuments lists the documents loaded on this node and saved in the
+// store with their metadata, only those tagged with the tag query parameter
+// when it is given. Documents in the trash are left out, or listed alone
+// when trashed is true.
+func (handler *Handler) ListDocuments(c *gin.Context) {
+	summaries, err := handler.Manager.Documents.List(c.Request.Context())
+	if err != nil {
+		handler.Manager.Logger.Error("Could not list documents", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document store unavailable"})
+		return
+	}
+	trashed := c.Query("trashed") == "true"
+	summaries = slices.DeleteFunc(summaries, func(summary document.Summary) bool {
+		return summary.TrashedAt.IsZero() == trashed || outsideTenant(c, summary.Tenant)
+	})
+	if tag := c.Query("tag"); tag != "" {
+		summaries = slices.DeleteFunc(summaries, func(summary document.Summary) bool {
+			return !slices.ContainsFunc(summary.Metadata.Tags, func(t string) bool { return strings.EqualFold(t, tag) })
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"documents": summaries})
+}
+
+// SendNotice shows a server notice, such as planned maintenance, to
+// everyone in a room
+func (handler *Handler) SendNotice(c *gin.Context) {
+	var request struct {
+		Text string `json:"text"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with a text string"})
+		return
+	}
+
+	docID := c.Param("docId")
+	principal := rbac.PrincipalFrom(c)
+	if principal.Tenant != "" {
+		if _, err := handler.Manager.TenantDocument(principal.Tenant, docID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+			return
+		}
+	}
+	recipients, err := handler.Manager.SendNotice(docID, request.Text)
+	if errors.Is(err, chat.ErrEmptyMessage) || errors.Is(err, chat.ErrMessageTooLong) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "notice text must not be empty or longer than a chat message"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send notice"})
+		return
+	}
+	handler.Manager.Logger.Info("Server notice sent by admin", "doc_id", docID, "recipients", recipients,
+		"principal", principal.Name, "role", principal.Role)
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "recipients": recipients})
+}
+
+// DisconnectClient force closes a connection, for moderation. With ban, a
+// duration such as 1h, the user is also kept off this node for that long.
+func (handler *Handler) DisconnectClient(c *gin.Context) {
+	connID := c.Param("connId")
+	principal := rbac.PrincipalFrom(c)
+	var ban time.Duration
+	if value := c.Query("ban"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ban must be a positive duration such as 1h"})
+			return
+		}
+		ban = parsed
+	}
+	if principal.Tenant != "" {
+		// Connections of other tenants are as good as missing
+		if !slices.ContainsFunc(handler.Manager.ClientSummaries(), func(client socket.ClientSummary) bool {
+			return client.ConnID == connID && client.Tenant == principal.Tenant
+		}) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+			return
+		}
+	}
+	if !handler.Manager.Disconnect(connID, ban) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+	handler.Manager.Logger.Info("Client disconnected by admin", "conn_id", connID, "ban", ban,
+		"principal", principal.Name, "role", principal.Role)
+	c.Status(http.StatusNoContent)
+}
+
+// ImpersonateDocuments lists the documents a user has open or recently
+// opened, as they would see them
+func (handler *Handler) ImpersonateDocuments(c *gin.Context) {
+	principal := rbac.PrincipalFrom(c)
+	docIDs, err := handler.Manager.UserDocuments(c.Param("userId"), principal.Name)
+	if errors.Is(err, socket.ErrUnknownUser) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"userId": c.Param("userId"), "documents": docIDs})
+}
+
+// ImpersonateRoom upgrades to a read-only WebSocket view of the room given
+// by the doc query parameter, as the user sees it
+func (handler *Handler) ImpersonateRoom(c *gin.Context) {
+	principal := rbac.PrincipalFrom(c)
+	handler.Manager.HandleImpersonation(c.Writer, c.Request, c.Param("userId"), principal.Name)
+}
+
+// Compact trims op logs now instead of waiting for the next scheduled run:
+// the one of the document given by the docId query parameter, or of every
+// loaded document without one
+func (handler *Handler) Compact(c *gin.Context) {
+	principal := rbac.PrincipalFrom(c)
+	retention := handler.Manager.Retention()
+
+	documents, ops := 0, 0
+	if docID := c.Query("docId"); docID != "" {
+		doc, err := handler.Manager.Documents.Lookup(docID)
+		if errors.Is(err, document.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+			return
+		}
+		if err != nil {
+			handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+			return
+		}
+		if ops = doc.Compact(retention); ops > 0 {
+			documents = 1
+		}
+	} else {
+		documents, ops = handler.Manager.Documents.Compact(retention)
+	}
+
+	handler.Manager.Logger.Info("Op logs compacted by admin", "documents", documents, "ops", ops,
+		"principal", principal.Name, "role", principal.Role)
+	c.JSON(http.StatusOK, gin.H{"documents": documents, "ops": ops})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"backend/ratelimit"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout for backing store calls made while serving a request
+const requestTimeout = 5 * time.Second
+
+// Handler serves the REST API on top of the socket manager's state
+type Handler struct {
+	Manager *socket.WebSocketManager
+
+	clientErrors *ratelimit.Keyed
+	authAttempts *ratelimit.Keyed
+}
+
+func NewHandler(manager *socket.WebSocketManager) *Handler {
+	return &Handler{
+		Manager:      manager,
+		clientErrors: ratelimit.NewKeyed(manager.Config.Limits.ClientErrors),
+		authAttempts: ratelimit.NewKeyed(manager.Config.Limits.AuthAttempts),
+	}
+}
+
+func (handler *Handler) RegisterRoutes(router gin.IRouter) {
+	// Edits are only applied by the node owning the document, so requests
+	// that change one are forwarded to it before anything else
+	trash := router.Group("/api/documents", handler.forwardToOwner, handler.requireTenant)
+	trash.POST("/:id/trash", handler.TrashDocument)
+	trash.POST("/:id/restore", handler.RestoreDocument)
+	router.GET("/api/trash", handler.ListTrash)
+
+	documents := router.Group("/api/documents", handler.requireTenant, handler.refuseTrashed)
+	owned := router.Group("/api/documents", handler.forwardToOwner, handler.requireTenant, handler.refuseTrashed)
+	documents.POST("", handler.CreateDocument)
+	documents.POST("/import", handler.ImportDocument)
+	documents.GET("/:id/presence", handler.GetPresence)
+	documents.GET("/:id/seen", handler.GetSeenState)
+	documents.GET("/:id/attribution", handler.GetAttribution)
+	documents.GET("/:id/comments", handler.ListComments)
+	documents.GET("/:id/export", handler.ExportDocument)
+	documents.GET("/:id/links", handler.GetLinkReport)
+	documents.GET("/:id/duplicates", handler.GetDuplicates)
+	owned.POST("/:id/comments", handler.AddComment)
+	owned.POST("/:id/comments/:commentId/resolve", handler.ResolveComment)
+	documents.GET("/:id/changes", handler.ListChanges)
+	documents.GET("/:id/audit", handler.ListAudit)
+	documents.GET("/:id/activity", handler.GetActivity)
+	documents.GET("/:id/diff", handler.GetDiff)
+	documents.GET("/:id/replay", handler.Replay)
+	owned.POST("/:id/changes/accept", handler.AcceptChanges)
+	owned.POST("/:id/changes/reject", handler.RejectChanges)
+	owned.POST("/:id/snapshots", handler.CreateSnapshot)
+	owned.POST("/:id/attachments", handler.AddAttachment)
+	documents.POST("/:id/share", handler.ShareDocument)
+	owned.POST("/:id/merge", handler.MergeChanges)
+	owned.PUT("/:id/folder", handler.FileDocument)
+	// Notifications are dispatched by the node owning the document
+	owned.GET("/:id/notifications", handler.GetNotificationLevel)
+	owned.PUT("/:id/notifications", handler.SetNotificationLevel)
+	documents.GET("/:id/moderation", handler.ListModeration)
+	owned.POST("/:id/moderation", handler.Moderate)
+
+	router.GET("/api/snapshots/:snapshotId", handler.GetSnapshot)
+	router.GET("/api/snapshots/:snapshotId/export", handler.ExportSnapshot)
+	router.GET("/s/:snapshotId", handler.ViewSnapshot)
+	router.GET("/api/attachments/:docId/:attachmentId", handler.GetAttachment)
+
+	router.GET("/api/templates", handler.ListTemplates)
+	router.POST("/api/templates", handler.CreateTemplate)
+
+	router.GET("/api/folders/tree", handler.GetFolderTree)
+	router.POST("/api/folders", handler.CreateFolder)
+	router.PATCH("/api/folders/:id", handler.UpdateFolder)
+	router.DELETE("/api/folders/:id", handler.DeleteFolder)
+	router.POST("/api/workspace/takeout", handler.StartTakeout)
+	router.GET("/api/workspace/takeout/:id", handler.GetTakeout)
+	router.GET("/api/workspace/takeout/:id/archive", handler.DownloadTakeout)
+	router.POST("/api/workspace/import", handler.ImportWorkspace)
+
+	router.GET("/api/notifications", handler.ListNotifications)
+	router.POST("/api/notifications/read", handler.MarkNotificationsRead)
+
+	router.GET("/api/meta", handler.GetMeta)
+	router.POST("/api/users/register", handler.Register)
+	router.POST("/api/users/login", handler.Login)
+	router.GET("/api/users/me", handler.GetProfile)
+	router.PATCH("/api/users/me", handler.UpdateProfile)
+	router.GET("/api/auth/:provider/login", handler.StartLogin)
+	router.GET("/api/auth/:provider/callback", handler.FinishLogin)
+	router.POST("/api/telemetry/client-errors", handler.ReportClientError)
+
+	rooms := router.Group("/api/rooms/:id", handler.forwardToOwner)
+	rooms.POST("/messages", handler.PostRoomMessage)
+	rooms.POST("/poll", handler.OpenLongPoll)
+	rooms.GET("/poll", handler.Poll)
+}
+
+// PresenceResponse lists the users connected to a document
+type PresenceResponse struct {
+	DocID string              `json:"docId"`
+	Users []map[string]string `json:"users"`
+}
+
+// GetPresence lists the users connected to a document on any node
+func (handler *Handler) GetPresence(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	docID := c.Param("id")
+	users, err := handler.Manager.Presence.List(ctx, docID)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "presence store unavailable"})
+		return
+	}
+	if users == nil {
+		users = []map[string]string{}
+	}
+	c.JSON(http.StatusOK, PresenceResponse{DocID: docID, Users: users})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/blobs"
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout for storing an upload, which may go to a remote object store
+const uploadTimeout = 2 * time.Minute
+
+// Room left in an upload for the multipart framing around the file
+const multipartOverhead = 64 << 10
+
+// AddAttachment stores the file in the multipart form's file field as an
+// attachment of the document and returns it with the URL it is served at.
+// Everyone in the room is told with an attachment-added message.
+func (handler *Handler) AddAttachment(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	maxSize := handler.Manager.Config.Attachments.MaxSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "attachment is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a file upload is required"})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "attachment is too large"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), uploadTimeout)
+	defer cancel()
+
+	docID := c.Param("id")
+	attachment, err := handler.Manager.AddAttachment(ctx, docID, session, filepath.Base(header.Filename), file)
+	switch {
+	case errors.Is(err, document.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+	case errors.Is(err, document.ErrEditRestricted):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, socket.ErrAttachmentType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "attachments must be one of " + strings.Join(handler.Manager.Config.Attachments.AllowedTypes, ", "),
+		})
+	case err != nil:
+		handler.Manager.Logger.Error("Could not store attachment", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "could not store attachment"})
+	default:
+		c.JSON(http.StatusCreated, gin.H{"attachment": attachment})
+	}
+}
+
+// GetAttachment serves an attachment. Images are shown inline so documents
+// can embed them; anything else downloads.
+func (handler *Handler) GetAttachment(c *gin.Context) {
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), uploadTimeout)
+	defer cancel()
+
+	docID := c.Param("docId")
+	if doc, err := handler.Manager.Documents.Lookup(docID); err == nil && !doc.ClaimTenant(tenant) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	body, info, err := handler.Manager.OpenAttachment(ctx, docID, c.Param("attachmentId"))
+	if errors.Is(err, blobs.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load attachment", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "attachment store unavailable"})
+		return
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(info.ContentType, "image/") {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", info.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": info.Name}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", immutableCacheControl)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		handler.Manager.Logger.Warn("Could not send attachment", "doc_id", docID, "error", err)
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AttributionResponse is who wrote each character of a document's plain
+// text, as ranges covering it end to end
+type AttributionResponse struct {
+	DocID    string                 `json:"docId"`
+	Revision int64                  `json:"revision"`
+	Ranges   []document.AuthorRange `json:"ranges"`
+}
+
+// GetAttribution returns who wrote which text of a document, as the
+// room's attribution message does
+func (handler *Handler) GetAttribution(c *gin.Context) {
+	docID := c.Param("id")
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	ranges, revision := doc.Attribution()
+	c.JSON(http.StatusOK, AttributionResponse{DocID: docID, Revision: revision, Ranges: ranges})
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/document"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audit entries returned per page, unless the limit query parameter asks
+// for fewer or more
+const (
+	defaultAuditPage = 50
+	maxAuditPage     = 500
+)
+
+// ListAudit returns a page of a document's audit trail, newest first, to
+// its owner. The next page starts before the entry numbered next, passed
+// back as the before query parameter; there is no next on the last page.
+func (handler *Handler) ListAudit(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	limit := defaultAuditPage
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxAuditPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number from 1 to " + strconv.Itoa(maxAuditPage)})
+			return
+		}
+		limit = parsed
+	}
+	var before int64
+	if value := c.Query("before"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before must be a positive entry number"})
+			return
+		}
+		before = parsed
+	}
+
+	docID := c.Param("id")
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	if owner := doc.Permissions().Owner; owner == "" || owner != session.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the document's owner can read its audit trail"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	entries, err := handler.Manager.Audit.List(ctx, docID, before, limit)
+	if err != nil {
+		handler.Manager.Logger.Error("Could not list audit trail", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "audit trail unavailable"})
+		return
+	}
+	response := gin.H{"docId": docID, "entries": entries}
+	if len(entries) == limit && entries[len(entries)-1].Seq > 1 {
+		response["next"] = entries[len(entries)-1].Seq
+	}
+	c.JSON(http.StatusOK, response)
+}
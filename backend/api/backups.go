@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/backup"
+	"backend/rbac"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout for taking or restoring a backup on request
+const backupTimeout = 10 * time.Minute
+
+// ListBackups lists the backups kept, newest first
+func (handler *Handler) ListBackups(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	list, err := handler.Manager.ListBackups(ctx)
+	if err != nil {
+		handler.backupError(c, err)
+		return
+	}
+	if list == nil {
+		list = []backup.Info{}
+	}
+	c.JSON(http.StatusOK, gin.H{"backups": list})
+}
+
+// CreateBackup backs up every document now, outside the schedule
+func (handler *Handler) CreateBackup(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), backupTimeout)
+	defer cancel()
+
+	principal := rbac.PrincipalFrom(c)
+	info, err := handler.Manager.CreateBackup(ctx)
+	if err != nil {
+		handler.backupError(c, err)
+		return
+	}
+	handler.Manager.Logger.Info("Backup taken by admin", "id", info.ID, "documents", info.Documents,
+		"principal", principal.Name, "role", principal.Role)
+	c.JSON(http.StatusCreated, info)
+}
+
+type restoreRequest struct {
+	DocIDs []string `json:"docIds"`
+}
+
+// RestoreBackup puts documents back as they were in a backup: those of
+// docIds when the body gives any, every one otherwise
+func (handler *Handler) RestoreBackup(c *gin.Context) {
+	var request restoreRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with an optional docIds array"})
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), backupTimeout)
+	defer cancel()
+
+	principal := rbac.PrincipalFrom(c)
+	result, err := handler.Manager.RestoreBackup(ctx, c.Param("backupId"), request.DocIDs)
+	if err != nil {
+		handler.backupError(c, err)
+		return
+	}
+	handler.Manager.Logger.Info("Backup restored by admin", "id", result.BackupID, "restored", len(result.Restored),
+		"principal", principal.Name, "role", principal.Role)
+	c.JSON(http.StatusOK, result)
+}
+
+func (handler *Handler) backupError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, socket.ErrBackupsDisabled):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case errors.Is(err, backup.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		handler.Manager.Logger.Error("Backup failed", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "backup store unavailable"})
+	}
+}
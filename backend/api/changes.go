@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReviewChangesRequest picks the tracked changes to accept or reject: those
+// listed in IDs, or every pending one when All is set
+type ReviewChangesRequest struct {
+	IDs []string `json:"ids"`
+	All bool     `json:"all"`
+}
+
+// ListChanges returns a document's pending tracked changes
+func (handler *Handler) ListChanges(c *gin.Context) {
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return
+	}
+	docID := c.Param("id")
+	doc, err := handler.Manager.TenantDocument(tenant, docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"docId":        docID,
+		"trackChanges": doc.Permissions().TrackChanges,
+		"changes":      socket.TrackedChanges(doc),
+	})
+}
+
+// AcceptChanges keeps tracked changes for the caller's session, which must
+// be the document owner's
+func (handler *Handler) AcceptChanges(c *gin.Context) {
+	handler.reviewChanges(c, true)
+}
+
+// RejectChanges undoes tracked changes for the caller's session, which must
+// be the document owner's
+func (handler *Handler) RejectChanges(c *gin.Context) {
+	handler.reviewChanges(c, false)
+}
+
+func (handler *Handler) reviewChanges(c *gin.Context, accept bool) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	var request ReviewChangesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if request.All == (len(request.IDs) > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "either ids or all must be given"})
+		return
+	}
+	ids := request.IDs
+	if request.All {
+		ids = nil
+	}
+
+	docID := c.Param("id")
+	reviewed, err := handler.Manager.ReviewChanges(docID, session, ids, accept)
+	switch {
+	case errors.Is(err, document.ErrNotFound), errors.Is(err, document.ErrChangeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrNotReviewer):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrFrozen):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		handler.Manager.Logger.Error("Could not review tracked changes", "doc_id", docID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not review changes"})
+	default:
+		if reviewed == nil {
+			reviewed = []document.TrackedChange{}
+		}
+		c.JSON(http.StatusOK, gin.H{"changes": reviewed})
+	}
+}
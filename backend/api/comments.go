@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"backend/chat"
+	"backend/comments"
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddCommentRequest anchors a comment to [Start, End) of the document as
+// the client saw it at Revision
+type AddCommentRequest struct {
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Revision int64  `json:"revision"`
+	Text     string `json:"text"`
+}
+
+// CommentsResponse lists the comments on a document
+type CommentsResponse struct {
+	DocID    string             `json:"docId"`
+	Comments []comments.Comment `json:"comments"`
+}
+
+// CommentResponse is a comment as it stands after a change
+type CommentResponse struct {
+	Comment comments.Comment `json:"comment"`
+}
+
+// ListComments returns every comment on a document with current anchors
+func (handler *Handler) ListComments(c *gin.Context) {
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return
+	}
+	docID := c.Param("id")
+	list, err := handler.Manager.ListComments(tenant, docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, CommentsResponse{DocID: docID, Comments: list})
+}
+
+// AddComment creates a comment attributed to the caller's session
+func (handler *Handler) AddComment(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	var request AddCommentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	anchor := document.Range{Start: request.Start, End: request.End}
+	comment, err := handler.Manager.AddComment(c.Request.Context(), c.Param("id"), session, request.Revision, anchor, request.Text)
+	if err != nil {
+		commentError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, CommentResponse{Comment: comment})
+}
+
+// ResolveComment marks a comment resolved by the caller's session
+func (handler *Handler) ResolveComment(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	comment, err := handler.Manager.ResolveComment(c.Param("id"), c.Param("commentId"), session)
+	if err != nil {
+		commentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, CommentResponse{Comment: comment})
+}
+
+// session authenticates the request by the websocket session id passed as a
+// bearer token
+func (handler *Handler) session(c *gin.Context) (socket.Session, bool) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if found && token != "" {
+		if session, ok := handler.Manager.Sessions.Lookup(token); ok {
+			if _, err := handler.Manager.SessionTenant(c.Request.Host, session); err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return socket.Session{}, false
+			}
+			return session, true
+		}
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "a valid session is required"})
+	return socket.Session{}, false
+}
+
+func commentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, comments.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrNotFound), errors.Is(err, socket.ErrWrongTenant):
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+	case errors.Is(err, socket.ErrDocumentQuota):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrTrashed):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, comments.ErrAlreadyResolved), errors.Is(err, document.ErrRevisionUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrInvalidRange), errors.Is(err, document.ErrRevisionInTheFuture),
+		errors.Is(err, chat.ErrEmptyMessage), errors.Is(err, chat.ErrMessageTooLong):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update comment"})
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/document"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDiff compares a document's plain text at the revisions in the from
+// and to query parameters, to defaulting to the current one, for whoever
+// may export it. Inserted and deleted text is attributed to the author of
+// the edit that made the change.
+func (handler *Handler) GetDiff(c *gin.Context) {
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil || from < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a revision number"})
+		return
+	}
+
+	docID := c.Param("id")
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	if !handler.mayExport(c, doc) {
+		return
+	}
+
+	to := doc.Revision()
+	if value := c.Query("to"); value != "" {
+		to, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || to < from {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a revision number no lower than from"})
+			return
+		}
+	}
+	segments, err := doc.DiffRevisions(from, to)
+	switch {
+	case errors.Is(err, document.ErrRevisionInTheFuture), errors.Is(err, document.ErrInvalidRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, document.ErrRevisionUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		handler.Manager.Logger.Error("Could not diff revisions", "doc_id", docID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not diff revisions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "from": from, "to": to, "segments": segments})
+}
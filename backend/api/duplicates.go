@@ -0,0 +1,31 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDuplicates suggests existing documents that the given one
+// substantially duplicates, most similar first
+func (handler *Handler) GetDuplicates(c *gin.Context) {
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return
+	}
+	docID := c.Param("id")
+	duplicates, err := handler.Manager.FindDuplicates(docID, tenant)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "duplicates": duplicates})
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/audit"
+	"backend/document"
+	"backend/export"
+	"backend/richtext"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportDocument streams the server's copy of a document in the format
+// given by the format query parameter. With changes=show, pending tracked
+// changes are rendered as insertions and deletions; by default the content
+// is exported as it stands, as if they were all accepted.
+func (handler *Handler) ExportDocument(c *gin.Context) {
+	format, err := export.Lookup(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "format must be one of " + strings.Join(export.Names(), ", "),
+		})
+		return
+	}
+	changes := c.DefaultQuery("changes", "hide")
+	if changes != "hide" && changes != "show" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "changes must be hide or show"})
+		return
+	}
+
+	docID := c.Param("id")
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	if !handler.mayExport(c, doc) {
+		return
+	}
+	content, revision := doc.Snapshot()
+	if changes == "show" {
+		redline, redlineRevision := doc.Redline()
+		content, revision = richtext.ToHTML(redline), redlineRevision
+	}
+	handler.Manager.Audit.Record(audit.Entry{
+		Action:   audit.ActionExported,
+		DocID:    docID,
+		UserID:   handler.caller(c),
+		Revision: revision,
+		Details:  map[string]string{"format": format.Name, "changes": changes},
+	})
+	handler.writeExport(c, format, "attachment", docID, doc.Metadata(), content, revision)
+}
+
+// mayExport checks the document's export policy against the caller's
+// session, if any, and answers with 401 or 403 when it doesn't allow them
+func (handler *Handler) mayExport(c *gin.Context, doc *document.Document) bool {
+	return handler.mayExportAs(c, doc, handler.caller(c))
+}
+
+// mayExportAs is mayExport for a caller already identified as userID
+func (handler *Handler) mayExportAs(c *gin.Context, doc *document.Document, userID string) bool {
+	capabilities := doc.Capabilities(userID)
+	if capabilities.Export {
+		return true
+	}
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "a valid session is required to export this document"})
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "exporting this document is restricted to its " + capabilities.ExportPolicy})
+	return false
+}
+
+// caller returns the user of the caller's session, or nothing when it
+// didn't present a valid one
+func (handler *Handler) caller(c *gin.Context) string {
+	if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found {
+		if session, ok := handler.Manager.Sessions.Lookup(token); ok {
+			if _, err := handler.Manager.SessionTenant(c.Request.Host, session); err == nil {
+				return session.UserID
+			}
+		}
+	}
+	return ""
+}
+
+// writeExport renders content in format as the response, shown inline or
+// downloaded as an attachment named after docID
+func (handler *Handler) writeExport(c *gin.Context, format export.Format, disposition string, docID string, metadata document.Metadata, content string, revision int64) {
+	writeExportHeaders(c, format, disposition, docID, revision)
+
+	// Headers are already sent, so a failure part way can only be logged
+	w := bufio.NewWriter(c.Writer)
+	err := format.Render(w, socket.ExportInfo(docID, metadata), content)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		handler.Manager.Logger.Warn("Export failed", "doc_id", docID, "format", format.Name, "error", err)
+	}
+}
+
+func writeExportHeaders(c *gin.Context, format export.Format, disposition string, docID string, revision int64) {
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": format.Filename(docID),
+	}))
+	c.Header("X-Document-Revision", strconv.FormatInt(revision, 10))
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/folders"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createFolderRequest struct {
+	Name        string              `json:"name"`
+	ParentID    string              `json:"parentId"`
+	Permissions folders.Permissions `json:"permissions"`
+}
+
+// CreateFolder adds a folder to the caller's workspace, at the root or
+// under parentId
+func (handler *Handler) CreateFolder(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request createFolderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a JSON object"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	folder, err := handler.Manager.CreateFolder(ctx, session.UserID, folders.Folder{
+		Name:        request.Name,
+		ParentID:    request.ParentID,
+		Permissions: request.Permissions,
+	})
+	if err != nil {
+		handler.folderError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"folder": folder})
+}
+
+type updateFolderRequest struct {
+	Name        *string              `json:"name"`
+	ParentID    *string              `json:"parentId"`
+	Permissions *folders.Permissions `json:"permissions"`
+}
+
+// UpdateFolder renames a folder, moves it under parentId ("" for the
+// root) or replaces the permissions it passes down to its documents
+func (handler *Handler) UpdateFolder(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request updateFolderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a JSON object"})
+		return
+	}
+	if request.Name == nil && request.ParentID == nil && request.Permissions == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name, parentId or permissions is required"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	folder, elsewhere, err := handler.Manager.UpdateFolder(ctx, session.UserID, c.Param("id"), socket.FolderUpdate{
+		Name:        request.Name,
+		ParentID:    request.ParentID,
+		Permissions: request.Permissions,
+	})
+	if err != nil {
+		handler.folderError(c, err)
+		return
+	}
+	// Documents another node owns take the folder's permissions there
+	for _, filed := range elsewhere {
+		if err := handler.Manager.RefileOnOwner(c.Request, filed); err != nil {
+			handler.Manager.Logger.Warn("Could not apply folder permissions on the document's owner", "doc_id", filed.DocID, "owner", filed.Owner.ID, "error", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"folder": folder})
+}
+
+// DeleteFolder removes an empty folder from the caller's workspace
+func (handler *Handler) DeleteFolder(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	if err := handler.Manager.DeleteFolder(ctx, session.UserID, c.Param("id")); err != nil {
+		handler.folderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetFolderTree returns the caller's workspace nested for the sidebar:
+// documents created through the API or filed with PUT
+// /api/documents/:id/folder, in their folders
+func (handler *Handler) GetFolderTree(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	tree, err := handler.Manager.WorkspaceTree(ctx, session.UserID)
+	if err != nil {
+		handler.folderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+type fileDocumentRequest struct {
+	FolderID string `json:"folderId"`
+}
+
+// FileDocument moves one of the caller's documents into a folder of their
+// workspace, or to its root when folderId is empty. The document takes the
+// permissions the folder passes down.
+func (handler *Handler) FileDocument(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request fileDocumentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a JSON object"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	docID := c.Param("id")
+	if err := handler.Manager.FileDocument(ctx, session.UserID, docID, request.FolderID); err != nil {
+		handler.folderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "folderId": request.FolderID})
+}
+
+func (handler *Handler) folderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, folders.ErrNotFound), errors.Is(err, document.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, folders.ErrInvalid), errors.Is(err, folders.ErrCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, folders.ErrNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrNotOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the document's owner can file it"})
+	default:
+		handler.Manager.Logger.Error("Could not update workspace", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "workspace unavailable"})
+	}
+}
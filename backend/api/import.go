@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/document"
+	"backend/ids"
+	"backend/importer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Workspace imports upload every image of the archive, so they get longer
+// than other uploads
+const importTimeout = 10 * time.Minute
+
+// ImportDocument replaces a document with an uploaded Markdown, HTML or
+// plain text file. The multipart form carries the file, an optional format
+// overriding the file extension and an optional docId; without one a new
+// document is created.
+func (handler *Handler) ImportDocument(c *gin.Context) {
+	// The upload is kept to forward the request to the node owning the
+	// document the form names, which checks the session itself
+	upload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, handler.Manager.Config.Limits.MaxChunkedSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not read the upload"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(upload))
+	docID := c.PostForm("docId")
+	if docID != "" {
+		c.Request.Body = io.NopCloser(bytes.NewReader(upload))
+		if handler.Manager.ForwardToOwner(c.Writer, c.Request, docID) {
+			return
+		}
+	}
+
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a file upload is required"})
+		return
+	}
+	defer file.Close()
+
+	format := c.PostForm("format")
+	if format == "" {
+		format = importer.FormatFromFilename(header.Filename)
+	}
+	content, err := importer.Convert(format, file)
+	if errors.Is(err, importer.ErrUnsupportedFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of md, html, txt"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not read the uploaded document"})
+		return
+	}
+
+	created := docID == ""
+	if created {
+		docID = ids.NewUUID()
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+	if _, err := handler.Manager.OpenTenantDocument(ctx, session.Tenant, docID, false); err != nil {
+		handler.tenantDocumentError(c, docID, err)
+		return
+	}
+	revision, err := handler.Manager.ReplaceContent(docID, session, content)
+	if handler.editLimited(c, err) {
+		return
+	}
+	if errors.Is(err, document.ErrEditRestricted) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	response := gin.H{"docId": docID, "revision": revision}
+	if created {
+		// Suggest existing documents the upload duplicates before the
+		// workspace ends up with both
+		if duplicates, err := handler.Manager.FindDuplicates(docID, session.Tenant); err == nil {
+			response["duplicates"] = duplicates
+		}
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// ImportWorkspace recreates a workspace exported from another editor in
+// the caller's: the multipart form carries the zip archive as file and the
+// editor it came from as source, one of notion, gdocs or paper. The
+// response reports on each folder, document and image of the archive.
+func (handler *Handler) ImportWorkspace(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	maxSize := handler.Manager.Config.Attachments.MaxImportSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "archive is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a file upload is required"})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "archive is too large"})
+		return
+	}
+
+	archive, err := importer.OpenArchive(c.PostForm("source"), file, header.Size)
+	if errors.Is(err, importer.ErrUnsupportedSource) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be one of " + strings.Join(importer.Sources(), ", ")})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), importTimeout)
+	defer cancel()
+	baseURL := handler.Manager.Proxies.Scheme(c.Request) + "://" + c.Request.Host
+	result := handler.Manager.ImportWorkspace(ctx, session, archive, baseURL)
+	handler.Manager.Logger.Info("Workspace imported", "user_id", session.UserID, "source", result.Source, "created", result.Created, "failed", result.Failed)
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/rbac"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RotateKeys reseals every document this node owns and every snapshot
+// with the active encryption key, in the background. The audit trail of
+// each document records when it was resealed.
+func (handler *Handler) RotateKeys(c *gin.Context) {
+	principal := rbac.PrincipalFrom(c)
+	active, err := handler.Manager.RotateKeys(principal.Name)
+	switch {
+	case errors.Is(err, socket.ErrEncryptionDisabled):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, socket.ErrRotationRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	handler.Manager.Logger.Info("Key rotation started by admin", "active_key", active,
+		"principal", principal.Name, "role", principal.Role)
+	c.JSON(http.StatusAccepted, gin.H{"activeKey": active})
+}
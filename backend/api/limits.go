@@ -0,0 +1,28 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// editLimited answers an edit refused for one of the document's limits,
+// 413 for its size and 429 for its rate, and reports whether err was one
+func (handler *Handler) editLimited(c *gin.Context, err error) bool {
+	retryAfter, limited := handler.Manager.EditLimited(err)
+	switch {
+	case !limited:
+		return false
+	case retryAfter > 0:
+		c.Header("Retry-After", socket.RetryAfterSeconds(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("documents are limited to %d characters", handler.Manager.Config.Limits.MaxDocumentSize),
+		})
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLinkReport returns the broken links found in a document by the last
+// link check
+func (handler *Handler) GetLinkReport(c *gin.Context) {
+	report, ok := handler.Manager.Links.Report(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "the document's links have not been checked yet"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenLongPoll opens a long polling connection to a room for clients that
+// can use neither WebSockets nor event streams. The connId it answers with
+// names the connection in polls and posted messages, and sessionId is the
+// session to send them with.
+func (handler *Handler) OpenLongPoll(c *gin.Context) {
+	client, err := handler.Manager.OpenLongPoll(c.Writer, c.Request, c.Param("id"))
+	var moved *socket.RoomMovedError
+	var refused *socket.CloseError
+	switch {
+	case errors.Is(err, socket.ErrOriginNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.As(err, &moved):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "url": moved.URL})
+	case errors.As(err, &refused):
+		if refused.RetryAfter > 0 {
+			c.Header("Retry-After", socket.RetryAfterSeconds(refused.RetryAfter))
+		}
+		c.JSON(refused.Reason.Status(), gin.H{"error": err.Error(), "code": refused.Reason.Name, "retry": refused.Reason.Retry})
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"connId": client.ConnID, "sessionId": client.SessionID})
+	}
+}
+
+// Poll answers with the messages queued for a long polling connection,
+// waiting for one when there are none yet. A 410 means the server closed
+// the connection and the client has to open a new one.
+func (handler *Handler) Poll(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	messages, err := handler.Manager.Poll(c.Request.Context(), c.Param("id"), c.Query("connId"), session.ID)
+	switch {
+	case errors.Is(err, socket.ErrUnknownConnection):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, socket.ErrAlreadyPolling):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, socket.ErrConnectionClosed):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, context.Canceled):
+		// The client went away mid-poll, there is no one to answer
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not poll"})
+	default:
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"messages": messages})
+	}
+}
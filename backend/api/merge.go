@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/richtext"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type mergeRequest struct {
+	BaseRevision *int64           `json:"baseRevision"`
+	Changes      []richtext.Delta `json:"changes"`
+}
+
+// mergedChange is a change of a merge as it was applied
+type mergedChange struct {
+	Revision int64          `json:"revision"`
+	Change   richtext.Delta `json:"change"`
+}
+
+// MergeChanges reconciles edits a client made while offline: changes made
+// one after another on top of baseRevision are transformed past whatever
+// was applied since and applied in turn. The response has each change as
+// applied with its revision, and the resulting content and revision for
+// the client to continue from.
+func (handler *Handler) MergeChanges(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, handler.Manager.Config.Limits.MaxChunkedSize)
+	var request mergeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a JSON object with delta changes"})
+		return
+	}
+	if request.BaseRevision == nil || *request.BaseRevision < 0 || len(request.Changes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "baseRevision and at least one change are required"})
+		return
+	}
+
+	docID := c.Param("id")
+	ops, changes, err := handler.Manager.MergeChanges(docID, session, *request.BaseRevision, request.Changes)
+	if handler.editLimited(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, document.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	case errors.Is(err, document.ErrEditRestricted), errors.Is(err, socket.ErrMuted):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, richtext.ErrInvalidDelta), errors.Is(err, richtext.ErrLengthMismatch),
+		errors.Is(err, document.ErrRevisionInTheFuture):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, document.ErrRevisionUnavailable), errors.Is(err, document.ErrFrozen):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		handler.Manager.Logger.Error("Could not merge changes", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+
+	merged := make([]mergedChange, len(ops))
+	for i, op := range ops {
+		merged[i] = mergedChange{Revision: op.Revision, Change: changes[i]}
+	}
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	content, revision := doc.Contents()
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "revision": revision, "content": content, "merged": merged})
+}
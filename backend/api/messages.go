@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PostRoomMessage takes a message from an event stream or long polling
+// client, named by the connId it was given when connecting, as if it had
+// arrived over a WebSocket. Problems with the message itself are reported
+// back the way other messages reach the client.
+func (handler *Handler) PostRoomMessage(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	message, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, handler.Manager.Config.Limits.MaxMessageSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "message is too large, use chunk messages for larger payloads"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not read the message"})
+		return
+	}
+
+	err = handler.Manager.PostMessage(c.Param("id"), c.Query("connId"), session.ID, message)
+	switch {
+	case errors.Is(err, socket.ErrUnknownConnection):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, socket.ErrInboxFull):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not deliver the message"})
+	default:
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// forwardToOwner hands requests for a room or document over to the node
+// that owns the document, when that isn't this one
+func (handler *Handler) forwardToOwner(c *gin.Context) {
+	if handler.Manager.ForwardToOwner(c.Writer, c.Request, c.Param("id")) {
+		c.Abort()
+	}
+}
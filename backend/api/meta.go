@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"backend/buildinfo"
+	"backend/events"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type metaResponse struct {
+	Build     buildinfo.Info `json:"build"`
+	Features  metaFeatures   `json:"features"`
+	Limits    metaLimits     `json:"limits"`
+	Protocols metaProtocols  `json:"protocols"`
+}
+
+// metaFeatures are the optional parts of the server that are configured
+type metaFeatures struct {
+	Persistence bool `json:"persistence"`
+	Presence    bool `json:"sharedPresence"`
+	Events      bool `json:"events"`
+	TLS         bool `json:"tls"`
+	AdminAPI    bool `json:"adminApi"`
+	LinkCheck   bool `json:"linkCheck"`
+	Compaction  bool `json:"compaction"`
+	Compression bool `json:"compression"`
+	Recording   bool `json:"recording"`
+	Email       bool `json:"email"`
+
+	// LoginProviders are the providers accounts can sign in with
+	LoginProviders []string `json:"loginProviders"`
+}
+
+type metaLimits struct {
+	MaxMessageSize  int64                    `json:"maxMessageSize"`
+	MaxDocumentSize int64                    `json:"maxDocumentSize"`
+	MaxChatLength   int                      `json:"maxChatLength"`
+	RateLimits      map[string]metaRateLimit `json:"rateLimits"`
+	ClientErrors    metaRateLimit            `json:"clientErrorReports"`
+	MuteSeconds     float64                  `json:"muteSeconds"`
+	HistorySize     int                      `json:"historySize"`
+	ChatHistorySize int                      `json:"chatHistorySize"`
+	MaxAttachment   int64                    `json:"maxAttachmentSize"`
+	AttachmentTypes []string                 `json:"attachmentTypes"`
+}
+
+type metaRateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type metaProtocols struct {
+	Version            int                  `json:"version"`
+	Encodings          []socket.Encoding    `json:"encodings"`
+	EventSchemaVersion int                  `json:"eventSchemaVersion"`
+	CloseCodes         []socket.CloseReason `json:"closeCodes"`
+}
+
+// GetMeta describes the running server: its build, what is enabled and
+// the limits clients are held to, so they can adapt without guessing.
+// Nothing secret is included and the endpoint is public.
+func (handler *Handler) GetMeta(c *gin.Context) {
+	cfg := handler.Manager.Config
+
+	rateLimits := make(map[string]metaRateLimit, len(cfg.Limits.RateLimits))
+	for msgType, limit := range cfg.Limits.RateLimits {
+		rateLimits[msgType] = metaRateLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	loginProviders := make([]string, 0, len(handler.Manager.Logins))
+	for name := range handler.Manager.Logins {
+		loginProviders = append(loginProviders, name)
+	}
+	sort.Strings(loginProviders)
+
+	c.JSON(http.StatusOK, metaResponse{
+		Build: buildinfo.Get(),
+		Features: metaFeatures{
+			Persistence: cfg.StorageDSN != "",
+			Presence:    cfg.RedisURL != "",
+			Events:      len(cfg.Kafka.Brokers) > 0,
+			TLS:         cfg.TLS.Enabled(),
+			AdminAPI:    len(cfg.APITokens) > 0,
+			LinkCheck:   cfg.LinkCheck.Interval > 0,
+			Compaction:  cfg.Compaction.Interval > 0,
+			Compression: cfg.Compression.Enabled,
+			Recording:   cfg.Recording.Percent > 0,
+			Email:       cfg.Mail.Host != "",
+
+			LoginProviders: loginProviders,
+		},
+		Limits: metaLimits{
+			MaxMessageSize:  cfg.Limits.MaxMessageSize,
+			MaxDocumentSize: cfg.Limits.MaxChunkedSize,
+			MaxChatLength:   cfg.Limits.MaxChatLength,
+			RateLimits:      rateLimits,
+			ClientErrors:    metaRateLimit{Rate: cfg.Limits.ClientErrors.Rate, Burst: cfg.Limits.ClientErrors.Burst},
+			MuteSeconds:     cfg.Limits.MuteDuration.Seconds(),
+			HistorySize:     cfg.Limits.HistorySize,
+			ChatHistorySize: cfg.Limits.ChatHistorySize,
+			MaxAttachment:   cfg.Attachments.MaxSize,
+			AttachmentTypes: cfg.Attachments.AllowedTypes,
+		},
+		Protocols: metaProtocols{
+			Version:            socket.ProtocolVersion,
+			Encodings:          socket.Encodings,
+			EventSchemaVersion: events.SchemaVersion,
+			CloseCodes:         socket.CloseReasons,
+		},
+	})
+}
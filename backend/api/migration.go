@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/rbac"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// migrationRequest names the node rooms move to: Target is where this node
+// reaches it, PublicURL where clients do when that differs
+type migrationRequest struct {
+	Target    string `json:"target"`
+	PublicURL string `json:"publicUrl"`
+}
+
+func bindMigration(c *gin.Context) (migrationRequest, bool) {
+	var request migrationRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with a target URL"})
+		return request, false
+	}
+	return request, true
+}
+
+// MigrateRoom hands a room over to another node and sends its clients there
+func (handler *Handler) MigrateRoom(c *gin.Context) {
+	request, ok := bindMigration(c)
+	if !ok {
+		return
+	}
+
+	docID := c.Param("docId")
+	principal := rbac.PrincipalFrom(c)
+	clients, err := handler.Manager.MigrateRoom(c.Request.Context(), docID, request.Target, request.PublicURL)
+	switch {
+	case errors.Is(err, document.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, socket.ErrRoomMigrating), errors.Is(err, socket.ErrNoMigrationToken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		handler.Manager.Logger.Warn("Room migration failed", "doc_id", docID, "target", request.Target, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	handler.Manager.Logger.Info("Room migrated by admin", "doc_id", docID, "target", request.Target,
+		"principal", principal.Name, "role", principal.Role)
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "clients": clients})
+}
+
+// Drain migrates every room on this node to another node, ahead of taking
+// this one down
+func (handler *Handler) Drain(c *gin.Context) {
+	request, ok := bindMigration(c)
+	if !ok {
+		return
+	}
+
+	principal := rbac.PrincipalFrom(c)
+	rooms, clients, err := handler.Manager.DrainRooms(c.Request.Context(), request.Target, request.PublicURL)
+	handler.Manager.Logger.Info("Node drained by admin", "target", request.Target, "rooms", rooms, "clients", clients,
+		"principal", principal.Name, "role", principal.Role, "error", err)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "rooms": rooms, "clients": clients})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rooms": rooms, "clients": clients})
+}
+
+// ReceiveRoom takes over a room another node is handing over
+func (handler *Handler) ReceiveRoom(c *gin.Context) {
+	var state socket.RoomState
+	if err := c.ShouldBindJSON(&state); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a room state"})
+		return
+	}
+	if state.Document.ID != c.Param("docId") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room state is for another document"})
+		return
+	}
+
+	err := handler.Manager.ReceiveRoom(state)
+	switch {
+	case errors.Is(err, document.ErrInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "the room has clients on this node"})
+	case err != nil:
+		handler.Manager.Logger.Error("Could not receive room", "doc_id", state.Document.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not take the room over"})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListModeration returns the bans and mutes in force in a document to its
+// owner
+func (handler *Handler) ListModeration(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	doc, ok := handler.lookupDocument(c)
+	if !ok {
+		return
+	}
+	if owner := doc.Permissions().Owner; owner == "" || owner != session.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": document.ErrNotModerator.Error()})
+		return
+	}
+	bans, mutes := doc.Restrictions()
+	if bans == nil {
+		bans = []document.Restriction{}
+	}
+	if mutes == nil {
+		mutes = []document.Restriction{}
+	}
+	c.JSON(http.StatusOK, gin.H{"docId": doc.ID, "bans": bans, "mutes": mutes})
+}
+
+// Moderate kicks, bans, unbans, mutes or unmutes a user in a document on
+// behalf of its owner, as the moderate message does over the socket
+func (handler *Handler) Moderate(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request socket.ModerationRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.UserID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a JSON object with action and userId"})
+		return
+	}
+	doc, ok := handler.lookupDocument(c)
+	if !ok {
+		return
+	}
+
+	moderated, err := handler.Manager.Moderate(doc, session.UserID, request)
+	switch {
+	case errors.Is(err, document.ErrNotModerator):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, moderated)
+	}
+}
+
+func (handler *Handler) lookupDocument(c *gin.Context) (*document.Document, bool) {
+	docID := c.Param("id")
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return nil, false
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return nil, false
+	}
+	return doc, true
+}
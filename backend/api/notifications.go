@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"backend/document"
+	"backend/notifications"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MarkReadRequest lists the notifications to mark read; none marks them all
+type MarkReadRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// NotificationLevelRequest is the level, all, mentions or none, to be
+// notified at about a document
+type NotificationLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// ListNotifications returns the caller's notifications, newest first, or
+// only the unread ones with ?unread=true
+func (handler *Handler) ListNotifications(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	store := handler.Manager.Notifications
+	list := store.List(session.UserID, c.Query("unread") == "true")
+	c.JSON(http.StatusOK, gin.H{"notifications": list, "unread": store.Unread(session.UserID)})
+}
+
+// MarkNotificationsRead marks some or all of the caller's notifications read
+func (handler *Handler) MarkNotificationsRead(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request MarkReadRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	store := handler.Manager.Notifications
+	marked := store.MarkRead(session.UserID, request.IDs)
+	c.JSON(http.StatusOK, gin.H{"marked": marked, "unread": store.Unread(session.UserID)})
+}
+
+// GetNotificationLevel returns the level the caller is notified at about
+// a document they joined
+func (handler *Handler) GetNotificationLevel(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	docID := c.Param("id")
+	level, err := handler.Manager.NotificationLevel(docID, session)
+	if err != nil {
+		handler.notificationLevelError(c, docID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "level": level})
+}
+
+// SetNotificationLevel chooses whether the caller is notified of every
+// change to a document they joined, only of mentions and comments, or of
+// nothing
+func (handler *Handler) SetNotificationLevel(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request NotificationLevelRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must give a level"})
+		return
+	}
+	docID := c.Param("id")
+	if err := handler.Manager.SetNotificationLevel(docID, session, request.Level); err != nil {
+		handler.notificationLevelError(c, docID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "level": request.Level})
+}
+
+func (handler *Handler) notificationLevelError(c *gin.Context, docID string, err error) {
+	switch {
+	case errors.Is(err, notifications.ErrInvalidLevel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, socket.ErrNotJoined):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrNotFound), errors.Is(err, socket.ErrWrongTenant):
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+	default:
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"backend/socket"
+	"backend/tenants"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookie holding the state a login was started with, checked on the
+// callback so a login can't be finished in a browser that didn't start it,
+// followed by the tenant whose host it was started on
+const oauthStateCookie = "oauth_state"
+
+// How long a browser has to finish signing in at the provider
+const oauthStateMaxAge = 10 * 60
+
+// StartLogin sends the browser to the provider to sign in to an account
+// of the tenant whose host it asked
+func (handler *Handler) StartLogin(c *gin.Context) {
+	provider, ok := handler.Manager.Logins[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "login provider not configured"})
+		return
+	}
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return
+	}
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	state := base64.RawURLEncoding.EncodeToString(raw)
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state+"."+tenant, oauthStateMaxAge, handler.callbackPath(provider.Name), "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, handler.callbackURL(provider.Name)))
+}
+
+// FinishLogin is where the provider sends the browser back to. It signs
+// in and sends the browser on to the client with the new session ID in
+// the URL fragment, or with loginError when signing in failed.
+func (handler *Handler) FinishLogin(c *gin.Context) {
+	provider, ok := handler.Manager.Logins[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "login provider not configured"})
+		return
+	}
+	cookie, err := c.Cookie(oauthStateCookie)
+	state, tenant, _ := strings.Cut(cookie, ".")
+	if err != nil || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 || (tenant != "" && !tenants.Valid(tenant)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login state does not match, start signing in again"})
+		return
+	}
+	c.SetCookie(oauthStateCookie, "", -1, handler.callbackPath(provider.Name), "", c.Request.TLS != nil, true)
+
+	if denied := c.Query("error"); denied != "" {
+		handler.redirectToClient(c, url.Values{"loginError": {denied}})
+		return
+	}
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), handler.callbackURL(provider.Name))
+	if err != nil {
+		handler.Manager.Logger.Warn("Could not finish login", "provider", provider.Name, "error", err)
+		handler.redirectToClient(c, url.Values{"loginError": {"provider_error"}})
+		return
+	}
+	_, session, err := handler.Manager.SignInWith(c.Request.Context(), tenant, identity)
+	if errors.Is(err, socket.ErrWrongTenant) {
+		handler.redirectToClient(c, url.Values{"loginError": {"wrong_tenant"}})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not sign in", "provider", provider.Name, "error", err)
+		handler.redirectToClient(c, url.Values{"loginError": {"server_error"}})
+		return
+	}
+	handler.redirectToClient(c, url.Values{"session": {session.ID}})
+}
+
+// redirectToClient sends the browser to the client with fragment, which
+// browsers never send on to servers
+func (handler *Handler) redirectToClient(c *gin.Context, fragment url.Values) {
+	c.Redirect(http.StatusFound, handler.Manager.Config.OAuth.ClientURL+"#"+fragment.Encode())
+}
+
+func (handler *Handler) callbackPath(provider string) string {
+	return "/api/auth/" + provider + "/callback"
+}
+
+func (handler *Handler) callbackURL(provider string) string {
+	return strings.TrimSuffix(handler.Manager.Config.OAuth.RedirectURL, "/") + handler.callbackPath(provider)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"backend/buildinfo"
+	"backend/document"
+	"backend/openapi"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse is how every failed request is answered
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// operation documents a route beyond its method and path. Session
+// operations take a session ID as their bearer token.
+type operation struct {
+	id       string
+	summary  string
+	session  bool
+	query    []openapi.Parameter
+	request  any
+	status   int
+	response any
+}
+
+func queryParam(name string, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+// documented are the operations integrations are expected to call, by the
+// name of the handler method serving them. The typed client in apiclient
+// is generated from them.
+var documented = map[string]operation{
+	"Register": {id: "register", summary: "Create an account and a session signed in to it",
+		request: RegisterRequest{}, status: http.StatusCreated, response: SessionResponse{}},
+	"Login": {id: "login", summary: "Start a session signed in to an account",
+		request: LoginRequest{}, status: http.StatusOK, response: SessionResponse{}},
+	"GetProfile": {id: "getProfile", summary: "Get the account the session is signed in to", session: true,
+		status: http.StatusOK, response: ProfileResponse{}},
+	"UpdateProfile": {id: "updateProfile", summary: "Change the display name, color or email opt-out of the account", session: true,
+		request: socket.ProfileUpdate{}, status: http.StatusOK, response: ProfileResponse{}},
+	"GetMeta": {id: "getMeta", summary: "Describe the server's build, features and limits",
+		status: http.StatusOK, response: metaResponse{}},
+	"CreateDocument": {id: "createDocument", summary: "Create a document owned by the caller", session: true,
+		query: []openapi.Parameter{
+			queryParam("template", "ID of a template to start from"),
+			queryParam("folder", "ID of the folder to file the document in"),
+		},
+		status: http.StatusCreated, response: CreateDocumentResponse{}},
+	"TrashDocument": {id: "trashDocument", summary: "Move a document to the trash", session: true,
+		status: http.StatusOK, response: socket.TrashedDocument{}},
+	"RestoreDocument": {id: "restoreDocument", summary: "Take a document back out of the trash", session: true,
+		status: http.StatusOK, response: document.Summary{}},
+	"ListTrash": {id: "listTrash", summary: "List the caller's documents in the trash", session: true,
+		status: http.StatusOK, response: TrashResponse{}},
+	"GetPresence": {id: "getPresence", summary: "List the users connected to a document",
+		status: http.StatusOK, response: PresenceResponse{}},
+	"GetAttribution": {id: "getAttribution", summary: "Get who wrote each range of a document's text",
+		status: http.StatusOK, response: AttributionResponse{}},
+	"ListComments": {id: "listComments", summary: "List the comments on a document",
+		status: http.StatusOK, response: CommentsResponse{}},
+	"AddComment": {id: "addComment", summary: "Comment on a range of a document", session: true,
+		request: AddCommentRequest{}, status: http.StatusCreated, response: CommentResponse{}},
+	"ResolveComment": {id: "resolveComment", summary: "Mark a comment resolved", session: true,
+		status: http.StatusOK, response: CommentResponse{}},
+	"ListTemplates": {id: "listTemplates", summary: "List the templates documents can be created from", session: true,
+		status: http.StatusOK, response: TemplatesResponse{}},
+}
+
+// Spec builds the OpenAPI document of the API from its registered routes.
+// Every route under /api is listed; the documented ones also get an
+// operation ID and the schemas of their bodies.
+func Spec(routes gin.RoutesInfo) *openapi.Document {
+	doc := openapi.New("Collaborative document editor", buildinfo.Get().Version)
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"session":  {Type: "http", Scheme: "bearer", Description: "the sessionId answered by register or login"},
+		"apiToken": {Type: "http", Scheme: "bearer", Description: "an API token from the server's configuration"},
+	}
+	errorResponse := openapi.Response{Description: "The request failed", Content: openapi.JSON(doc.SchemaOf(ErrorResponse{}))}
+
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		path, parameters := openAPIPath(route.Path)
+		tag, _, _ := strings.Cut(strings.TrimPrefix(path, "/api/"), "/")
+		documentation := documented[handlerName(route.Handler)]
+		operation := openapi.Operation{
+			OperationID: documentation.id,
+			Summary:     documentation.summary,
+			Tags:        []string{tag},
+			Parameters:  append(parameters, documentation.query...),
+			Responses:   map[string]openapi.Response{"default": errorResponse},
+		}
+		switch {
+		case tag == "admin":
+			operation.Security = []map[string][]string{{"apiToken": {}}}
+		case documentation.session:
+			operation.Security = []map[string][]string{{"session": {}}}
+		}
+		if documentation.request != nil {
+			operation.RequestBody = &openapi.RequestBody{Required: true, Content: openapi.JSON(doc.SchemaOf(documentation.request))}
+		}
+		if documentation.response != nil {
+			operation.Responses[strconv.Itoa(documentation.status)] = openapi.Response{
+				Description: http.StatusText(documentation.status),
+				Content:     openapi.JSON(doc.SchemaOf(documentation.response)),
+			}
+		}
+		doc.Add(route.Method, path, operation)
+	}
+	return doc
+}
+
+// RegisterOpenAPI serves the OpenAPI document of every route registered on
+// router at /api/openapi.json, built on the first request
+func (handler *Handler) RegisterOpenAPI(router *gin.Engine) {
+	spec := sync.OnceValues(func() ([]byte, error) {
+		return json.Marshal(Spec(router.Routes()))
+	})
+	router.GET("/api/openapi.json", func(c *gin.Context) {
+		body, err := spec()
+		if err != nil {
+			handler.Manager.Logger.Error("Could not build the OpenAPI document", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not build the OpenAPI document"})
+			return
+		}
+		c.Data(http.StatusOK, "application/json", body)
+	})
+}
+
+// openAPIPath turns gin's :param segments into OpenAPI's {param} and
+// returns the path parameters
+func openAPIPath(path string) (string, []openapi.Parameter) {
+	segments := strings.Split(path, "/")
+	var parameters []openapi.Parameter
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+			parameters = append(parameters, openapi.Parameter{Name: name, In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}})
+		}
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+// handlerName is the method name in a handler's function name, such as
+// CreateDocument in backend/api.(*Handler).CreateDocument-fm
+func handlerName(function string) string {
+	return strings.TrimSuffix(function[strings.LastIndex(function, ".")+1:], "-fm")
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Fastest playback a replay may ask for
+const maxReplaySpeed = 100
+
+// Replay plays back a document's op log between the revisions in the from
+// and to query parameters, to defaulting to the current one, at the pace
+// the ops were made times speed, over a WebSocket or as Server-Sent
+// Events. Since browsers can't set headers on either, the caller's session
+// may also come in the session query parameter. Like a diff it is for
+// whoever may export the document.
+func (handler *Handler) Replay(c *gin.Context) {
+	from, err := strconv.ParseInt(c.DefaultQuery("from", "0"), 10, 64)
+	if err != nil || from < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a revision number"})
+		return
+	}
+	speed, err := strconv.ParseFloat(c.DefaultQuery("speed", "1"), 64)
+	if err != nil || speed <= 0 || speed > maxReplaySpeed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "speed must be a number above 0 and at most " + strconv.Itoa(maxReplaySpeed)})
+		return
+	}
+
+	docID := c.Param("id")
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	userID := handler.caller(c)
+	if userID == "" {
+		if session, ok := handler.Manager.Sessions.Lookup(c.Query("session")); ok {
+			if _, err := handler.Manager.SessionTenant(c.Request.Host, session); err == nil {
+				userID = session.UserID
+			}
+		}
+	}
+	if !handler.mayExportAs(c, doc, userID) {
+		return
+	}
+
+	to := doc.Revision()
+	if value := c.Query("to"); value != "" {
+		to, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || to < from {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a revision number no lower than from"})
+			return
+		}
+	}
+	var ops []document.Op
+	if to > 0 {
+		ops, err = doc.OpsBetween(max(from, 1), to)
+	}
+	switch {
+	case errors.Is(err, document.ErrRevisionInTheFuture), errors.Is(err, document.ErrInvalidRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, document.ErrRevisionUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		handler.Manager.Logger.Error("Could not read op log", "doc_id", docID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read op log"})
+		return
+	}
+	handler.Manager.StreamReplay(c.Writer, c.Request, socket.Replay{DocID: docID, From: from, To: to, Speed: speed, Ops: ops})
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSeenState returns how far each user who opened a document has seen
+// it, as the room's seen-state message does
+func (handler *Handler) GetSeenState(c *gin.Context) {
+	docID := c.Param("id")
+	doc, err := handler.Manager.Documents.Lookup(docID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return
+	}
+	seen := socket.SeenState(doc)
+	c.JSON(http.StatusOK, gin.H{"docId": docID, "revision": seen.Revision, "users": seen.Users})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/document"
+	"backend/share"
+	"backend/socket"
+	"backend/users"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lifetime of a share link when the request doesn't give one
+const defaultShareTTL = 7 * 24 * time.Hour
+
+// Timeout for emailing an invitation
+const mailTimeout = 30 * time.Second
+
+// ShareRequest asks for an invitation link granting Role, edit or view,
+// for ExpiresIn, a duration such as 24h, and for it to be emailed to Email
+// if set
+type ShareRequest struct {
+	Role      string `json:"role"`
+	ExpiresIn string `json:"expiresIn"`
+	Email     string `json:"email"`
+}
+
+// ShareDocument issues a signed, expiring share token for the caller's
+// session, which must be the document owner's. Joining with the token in
+// the share query parameter grants its role. When an email is given the
+// invitation is sent there too; emailed tells whether it was, since
+// accounts can opt out of email and the link works all the same.
+func (handler *Handler) ShareDocument(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+
+	var request ShareRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	ttl := min(defaultShareTTL, handler.Manager.Config.Share.MaxTTL)
+	if request.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(request.ExpiresIn)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiresIn must be a duration such as 24h"})
+			return
+		}
+		ttl = parsed
+	}
+	if request.Email != "" {
+		if handler.Manager.Mailer == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": socket.ErrMailDisabled.Error()})
+			return
+		}
+		if _, err := users.NormalizeEmail(request.Email); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	docID := c.Param("id")
+	token, grant, err := handler.Manager.ShareLink(docID, session, request.Role, ttl)
+	switch {
+	case errors.Is(err, document.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrNotOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the document's owner can share it"})
+	case errors.Is(err, share.ErrInvalidRole), errors.Is(err, socket.ErrShareTTL):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		handler.Manager.Logger.Error("Could not share document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not share document"})
+	case request.Email != "":
+		ctx, cancel := context.WithTimeout(c.Request.Context(), mailTimeout)
+		defer cancel()
+		emailed, err := handler.Manager.InviteByEmail(ctx, session, request.Email, token, grant)
+		if err != nil {
+			handler.Manager.Logger.Warn("Could not email share invitation", "doc_id", docID, "error", err)
+		}
+		c.JSON(http.StatusCreated, gin.H{"token": token, "role": grant.Role, "expiresAt": grant.ExpiresAt, "emailed": emailed})
+	default:
+		c.JSON(http.StatusCreated, gin.H{"token": token, "role": grant.Role, "expiresAt": grant.ExpiresAt})
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"backend/blobs"
+	"backend/document"
+	"backend/export"
+	"backend/richtext"
+	"backend/snapshots"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Snapshots never change, so anything may cache them for good unless
+// their document restricts who may export it
+const (
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	restrictedCacheControl = "private, no-store"
+)
+
+// CreateSnapshot archives the document's current state and returns the
+// snapshot with its permalink
+func (handler *Handler) CreateSnapshot(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	docID := c.Param("id")
+	snapshot, err := handler.Manager.CreateSnapshot(ctx, docID, session)
+	if errors.Is(err, document.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not create snapshot", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "could not create snapshot"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"snapshot": snapshot, "permalink": "/s/" + snapshot.ID})
+}
+
+// GetSnapshot returns a snapshot with its content as a delta and as HTML
+func (handler *Handler) GetSnapshot(c *gin.Context) {
+	snapshot, ok := handler.snapshot(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshot": snapshot, "html": richtext.ToHTML(snapshot.Content)})
+}
+
+// ExportSnapshot downloads a snapshot in the format given by the format
+// query parameter
+func (handler *Handler) ExportSnapshot(c *gin.Context) {
+	format, err := export.Lookup(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "format must be one of " + strings.Join(export.Names(), ", "),
+		})
+		return
+	}
+	handler.renderSnapshot(c, format, "attachment")
+}
+
+// ViewSnapshot is the permalink: the snapshot as a standalone HTML page
+func (handler *Handler) ViewSnapshot(c *gin.Context) {
+	format, _ := export.Lookup("html")
+	handler.renderSnapshot(c, format, "inline")
+}
+
+func (handler *Handler) renderSnapshot(c *gin.Context, format export.Format, disposition string) {
+	snapshot, ok := handler.snapshot(c)
+	if !ok {
+		return
+	}
+	if handler.Manager.Exports == nil {
+		handler.writeExport(c, format, disposition, snapshot.DocID, snapshot.Metadata,
+			richtext.ToHTML(snapshot.Content), snapshot.Revision)
+		return
+	}
+	handler.writeStoredExport(c, format, disposition, snapshot)
+}
+
+// writeStoredExport serves a snapshot's export from the blob store,
+// rendering and keeping it there the first time. Snapshots never change,
+// so neither do their exports.
+func (handler *Handler) writeStoredExport(c *gin.Context, format export.Format, disposition string, snapshot snapshots.Snapshot) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), uploadTimeout)
+	defer cancel()
+
+	key := snapshot.ID + format.Extension
+	body, _, err := handler.Manager.Exports.Get(ctx, key)
+	if err == nil {
+		defer body.Close()
+		writeExportHeaders(c, format, disposition, snapshot.DocID, snapshot.Revision)
+		if _, err := io.Copy(c.Writer, body); err != nil {
+			handler.Manager.Logger.Warn("Could not send stored export", "snapshot_id", snapshot.ID, "error", err)
+		}
+		return
+	}
+	if !errors.Is(err, blobs.ErrNotFound) {
+		handler.Manager.Logger.Warn("Could not load stored export", "snapshot_id", snapshot.ID, "error", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := format.Render(&rendered, socket.ExportInfo(snapshot.DocID, snapshot.Metadata), richtext.ToHTML(snapshot.Content)); err != nil {
+		handler.Manager.Logger.Warn("Export failed", "doc_id", snapshot.DocID, "format", format.Name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not export snapshot"})
+		return
+	}
+	info := blobs.Info{Name: key, ContentType: format.ContentType, Size: int64(rendered.Len())}
+	if err := handler.Manager.Exports.Put(ctx, key, bytes.NewReader(rendered.Bytes()), info); err != nil {
+		handler.Manager.Logger.Warn("Could not store export", "snapshot_id", snapshot.ID, "error", err)
+	}
+	writeExportHeaders(c, format, disposition, snapshot.DocID, snapshot.Revision)
+	c.Writer.Write(rendered.Bytes())
+}
+
+func (handler *Handler) snapshot(c *gin.Context) (snapshots.Snapshot, bool) {
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return snapshots.Snapshot{}, false
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	snapshot, err := handler.Manager.Snapshots.Get(ctx, c.Param("snapshotId"))
+	if errors.Is(err, snapshots.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+		return snapshots.Snapshot{}, false
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load snapshot", "snapshot_id", c.Param("snapshotId"), "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "snapshot store unavailable"})
+		return snapshots.Snapshot{}, false
+	}
+
+	// The document's current export policy covers its snapshots too
+	doc, err := handler.Manager.Documents.Lookup(snapshot.DocID)
+	if errors.Is(err, document.ErrNotFound) {
+		c.Header("Cache-Control", immutableCacheControl)
+		return snapshot, true
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not load document", "doc_id", snapshot.DocID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+		return snapshots.Snapshot{}, false
+	}
+	if !doc.ClaimTenant(tenant) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+		return snapshots.Snapshot{}, false
+	}
+	if !handler.mayExport(c, doc) {
+		return snapshots.Snapshot{}, false
+	}
+	if doc.Permissions().Export == document.ExportAnyone {
+		c.Header("Cache-Control", immutableCacheControl)
+	} else {
+		c.Header("Cache-Control", restrictedCacheControl)
+	}
+	return snapshot, true
+}
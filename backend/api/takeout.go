@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/export"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type takeoutRequest struct {
+	Format string `json:"format"`
+}
+
+// StartTakeout starts archiving the caller's workspace, with documents in
+// the format the body names, HTML by default. The job runs in the
+// background; follow it with GetTakeout.
+func (handler *Handler) StartTakeout(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request takeoutRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a JSON object"})
+		return
+	}
+	if request.Format == "" {
+		request.Format = "html"
+	}
+	format, err := export.Lookup(request.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of " + strings.Join(export.Names(), ", ")})
+		return
+	}
+
+	job, err := handler.Manager.StartTakeout(session, format)
+	if err != nil {
+		takeoutError(c, err)
+		return
+	}
+	handler.Manager.Logger.Info("Takeout started", "takeout_id", job.ID, "user_id", session.UserID, "format", format.Name)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetTakeout returns where a takeout of the caller's stands
+func (handler *Handler) GetTakeout(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	job, err := handler.Manager.Takeout(session, c.Param("id"))
+	if err != nil {
+		takeoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// DownloadTakeout sends the zip archive of a finished takeout
+func (handler *Handler) DownloadTakeout(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), uploadTimeout)
+	defer cancel()
+
+	id := c.Param("id")
+	body, info, err := handler.Manager.OpenTakeout(ctx, session, id)
+	if err != nil {
+		if !errors.Is(err, socket.ErrTakeoutNotFound) && !errors.Is(err, socket.ErrTakeoutNotReady) {
+			handler.Manager.Logger.Error("Could not load takeout archive", "takeout_id", id, "error", err)
+		}
+		takeoutError(c, err)
+		return
+	}
+	defer body.Close()
+
+	c.Header("Content-Type", info.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		handler.Manager.Logger.Warn("Could not send takeout archive", "takeout_id", id, "error", err)
+	}
+}
+
+func takeoutError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, socket.ErrTakeoutNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, socket.ErrTakeoutRunning), errors.Is(err, socket.ErrTakeoutNotReady):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "takeout store unavailable"})
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest client error report accepted
+const maxReportSize = 16 << 10
+
+// ReportClientError takes a sync failure or desync report from a client's
+// session and logs it next to the room's logs. Reports are rate limited
+// per user and must match ClientErrorReport exactly.
+func (handler *Handler) ReportClientError(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	if !handler.clientErrors.Allow(session.UserID, time.Now()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many error reports, try again later"})
+		return
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxReportSize))
+	decoder.DisallowUnknownFields()
+	var report socket.ClientErrorReport
+	if err := decoder.Decode(&report); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "report is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report: " + err.Error()})
+		return
+	}
+	if err := report.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report: " + err.Error()})
+		return
+	}
+
+	handler.Manager.ReportClientError(session.UserID, report)
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/folders"
+	"backend/richtext"
+	"backend/socket"
+	"backend/templates"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createTemplateRequest struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Content     richtext.Delta `json:"content"`
+	// DocID takes the content from a document instead, which the caller
+	// must be allowed to export
+	DocID string `json:"docId"`
+}
+
+// CreateTemplate stores a template for the caller's tenant with the given
+// content, or with the current content of one of the tenant's documents
+func (handler *Handler) CreateTemplate(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var request createTemplateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a JSON object"})
+		return
+	}
+	if (request.DocID == "") == (request.Content == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "either content or docId is required"})
+		return
+	}
+
+	content := request.Content
+	if request.DocID != "" {
+		doc, err := handler.Manager.TenantDocument(session.Tenant, request.DocID)
+		if errors.Is(err, document.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+			return
+		}
+		if err != nil {
+			handler.Manager.Logger.Error("Could not load document", "doc_id", request.DocID, "error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+			return
+		}
+		if !handler.mayExport(c, doc) {
+			return
+		}
+		content, _ = doc.Contents()
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	template, err := handler.Manager.CreateTemplate(ctx, session, templates.Template{
+		Name:        request.Name,
+		Description: request.Description,
+		Content:     content,
+	})
+	if errors.Is(err, templates.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not create template", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "could not create template"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"template": template})
+}
+
+// TemplatesResponse lists templates
+type TemplatesResponse struct {
+	Templates []templates.Template `json:"templates"`
+}
+
+// CreateDocumentResponse is a new document, at the revision its template
+// gave it
+type CreateDocumentResponse struct {
+	DocID      string `json:"docId"`
+	Revision   int64  `json:"revision"`
+	TemplateID string `json:"templateId,omitempty"`
+}
+
+// ListTemplates returns the templates of the caller's tenant, sorted by
+// name
+func (handler *Handler) ListTemplates(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	list, err := handler.Manager.TenantTemplates(ctx, session.Tenant)
+	if err != nil {
+		handler.Manager.Logger.Error("Could not list templates", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "templates unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, TemplatesResponse{Templates: list})
+}
+
+// CreateDocument creates a document owned by the caller, empty or from the
+// template in the template query parameter, filed in the folder in the
+// folder query parameter or at the root of the caller's workspace
+func (handler *Handler) CreateDocument(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	templateID := c.Query("template")
+	docID, revision, err := handler.Manager.CreateDocument(ctx, session, templateID, c.Query("folder"))
+	if errors.Is(err, templates.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return
+	}
+	if errors.Is(err, folders.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "folder not found"})
+		return
+	}
+	if handler.editLimited(c, err) {
+		return
+	}
+	if errors.Is(err, socket.ErrDocumentQuota) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		handler.Manager.Logger.Error("Could not create document", "template_id", templateID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "could not create document"})
+		return
+	}
+	c.JSON(http.StatusCreated, CreateDocumentResponse{DocID: docID, Revision: revision, TemplateID: templateID})
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hostTenant returns the tenant the request's host is for, answering 404
+// for a subdomain that can't be one
+func (handler *Handler) hostTenant(c *gin.Context) (string, bool) {
+	tenant, err := handler.Manager.HostTenant(c.Request.Host)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return tenant, true
+}
+
+// requireTenant keeps requests about a document to its tenant's host.
+// Another tenant's document answers 404, as a missing one does.
+func (handler *Handler) requireTenant(c *gin.Context) {
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		c.Abort()
+		return
+	}
+	docID := c.Param("id")
+	if docID == "" {
+		return
+	}
+	if doc, err := handler.Manager.Documents.Lookup(docID); err == nil && !doc.ClaimTenant(tenant) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "document not found"})
+	}
+}
+
+// tenantDocumentError answers a failure to open a document for a tenant
+func (handler *Handler) tenantDocumentError(c *gin.Context, docID string, err error) {
+	switch {
+	case errors.Is(err, socket.ErrWrongTenant):
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+	case errors.Is(err, socket.ErrDocumentQuota):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, document.ErrTrashed):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		handler.Manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document unavailable"})
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"backend/document"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrashDocument moves a document to the trash on behalf of its owner,
+// closing every connection to it. It is purged once the trash retention
+// has passed unless restored first.
+func (handler *Handler) TrashDocument(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	doc, ok := handler.lookupDocument(c)
+	if !ok {
+		return
+	}
+	trashed, err := handler.Manager.TrashDocument(doc, session.UserID)
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, trashed)
+}
+
+// RestoreDocument takes a document back out of the trash on behalf of its
+// owner
+func (handler *Handler) RestoreDocument(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	doc, ok := handler.lookupDocument(c)
+	if !ok {
+		return
+	}
+	if err := handler.Manager.RestoreDocument(doc, session.UserID); err != nil {
+		trashError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, doc.Summary())
+}
+
+// TrashResponse lists documents in the trash, most recently trashed first
+type TrashResponse struct {
+	Documents []socket.TrashedDocument `json:"documents"`
+}
+
+// ListTrash lists the caller's documents in the trash and when each will
+// be purged
+func (handler *Handler) ListTrash(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	trash, err := handler.Manager.Trash(ctx, session.Tenant, session.UserID)
+	if err != nil {
+		handler.Manager.Logger.Error("Could not list the trash", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document store unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, TrashResponse{Documents: trash})
+}
+
+// refuseTrashed answers requests for a document in the trash as gone, so
+// it can only be restored
+func (handler *Handler) refuseTrashed(c *gin.Context) {
+	docID := c.Param("id")
+	if docID == "" {
+		return
+	}
+	if doc, err := handler.Manager.Documents.Lookup(docID); err == nil {
+		if _, trashed := doc.TrashedAt(); trashed {
+			c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": document.ErrTrashed.Error()})
+		}
+	}
+}
+
+func trashError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, document.ErrNotOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the document's owner can move it to the trash or restore it"})
+	case errors.Is(err, document.ErrTrashed), errors.Is(err, document.ErrNotTrashed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update the document"})
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/metrics"
+	"backend/socket"
+	"backend/users"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRequest creates an account
+type RegisterRequest struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	DisplayName string `json:"displayName"`
+}
+
+// LoginRequest signs in to an account
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// SessionResponse is a session signed in to the account User, whose ID is
+// the bearer token of the API
+type SessionResponse struct {
+	SessionID string        `json:"sessionId"`
+	User      users.Profile `json:"user"`
+}
+
+// ProfileResponse is the account the caller is signed in to
+type ProfileResponse struct {
+	User users.Profile `json:"user"`
+}
+
+// Register creates an account and answers with a session signed in to it,
+// used like any other session to connect and call the API
+func (handler *Handler) Register(c *gin.Context) {
+	if !handler.allowAuthAttempt(c) {
+		return
+	}
+	var request RegisterRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return
+	}
+	user, session, err := handler.Manager.SignUp(c.Request.Context(), tenant, request.Email, request.Password, request.DisplayName)
+	switch {
+	case errors.Is(err, users.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, users.ErrInvalidEmail), errors.Is(err, users.ErrInvalidPassword),
+		errors.Is(err, socket.ErrInvalidUserName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		handler.Manager.Logger.Error("Could not register account", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not register account"})
+	default:
+		c.JSON(http.StatusCreated, SessionResponse{SessionID: session.ID, User: user.Profile()})
+	}
+}
+
+// Login answers with a new session signed in to the account
+func (handler *Handler) Login(c *gin.Context) {
+	if !handler.allowAuthAttempt(c) {
+		return
+	}
+	var request LoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	tenant, ok := handler.hostTenant(c)
+	if !ok {
+		return
+	}
+	user, session, err := handler.Manager.SignIn(c.Request.Context(), tenant, request.Email, request.Password)
+	switch {
+	case errors.Is(err, users.ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case err != nil:
+		handler.Manager.Logger.Error("Could not sign in", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not sign in"})
+	default:
+		c.JSON(http.StatusOK, SessionResponse{SessionID: session.ID, User: user.Profile()})
+	}
+}
+
+// GetProfile returns the account the caller's session is signed in to
+func (handler *Handler) GetProfile(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	user, err := handler.Manager.Account(c.Request.Context(), session)
+	profileResponse(c, user, err)
+}
+
+// UpdateProfile changes the display name, color and email opt-out of the
+// caller's account
+func (handler *Handler) UpdateProfile(c *gin.Context) {
+	session, ok := handler.session(c)
+	if !ok {
+		return
+	}
+	var update socket.ProfileUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	user, err := handler.Manager.UpdateProfile(c.Request.Context(), session, update)
+	profileResponse(c, user, err)
+}
+
+func profileResponse(c *gin.Context, user users.User, err error) {
+	switch {
+	case errors.Is(err, socket.ErrNotSignedIn):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, socket.ErrInvalidUserName), errors.Is(err, socket.ErrInvalidColor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load profile"})
+	default:
+		c.JSON(http.StatusOK, ProfileResponse{User: user.Profile()})
+	}
+}
+
+// allowAuthAttempt rate limits sign-ins and registrations by client
+// address, answering 429 once the address has tried too many
+func (handler *Handler) allowAuthAttempt(c *gin.Context) bool {
+	if handler.Manager.Config.Limits.AuthAttempts.Rate <= 0 {
+		return true
+	}
+	remoteIP := handler.Manager.Proxies.ClientIP(c.Request)
+	if handler.authAttempts.Allow(remoteIP, time.Now()) {
+		return true
+	}
+	metrics.LimitRejections.WithLabelValues("auth-attempts").Inc()
+	handler.Manager.Logger.Warn("Too many sign-in attempts", "remote_ip", remoteIP)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many sign-in attempts, try again later"})
+	return false
+}
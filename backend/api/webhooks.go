@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"backend/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListWebhookDeliveries lists the latest webhook deliveries, newest first,
+// only those with the status query parameter when it is given
+func (handler *Handler) ListWebhookDeliveries(c *gin.Context) {
+	if handler.Manager.Webhooks == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhooks are not configured"})
+		return
+	}
+	status := c.Query("status")
+	switch status {
+	case "", webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": handler.Manager.Webhooks.Deliveries(status)})
+}
@@ -0,0 +1,273 @@
+// Package audit keeps an append-only trail of what happened to each
+// document: who joined and left, who edited which ranges, commented on it,
+// renamed it or themselves in it, who exported it, who moved it to the
+// trash or back, who changed who may do what and when it was resealed
+// with a new encryption key. Entries are never changed once recorded; the
+// retention policy only prunes the oldest.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the trail
+const (
+	ActionJoined             = "joined"
+	ActionLeft               = "left"
+	ActionEdited             = "edited"
+	ActionExported           = "exported"
+	ActionPermissionsChanged = "permissions-changed"
+	ActionShared             = "shared"
+	ActionModerated          = "moderated"
+	ActionCommented          = "commented"
+	ActionRenamed            = "renamed"
+	ActionTrashed            = "trashed"
+	ActionRestored           = "restored"
+	ActionKeyRotated         = "key-rotated"
+)
+
+// Range is the part of a document an edit replaced: Deleted characters at
+// Pos were replaced by Inserted characters
+type Range struct {
+	Pos      int `json:"pos"`
+	Deleted  int `json:"deleted"`
+	Inserted int `json:"inserted"`
+}
+
+// Entry is one thing that happened to a document. Seq numbers the entries
+// of a document from 1 in the order they were recorded.
+type Entry struct {
+	Seq      int64             `json:"seq"`
+	DocID    string            `json:"docId"`
+	Action   string            `json:"action"`
+	UserID   string            `json:"userId,omitempty"`
+	At       time.Time         `json:"at"`
+	Revision int64             `json:"revision,omitempty"`
+	Range    *Range            `json:"range,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// Retention is how much of each document's trail is kept: no entry older
+// than MaxAge and at most the latest MaxEntries, zero disabling either
+type Retention struct {
+	MaxAge     time.Duration
+	MaxEntries int
+}
+
+// Store keeps the trail. AppendAudit numbers an entry after the latest one
+// of its document; entries are never changed, only pruned.
+type Store interface {
+	AppendAudit(ctx context.Context, entry Entry) error
+	// ListAudit returns up to limit entries of a document, newest first,
+	// starting below the one numbered before, or with the latest when
+	// before is zero
+	ListAudit(ctx context.Context, docID string, before int64, limit int) ([]Entry, error)
+	// PruneAudit drops the entries recorded before cutoff, unless it is
+	// zero, and the oldest of each document past keep, unless it is zero,
+	// and returns how many it dropped
+	PruneAudit(ctx context.Context, cutoff time.Time, keep int) (int, error)
+}
+
+// MemoryStore is the Store used when no storage DSN is configured. The
+// trail lasts until the server restarts.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	entries map[string][]Entry
+	seqs    map[string]int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string][]Entry),
+		seqs:    make(map[string]int64),
+	}
+}
+
+func (store *MemoryStore) AppendAudit(_ context.Context, entry Entry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.seqs[entry.DocID]++
+	entry.Seq = store.seqs[entry.DocID]
+	store.entries[entry.DocID] = append(store.entries[entry.DocID], entry)
+	return nil
+}
+
+func (store *MemoryStore) ListAudit(_ context.Context, docID string, before int64, limit int) ([]Entry, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return Page(store.entries[docID], before, limit), nil
+}
+
+func (store *MemoryStore) PruneAudit(_ context.Context, cutoff time.Time, keep int) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pruned := 0
+	for docID, entries := range store.entries {
+		kept := Prune(entries, cutoff, keep)
+		pruned += len(entries) - len(kept)
+		if len(kept) == 0 {
+			delete(store.entries, docID)
+			continue
+		}
+		store.entries[docID] = kept
+	}
+	return pruned, nil
+}
+
+// Page picks a page of entries, kept oldest first, as ListAudit returns it
+func Page(entries []Entry, before int64, limit int) []Entry {
+	page := make([]Entry, 0, min(limit, len(entries)))
+	for _, entry := range slices.Backward(entries) {
+		if len(page) == limit {
+			break
+		}
+		if before == 0 || entry.Seq < before {
+			page = append(page, entry)
+		}
+	}
+	return page
+}
+
+// Prune returns the tail of entries, kept oldest first, that PruneAudit
+// keeps
+func Prune(entries []Entry, cutoff time.Time, keep int) []Entry {
+	if keep > 0 && len(entries) > keep {
+		entries = entries[len(entries)-keep:]
+	}
+	if !cutoff.IsZero() {
+		first := 0
+		for first < len(entries) && entries[first].At.Before(cutoff) {
+			first++
+		}
+		entries = entries[first:]
+	}
+	return entries
+}
+
+// Capacity of the queue of entries waiting to be stored
+const queueSize = 1024
+
+// Timeout for storing an entry or pruning the trail
+const storeTimeout = 5 * time.Second
+
+// Interval between applications of the retention policy
+const pruneInterval = time.Hour
+
+// Trail records entries in the background so a slow store never blocks
+// the editing path. Entries are dropped, with a warning, when the queue is
+// full.
+type Trail struct {
+	store     Store
+	retention Retention
+	logger    *slog.Logger
+
+	queue chan Entry
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewTrail(store Store, retention Retention, logger *slog.Logger) *Trail {
+	trail := &Trail{
+		store:     store,
+		retention: retention,
+		logger:    logger,
+		queue:     make(chan Entry, queueSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go trail.run()
+	return trail
+}
+
+// SetStore moves the trail to store. Like document.Registry.SetStore it
+// must be called before anything is recorded.
+func (trail *Trail) SetStore(store Store) {
+	trail.store = store
+}
+
+// Record queues an entry without blocking, stamped with the time now
+func (trail *Trail) Record(entry Entry) {
+	entry.At = time.Now().UTC()
+	select {
+	case trail.queue <- entry:
+	default:
+		trail.logger.Warn("Audit queue full, dropping entry", "action", entry.Action, "doc_id", entry.DocID)
+	}
+}
+
+// List returns up to limit entries of a document, newest first, starting
+// below the one numbered before, or with the latest when before is zero
+func (trail *Trail) List(ctx context.Context, docID string, before int64, limit int) ([]Entry, error) {
+	return trail.store.ListAudit(ctx, docID, before, limit)
+}
+
+// Close stores whatever is still queued
+func (trail *Trail) Close() error {
+	close(trail.stop)
+	<-trail.done
+	return nil
+}
+
+func (trail *Trail) run() {
+	defer close(trail.done)
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry := <-trail.queue:
+			trail.append(entry)
+		case <-ticker.C:
+			trail.prune()
+		case <-trail.stop:
+			for {
+				select {
+				case entry := <-trail.queue:
+					trail.append(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (trail *Trail) append(entry Entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := trail.store.AppendAudit(ctx, entry); err != nil {
+		trail.logger.Warn("Could not record audit entry", "action", entry.Action, "doc_id", entry.DocID, "error", err)
+	}
+}
+
+// prune applies the retention policy
+func (trail *Trail) prune() {
+	if trail.retention.MaxAge == 0 && trail.retention.MaxEntries == 0 {
+		return
+	}
+	var cutoff time.Time
+	if trail.retention.MaxAge > 0 {
+		cutoff = time.Now().Add(-trail.retention.MaxAge)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	pruned, err := trail.store.PruneAudit(ctx, cutoff, trail.retention.MaxEntries)
+	if err != nil {
+		trail.logger.Warn("Could not prune audit trail", "error", err)
+		return
+	}
+	if pruned > 0 {
+		trail.logger.Info("Pruned audit trail", "entries", pruned)
+	}
+}
@@ -0,0 +1,319 @@
+// Package backup writes every document, content and metadata, to a
+// gzipped tar archive in a blob store, on a schedule or on demand, and
+// reads archives back so documents can be restored from them. An index
+// blob lists the archives, of which only the latest are kept.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/blobs"
+	"backend/document"
+	"backend/encryption"
+	"backend/metrics"
+)
+
+var ErrNotFound = errors.New("backup not found")
+
+// Version of the archive layout written
+const formatVersion = 1
+
+const (
+	indexKey     = "index.json"
+	manifestName = "manifest.json"
+	documentsDir = "documents/"
+)
+
+// Timeout for taking a scheduled backup
+const scheduledTimeout = 30 * time.Minute
+
+// Info describes an archive in the store
+type Info struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Documents int       `json:"documents"`
+	Size      int64     `json:"size"`
+}
+
+// Manifest is the first file of an archive. The documents follow it, one
+// JSON record each under documents/.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Documents int       `json:"documents"`
+}
+
+// Source returns every document to back up
+type Source func(ctx context.Context) ([]document.Record, error)
+
+// Backups takes backups of source into store, keeping the latest keep of
+// them, or every one when keep is zero
+type Backups struct {
+	store  blobs.Store
+	source Source
+	keep   int
+	logger *slog.Logger
+
+	// Keys, unless nil, seal each document in the archives, as they are
+	// sealed in the document store
+	Keys *encryption.Keyring
+
+	// mutex serializes backups, which update the index
+	mutex sync.Mutex
+
+	done chan struct{}
+	once sync.Once
+}
+
+func New(store blobs.Store, source Source, keep int, logger *slog.Logger) *Backups {
+	return &Backups{
+		store:  store,
+		source: source,
+		keep:   keep,
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+}
+
+// Run takes a backup each interval until Close is called
+func (backups *Backups) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), scheduledTimeout)
+			info, err := backups.Create(ctx)
+			cancel()
+			if err != nil {
+				backups.logger.Error("Scheduled backup failed", "error", err)
+				continue
+			}
+			backups.logger.Info("Backup taken", "id", info.ID, "documents", info.Documents, "size", info.Size)
+		case <-backups.done:
+			return
+		}
+	}
+}
+
+// Close stops a running backup loop
+func (backups *Backups) Close() {
+	backups.once.Do(func() { close(backups.done) })
+}
+
+// Create backs up every document now
+func (backups *Backups) Create(ctx context.Context) (Info, error) {
+	info, err := backups.create(ctx)
+	if err != nil {
+		metrics.Backups.WithLabelValues("error").Inc()
+		return Info{}, err
+	}
+	metrics.Backups.WithLabelValues("ok").Inc()
+	metrics.LastBackup.Set(float64(info.CreatedAt.Unix()))
+	return info, nil
+}
+
+func (backups *Backups) create(ctx context.Context) (Info, error) {
+	backups.mutex.Lock()
+	defer backups.mutex.Unlock()
+
+	records, err := backups.source(ctx)
+	if err != nil {
+		return Info{}, fmt.Errorf("listing documents: %w", err)
+	}
+	now := time.Now().UTC()
+	var archive bytes.Buffer
+	if err := write(&archive, Manifest{Version: formatVersion, CreatedAt: now, Documents: len(records)}, records, backups.Keys); err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		ID:        now.Format("20060102T150405.000Z"),
+		CreatedAt: now,
+		Documents: len(records),
+		Size:      int64(archive.Len()),
+	}
+	key := archiveKey(info.ID)
+	if err := backups.store.Put(ctx, key, &archive, blobs.Info{Name: key, ContentType: "application/gzip", Size: info.Size}); err != nil {
+		return Info{}, fmt.Errorf("storing backup: %w", err)
+	}
+
+	index, err := backups.index(ctx)
+	if err != nil {
+		return Info{}, err
+	}
+	index = append([]Info{info}, index...)
+	var expired []Info
+	if backups.keep > 0 && len(index) > backups.keep {
+		index, expired = index[:backups.keep], index[backups.keep:]
+	}
+	if err := backups.saveIndex(ctx, index); err != nil {
+		return Info{}, err
+	}
+	for _, old := range expired {
+		if err := backups.store.Delete(ctx, archiveKey(old.ID)); err != nil && !errors.Is(err, blobs.ErrNotFound) {
+			backups.logger.Warn("Could not delete expired backup", "id", old.ID, "error", err)
+		}
+	}
+	return info, nil
+}
+
+// List returns the backups kept, newest first
+func (backups *Backups) List(ctx context.Context) ([]Info, error) {
+	backups.mutex.Lock()
+	defer backups.mutex.Unlock()
+	return backups.index(ctx)
+}
+
+// Read returns the documents of backup id
+func (backups *Backups) Read(ctx context.Context, id string) (Manifest, []document.Record, error) {
+	index, err := backups.List(ctx)
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	if !slices.ContainsFunc(index, func(info Info) bool { return info.ID == id }) {
+		return Manifest{}, nil, ErrNotFound
+	}
+	body, _, err := backups.store.Get(ctx, archiveKey(id))
+	if errors.Is(err, blobs.ErrNotFound) {
+		return Manifest{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	defer body.Close()
+	return read(body, backups.Keys)
+}
+
+func (backups *Backups) index(ctx context.Context) ([]Info, error) {
+	body, _, err := backups.store.Get(ctx, indexKey)
+	if errors.Is(err, blobs.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading backup index: %w", err)
+	}
+	defer body.Close()
+	var index []Info
+	if err := json.NewDecoder(body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("decoding backup index: %w", err)
+	}
+	return index, nil
+}
+
+func (backups *Backups) saveIndex(ctx context.Context, index []Info) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	info := blobs.Info{Name: indexKey, ContentType: "application/json", Size: int64(len(data))}
+	if err := backups.store.Put(ctx, indexKey, bytes.NewReader(data), info); err != nil {
+		return fmt.Errorf("storing backup index: %w", err)
+	}
+	return nil
+}
+
+func archiveKey(id string) string {
+	return id + ".tar.gz"
+}
+
+// write archives records, sealed with keys, after their manifest
+func write(w io.Writer, manifest Manifest, records []document.Record, keys *encryption.Keyring) error {
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+
+	add := func(name string, value any, scope string) error {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if scope != "" {
+			if data, err = keys.Seal(scope, data); err != nil {
+				return err
+			}
+		}
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = archive.Write(data)
+		return err
+	}
+	if err := add(manifestName, manifest, ""); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := add(documentsDir+url.PathEscape(record.ID)+".json", record, encryption.DocumentScope(record.ID)); err != nil {
+			return fmt.Errorf("archiving document %q: %w", record.ID, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+// read unpacks an archive written by write
+func read(r io.Reader, keys *encryption.Keyring) (Manifest, []document.Record, error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("reading backup: %w", err)
+	}
+	archive := tar.NewReader(compressed)
+
+	var manifest Manifest
+	var records []document.Record
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Manifest{}, nil, fmt.Errorf("reading backup: %w", err)
+		}
+		switch {
+		case header.Name == manifestName:
+			if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+				return Manifest{}, nil, fmt.Errorf("reading backup manifest: %w", err)
+			}
+			if manifest.Version != formatVersion {
+				return Manifest{}, nil, fmt.Errorf("backup format %d is not supported", manifest.Version)
+			}
+		case strings.HasPrefix(header.Name, documentsDir):
+			id, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(header.Name, documentsDir), ".json"))
+			if err != nil {
+				return Manifest{}, nil, fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			data, err := io.ReadAll(archive)
+			if err != nil {
+				return Manifest{}, nil, fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			if data, err = keys.Open(encryption.DocumentScope(id), data); err != nil {
+				return Manifest{}, nil, fmt.Errorf("decrypting %s: %w", header.Name, err)
+			}
+			var record document.Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return Manifest{}, nil, fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			records = append(records, record)
+		}
+	}
+	if manifest.Version == 0 {
+		return Manifest{}, nil, errors.New("backup has no manifest")
+	}
+	return manifest, records, nil
+}
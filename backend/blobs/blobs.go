@@ -0,0 +1,147 @@
+// Package blobs keeps large binary objects, such as uploaded attachments,
+// in memory, on local disk or in an S3-compatible object store. Keys are
+// slash separated paths.
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a blob. Name is the file name it was uploaded under.
+type Info struct {
+	Name        string
+	ContentType string
+	Size        int64
+}
+
+// Store keeps blobs by key. Put reads body to the end and replaces any blob
+// with the same key; Get fails with ErrNotFound for unknown keys and the
+// caller closes the body.
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, info Info) error
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Server-side encryption an S3 store can ask for
+const (
+	EncryptionAES256 = "AES256"
+	EncryptionKMS    = "aws:kms"
+)
+
+// Options apply to every blob of a store opened with Open. Keys are put
+// under Prefix, and Encryption has an S3 store encrypt objects at rest,
+// with KMSKeyID or the bucket's default key for aws:kms.
+type Options struct {
+	Prefix     string
+	Encryption string
+	KMSKeyID   string
+}
+
+// Open returns the store a DSN points to: file:///path for a directory or
+// s3://bucket for an object store
+func Open(dsn string, options Options) (Store, error) {
+	var store Store
+	scheme, _, _ := strings.Cut(dsn, ":")
+	switch scheme {
+	case "file":
+		fileStore, err := NewFileStore(strings.TrimPrefix(strings.TrimPrefix(dsn, "file:"), "//"))
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	case "s3":
+		s3Store, err := NewS3Store(dsn)
+		if err != nil {
+			return nil, err
+		}
+		s3Store.Encryption, s3Store.KMSKeyID = options.Encryption, options.KMSKeyID
+		store = s3Store
+	default:
+		return nil, fmt.Errorf("blob DSN must start with file:// or s3://")
+	}
+	if options.Encryption != "" && scheme != "s3" {
+		return nil, fmt.Errorf("server-side encryption needs an s3:// blob DSN")
+	}
+	return WithPrefix(store, options.Prefix), nil
+}
+
+// WithPrefix returns a view of store that keeps every blob under prefix,
+// so that several kinds of blob can share one bucket or directory
+func WithPrefix(store Store, prefix string) Store {
+	if prefix == "" {
+		return store
+	}
+	return prefixed{store: store, prefix: strings.TrimSuffix(prefix, "/") + "/"}
+}
+
+type prefixed struct {
+	store  Store
+	prefix string
+}
+
+func (view prefixed) Put(ctx context.Context, key string, body io.Reader, info Info) error {
+	return view.store.Put(ctx, view.prefix+key, body, info)
+}
+
+func (view prefixed) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	return view.store.Get(ctx, view.prefix+key)
+}
+
+func (view prefixed) Delete(ctx context.Context, key string) error {
+	return view.store.Delete(ctx, view.prefix+key)
+}
+
+// MemoryStore keeps blobs in memory, for servers without blob storage
+type MemoryStore struct {
+	mutex sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data []byte
+	info Info
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blobs: make(map[string]memoryBlob)}
+}
+
+func (store *MemoryStore) Put(_ context.Context, key string, body io.Reader, info Info) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	info.Size = int64(len(data))
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.blobs[key] = memoryBlob{data: data, info: info}
+	return nil
+}
+
+func (store *MemoryStore) Get(_ context.Context, key string) (io.ReadCloser, Info, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	blob, ok := store.blobs[key]
+	if !ok {
+		return nil, Info{}, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(blob.data)), blob.info, nil
+}
+
+func (store *MemoryStore) Delete(_ context.Context, key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.blobs, key)
+	return nil
+}
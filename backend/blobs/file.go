@@ -0,0 +1,104 @@
+package blobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps each blob in a file under Dir, with its info in a JSON
+// file next to it. Key segments are escaped so keys can't leave Dir.
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file blob storage needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (store *FileStore) path(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+		if segments[i] == "." || segments[i] == ".." {
+			segments[i] = strings.ReplaceAll(segments[i], ".", "%2E")
+		}
+	}
+	return filepath.Join(store.Dir, filepath.Join(segments...))
+}
+
+// Put writes the blob to a temporary file first so a failed upload leaves
+// any blob it would have replaced alone
+func (store *FileStore) Put(_ context.Context, key string, body io.Reader, info Info) error {
+	path := store.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	info.Size, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".json", raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (store *FileStore) Get(_ context.Context, key string) (io.ReadCloser, Info, error) {
+	path := store.path(key)
+	raw, err := os.ReadFile(path + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	var info Info
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, Info{}, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return file, info, nil
+}
+
+func (store *FileStore) Delete(_ context.Context, key string) error {
+	path := store.path(key)
+	for _, name := range []string{path, path + ".json"} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"backend/awssig"
+)
+
+// S3Store keeps blobs as objects in a bucket of S3 or a compatible store
+// such as MinIO. Credentials come from the standard AWS environment
+// variables.
+type S3Store struct {
+	Bucket   string
+	Region   string
+	Endpoint *url.URL
+	// PathStyle addresses the bucket in the path rather than the host
+	// name, as most S3-compatible stores need
+	PathStyle bool
+	// Encryption is the server-side encryption objects are put with,
+	// AES256 or aws:kms, and KMSKeyID the key for aws:kms; without it the
+	// bucket's default key is used
+	Encryption string
+	KMSKeyID   string
+	Client     *http.Client
+
+	creds awssig.Credentials
+}
+
+// NewS3Store opens the bucket of a DSN such as
+// s3://bucket?region=eu-west-1 for AWS or
+// s3://bucket?endpoint=http://minio:9000&path_style=true for a compatible
+// store. The region defaults to us-east-1.
+func NewS3Store(dsn string) (*S3Store, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("S3 DSN must be s3://bucket")
+	}
+	query := parsed.Query()
+	store := &S3Store{
+		Bucket:    parsed.Host,
+		Region:    query.Get("region"),
+		PathStyle: query.Get("path_style") == "true",
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	if store.Region == "" {
+		store.Region = "us-east-1"
+	}
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "https://s3." + store.Region + ".amazonaws.com"
+	}
+	if store.Endpoint, err = url.Parse(endpoint); err != nil || store.Endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint must be an absolute URL")
+	}
+	if store.creds, err = awssig.FromEnv(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *S3Store) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	object := *store.Endpoint
+	object.Path, object.RawPath = "/"+key, "/"+strings.Join(segments, "/")
+	if store.PathStyle {
+		object.Path, object.RawPath = "/"+store.Bucket+object.Path, "/"+escape(store.Bucket)+object.RawPath
+	} else {
+		object.Host = store.Bucket + "." + object.Host
+	}
+	return object.String()
+}
+
+func (store *S3Store) request(ctx context.Context, method string, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, store.objectURL(key), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", awssig.UnsignedPayload)
+	return req, nil
+}
+
+func (store *S3Store) do(req *http.Request) (*http.Response, error) {
+	awssig.Sign(req, awssig.UnsignedPayload, store.creds, store.Region, "s3", time.Now())
+	resp, err := store.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s returned %s: %s", req.Method, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// Put uploads the blob in one request. S3 needs its length up front, so a
+// blob whose info has no size is read into memory first.
+func (store *S3Store) Put(ctx context.Context, key string, body io.Reader, info Info) error {
+	if info.Size <= 0 {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		body, info.Size = bytes.NewReader(data), int64(len(data))
+	}
+	req, err := store.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size
+	req.Header.Set("Content-Type", info.ContentType)
+	if store.Encryption != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", store.Encryption)
+		if store.KMSKeyID != "" {
+			req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", store.KMSKeyID)
+		}
+	}
+	if info.Name != "" {
+		req.Header.Set("X-Amz-Meta-Name", url.QueryEscape(info.Name))
+	}
+	resp, err := store.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (store *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	req, err := store.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	resp, err := store.do(req)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	info := Info{ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength}
+	info.Name, _ = url.QueryUnescape(resp.Header.Get("X-Amz-Meta-Name"))
+	return resp.Body, info, nil
+}
+
+func (store *S3Store) Delete(ctx context.Context, key string) error {
+	req, err := store.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := store.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// escape encodes a key segment the way Signature Version 4 expects,
+// leaving only unreserved characters as they are
+func escape(segment string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(segment) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '.' || b == '_' || b == '~' {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,48 @@
+// Package buildinfo reports which build of the server is running
+package buildinfo
+
+import (
+	"runtime/debug"
+)
+
+// Version and Commit are set at build time, e.g.
+//
+//	go build -ldflags "-X backend/buildinfo.Version=1.4.0 -X backend/buildinfo.Commit=$(git rev-parse HEAD)"
+//
+// Without them the commit is taken from the VCS stamp Go embeds in builds
+// made inside a checkout.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = build.GoVersion
+	if info.Commit != "" {
+		return info
+	}
+	var modified bool
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if info.Commit != "" && modified {
+		info.Commit += "-dirty"
+	}
+	return info
+}
@@ -0,0 +1,119 @@
+// Package canary runs an alternative document engine in shadow of the
+// primary one on a share of rooms, so that a replacement engine can be
+// compared against real traffic before any client depends on it
+package canary
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+
+	"backend/document"
+	"backend/metrics"
+)
+
+// Engine is an alternative implementation of the document model. It is
+// given every op accepted in a canary room, in revision order, and keeps
+// its own idea of the document's text.
+type Engine interface {
+	Name() string
+
+	// Apply takes an accepted op and returns the engine's resulting text
+	Apply(docID string, op document.Op) (string, error)
+
+	// Forget drops what the engine holds for a document; it starts over
+	// from the next op
+	Forget(docID string)
+}
+
+var engines = map[string]func() Engine{
+	"relay": func() Engine { return RelayEngine{} },
+}
+
+// NewEngine returns the engine registered under name
+func NewEngine(name string) (Engine, error) {
+	newEngine, ok := engines[name]
+	if !ok {
+		names := make([]string, 0, len(engines))
+		for name := range engines {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown canary engine %q, available: %v", name, names)
+	}
+	return newEngine(), nil
+}
+
+// Outcomes of comparing the engines on an op
+const (
+	ResultMatch    = "match"
+	ResultDiverged = "diverged"
+	ResultError    = "error"
+)
+
+// Runner assigns Percent of rooms to the canary engine and checks it
+// against the primary engine on every op in those rooms. A nil Runner
+// covers no rooms.
+type Runner struct {
+	engine  Engine
+	percent uint32
+	logger  *slog.Logger
+}
+
+func NewRunner(engine Engine, percent int, logger *slog.Logger) *Runner {
+	return &Runner{engine: engine, percent: uint32(percent), logger: logger}
+}
+
+// Covers reports whether docID is a canary room. Assignment hashes the
+// document ID, so a room stays in or out across restarts and nodes.
+func (runner *Runner) Covers(docID string) bool {
+	if runner == nil {
+		return false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(docID))
+	return hash.Sum32()%100 < runner.percent
+}
+
+// Check feeds op to the canary engine and compares its text with primary,
+// the primary engine's text at op.Revision. After a divergence the engine
+// starts the document over so one bad op isn't reported on every later
+// one.
+func (runner *Runner) Check(docID string, op document.Op, primary string) string {
+	name := runner.engine.Name()
+	text, err := runner.engine.Apply(docID, op)
+	switch {
+	case err != nil:
+		runner.logger.Warn("Canary engine failed", "engine", name, "doc_id", docID, "revision", op.Revision, "error", err)
+		runner.engine.Forget(docID)
+		metrics.CanaryOps.WithLabelValues(name, ResultError).Inc()
+		return ResultError
+	case text != primary:
+		runner.logger.Warn("Canary engine diverged", "engine", name, "doc_id", docID, "revision", op.Revision,
+			"position", firstDifference(text, primary), "canary_length", len([]rune(text)), "primary_length", len([]rune(primary)))
+		runner.engine.Forget(docID)
+		metrics.CanaryOps.WithLabelValues(name, ResultDiverged).Inc()
+		return ResultDiverged
+	}
+	metrics.CanaryOps.WithLabelValues(name, ResultMatch).Inc()
+	return ResultMatch
+}
+
+// Forget drops the canary engine's state for a document unloaded from
+// memory
+func (runner *Runner) Forget(docID string) {
+	if runner != nil {
+		runner.engine.Forget(docID)
+	}
+}
+
+// firstDifference is the index of the first rune where a and b differ
+func firstDifference(a string, b string) int {
+	x, y := []rune(a), []rune(b)
+	i := 0
+	for i < len(x) && i < len(y) && x[i] == y[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,41 @@
+package canary
+
+import (
+	"encoding/json"
+	"errors"
+
+	"backend/document"
+	"backend/richtext"
+)
+
+// RelayEngine is the relay model clients have used: each edit frame
+// carries the whole document as HTML and replaces the receiver's copy.
+// Running it as the canary checks that what relay clients rebuild from
+// those frames is the document the server holds.
+type RelayEngine struct{}
+
+func (RelayEngine) Name() string {
+	return "relay"
+}
+
+func (RelayEngine) Apply(docID string, op document.Op) (string, error) {
+	var frame struct {
+		Data struct {
+			Content *string `json:"content"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(op.Payload, &frame); err != nil {
+		return "", err
+	}
+	if frame.Data.Content == nil {
+		return "", errors.New("relayed frame has no data.content")
+	}
+	content, err := richtext.FromHTML(*frame.Data.Content)
+	if err != nil {
+		return "", err
+	}
+	return content.Text(), nil
+}
+
+// Forget is a no-op, every frame is a complete document
+func (RelayEngine) Forget(docID string) {}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"backend/sanitize"
+)
+
+var (
+	ErrEmptyMessage   = errors.New("chat message is empty")
+	ErrMessageTooLong = errors.New("chat message is too long")
+)
+
+// Message is a chat line as stored and sent to clients. UserData always
+// comes from the server-side identity of the sender.
+type Message struct {
+	ID       string            `json:"id"`
+	Text     string            `json:"text"`
+	SentAt   time.Time         `json:"sentAt"`
+	UserData map[string]string `json:"userData"`
+}
+
+// History keeps the most recent messages of every document
+type History struct {
+	mutex    sync.RWMutex
+	messages map[string][]Message
+	limit    int
+}
+
+func NewHistory(limit int) *History {
+	return &History{
+		messages: make(map[string][]Message),
+		limit:    limit,
+	}
+}
+
+func (history *History) Append(docID string, message Message) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	messages := append(history.messages[docID], message)
+	if len(messages) > history.limit {
+		messages = messages[len(messages)-history.limit:]
+	}
+	history.messages[docID] = messages
+}
+
+// Restore replaces a document's messages, such as with those handed over
+// by another node
+func (history *History) Restore(docID string, messages []Message) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	if len(messages) == 0 {
+		delete(history.messages, docID)
+		return
+	}
+	if len(messages) > history.limit {
+		messages = messages[len(messages)-history.limit:]
+	}
+	history.messages[docID] = append([]Message{}, messages...)
+}
+
+// Recent returns a copy of the stored messages of a document, oldest first
+func (history *History) Recent(docID string) []Message {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	return append([]Message{}, history.messages[docID]...)
+}
+
+// Sanitize trims the text, strips control characters other than newlines
+// and tabs, enforces the length limit and HTML-escapes the result
+func Sanitize(text string, maxLength int) (string, error) {
+	text = sanitize.Text(strings.TrimSpace(text), true)
+
+	if text == "" {
+		return "", ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(text) > maxLength {
+		return "", ErrMessageTooLong
+	}
+	return html.EscapeString(text), nil
+}
@@ -0,0 +1,253 @@
+// Command apiclient-gen writes the operations and types of the apiclient
+// package from the OpenAPI document of the API: a method for every
+// documented operation and a Go type for every schema they use.
+//
+//	go generate ./apiclient
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"backend/api"
+	"backend/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Words written in capitals in Go names
+var initialisms = map[string]bool{"id": true, "url": true, "tls": true, "api": true, "html": true, "json": true, "http": true, "ws": true}
+
+func main() {
+	out := flag.String("out", "client_gen.go", "file to write")
+	pkg := flag.String("package", "apiclient", "package of the written file")
+	flag.Parse()
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	(&api.Handler{}).RegisterRoutes(router)
+	spec := api.Spec(router.Routes())
+
+	var code bytes.Buffer
+	writeOperations(&code, spec)
+	writeTypes(&code, spec)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by apiclient-gen from the API's OpenAPI document. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\nimport (\n", *pkg)
+	for _, imported := range []string{"context", "encoding/json", "net/url", "time"} {
+		if bytes.Contains(code.Bytes(), []byte(imported[strings.LastIndex(imported, "/")+1:]+".")) {
+			fmt.Fprintf(&buf, "%q\n", imported)
+		}
+	}
+	fmt.Fprintf(&buf, ")\n\n")
+	buf.Write(code.Bytes())
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("formatting generated code: %v\n%s", err, buf.Bytes())
+	}
+	if err := os.WriteFile(*out, source, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// endpoint is a documented operation with where it is served
+type endpoint struct {
+	method    string
+	path      string
+	operation openapi.Operation
+}
+
+func writeOperations(buf *bytes.Buffer, spec *openapi.Document) {
+	var endpoints []endpoint
+	for path, methods := range spec.Paths {
+		for method, operation := range methods {
+			if operation.OperationID != "" {
+				endpoints = append(endpoints, endpoint{strings.ToUpper(method), path, operation})
+			}
+		}
+	}
+	slices.SortFunc(endpoints, func(a, b endpoint) int { return strings.Compare(a.operation.OperationID, b.operation.OperationID) })
+
+	for _, endpoint := range endpoints {
+		operation := endpoint.operation
+		name := goName(operation.OperationID)
+
+		args := []string{"ctx context.Context"}
+		path := `"` + endpoint.path + `"`
+		var query []openapi.Parameter
+		for _, parameter := range operation.Parameters {
+			switch parameter.In {
+			case "path":
+				arg := argName(parameter.Name)
+				args = append(args, arg+" string")
+				path = strings.Replace(path, "{"+parameter.Name+"}", `"+url.PathEscape(`+arg+`)+"`, 1)
+			case "query":
+				query = append(query, parameter)
+			}
+		}
+		path = strings.TrimSuffix(path, `+""`)
+		if len(query) > 0 {
+			fmt.Fprintf(buf, "// %sParams are the optional query parameters of %s\n", name, name)
+			fmt.Fprintf(buf, "type %sParams struct {\n", name)
+			for _, parameter := range query {
+				if parameter.Description != "" {
+					fmt.Fprintf(buf, "// %s\n", parameter.Description)
+				}
+				fmt.Fprintf(buf, "%s string\n", goName(parameter.Name))
+			}
+			fmt.Fprintf(buf, "}\n\n")
+			args = append(args, "params *"+name+"Params")
+		}
+		body := "nil"
+		if operation.RequestBody != nil {
+			args = append(args, "body "+goType(operation.RequestBody.Content["application/json"].Schema))
+			body = "body"
+		}
+		result := "json.RawMessage"
+		for status, response := range operation.Responses {
+			if strings.HasPrefix(status, "2") && response.Content != nil {
+				result = goType(response.Content["application/json"].Schema)
+			}
+		}
+
+		fmt.Fprintf(buf, "// %s calls %s %s: %s\n", name, endpoint.method, endpoint.path, lowerFirst(operation.Summary))
+		fmt.Fprintf(buf, "func (client *Client) %s(%s) (*%s, error) {\n", name, strings.Join(args, ", "), result)
+		queryArg := "nil"
+		if len(query) > 0 {
+			queryArg = "query"
+			fmt.Fprintf(buf, "query := url.Values{}\nif params != nil {\n")
+			for _, parameter := range query {
+				field := goName(parameter.Name)
+				fmt.Fprintf(buf, "if params.%s != \"\" {\nquery.Set(%q, params.%s)\n}\n", field, parameter.Name, field)
+			}
+			fmt.Fprintf(buf, "}\n")
+		}
+		fmt.Fprintf(buf, "var out %s\n", result)
+		fmt.Fprintf(buf, "if err := client.do(ctx, %q, %s, %s, %s, &out); err != nil {\nreturn nil, err\n}\n", endpoint.method, path, queryArg, body)
+		fmt.Fprintf(buf, "return &out, nil\n}\n\n")
+	}
+}
+
+func writeTypes(buf *bytes.Buffer, spec *openapi.Document) {
+	names := make([]string, 0, len(spec.Components.Schemas))
+	for name := range spec.Components.Schemas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(buf, "type %s %s\n\n", name, goStruct(spec.Components.Schemas[name]))
+	}
+}
+
+// goStruct is the Go struct encoding an object schema. Fields that aren't
+// required are left out of requests when empty.
+func goStruct(schema *openapi.Schema) string {
+	properties := make([]string, 0, len(schema.Properties))
+	for property := range schema.Properties {
+		properties = append(properties, property)
+	}
+	slices.Sort(properties)
+
+	var buf strings.Builder
+	buf.WriteString("struct {\n")
+	for _, property := range properties {
+		fieldSchema := schema.Properties[property]
+		fieldType := goType(fieldSchema)
+		tag := property
+		if !slices.Contains(schema.Required, property) {
+			tag += ",omitempty"
+			if fieldSchema.Ref != "" || fieldType == "time.Time" {
+				fieldType = "*" + fieldType
+			}
+		}
+		fmt.Fprintf(&buf, "%s %s `json:%q`\n", goName(property), fieldType, tag)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func goType(schema *openapi.Schema) string {
+	if schema.Ref != "" {
+		return schema.Ref[strings.LastIndex(schema.Ref, "/")+1:]
+	}
+	var name string
+	switch schema.Type {
+	case "string":
+		switch schema.Format {
+		case "date-time":
+			name = "time.Time"
+		case "byte":
+			name = "[]byte"
+		default:
+			name = "string"
+		}
+	case "integer":
+		name = "int"
+		if schema.Format == "int64" {
+			name = "int64"
+		}
+	case "number":
+		name = "float64"
+	case "boolean":
+		name = "bool"
+	case "array":
+		return "[]" + goType(schema.Items)
+	case "object":
+		if schema.AdditionalProperties != nil {
+			return "map[string]" + goType(schema.AdditionalProperties)
+		}
+		name = goStruct(schema)
+	default:
+		return "json.RawMessage"
+	}
+	if schema.Nullable {
+		return "*" + name
+	}
+	return name
+}
+
+// goName is the exported Go name of a camelCase JSON name
+func goName(name string) string {
+	var words []string
+	start := 0
+	runes := []rune(name)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && !unicode.IsUpper(runes[i-1]) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	words = append(words, string(runes[start:]))
+	for i, word := range words {
+		if initialisms[strings.ToLower(word)] {
+			words[i] = strings.ToUpper(word)
+		} else {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// argName is a path parameter's name as a Go argument
+func argName(name string) string {
+	if name == "id" {
+		return "docID"
+	}
+	return lowerFirst(goName(name))
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How long a client waits for the document after connecting
+const joinTimeout = 10 * time.Second
+
+// Every edit inserts a marker naming its client and number, so the relay
+// of the edit can be told apart in the other clients of the room
+var markerPattern = regexp.MustCompile(`⟦\d+\.\d+⟧`)
+
+type message struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// client is a simulated user in a room
+type client struct {
+	id    int
+	room  string
+	conn  *websocket.Conn
+	stats *stats
+
+	writeMutex sync.Mutex
+
+	mutex    sync.Mutex
+	revision int64
+	seq      int64
+	pending  map[int64]string
+	closing  bool
+	joined   chan struct{}
+}
+
+// dial connects a client to room and waits for the document
+func dial(endpoint string, room string, id int, stats *stats) (*client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint+"?doc="+url.QueryEscape(room), nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		id:      id,
+		room:    room,
+		conn:    conn,
+		stats:   stats,
+		pending: make(map[int64]string),
+		joined:  make(chan struct{}),
+	}
+	go c.read()
+
+	select {
+	case <-c.joined:
+		return c, nil
+	case <-time.After(joinTimeout):
+		conn.Close()
+		return nil, errors.New("no doc-sync received")
+	}
+}
+
+// generate sends edits and typing updates at about the given rates, per
+// second, until stop closes
+func (c *client) generate(editRate float64, cursorRate float64, stop <-chan struct{}) {
+	edits, editInterval := newPacer(editRate)
+	cursors, cursorInterval := newPacer(cursorRate)
+	defer stopPacer(edits)
+	defer stopPacer(cursors)
+
+	for {
+		select {
+		case <-timerC(edits):
+			c.edit()
+			edits.Reset(jitter(editInterval))
+		case <-timerC(cursors):
+			c.send(message{Type: "typing", Data: json.RawMessage(`{"typing":true}`)})
+			cursors.Reset(jitter(cursorInterval))
+		case <-stop:
+			return
+		}
+	}
+}
+
+// edit inserts a marker at the start of the document against the latest
+// revision the client knows of, numbered so the server acknowledges it
+func (c *client) edit() {
+	c.mutex.Lock()
+	c.seq++
+	seq, revision := c.seq, c.revision
+	marker := fmt.Sprintf("⟦%d.%d⟧", c.id, seq)
+	c.pending[seq] = marker
+	c.mutex.Unlock()
+
+	data, err := json.Marshal(map[string]any{
+		"delta":        []map[string]string{{"insert": marker}},
+		"baseRevision": revision,
+		"seq":          seq,
+		"position":     map[string]int{"x": rand.IntN(800), "y": rand.IntN(600)},
+	})
+	if err != nil {
+		return
+	}
+	c.stats.editSent(marker, time.Now())
+	c.send(message{Type: "content", Data: data})
+}
+
+func (c *client) send(m message) {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if err := c.conn.WriteMessage(websocket.TextMessage, raw); err != nil {
+		c.stats.count("write errors")
+	}
+}
+
+func (c *client) close() {
+	c.mutex.Lock()
+	c.closing = true
+	c.mutex.Unlock()
+
+	c.writeMutex.Lock()
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMutex.Unlock()
+	c.conn.Close()
+}
+
+// read handles what the server sends until the connection closes. The
+// server batches messages into one frame, one per line.
+func (c *client) read() {
+	for {
+		_, frame, err := c.conn.ReadMessage()
+		if err != nil {
+			c.mutex.Lock()
+			closing := c.closing
+			c.mutex.Unlock()
+			if !closing {
+				c.stats.count("disconnects")
+			}
+			return
+		}
+		at := time.Now()
+		for line := range strings.Lines(string(frame)) {
+			var m message
+			if json.Unmarshal([]byte(line), &m) != nil {
+				c.stats.count("undecodable messages")
+				continue
+			}
+			c.handle(m, at)
+		}
+	}
+}
+
+func (c *client) handle(m message, at time.Time) {
+	switch m.Type {
+	case "doc-sync":
+		var data struct {
+			Revision int64 `json:"revision"`
+		}
+		if json.Unmarshal(m.Data, &data) == nil {
+			c.seen(data.Revision)
+		}
+		select {
+		case <-c.joined:
+		default:
+			close(c.joined)
+		}
+	case "content":
+		var data struct {
+			Revision int64           `json:"revision"`
+			Delta    json.RawMessage `json:"delta"`
+		}
+		if json.Unmarshal(m.Data, &data) != nil {
+			return
+		}
+		c.seen(data.Revision)
+		for _, marker := range markerPattern.FindAllString(string(data.Delta), -1) {
+			c.stats.editRelayed(marker, at)
+		}
+	case "edit-ack":
+		var data struct {
+			Seq      int64  `json:"seq"`
+			Status   string `json:"status"`
+			Revision int64  `json:"revision"`
+		}
+		if json.Unmarshal(m.Data, &data) != nil {
+			return
+		}
+		c.seen(data.Revision)
+		c.mutex.Lock()
+		marker, ok := c.pending[data.Seq]
+		delete(c.pending, data.Seq)
+		c.mutex.Unlock()
+		if ok {
+			c.stats.editAcked(marker, c.room, data.Status, at)
+		}
+	case "error":
+		var data struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(m.Data, &data) != nil {
+			return
+		}
+		if data.Error.Code == "stale-revision" {
+			c.stats.count("stale revisions")
+		} else {
+			c.stats.count("error " + data.Error.Code)
+		}
+	case "resync-required":
+		c.stats.count("resyncs required")
+	case "rate-limited":
+		c.stats.count("rate limit warnings")
+	case "edit-retransmit":
+		c.stats.count("retransmits requested")
+	}
+}
+
+// seen records a revision of the document, which the next edit is based on
+func (c *client) seen(revision int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.revision = max(c.revision, revision)
+}
+
+// newPacer returns a timer firing about rate times per second, or nil when
+// rate is zero
+func newPacer(rate float64) (*time.Timer, time.Duration) {
+	if rate <= 0 {
+		return nil, 0
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	return time.NewTimer(jitter(interval)), interval
+}
+
+func stopPacer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// timerC is the channel of timer, or nil, which never fires, without one
+func timerC(timer *time.Timer) <-chan time.Time {
+	if timer == nil {
+		return nil
+	}
+	return timer.C
+}
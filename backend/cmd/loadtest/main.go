@@ -0,0 +1,255 @@
+// Command loadtest runs simulated clients against a collaboration server:
+// a number of rooms, each joined by a number of clients that make edits
+// and send typing activity at given rates. It reports how long edits took
+// to be acknowledged and to reach the rest of their room, in percentiles,
+// and counts what was rejected, dropped or never arrived.
+//
+//	go run ./cmd/loadtest -url ws://localhost:8080/ws -rooms 20 -clients 10 -duration 1m
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type options struct {
+	URL        string
+	Rooms      int
+	Clients    int
+	Duration   time.Duration
+	Ramp       time.Duration
+	Drain      time.Duration
+	EditRate   float64
+	CursorRate float64
+	RoomPrefix string
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.URL, "url", "ws://localhost:8080/ws", "WebSocket endpoint of the server")
+	flag.IntVar(&opts.Rooms, "rooms", 10, "rooms to open")
+	flag.IntVar(&opts.Clients, "clients", 10, "clients joining each room")
+	flag.DurationVar(&opts.Duration, "duration", 30*time.Second, "how long clients generate traffic once connected")
+	flag.DurationVar(&opts.Ramp, "ramp", 5*time.Second, "period over which clients connect")
+	flag.DurationVar(&opts.Drain, "drain", 3*time.Second, "how long to wait for acks and relays after traffic stops")
+	flag.Float64Var(&opts.EditRate, "edit-rate", 1, "edits per second per client")
+	flag.Float64Var(&opts.CursorRate, "cursor-rate", 4, "typing updates per second per client, standing in for cursor movement")
+	flag.StringVar(&opts.RoomPrefix, "room-prefix", "loadtest-"+strconv.FormatInt(time.Now().Unix(), 36)+"-", "prefix of the document IDs of the rooms")
+	flag.Parse()
+
+	if opts.Rooms < 1 || opts.Clients < 1 || opts.Duration <= 0 || opts.Ramp < 0 || opts.Drain < 0 ||
+		opts.EditRate < 0 || opts.CursorRate < 0 {
+		fmt.Fprintln(os.Stderr, "rooms and clients must be at least 1, duration positive and rates and periods not negative")
+		os.Exit(2)
+	}
+
+	stats := newStats()
+	total := opts.Rooms * opts.Clients
+	log.Printf("Connecting %d clients to %d rooms over %s", total, opts.Rooms, opts.Ramp)
+
+	var clients []*client
+	var mutex sync.Mutex
+	var connecting sync.WaitGroup
+	for i := range total {
+		room := fmt.Sprintf("%s%d", opts.RoomPrefix, i%opts.Rooms)
+		delay := time.Duration(0)
+		if total > 1 {
+			delay = opts.Ramp * time.Duration(i) / time.Duration(total-1)
+		}
+		connecting.Add(1)
+		go func() {
+			defer connecting.Done()
+			time.Sleep(delay)
+			c, err := dial(opts.URL, room, i, stats)
+			if err != nil {
+				stats.count("connect failures")
+				log.Printf("Client %d could not connect: %v", i, err)
+				return
+			}
+			mutex.Lock()
+			clients = append(clients, c)
+			mutex.Unlock()
+		}()
+	}
+	connecting.Wait()
+	if len(clients) == 0 {
+		log.Fatal("No client could connect")
+	}
+	for _, c := range clients {
+		stats.join(c.room)
+	}
+
+	log.Printf("%d clients connected, generating traffic for %s", len(clients), opts.Duration)
+	stop := make(chan struct{})
+	var running sync.WaitGroup
+	for _, c := range clients {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			c.generate(opts.EditRate, opts.CursorRate, stop)
+		}()
+	}
+	progress := time.NewTicker(5 * time.Second)
+	deadline := time.After(opts.Duration)
+generating:
+	for {
+		select {
+		case <-progress.C:
+			log.Print(stats.progress())
+		case <-deadline:
+			break generating
+		}
+	}
+	progress.Stop()
+	close(stop)
+	running.Wait()
+
+	log.Printf("Traffic stopped, waiting %s for acks and relays", opts.Drain)
+	time.Sleep(opts.Drain)
+	for _, c := range clients {
+		c.close()
+	}
+	stats.report(os.Stdout, len(clients))
+}
+
+// stats gathers what every client measured
+type stats struct {
+	mutex sync.Mutex
+
+	ackLatency   []time.Duration
+	relayLatency []time.Duration
+	counts       map[string]int
+	roomSizes    map[string]int
+
+	// sent maps the marker of every edit to when it was sent, and
+	// committed records the room of those the server committed
+	sent      map[string]time.Time
+	committed map[string]string
+	relayed   int
+}
+
+func newStats() *stats {
+	return &stats{
+		counts:    make(map[string]int),
+		roomSizes: make(map[string]int),
+		sent:      make(map[string]time.Time),
+		committed: make(map[string]string),
+	}
+}
+
+func (s *stats) count(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.counts[name]++
+}
+
+func (s *stats) join(room string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.roomSizes[room]++
+}
+
+func (s *stats) editSent(marker string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent[marker] = at
+	s.counts["edits sent"]++
+}
+
+func (s *stats) editAcked(marker string, room string, status string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.counts["edits "+status]++
+	if sentAt, ok := s.sent[marker]; ok {
+		s.ackLatency = append(s.ackLatency, at.Sub(sentAt))
+	}
+	if status == "committed" {
+		s.committed[marker] = room
+	}
+}
+
+func (s *stats) editRelayed(marker string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sentAt, ok := s.sent[marker]; ok {
+		s.relayLatency = append(s.relayLatency, at.Sub(sentAt))
+		s.relayed++
+	}
+}
+
+func (s *stats) progress() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return fmt.Sprintf("%d edits sent, %d committed, %d stale, %d relays received, %d resyncs",
+		s.counts["edits sent"], s.counts["edits committed"], s.counts["stale revisions"], s.relayed,
+		s.counts["resyncs required"])
+}
+
+// report prints the results. An edit committed in a room is expected to
+// reach every other client that was connected to it; the relays that
+// didn't arrive are missed.
+func (s *stats) report(w io.Writer, connected int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expected := 0
+	for _, room := range s.committed {
+		expected += s.roomSizes[room] - 1
+	}
+	fmt.Fprintf(w, "\nClients connected   %d\n", connected)
+	fmt.Fprintf(w, "Edit ack latency    %s\n", percentiles(s.ackLatency))
+	fmt.Fprintf(w, "Edit relay latency  %s\n", percentiles(s.relayLatency))
+	fmt.Fprintf(w, "Relays              %d expected, %d received, %d missed\n", expected, s.relayed, max(expected-s.relayed, 0))
+	fmt.Fprintf(w, "Edits unacked       %d\n", s.counts["edits sent"]-s.acked())
+
+	names := make([]string, 0, len(s.counts))
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "Counts")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-30s %d\n", name, s.counts[name])
+	}
+}
+
+// acked is how many edits were acknowledged, whatever became of them. It
+// must be called with the lock held.
+func (s *stats) acked() int {
+	acked := 0
+	for name, count := range s.counts {
+		if strings.HasPrefix(name, "edits ") && name != "edits sent" {
+			acked += count
+		}
+	}
+	return acked
+}
+
+func percentiles(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "no samples"
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	at := func(p float64) time.Duration {
+		return sorted[min(int(p*float64(len(sorted))), len(sorted)-1)].Round(10 * time.Microsecond)
+	}
+	return fmt.Sprintf("p50 %s  p90 %s  p99 %s  max %s  (%d samples)",
+		at(0.50), at(0.90), at(0.99), sorted[len(sorted)-1].Round(10*time.Microsecond), len(sorted))
+}
+
+// jitter spreads an interval by up to a fifth either way, so clients
+// started together don't stay in lockstep
+func jitter(interval time.Duration) time.Duration {
+	return interval*4/5 + time.Duration(rand.Int64N(int64(interval)*2/5+1))
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backend/config"
+	"backend/document"
+	"backend/export"
+	"backend/richtext"
+	"backend/storage"
+)
+
+// command is something the server binary does, named by its first
+// argument. Each takes the server's configuration flags besides its own.
+type command struct {
+	summary string
+	run     func(args []string)
+}
+
+var commands = map[string]command{
+	"serve":       {"serve the editor (the default)", runServer},
+	"migrate":     {"rewrite every saved document in the current format; stop servers using the store first", runMigrate},
+	"export-doc":  {"export documents from the store to files", runExportDoc},
+	"list-docs":   {"list the documents in the store", runListDocs},
+	"purge-trash": {"delete documents that have been in the trash for too long", runPurgeTrash},
+	"rotate-keys": {"seal every saved document with the active encryption key; stop servers using the store first", runRotateKeys},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [flags] [args]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun a command with -h for its flags.\n")
+}
+
+// openStore opens the configured document store, which the commands that
+// work on saved documents can't do without
+func openStore(cfg *config.Config, logger *slog.Logger) (storage.Store, *document.Registry) {
+	if cfg.StorageDSN == "" {
+		logger.Error("No storage DSN configured, there are no saved documents to work on")
+		os.Exit(1)
+	}
+	store, err := storage.Open(cfg.StorageDSN, openKeyring(cfg, logger))
+	if err != nil {
+		logger.Error("Document store error", "error", err)
+		os.Exit(1)
+	}
+	registry := document.NewRegistry()
+	registry.SetStore(store)
+	return store, registry
+}
+
+// runMigrate loads every saved document and saves it again in the format
+// this version writes: content normalized, defaults filled in and op logs
+// that don't lead up to their revision dropped
+func runMigrate(args []string) {
+	var dryRun bool
+	cfg, _ := loadCommand("migrate", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "only list the documents that would be rewritten")
+	})
+	logger, resolver := setup(cfg)
+	defer resolver.Close()
+	store, registry := openStore(cfg, logger)
+	defer store.Close()
+
+	ctx := context.Background()
+	records, err := registry.Records(ctx)
+	if err != nil {
+		logger.Error("Could not list documents", "error", err)
+		os.Exit(1)
+	}
+	var rewritten, failed int
+	for _, record := range records {
+		doc, err := document.FromRecord(record)
+		if err != nil {
+			logger.Error("Could not read document", "doc_id", record.ID, "error", err)
+			failed++
+			continue
+		}
+		migrated := doc.Record()
+		migrated.Hibernated = record.Hibernated
+		before, _ := json.Marshal(record)
+		after, _ := json.Marshal(migrated)
+		if bytes.Equal(before, after) {
+			continue
+		}
+		if dryRun {
+			fmt.Println(record.ID)
+			rewritten++
+			continue
+		}
+		if err := store.Save(ctx, migrated); err != nil {
+			logger.Error("Could not save document", "doc_id", record.ID, "error", err)
+			failed++
+			continue
+		}
+		logger.Info("Migrated document", "doc_id", record.ID)
+		rewritten++
+	}
+	logger.Info("Migration finished", "documents", len(records), "rewritten", rewritten, "failed", failed, "dry_run", dryRun)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runExportDoc exports the documents named by the arguments, or every
+// document that isn't in the trash with -all. A single document goes to
+// standard output unless -out names a file; several go to files in the
+// -out directory, named after their IDs.
+func runExportDoc(args []string) {
+	var formatName, out, changes string
+	var all bool
+	cfg, ids := loadCommand("export-doc", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&formatName, "format", "md", "format to export to ("+strings.Join(export.Names(), ", ")+")")
+		fs.StringVar(&out, "out", "", "file to write a single document to, or directory to write several to (default standard output)")
+		fs.StringVar(&changes, "changes", "hide", "render pending tracked changes (show) or export as if they were accepted (hide)")
+		fs.BoolVar(&all, "all", false, "export every document that isn't in the trash")
+	})
+	format, err := export.Lookup(formatName)
+	if err != nil {
+		log.Fatal("format must be one of ", strings.Join(export.Names(), ", "))
+	}
+	if changes != "hide" && changes != "show" {
+		log.Fatal("changes must be hide or show")
+	}
+	logger, resolver := setup(cfg)
+	defer resolver.Close()
+	store, registry := openStore(cfg, logger)
+	defer store.Close()
+
+	ctx := context.Background()
+	if all {
+		summaries, err := registry.List(ctx)
+		if err != nil {
+			logger.Error("Could not list documents", "error", err)
+			os.Exit(1)
+		}
+		for _, summary := range summaries {
+			if summary.TrashedAt.IsZero() {
+				ids = append(ids, summary.ID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		log.Fatal("name the documents to export, or pass -all")
+	}
+	toDir := len(ids) > 1 || all
+	if toDir {
+		if out == "" {
+			log.Fatal("exporting several documents needs -out naming a directory")
+		}
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	failed := 0
+	for _, id := range ids {
+		path := out
+		if toDir {
+			path = filepath.Join(out, format.Filename(id))
+		}
+		if err := exportDocument(registry, id, format, changes == "show", path); err != nil {
+			logger.Error("Could not export document", "doc_id", id, "error", err)
+			failed++
+			continue
+		}
+		if path != "" {
+			logger.Info("Exported document", "doc_id", id, "path", path)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// exportDocument writes a document in format to path, or to standard
+// output when path is empty
+func exportDocument(registry *document.Registry, id string, format export.Format, showChanges bool, path string) error {
+	doc, err := registry.Lookup(id)
+	if err != nil {
+		return err
+	}
+	content, _ := doc.Snapshot()
+	if showChanges {
+		redline, _ := doc.Redline()
+		content = richtext.ToHTML(redline)
+	}
+	metadata := doc.Metadata()
+	info := export.Info{Title: metadata.Title, Language: metadata.Language, Direction: metadata.Direction}
+	if info.Title == "" {
+		info.Title = id
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	if err := format.Render(buffered, info, content); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// runListDocs prints the saved documents, most recently updated first, as
+// a table or as JSON lines
+func runListDocs(args []string) {
+	var trashed, asJSON bool
+	cfg, _ := loadCommand("list-docs", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&trashed, "trashed", false, "only list documents in the trash")
+		fs.BoolVar(&asJSON, "json", false, "print a JSON object per document")
+	})
+	logger, resolver := setup(cfg)
+	defer resolver.Close()
+	store, registry := openStore(cfg, logger)
+	defer store.Close()
+
+	summaries, err := registry.List(context.Background())
+	if err != nil {
+		logger.Error("Could not list documents", "error", err)
+		os.Exit(1)
+	}
+	if trashed {
+		summaries = slices.DeleteFunc(summaries, func(summary document.Summary) bool { return summary.TrashedAt.IsZero() })
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, summary := range summaries {
+			encoder.Encode(summary)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tTENANT\tREVISION\tUPDATED\tTRASHED")
+	for _, summary := range summaries {
+		trashedAt := ""
+		if !summary.TrashedAt.IsZero() {
+			trashedAt = summary.TrashedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintln(w, strings.Join([]string{summary.ID, summary.Metadata.Title, summary.Tenant,
+			strconv.FormatInt(summary.Revision, 10), summary.UpdatedAt.Format(time.RFC3339), trashedAt}, "\t"))
+	}
+	w.Flush()
+}
+
+// runPurgeTrash deletes the documents trashed longer ago than the trash
+// retention, or -older-than. Unlike the server's own purges it publishes
+// no events.
+func runPurgeTrash(args []string) {
+	var olderThan time.Duration
+	var dryRun bool
+	cfg, _ := loadCommand("purge-trash", args, func(fs *flag.FlagSet) {
+		fs.DurationVar(&olderThan, "older-than", 0, "purge documents trashed longer ago than this (default the trash retention)")
+		fs.BoolVar(&dryRun, "dry-run", false, "only list the documents that would be purged")
+	})
+	if olderThan == 0 {
+		olderThan = cfg.Trash.Retention
+	}
+	if olderThan <= 0 {
+		log.Fatal("no trash retention is configured, pass -older-than")
+	}
+	logger, resolver := setup(cfg)
+	defer resolver.Close()
+	store, registry := openStore(cfg, logger)
+	defer store.Close()
+
+	ctx := context.Background()
+	cutoff := time.Now().Add(-olderThan)
+	if dryRun {
+		summaries, err := registry.List(ctx)
+		if err != nil {
+			logger.Error("Could not list documents", "error", err)
+			os.Exit(1)
+		}
+		for _, summary := range summaries {
+			if !summary.TrashedAt.IsZero() && summary.TrashedAt.Before(cutoff) {
+				fmt.Println(summary.ID)
+			}
+		}
+		return
+	}
+	purged, err := registry.Purge(ctx, cutoff)
+	for _, id := range purged {
+		logger.Info("Purged trashed document", "doc_id", id)
+	}
+	if err != nil {
+		logger.Error("Could not purge the trash", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Purge finished", "purged", len(purged), "older_than", olderThan)
+}
+
+// runRotateKeys loads every saved document and saves it again, sealed with
+// the active encryption key. It finishes a key rotation, after which the
+// old key is only needed for snapshots, and seals documents saved before
+// encryption was enabled, so those are read whatever AllowPlaintext says.
+func runRotateKeys(args []string) {
+	cfg, _ := loadCommand("rotate-keys", args, nil)
+	if len(cfg.Encryption.Keys) == 0 {
+		log.Fatal("no encryption keys are configured")
+	}
+	cfg.Encryption.AllowPlaintext = true
+	logger, resolver := setup(cfg)
+	defer resolver.Close()
+	store, registry := openStore(cfg, logger)
+	defer store.Close()
+
+	ctx := context.Background()
+	records, err := registry.Records(ctx)
+	if err != nil {
+		logger.Error("Could not list documents", "error", err)
+		os.Exit(1)
+	}
+	var failed int
+	for _, record := range records {
+		if err := store.Save(ctx, record); err != nil {
+			logger.Error("Could not save document", "doc_id", record.ID, "error", err)
+			failed++
+		}
+	}
+	logger.Info("Rotation finished", "documents", len(records), "failed", failed, "active_key", cfg.Encryption.ActiveKey)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,99 @@
+package comments
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"backend/document"
+)
+
+const MaxTextLength = 5000
+
+var (
+	ErrNotFound        = errors.New("comment not found")
+	ErrAlreadyResolved = errors.New("comment is already resolved")
+)
+
+// Comment is a note attached to a range of a document. The anchor itself
+// lives in the document, which moves it as edits are applied; Anchor here
+// is filled in whenever a comment is read.
+type Comment struct {
+	ID         string            `json:"id"`
+	DocID      string            `json:"docId"`
+	Text       string            `json:"text"`
+	Author     map[string]string `json:"author"`
+	Anchor     document.Range    `json:"anchor"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	Resolved   bool              `json:"resolved"`
+	ResolvedAt *time.Time        `json:"resolvedAt,omitempty"`
+	ResolvedBy map[string]string `json:"resolvedBy,omitempty"`
+}
+
+type Store struct {
+	mutex    sync.RWMutex
+	comments map[string][]*Comment
+}
+
+func NewStore() *Store {
+	return &Store{comments: make(map[string][]*Comment)}
+}
+
+func (store *Store) Add(comment Comment) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.comments[comment.DocID] = append(store.comments[comment.DocID], &comment)
+}
+
+// Resolve marks a comment resolved by the given user and returns it
+func (store *Store) Resolve(docID string, id string, resolvedBy map[string]string) (Comment, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, comment := range store.comments[docID] {
+		if comment.ID != id {
+			continue
+		}
+		if comment.Resolved {
+			return Comment{}, ErrAlreadyResolved
+		}
+		now := time.Now().UTC()
+		comment.Resolved = true
+		comment.ResolvedAt = &now
+		comment.ResolvedBy = resolvedBy
+		return *comment, nil
+	}
+	return Comment{}, ErrNotFound
+}
+
+// Restore replaces a document's comments, such as with those handed over
+// by another node
+func (store *Store) Restore(docID string, comments []Comment) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if len(comments) == 0 {
+		delete(store.comments, docID)
+		return
+	}
+	restored := make([]*Comment, len(comments))
+	for i := range comments {
+		comment := comments[i]
+		comment.DocID = docID
+		restored[i] = &comment
+	}
+	store.comments[docID] = restored
+}
+
+// List returns copies of a document's comments in creation order
+func (store *Store) List(docID string) []Comment {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	list := make([]Comment, 0, len(store.comments[docID]))
+	for _, comment := range store.comments[docID] {
+		list = append(list, *comment)
+	}
+	return list
+}
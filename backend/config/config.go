@@ -0,0 +1,1539 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/tenants"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every tunable of the server. Values are resolved in order:
+// built-in defaults, optional YAML file, environment variables, then flags.
+type Config struct {
+	ListenAddr string `yaml:"listen_addr"`
+	// GRPCAddr serves the internal gRPC API of the document engine, in
+	// plain text unless TLS has certificate files; empty disables it. It
+	// is meant for other services and should not be reachable publicly.
+	GRPCAddr string `yaml:"grpc_addr"`
+
+	// AllowedOrigins are the browser origins allowed to open WebSockets
+	// and call the REST API: exact origins, "*." subdomain wildcards or
+	// "*" for any. DevMode allows every origin regardless and must not be
+	// used in production.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	DevMode        bool     `yaml:"dev_mode"`
+
+	// TrustedProxies are the reverse proxies, as addresses or CIDR blocks,
+	// whose X-Forwarded-For is believed to name the client. Requests from
+	// anywhere else are taken to come from their peer address.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	StorageDSN string        `yaml:"storage_dsn"`
+	RedisURL   string        `yaml:"redis_url"`
+	Log        LogConfig     `yaml:"log"`
+	Limits     Limits        `yaml:"limits"`
+	Kafka      KafkaConfig   `yaml:"kafka"`
+	Secrets    SecretsConfig `yaml:"secrets"`
+	TLS        TLSConfig     `yaml:"tls"`
+	LinkCheck  LinkCheck     `yaml:"link_check"`
+	Analysis   Analysis      `yaml:"analysis"`
+
+	Backpressure Backpressure `yaml:"backpressure"`
+	Fanout       Fanout       `yaml:"fanout"`
+	EditBatch    EditBatch    `yaml:"edit_batch"`
+	Compaction   Compaction   `yaml:"compaction"`
+
+	Compression Compression `yaml:"compression"`
+	Canary      Canary      `yaml:"canary"`
+	Recording   Recording   `yaml:"recording"`
+	EventLog    EventLog    `yaml:"event_log"`
+	Migration   Migration   `yaml:"migration"`
+	Cluster     Cluster     `yaml:"cluster"`
+	Share       Share       `yaml:"share"`
+	OAuth       OAuth       `yaml:"oauth"`
+	Webhooks    Webhooks    `yaml:"webhooks"`
+	Mail        Mail        `yaml:"mail"`
+	Attachments Attachments `yaml:"attachments"`
+	Blobs       Blobs       `yaml:"blobs"`
+	Backups     Backups     `yaml:"backups"`
+	Trash       Trash       `yaml:"trash"`
+	Audit       Audit       `yaml:"audit"`
+	Spectators  Spectators  `yaml:"spectators"`
+	Bots        Bots        `yaml:"bots"`
+	Latency     Latency     `yaml:"latency"`
+	Encryption  Encryption  `yaml:"encryption"`
+	Frontend    Frontend    `yaml:"frontend"`
+	Tenancy     Tenancy     `yaml:"tenancy"`
+
+	// APITokens grant access to the admin, moderation and metrics
+	// endpoints. Without any, those endpoints reject every request, except
+	// metrics which is left public.
+	APITokens []APIToken `yaml:"api_tokens"`
+
+	// PresenceRosterInterval is how often every room receives the full
+	// user list so clients can reconcile missed joins and leaves; zero
+	// disables the periodic roster
+	PresenceRosterInterval time.Duration `yaml:"presence_roster_interval"`
+
+	// PresenceTTL is how long a presence entry survives without being
+	// refreshed by the node holding the connection
+	PresenceTTL time.Duration `yaml:"presence_ttl"`
+
+	// SessionTTL is how long a disconnected session can still be resumed
+	SessionTTL time.Duration `yaml:"session_ttl"`
+
+	// DocumentIdleTimeout is how long a document stays in memory after its
+	// last client leaves before it is saved to StorageDSN and unloaded;
+	// zero keeps every document loaded
+	DocumentIdleTimeout time.Duration `yaml:"document_idle_timeout"`
+
+	// AutosaveInterval is how often documents changed since they were
+	// last saved are saved to StorageDSN while they stay loaded; zero
+	// only saves them when they unload
+	AutosaveInterval time.Duration `yaml:"autosave_interval"`
+
+	// HibernateAfter is how long a document stays unloaded before its
+	// chat, comments and duplicate index entry are flushed to StorageDSN
+	// and released, until the document is next opened; zero disables it
+	HibernateAfter time.Duration `yaml:"hibernate_after"`
+
+	// DuplicateThreshold is the similarity, from 0 to 1, above which a
+	// document is suggested as a duplicate of another
+	DuplicateThreshold float64 `yaml:"duplicate_threshold"`
+
+	// TitleSuggestDelay is how long a document nobody has titled must go
+	// unedited, with nobody typing in it, before it is titled after its
+	// first heading or line; zero disables title suggestions
+	TitleSuggestDelay time.Duration `yaml:"title_suggest_delay"`
+}
+
+// KafkaConfig enables the document change event stream when Brokers is set
+type KafkaConfig struct {
+	Brokers     []string `yaml:"brokers"`
+	TopicPrefix string   `yaml:"topic_prefix"`
+}
+
+// APIToken is a credential for the privileged endpoints. Only the token's
+// SHA-256, in hex, is configured so the config never holds the token itself.
+// A token with a Tenant only reaches that tenant's documents through the
+// gRPC API.
+type APIToken struct {
+	Name   string `yaml:"name"`
+	Role   string `yaml:"role"`
+	SHA256 string `yaml:"sha256"`
+	Tenant string `yaml:"tenant"`
+}
+
+// LinkCheck controls the background job that follows the external links
+// in documents to report broken ones
+type LinkCheck struct {
+	// Interval between runs; zero disables the job
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout for following a single link
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Analysis runs the text of documents whose owner enabled it through a
+// LanguageTool server at URL, such as "http://localhost:8010", once they
+// have been idle for IdleDelay, and shows the room what it finds. An empty
+// URL disables it.
+type Analysis struct {
+	URL       string        `yaml:"url"`
+	IdleDelay time.Duration `yaml:"idle_delay"`
+
+	// Timeout for analysing a single document
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Backpressure is how messages for a client whose send buffer is full are
+// handled. Messages of the Coalesce types are held back, only the latest
+// from each sender, until the buffer has room; LowPriority types are
+// dropped. Dropping anything else sends the client a resync-required and
+// the document state once the buffer has room. A client whose buffer stays
+// full for StallTimeout is disconnected; zero disconnects it as soon as
+// its buffer fills.
+type Backpressure struct {
+	Coalesce     []string      `yaml:"coalesce"`
+	LowPriority  []string      `yaml:"low_priority"`
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+}
+
+// Fanout spreads the delivery of each message to a room of more than
+// BatchSize clients over up to Workers goroutines, BatchSize clients to
+// each. Zero workers delivers to each client in turn, whatever the room.
+type Fanout struct {
+	Workers   int `yaml:"workers"`
+	BatchSize int `yaml:"batch_size"`
+}
+
+// EditBatch holds back the edits relayed to a room for up to Window, or
+// until MaxOps of them are held, so a run of keystrokes by one author goes
+// out as one frame. Zero Window relays every edit on its own.
+type EditBatch struct {
+	Window time.Duration `yaml:"window"`
+	MaxOps int           `yaml:"max_ops"`
+}
+
+// Compaction controls the background job that trims each document's op
+// log to the latest Limits.HistorySize ops
+type Compaction struct {
+	// Interval between runs; zero leaves compaction to the admin endpoint
+	Interval time.Duration `yaml:"interval"`
+
+	// MaxAge also drops ops older than this; zero keeps them regardless
+	// of age
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Compression negotiates permessage-deflate with clients that offer it.
+// Frames smaller than Threshold bytes, such as cursor updates, are sent
+// uncompressed since deflate barely shrinks them.
+type Compression struct {
+	Enabled   bool `yaml:"enabled"`
+	Level     int  `yaml:"level"`
+	Threshold int  `yaml:"threshold"`
+}
+
+// Canary runs the named alternative document engine in shadow of the
+// primary one on Percent of rooms and compares their results on every op
+type Canary struct {
+	Engine  string `yaml:"engine"`
+	Percent int    `yaml:"percent"`
+}
+
+// Recording records the full message streams of Percent of rooms to DSN,
+// a file:// or redis:// URL, for offline analysis. Session secrets are
+// always scrubbed from recorded messages, and Scrub names further
+// scrubbers: names, chat and text.
+type Recording struct {
+	Percent int      `yaml:"percent"`
+	DSN     string   `yaml:"dsn"`
+	Scrub   []string `yaml:"scrub"`
+}
+
+// EventLog appends every applied op and presence change to DSN, a
+// file:///path.ndjson or kafka://broker1,broker2/topic URL, for analytics
+// and replay tooling; an empty DSN disables it
+type EventLog struct {
+	DSN string `yaml:"dsn"`
+}
+
+// Frontend is the editor web app, served under BasePath. The build
+// embedded in the binary is served unless Dir names a directory to serve
+// instead, such as a frontend being worked on.
+type Frontend struct {
+	Dir      string `yaml:"dir"`
+	BasePath string `yaml:"base_path"`
+}
+
+// Migration is how this node hands rooms over to other nodes. Token is an
+// API token with an operator role on the receiving nodes; it may be a
+// secret reference.
+type Migration struct {
+	Token string `yaml:"token"`
+}
+
+// Cluster makes this node one of several sharing the documents, each
+// document owned by one node at a time through a lease renewed within
+// LeaseTTL. AdvertiseURL is where the other nodes reach this one; empty
+// keeps every document on this node. NodeID defaults to the host name.
+// Leases are kept in Redis, so every node needs the same RedisURL.
+type Cluster struct {
+	NodeID       string        `yaml:"node_id"`
+	AdvertiseURL string        `yaml:"advertise_url"`
+	LeaseTTL     time.Duration `yaml:"lease_ttl"`
+}
+
+// Share is how invitation links are signed. Secret is the signing key,
+// which every node must share and which may be a secret reference; empty
+// uses a random key, so links stop working on restart. MaxTTL caps how
+// long a link can stay valid.
+type Share struct {
+	Secret string        `yaml:"secret"`
+	MaxTTL time.Duration `yaml:"max_ttl"`
+}
+
+// OAuth is signing in with Google or GitHub. A provider is offered when
+// its client ID is set, and its client secret may be a secret reference.
+// RedirectURL is the public base URL of this server that providers send
+// browsers back to; ClientURL is where browsers go once signed in.
+type OAuth struct {
+	RedirectURL string      `yaml:"redirect_url"`
+	ClientURL   string      `yaml:"client_url"`
+	Google      OAuthClient `yaml:"google"`
+	GitHub      OAuthClient `yaml:"github"`
+}
+
+// OAuthClient is this server's registration at a login provider
+type OAuthClient struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+// Webhooks POSTs change events, signed with an HMAC-SHA256 of the body, to
+// outside services. URLs receive every event signed with Secret; Endpoints
+// can pick the events they receive and sign with their own secret. Secrets
+// may be secret references. Failed deliveries are retried MaxAttempts
+// times in all, waiting Backoff and then twice as long each time, and the
+// latest LogSize deliveries are kept for the admin API.
+type Webhooks struct {
+	URLs      []string          `yaml:"urls"`
+	Secret    string            `yaml:"secret"`
+	Endpoints []WebhookEndpoint `yaml:"endpoints"`
+
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+	Timeout     time.Duration `yaml:"timeout"`
+	LogSize     int           `yaml:"log_size"`
+}
+
+// Mail sends share invitations and digests of unread notifications by
+// email through the SMTP server at Host:Port, as From. Username and
+// Password, which may be a secret reference, authenticate when set. Links
+// in emails point to the client at ClientURL. Digests go out every
+// DigestInterval to users who haven't opted out; zero sends none. Without
+// a Host no email is sent.
+type Mail struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+
+	ClientURL      string        `yaml:"client_url"`
+	DigestInterval time.Duration `yaml:"digest_interval"`
+}
+
+// Attachments are files uploaded to documents, kept in the blob store at
+// DSN, file:// or s3://, or in memory without one. Uploads larger than
+// MaxSize bytes or whose sniffed content type isn't one of AllowedTypes
+// are refused. Workspace archives imported from other editors, images
+// included, may be up to MaxImportSize bytes.
+type Attachments struct {
+	DSN           string   `yaml:"dsn"`
+	MaxSize       int64    `yaml:"max_size"`
+	AllowedTypes  []string `yaml:"allowed_types"`
+	MaxImportSize int64    `yaml:"max_import_size"`
+}
+
+// Blobs is the object store for large objects: snapshots, exports of
+// them and attachments, unless Attachments has a DSN of its own. DSN is
+// file:// or s3://bucket, every key is put under Prefix, and Encryption,
+// AES256 or aws:kms, has S3 encrypt objects at rest with KMSKeyID or the
+// bucket's default key.
+type Blobs struct {
+	DSN        string `yaml:"dsn"`
+	Prefix     string `yaml:"prefix"`
+	Encryption string `yaml:"encryption"`
+	KMSKeyID   string `yaml:"kms_key_id"`
+}
+
+// Backups are full backups of every document, taken each Interval into
+// the store at DSN, file:// or s3://bucket, or under backups/ in the blob
+// store when DSN is empty. Only the latest Keep are kept, every one when
+// Keep is zero, and a zero Interval only takes them on demand.
+type Backups struct {
+	DSN      string        `yaml:"dsn"`
+	Interval time.Duration `yaml:"interval"`
+	Keep     int           `yaml:"keep"`
+}
+
+// Trash is how long documents stay in the trash before they are purged
+// for good, checked each PurgeInterval. A zero Retention keeps them until
+// restored.
+type Trash struct {
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// Audit is the retention policy of each document's audit trail. Entries
+// older than MaxAge are pruned, and so are the oldest past MaxEntries;
+// zero disables either limit.
+type Audit struct {
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxEntries int           `yaml:"max_entries"`
+}
+
+// Spectators keeps crowds of viewers out of presence. Past the first
+// Threshold view-only connections to a room, further ones join as
+// spectators: they are counted, not announced, and the room is sent the
+// count every Interval when it changed. A zero Threshold announces every
+// viewer.
+type Spectators struct {
+	Threshold int           `yaml:"threshold"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+// Bots are machine clients, such as a meeting-notes bot, connecting to
+// /ws/bot with an API token whose role grants bots:connect. A bot's single
+// connection may subscribe to up to MaxRooms rooms.
+type Bots struct {
+	MaxRooms int `yaml:"max_rooms"`
+}
+
+// Latency is how often every client is sent a ping to measure its round
+// trip and clock skew, which its presence then carries; zero only measures
+// when a client asks.
+type Latency struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Encryption seals stored documents, op logs included, snapshots and
+// backups at rest. Keys maps key IDs to 32-byte keys in base64, which may
+// be secret references, or to data keys wrapped by AWS KMS in KMSRegion,
+// prefixed with "kms:". ActiveKey seals every write; to rotate, add a key,
+// make it active and keep the old one until rotate-keys has resealed the
+// documents, and for as long as snapshots sealed with it are kept.
+// Values stored unsealed are refused unless AllowPlaintext is set, which
+// is meant for migrating a store written before encryption was enabled.
+type Encryption struct {
+	ActiveKey      string            `yaml:"active_key"`
+	Keys           map[string]string `yaml:"keys"`
+	KMSRegion      string            `yaml:"kms_region"`
+	AllowPlaintext bool              `yaml:"allow_plaintext"`
+}
+
+// Tenancy serves each tenant on its own subdomain of Domain, acme.Domain
+// for the tenant acme, and keeps their documents apart. Domain itself
+// serves the default tenant, which has no quotas. Every other tenant may
+// have up to MaxDocuments documents and MaxConnections connections at
+// once, unless Quotas sets its own; zero is unlimited.
+type Tenancy struct {
+	Domain         string                 `yaml:"domain"`
+	MaxDocuments   int                    `yaml:"max_documents"`
+	MaxConnections int                    `yaml:"max_connections"`
+	Quotas         map[string]TenantQuota `yaml:"quotas"`
+}
+
+type TenantQuota struct {
+	MaxDocuments   int `yaml:"max_documents"`
+	MaxConnections int `yaml:"max_connections"`
+}
+
+// Quota returns the quota of tenant
+func (tenancy Tenancy) Quota(tenant string) TenantQuota {
+	if tenant == "" {
+		return TenantQuota{}
+	}
+	if quota, ok := tenancy.Quotas[tenant]; ok {
+		return quota
+	}
+	return TenantQuota{MaxDocuments: tenancy.MaxDocuments, MaxConnections: tenancy.MaxConnections}
+}
+
+// WebhookEndpoint is a webhook receiver; without Events it receives every
+// event, and without a Secret it signs with the shared one
+type WebhookEndpoint struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+}
+
+// AllEndpoints returns Endpoints followed by an endpoint for each of URLs,
+// every one with its secret filled in
+func (webhooks Webhooks) AllEndpoints() []WebhookEndpoint {
+	endpoints := make([]WebhookEndpoint, 0, len(webhooks.Endpoints)+len(webhooks.URLs))
+	for _, endpoint := range webhooks.Endpoints {
+		if endpoint.Secret == "" {
+			endpoint.Secret = webhooks.Secret
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	for _, u := range webhooks.URLs {
+		endpoints = append(endpoints, WebhookEndpoint{URL: u, Secret: webhooks.Secret})
+	}
+	return endpoints
+}
+
+// TLSConfig serves HTTPS on ListenAddr, either with the certificate in
+// CertFile and KeyFile or with certificates obtained from Let's Encrypt for
+// AutocertHosts. Neither being set serves plain HTTP.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	AutocertHosts    []string `yaml:"autocert_hosts"`
+	AutocertEmail    string   `yaml:"autocert_email"`
+	AutocertCacheDir string   `yaml:"autocert_cache_dir"`
+
+	// RedirectAddr serves plain HTTP that redirects to HTTPS and answers
+	// ACME challenges; empty disables it
+	RedirectAddr string `yaml:"redirect_addr"`
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (tls TLSConfig) Enabled() bool {
+	return tls.CertFile != "" || tls.KeyFile != "" || len(tls.AutocertHosts) > 0
+}
+
+// SecretsConfig selects the store that "secret:" references in StorageDSN,
+// RedisURL, the recording DSN, the migration token and the share secret
+// are resolved from. An empty Provider disables references.
+type SecretsConfig struct {
+	Provider string `yaml:"provider"`
+
+	// RefreshInterval is how often resolved secrets are re-fetched to
+	// pick up rotations; zero disables refreshing
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+
+	Dir            string `yaml:"dir"`
+	VaultAddr      string `yaml:"vault_addr"`
+	VaultMount     string `yaml:"vault_mount"`
+	VaultTokenFile string `yaml:"vault_token_file"`
+	AWSRegion      string `yaml:"aws_region"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type Limits struct {
+	ReadBufferSize  int `yaml:"read_buffer_size"`
+	WriteBufferSize int `yaml:"write_buffer_size"`
+	SendBufferSize  int `yaml:"send_buffer_size"`
+
+	// MaxMessageSize caps a single frame; larger frames are discarded and
+	// answered with an error. MaxChunkedSize caps a payload reassembled
+	// from chunk messages and is also the hard per-frame read limit.
+	MaxMessageSize int64 `yaml:"max_message_size"`
+	MaxChunkedSize int64 `yaml:"max_chunked_size"`
+
+	// RateLimits are keyed by message type; "*" applies to every type
+	// without an explicit entry
+	RateLimits   map[string]RateLimit `yaml:"rate_limits"`
+	MuteDuration time.Duration        `yaml:"mute_duration"`
+
+	// MaxMutes is how many times within a minute a client may be muted
+	// before it is disconnected as rate-limited; 0 only ever mutes
+	MaxMutes int `yaml:"max_mutes"`
+
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// IdleTimeout closes connections that have shown no activity for this
+	// long as idle-timeout; 0 keeps them open. They are sent an
+	// idle-warning IdleWarning before, or halfway through for timeouts
+	// shorter than twice that, and IdlePolicy is what counts as
+	// activity: "any" frame, pongs to the server's pings included, or
+	// only "messages", which closes open but unattended editors too.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	IdleWarning time.Duration `yaml:"idle_warning"`
+	IdlePolicy  string        `yaml:"idle_policy"`
+
+	// HistorySize is how many recent ops per document compaction keeps to
+	// replay to reconnecting clients before falling back to a full sync
+	HistorySize int `yaml:"history_size"`
+
+	ChatHistorySize int `yaml:"chat_history_size"`
+	MaxChatLength   int `yaml:"max_chat_length"`
+
+	// NotificationsPerUser is how many recent notifications each user
+	// keeps for catching up
+	NotificationsPerUser int `yaml:"notifications_per_user"`
+
+	// MaxRoomClients caps the clients connected to one room on this node;
+	// 0 is unlimited
+	MaxRoomClients int `yaml:"max_room_clients"`
+
+	// MaxRooms caps the rooms open on this node; 0 is unlimited
+	MaxRooms int `yaml:"max_rooms"`
+
+	// MaxUserConnections and MaxIPConnections cap the connections open at
+	// once on this node by one user and from one client address, across
+	// rooms; 0 is unlimited
+	MaxUserConnections int `yaml:"max_user_connections"`
+	MaxIPConnections   int `yaml:"max_ip_connections"`
+
+	// MaxDocumentSize caps a document's text in characters; 0 is
+	// unlimited. DocumentOps limits the edits each document takes,
+	// whoever makes them; a zero rate is unlimited.
+	MaxDocumentSize int       `yaml:"max_document_size"`
+	DocumentOps     RateLimit `yaml:"document_ops"`
+
+	// ClientErrors limits the error reports each user can send to the
+	// telemetry endpoint
+	ClientErrors RateLimit `yaml:"client_errors"`
+
+	// AuthAttempts limits the sign-ins and registrations each client
+	// address can attempt; a zero rate is unlimited
+	AuthAttempts RateLimit `yaml:"auth_attempts"`
+}
+
+// RateLimit describes a token bucket refilled at Rate tokens per second
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// DefaultRateLimitKey is the RateLimits entry used for unlisted types
+const DefaultRateLimitKey = "*"
+
+func Default() *Config {
+	return &Config{
+		ListenAddr:             ":8080",
+		AllowedOrigins:         []string{"http://localhost:5173"},
+		PresenceRosterInterval: 30 * time.Second,
+		SessionTTL:             24 * time.Hour,
+		DocumentIdleTimeout:    30 * time.Minute,
+		AutosaveInterval:       5 * time.Second,
+		DuplicateThreshold:     0.8,
+		TitleSuggestDelay:      5 * time.Second,
+		PresenceTTL:            30 * time.Second,
+		Kafka: KafkaConfig{
+			TopicPrefix: "collab",
+		},
+		Backpressure: Backpressure{
+			Coalesce:     []string{"content", "save-status", "seen-state"},
+			LowPriority:  []string{"typing", "presence-roster", "link-report", "annotation", "viewer-count"},
+			StallTimeout: 10 * time.Second,
+		},
+		Fanout: Fanout{
+			Workers:   8,
+			BatchSize: 128,
+		},
+		EditBatch: EditBatch{
+			Window: 25 * time.Millisecond,
+			MaxOps: 20,
+		},
+		LinkCheck: LinkCheck{
+			Interval: time.Hour,
+			Timeout:  10 * time.Second,
+		},
+		Analysis: Analysis{
+			IdleDelay: 5 * time.Second,
+			Timeout:   10 * time.Second,
+		},
+		Compaction: Compaction{
+			Interval: 5 * time.Minute,
+			MaxAge:   7 * 24 * time.Hour,
+		},
+		Compression: Compression{
+			Enabled:   true,
+			Level:     1,
+			Threshold: 1024,
+		},
+		Recording: Recording{
+			Scrub: []string{"names", "chat"},
+		},
+		Share: Share{
+			MaxTTL: 30 * 24 * time.Hour,
+		},
+		Cluster: Cluster{
+			LeaseTTL: 15 * time.Second,
+		},
+		OAuth: OAuth{
+			ClientURL: "/",
+		},
+		Webhooks: Webhooks{
+			MaxAttempts: 6,
+			Backoff:     time.Second,
+			Timeout:     10 * time.Second,
+			LogSize:     500,
+		},
+		Mail: Mail{
+			Port:           587,
+			DigestInterval: 24 * time.Hour,
+		},
+		Attachments: Attachments{
+			MaxSize:       10 << 20,
+			AllowedTypes:  []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"},
+			MaxImportSize: 100 << 20,
+		},
+		Backups: Backups{
+			Interval: 24 * time.Hour,
+			Keep:     7,
+		},
+		Trash: Trash{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Audit: Audit{
+			MaxAge:     90 * 24 * time.Hour,
+			MaxEntries: 10000,
+		},
+		Spectators: Spectators{
+			Threshold: 50,
+			Interval:  5 * time.Second,
+		},
+		Bots: Bots{
+			MaxRooms: 100,
+		},
+		Latency: Latency{
+			Interval: 30 * time.Second,
+		},
+		Frontend: Frontend{
+			BasePath: "/",
+		},
+		TLS: TLSConfig{
+			AutocertCacheDir: "autocert-cache",
+			RedirectAddr:     ":80",
+		},
+		Secrets: SecretsConfig{
+			RefreshInterval: 5 * time.Minute,
+			Dir:             "/run/secrets",
+			VaultMount:      "secret",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Limits: Limits{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			SendBufferSize:  256,
+			MaxMessageSize:  1 << 20,
+			MaxChunkedSize:  16 << 20,
+			RateLimits: map[string]RateLimit{
+				DefaultRateLimitKey: {Rate: 30, Burst: 60},
+				"content":           {Rate: 15, Burst: 30},
+			},
+			MuteDuration: 5 * time.Second,
+			MaxMutes:     5,
+			WriteTimeout: 10 * time.Second,
+			IdleWarning:  30 * time.Second,
+			IdlePolicy:   "any",
+			HistorySize:  500,
+
+			ChatHistorySize: 100,
+			MaxChatLength:   2000,
+
+			NotificationsPerUser: 200,
+
+			ClientErrors: RateLimit{Rate: 0.2, Burst: 10},
+			AuthAttempts: RateLimit{Rate: 0.1, Burst: 10},
+		},
+	}
+}
+
+// Load builds the configuration from a YAML file (-config flag or
+// CONFIG_FILE env var), the environment and the given command line args.
+func Load(args []string) (*Config, error) {
+	cfg, _, err := LoadCommand("server", args, nil)
+	return cfg, err
+}
+
+// LoadCommand is Load for the command called name, whose own flags, if
+// any, commandFlags registers alongside the configuration's. It also
+// returns the arguments left after the flags.
+func LoadCommand(name string, args []string, commandFlags func(fs *flag.FlagSet)) (*Config, []string, error) {
+	// First pass only discovers the config file path
+	scratch := Default()
+	fs, configPath := newFlagSet(name, scratch, commandFlags)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	if *configPath != "" {
+		if err := loadFile(cfg, *configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// Second pass: flags default to the resolved values, so only flags
+	// passed explicitly override the file and environment
+	fs, _ = newFlagSet(name, cfg, commandFlags)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.ListenAddr == "" {
+		return fmt.Errorf("listen address must not be empty")
+	}
+	if cfg.Limits.ReadBufferSize <= 0 || cfg.Limits.WriteBufferSize <= 0 {
+		return fmt.Errorf("read and write buffer sizes must be positive")
+	}
+	if cfg.Limits.SendBufferSize <= 0 {
+		return fmt.Errorf("send buffer size must be positive")
+	}
+	if cfg.Limits.MaxRoomClients < 0 {
+		return fmt.Errorf("max room clients must not be negative")
+	}
+	if cfg.Limits.MaxUserConnections < 0 || cfg.Limits.MaxIPConnections < 0 {
+		return fmt.Errorf("max user and IP connections must not be negative")
+	}
+	if cfg.Limits.MaxRooms < 0 || cfg.Limits.MaxDocumentSize < 0 {
+		return fmt.Errorf("max rooms and max document size must not be negative")
+	}
+	if cfg.Limits.DocumentOps.Rate < 0 || cfg.Limits.DocumentOps.Rate > 0 && cfg.Limits.DocumentOps.Burst <= 0 {
+		return fmt.Errorf("document ops rate must not be negative, and needs a positive burst when set")
+	}
+	if cfg.PresenceTTL < 3*time.Second {
+		return fmt.Errorf("presence TTL must be at least 3s")
+	}
+	if cfg.SessionTTL <= 0 {
+		return fmt.Errorf("session TTL must be positive")
+	}
+	if cfg.DocumentIdleTimeout < 0 {
+		return fmt.Errorf("document idle timeout must not be negative")
+	}
+	if cfg.AutosaveInterval < 0 {
+		return fmt.Errorf("autosave interval must not be negative")
+	}
+	if cfg.HibernateAfter < 0 {
+		return fmt.Errorf("hibernate after must not be negative")
+	}
+	if cfg.HibernateAfter > 0 && (cfg.StorageDSN == "" || cfg.DocumentIdleTimeout == 0) {
+		return fmt.Errorf("hibernation requires a storage DSN and a document idle timeout")
+	}
+	if cfg.DuplicateThreshold <= 0 || cfg.DuplicateThreshold > 1 {
+		return fmt.Errorf("duplicate threshold must be above 0 and at most 1")
+	}
+	if cfg.TitleSuggestDelay < 0 {
+		return fmt.Errorf("title suggest delay must not be negative")
+	}
+	if cfg.Limits.ChatHistorySize < 0 || cfg.Limits.MaxChatLength <= 0 {
+		return fmt.Errorf("chat history size must not be negative and max chat length must be positive")
+	}
+	if cfg.Limits.NotificationsPerUser <= 0 {
+		return fmt.Errorf("notifications per user must be positive")
+	}
+	if cfg.Limits.HistorySize < 0 {
+		return fmt.Errorf("history size must not be negative")
+	}
+	if cfg.Limits.WriteTimeout <= 0 {
+		return fmt.Errorf("write timeout must be positive")
+	}
+	if cfg.Limits.MaxMutes < 0 || cfg.Limits.IdleTimeout < 0 || cfg.Limits.IdleWarning < 0 {
+		return fmt.Errorf("max mutes, idle timeout and idle warning must not be negative")
+	}
+	if cfg.Limits.IdlePolicy != "any" && cfg.Limits.IdlePolicy != "messages" {
+		return fmt.Errorf("idle policy must be any or messages")
+	}
+	if cfg.Limits.MaxMessageSize <= 0 || cfg.Limits.MaxChunkedSize < cfg.Limits.MaxMessageSize {
+		return fmt.Errorf("max message size must be positive and not exceed max chunked size")
+	}
+	switch cfg.Secrets.Provider {
+	case "", "file":
+	case "vault":
+		if cfg.Secrets.VaultAddr == "" {
+			return fmt.Errorf("vault secrets provider requires a vault address")
+		}
+	case "aws":
+		if cfg.Secrets.AWSRegion == "" {
+			return fmt.Errorf("aws secrets provider requires an AWS region")
+		}
+	default:
+		return fmt.Errorf("secrets provider must be file, vault or aws")
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS needs both a certificate and a key file")
+	}
+	if cfg.TLS.CertFile != "" && len(cfg.TLS.AutocertHosts) > 0 {
+		return fmt.Errorf("TLS certificate files and autocert hosts are mutually exclusive")
+	}
+	if len(cfg.TLS.AutocertHosts) > 0 && cfg.TLS.AutocertCacheDir == "" {
+		return fmt.Errorf("autocert needs a cache directory")
+	}
+	if cfg.TLS.Enabled() && cfg.TLS.RedirectAddr == cfg.ListenAddr {
+		return fmt.Errorf("TLS redirect address must differ from the listen address")
+	}
+	if cfg.GRPCAddr != "" && (cfg.GRPCAddr == cfg.ListenAddr || cfg.GRPCAddr == cfg.TLS.RedirectAddr) {
+		return fmt.Errorf("gRPC address must differ from the listen and redirect addresses")
+	}
+	if cfg.Compression.Level < -2 || cfg.Compression.Level > 9 {
+		return fmt.Errorf("compression level must be between -2 and 9")
+	}
+	if cfg.Compression.Threshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative")
+	}
+	if cfg.Canary.Percent < 0 || cfg.Canary.Percent > 100 {
+		return fmt.Errorf("canary percent must be between 0 and 100")
+	}
+	if cfg.Canary.Percent > 0 && cfg.Canary.Engine == "" {
+		return fmt.Errorf("canary percent needs a canary engine")
+	}
+	if cfg.Recording.Percent < 0 || cfg.Recording.Percent > 100 {
+		return fmt.Errorf("recording percent must be between 0 and 100")
+	}
+	if cfg.Recording.Percent > 0 && cfg.Recording.DSN == "" {
+		return fmt.Errorf("recording percent needs a recording DSN")
+	}
+	if dsn := cfg.EventLog.DSN; dsn != "" && !strings.HasPrefix(dsn, "file://") && !strings.HasPrefix(dsn, "kafka://") {
+		return fmt.Errorf("event log DSN must start with file:// or kafka://")
+	}
+	if !strings.HasPrefix(cfg.Frontend.BasePath, "/") {
+		return fmt.Errorf("frontend base path must start with /")
+	}
+	if cfg.Backpressure.StallTimeout < 0 {
+		return fmt.Errorf("backpressure stall timeout must not be negative")
+	}
+	if cfg.Fanout.Workers < 0 || cfg.Fanout.BatchSize <= 0 {
+		return fmt.Errorf("fanout workers must not be negative and its batch size must be positive")
+	}
+	if cfg.EditBatch.Window < 0 || cfg.EditBatch.MaxOps <= 0 {
+		return fmt.Errorf("edit batch window must not be negative and its max ops must be positive")
+	}
+	if cfg.LinkCheck.Interval < 0 || cfg.LinkCheck.Timeout <= 0 {
+		return fmt.Errorf("link check interval must not be negative and its timeout must be positive")
+	}
+	if cfg.Analysis.IdleDelay < 0 || cfg.Analysis.Timeout <= 0 {
+		return fmt.Errorf("analysis idle delay must not be negative and its timeout must be positive")
+	}
+	if cfg.Compaction.Interval < 0 || cfg.Compaction.MaxAge < 0 {
+		return fmt.Errorf("compaction interval and max age must not be negative")
+	}
+	if cfg.Secrets.RefreshInterval < 0 {
+		return fmt.Errorf("secrets refresh interval must not be negative")
+	}
+	if cfg.Share.MaxTTL <= 0 {
+		return fmt.Errorf("share link max TTL must be positive")
+	}
+	for _, client := range []OAuthClient{cfg.OAuth.Google, cfg.OAuth.GitHub} {
+		if client.ClientID == "" {
+			continue
+		}
+		if client.ClientSecret == "" {
+			return fmt.Errorf("OAuth client IDs need a client secret")
+		}
+		if cfg.OAuth.RedirectURL == "" {
+			return fmt.Errorf("OAuth login needs a redirect URL")
+		}
+	}
+	for _, endpoint := range cfg.Webhooks.AllEndpoints() {
+		if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook URL %q must be an absolute http or https URL", endpoint.URL)
+		}
+		if endpoint.Secret == "" {
+			return fmt.Errorf("webhook %q needs a secret to sign deliveries with", endpoint.URL)
+		}
+	}
+	if cfg.Webhooks.MaxAttempts < 1 || cfg.Webhooks.Backoff <= 0 || cfg.Webhooks.Timeout <= 0 {
+		return fmt.Errorf("webhook max attempts, backoff and timeout must be positive")
+	}
+	if cfg.Webhooks.LogSize < 0 {
+		return fmt.Errorf("webhook log size must not be negative")
+	}
+	if cfg.Mail.Host != "" {
+		if cfg.Mail.Port <= 0 || cfg.Mail.Port > 65535 {
+			return fmt.Errorf("mail port must be between 1 and 65535")
+		}
+		if cfg.Mail.From == "" {
+			return fmt.Errorf("mail needs a from address")
+		}
+		if u, err := url.Parse(cfg.Mail.ClientURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("mail client URL must be an absolute http or https URL")
+		}
+	}
+	if cfg.Cluster.AdvertiseURL != "" {
+		if u, err := url.Parse(cfg.Cluster.AdvertiseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("cluster advertise URL must be an absolute http or https URL")
+		}
+		if cfg.RedisURL == "" {
+			return fmt.Errorf("cluster advertise URL needs a Redis URL to keep document leases in")
+		}
+	}
+	if cfg.Cluster.LeaseTTL <= 0 {
+		return fmt.Errorf("cluster lease TTL must be positive")
+	}
+	if cfg.Mail.DigestInterval < 0 {
+		return fmt.Errorf("mail digest interval must not be negative")
+	}
+	if cfg.Attachments.MaxSize <= 0 {
+		return fmt.Errorf("attachment max size must be positive")
+	}
+	if len(cfg.Attachments.AllowedTypes) == 0 {
+		return fmt.Errorf("attachments need at least one allowed content type")
+	}
+	if cfg.Attachments.MaxImportSize <= 0 {
+		return fmt.Errorf("import max size must be positive")
+	}
+	if cfg.Blobs.Encryption != "" && cfg.Blobs.Encryption != "AES256" && cfg.Blobs.Encryption != "aws:kms" {
+		return fmt.Errorf("blob encryption must be AES256 or aws:kms")
+	}
+	if cfg.Blobs.KMSKeyID != "" && cfg.Blobs.Encryption != "aws:kms" {
+		return fmt.Errorf("a blob KMS key needs aws:kms encryption")
+	}
+	if cfg.Blobs.Encryption != "" && !strings.HasPrefix(cfg.Blobs.DSN, "s3:") {
+		return fmt.Errorf("blob encryption needs an s3:// blob DSN")
+	}
+	if cfg.Backups.Interval < 0 || cfg.Backups.Keep < 0 {
+		return fmt.Errorf("backup interval and keep must not be negative")
+	}
+	if cfg.Trash.Retention < 0 {
+		return fmt.Errorf("trash retention must not be negative")
+	}
+	if cfg.Trash.Retention > 0 && cfg.Trash.PurgeInterval <= 0 {
+		return fmt.Errorf("trash purge interval must be positive")
+	}
+	if cfg.Audit.MaxAge < 0 || cfg.Audit.MaxEntries < 0 {
+		return fmt.Errorf("audit max age and max entries must not be negative")
+	}
+	if cfg.Spectators.Threshold < 0 {
+		return fmt.Errorf("spectator threshold must not be negative")
+	}
+	if cfg.Spectators.Threshold > 0 && cfg.Spectators.Interval <= 0 {
+		return fmt.Errorf("spectator interval must be positive")
+	}
+	if cfg.Bots.MaxRooms <= 0 {
+		return fmt.Errorf("bot max rooms must be positive")
+	}
+	if cfg.Latency.Interval < 0 {
+		return fmt.Errorf("latency interval must not be negative")
+	}
+	if len(cfg.Encryption.Keys) > 0 {
+		if _, ok := cfg.Encryption.Keys[cfg.Encryption.ActiveKey]; !ok {
+			return fmt.Errorf("active encryption key %q is not among the encryption keys", cfg.Encryption.ActiveKey)
+		}
+		for id, key := range cfg.Encryption.Keys {
+			if strings.HasPrefix(key, "kms:") && cfg.Encryption.KMSRegion == "" {
+				return fmt.Errorf("encryption key %q is wrapped by KMS but no KMS region is configured", id)
+			}
+		}
+	} else if cfg.Encryption.ActiveKey != "" {
+		return fmt.Errorf("an active encryption key needs encryption keys")
+	} else if cfg.Encryption.AllowPlaintext {
+		return fmt.Errorf("allowing plaintext values needs encryption keys")
+	}
+	if cfg.Tenancy.MaxDocuments < 0 || cfg.Tenancy.MaxConnections < 0 {
+		return fmt.Errorf("tenant quotas must not be negative")
+	}
+	for tenant, quota := range cfg.Tenancy.Quotas {
+		if !tenants.Valid(tenant) {
+			return fmt.Errorf("tenant %q must be a lowercase DNS label", tenant)
+		}
+		if quota.MaxDocuments < 0 || quota.MaxConnections < 0 {
+			return fmt.Errorf("quotas of tenant %q must not be negative", tenant)
+		}
+	}
+	for _, token := range cfg.APITokens {
+		if token.Name == "" || token.Role == "" {
+			return fmt.Errorf("API tokens need a name and a role")
+		}
+		if token.Tenant != "" && !tenants.Valid(token.Tenant) {
+			return fmt.Errorf("API token %q has invalid tenant %q", token.Name, token.Tenant)
+		}
+		if sum, err := hex.DecodeString(token.SHA256); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("API token %q must have a hex encoded SHA-256", token.Name)
+		}
+	}
+	for messageType, limit := range cfg.Limits.RateLimits {
+		if limit.Rate <= 0 || limit.Burst <= 0 {
+			return fmt.Errorf("rate limit for %q must have positive rate and burst", messageType)
+		}
+	}
+	if cfg.Limits.ClientErrors.Rate <= 0 || cfg.Limits.ClientErrors.Burst <= 0 {
+		return fmt.Errorf("client error rate limit must have positive rate and burst")
+	}
+	if cfg.Limits.AuthAttempts.Rate < 0 || cfg.Limits.AuthAttempts.Rate > 0 && cfg.Limits.AuthAttempts.Burst <= 0 {
+		return fmt.Errorf("auth attempts rate must not be negative, and needs a positive burst when set")
+	}
+	return nil
+}
+
+func newFlagSet(name string, cfg *Config, commandFlags func(fs *flag.FlagSet)) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if commandFlags != nil {
+		commandFlags(fs)
+	}
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+
+	fs.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "address to listen on")
+	fs.StringVar(&cfg.GRPCAddr, "grpc-listen", cfg.GRPCAddr, "address serving the internal gRPC API (empty disables)")
+	fs.Var((*stringList)(&cfg.AllowedOrigins), "allowed-origins", "comma separated list of allowed origins (*.example.com matches subdomains)")
+	fs.Var((*stringList)(&cfg.TrustedProxies), "trusted-proxies", "comma separated addresses or CIDR blocks of reverse proxies whose X-Forwarded-For is trusted")
+	fs.BoolVar(&cfg.DevMode, "dev-mode", cfg.DevMode, "allow every origin, for local development only")
+	fs.StringVar(&cfg.StorageDSN, "storage-dsn", cfg.StorageDSN, "storage connection string")
+	fs.StringVar(&cfg.RedisURL, "redis-url", cfg.RedisURL, "Redis connection URL")
+	fs.DurationVar(&cfg.PresenceRosterInterval, "presence-roster-interval", cfg.PresenceRosterInterval, "interval between full presence roster broadcasts (0 disables)")
+	fs.DurationVar(&cfg.PresenceTTL, "presence-ttl", cfg.PresenceTTL, "expiry of presence entries that are not refreshed")
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", cfg.SessionTTL, "how long a disconnected session can be resumed")
+	fs.DurationVar(&cfg.DocumentIdleTimeout, "document-idle-timeout", cfg.DocumentIdleTimeout, "how long a document without clients stays loaded before it is saved and unloaded (0 keeps it loaded)")
+	fs.DurationVar(&cfg.AutosaveInterval, "autosave-interval", cfg.AutosaveInterval, "how often changed documents are saved while they stay loaded (0 only saves them when they unload)")
+	fs.DurationVar(&cfg.HibernateAfter, "hibernate-after", cfg.HibernateAfter, "how long an unloaded document waits before its chat, comments and index entry are flushed and released (0 disables)")
+	fs.DurationVar(&cfg.TitleSuggestDelay, "title-suggest-delay", cfg.TitleSuggestDelay, "how long an untitled document goes unedited before it is titled after its first line (0 disables)")
+	fs.Float64Var(&cfg.DuplicateThreshold, "duplicate-threshold", cfg.DuplicateThreshold, "similarity from 0 to 1 above which documents are suggested as duplicates")
+	fs.Var((*stringList)(&cfg.Kafka.Brokers), "kafka-brokers", "comma separated Kafka brokers for the change event stream")
+	fs.StringVar(&cfg.Kafka.TopicPrefix, "kafka-topic-prefix", cfg.Kafka.TopicPrefix, "prefix of the Kafka topics events are published to")
+	fs.StringVar(&cfg.Secrets.Provider, "secrets-provider", cfg.Secrets.Provider, "store secret: references are resolved from (file, vault or aws)")
+	fs.DurationVar(&cfg.Secrets.RefreshInterval, "secrets-refresh-interval", cfg.Secrets.RefreshInterval, "interval between secret refreshes (0 disables)")
+	fs.StringVar(&cfg.Secrets.Dir, "secrets-dir", cfg.Secrets.Dir, "directory read by the file secrets provider")
+	fs.StringVar(&cfg.Secrets.VaultAddr, "vault-addr", cfg.Secrets.VaultAddr, "Vault server address")
+	fs.StringVar(&cfg.Secrets.VaultMount, "vault-mount", cfg.Secrets.VaultMount, "mount path of the Vault KV v2 engine")
+	fs.StringVar(&cfg.Secrets.VaultTokenFile, "vault-token-file", cfg.Secrets.VaultTokenFile, "file holding the Vault token (defaults to VAULT_TOKEN)")
+	fs.StringVar(&cfg.Secrets.AWSRegion, "aws-region", cfg.Secrets.AWSRegion, "AWS region of Secrets Manager")
+	fs.Var((*stringList)(&cfg.Backpressure.Coalesce), "backpressure-coalesce", "comma separated message types held back for slow clients, keeping the latest per sender")
+	fs.Var((*stringList)(&cfg.Backpressure.LowPriority), "backpressure-low-priority", "comma separated message types dropped for slow clients")
+	fs.DurationVar(&cfg.Backpressure.StallTimeout, "backpressure-stall-timeout", cfg.Backpressure.StallTimeout, "how long a client's send buffer may stay full before it is disconnected")
+	fs.IntVar(&cfg.Fanout.Workers, "fanout-workers", cfg.Fanout.Workers, "goroutines sharing delivery to large rooms (0 delivers serially)")
+	fs.IntVar(&cfg.Fanout.BatchSize, "fanout-batch-size", cfg.Fanout.BatchSize, "clients per fanout batch; smaller rooms are delivered serially")
+	fs.DurationVar(&cfg.EditBatch.Window, "edit-batch-window", cfg.EditBatch.Window, "how long edits are held back to be relayed together (0 relays each at once)")
+	fs.IntVar(&cfg.EditBatch.MaxOps, "edit-batch-max-ops", cfg.EditBatch.MaxOps, "edits relayed together at most")
+	fs.DurationVar(&cfg.LinkCheck.Interval, "link-check-interval", cfg.LinkCheck.Interval, "interval between broken link checks (0 disables)")
+	fs.DurationVar(&cfg.LinkCheck.Timeout, "link-check-timeout", cfg.LinkCheck.Timeout, "timeout for following a single link")
+	fs.StringVar(&cfg.Analysis.URL, "analysis-url", cfg.Analysis.URL, "LanguageTool server documents are analysed with (empty disables)")
+	fs.DurationVar(&cfg.Analysis.IdleDelay, "analysis-idle-delay", cfg.Analysis.IdleDelay, "how long a document must go unedited before it is analysed")
+	fs.DurationVar(&cfg.Analysis.Timeout, "analysis-timeout", cfg.Analysis.Timeout, "timeout for analysing a single document")
+	fs.DurationVar(&cfg.Compaction.Interval, "compaction-interval", cfg.Compaction.Interval, "interval between op log compactions (0 disables)")
+	fs.DurationVar(&cfg.Compaction.MaxAge, "compaction-max-age", cfg.Compaction.MaxAge, "age beyond which compaction drops ops (0 keeps them regardless of age)")
+	fs.BoolVar(&cfg.Compression.Enabled, "compression", cfg.Compression.Enabled, "negotiate permessage-deflate with clients that support it")
+	fs.IntVar(&cfg.Compression.Level, "compression-level", cfg.Compression.Level, "deflate level from -2 (Huffman only) to 9 (best compression)")
+	fs.IntVar(&cfg.Compression.Threshold, "compression-threshold", cfg.Compression.Threshold, "frames smaller than this many bytes are sent uncompressed")
+	fs.StringVar(&cfg.Canary.Engine, "canary-engine", cfg.Canary.Engine, "document engine run in shadow on canary rooms (relay)")
+	fs.IntVar(&cfg.Canary.Percent, "canary-percent", cfg.Canary.Percent, "percentage of rooms the canary engine runs on (0 disables)")
+	fs.IntVar(&cfg.Recording.Percent, "recording-percent", cfg.Recording.Percent, "percentage of rooms whose messages are recorded (0 disables)")
+	fs.StringVar(&cfg.Recording.DSN, "recording-dsn", cfg.Recording.DSN, "where recorded messages are kept (file:// or redis://)")
+	fs.StringVar(&cfg.EventLog.DSN, "event-log-dsn", cfg.EventLog.DSN, "where every op and presence event is logged (file:// or kafka://, empty disables)")
+	fs.StringVar(&cfg.Frontend.Dir, "frontend-dir", cfg.Frontend.Dir, "directory the web app is served from instead of the build embedded in the binary")
+	fs.StringVar(&cfg.Frontend.BasePath, "frontend-base-path", cfg.Frontend.BasePath, "path the web app is served under")
+	fs.Var((*stringList)(&cfg.Recording.Scrub), "recording-scrub", "comma separated scrubbers applied to recorded messages (names, chat, text)")
+	fs.StringVar(&cfg.Cluster.NodeID, "node-id", cfg.Cluster.NodeID, "name of this node in the cluster (defaults to the host name)")
+	fs.StringVar(&cfg.Cluster.AdvertiseURL, "advertise-url", cfg.Cluster.AdvertiseURL, "URL other nodes reach this one at; enables document ownership across nodes")
+	fs.DurationVar(&cfg.Cluster.LeaseTTL, "lease-ttl", cfg.Cluster.LeaseTTL, "how long a node owns a document without renewing its lease")
+	fs.StringVar(&cfg.Migration.Token, "migration-token", cfg.Migration.Token, "API token presented to other nodes when handing rooms over to them")
+	fs.StringVar(&cfg.Share.Secret, "share-secret", cfg.Share.Secret, "key invitation links are signed with (random when empty)")
+	fs.DurationVar(&cfg.Share.MaxTTL, "share-max-ttl", cfg.Share.MaxTTL, "longest an invitation link can stay valid")
+	fs.Var((*stringList)(&cfg.Webhooks.URLs), "webhook-urls", "comma separated URLs every change event is POSTed to")
+	fs.StringVar(&cfg.Webhooks.Secret, "webhook-secret", cfg.Webhooks.Secret, "key webhook deliveries are signed with")
+	fs.IntVar(&cfg.Webhooks.MaxAttempts, "webhook-max-attempts", cfg.Webhooks.MaxAttempts, "attempts at delivering a webhook before giving up")
+	fs.DurationVar(&cfg.Webhooks.Backoff, "webhook-backoff", cfg.Webhooks.Backoff, "wait before the first webhook retry, doubled for each one after")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhook-timeout", cfg.Webhooks.Timeout, "timeout of a single webhook delivery attempt")
+	fs.IntVar(&cfg.Webhooks.LogSize, "webhook-log-size", cfg.Webhooks.LogSize, "webhook deliveries kept for the admin API")
+	fs.StringVar(&cfg.Mail.Host, "mail-host", cfg.Mail.Host, "SMTP server emails are sent through (empty sends none)")
+	fs.IntVar(&cfg.Mail.Port, "mail-port", cfg.Mail.Port, "port of the SMTP server")
+	fs.StringVar(&cfg.Mail.Username, "mail-username", cfg.Mail.Username, "user authenticating with the SMTP server")
+	fs.StringVar(&cfg.Mail.Password, "mail-password", cfg.Mail.Password, "password authenticating with the SMTP server")
+	fs.StringVar(&cfg.Mail.From, "mail-from", cfg.Mail.From, "address emails are sent from")
+	fs.StringVar(&cfg.Mail.ClientURL, "mail-client-url", cfg.Mail.ClientURL, "absolute URL of the client links in emails point to")
+	fs.DurationVar(&cfg.Mail.DigestInterval, "mail-digest-interval", cfg.Mail.DigestInterval, "interval between notification digest emails (0 disables)")
+	fs.StringVar(&cfg.Attachments.DSN, "attachments-dsn", cfg.Attachments.DSN, "where attachments are kept (file:// or s3://, in memory when empty)")
+	fs.Int64Var(&cfg.Attachments.MaxSize, "attachment-max-size", cfg.Attachments.MaxSize, "largest accepted attachment in bytes")
+	fs.Var((*stringList)(&cfg.Attachments.AllowedTypes), "attachment-types", "comma separated content types attachments may have")
+	fs.Int64Var(&cfg.Attachments.MaxImportSize, "import-max-size", cfg.Attachments.MaxImportSize, "largest accepted workspace archive import in bytes")
+	fs.StringVar(&cfg.Blobs.DSN, "blob-dsn", cfg.Blobs.DSN, "object store for snapshots, exports and attachments (file:// or s3://bucket)")
+	fs.StringVar(&cfg.Blobs.Prefix, "blob-prefix", cfg.Blobs.Prefix, "prefix of every key in the blob store")
+	fs.StringVar(&cfg.Blobs.Encryption, "blob-encryption", cfg.Blobs.Encryption, "server-side encryption of S3 objects (AES256 or aws:kms)")
+	fs.StringVar(&cfg.Blobs.KMSKeyID, "blob-kms-key-id", cfg.Blobs.KMSKeyID, "KMS key of aws:kms encryption (the bucket's default when empty)")
+	fs.StringVar(&cfg.Backups.DSN, "backup-dsn", cfg.Backups.DSN, "store for full backups (file:// or s3://bucket, the blob store when empty)")
+	fs.DurationVar(&cfg.Backups.Interval, "backup-interval", cfg.Backups.Interval, "interval between scheduled backups (0 only takes them on demand)")
+	fs.IntVar(&cfg.Backups.Keep, "backup-keep", cfg.Backups.Keep, "backups kept, oldest deleted first (0 keeps every one)")
+	fs.DurationVar(&cfg.Trash.Retention, "trash-retention", cfg.Trash.Retention, "time trashed documents are kept before they are purged (0 keeps them until restored)")
+	fs.DurationVar(&cfg.Trash.PurgeInterval, "trash-purge-interval", cfg.Trash.PurgeInterval, "interval between purges of expired trashed documents")
+	fs.DurationVar(&cfg.Audit.MaxAge, "audit-max-age", cfg.Audit.MaxAge, "age beyond which audit entries are pruned (0 keeps them regardless of age)")
+	fs.IntVar(&cfg.Audit.MaxEntries, "audit-max-entries", cfg.Audit.MaxEntries, "audit entries kept per document (0 keeps every one)")
+	fs.IntVar(&cfg.Spectators.Threshold, "spectator-threshold", cfg.Spectators.Threshold, "view-only connections to a room announced before further ones join as spectators (0 announces all)")
+	fs.DurationVar(&cfg.Spectators.Interval, "spectator-interval", cfg.Spectators.Interval, "interval between viewer-count broadcasts to rooms with spectators")
+	fs.IntVar(&cfg.Bots.MaxRooms, "bot-max-rooms", cfg.Bots.MaxRooms, "rooms a bot connection may subscribe to at once")
+	fs.DurationVar(&cfg.Latency.Interval, "latency-interval", cfg.Latency.Interval, "interval between latency pings to every client (0 only measures on request)")
+	fs.Var((*keyMap)(&cfg.Encryption.Keys), "encryption-keys", "comma separated id:key encryption keys, base64 or kms: wrapped (empty stores documents in plaintext)")
+	fs.StringVar(&cfg.Encryption.ActiveKey, "encryption-active-key", cfg.Encryption.ActiveKey, "ID of the encryption key new writes are sealed with")
+	fs.StringVar(&cfg.Encryption.KMSRegion, "encryption-kms-region", cfg.Encryption.KMSRegion, "AWS region of the KMS key wrapping encryption keys")
+	fs.BoolVar(&cfg.Encryption.AllowPlaintext, "encryption-allow-plaintext", cfg.Encryption.AllowPlaintext, "read values stored unsealed, while migrating a store to encryption")
+	fs.StringVar(&cfg.Tenancy.Domain, "tenant-domain", cfg.Tenancy.Domain, "domain whose subdomains are tenants (empty serves only the default tenant)")
+	fs.IntVar(&cfg.Tenancy.MaxDocuments, "tenant-max-documents", cfg.Tenancy.MaxDocuments, "documents each tenant may have (0 is unlimited)")
+	fs.IntVar(&cfg.Tenancy.MaxConnections, "tenant-max-connections", cfg.Tenancy.MaxConnections, "connections each tenant may have at once (0 is unlimited)")
+	fs.StringVar(&cfg.OAuth.RedirectURL, "oauth-redirect-url", cfg.OAuth.RedirectURL, "public base URL of this server login providers send browsers back to")
+	fs.StringVar(&cfg.OAuth.ClientURL, "oauth-client-url", cfg.OAuth.ClientURL, "where browsers go once signed in with a login provider")
+	fs.StringVar(&cfg.OAuth.Google.ClientID, "google-client-id", cfg.OAuth.Google.ClientID, "Google OAuth client ID, offers signing in with Google")
+	fs.StringVar(&cfg.OAuth.Google.ClientSecret, "google-client-secret", cfg.OAuth.Google.ClientSecret, "Google OAuth client secret")
+	fs.StringVar(&cfg.OAuth.GitHub.ClientID, "github-client-id", cfg.OAuth.GitHub.ClientID, "GitHub OAuth client ID, offers signing in with GitHub")
+	fs.StringVar(&cfg.OAuth.GitHub.ClientSecret, "github-client-secret", cfg.OAuth.GitHub.ClientSecret, "GitHub OAuth client secret")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert-file", cfg.TLS.CertFile, "TLS certificate file, enables HTTPS")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key-file", cfg.TLS.KeyFile, "TLS private key file")
+	fs.Var((*stringList)(&cfg.TLS.AutocertHosts), "autocert-hosts", "comma separated hostnames to obtain Let's Encrypt certificates for, enables HTTPS")
+	fs.StringVar(&cfg.TLS.AutocertEmail, "autocert-email", cfg.TLS.AutocertEmail, "contact email for the Let's Encrypt account")
+	fs.StringVar(&cfg.TLS.AutocertCacheDir, "autocert-cache-dir", cfg.TLS.AutocertCacheDir, "directory Let's Encrypt certificates are cached in")
+	fs.StringVar(&cfg.TLS.RedirectAddr, "tls-redirect-addr", cfg.TLS.RedirectAddr, "address serving HTTP to HTTPS redirects when TLS is on (empty disables)")
+	fs.Var((*tokenList)(&cfg.APITokens), "api-tokens", "comma separated name:role:sha256 API tokens, with :tenant to restrict one to a tenant")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "log level (debug, info, warn, error)")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "log output format (text or json)")
+	fs.IntVar(&cfg.Limits.ReadBufferSize, "read-buffer-size", cfg.Limits.ReadBufferSize, "WebSocket read buffer size in bytes")
+	fs.IntVar(&cfg.Limits.WriteBufferSize, "write-buffer-size", cfg.Limits.WriteBufferSize, "WebSocket write buffer size in bytes")
+	fs.IntVar(&cfg.Limits.SendBufferSize, "send-buffer-size", cfg.Limits.SendBufferSize, "queued outbound messages per client")
+	fs.Int64Var(&cfg.Limits.MaxMessageSize, "max-message-size", cfg.Limits.MaxMessageSize, "largest accepted WebSocket frame in bytes")
+	fs.Int64Var(&cfg.Limits.MaxChunkedSize, "max-chunked-size", cfg.Limits.MaxChunkedSize, "largest payload accepted through chunked messages in bytes")
+	fs.DurationVar(&cfg.Limits.MuteDuration, "mute-duration", cfg.Limits.MuteDuration, "how long a client is muted after exceeding a rate limit")
+	fs.IntVar(&cfg.Limits.HistorySize, "history-size", cfg.Limits.HistorySize, "recent ops compaction keeps per document for reconnect replay")
+	fs.IntVar(&cfg.Limits.ChatHistorySize, "chat-history-size", cfg.Limits.ChatHistorySize, "chat messages kept per document")
+	fs.IntVar(&cfg.Limits.MaxRoomClients, "max-room-clients", cfg.Limits.MaxRoomClients, "clients allowed in one room on this node (0 is unlimited)")
+	fs.IntVar(&cfg.Limits.MaxRooms, "max-rooms", cfg.Limits.MaxRooms, "rooms open at once on this node (0 is unlimited)")
+	fs.IntVar(&cfg.Limits.MaxUserConnections, "max-user-connections", cfg.Limits.MaxUserConnections, "connections one user may have open at once on this node (0 is unlimited)")
+	fs.IntVar(&cfg.Limits.MaxIPConnections, "max-ip-connections", cfg.Limits.MaxIPConnections, "connections one client address may have open at once on this node (0 is unlimited)")
+	fs.IntVar(&cfg.Limits.MaxDocumentSize, "max-document-size", cfg.Limits.MaxDocumentSize, "longest document in characters (0 is unlimited)")
+	fs.Float64Var(&cfg.Limits.DocumentOps.Rate, "document-ops-rate", cfg.Limits.DocumentOps.Rate, "edits per second each document takes (0 is unlimited)")
+	fs.IntVar(&cfg.Limits.DocumentOps.Burst, "document-ops-burst", cfg.Limits.DocumentOps.Burst, "edits a document takes at once before document-ops-rate applies")
+	fs.Float64Var(&cfg.Limits.AuthAttempts.Rate, "auth-attempts-rate", cfg.Limits.AuthAttempts.Rate, "sign-ins and registrations per second each client address may attempt (0 is unlimited)")
+	fs.IntVar(&cfg.Limits.AuthAttempts.Burst, "auth-attempts-burst", cfg.Limits.AuthAttempts.Burst, "sign-ins and registrations a client address may attempt at once before auth-attempts-rate applies")
+	fs.IntVar(&cfg.Limits.MaxChatLength, "max-chat-length", cfg.Limits.MaxChatLength, "longest accepted chat message in characters")
+	fs.IntVar(&cfg.Limits.NotificationsPerUser, "notifications-per-user", cfg.Limits.NotificationsPerUser, "recent notifications kept per user")
+	fs.DurationVar(&cfg.Limits.WriteTimeout, "write-timeout", cfg.Limits.WriteTimeout, "deadline for writing a frame to a client")
+	fs.IntVar(&cfg.Limits.MaxMutes, "max-mutes", cfg.Limits.MaxMutes, "mutes within a minute after which a client is disconnected (0 only mutes)")
+	fs.DurationVar(&cfg.Limits.IdleTimeout, "idle-timeout", cfg.Limits.IdleTimeout, "how long a connection may show no activity before it is closed (0 keeps it open)")
+	fs.DurationVar(&cfg.Limits.IdleWarning, "idle-warning", cfg.Limits.IdleWarning, "how long before an idle connection is closed it is warned")
+	fs.StringVar(&cfg.Limits.IdlePolicy, "idle-policy", cfg.Limits.IdlePolicy, "what keeps a connection from going idle: any frame including pongs (any) or only messages (messages)")
+
+	return fs, configPath
+}
+
+func loadFile(cfg *Config, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := yaml.Unmarshal(raw, cfg); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func applyEnv(cfg *Config) error {
+	envString(&cfg.ListenAddr, "LISTEN_ADDR")
+	envString(&cfg.GRPCAddr, "GRPC_ADDR")
+	envList(&cfg.AllowedOrigins, "ALLOWED_ORIGINS")
+	envList(&cfg.TrustedProxies, "TRUSTED_PROXIES")
+	if err := envFloat(&cfg.DuplicateThreshold, "DUPLICATE_THRESHOLD"); err != nil {
+		return err
+	}
+	if err := envFloat(&cfg.Limits.DocumentOps.Rate, "DOCUMENT_OPS_RATE"); err != nil {
+		return err
+	}
+	if err := envFloat(&cfg.Limits.AuthAttempts.Rate, "AUTH_ATTEMPTS_RATE"); err != nil {
+		return err
+	}
+	if err := envBool(&cfg.DevMode, "DEV_MODE"); err != nil {
+		return err
+	}
+	if err := envBool(&cfg.Compression.Enabled, "COMPRESSION"); err != nil {
+		return err
+	}
+	if err := envBool(&cfg.Encryption.AllowPlaintext, "ENCRYPTION_ALLOW_PLAINTEXT"); err != nil {
+		return err
+	}
+	envString(&cfg.StorageDSN, "STORAGE_DSN")
+	envString(&cfg.RedisURL, "REDIS_URL")
+	envList(&cfg.Kafka.Brokers, "KAFKA_BROKERS")
+	envString(&cfg.Kafka.TopicPrefix, "KAFKA_TOPIC_PREFIX")
+	envString(&cfg.Secrets.Provider, "SECRETS_PROVIDER")
+	envString(&cfg.Secrets.Dir, "SECRETS_DIR")
+	envString(&cfg.Secrets.VaultAddr, "VAULT_ADDR")
+	envString(&cfg.Secrets.VaultMount, "VAULT_MOUNT")
+	envString(&cfg.Secrets.VaultTokenFile, "VAULT_TOKEN_FILE")
+	envString(&cfg.Secrets.AWSRegion, "AWS_REGION")
+	envString(&cfg.Canary.Engine, "CANARY_ENGINE")
+	envString(&cfg.Recording.DSN, "RECORDING_DSN")
+	envList(&cfg.Recording.Scrub, "RECORDING_SCRUB")
+	envString(&cfg.EventLog.DSN, "EVENT_LOG_DSN")
+	envString(&cfg.Frontend.Dir, "FRONTEND_DIR")
+	envString(&cfg.Frontend.BasePath, "FRONTEND_BASE_PATH")
+	envString(&cfg.Limits.IdlePolicy, "IDLE_POLICY")
+	envString(&cfg.Analysis.URL, "ANALYSIS_URL")
+	envList(&cfg.Backpressure.Coalesce, "BACKPRESSURE_COALESCE")
+	envList(&cfg.Backpressure.LowPriority, "BACKPRESSURE_LOW_PRIORITY")
+	envString(&cfg.Migration.Token, "MIGRATION_TOKEN")
+	envString(&cfg.Cluster.NodeID, "NODE_ID")
+	envString(&cfg.Cluster.AdvertiseURL, "ADVERTISE_URL")
+	envString(&cfg.Share.Secret, "SHARE_SECRET")
+	envList(&cfg.Webhooks.URLs, "WEBHOOK_URLS")
+	envString(&cfg.Webhooks.Secret, "WEBHOOK_SECRET")
+	envString(&cfg.Mail.Host, "MAIL_HOST")
+	envString(&cfg.Mail.Username, "MAIL_USERNAME")
+	envString(&cfg.Mail.Password, "MAIL_PASSWORD")
+	envString(&cfg.Mail.From, "MAIL_FROM")
+	envString(&cfg.Mail.ClientURL, "MAIL_CLIENT_URL")
+	envString(&cfg.Attachments.DSN, "ATTACHMENTS_DSN")
+	envList(&cfg.Attachments.AllowedTypes, "ATTACHMENT_TYPES")
+	envString(&cfg.Blobs.DSN, "BLOB_DSN")
+	envString(&cfg.Blobs.Prefix, "BLOB_PREFIX")
+	envString(&cfg.Blobs.Encryption, "BLOB_ENCRYPTION")
+	envString(&cfg.Blobs.KMSKeyID, "BLOB_KMS_KEY_ID")
+	envString(&cfg.Backups.DSN, "BACKUP_DSN")
+	envString(&cfg.Encryption.ActiveKey, "ENCRYPTION_ACTIVE_KEY")
+	envString(&cfg.Encryption.KMSRegion, "ENCRYPTION_KMS_REGION")
+	if value, ok := os.LookupEnv("ENCRYPTION_KEYS"); ok {
+		if err := (*keyMap)(&cfg.Encryption.Keys).Set(value); err != nil {
+			return fmt.Errorf("invalid ENCRYPTION_KEYS: %w", err)
+		}
+	}
+	envString(&cfg.Tenancy.Domain, "TENANT_DOMAIN")
+	envString(&cfg.OAuth.RedirectURL, "OAUTH_REDIRECT_URL")
+	envString(&cfg.OAuth.ClientURL, "OAUTH_CLIENT_URL")
+	envString(&cfg.OAuth.Google.ClientID, "GOOGLE_CLIENT_ID")
+	envString(&cfg.OAuth.Google.ClientSecret, "GOOGLE_CLIENT_SECRET")
+	envString(&cfg.OAuth.GitHub.ClientID, "GITHUB_CLIENT_ID")
+	envString(&cfg.OAuth.GitHub.ClientSecret, "GITHUB_CLIENT_SECRET")
+	envString(&cfg.TLS.CertFile, "TLS_CERT_FILE")
+	envString(&cfg.TLS.KeyFile, "TLS_KEY_FILE")
+	envList(&cfg.TLS.AutocertHosts, "AUTOCERT_HOSTS")
+	envString(&cfg.TLS.AutocertEmail, "AUTOCERT_EMAIL")
+	envString(&cfg.TLS.AutocertCacheDir, "AUTOCERT_CACHE_DIR")
+	envString(&cfg.TLS.RedirectAddr, "TLS_REDIRECT_ADDR")
+	envString(&cfg.Log.Level, "LOG_LEVEL")
+	if value, ok := os.LookupEnv("API_TOKENS"); ok {
+		if err := (*tokenList)(&cfg.APITokens).Set(value); err != nil {
+			return fmt.Errorf("invalid API_TOKENS: %w", err)
+		}
+	}
+	envString(&cfg.Log.Format, "LOG_FORMAT")
+
+	for name, target := range map[string]*int{
+		"READ_BUFFER_SIZE":       &cfg.Limits.ReadBufferSize,
+		"WRITE_BUFFER_SIZE":      &cfg.Limits.WriteBufferSize,
+		"SEND_BUFFER_SIZE":       &cfg.Limits.SendBufferSize,
+		"HISTORY_SIZE":           &cfg.Limits.HistorySize,
+		"CHAT_HISTORY_SIZE":      &cfg.Limits.ChatHistorySize,
+		"MAX_CHAT_LENGTH":        &cfg.Limits.MaxChatLength,
+		"NOTIFICATIONS_PER_USER": &cfg.Limits.NotificationsPerUser,
+		"MAX_ROOM_CLIENTS":       &cfg.Limits.MaxRoomClients,
+		"MAX_ROOMS":              &cfg.Limits.MaxRooms,
+		"MAX_USER_CONNECTIONS":   &cfg.Limits.MaxUserConnections,
+		"MAX_IP_CONNECTIONS":     &cfg.Limits.MaxIPConnections,
+		"MAX_DOCUMENT_SIZE":      &cfg.Limits.MaxDocumentSize,
+		"MAX_MUTES":              &cfg.Limits.MaxMutes,
+		"DOCUMENT_OPS_BURST":     &cfg.Limits.DocumentOps.Burst,
+		"AUTH_ATTEMPTS_BURST":    &cfg.Limits.AuthAttempts.Burst,
+
+		"FANOUT_WORKERS":         &cfg.Fanout.Workers,
+		"FANOUT_BATCH_SIZE":      &cfg.Fanout.BatchSize,
+		"EDIT_BATCH_MAX_OPS":     &cfg.EditBatch.MaxOps,
+		"COMPRESSION_LEVEL":      &cfg.Compression.Level,
+		"COMPRESSION_THRESHOLD":  &cfg.Compression.Threshold,
+		"CANARY_PERCENT":         &cfg.Canary.Percent,
+		"RECORDING_PERCENT":      &cfg.Recording.Percent,
+		"WEBHOOK_MAX_ATTEMPTS":   &cfg.Webhooks.MaxAttempts,
+		"WEBHOOK_LOG_SIZE":       &cfg.Webhooks.LogSize,
+		"MAIL_PORT":              &cfg.Mail.Port,
+		"AUDIT_MAX_ENTRIES":      &cfg.Audit.MaxEntries,
+		"BACKUP_KEEP":            &cfg.Backups.Keep,
+		"SPECTATOR_THRESHOLD":    &cfg.Spectators.Threshold,
+		"BOT_MAX_ROOMS":          &cfg.Bots.MaxRooms,
+		"TENANT_MAX_DOCUMENTS":   &cfg.Tenancy.MaxDocuments,
+		"TENANT_MAX_CONNECTIONS": &cfg.Tenancy.MaxConnections,
+	} {
+		if err := envInt(target, name); err != nil {
+			return err
+		}
+	}
+	for name, target := range map[string]*int64{
+		"MAX_MESSAGE_SIZE": &cfg.Limits.MaxMessageSize,
+		"MAX_CHUNKED_SIZE": &cfg.Limits.MaxChunkedSize,
+
+		"ATTACHMENT_MAX_SIZE": &cfg.Attachments.MaxSize,
+		"IMPORT_MAX_SIZE":     &cfg.Attachments.MaxImportSize,
+	} {
+		if err := envInt64(target, name); err != nil {
+			return err
+		}
+	}
+	for name, target := range map[string]*time.Duration{
+		"MUTE_DURATION": &cfg.Limits.MuteDuration,
+		"WRITE_TIMEOUT": &cfg.Limits.WriteTimeout,
+		"IDLE_TIMEOUT":  &cfg.Limits.IdleTimeout,
+		"IDLE_WARNING":  &cfg.Limits.IdleWarning,
+
+		"PRESENCE_ROSTER_INTERVAL":   &cfg.PresenceRosterInterval,
+		"SESSION_TTL":                &cfg.SessionTTL,
+		"DOCUMENT_IDLE_TIMEOUT":      &cfg.DocumentIdleTimeout,
+		"AUTOSAVE_INTERVAL":          &cfg.AutosaveInterval,
+		"HIBERNATE_AFTER":            &cfg.HibernateAfter,
+		"TITLE_SUGGEST_DELAY":        &cfg.TitleSuggestDelay,
+		"PRESENCE_TTL":               &cfg.PresenceTTL,
+		"SECRETS_REFRESH_INTERVAL":   &cfg.Secrets.RefreshInterval,
+		"LINK_CHECK_INTERVAL":        &cfg.LinkCheck.Interval,
+		"BACKPRESSURE_STALL_TIMEOUT": &cfg.Backpressure.StallTimeout,
+		"EDIT_BATCH_WINDOW":          &cfg.EditBatch.Window,
+		"LEASE_TTL":                  &cfg.Cluster.LeaseTTL,
+		"LINK_CHECK_TIMEOUT":         &cfg.LinkCheck.Timeout,
+		"ANALYSIS_IDLE_DELAY":        &cfg.Analysis.IdleDelay,
+		"ANALYSIS_TIMEOUT":           &cfg.Analysis.Timeout,
+		"COMPACTION_INTERVAL":        &cfg.Compaction.Interval,
+		"COMPACTION_MAX_AGE":         &cfg.Compaction.MaxAge,
+		"SHARE_MAX_TTL":              &cfg.Share.MaxTTL,
+		"WEBHOOK_BACKOFF":            &cfg.Webhooks.Backoff,
+		"WEBHOOK_TIMEOUT":            &cfg.Webhooks.Timeout,
+		"MAIL_DIGEST_INTERVAL":       &cfg.Mail.DigestInterval,
+		"AUDIT_MAX_AGE":              &cfg.Audit.MaxAge,
+		"BACKUP_INTERVAL":            &cfg.Backups.Interval,
+		"TRASH_RETENTION":            &cfg.Trash.Retention,
+		"TRASH_PURGE_INTERVAL":       &cfg.Trash.PurgeInterval,
+		"SPECTATOR_INTERVAL":         &cfg.Spectators.Interval,
+		"LATENCY_INTERVAL":           &cfg.Latency.Interval,
+	} {
+		if err := envDuration(target, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func envString(target *string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+func envList(target *[]string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = splitList(value)
+	}
+}
+
+func envBool(target *bool, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envInt(target *int, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envInt64(target *int64, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envFloat(target *float64, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envDuration(target *time.Duration, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// stringList is a comma separated flag value
+type stringList []string
+
+func (list *stringList) String() string {
+	if list == nil {
+		return ""
+	}
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = splitList(value)
+	return nil
+}
+
+// tokenList is a comma separated list of name:role:sha256 API tokens
+type tokenList []APIToken
+
+func (list *tokenList) String() string {
+	if list == nil {
+		return ""
+	}
+	names := make([]string, len(*list))
+	for i, token := range *list {
+		names[i] = token.Name + ":" + token.Role
+	}
+	return strings.Join(names, ",")
+}
+
+func (list *tokenList) Set(value string) error {
+	var tokens []APIToken
+	for _, item := range splitList(value) {
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 && len(parts) != 4 {
+			return fmt.Errorf("API token %q is not name:role:sha256 or name:role:sha256:tenant", item)
+		}
+		token := APIToken{Name: parts[0], Role: parts[1], SHA256: parts[2]}
+		if len(parts) == 4 {
+			token.Tenant = parts[3]
+		}
+		tokens = append(tokens, token)
+	}
+	*list = tokens
+	return nil
+}
+
+// keyMap is a comma separated list of id:key encryption keys. Only the IDs
+// are ever printed.
+type keyMap map[string]string
+
+func (keys *keyMap) String() string {
+	if keys == nil {
+		return ""
+	}
+	ids := make([]string, 0, len(*keys))
+	for id := range *keys {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return strings.Join(ids, ",")
+}
+
+func (keys *keyMap) Set(value string) error {
+	parsed := make(map[string]string)
+	for _, item := range splitList(value) {
+		id, key, ok := strings.Cut(item, ":")
+		if !ok || id == "" || key == "" {
+			return fmt.Errorf("encryption key %q is not id:key", item)
+		}
+		parsed[id] = key
+	}
+	*keys = parsed
+	return nil
+}
@@ -0,0 +1,278 @@
+// Package docrpc is the internal gRPC API of the document engine. The
+// service is defined in document.proto; document.pb.go and
+// document_grpc.pb.go are generated from it with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--go-grpc_out=. --go-grpc_opt=paths=source_relative document.proto
+package docrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"backend/document"
+	"backend/rbac"
+	"backend/richtext"
+	"backend/socket"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ownerTrailer carries the URL of the node owning a document to callers
+// that sent an edit to another node
+const ownerTrailer = "x-document-owner"
+
+// Scope each method needs of the caller's API token
+var methodScopes = map[string]rbac.Scope{
+	DocumentService_ApplyOps_FullMethodName:    rbac.ScopeDocsWrite,
+	DocumentService_GetSnapshot_FullMethodName: rbac.ScopeDocsRead,
+	DocumentService_Subscribe_FullMethodName:   rbac.ScopeDocsRead,
+}
+
+// Server serves DocumentService from the manager's documents
+type Server struct {
+	UnimplementedDocumentServiceServer
+
+	Manager    *socket.WebSocketManager
+	Authorizer *rbac.Authorizer
+}
+
+// NewGRPCServer returns a gRPC server with the document service
+// registered, which checks every call's API token
+func NewGRPCServer(manager *socket.WebSocketManager, authorizer *rbac.Authorizer, options ...grpc.ServerOption) *grpc.Server {
+	server := &Server{Manager: manager, Authorizer: authorizer}
+	options = append(options,
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			principal, err := server.authorize(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(withPrincipal(ctx, principal), req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			principal, err := server.authorize(stream.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &principalStream{ServerStream: stream, ctx: withPrincipal(stream.Context(), principal)})
+		}),
+	)
+	grpcServer := grpc.NewServer(options...)
+	RegisterDocumentServiceServer(grpcServer, server)
+	return grpcServer
+}
+
+// authorize checks the bearer API token in the call's metadata against the
+// scope the method needs
+func (server *Server) authorize(ctx context.Context, method string) (rbac.Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get("authorization"); len(values) > 0 {
+		token, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	principal, ok := server.Authorizer.Authenticate(token)
+	if token == "" || !ok {
+		return rbac.Principal{}, status.Error(codes.Unauthenticated, "a valid API token is required")
+	}
+	if scope := methodScopes[method]; !principal.Allows(scope) {
+		return rbac.Principal{}, status.Errorf(codes.PermissionDenied, "role %s lacks scope %s", principal.Role, scope)
+	}
+	return principal, nil
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, principal rbac.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// principalStream is a stream whose context carries the caller
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *principalStream) Context() context.Context {
+	return stream.ctx
+}
+
+// lookup returns docID, which a token restricted to a tenant only finds
+// among the tenant's documents, unless it is in the trash
+func (server *Server) lookup(ctx context.Context, docID string) (*document.Document, error) {
+	doc, err := server.Manager.Documents.Lookup(docID)
+	if err != nil {
+		return nil, err
+	}
+	principal, _ := ctx.Value(principalKey{}).(rbac.Principal)
+	if principal.Tenant != "" && !doc.ClaimTenant(principal.Tenant) {
+		return nil, document.ErrNotFound
+	}
+	if _, trashed := doc.TrashedAt(); trashed {
+		return nil, document.ErrTrashed
+	}
+	return doc, nil
+}
+
+func (server *Server) ApplyOps(ctx context.Context, req *ApplyOpsRequest) (*ApplyOpsResponse, error) {
+	if req.DocId == "" || req.BaseRevision < 0 || len(req.Changes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "doc_id, base_revision and at least one change are required")
+	}
+	changes := make([]richtext.Delta, len(req.Changes))
+	for i, change := range req.Changes {
+		if err := json.Unmarshal([]byte(change), &changes[i]); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "change %d is not a JSON delta", i)
+		}
+	}
+
+	// Only the node owning the document applies edits to it. Callers
+	// are told which node that is to send the call there instead.
+	owner, local, err := server.Manager.OwnerOf(ctx, req.DocId)
+	if err != nil {
+		server.Manager.Logger.Warn("Could not look up document owner", "doc_id", req.DocId, "error", err)
+		return nil, status.Error(codes.Unavailable, "the document's owner could not be looked up")
+	}
+	if !local {
+		grpc.SetTrailer(ctx, metadata.Pairs(ownerTrailer, owner.URL))
+		return nil, status.Errorf(codes.FailedPrecondition, "the document is owned by node %s at %s", owner.ID, owner.URL)
+	}
+
+	// Edits are attributed to the service holding the token, under the
+	// name it gives
+	if _, err := server.lookup(ctx, req.DocId); err != nil {
+		return nil, server.status(req.DocId, err)
+	}
+	principal, _ := ctx.Value(principalKey{}).(rbac.Principal)
+	author := socket.Session{UserID: "service:" + principal.Name, UserName: req.Author, Tenant: principal.Tenant}
+	if author.UserName == "" {
+		author.UserName = principal.Name
+	}
+	ops, _, err := server.Manager.MergeChanges(req.DocId, author, req.BaseRevision, changes)
+	if err != nil {
+		return nil, server.status(req.DocId, err)
+	}
+
+	response := &ApplyOpsResponse{Ops: make([]*Op, len(ops))}
+	for i, op := range ops {
+		response.Ops[i] = opMessage(req.DocId, op)
+	}
+	doc, err := server.Manager.Documents.Lookup(req.DocId)
+	if err != nil {
+		return nil, server.status(req.DocId, err)
+	}
+	response.Revision = doc.Revision()
+	return response, nil
+}
+
+func (server *Server) GetSnapshot(ctx context.Context, req *GetSnapshotRequest) (*Snapshot, error) {
+	doc, err := server.lookup(ctx, req.DocId)
+	if err != nil {
+		return nil, server.status(req.DocId, err)
+	}
+	return snapshotMessage(doc)
+}
+
+// Subscribe subscribes before reading the op log, so no op falls between
+// the ops replayed and the live ones
+func (server *Server) Subscribe(req *SubscribeRequest, stream grpc.ServerStreamingServer[DocumentEvent]) error {
+	doc, err := server.lookup(stream.Context(), req.DocId)
+	if err != nil {
+		return server.status(req.DocId, err)
+	}
+	ops, cancel := server.Manager.SubscribeOps(req.DocId)
+	defer cancel()
+
+	last := req.FromRevision
+	missed, ok := doc.OpsSince(last)
+	if last <= 0 || !ok {
+		snapshot, err := snapshotMessage(doc)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&DocumentEvent{Event: &DocumentEvent_Snapshot{Snapshot: snapshot}}); err != nil {
+			return err
+		}
+		last, missed = snapshot.Revision, nil
+	}
+	for _, op := range missed {
+		if err := stream.Send(&DocumentEvent{Event: &DocumentEvent_Op{Op: opMessage(req.DocId, op)}}); err != nil {
+			return err
+		}
+		last = op.Revision
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case op, ok := <-ops:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "subscriber fell behind at revision %d", last)
+			}
+			if op.Revision <= last {
+				continue
+			}
+			if err := stream.Send(&DocumentEvent{Event: &DocumentEvent_Op{Op: opMessage(req.DocId, op)}}); err != nil {
+				return err
+			}
+			last = op.Revision
+		}
+	}
+}
+
+// status turns an error of the document engine into a gRPC status
+func (server *Server) status(docID string, err error) error {
+	switch {
+	case errors.Is(err, document.ErrNotFound):
+		return status.Error(codes.NotFound, "document not found")
+	case errors.Is(err, document.ErrEditRestricted), errors.Is(err, socket.ErrMuted):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, richtext.ErrInvalidDelta), errors.Is(err, richtext.ErrLengthMismatch),
+		errors.Is(err, document.ErrRevisionInTheFuture):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, document.ErrRevisionUnavailable), errors.Is(err, document.ErrTrashed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, document.ErrFrozen):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if _, limited := server.Manager.EditLimited(err); limited {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	server.Manager.Logger.Error("gRPC call failed", "doc_id", docID, "error", err)
+	return status.Error(codes.Unavailable, "document unavailable")
+}
+
+func snapshotMessage(doc *document.Document) (*Snapshot, error) {
+	content, revision := doc.Contents()
+	delta, err := json.Marshal(content)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "could not encode the document")
+	}
+	return &Snapshot{
+		DocId:           doc.ID,
+		Revision:        revision,
+		Delta:           string(delta),
+		Html:            richtext.ToHTML(content),
+		Title:           doc.Metadata().Title,
+		UpdatedAtUnixMs: doc.UpdatedAt().UnixMilli(),
+	}, nil
+}
+
+func opMessage(docID string, op document.Op) *Op {
+	return &Op{
+		DocId:        docID,
+		Revision:     op.Revision,
+		Author:       op.Author,
+		Pos:          int32(op.Edit.Pos),
+		Deleted:      int32(op.Edit.Deleted),
+		Inserted:     int32(op.Edit.Inserted),
+		DeletedText:  op.Deleted,
+		InsertedText: op.Inserted,
+		TimeUnixMs:   op.Time.UnixMilli(),
+		Payload:      op.Payload,
+	}
+}
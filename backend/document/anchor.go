@@ -0,0 +1,121 @@
+package document
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidRange        = errors.New("range is outside the document")
+	ErrRevisionUnavailable = errors.New("revision is no longer in the document history")
+	ErrRevisionInTheFuture = errors.New("revision has not been reached yet")
+	ErrAnchorAlreadyExists = errors.New("anchor already exists")
+)
+
+// Range is a half-open span of the document's plain text in characters
+// (runes)
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Edit describes a content change as a single replacement: Deleted
+// characters at Pos were replaced by Inserted characters
+type Edit struct {
+	Pos      int `json:"pos"`
+	Deleted  int `json:"deleted"`
+	Inserted int `json:"inserted"`
+}
+
+// Diff finds the smallest single replacement turning before into after by
+// trimming their common prefix and suffix
+func Diff(before string, after string) Edit {
+	a, b := []rune(before), []rune(after)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return Edit{
+		Pos:      prefix,
+		Deleted:  len(a) - prefix - suffix,
+		Inserted: len(b) - prefix - suffix,
+	}
+}
+
+// TransformRange moves a range so it keeps covering the same text after
+// the edit. Text inserted exactly at either boundary stays outside the
+// range; a range whose text was deleted entirely collapses to the edit.
+func (edit Edit) TransformRange(r Range) Range {
+	deletedEnd := edit.Pos + edit.Deleted
+
+	start := r.Start
+	switch {
+	case start >= deletedEnd:
+		start += edit.Inserted - edit.Deleted
+	case start > edit.Pos:
+		start = edit.Pos + edit.Inserted
+	}
+
+	end := r.End
+	switch {
+	case end > deletedEnd || (end == deletedEnd && edit.Deleted > 0):
+		end += edit.Inserted - edit.Deleted
+	case end > edit.Pos:
+		end = edit.Pos
+	}
+
+	if end < start {
+		end = start
+	}
+	return Range{Start: start, End: end}
+}
+
+// AddAnchor registers a range that refers to the content at revision. It
+// is transformed through every later edit, then kept up to date as new
+// edits are applied.
+func (doc *Document) AddAnchor(id string, revision int64, r Range) (Range, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if _, exists := doc.anchors[id]; exists {
+		return Range{}, ErrAnchorAlreadyExists
+	}
+	if revision > doc.revision {
+		return Range{}, ErrRevisionInTheFuture
+	}
+	if revision < doc.revision {
+		if len(doc.history) == 0 || doc.history[0].Revision > revision+1 {
+			return Range{}, ErrRevisionUnavailable
+		}
+		for _, op := range doc.history[revision+1-doc.history[0].Revision:] {
+			r = op.Edit.TransformRange(r)
+		}
+	}
+
+	if r.Start < 0 || r.End < r.Start || r.End > utf8.RuneCountInString(doc.text) {
+		return Range{}, ErrInvalidRange
+	}
+	doc.anchors[id] = r
+	return r, nil
+}
+
+// Anchor returns the current position of an anchored range
+func (doc *Document) Anchor(id string) (Range, bool) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+
+	r, ok := doc.anchors[id]
+	return r, ok
+}
+
+func (doc *Document) RemoveAnchor(id string) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	delete(doc.anchors, id)
+}
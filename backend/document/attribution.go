@@ -0,0 +1,134 @@
+package document
+
+import "unicode/utf8"
+
+// AuthorSpan is a run of consecutive characters of the plain text written
+// by the same user. Author is empty for text whose author isn't known,
+// such as content written before authorship was kept.
+type AuthorSpan struct {
+	Author string `json:"author,omitempty"`
+	Length int    `json:"length"`
+}
+
+// AuthorRange is the text from Start to End written by Author
+type AuthorRange struct {
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Author string `json:"author,omitempty"`
+}
+
+// AttributionUpdate is how an op changed the document's authorship: the
+// Deleted characters at Pos were replaced by Ranges, which cover the text
+// the op inserted in its place. Authorship elsewhere is unchanged and only
+// moves along.
+type AttributionUpdate struct {
+	Revision int64         `json:"revision"`
+	Pos      int           `json:"pos"`
+	Deleted  int           `json:"deleted"`
+	Ranges   []AuthorRange `json:"ranges"`
+}
+
+// Attribution returns who wrote each character of the plain text, as
+// ranges covering it end to end, and the revision it is for
+func (doc *Document) Attribution() ([]AuthorRange, int64) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return authorRanges(doc.authors, 0), doc.revision
+}
+
+// Attribution returns how the op changed the document's authorship. Only
+// ops just applied know it; ops from the history return false.
+func (op Op) Attribution() (AttributionUpdate, bool) {
+	if op.authors == nil {
+		return AttributionUpdate{}, false
+	}
+	return AttributionUpdate{
+		Revision: op.Revision,
+		Pos:      op.Edit.Pos,
+		Deleted:  op.Edit.Deleted,
+		Ranges:   authorRanges(op.authors, op.Edit.Pos),
+	}, true
+}
+
+// unattributed is the authorship of text nobody is known to have written
+func unattributed(text string) []AuthorSpan {
+	return []AuthorSpan{{Length: utf8.RuneCountInString(text)}}
+}
+
+// attribute returns spans after edit, with the text it inserted written by
+// author
+func attribute(spans []AuthorSpan, edit Edit, author string) []AuthorSpan {
+	out := make([]AuthorSpan, 0, len(spans)+2)
+	deletedEnd := edit.Pos + edit.Deleted
+	inserted := false
+	pos := 0
+	for _, span := range spans {
+		start, end := pos, pos+span.Length
+		pos = end
+		if start < edit.Pos {
+			out = appendSpan(out, AuthorSpan{span.Author, min(end, edit.Pos) - start})
+		}
+		if end >= edit.Pos && !inserted {
+			out = appendSpan(out, AuthorSpan{author, edit.Inserted})
+			inserted = true
+		}
+		if end > deletedEnd {
+			out = appendSpan(out, AuthorSpan{span.Author, end - max(start, deletedEnd)})
+		}
+	}
+	if !inserted {
+		out = appendSpan(out, AuthorSpan{author, edit.Inserted})
+	}
+	return out
+}
+
+// appendSpan appends span, merging it into the last one when the same user
+// wrote both
+func appendSpan(spans []AuthorSpan, span AuthorSpan) []AuthorSpan {
+	if span.Length <= 0 {
+		return spans
+	}
+	if last := len(spans) - 1; last >= 0 && spans[last].Author == span.Author {
+		spans[last].Length += span.Length
+		return spans
+	}
+	return append(spans, span)
+}
+
+// window returns the spans of the Length characters at pos
+func window(spans []AuthorSpan, pos int, length int) []AuthorSpan {
+	out := []AuthorSpan{}
+	start := 0
+	for _, span := range spans {
+		end := start + span.Length
+		from, to := max(start, pos), min(end, pos+length)
+		if from < to {
+			out = appendSpan(out, AuthorSpan{span.Author, to - from})
+		}
+		start = end
+	}
+	return out
+}
+
+// authorRanges places spans one after the other from start
+func authorRanges(spans []AuthorSpan, start int) []AuthorRange {
+	ranges := make([]AuthorRange, 0, len(spans))
+	for _, span := range spans {
+		ranges = append(ranges, AuthorRange{Start: start, End: start + span.Length, Author: span.Author})
+		start += span.Length
+	}
+	return ranges
+}
+
+// spansCover reports whether spans cover exactly text, so a saved
+// attribution still fits the content it was saved with
+func spansCover(spans []AuthorSpan, text string) bool {
+	total := 0
+	for _, span := range spans {
+		if span.Length <= 0 {
+			return false
+		}
+		total += span.Length
+	}
+	return total == utf8.RuneCountInString(text)
+}
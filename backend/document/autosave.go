@@ -0,0 +1,212 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Where a document stands with the store, in SaveStatus
+const (
+	SaveDirty  = "dirty"
+	SaveSaving = "saving"
+	SaveSaved  = "saved"
+	SaveError  = "save-error"
+)
+
+// SaveStatus is where a document stands with the store. Revision and
+// SavedAt are those of the last successful save; SavedAt is zero until
+// the document has been saved.
+type SaveStatus struct {
+	State    string    `json:"state"`
+	Revision int64     `json:"revision"`
+	SavedAt  time.Time `json:"savedAt,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SaveListener is told whenever the save status of a loaded document
+// changes. It is called with the document locked and must not use it.
+type SaveListener func(id string, status SaveStatus)
+
+// saveState is what a document knows of its last successful save
+type saveState struct {
+	// version is the updatedAt of the content saved
+	version  time.Time
+	revision int64
+	at       time.Time
+
+	failed   bool
+	listener SaveListener
+}
+
+// SetSaveListener installs the function told about save status changes.
+// Like SetStore it must be called before any document is opened.
+func (registry *Registry) SetSaveListener(listener SaveListener) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.saveListener = listener
+}
+
+// followSaves has listener follow a document kept in the store; saved is the
+// record it was last saved as, if it ever was
+func (doc *Document) followSaves(listener SaveListener, saved *Record) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	doc.saved.listener = listener
+	if saved != nil {
+		doc.saved.version = doc.updatedAt
+		doc.saved.revision = saved.Revision
+		doc.saved.at = saved.UpdatedAt
+	}
+}
+
+// SaveChanged saves every loaded document that changed since it was last
+// saved and returns how many it saved. Documents that fail to save are
+// retried on the next call.
+func (registry *Registry) SaveChanged() (count int, err error) {
+	registry.mutex.Lock()
+	store := registry.store
+	registry.mutex.Unlock()
+	if store == nil {
+		return 0, nil
+	}
+
+	var errs []error
+	for _, doc := range registry.All() {
+		record, changed := doc.beginSave()
+		if !changed {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		saveErr := store.Save(ctx, record)
+		cancel()
+
+		doc.endSave(record, saveErr)
+		if saveErr != nil {
+			errs = append(errs, fmt.Errorf("saving document %q: %w", doc.ID, saveErr))
+			continue
+		}
+		count++
+	}
+	return count, errors.Join(errs...)
+}
+
+// Resave saves a loaded or saved document again whether or not it
+// changed, so the store writes it the way it writes now, sealed with the
+// active encryption key for one. The document is held meanwhile so it
+// can't be evicted halfway.
+func (registry *Registry) Resave(id string) error {
+	registry.mutex.Lock()
+	store := registry.store
+	registry.mutex.Unlock()
+	if store == nil {
+		return nil
+	}
+	doc, err := registry.open(id, false, true)
+	if err != nil {
+		return err
+	}
+	defer registry.Release(id)
+
+	record := doc.beginResave()
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	saveErr := store.Save(ctx, record)
+	cancel()
+
+	doc.endSave(record, saveErr)
+	if saveErr != nil {
+		return fmt.Errorf("saving document %q: %w", id, saveErr)
+	}
+	return nil
+}
+
+// SaveStatus returns where the document stands with the store, or false
+// when there is no store to save it to
+func (doc *Document) SaveStatus() (SaveStatus, bool) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+
+	if doc.saved.listener == nil {
+		return SaveStatus{}, false
+	}
+	switch {
+	case doc.saved.failed:
+		return doc.saveStatus(SaveError), true
+	case doc.updatedAt.After(doc.saved.version):
+		return doc.saveStatus(SaveDirty), true
+	}
+	return doc.saveStatus(SaveSaved), true
+}
+
+// changed records a change made at, telling the save listener when it is
+// the first since the document was saved. It must be called with the lock
+// held.
+func (doc *Document) changed(at time.Time) {
+	clean := !doc.updatedAt.After(doc.saved.version)
+	doc.updatedAt = at
+	if clean && doc.updatedAt.After(doc.saved.version) {
+		doc.notify(SaveDirty)
+	}
+}
+
+// beginSave returns the document as it stands when it changed since it
+// was last saved, telling the save listener it is being saved
+func (doc *Document) beginSave() (Record, bool) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if !doc.updatedAt.After(doc.saved.version) {
+		return Record{}, false
+	}
+	doc.notify(SaveSaving)
+	return doc.record(), true
+}
+
+// beginResave returns the document as it stands, changed or not, telling
+// the save listener it is being saved
+func (doc *Document) beginResave() Record {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	doc.notify(SaveSaving)
+	return doc.record()
+}
+
+// endSave records how saving record went. Changes made while it was being
+// saved leave the document dirty.
+func (doc *Document) endSave(record Record, err error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if err != nil {
+		doc.saved.failed = true
+		doc.notify(SaveError)
+		return
+	}
+	doc.saved.version = record.UpdatedAt
+	doc.saved.revision = record.Revision
+	doc.saved.at = time.Now()
+	doc.saved.failed = false
+	doc.notify(SaveSaved)
+	if doc.updatedAt.After(doc.saved.version) {
+		doc.notify(SaveDirty)
+	}
+}
+
+// saveStatus must be called with the lock held
+func (doc *Document) saveStatus(state string) SaveStatus {
+	status := SaveStatus{State: state, Revision: doc.saved.revision, SavedAt: doc.saved.at}
+	if state == SaveError {
+		status.Error = "the document could not be saved, it is retried shortly"
+	}
+	return status
+}
+
+// notify must be called with the lock held
+func (doc *Document) notify(state string) {
+	if doc.saved.listener != nil {
+		doc.saved.listener(doc.ID, doc.saveStatus(state))
+	}
+}
@@ -0,0 +1,217 @@
+package document
+
+import (
+	"errors"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"backend/ids"
+	"backend/richtext"
+)
+
+var ErrChangeNotFound = errors.New("tracked change not found")
+
+// TrackedChange is an edit made while track changes is on and not yet
+// reviewed. The edit is already in the content: Range covers the text it
+// inserted, moved along with later edits like an anchor, and Deleted is the
+// content it replaced. Consecutive edits of an author to the same spot are
+// merged into one change. Formatting changes are not tracked.
+type TrackedChange struct {
+	ID        string         `json:"id"`
+	Author    string         `json:"author"`
+	Revision  int64          `json:"revision"`
+	Range     Range          `json:"range"`
+	Deleted   richtext.Delta `json:"deleted,omitempty"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// empty reports whether the change no longer changes anything, as when its
+// author deleted all the text they had inserted
+func (change TrackedChange) empty() bool {
+	return change.Range.Start == change.Range.End && len(change.Deleted) == 0
+}
+
+// SetTrackChanges turns tracking of edits on or off on behalf of userID,
+// who must be the owner. Changes already tracked stay pending either way.
+func (doc *Document) SetTrackChanges(userID string, enabled bool) error {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if userID == "" || userID != doc.permissions.Owner {
+		return ErrNotOwner
+	}
+	doc.permissions.TrackChanges = enabled
+	doc.changed(time.Now())
+	return nil
+}
+
+// TrackedChanges returns the pending changes, oldest first, along with the
+// content their ranges refer to
+func (doc *Document) TrackedChanges() ([]TrackedChange, richtext.Delta) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return slices.Clone(doc.changes), doc.content
+}
+
+// Redline returns the content with the pending changes marked: inserted
+// text carries richtext.TrackedInsert and deleted content is put back in
+// front of it carrying richtext.TrackedDelete
+func (doc *Document) Redline() (richtext.Delta, int64) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+
+	changes := slices.Clone(doc.changes)
+	slices.SortStableFunc(changes, func(a, b TrackedChange) int { return a.Range.Start - b.Range.Start })
+
+	var out richtext.Delta
+	position := 0
+	for _, change := range changes {
+		// A change inside one already marked is shown as part of it
+		if change.Range.Start < position {
+			continue
+		}
+		out = append(out, richtext.Slice(doc.content, position, change.Range.Start)...)
+		out = append(out, richtext.Mark(change.Deleted, richtext.TrackedDelete)...)
+		out = append(out, richtext.Mark(richtext.Slice(doc.content, change.Range.Start, change.Range.End), richtext.TrackedInsert)...)
+		position = change.Range.End
+	}
+	out = append(out, richtext.Slice(doc.content, position, utf8.RuneCountInString(doc.text))...)
+	return out, doc.revision
+}
+
+// AcceptChanges keeps the given pending changes as they are on behalf of
+// userID, who must be the owner, or all of them when ids is nil
+func (doc *Document) AcceptChanges(userID string, ids []string) ([]TrackedChange, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	accepted, err := doc.reviewedChanges(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	doc.changed(time.Now())
+	return accepted, nil
+}
+
+// RejectChanges undoes the given pending changes on behalf of userID, who
+// must be the owner, or all of them when ids is nil. The changes are undone
+// newest first in a single edit credited to userID; op is zero when the
+// content didn't change.
+func (doc *Document) RejectChanges(userID string, ids []string, payload Payload) (Op, []TrackedChange, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	rejected, err := doc.reviewedChanges(userID, ids)
+	if err != nil {
+		return Op{}, nil, err
+	}
+	slices.SortStableFunc(rejected, func(a, b TrackedChange) int { return int(b.Revision - a.Revision) })
+
+	ranges := make([]Range, len(rejected))
+	for i, change := range rejected {
+		ranges[i] = change.Range
+	}
+	content := doc.content
+	var edits []Edit
+	for i, change := range rejected {
+		r := ranges[i]
+		revert := richtext.Delta{}
+		if r.Start > 0 {
+			revert = append(revert, richtext.Op{Retain: r.Start})
+		}
+		if r.End > r.Start {
+			revert = append(revert, richtext.Op{Delete: r.End - r.Start})
+		}
+		revert = append(revert, change.Deleted...)
+		reverted, err := richtext.Compose(content, revert)
+		if err != nil {
+			// Put the changes back, nothing was applied
+			doc.changes = append(doc.changes, rejected...)
+			return Op{}, nil, err
+		}
+		content = reverted
+		edit := Edit{Pos: r.Start, Deleted: r.End - r.Start, Inserted: utf8.RuneCountInString(change.Deleted.Text())}
+		edits = append(edits, edit)
+		for j := i + 1; j < len(ranges); j++ {
+			ranges[j] = edit.TransformRange(ranges[j])
+		}
+	}
+	if len(edits) == 0 {
+		doc.changed(time.Now())
+		return Op{}, rejected, nil
+	}
+	return doc.applyEdits(userID, content, edits, payload), rejected, nil
+}
+
+// reviewedChanges checks that userID may review changes and takes the ones
+// with the given IDs, or all of them, out of the pending list, with the
+// lock held
+func (doc *Document) reviewedChanges(userID string, ids []string) ([]TrackedChange, error) {
+	if userID == "" || userID != doc.permissions.Owner {
+		return nil, ErrNotReviewer
+	}
+	if doc.frozen {
+		return nil, ErrFrozen
+	}
+	if ids == nil {
+		reviewed := doc.changes
+		doc.changes = nil
+		return reviewed, nil
+	}
+	for _, id := range ids {
+		if !slices.ContainsFunc(doc.changes, func(change TrackedChange) bool { return change.ID == id }) {
+			return nil, ErrChangeNotFound
+		}
+	}
+	var reviewed, pending []TrackedChange
+	for _, change := range doc.changes {
+		if slices.Contains(ids, change.ID) {
+			reviewed = append(reviewed, change)
+		} else {
+			pending = append(pending, change)
+		}
+	}
+	doc.changes = pending
+	return reviewed, nil
+}
+
+// track turns author's edit of the content into a pending change, merged
+// with a change of theirs it touches, with the lock held. It is called
+// before the edit is applied and returns the change in the coordinates of
+// the edited content, having taken any change it was merged with out of the
+// pending list; ok is false for edits that leave the text as it was.
+func (doc *Document) track(author string, edit Edit) (change TrackedChange, ok bool) {
+	if edit.Deleted == 0 && edit.Inserted == 0 {
+		return TrackedChange{}, false
+	}
+	deletedEnd := edit.Pos + edit.Deleted
+	now := time.Now()
+
+	i := slices.IndexFunc(doc.changes, func(change TrackedChange) bool {
+		return change.Author == author && edit.Pos <= change.Range.End && deletedEnd >= change.Range.Start
+	})
+	if i < 0 {
+		return TrackedChange{
+			ID:        ids.NewUUID(),
+			Author:    author,
+			Range:     Range{Start: edit.Pos, End: edit.Pos + edit.Inserted},
+			Deleted:   richtext.Slice(doc.content, edit.Pos, deletedEnd),
+			UpdatedAt: now,
+		}, true
+	}
+
+	// Text the edit deleted on either side of the change was original
+	// text, so it joins what the change deleted
+	merged := doc.changes[i]
+	doc.changes = slices.Delete(doc.changes, i, i+1)
+	start, end := min(merged.Range.Start, edit.Pos), max(merged.Range.End, deletedEnd)
+	var deleted richtext.Delta
+	deleted = append(deleted, richtext.Slice(doc.content, start, merged.Range.Start)...)
+	deleted = append(deleted, merged.Deleted...)
+	deleted = append(deleted, richtext.Slice(doc.content, merged.Range.End, end)...)
+	merged.Range = Range{Start: start, End: end + edit.Inserted - edit.Deleted}
+	merged.Deleted = deleted
+	merged.UpdatedAt = now
+	return merged, true
+}
@@ -0,0 +1,138 @@
+package document
+
+import (
+	"slices"
+)
+
+// Types of DiffSegment
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffSegment is a run of plain text in a diff between two revisions:
+// text both have, text only the later one has, or text only the earlier
+// one had. Inserted and deleted runs name the author and revision of the
+// op that inserted or deleted them.
+type DiffSegment struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Author   string `json:"author,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+}
+
+// diffChar is a character of the earlier revision or one inserted since,
+// as the ops between the revisions are replayed
+type diffChar struct {
+	char     rune
+	kind     string
+	author   string
+	revision int64
+}
+
+// DiffRevisions compares the plain text at revisions from and to, which
+// must both still be reachable through the op log. Text inserted and
+// deleted again between them doesn't show.
+func (doc *Document) DiffRevisions(from int64, to int64) ([]DiffSegment, error) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+
+	if from < 0 || from > to {
+		return nil, ErrInvalidRange
+	}
+	if to > doc.revision {
+		return nil, ErrRevisionInTheFuture
+	}
+	var ops []Op
+	if from < doc.revision {
+		if len(doc.history) == 0 || doc.history[0].Revision > from+1 {
+			return nil, ErrRevisionUnavailable
+		}
+		ops = doc.history[from+1-doc.history[0].Revision:]
+	}
+	for _, op := range ops {
+		if !op.hasText() {
+			return nil, ErrRevisionUnavailable
+		}
+	}
+
+	// Undo every op since from to get back to its text
+	text := []rune(doc.text)
+	for _, op := range slices.Backward(ops) {
+		text = slices.Concat(text[:op.Edit.Pos], []rune(op.Deleted), text[op.Edit.Pos+op.Edit.Inserted:])
+	}
+
+	chars := make([]diffChar, len(text))
+	for i, char := range text {
+		chars[i] = diffChar{char: char, kind: DiffEqual}
+	}
+	for _, op := range ops[:to-from] {
+		chars = replay(chars, op)
+	}
+	return segments(chars), nil
+}
+
+// hasText reports whether the op recorded the text it replaced
+func (op Op) hasText() bool {
+	return (op.Edit.Deleted == 0 || op.Deleted != "") && (op.Edit.Inserted == 0 || op.Inserted != "")
+}
+
+// replay applies op to chars: earlier text it deletes is kept marked as
+// deleted, text inserted since and deleted again is dropped
+func replay(chars []diffChar, op Op) []diffChar {
+	i := visibleIndex(chars, op.Edit.Pos)
+	for remaining := op.Edit.Deleted; remaining > 0 && i < len(chars); {
+		switch chars[i].kind {
+		case DiffDelete:
+			i++
+			continue
+		case DiffInsert:
+			chars = slices.Delete(chars, i, i+1)
+		default:
+			chars[i] = diffChar{char: chars[i].char, kind: DiffDelete, author: op.Author, revision: op.Revision}
+			i++
+		}
+		remaining--
+	}
+
+	inserted := make([]diffChar, 0, op.Edit.Inserted)
+	for _, char := range op.Inserted {
+		inserted = append(inserted, diffChar{char: char, kind: DiffInsert, author: op.Author, revision: op.Revision})
+	}
+	return slices.Insert(chars, i, inserted...)
+}
+
+// visibleIndex returns the index in chars of the character at pos in the
+// text they currently make up, after any deleted ones before it
+func visibleIndex(chars []diffChar, pos int) int {
+	visible := 0
+	for i, char := range chars {
+		if char.kind == DiffDelete {
+			continue
+		}
+		if visible == pos {
+			return i
+		}
+		visible++
+	}
+	return len(chars)
+}
+
+// segments joins runs of characters alike into segments
+func segments(chars []diffChar) []DiffSegment {
+	list := []DiffSegment{}
+	var run []rune
+	for i, char := range chars {
+		run = append(run, char.char)
+		if i+1 < len(chars) {
+			next := chars[i+1]
+			if next.kind == char.kind && next.author == char.author && next.revision == char.revision {
+				continue
+			}
+		}
+		list = append(list, DiffSegment{Type: char.kind, Text: string(run), Author: char.author, Revision: char.revision})
+		run = run[:0]
+	}
+	return list
+}
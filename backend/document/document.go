@@ -0,0 +1,643 @@
+package document
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/ratelimit"
+	"backend/richtext"
+)
+
+var (
+	// ErrStaleRevision rejects a change made against an older revision
+	ErrStaleRevision = errors.New("change is based on a stale revision")
+	// ErrFrozen rejects a change to a document that is being handed over
+	// to another node
+	ErrFrozen = errors.New("document is moving to another node")
+	// ErrInUse rejects replacing a document that clients hold open
+	ErrInUse = errors.New("document is in use")
+)
+
+// Op is one accepted edit. Payload is the exact frame relayed to the room,
+// kept so reconnecting clients can be replayed what they missed. Deleted
+// and Inserted are the plain text the edit replaced, which diffs between
+// revisions are built from; ops saved before they were recorded lack them.
+type Op struct {
+	Revision int64           `json:"revision"`
+	Author   string          `json:"author"`
+	Edit     Edit            `json:"edit"`
+	Deleted  string          `json:"deleted,omitempty"`
+	Inserted string          `json:"inserted,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+	Time     time.Time       `json:"time"`
+
+	// authors is who wrote the text the op inserted, see Attribution
+	authors []AuthorSpan
+}
+
+// Retention is how much of the op log Compact keeps: at most the latest
+// Revisions ops, and none older than MaxAge unless MaxAge is zero
+type Retention struct {
+	Revisions int
+	MaxAge    time.Duration
+}
+
+// Document is the server's authoritative copy of a room's content. Edits
+// and anchors are tracked in the plain text of the content. The op log
+// grows with every edit until Compact folds old ops away.
+type Document struct {
+	ID string
+
+	mutex     sync.RWMutex
+	content   richtext.Delta
+	text      string
+	revision  int64
+	updatedAt time.Time
+	history   []Op
+	anchors   map[string]Range
+	metadata  Metadata
+
+	permissions Permissions
+	editors     map[string]bool
+	suggestions []Suggestion
+	changes     []TrackedChange
+	reactions   []Reaction
+
+	// authors is who wrote each character of text, run by run
+	authors []AuthorSpan
+
+	// bans and mutes end at their time, or never when it is zero
+	bans  map[string]time.Time
+	mutes map[string]time.Time
+
+	// seen is how far each user has seen the document
+	seen map[string]SeenMarker
+
+	// trashedAt is when the document was moved to the trash, zero if not
+	trashedAt time.Time
+
+	// titledAt is the revision a title was last suggested at
+	titledAt int64
+
+	// frozen stops content changes while the document is handed over
+	frozen bool
+
+	// tenant the document belongs to, settled once claimed is set
+	tenant  string
+	claimed bool
+
+	// maxSize and ops are the registry's limits; see checkLimits
+	maxSize int
+	ops     *ratelimit.Bucket
+
+	saved saveState
+}
+
+func New(id string) *Document {
+	return &Document{
+		ID:        id,
+		content:   richtext.Delta{{Insert: "\n"}},
+		text:      "\n",
+		authors:   unattributed("\n"),
+		updatedAt: time.Now(),
+		anchors:   make(map[string]Range),
+		metadata:  Metadata{Direction: "ltr"},
+
+		permissions: Permissions{Export: ExportAnyone},
+		editors:     make(map[string]bool),
+		bans:        make(map[string]time.Time),
+		mutes:       make(map[string]time.Time),
+		seen:        make(map[string]SeenMarker),
+	}
+}
+
+// Snapshot returns the current content rendered as HTML and the revision
+// it corresponds to
+func (doc *Document) Snapshot() (content string, revision int64) {
+	delta, revision := doc.Contents()
+	return richtext.ToHTML(delta), revision
+}
+
+// Contents returns the current content and the revision it corresponds to
+func (doc *Document) Contents() (content richtext.Delta, revision int64) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.content, doc.revision
+}
+
+func (doc *Document) Revision() int64 {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.revision
+}
+
+func (doc *Document) UpdatedAt() time.Time {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.updatedAt
+}
+
+// Payload builds the frame stored in the op history for the content an edit
+// produced at revision
+type Payload func(revision int64, content richtext.Delta) []byte
+
+// Replace swaps in new normalized content, assigns the next revision and
+// moves anchored ranges along with the edit. It fails with
+// ErrEditRestricted when the document's mode doesn't let author edit.
+func (doc *Document) Replace(author string, content richtext.Delta, payload Payload) (Op, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if doc.frozen {
+		return Op{}, ErrFrozen
+	}
+	if !doc.canEdit(author) {
+		return Op{}, ErrEditRestricted
+	}
+	if err := doc.checkLimits(content.Text()); err != nil {
+		return Op{}, err
+	}
+	return doc.applyTracked(author, content, payload), nil
+}
+
+// ApplyChange composes a normalized change made against revision base
+// into the content. Live edits aren't transformed: a change whose base is
+// not the current revision fails with ErrStaleRevision and has to be
+// redone on the latest content. Merge transforms changes made offline.
+func (doc *Document) ApplyChange(author string, base int64, change richtext.Delta, payload Payload) (Op, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if doc.frozen {
+		return Op{}, ErrFrozen
+	}
+	if !doc.canEdit(author) {
+		return Op{}, ErrEditRestricted
+	}
+	if base != doc.revision {
+		return Op{}, ErrStaleRevision
+	}
+	content, err := richtext.Compose(doc.content, change)
+	if err != nil {
+		return Op{}, err
+	}
+	if err := doc.checkLimits(content.Text()); err != nil {
+		return Op{}, err
+	}
+	return doc.applyTracked(author, content, payload), nil
+}
+
+// applyTracked applies an edit, recording it as a pending change when
+// track changes is on
+func (doc *Document) applyTracked(author string, content richtext.Delta, payload Payload) Op {
+	if !doc.permissions.TrackChanges {
+		return doc.apply(author, content, payload)
+	}
+	change, tracked := doc.track(author, Diff(doc.text, content.Text()))
+	op := doc.apply(author, content, payload)
+	if tracked && !change.empty() {
+		change.Revision = op.Revision
+		doc.changes = append(doc.changes, change)
+	}
+	return op
+}
+
+func (doc *Document) apply(author string, content richtext.Delta, payload Payload) Op {
+	return doc.applyEdits(author, content, []Edit{Diff(doc.text, content.Text())}, payload)
+}
+
+// applyEdits swaps in content reached from the current one by edits in
+// turn. Anchors, tracked changes, reactions and authorship follow each
+// edit, with the text it inserts credited to author; the op records them
+// as one.
+func (doc *Document) applyEdits(author string, content richtext.Delta, edits []Edit, payload Payload) Op {
+	text := content.Text()
+	doc.revision++
+	doc.content = content
+	doc.changed(time.Now())
+	for _, edit := range edits {
+		for id, r := range doc.anchors {
+			doc.anchors[id] = edit.TransformRange(r)
+		}
+		for i := range doc.changes {
+			doc.changes[i].Range = edit.TransformRange(doc.changes[i].Range)
+		}
+		for i := range doc.reactions {
+			doc.reactions[i].Range = edit.TransformRange(doc.reactions[i].Range)
+		}
+		doc.authors = attribute(doc.authors, edit, author)
+	}
+	doc.changes = slices.DeleteFunc(doc.changes, TrackedChange.empty)
+	doc.reactions = slices.DeleteFunc(doc.reactions, func(reaction Reaction) bool { return reaction.Range.Start == reaction.Range.End })
+
+	op := Op{Revision: doc.revision, Author: author, Edit: Diff(doc.text, text), Payload: payload(doc.revision, content), Time: doc.updatedAt}
+	before, after := []rune(doc.text), []rune(text)
+	op.Deleted = string(before[op.Edit.Pos : op.Edit.Pos+op.Edit.Deleted])
+	op.Inserted = string(after[op.Edit.Pos : op.Edit.Pos+op.Edit.Inserted])
+	doc.text = text
+	doc.history = append(doc.history, op)
+	op.authors = window(doc.authors, op.Edit.Pos, op.Edit.Inserted)
+	return op
+}
+
+// Compact drops the ops retention no longer keeps and returns how many.
+// Their effect is already in the content; clients that fell further behind
+// get a full doc-sync instead of a replay.
+func (doc *Document) Compact(retention Retention) int {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	keep := min(len(doc.history), retention.Revisions)
+	if retention.MaxAge > 0 {
+		cutoff := time.Now().Add(-retention.MaxAge)
+		for keep > 0 && doc.history[len(doc.history)-keep].Time.Before(cutoff) {
+			keep--
+		}
+	}
+	dropped := len(doc.history) - keep
+	if dropped > 0 {
+		// Copy so the dropped ops' payloads can be freed
+		doc.history = slices.Clone(doc.history[dropped:])
+	}
+	return dropped
+}
+
+// OpsSince returns the ops after revision. ok is false when some of them
+// are no longer in the history and the caller needs a full snapshot.
+func (doc *Document) OpsSince(revision int64) (ops []Op, ok bool) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+
+	if revision >= doc.revision {
+		return nil, true
+	}
+	if len(doc.history) == 0 || doc.history[0].Revision > revision+1 {
+		return nil, false
+	}
+	start := int(revision + 1 - doc.history[0].Revision)
+	return append([]Op(nil), doc.history[start:]...), true
+}
+
+// OpsBetween returns the ops from revision from through to, which must all
+// still be in the history
+func (doc *Document) OpsBetween(from int64, to int64) ([]Op, error) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+
+	if from < 1 || from > to {
+		return nil, ErrInvalidRange
+	}
+	if to > doc.revision {
+		return nil, ErrRevisionInTheFuture
+	}
+	if len(doc.history) == 0 || doc.history[0].Revision > from {
+		return nil, ErrRevisionUnavailable
+	}
+	start := from - doc.history[0].Revision
+	return slices.Clone(doc.history[start : start+to-from+1]), nil
+}
+
+// How long a single load or save may take
+const storeTimeout = 10 * time.Second
+
+// Registry holds the documents loaded in memory. With a store, documents
+// nobody holds open are saved and unloaded by Evict and loaded again on
+// next use.
+type Registry struct {
+	mutex     sync.Mutex
+	documents map[string]*Document
+	store     Store
+	waker     Waker
+
+	saveListener   SaveListener
+	createListener func(id string)
+	limits         Limits
+
+	// Clients holding each document open, when each was last used, and
+	// the documents being loaded or saved, which others wait for
+	holders  map[string]int
+	lastUsed map[string]time.Time
+	pending  map[string]chan struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		documents: make(map[string]*Document),
+		holders:   make(map[string]int),
+		lastUsed:  make(map[string]time.Time),
+		pending:   make(map[string]chan struct{}),
+	}
+}
+
+// SetStore makes the registry load documents from store and enables
+// eviction. It must be called before any document is opened.
+func (registry *Registry) SetStore(store Store) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.store = store
+}
+
+// SetCreateListener installs the function told when a document is created
+// on first use. It is called with the registry locked and must not use
+// it. Like SetStore it must be called before any document is opened.
+func (registry *Registry) SetCreateListener(listener func(id string)) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.createListener = listener
+}
+
+// Open returns the document with the given ID, loading it from the store
+// or creating an empty one on first use
+func (registry *Registry) Open(id string) (*Document, error) {
+	return registry.open(id, true, false)
+}
+
+// Lookup returns the document with the given ID if it is loaded or saved,
+// or ErrNotFound
+func (registry *Registry) Lookup(id string) (*Document, error) {
+	return registry.open(id, false, false)
+}
+
+// Acquire opens a document and keeps it loaded until a matching Release
+func (registry *Registry) Acquire(id string) (*Document, error) {
+	return registry.open(id, true, true)
+}
+
+// Release lets a document be evicted once nobody else holds it and it has
+// been idle long enough
+func (registry *Registry) Release(id string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.holders[id]--
+	if registry.holders[id] <= 0 {
+		delete(registry.holders, id)
+	}
+	registry.lastUsed[id] = time.Now()
+}
+
+func (registry *Registry) open(id string, create bool, hold bool) (*Document, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	for {
+		if doc, ok := registry.documents[id]; ok {
+			registry.use(id, hold)
+			return doc, nil
+		}
+		pending, busy := registry.pending[id]
+		if !busy {
+			break
+		}
+		registry.mutex.Unlock()
+		<-pending
+		registry.mutex.Lock()
+	}
+
+	if registry.store == nil {
+		if !create {
+			return nil, ErrNotFound
+		}
+		doc := New(id)
+		registry.limit(doc)
+		registry.documents[id] = doc
+		registry.use(id, hold)
+		registry.created(id)
+		return doc, nil
+	}
+
+	// Load without holding the registry, other documents stay usable
+	pending := make(chan struct{})
+	registry.pending[id] = pending
+	listener := registry.saveListener
+	registry.mutex.Unlock()
+	doc, created, err := registry.load(id, create, listener)
+	registry.mutex.Lock()
+	delete(registry.pending, id)
+	close(pending)
+
+	if err != nil {
+		return nil, err
+	}
+	registry.limit(doc)
+	registry.documents[id] = doc
+	registry.use(id, hold)
+	if created {
+		registry.created(id)
+	}
+	return doc, nil
+}
+
+// created tells the create listener about a new document. It must be
+// called with the registry locked.
+func (registry *Registry) created(id string) {
+	if registry.createListener != nil {
+		registry.createListener(id)
+	}
+}
+
+func (registry *Registry) use(id string, hold bool) {
+	if hold {
+		registry.holders[id]++
+	}
+	registry.lastUsed[id] = time.Now()
+}
+
+// load reads a document from the store, creating it when it was never
+// saved and create is set
+func (registry *Registry) load(id string, create bool, listener SaveListener) (doc *Document, created bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	record, err := registry.store.Load(ctx, id)
+	if errors.Is(err, ErrNotFound) && create {
+		doc := New(id)
+		doc.followSaves(listener, nil)
+		return doc, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	record.ID = id
+	doc, err = FromRecord(record)
+	if err != nil {
+		return nil, false, err
+	}
+	doc.followSaves(listener, &record)
+	if len(record.Hibernated) > 0 && registry.waker != nil {
+		registry.waker(id, record.Hibernated)
+	}
+	return doc, false, nil
+}
+
+// Evict saves and unloads the documents nobody holds that have been
+// neither used nor changed for idle. Documents that fail to save stay
+// loaded and are retried on the next call.
+func (registry *Registry) Evict(idle time.Duration) (unloaded []string, err error) {
+	registry.mutex.Lock()
+	if registry.store == nil {
+		registry.mutex.Unlock()
+		return nil, nil
+	}
+	var evicting []*Document
+	for id, doc := range registry.documents {
+		if registry.holders[id] > 0 || time.Since(registry.lastUsed[id]) < idle || time.Since(doc.UpdatedAt()) < idle {
+			continue
+		}
+		// Anyone opening it meanwhile waits for the save and loads it back
+		delete(registry.documents, id)
+		registry.pending[id] = make(chan struct{})
+		evicting = append(evicting, doc)
+	}
+	registry.mutex.Unlock()
+
+	var errs []error
+	for _, doc := range evicting {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		saveErr := registry.store.Save(ctx, doc.Record())
+		cancel()
+
+		registry.mutex.Lock()
+		if saveErr != nil {
+			errs = append(errs, fmt.Errorf("saving document %q: %w", doc.ID, saveErr))
+			registry.documents[doc.ID] = doc
+		} else {
+			delete(registry.lastUsed, doc.ID)
+			unloaded = append(unloaded, doc.ID)
+		}
+		close(registry.pending[doc.ID])
+		delete(registry.pending, doc.ID)
+		registry.mutex.Unlock()
+	}
+	return unloaded, errors.Join(errs...)
+}
+
+// Install puts a document handed over by another node in place of any copy
+// loaded here, saving it first when there is a store so the handover
+// survives a restart. It fails with ErrInUse when the copy here is held
+// open or being loaded or saved.
+func (registry *Registry) Install(record Record) (*Document, error) {
+	doc, err := FromRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, pending := registry.pending[record.ID]; pending || registry.holders[record.ID] > 0 {
+		return nil, ErrInUse
+	}
+	if registry.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := registry.store.Save(ctx, record); err != nil {
+			return nil, fmt.Errorf("saving document %q: %w", record.ID, err)
+		}
+		doc.followSaves(registry.saveListener, &record)
+	}
+	registry.limit(doc)
+	registry.documents[record.ID] = doc
+	registry.lastUsed[record.ID] = time.Now()
+	return doc, nil
+}
+
+// Drop unloads a document without saving it, once another node has taken
+// it over and its copy is the one that counts
+func (registry *Registry) Drop(id string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	delete(registry.documents, id)
+	delete(registry.lastUsed, id)
+}
+
+// All returns the documents currently loaded
+func (registry *Registry) All() []*Document {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	documents := make([]*Document, 0, len(registry.documents))
+	for _, doc := range registry.documents {
+		documents = append(documents, doc)
+	}
+	return documents
+}
+
+// Compact compacts the op log of every loaded document and returns how
+// many documents lost ops and how many ops were dropped in total
+func (registry *Registry) Compact(retention Retention) (documents int, ops int) {
+	for _, doc := range registry.All() {
+		if dropped := doc.Compact(retention); dropped > 0 {
+			documents++
+			ops += dropped
+		}
+	}
+	return documents, ops
+}
+
+// List summarizes every document, the loaded ones as they are in memory
+// and, when the store can list its documents, the saved ones too. Most
+// recently updated documents come first.
+func (registry *Registry) List(ctx context.Context) ([]Summary, error) {
+	registry.mutex.Lock()
+	lister, _ := registry.store.(Lister)
+	registry.mutex.Unlock()
+
+	summaries := make(map[string]Summary)
+	if lister != nil {
+		records, err := lister.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			summaries[record.ID] = Summary{ID: record.ID, Tenant: record.Tenant, Metadata: record.Metadata, Revision: record.Revision, UpdatedAt: record.UpdatedAt, TrashedAt: record.TrashedAt}
+		}
+	}
+	for _, doc := range registry.All() {
+		summaries[doc.ID] = doc.Summary()
+	}
+
+	list := slices.Collect(maps.Values(summaries))
+	slices.SortFunc(list, func(a, b Summary) int {
+		if c := b.UpdatedAt.Compare(a.UpdatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return list, nil
+}
+
+// Records returns every document in full, the loaded ones as they are in
+// memory and, when the store can list its documents, the saved ones too,
+// ordered by ID
+func (registry *Registry) Records(ctx context.Context) ([]Record, error) {
+	registry.mutex.Lock()
+	lister, _ := registry.store.(Lister)
+	registry.mutex.Unlock()
+
+	records := make(map[string]Record)
+	if lister != nil {
+		saved, err := lister.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range saved {
+			records[record.ID] = record
+		}
+	}
+	for _, doc := range registry.All() {
+		records[doc.ID] = doc.Record()
+	}
+
+	list := slices.Collect(maps.Values(records))
+	slices.SortFunc(list, func(a, b Record) int { return strings.Compare(a.ID, b.ID) })
+	return list, nil
+}
@@ -0,0 +1,70 @@
+package document
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Waker receives the state flushed into a hibernated document's record
+// when the document is loaded again, before anyone can use it
+type Waker func(id string, state json.RawMessage)
+
+// SetWaker installs the function that restores hibernated state. Like
+// SetStore it must be called before any document is opened.
+func (registry *Registry) SetWaker(waker Waker) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.waker = waker
+}
+
+// Hibernate flushes state kept outside an unloaded document into its saved
+// record. flush returns that state and release drops it from memory once
+// it is saved; both run while anyone opening the document waits, so
+// nothing is lost or restored twice. It fails with ErrInUse when the
+// document is loaded or being loaded or saved, and with ErrNotFound when
+// it was never saved.
+func (registry *Registry) Hibernate(id string, flush func() (json.RawMessage, error), release func()) error {
+	registry.mutex.Lock()
+	if registry.store == nil {
+		registry.mutex.Unlock()
+		return ErrNotFound
+	}
+	if _, loaded := registry.documents[id]; loaded {
+		registry.mutex.Unlock()
+		return ErrInUse
+	}
+	if _, pending := registry.pending[id]; pending {
+		registry.mutex.Unlock()
+		return ErrInUse
+	}
+	pending := make(chan struct{})
+	registry.pending[id] = pending
+	registry.mutex.Unlock()
+
+	defer func() {
+		registry.mutex.Lock()
+		delete(registry.pending, id)
+		close(pending)
+		registry.mutex.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	record, err := registry.store.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	state, err := flush()
+	if err != nil {
+		return err
+	}
+	record.ID = id
+	record.Hibernated = state
+	if err := registry.store.Save(ctx, record); err != nil {
+		return fmt.Errorf("saving document %q: %w", id, err)
+	}
+	release()
+	return nil
+}
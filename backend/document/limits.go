@@ -0,0 +1,60 @@
+package document
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"backend/config"
+	"backend/ratelimit"
+)
+
+var (
+	// ErrTooLarge rejects an edit that would take a document past its
+	// size limit
+	ErrTooLarge = errors.New("document would exceed its size limit")
+	// ErrTooManyOps rejects an edit to a document taking edits faster than
+	// its limit
+	ErrTooManyOps = errors.New("document is taking edits faster than allowed")
+)
+
+// Limits bound a document's text, in characters, and the edits it takes
+// from everyone together. Zero values are unlimited.
+type Limits struct {
+	MaxSize int
+	Ops     config.RateLimit
+}
+
+// SetLimits applies limits to every document opened afterwards. Like
+// SetStore it must be called before any document is opened.
+func (registry *Registry) SetLimits(limits Limits) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.limits = limits
+}
+
+// limit applies the registry's limits to a document before anyone else
+// can use it
+func (registry *Registry) limit(doc *Document) {
+	doc.maxSize = registry.limits.MaxSize
+	if registry.limits.Ops.Rate > 0 {
+		doc.ops = ratelimit.NewBucket(registry.limits.Ops, time.Now())
+	}
+}
+
+// checkLimits fails an edit leaving text that is over the size limit, or
+// that comes too soon after the edits before it. Edits that shrink a
+// document already over the limit are let through, so it can be brought
+// back under.
+func (doc *Document) checkLimits(text string) error {
+	if doc.maxSize > 0 {
+		size := utf8.RuneCountInString(text)
+		if size > doc.maxSize && size > utf8.RuneCountInString(doc.text) {
+			return ErrTooLarge
+		}
+	}
+	if doc.ops != nil && !doc.ops.Allow(time.Now()) {
+		return ErrTooManyOps
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package document
+
+import (
+	"backend/richtext"
+)
+
+// Merge applies normalized changes made one after another on top of
+// revision base, such as edits made offline, transforming them past the
+// ops applied since. Where both insert at the same place the ops already
+// applied come first. Each change becomes an op of its own, and either
+// all of them are applied or none; the changes are returned as applied.
+func (doc *Document) Merge(author string, base int64, changes []richtext.Delta, payload Payload) ([]Op, []richtext.Delta, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if doc.frozen {
+		return nil, nil, ErrFrozen
+	}
+	if !doc.canEdit(author) {
+		return nil, nil, ErrEditRestricted
+	}
+	if base > doc.revision {
+		return nil, nil, ErrRevisionInTheFuture
+	}
+	var since []richtext.Delta
+	if base < doc.revision {
+		if len(doc.history) == 0 || doc.history[0].Revision > base+1 {
+			return nil, nil, ErrRevisionUnavailable
+		}
+		for _, op := range doc.history[base+1-doc.history[0].Revision:] {
+			if !op.hasText() {
+				return nil, nil, ErrRevisionUnavailable
+			}
+			since = append(since, op.change())
+		}
+	}
+
+	// Compose everything first so a change that doesn't fit fails the
+	// whole merge
+	transformed := make([]richtext.Delta, len(changes))
+	contents := make([]richtext.Delta, len(changes))
+	content := doc.content
+	for i, change := range changes {
+		for j, op := range since {
+			change, since[j] = richtext.Transform(op, change, true), richtext.Transform(change, op, false)
+		}
+		var err error
+		if content, err = richtext.Compose(content, change); err != nil {
+			return nil, nil, err
+		}
+		transformed[i], contents[i] = change, content
+	}
+
+	if len(contents) > 0 {
+		if err := doc.checkLimits(contents[len(contents)-1].Text()); err != nil {
+			return nil, nil, err
+		}
+	}
+	ops := make([]Op, len(contents))
+	for i, content := range contents {
+		ops[i] = doc.applyTracked(author, content, payload)
+	}
+	return ops, transformed, nil
+}
+
+// change returns the op's edit as a change to the plain text
+func (op Op) change() richtext.Delta {
+	var change richtext.Delta
+	if op.Edit.Pos > 0 {
+		change = append(change, richtext.Op{Retain: op.Edit.Pos})
+	}
+	if op.Edit.Deleted > 0 {
+		change = append(change, richtext.Op{Delete: op.Edit.Deleted})
+	}
+	if op.Inserted != "" {
+		change = append(change, richtext.Op{Insert: op.Inserted})
+	}
+	return change
+}
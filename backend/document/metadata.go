@@ -0,0 +1,184 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"backend/richtext"
+	"backend/sanitize"
+)
+
+var ErrInvalidMetadata = errors.New("invalid document metadata")
+
+// Limits on the descriptive metadata, in characters
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 2000
+	MaxTags              = 20
+	MaxTagLength         = 50
+)
+
+// Shape of a BCP 47 language tag, such as "en", "ar-EG" or "zh-Hant-TW"
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// Metadata describes the document as a whole. Language is a BCP 47 tag,
+// empty when unknown. Direction is the base direction of the lines that
+// don't set one of their own. Title, Description and Tags are edited
+// separately from the content and shown in document lists.
+// TitleSuggested marks a title taken from the content rather than given.
+type Metadata struct {
+	Language       string   `json:"language"`
+	Direction      string   `json:"direction"`
+	Title          string   `json:"title,omitempty"`
+	TitleSuggested bool     `json:"titleSuggested,omitempty"`
+	Description    string   `json:"description,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+}
+
+func (metadata Metadata) Validate() error {
+	if metadata.Language != "" && !languageTag.MatchString(metadata.Language) {
+		return fmt.Errorf("%w: language must be a BCP 47 tag such as en or ar-EG", ErrInvalidMetadata)
+	}
+	if metadata.Direction != "ltr" && metadata.Direction != "rtl" {
+		return fmt.Errorf("%w: direction must be ltr or rtl", ErrInvalidMetadata)
+	}
+	if utf8.RuneCountInString(metadata.Title) > MaxTitleLength || sanitize.HasControl(metadata.Title, false) {
+		return fmt.Errorf("%w: title must be a single line of at most %d characters", ErrInvalidMetadata, MaxTitleLength)
+	}
+	if utf8.RuneCountInString(metadata.Description) > MaxDescriptionLength || sanitize.HasControl(metadata.Description, true) {
+		return fmt.Errorf("%w: description must be text of at most %d characters", ErrInvalidMetadata, MaxDescriptionLength)
+	}
+	if len(metadata.Tags) > MaxTags {
+		return fmt.Errorf("%w: a document has at most %d tags", ErrInvalidMetadata, MaxTags)
+	}
+	for _, tag := range metadata.Tags {
+		if tag == "" || utf8.RuneCountInString(tag) > MaxTagLength || sanitize.HasControl(tag, false) {
+			return fmt.Errorf("%w: tags must be single lines of 1 to %d characters", ErrInvalidMetadata, MaxTagLength)
+		}
+	}
+	return nil
+}
+
+// MetadataUpdate changes the fields of a document's metadata that are set
+// and leaves the others as they are
+type MetadataUpdate struct {
+	Language    *string   `json:"language"`
+	Direction   *string   `json:"direction"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+}
+
+// normalizedTags trims tags and drops empty and duplicate ones, comparing
+// case-insensitively and keeping the first spelling
+func normalizedTags(tags []string) []string {
+	var out []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.ContainsFunc(out, func(kept string) bool { return strings.EqualFold(kept, tag) }) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+func (doc *Document) Metadata() Metadata {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.metadata
+}
+
+// UpdateMetadata applies an update to the document's metadata field by
+// field, the last update of a field reaching the server winning, so
+// concurrent edits of different fields all take effect. announce runs while
+// the document is locked so changes reach everyone in the order they were
+// made.
+func (doc *Document) UpdateMetadata(update MetadataUpdate, announce func(Metadata)) (Metadata, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	metadata := doc.metadata
+	if update.Language != nil {
+		metadata.Language = *update.Language
+	}
+	if update.Direction != nil {
+		metadata.Direction = *update.Direction
+	}
+	if update.Title != nil {
+		metadata.Title = strings.TrimSpace(*update.Title)
+		metadata.TitleSuggested = false
+	}
+	if update.Description != nil {
+		metadata.Description = strings.TrimSpace(*update.Description)
+	}
+	if update.Tags != nil {
+		metadata.Tags = normalizedTags(*update.Tags)
+	}
+	if err := metadata.Validate(); err != nil {
+		return Metadata{}, err
+	}
+
+	doc.metadata = metadata
+	doc.changed(time.Now())
+	announce(metadata)
+	return metadata, nil
+}
+
+// Longest title suggested from the content, in characters
+const maxSuggestedTitle = 80
+
+// SuggestTitle titles a document after its first heading, or its first
+// line of text when it has none, unless someone gave it a title. Suggested
+// titles follow the content until then. It reports false, changing
+// nothing, when there is no new title to suggest; announce runs while the
+// document is locked, like in UpdateMetadata.
+func (doc *Document) SuggestTitle(announce func(Metadata)) (Metadata, bool) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if (doc.metadata.Title != "" && !doc.metadata.TitleSuggested) || doc.titledAt == doc.revision {
+		return Metadata{}, false
+	}
+	doc.titledAt = doc.revision
+	title := strings.Join(strings.FieldsFunc(richtext.Headline(doc.content), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}), " ")
+	if runes := []rune(title); len(runes) > maxSuggestedTitle {
+		title = string(runes[:maxSuggestedTitle])
+		if i := strings.LastIndexByte(title, ' '); i > 0 {
+			title = title[:i]
+		}
+		title += "…"
+	}
+	if title == "" || title == doc.metadata.Title {
+		return Metadata{}, false
+	}
+
+	doc.metadata.Title = title
+	doc.metadata.TitleSuggested = true
+	doc.changed(time.Now())
+	announce(doc.metadata)
+	return doc.metadata, true
+}
+
+// Summary describes a document in lists
+type Summary struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Metadata  Metadata  `json:"metadata"`
+	Revision  int64     `json:"revision"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	TrashedAt time.Time `json:"trashedAt,omitzero"`
+}
+
+func (doc *Document) Summary() Summary {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return Summary{ID: doc.ID, Tenant: doc.tenant, Metadata: doc.metadata, Revision: doc.revision, UpdatedAt: doc.updatedAt, TrashedAt: doc.trashedAt}
+}
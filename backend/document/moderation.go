@@ -0,0 +1,112 @@
+package document
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Moderation actions. A kick only ends the user's connections; bans and
+// mutes are kept with the document until they run out or are lifted.
+const (
+	ModerationKick   = "kick"
+	ModerationBan    = "ban"
+	ModerationUnban  = "unban"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+)
+
+var (
+	ErrNotModerator      = errors.New("only the document's owner can moderate it")
+	ErrModeratingOwner   = errors.New("the document's owner can't be moderated")
+	ErrInvalidModeration = errors.New("action must be kick, ban, unban, mute or unmute")
+)
+
+// Restriction bans a user from a document or mutes them in it until
+// Until, or until lifted when Until is zero
+type Restriction struct {
+	UserID string    `json:"userId"`
+	Until  time.Time `json:"until,omitzero"`
+}
+
+// Moderate applies action to userID on behalf of moderator, who must be
+// the owner. until ends a ban or mute, zero lasting until it is lifted.
+func (doc *Document) Moderate(moderator string, action string, userID string, until time.Time) error {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if moderator == "" || moderator != doc.permissions.Owner {
+		return ErrNotModerator
+	}
+	if userID == doc.permissions.Owner {
+		return ErrModeratingOwner
+	}
+	switch action {
+	case ModerationKick:
+		return nil
+	case ModerationBan:
+		doc.bans[userID] = until
+	case ModerationUnban:
+		delete(doc.bans, userID)
+	case ModerationMute:
+		doc.mutes[userID] = until
+	case ModerationUnmute:
+		delete(doc.mutes, userID)
+	default:
+		return ErrInvalidModeration
+	}
+	doc.changed(time.Now())
+	return nil
+}
+
+// Banned reports whether userID is banned from the document and until
+// when, zero for until lifted
+func (doc *Document) Banned(userID string) (time.Time, bool) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return restricted(doc.bans, userID)
+}
+
+// Muted reports whether userID is muted in the document
+func (doc *Document) Muted(userID string) bool {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	_, muted := restricted(doc.mutes, userID)
+	return muted
+}
+
+// Restrictions returns the bans and mutes in force, by user ID
+func (doc *Document) Restrictions() (bans []Restriction, mutes []Restriction) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return restrictions(doc.bans), restrictions(doc.mutes)
+}
+
+func restricted(until map[string]time.Time, userID string) (time.Time, bool) {
+	end, ok := until[userID]
+	if !ok || (!end.IsZero() && !time.Now().Before(end)) {
+		return time.Time{}, false
+	}
+	return end, true
+}
+
+// restrictions lists the entries of until still in force, which are also
+// all that is saved
+func restrictions(until map[string]time.Time) []Restriction {
+	var list []Restriction
+	for _, userID := range slices.Sorted(maps.Keys(until)) {
+		if end, ok := restricted(until, userID); ok {
+			list = append(list, Restriction{UserID: userID, Until: end})
+		}
+	}
+	return list
+}
+
+func restrictionMap(list []Restriction) map[string]time.Time {
+	until := make(map[string]time.Time, len(list))
+	for _, restriction := range list {
+		until[restriction.UserID] = restriction.Until
+	}
+	return until
+}
@@ -0,0 +1,176 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Who may export or download a document
+const (
+	ExportOwners  = "owners"
+	ExportEditors = "editors"
+	ExportAnyone  = "anyone"
+)
+
+// How a document may be edited. In locked and suggesting mode only the
+// owner edits; in suggesting mode everyone else's edits become suggestions
+// the owner accepts or rejects.
+const (
+	ModeEditing    = "editing"
+	ModeLocked     = "locked"
+	ModeSuggesting = "suggesting"
+)
+
+var (
+	ErrInvalidPermissions = errors.New("invalid document permissions")
+	ErrNotOwner           = errors.New("only the document's owner can change its permissions")
+	ErrEditRestricted     = errors.New("only the document's owner can edit it in its current mode")
+)
+
+// Permissions are the access settings of a document. Owner is the user who
+// first opened it; Export is one of the Export constants and Mode one of
+// the Mode constants, empty meaning editing for documents saved before
+// modes existed. TrackChanges records edits as changes for the owner to
+// review. Analysis runs the text through the language service.
+type Permissions struct {
+	Owner        string `json:"owner"`
+	Export       string `json:"export"`
+	Mode         string `json:"mode,omitempty"`
+	TrackChanges bool   `json:"trackChanges,omitempty"`
+	Analysis     bool   `json:"analysis,omitempty"`
+}
+
+// Capabilities are what a given user may do with a document. Suggest is
+// set for users whose edits become suggestions.
+type Capabilities struct {
+	Owner        bool   `json:"owner"`
+	Export       bool   `json:"export"`
+	ExportPolicy string `json:"exportPolicy"`
+	Edit         bool   `json:"edit"`
+	Suggest      bool   `json:"suggest"`
+	Mode         string `json:"mode"`
+	TrackChanges bool   `json:"trackChanges"`
+	Analysis     bool   `json:"analysis"`
+}
+
+func (doc *Document) Permissions() Permissions {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.permissions
+}
+
+// Editors returns the users who have joined the document, sorted
+func (doc *Document) Editors() []string {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return slices.Sorted(maps.Keys(doc.editors))
+}
+
+// Join records userID as an editor, and as the owner of a document that
+// has none yet
+func (doc *Document) Join(userID string) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if doc.permissions.Owner == "" {
+		doc.permissions.Owner = userID
+	}
+	doc.editors[userID] = true
+}
+
+// SetExportPolicy changes who may export the document on behalf of userID,
+// who must be its owner
+func (doc *Document) SetExportPolicy(userID string, policy string) error {
+	if !slices.Contains([]string{ExportOwners, ExportEditors, ExportAnyone}, policy) {
+		return fmt.Errorf("%w: export must be owners, editors or anyone", ErrInvalidPermissions)
+	}
+
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if userID == "" || userID != doc.permissions.Owner {
+		return ErrNotOwner
+	}
+	doc.permissions.Export = policy
+	doc.changed(time.Now())
+	return nil
+}
+
+// SetMode locks the document, opens it to suggestions or back to editing on
+// behalf of userID, who must be its owner. Pending suggestions are kept
+// whatever the mode.
+func (doc *Document) SetMode(userID string, mode string) error {
+	if !slices.Contains([]string{ModeEditing, ModeLocked, ModeSuggesting}, mode) {
+		return fmt.Errorf("%w: mode must be editing, locked or suggesting", ErrInvalidPermissions)
+	}
+
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if userID == "" || userID != doc.permissions.Owner {
+		return ErrNotOwner
+	}
+	doc.permissions.Mode = mode
+	doc.changed(time.Now())
+	return nil
+}
+
+// SetAnalysis turns analysis of the text by the language service on or off
+// on behalf of userID, who must be the owner
+func (doc *Document) SetAnalysis(userID string, enabled bool) error {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if userID == "" || userID != doc.permissions.Owner {
+		return ErrNotOwner
+	}
+	doc.permissions.Analysis = enabled
+	doc.changed(time.Now())
+	return nil
+}
+
+// mode returns the document's mode, with the lock held
+func (doc *Document) mode() string {
+	if doc.permissions.Mode == "" {
+		return ModeEditing
+	}
+	return doc.permissions.Mode
+}
+
+// canEdit reports whether userID may change the content directly, with the
+// lock held
+func (doc *Document) canEdit(userID string) bool {
+	return doc.mode() == ModeEditing || (userID != "" && userID == doc.permissions.Owner)
+}
+
+// Capabilities returns what userID may do, an empty userID standing for
+// someone without a session
+func (doc *Document) Capabilities(userID string) Capabilities {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+
+	owner := userID != "" && userID == doc.permissions.Owner
+	var export bool
+	switch doc.permissions.Export {
+	case ExportOwners:
+		export = owner
+	case ExportEditors:
+		export = owner || (userID != "" && doc.editors[userID])
+	default:
+		export = true
+	}
+	edit := doc.canEdit(userID)
+	return Capabilities{
+		Owner:        owner,
+		Export:       export,
+		ExportPolicy: doc.permissions.Export,
+		Edit:         edit,
+		Suggest:      !edit && doc.mode() == ModeSuggesting,
+		Mode:         doc.mode(),
+		TrackChanges: doc.permissions.TrackChanges,
+		Analysis:     doc.permissions.Analysis,
+	}
+}
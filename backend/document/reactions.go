@@ -0,0 +1,134 @@
+package document
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"backend/ids"
+)
+
+// MaxReactionEmoji caps the different emoji on one range
+const MaxReactionEmoji = 20
+
+var (
+	ErrReactionNotFound = errors.New("reaction not found")
+	ErrTooManyEmoji     = errors.New("this text has as many different reactions as it can take")
+)
+
+// Reaction is the emoji reactions on one range of the document: for each
+// emoji, the users who reacted with it. Range moves along with edits like
+// an anchor; reactions on text that is deleted entirely go with it.
+type Reaction struct {
+	ID    string              `json:"id"`
+	Range Range               `json:"range"`
+	Users map[string][]string `json:"users"`
+}
+
+// Counts returns how many users reacted with each emoji
+func (reaction Reaction) Counts() map[string]int {
+	counts := make(map[string]int, len(reaction.Users))
+	for emoji, users := range reaction.Users {
+		counts[emoji] = len(users)
+	}
+	return counts
+}
+
+func (reaction Reaction) clone() Reaction {
+	reaction.Users = maps.Clone(reaction.Users)
+	for emoji, users := range reaction.Users {
+		reaction.Users[emoji] = slices.Clone(users)
+	}
+	return reaction
+}
+
+// AddReaction records userID reacting with emoji to a non-empty range of
+// the content as it was at revision. Reactions to the same range are
+// gathered in one Reaction, which is returned.
+func (doc *Document) AddReaction(userID string, revision int64, r Range, emoji string) (Reaction, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if revision > doc.revision {
+		return Reaction{}, ErrRevisionInTheFuture
+	}
+	if r.Start < 0 || r.End <= r.Start {
+		return Reaction{}, ErrInvalidRange
+	}
+	if revision < doc.revision {
+		if len(doc.history) == 0 || doc.history[0].Revision > revision+1 {
+			return Reaction{}, ErrRevisionUnavailable
+		}
+		for _, op := range doc.history[revision+1-doc.history[0].Revision:] {
+			r = op.Edit.TransformRange(r)
+		}
+	}
+	if r.End <= r.Start || r.End > utf8.RuneCountInString(doc.text) {
+		return Reaction{}, ErrInvalidRange
+	}
+
+	i := slices.IndexFunc(doc.reactions, func(reaction Reaction) bool { return reaction.Range == r })
+	if i < 0 {
+		doc.reactions = append(doc.reactions, Reaction{ID: ids.NewUUID(), Range: r, Users: make(map[string][]string)})
+		i = len(doc.reactions) - 1
+	}
+	reaction := &doc.reactions[i]
+	if _, ok := reaction.Users[emoji]; !ok && len(reaction.Users) >= MaxReactionEmoji {
+		return Reaction{}, ErrTooManyEmoji
+	}
+	if !slices.Contains(reaction.Users[emoji], userID) {
+		reaction.Users[emoji] = append(reaction.Users[emoji], userID)
+		doc.changed(time.Now())
+	}
+	return reaction.clone(), nil
+}
+
+// RemoveReaction takes back userID's emoji reaction from the reaction id
+// and returns what is left of it, which has no users once the last one is
+// taken back
+func (doc *Document) RemoveReaction(userID string, id string, emoji string) (Reaction, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	i := slices.IndexFunc(doc.reactions, func(reaction Reaction) bool { return reaction.ID == id })
+	if i < 0 {
+		return Reaction{}, ErrReactionNotFound
+	}
+	reaction := &doc.reactions[i]
+	users := reaction.Users[emoji]
+	j := slices.Index(users, userID)
+	if j < 0 {
+		return Reaction{}, ErrReactionNotFound
+	}
+	reaction.Users[emoji] = slices.Delete(users, j, j+1)
+	if len(reaction.Users[emoji]) == 0 {
+		delete(reaction.Users, emoji)
+	}
+	removed := reaction.clone()
+	if len(reaction.Users) == 0 {
+		doc.reactions = slices.Delete(doc.reactions, i, i+1)
+	}
+	doc.changed(time.Now())
+	return removed, nil
+}
+
+// Reactions returns the reactions on the document, in the order their
+// ranges were first reacted to
+func (doc *Document) Reactions() []Reaction {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return cloneReactions(doc.reactions)
+}
+
+func cloneReactions(reactions []Reaction) []Reaction {
+	if reactions == nil {
+		return nil
+	}
+	clones := make([]Reaction, len(reactions))
+	for i, reaction := range reactions {
+		clones[i] = reaction.clone()
+	}
+	return clones
+}
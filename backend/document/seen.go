@@ -0,0 +1,64 @@
+package document
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// SeenMarker is the latest revision a user has received of a document and
+// when they did
+type SeenMarker struct {
+	UserID   string    `json:"userId"`
+	Revision int64     `json:"revision"`
+	At       time.Time `json:"at"`
+}
+
+// MarkSeen records that userID has received revision, and reports whether
+// it moved their marker forward. Markers are saved with the document's
+// next change rather than making it dirty, so viewing a document doesn't
+// count as changing it.
+func (doc *Document) MarkSeen(userID string, revision int64) bool {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if userID == "" || revision > doc.revision {
+		return false
+	}
+	if marker, ok := doc.seen[userID]; ok && marker.Revision >= revision {
+		return false
+	}
+	doc.seen[userID] = SeenMarker{UserID: userID, Revision: revision, At: time.Now().UTC()}
+	return true
+}
+
+// SeenBy returns how far userID has seen the document, and false if they
+// never opened it
+func (doc *Document) SeenBy(userID string) (SeenMarker, bool) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	marker, ok := doc.seen[userID]
+	return marker, ok
+}
+
+// Seen returns every user's marker, by user ID
+func (doc *Document) Seen() []SeenMarker {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return seenMarkers(doc.seen)
+}
+
+// Unread is how many revisions userID hasn't seen yet
+func (doc *Document) Unread(userID string) int64 {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.revision - doc.seen[userID].Revision
+}
+
+func seenMarkers(seen map[string]SeenMarker) []SeenMarker {
+	markers := make([]SeenMarker, 0, len(seen))
+	for _, userID := range slices.Sorted(maps.Keys(seen)) {
+		markers = append(markers, seen[userID])
+	}
+	return markers
+}
@@ -0,0 +1,168 @@
+package document
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"backend/richtext"
+)
+
+// ErrNotFound is returned for a document that was never saved
+var ErrNotFound = errors.New("document not found")
+
+// Store persists documents while they are unloaded from memory
+type Store interface {
+	// Load returns the saved document, or ErrNotFound
+	Load(ctx context.Context, id string) (Record, error)
+	Save(ctx context.Context, record Record) error
+}
+
+// Lister is a Store that can also return every document it holds
+type Lister interface {
+	List(ctx context.Context) ([]Record, error)
+}
+
+// Deleter is a Store that can also delete a document for good
+type Deleter interface {
+	Delete(ctx context.Context, id string) error
+}
+
+// Record is the saved form of a document, op log included so clients can
+// still be replayed what they missed after a reload
+type Record struct {
+	ID          string           `json:"id"`
+	Revision    int64            `json:"revision"`
+	Content     richtext.Delta   `json:"content"`
+	Metadata    Metadata         `json:"metadata"`
+	Permissions Permissions      `json:"permissions"`
+	Editors     []string         `json:"editors,omitempty"`
+	Anchors     map[string]Range `json:"anchors,omitempty"`
+	Suggestions []Suggestion     `json:"suggestions,omitempty"`
+	Changes     []TrackedChange  `json:"changes,omitempty"`
+	Reactions   []Reaction       `json:"reactions,omitempty"`
+	Attribution []AuthorSpan     `json:"attribution,omitempty"`
+	Bans        []Restriction    `json:"bans,omitempty"`
+	Mutes       []Restriction    `json:"mutes,omitempty"`
+	Seen        []SeenMarker     `json:"seen,omitempty"`
+	Tenant      string           `json:"tenant,omitempty"`
+	TrashedAt   time.Time        `json:"trashedAt,omitzero"`
+	Ops         []Op             `json:"ops,omitempty"`
+	UpdatedAt   time.Time        `json:"updatedAt"`
+
+	// Hibernated is room state kept outside the document, such as chat,
+	// flushed here while the document hibernates and handed to the waker
+	// when it is next loaded
+	Hibernated json.RawMessage `json:"hibernated,omitempty"`
+}
+
+func (doc *Document) Record() Record {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.record()
+}
+
+// Freeze stops content changes and returns the document as it stands, so
+// nothing is lost between the handover and the clients moving
+func (doc *Document) Freeze() Record {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	doc.frozen = true
+	return doc.record()
+}
+
+// Thaw lets content change again after a handover that failed
+func (doc *Document) Thaw() {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+	doc.frozen = false
+}
+
+// record must be called with the lock held
+func (doc *Document) record() Record {
+	return Record{
+		ID:          doc.ID,
+		Revision:    doc.revision,
+		Content:     doc.content,
+		Metadata:    doc.metadata,
+		Permissions: doc.permissions,
+		Editors:     slices.Sorted(maps.Keys(doc.editors)),
+		Anchors:     maps.Clone(doc.anchors),
+		Suggestions: slices.Clone(doc.suggestions),
+		Changes:     slices.Clone(doc.changes),
+		Reactions:   cloneReactions(doc.reactions),
+		Attribution: slices.Clone(doc.authors),
+		Bans:        restrictions(doc.bans),
+		Mutes:       restrictions(doc.mutes),
+		Seen:        seenMarkers(doc.seen),
+		Tenant:      doc.tenant,
+		TrashedAt:   doc.trashedAt,
+		Ops:         slices.Clone(doc.history),
+		UpdatedAt:   doc.updatedAt,
+	}
+}
+
+// FromRecord rebuilds a saved document. The content is normalized again
+// since the store may have been written by an older version, an op log
+// that doesn't lead up to the saved revision is discarded and authorship
+// that doesn't cover the content is forgotten.
+func FromRecord(record Record) (*Document, error) {
+	content, err := richtext.Normalize(record.Content)
+	if err != nil {
+		return nil, err
+	}
+	if err := record.Metadata.Validate(); err != nil {
+		return nil, err
+	}
+
+	doc := New(record.ID)
+	doc.content = content
+	doc.text = content.Text()
+	doc.revision = record.Revision
+	doc.metadata = record.Metadata
+	if record.Permissions.Export != "" {
+		doc.permissions = record.Permissions
+	}
+	for _, userID := range record.Editors {
+		doc.editors[userID] = true
+	}
+	if !record.UpdatedAt.IsZero() {
+		doc.updatedAt = record.UpdatedAt
+	}
+	for id, r := range record.Anchors {
+		doc.anchors[id] = r
+	}
+	doc.suggestions = record.Suggestions
+	doc.changes = record.Changes
+	doc.reactions = record.Reactions
+	doc.authors = unattributed(doc.text)
+	if spansCover(record.Attribution, doc.text) {
+		doc.authors = record.Attribution
+	}
+	doc.bans = restrictionMap(record.Bans)
+	doc.mutes = restrictionMap(record.Mutes)
+	for _, marker := range record.Seen {
+		doc.seen[marker.UserID] = marker
+	}
+	doc.tenant, doc.claimed = record.Tenant, true
+	doc.trashedAt = record.TrashedAt
+	if continuous(record.Ops, record.Revision) {
+		doc.history = record.Ops
+	}
+	return doc, nil
+}
+
+// continuous reports whether ops are consecutive revisions ending at
+// revision, each with a payload to replay
+func continuous(ops []Op, revision int64) bool {
+	for i, op := range ops {
+		if op.Revision != revision-int64(len(ops)-1-i) || len(op.Payload) == 0 || string(op.Payload) == "null" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package document
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"backend/ids"
+	"backend/richtext"
+)
+
+var (
+	ErrSuggestionNotFound = errors.New("suggestion not found")
+	ErrNotSuggesting      = errors.New("the document doesn't take suggestions from this user")
+	ErrNotReviewer        = errors.New("only the document's owner can accept or reject suggestions and changes")
+)
+
+// Suggestion is an edit proposed in suggesting mode: the whole content its
+// author would like the document to have, made on top of BaseRevision.
+// Each author has at most one pending suggestion, which follows their
+// edits until it is accepted or rejected.
+type Suggestion struct {
+	ID           string         `json:"id"`
+	Author       string         `json:"author"`
+	BaseRevision int64          `json:"baseRevision"`
+	Content      richtext.Delta `json:"content"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+}
+
+// Suggest records content as author's suggestion, made on top of revision
+// base, replacing the one they already have pending
+func (doc *Document) Suggest(author string, base int64, content richtext.Delta) (Suggestion, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if doc.frozen {
+		return Suggestion{}, ErrFrozen
+	}
+	if author == "" || doc.canEdit(author) || doc.mode() != ModeSuggesting {
+		return Suggestion{}, ErrNotSuggesting
+	}
+	if base != doc.revision {
+		return Suggestion{}, ErrStaleRevision
+	}
+
+	suggestion := Suggestion{
+		ID:           ids.NewUUID(),
+		Author:       author,
+		BaseRevision: base,
+		Content:      content,
+		UpdatedAt:    time.Now(),
+	}
+	doc.changed(suggestion.UpdatedAt)
+	i := slices.IndexFunc(doc.suggestions, func(s Suggestion) bool { return s.Author == author })
+	if i < 0 {
+		doc.suggestions = append(doc.suggestions, suggestion)
+		return suggestion, nil
+	}
+	suggestion.ID = doc.suggestions[i].ID
+	doc.suggestions[i] = suggestion
+	return suggestion, nil
+}
+
+// Suggestions returns the pending suggestions, oldest author first
+func (doc *Document) Suggestions() []Suggestion {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return slices.Clone(doc.suggestions)
+}
+
+// AcceptSuggestion applies a suggestion on behalf of userID, who must be
+// the owner, crediting the edit to the suggestion's author. There is no
+// transformation here either: a suggestion made before the latest revision
+// fails with ErrStaleRevision and stays pending.
+func (doc *Document) AcceptSuggestion(userID string, id string, payload Payload) (Op, Suggestion, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	i, err := doc.reviewed(userID, id)
+	if err != nil {
+		return Op{}, Suggestion{}, err
+	}
+	suggestion := doc.suggestions[i]
+	if doc.frozen {
+		return Op{}, suggestion, ErrFrozen
+	}
+	if suggestion.BaseRevision != doc.revision {
+		return Op{}, suggestion, ErrStaleRevision
+	}
+	if err := doc.checkLimits(suggestion.Content.Text()); err != nil {
+		return Op{}, suggestion, err
+	}
+	doc.suggestions = slices.Delete(doc.suggestions, i, i+1)
+	return doc.apply(suggestion.Author, suggestion.Content, payload), suggestion, nil
+}
+
+// RejectSuggestion drops a suggestion on behalf of userID, who must be the
+// owner
+func (doc *Document) RejectSuggestion(userID string, id string) (Suggestion, error) {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	i, err := doc.reviewed(userID, id)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	suggestion := doc.suggestions[i]
+	doc.suggestions = slices.Delete(doc.suggestions, i, i+1)
+	doc.changed(time.Now())
+	return suggestion, nil
+}
+
+// reviewed finds the suggestion userID wants to accept or reject, with the
+// lock held
+func (doc *Document) reviewed(userID string, id string) (int, error) {
+	if userID == "" || userID != doc.permissions.Owner {
+		return 0, ErrNotReviewer
+	}
+	i := slices.IndexFunc(doc.suggestions, func(s Suggestion) bool { return s.ID == id })
+	if i < 0 {
+		return 0, ErrSuggestionNotFound
+	}
+	return i, nil
+}
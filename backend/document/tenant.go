@@ -0,0 +1,29 @@
+package document
+
+import "time"
+
+// Tenant returns the tenant the document belongs to, empty for the
+// default tenant
+func (doc *Document) Tenant() string {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.tenant
+}
+
+// ClaimTenant reports whether the document belongs to tenant. A document
+// created since the server started belongs to the tenant of whoever first
+// claims it, which is whoever created it as long as every access claims;
+// saved documents keep the tenant they were saved with.
+func (doc *Document) ClaimTenant(tenant string) bool {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if !doc.claimed {
+		doc.tenant, doc.claimed = tenant, true
+		// Saved even if never edited, so the claim survives unloading
+		if tenant != "" {
+			doc.changed(time.Now())
+		}
+	}
+	return doc.tenant == tenant
+}
@@ -0,0 +1,99 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTrashed    = errors.New("document is in the trash")
+	ErrNotTrashed = errors.New("document is not in the trash")
+)
+
+// Trash moves the document to the trash on behalf of userID, who must be
+// the owner. It stays there, restorable, until it is purged.
+func (doc *Document) Trash(userID string) error {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if userID == "" || userID != doc.permissions.Owner {
+		return ErrNotOwner
+	}
+	if !doc.trashedAt.IsZero() {
+		return ErrTrashed
+	}
+	now := time.Now()
+	doc.trashedAt = now.UTC()
+	doc.changed(now)
+	return nil
+}
+
+// Restore takes the document back out of the trash on behalf of userID,
+// who must be the owner
+func (doc *Document) Restore(userID string) error {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+
+	if userID == "" || userID != doc.permissions.Owner {
+		return ErrNotOwner
+	}
+	if doc.trashedAt.IsZero() {
+		return ErrNotTrashed
+	}
+	doc.trashedAt = time.Time{}
+	doc.changed(time.Now())
+	return nil
+}
+
+// TrashedAt returns when the document was moved to the trash, and false
+// if it isn't there
+func (doc *Document) TrashedAt() (time.Time, bool) {
+	doc.mutex.RLock()
+	defer doc.mutex.RUnlock()
+	return doc.trashedAt, !doc.trashedAt.IsZero()
+}
+
+// Purge deletes the documents trashed before cutoff for good and returns
+// their IDs. Documents held open are left for a later call, and nothing is
+// purged from a store that can't delete.
+func (registry *Registry) Purge(ctx context.Context, cutoff time.Time) (purged []string, err error) {
+	records, err := registry.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.mutex.Lock()
+	deleter, canDelete := registry.store.(Deleter)
+	if registry.store != nil && !canDelete {
+		registry.mutex.Unlock()
+		return nil, nil
+	}
+	registry.mutex.Unlock()
+
+	var errs []error
+	for _, record := range records {
+		if record.TrashedAt.IsZero() || !record.TrashedAt.Before(cutoff) {
+			continue
+		}
+		registry.mutex.Lock()
+		_, pending := registry.pending[record.ID]
+		if pending || registry.holders[record.ID] > 0 {
+			registry.mutex.Unlock()
+			continue
+		}
+		if deleter != nil {
+			if err := deleter.Delete(ctx, record.ID); err != nil && !errors.Is(err, ErrNotFound) {
+				registry.mutex.Unlock()
+				errs = append(errs, fmt.Errorf("deleting document %q: %w", record.ID, err))
+				continue
+			}
+		}
+		delete(registry.documents, record.ID)
+		delete(registry.lastUsed, record.ID)
+		registry.mutex.Unlock()
+		purged = append(purged, record.ID)
+	}
+	return purged, errors.Join(errs...)
+}
@@ -0,0 +1,223 @@
+// Package encryption seals documents, op logs included, and snapshots
+// before they are stored, with AES-256-GCM under a key derived for each
+// document from a key of the keyring. Sealed values name the key that
+// sealed them, so keys can be rotated: the active key seals every write
+// while retired keys are kept to open what they sealed.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"backend/config"
+	"backend/metrics"
+)
+
+// KeySize is the length of every key, for AES-256
+const KeySize = 32
+
+// prefix starts every sealed value. Stored JSON starts with "{", so values
+// written before encryption was enabled are told apart.
+const prefix = "enc1:"
+
+var (
+	ErrUnknownKey = errors.New("sealed with a key that is not configured")
+	ErrNoKeys     = errors.New("sealed but no encryption keys are configured")
+	ErrNotSealed  = errors.New("stored unsealed but encryption is enabled")
+)
+
+// DocumentScope and SnapshotScope name what a value is sealed for. The
+// key is derived from the scope and the scope authenticated, so a sealed
+// value only opens as the document or snapshot it was written for.
+func DocumentScope(id string) string {
+	return "document/" + id
+}
+
+func SnapshotScope(id string) string {
+	return "snapshot/" + id
+}
+
+// Keyring holds the keys values are sealed with, by ID. A nil Keyring
+// leaves values in plaintext and refuses to open sealed ones. Values that
+// aren't sealed are refused, unless AllowPlaintext is set while a store
+// written before encryption was enabled is migrated; each one read then
+// is logged to Logger, if set.
+type Keyring struct {
+	Active         string
+	AllowPlaintext bool
+	Logger         *slog.Logger
+	keys           map[string][]byte
+}
+
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("encryption key ID %q must be non-empty and free of colons", id)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %q is %d bytes, not %d", id, len(key), KeySize)
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not configured", active)
+	}
+	return &Keyring{Active: active, keys: keys}, nil
+}
+
+// Load builds the keyring configured in cfg, decrypting keys wrapped by
+// KMS and logging plaintext values read to logger. It returns nil when no
+// keys are configured.
+func Load(ctx context.Context, cfg config.Encryption, logger *slog.Logger) (*Keyring, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	kms := NewKMS(cfg.KMSRegion)
+	keys := make(map[string][]byte, len(cfg.Keys))
+	for id, value := range cfg.Keys {
+		wrapped, isKMS := strings.CutPrefix(value, KMSPrefix)
+		raw, err := base64.StdEncoding.DecodeString(wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q is not base64", id)
+		}
+		if isKMS {
+			if raw, err = kms.Decrypt(ctx, raw); err != nil {
+				return nil, fmt.Errorf("decrypting encryption key %q: %w", id, err)
+			}
+		}
+		keys[id] = raw
+	}
+	keyring, err := NewKeyring(cfg.ActiveKey, keys)
+	if err != nil {
+		return nil, err
+	}
+	keyring.AllowPlaintext = cfg.AllowPlaintext
+	keyring.Logger = logger
+	return keyring, nil
+}
+
+// Seal encrypts plaintext for scope under the active key
+func (keyring *Keyring) Seal(scope string, plaintext []byte) ([]byte, error) {
+	if keyring == nil {
+		return plaintext, nil
+	}
+	aead, err := keyring.aead(keyring.Active, scope)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(scope))
+
+	out := make([]byte, 0, len(prefix)+len(keyring.Active)+1+base64.StdEncoding.EncodedLen(len(sealed)))
+	out = append(out, prefix...)
+	out = append(out, keyring.Active...)
+	out = append(out, ':')
+	return base64.StdEncoding.AppendEncode(out, sealed), nil
+}
+
+// Open decrypts a value sealed for scope. Values that aren't sealed are
+// returned as they are by a nil keyring or one that allows plaintext, and
+// refused by any other.
+func (keyring *Keyring) Open(scope string, data []byte) ([]byte, error) {
+	id, ok := KeyID(data)
+	if !ok {
+		return keyring.plaintext(scope, data)
+	}
+	if keyring == nil {
+		return nil, ErrNoKeys
+	}
+	aead, err := keyring.aead(id, scope)
+	if err != nil {
+		return nil, err
+	}
+	encoded := data[len(prefix)+len(id)+1:]
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(sealed, encoded)
+	sealed = sealed[:n]
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value is malformed")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(scope))
+	if err != nil {
+		return nil, fmt.Errorf("sealed value does not open with key %q: %w", id, err)
+	}
+	return plaintext, nil
+}
+
+// Reseal seals data, stored for scope under any key of the keyring or
+// unsealed, again with the active key. It reports false and leaves data
+// as it is when the active key sealed it already.
+func (keyring *Keyring) Reseal(scope string, data []byte) ([]byte, bool, error) {
+	if id, ok := KeyID(data); keyring == nil || ok && id == keyring.Active {
+		return data, false, nil
+	}
+	plaintext, err := keyring.Open(scope, data)
+	if err != nil {
+		return nil, false, err
+	}
+	sealed, err := keyring.Seal(scope, plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	return sealed, true, nil
+}
+
+// plaintext returns data, stored for scope without being sealed, if the
+// keyring allows it
+func (keyring *Keyring) plaintext(scope string, data []byte) ([]byte, error) {
+	if keyring == nil {
+		return data, nil
+	}
+	if !keyring.AllowPlaintext {
+		metrics.PlaintextReads.WithLabelValues("refused").Inc()
+		return nil, fmt.Errorf("%w: %s", ErrNotSealed, scope)
+	}
+	metrics.PlaintextReads.WithLabelValues("allowed").Inc()
+	if keyring.Logger != nil {
+		keyring.Logger.Warn("Read a value stored unsealed", "scope", scope)
+	}
+	return data, nil
+}
+
+// KeyID returns the ID of the key that sealed data, or false if data is
+// not sealed
+func KeyID(data []byte) (string, bool) {
+	rest, ok := bytes.CutPrefix(data, []byte(prefix))
+	if !ok {
+		return "", false
+	}
+	end := bytes.IndexByte(rest, ':')
+	if end < 0 {
+		return "", false
+	}
+	return string(rest[:end]), true
+}
+
+// aead is the cipher of the key derived from key id for scope
+func (keyring *Keyring) aead(id string, scope string) (cipher.AEAD, error) {
+	master, ok := keyring.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	key, err := hkdf.Key(sha256.New, master, nil, scope, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
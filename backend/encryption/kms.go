@@ -0,0 +1,88 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"backend/awssig"
+)
+
+// Timeout for decrypting a single data key
+const decryptTimeout = 10 * time.Second
+
+// KMSPrefix marks a configured key as wrapped by AWS KMS: the rest is the
+// base64 ciphertext blob of a data key, such as GenerateDataKey returns,
+// which is decrypted at startup so the key itself is never configured
+const KMSPrefix = "kms:"
+
+// KMS decrypts data keys with AWS KMS. Credentials come from the standard
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optional AWS_SESSION_TOKEN
+// environment variables.
+type KMS struct {
+	Region   string
+	Endpoint string
+	Client   *http.Client
+}
+
+func NewKMS(region string) *KMS {
+	return &KMS{
+		Region:   region,
+		Endpoint: "https://kms." + region + ".amazonaws.com/",
+		Client:   http.DefaultClient,
+	}
+}
+
+type kmsDecryptResponse struct {
+	Plaintext []byte
+}
+
+type kmsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// Decrypt returns the plaintext of a ciphertext blob. The blob names the
+// KMS key that encrypted it.
+func (kms *KMS) Decrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	creds, err := awssig.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, decryptTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string][]byte{"CiphertextBlob": blob})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, kms.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	awssig.Sign(req, awssig.PayloadHash(body), creds, kms.Region, "kms", time.Now())
+
+	resp, err := kms.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure kmsError
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&failure)
+		return nil, fmt.Errorf("KMS returned %s: %s", resp.Status, failure.Type)
+	}
+
+	var decrypted kmsDecryptResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decrypted); err != nil {
+		return nil, fmt.Errorf("decoding KMS response: %w", err)
+	}
+	return decrypted.Plaintext, nil
+}
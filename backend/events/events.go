@@ -0,0 +1,252 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"backend/ids"
+)
+
+// SchemaVersion is the version of the envelope and of every payload type
+// below. Within a version, fields are only ever added, never renamed,
+// removed or retyped, so consumers can ignore unknown fields safely. Any
+// breaking change bumps the version and is announced before release.
+const SchemaVersion = 1
+
+// Event types published for document changes
+const (
+	TypeDocumentCreated  = "document.created"
+	TypeDocumentUpdated  = "document.updated"
+	TypeMetadataUpdated  = "document.metadata_updated"
+	TypeSnapshotCreated  = "document.snapshot_created"
+	TypeDocumentTrashed  = "document.trashed"
+	TypeDocumentRestored = "document.restored"
+	TypeDocumentPurged   = "document.purged"
+	TypeCommentAdded     = "comment.added"
+	TypeCommentResolved  = "comment.resolved"
+	TypeUserJoined       = "user.joined"
+	TypeUserLeft         = "user.left"
+	TypeUserRenamed      = "user.renamed"
+)
+
+// Event types only written to the event log, which records every op and
+// presence change so the stream of a room can be analysed or replayed
+const (
+	TypeOpApplied       = "op.applied"
+	TypePresenceJoined  = "presence.joined"
+	TypePresenceUpdated = "presence.updated"
+	TypePresenceLeft    = "presence.left"
+)
+
+// Event is the envelope shared by every consumer of change events: the
+// Kafka stream, webhooks and the activity feed. The payload layout is
+// determined by Type.
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Actor         string          `json:"actor,omitempty"`
+	DocID         string          `json:"doc_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// DocumentCreated is the payload of document.created, published when a
+// document is first opened
+type DocumentCreated struct{}
+
+// DocumentUpdated is the payload of document.updated
+type DocumentUpdated struct {
+	Revision int64 `json:"revision"`
+}
+
+// MetadataUpdated is the payload of document.metadata_updated
+type MetadataUpdated struct {
+	Language    string   `json:"language"`
+	Direction   string   `json:"direction"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// SnapshotCreated is the payload of document.snapshot_created
+type SnapshotCreated struct {
+	SnapshotID string `json:"snapshot_id"`
+	Revision   int64  `json:"revision"`
+}
+
+// DocumentTrashed is the payload of document.trashed. PurgeAt is when the
+// document will be purged unless restored, omitted when it is kept until
+// then.
+type DocumentTrashed struct {
+	PurgeAt time.Time `json:"purge_at,omitzero"`
+}
+
+// DocumentRestored is the payload of document.restored
+type DocumentRestored struct{}
+
+// DocumentPurged is the payload of document.purged, published when a
+// trashed document is deleted for good
+type DocumentPurged struct{}
+
+// CommentAdded is the payload of comment.added
+type CommentAdded struct {
+	CommentID string `json:"comment_id"`
+}
+
+// CommentResolved is the payload of comment.resolved
+type CommentResolved struct {
+	CommentID string `json:"comment_id"`
+}
+
+// UserJoined is the payload of user.joined, published when a user's first
+// connection to a document opens
+type UserJoined struct {
+	UserID   string `json:"user_id"`
+	ViewOnly bool   `json:"view_only,omitempty"`
+}
+
+// UserLeft is the payload of user.left, published when a user's last
+// connection to a document closes
+type UserLeft struct {
+	UserID string `json:"user_id"`
+}
+
+// UserRenamed is the payload of user.renamed, published for each document
+// a user has open when they change the name they go by
+type UserRenamed struct {
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+}
+
+// OpApplied is the payload of op.applied. Deleted characters at Pos were
+// replaced by Inserted ones, DeletedText by InsertedText; Frame is the
+// message that relays the op to clients, enough to replay it.
+type OpApplied struct {
+	Revision     int64           `json:"revision"`
+	Pos          int             `json:"pos"`
+	Deleted      int             `json:"deleted"`
+	Inserted     int             `json:"inserted"`
+	DeletedText  string          `json:"deleted_text,omitempty"`
+	InsertedText string          `json:"inserted_text,omitempty"`
+	Frame        json.RawMessage `json:"frame,omitempty"`
+}
+
+// Presence is the payload of presence.joined, presence.updated and
+// presence.left, one per connection
+type Presence struct {
+	ConnID   string            `json:"conn_id"`
+	UserID   string            `json:"user_id"`
+	UserData map[string]string `json:"user_data,omitempty"`
+}
+
+// New wraps a payload in a fresh envelope
+func New(eventType string, docID string, actor string, payload any) (Event, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("marshalling %s payload: %w", eventType, err)
+	}
+	return Event{
+		ID:            ids.NewUUID(),
+		Type:          eventType,
+		SchemaVersion: SchemaVersion,
+		OccurredAt:    time.Now().UTC(),
+		Actor:         actor,
+		DocID:         docID,
+		Payload:       raw,
+	}, nil
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close() error
+}
+
+// Publishers publishes every event to each of its publishers in turn
+type Publishers []Publisher
+
+func (publishers Publishers) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, publisher := range publishers {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (publishers Publishers) Close() error {
+	var errs []error
+	for _, publisher := range publishers {
+		if err := publisher.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Capacity of the queue between the socket layer and the publisher
+const queueSize = 1024
+
+// Timeout for publishing a single event
+const publishTimeout = 5 * time.Second
+
+// Dispatcher decouples event producers from the publisher so a slow or
+// unavailable broker never blocks the editing path. Events are dropped,
+// with a warning, when the queue is full.
+type Dispatcher struct {
+	publisher Publisher
+	queue     chan Event
+	done      chan struct{}
+	logger    *slog.Logger
+}
+
+func NewDispatcher(publisher Publisher, logger *slog.Logger) *Dispatcher {
+	dispatcher := &Dispatcher{
+		publisher: publisher,
+		queue:     make(chan Event, queueSize),
+		done:      make(chan struct{}),
+		logger:    logger,
+	}
+	go dispatcher.run()
+	return dispatcher
+}
+
+// Emit queues an event without blocking. A nil dispatcher discards events,
+// which is how the event stream is disabled.
+func (dispatcher *Dispatcher) Emit(event Event) {
+	if dispatcher == nil {
+		return
+	}
+	select {
+	case dispatcher.queue <- event:
+	default:
+		dispatcher.logger.Warn("Event queue full, dropping event", "type", event.Type, "doc_id", event.DocID)
+	}
+}
+
+// Close publishes whatever is still queued and closes the publisher
+func (dispatcher *Dispatcher) Close() error {
+	if dispatcher == nil {
+		return nil
+	}
+	close(dispatcher.queue)
+	<-dispatcher.done
+	return dispatcher.publisher.Close()
+}
+
+func (dispatcher *Dispatcher) run() {
+	defer close(dispatcher.done)
+
+	for event := range dispatcher.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		if err := dispatcher.publisher.Publish(ctx, event); err != nil {
+			dispatcher.logger.Warn("Could not publish event", "type", event.Type, "doc_id", event.DocID, "error", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher writes each event type to its own topic, named
+// "<prefix>.<event type>", or every event to one topic, keyed by document
+// so per-document ordering holds
+type KafkaPublisher struct {
+	writer      *kafka.Writer
+	topicPrefix string
+	topic       string
+}
+
+func NewKafkaPublisher(brokers []string, topicPrefix string) *KafkaPublisher {
+	return &KafkaPublisher{writer: newKafkaWriter(brokers), topicPrefix: topicPrefix}
+}
+
+// NewKafkaTopicPublisher publishes every event to topic
+func NewKafkaTopicPublisher(brokers []string, topic string) *KafkaPublisher {
+	return &KafkaPublisher{writer: newKafkaWriter(brokers), topic: topic}
+}
+
+func newKafkaWriter(brokers []string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		AllowAutoTopicCreation: true,
+	}
+}
+
+func (publisher *KafkaPublisher) Publish(ctx context.Context, event Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	topic := publisher.topic
+	if topic == "" {
+		topic = publisher.topicPrefix + "." + event.Type
+	}
+	return publisher.writer.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
+		Key:   []byte(event.DocID),
+		Value: value,
+	})
+}
+
+func (publisher *KafkaPublisher) Close() error {
+	return publisher.writer.Close()
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// OpenLog opens the event log a DSN names: file:///path appends events to
+// an NDJSON file, kafka://broker1,broker2/topic publishes them to a single
+// Kafka topic keyed by document
+func OpenLog(dsn string) (Publisher, error) {
+	scheme, rest, _ := strings.Cut(dsn, ":")
+	switch scheme {
+	case "file":
+		return NewFilePublisher(strings.TrimPrefix(rest, "//"))
+	case "kafka":
+		brokers, topic, _ := strings.Cut(strings.TrimPrefix(rest, "//"), "/")
+		if brokers == "" || topic == "" {
+			return nil, fmt.Errorf("kafka event log needs brokers and a topic")
+		}
+		return NewKafkaTopicPublisher(strings.Split(brokers, ","), topic), nil
+	}
+	return nil, fmt.Errorf("event log DSN must start with file:// or kafka://")
+}
+
+// FilePublisher appends each event to a file as one line of JSON
+type FilePublisher struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file event log needs a path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("creating event log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening event log: %w", err)
+	}
+	return &FilePublisher{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (publisher *FilePublisher) Publish(_ context.Context, event Event) error {
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+	return publisher.encoder.Encode(event)
+}
+
+func (publisher *FilePublisher) Close() error {
+	return publisher.file.Close()
+}
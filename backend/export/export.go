@@ -0,0 +1,80 @@
+// Package export renders document content, which is the HTML produced by the
+// editor, into downloadable formats
+package export
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+// Characters kept when deriving a filename from a document ID
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Format is an output format documents can be exported to
+type Format struct {
+	Name        string
+	Extension   string
+	ContentType string
+	render      func(w io.Writer, info Info, nodes []*html.Node) error
+}
+
+var formats = map[string]Format{
+	"md":   {Name: "md", Extension: ".md", ContentType: "text/markdown; charset=utf-8", render: renderMarkdown},
+	"html": {Name: "html", Extension: ".html", ContentType: "text/html; charset=utf-8", render: renderHTML},
+	"txt":  {Name: "txt", Extension: ".txt", ContentType: "text/plain; charset=utf-8", render: renderText},
+	"pdf":  {Name: "pdf", Extension: ".pdf", ContentType: "application/pdf", render: renderPDF},
+}
+
+// Lookup returns the format with the given name
+func Lookup(name string) (Format, error) {
+	format, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return Format{}, ErrUnsupportedFormat
+	}
+	return format, nil
+}
+
+// Names lists the supported format names
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Filename names the file a document exported in this format is saved as
+func (format Format) Filename(docID string) string {
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(docID, "-"), "-.")
+	if name == "" {
+		name = "document"
+	}
+	return name + format.Extension
+}
+
+// Info is the document metadata carried by the formats that support it.
+// Language is a BCP 47 tag or empty, Direction is "ltr" or "rtl".
+type Info struct {
+	Title     string
+	Language  string
+	Direction string
+}
+
+// Render writes content to w in this format
+func (format Format) Render(w io.Writer, info Info, content string) error {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return err
+	}
+	return format.render(w, info, nodes)
+}
@@ -0,0 +1,134 @@
+package export
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements kept in HTML exports, with the attributes each may carry on top
+// of dir, which every element may.
+// Anything else is unwrapped to its content, or dropped entirely when it is
+// in skippedElements.
+var allowedElements = map[atom.Atom][]string{
+	atom.P: nil, atom.Div: nil, atom.Span: nil, atom.Br: nil, atom.Hr: nil,
+	atom.B: nil, atom.Strong: nil, atom.I: nil, atom.Em: nil, atom.U: nil,
+	atom.S: nil, atom.Strike: nil, atom.Del: nil, atom.Ins: nil,
+	atom.Sub: nil, atom.Sup: nil, atom.Code: nil, atom.Pre: nil,
+	atom.Blockquote: nil, atom.H1: nil, atom.H2: nil, atom.H3: nil,
+	atom.H4: nil, atom.H5: nil, atom.H6: nil, atom.Ul: nil,
+	atom.Ol: {"start"}, atom.Li: nil, atom.A: {"href", "title"},
+	atom.Img:   {"src", "alt", "title", "width", "height"},
+	atom.Table: nil, atom.Thead: nil, atom.Tbody: nil, atom.Tfoot: nil,
+	atom.Tr: nil, atom.Th: {"colspan", "rowspan"}, atom.Td: {"colspan", "rowspan"},
+	atom.Caption: nil,
+}
+
+// renderHTML writes a standalone page with the content as its main
+// landmark. Content comes straight from editors, so it is reduced to an
+// allowlist of formatting markup first.
+func renderHTML(w io.Writer, info Info, nodes []*html.Node) error {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	for _, node := range nodes {
+		body.AppendChild(node)
+	}
+	sanitize(body)
+
+	root := "<html"
+	if info.Language != "" {
+		root += ` lang="` + html.EscapeString(info.Language) + `"`
+	}
+	if info.Direction != "" {
+		root += ` dir="` + html.EscapeString(info.Direction) + `"`
+	}
+	header := "<!DOCTYPE html>\n" + root + ">\n<head>\n<meta charset=\"utf-8\">\n<title>" +
+		html.EscapeString(info.Title) + "</title>\n</head>\n"
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	main := &html.Node{Type: html.ElementNode, Data: "main", DataAtom: atom.Main}
+	for child := body.FirstChild; child != nil; child = body.FirstChild {
+		body.RemoveChild(child)
+		main.AppendChild(child)
+	}
+	body.AppendChild(main)
+	if err := html.Render(w, body); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n</html>\n")
+	return err
+}
+
+func sanitize(parent *html.Node) {
+	for child := parent.FirstChild; child != nil; {
+		next := child.NextSibling
+		switch child.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			if skippedElements[child.DataAtom] {
+				parent.RemoveChild(child)
+				break
+			}
+			sanitize(child)
+			keys, ok := allowedElements[child.DataAtom]
+			if !ok {
+				for grandchild := child.FirstChild; grandchild != nil; grandchild = child.FirstChild {
+					child.RemoveChild(grandchild)
+					parent.InsertBefore(grandchild, child)
+				}
+				parent.RemoveChild(child)
+				break
+			}
+			child.Attr = allowedAttributes(child, keys)
+		default:
+			parent.RemoveChild(child)
+		}
+		child = next
+	}
+}
+
+func allowedAttributes(node *html.Node, keys []string) []html.Attribute {
+	var kept []html.Attribute
+	for _, a := range node.Attr {
+		if a.Namespace != "" || (!contains(keys, a.Key) && a.Key != "dir") {
+			continue
+		}
+		switch a.Key {
+		case "dir":
+			if a.Val != "ltr" && a.Val != "rtl" {
+				continue
+			}
+		case "href":
+			if !safeURL(a.Val, "http", "https", "mailto") {
+				continue
+			}
+		case "src":
+			if !safeURL(a.Val, "http", "https") {
+				continue
+			}
+		}
+		kept = append(kept, html.Attribute{Key: a.Key, Val: a.Val})
+	}
+	return kept
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL reports whether u is relative or uses one of the given schemes
+func safeURL(u string, schemes ...string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "" || contains(schemes, strings.ToLower(parsed.Scheme))
+}
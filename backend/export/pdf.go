@@ -0,0 +1,201 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Pages are US Letter with one inch margins, set in 10pt Courier so that
+// wrapping can be done by counting characters
+const (
+	pdfPageWidth    = 612
+	pdfPageHeight   = 792
+	pdfMargin       = 72
+	pdfFontSize     = 10
+	pdfLeading      = 12
+	pdfColumns      = (pdfPageWidth - 2*pdfMargin) * 10 / (6 * pdfFontSize)
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+)
+
+// Characters outside Latin-1 that WinAnsiEncoding can still represent
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86,
+	'‡': 0x87, 'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C,
+	'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95,
+	'–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// renderPDF lays the plain text rendering out on pages, with right to left
+// lines aligned to the right margin. The output is tagged: headings, list
+// items, quotes, code and images with alt text are marked up in a structure
+// tree so assistive technology can navigate it. Only the standard Courier
+// font is used, so characters it cannot encode become '?'.
+func renderPDF(w io.Writer, info Info, nodes []*html.Node) error {
+	tree := newStructTree()
+	var lines []pdfLine
+	for _, line := range flatten(nodes, false, info.Direction == "rtl") {
+		element := tree.add(line)
+		for _, piece := range wrap(line.text, pdfColumns) {
+			if line.rtl {
+				piece = strings.Repeat(" ", pdfColumns-utf8.RuneCountInString(piece)) + piece
+			}
+			lines = append(lines, pdfLine{text: piece, element: element})
+		}
+	}
+	var pages [][]pdfLine
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+	for i, page := range pages {
+		tree.mark(i, page)
+	}
+
+	// Objects 1-4 are fixed, each page is followed by its content stream
+	// and the structure tree comes last
+	pdf := &pdfWriter{w: w}
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageObject(i))
+	}
+	treeObject := pageObject(len(pages))
+	catalog := fmt.Sprintf("/Type /Catalog /Pages 2 0 R /MarkInfo << /Marked true >> /StructTreeRoot %d 0 R", treeObject)
+	if info.Language != "" {
+		catalog += " /Lang " + pdfTextString(info.Language)
+	}
+	catalog += " /ViewerPreferences << /DisplayDocTitle true"
+	if info.Direction == "rtl" {
+		catalog += " /Direction /R2L"
+	}
+	catalog += " >>"
+
+	pdf.header()
+	pdf.object("<< " + catalog + " >>")
+	pdf.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	pdf.object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	pdf.object(fmt.Sprintf("<< /Title %s /Producer (collab) /CreationDate (D:%s) >>",
+		pdfTextString(info.Title), time.Now().UTC().Format("20060102150405Z")))
+	for i, page := range pages {
+		pdf.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R /StructParents %d /Tabs /S >>",
+			pdfPageWidth, pdfPageHeight, pageObject(i)+1, i))
+		pdf.stream(tree.pageContent(page))
+	}
+	tree.write(pdf, treeObject)
+	pdf.trailer()
+	return pdf.err
+}
+
+// pageObject is the object number of page i; its content stream follows it
+func pageObject(i int) int {
+	return 5 + 2*i
+}
+
+// wrap breaks a line at spaces so no piece is longer than width runes,
+// splitting words that do not fit on a line of their own
+func wrap(line string, width int) []string {
+	var wrapped []string
+	for utf8.RuneCountInString(line) > width {
+		runes := []rune(line)
+		cut := width
+		for i := width; i > 0; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		wrapped = append(wrapped, strings.TrimRight(string(runes[:cut]), " "))
+		line = strings.TrimLeft(string(runes[cut:]), " ")
+	}
+	return append(wrapped, line)
+}
+
+// pdfTextString encodes s as a text string, such as metadata or alt text,
+// which unlike page content can hold any character
+func pdfTextString(s string) string {
+	ascii := true
+	for _, r := range s {
+		ascii = ascii && r < 0x80
+	}
+	if ascii {
+		return pdfString(s)
+	}
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", unit)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// pdfString encodes s as a WinAnsi literal string
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7F:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsiExtras[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsiExtras[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfWriter numbers objects in write order and records their offsets for
+// the cross-reference table. The first error is kept and later writes are
+// skipped.
+type pdfWriter struct {
+	w       io.Writer
+	offset  int
+	offsets []int
+	err     error
+}
+
+func (pdf *pdfWriter) write(s string) {
+	if pdf.err != nil {
+		return
+	}
+	n, err := io.WriteString(pdf.w, s)
+	pdf.offset += n
+	pdf.err = err
+}
+
+func (pdf *pdfWriter) header() {
+	pdf.write("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+}
+
+func (pdf *pdfWriter) object(body string) {
+	pdf.offsets = append(pdf.offsets, pdf.offset)
+	pdf.write(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", len(pdf.offsets), body))
+}
+
+func (pdf *pdfWriter) stream(data []byte) {
+	pdf.object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(data), data))
+}
+
+func (pdf *pdfWriter) trailer() {
+	xref := pdf.offset
+	pdf.write(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(pdf.offsets)+1))
+	for _, offset := range pdf.offsets {
+		pdf.write(fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+	pdf.write(fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pdf.offsets)+1, xref))
+}
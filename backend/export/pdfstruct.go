@@ -0,0 +1,140 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pdfLine is a line of a PDF page. element is the structure element the
+// text belongs to, -1 for blank lines, and mcid its marked content ID on
+// the page.
+type pdfLine struct {
+	text    string
+	element int
+	mcid    int
+}
+
+// structElement is a node of the structure tree. Leaves hold marked
+// content, containers hold other elements.
+type structElement struct {
+	role   string
+	alt    string
+	parent int
+	kids   []int
+	marks  []markedContent
+}
+
+type markedContent struct {
+	page int
+	mcid int
+}
+
+// structTree is the logical structure of a tagged PDF. Element 0 is the
+// Document every other element descends from.
+type structTree struct {
+	elements []structElement
+	list     int
+	parents  [][]int
+}
+
+func newStructTree() *structTree {
+	return &structTree{
+		elements: []structElement{{role: "Document", parent: -1}},
+		list:     -1,
+	}
+}
+
+func (tree *structTree) addElement(role string, parent int) int {
+	tree.elements = append(tree.elements, structElement{role: role, parent: parent})
+	index := len(tree.elements) - 1
+	tree.elements[parent].kids = append(tree.elements[parent].kids, index)
+	return index
+}
+
+// add creates the elements a flattened line is marked up as and returns
+// the one its text goes into. Consecutive list items share a list.
+func (tree *structTree) add(line flatLine) int {
+	switch line.role {
+	case "":
+		tree.list = -1
+		return -1
+	case "LI":
+		if tree.list < 0 {
+			tree.list = tree.addElement("L", 0)
+		}
+		return tree.addElement("LBody", tree.addElement("LI", tree.list))
+	}
+	tree.list = -1
+	element := tree.addElement(line.role, 0)
+	tree.elements[element].alt = line.alt
+	return element
+}
+
+// mark numbers the marked content of page i
+func (tree *structTree) mark(i int, page []pdfLine) {
+	tree.parents = append(tree.parents, nil)
+	for j := range page {
+		element := page[j].element
+		if element < 0 {
+			continue
+		}
+		page[j].mcid = len(tree.parents[i])
+		tree.parents[i] = append(tree.parents[i], element)
+		tree.elements[element].marks = append(tree.elements[element].marks, markedContent{page: i, mcid: page[j].mcid})
+	}
+}
+
+func (tree *structTree) pageContent(page []pdfLine) []byte {
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin-pdfFontSize)
+	for _, line := range page {
+		switch {
+		case line.element >= 0:
+			fmt.Fprintf(&content, "/%s <</MCID %d>> BDC %s Tj EMC T*\n", tree.elements[line.element].role, line.mcid, pdfString(line.text))
+		case line.text != "":
+			fmt.Fprintf(&content, "/Artifact BMC %s Tj EMC T*\n", pdfString(line.text))
+		default:
+			content.WriteString("T*\n")
+		}
+	}
+	content.WriteString("ET\n")
+	return content.Bytes()
+}
+
+// write writes the tree root as object first followed by the elements
+func (tree *structTree) write(pdf *pdfWriter, first int) {
+	ref := func(element int) string {
+		return fmt.Sprintf("%d 0 R", first+1+element)
+	}
+
+	var nums []string
+	for page, elements := range tree.parents {
+		refs := make([]string, len(elements))
+		for i, element := range elements {
+			refs[i] = ref(element)
+		}
+		nums = append(nums, fmt.Sprintf("%d [%s]", page, strings.Join(refs, " ")))
+	}
+	pdf.object(fmt.Sprintf("<< /Type /StructTreeRoot /K %s /ParentTree << /Nums [%s] >> /ParentTreeNextKey %d >>",
+		ref(0), strings.Join(nums, " "), len(tree.parents)))
+
+	for _, element := range tree.elements {
+		parent := fmt.Sprintf("%d 0 R", first)
+		if element.parent >= 0 {
+			parent = ref(element.parent)
+		}
+		var kids []string
+		for _, kid := range element.kids {
+			kids = append(kids, ref(kid))
+		}
+		for _, mark := range element.marks {
+			kids = append(kids, fmt.Sprintf("<< /Type /MCR /Pg %d 0 R /MCID %d >>", pageObject(mark.page), mark.mcid))
+		}
+		body := fmt.Sprintf("<< /Type /StructElem /S /%s /P %s /K [%s]", element.role, parent, strings.Join(kids, " "))
+		if element.alt != "" {
+			body += " /Alt " + pdfTextString(element.alt)
+		}
+		pdf.object(body + " >>")
+	}
+}
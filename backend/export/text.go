@@ -0,0 +1,460 @@
+package export
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements whose content is never part of the document text
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Title: true, atom.Script: true, atom.Style: true,
+	atom.Template: true, atom.Noscript: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Svg: true,
+}
+
+// Elements that start on a new line
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Fieldset: true,
+	atom.Figure: true, atom.Figcaption: true, atom.Footer: true, atom.Form: true,
+	atom.Header: true, atom.Main: true, atom.Nav: true, atom.Section: true,
+	atom.Tr: true, atom.Li: true,
+}
+
+// Elements separated from their surroundings by a blank line
+var paragraphElements = map[atom.Atom]bool{
+	atom.P: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Ul: true, atom.Ol: true,
+	atom.Blockquote: true, atom.Pre: true, atom.Table: true, atom.Hr: true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+func renderText(w io.Writer, _ Info, nodes []*html.Node) error {
+	return writeLines(w, flatten(nodes, false, false))
+}
+
+func renderMarkdown(w io.Writer, _ Info, nodes []*html.Node) error {
+	return writeLines(w, flatten(nodes, true, false))
+}
+
+func writeLines(w io.Writer, lines []flatLine) error {
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line.text+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type list struct {
+	ordered bool
+	next    int
+	indent  int
+}
+
+// flatLine is a line of flattened text. Role is the structure type the
+// line has in tagged PDFs, empty for blank lines, and Alt is the alt text
+// of a line that is only an image.
+type flatLine struct {
+	text string
+	rtl  bool
+	role string
+	alt  string
+}
+
+// Structure types of the elements that lines can belong to; other lines
+// are paragraphs
+var elementRoles = map[atom.Atom]string{
+	atom.H1: "H1", atom.H2: "H2", atom.H3: "H3", atom.H4: "H4", atom.H5: "H5",
+	atom.H6: "H6", atom.Li: "LI", atom.Blockquote: "BlockQuote", atom.Pre: "Code",
+}
+
+// flattener turns an HTML tree into lines of plain text or Markdown,
+// collapsing whitespace the way a browser would outside of <pre>. It also
+// tracks the direction and structure of each line for PDFs.
+type flattener struct {
+	markdown bool
+	lines    []flatLine
+	current  flatLine
+	prefix   int
+	rtl      bool
+	role     string
+	line     strings.Builder
+	open     bool
+	space    bool
+	blank    bool
+	quotes   int
+	lists    []*list
+	marker   string
+	pre      int
+	code     int
+}
+
+// flatten returns the lines of the content; rtl is the direction of lines
+// without a dir of their own
+func flatten(nodes []*html.Node, markdown bool, rtl bool) []flatLine {
+	f := &flattener{markdown: markdown, rtl: rtl, role: "P"}
+	for _, node := range nodes {
+		f.node(node)
+	}
+	f.endLine()
+	for len(f.lines) > 0 && strings.Trim(f.lines[len(f.lines)-1].text, "> ") == "" {
+		f.lines = f.lines[:len(f.lines)-1]
+	}
+	return f.lines
+}
+
+func (f *flattener) quotePrefix() string {
+	if f.markdown {
+		return strings.Repeat("> ", f.quotes)
+	}
+	return strings.Repeat("    ", f.quotes)
+}
+
+// startLine writes the quote and list prefix of a new line
+func (f *flattener) startLine() {
+	if f.open {
+		return
+	}
+	f.line.WriteString(f.quotePrefix())
+	indent := 0
+	for _, l := range f.lists {
+		indent += l.indent
+	}
+	if f.marker != "" {
+		indent -= f.lists[len(f.lists)-1].indent
+		f.line.WriteString(strings.Repeat(" ", indent) + f.marker)
+		f.marker = ""
+	} else {
+		f.line.WriteString(strings.Repeat(" ", indent))
+	}
+	f.open = true
+	f.space = true
+	f.prefix = f.line.Len()
+	f.current = flatLine{rtl: f.rtl, role: f.role}
+}
+
+func (f *flattener) endLine() {
+	if !f.open {
+		return
+	}
+	line := strings.TrimRightFunc(f.line.String(), unicode.IsSpace)
+	f.current.text = line
+	if f.current.alt != "" && strings.TrimSpace(line[min(f.prefix, len(line)):]) == f.current.alt {
+		f.current.role = "Figure"
+	} else {
+		f.current.alt = ""
+	}
+	f.blank = strings.Trim(line, "> ") == ""
+	if f.blank {
+		f.current.role = ""
+	}
+	f.lines = append(f.lines, f.current)
+	f.line.Reset()
+	f.open = false
+}
+
+// newline ends the current line even when it is empty
+func (f *flattener) newline() {
+	f.startLine()
+	f.endLine()
+}
+
+func (f *flattener) paragraph() {
+	f.endLine()
+	if len(f.lines) > 0 && !f.blank {
+		f.lines = append(f.lines, flatLine{text: strings.TrimRight(f.quotePrefix(), " ")})
+		f.blank = true
+	}
+}
+
+// raw writes s verbatim, without whitespace collapsing or escaping
+func (f *flattener) raw(s string) {
+	f.startLine()
+	f.line.WriteString(s)
+	f.space = strings.HasSuffix(s, " ")
+}
+
+func (f *flattener) text(s string) {
+	if f.pre > 0 {
+		for i, segment := range strings.Split(s, "\n") {
+			if i > 0 {
+				f.newline()
+			}
+			if segment != "" {
+				f.raw(strings.ReplaceAll(segment, "\t", "    "))
+			}
+		}
+		return
+	}
+
+	words := strings.FieldsFunc(s, unicode.IsSpace)
+	leading := s != "" && unicode.IsSpace(rune(s[0]))
+	trailing := s != "" && unicode.IsSpace(rune(s[len(s)-1]))
+	for i, word := range words {
+		if i > 0 || leading {
+			f.separate()
+		}
+		if f.markdown && f.code == 0 {
+			word = escapeMarkdown(word)
+		}
+		f.raw(word)
+	}
+	if trailing {
+		f.separate()
+	}
+}
+
+// separate writes a single space between words on the same line
+func (f *flattener) separate() {
+	if f.open && !f.space {
+		f.raw(" ")
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// inline wraps the element's content in a Markdown delimiter, skipping
+// elements with no visible text so no stray delimiters are left behind
+func (f *flattener) inline(node *html.Node, delimiter string) {
+	if !f.markdown || strings.TrimSpace(textContent(node)) == "" {
+		f.children(node)
+		return
+	}
+	f.separate()
+	f.raw(delimiter)
+	f.space = true
+	f.children(node)
+	f.space = false
+	f.raw(delimiter)
+}
+
+func (f *flattener) children(node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		f.node(child)
+	}
+}
+
+func (f *flattener) node(node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		f.text(node.Data)
+		return
+	case html.ElementNode:
+	default:
+		f.children(node)
+		return
+	}
+	if skippedElements[node.DataAtom] {
+		return
+	}
+
+	switch node.DataAtom {
+	case atom.Br:
+		f.newline()
+		return
+	case atom.Hr:
+		f.paragraph()
+		if f.markdown {
+			f.raw("---")
+		} else {
+			f.raw(strings.Repeat("-", 40))
+		}
+		f.paragraph()
+		return
+	case atom.Img:
+		f.image(node)
+		return
+	case atom.B, atom.Strong:
+		f.inline(node, "**")
+		return
+	case atom.I, atom.Em:
+		f.inline(node, "_")
+		return
+	case atom.S, atom.Strike, atom.Del:
+		f.inline(node, "~~")
+		return
+	case atom.Code, atom.Kbd, atom.Samp:
+		if f.pre == 0 {
+			f.code++
+			f.inline(node, "`")
+			f.code--
+			return
+		}
+	case atom.A:
+		f.link(node)
+		return
+	case atom.Td, atom.Th:
+		if previousElement(node) != nil {
+			f.raw(" | ")
+		}
+		f.children(node)
+		return
+	}
+
+	// Lists nested in a list item stay attached to it
+	paragraph := paragraphElements[node.DataAtom] &&
+		!((node.DataAtom == atom.Ul || node.DataAtom == atom.Ol) && len(f.lists) > 0)
+	if paragraph {
+		f.paragraph()
+	} else if blockElements[node.DataAtom] || paragraphElements[node.DataAtom] {
+		f.endLine()
+	}
+	if dir := attr(node, "dir"); dir == "ltr" || dir == "rtl" {
+		saved := f.rtl
+		f.rtl = dir == "rtl"
+		defer func() { f.rtl = saved }()
+	}
+	if role, ok := elementRoles[node.DataAtom]; ok {
+		saved := f.role
+		f.role = role
+		defer func() { f.role = saved }()
+	}
+
+	switch node.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		if f.markdown {
+			f.raw(strings.Repeat("#", headingLevels[node.DataAtom]) + " ")
+		}
+		f.children(node)
+	case atom.Ul, atom.Ol:
+		l := &list{ordered: node.DataAtom == atom.Ol, next: 1, indent: 2}
+		if l.ordered {
+			l.indent = 3
+		}
+		if start, err := strconv.Atoi(attr(node, "start")); err == nil && l.ordered {
+			l.next = start
+		}
+		f.lists = append(f.lists, l)
+		f.children(node)
+		f.lists = f.lists[:len(f.lists)-1]
+	case atom.Li:
+		if len(f.lists) == 0 {
+			f.children(node)
+			break
+		}
+		l := f.lists[len(f.lists)-1]
+		if l.ordered {
+			f.marker = strconv.Itoa(l.next) + ". "
+			l.next++
+		} else {
+			f.marker = "- "
+		}
+		f.startLine()
+		f.children(node)
+	case atom.Blockquote:
+		f.quotes++
+		f.children(node)
+		f.endLine()
+		f.quotes--
+	case atom.Pre:
+		if f.markdown {
+			f.raw("```")
+			f.endLine()
+		}
+		f.pre++
+		f.children(node)
+		f.pre--
+		f.endLine()
+		if f.markdown {
+			f.raw("```")
+		}
+	default:
+		f.children(node)
+	}
+
+	if paragraph {
+		f.paragraph()
+	} else if blockElements[node.DataAtom] || paragraphElements[node.DataAtom] {
+		f.endLine()
+	}
+}
+
+func (f *flattener) link(node *html.Node) {
+	href := attr(node, "href")
+	if !safeURL(href, "http", "https", "mailto") || href == "" {
+		f.children(node)
+		return
+	}
+	if !f.markdown {
+		f.children(node)
+		if text := strings.TrimSpace(textContent(node)); text != href {
+			f.separate()
+			f.raw("(" + href + ")")
+		}
+		return
+	}
+	f.separate()
+	f.raw("[")
+	f.space = true
+	f.children(node)
+	f.space = false
+	f.raw("](" + markdownURL(href) + ")")
+}
+
+func (f *flattener) image(node *html.Node) {
+	alt := strings.Join(strings.Fields(attr(node, "alt")), " ")
+	src := attr(node, "src")
+	if !f.markdown || !safeURL(src, "http", "https") || src == "" {
+		if alt != "" {
+			if !f.open {
+				f.startLine()
+				f.current.alt = alt
+			}
+			f.separate()
+			f.raw(alt)
+		}
+		return
+	}
+	f.separate()
+	f.raw("![" + escapeMarkdown(alt) + "](" + markdownURL(src) + ")")
+}
+
+// markdownURL keeps a destination from terminating the link early
+func markdownURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(u)
+}
+
+func attr(node *html.Node, key string) string {
+	for _, a := range node.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var b strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+func previousElement(node *html.Node) *html.Node {
+	for sibling := node.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
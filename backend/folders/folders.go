@@ -0,0 +1,361 @@
+// Package folders organizes each user's documents into a tree of folders
+// for the sidebar. A user's workspace holds the folders they created and
+// where each of their documents is filed; documents not filed anywhere
+// sit at the root.
+//
+// Folders carry permissions their documents inherit: setting them applies
+// them to every document beneath, and a document filed into a folder takes
+// those of the folder. A folder without a setting of its own inherits it
+// from its parent.
+package folders
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"backend/document"
+	"backend/sanitize"
+)
+
+// MaxNameLength bounds folder names
+const MaxNameLength = 100
+
+var (
+	ErrNotFound = errors.New("folder not found")
+	ErrInvalid  = errors.New("invalid folder")
+	ErrCycle    = errors.New("a folder can't be moved into itself or one of its subfolders")
+	ErrNotEmpty = errors.New("folder is not empty")
+)
+
+// Permissions are the document permissions a folder passes down. Empty
+// fields are inherited from the parent folder.
+type Permissions struct {
+	Export string `json:"export,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+// Validate checks the settings are document permission values
+func (permissions Permissions) Validate() error {
+	if permissions.Export != "" && !slices.Contains([]string{document.ExportOwners, document.ExportEditors, document.ExportAnyone}, permissions.Export) {
+		return fmt.Errorf("%w: export must be owners, editors or anyone", ErrInvalid)
+	}
+	if permissions.Mode != "" && !slices.Contains([]string{document.ModeEditing, document.ModeLocked, document.ModeSuggesting}, permissions.Mode) {
+		return fmt.Errorf("%w: mode must be editing, locked or suggesting", ErrInvalid)
+	}
+	return nil
+}
+
+// Folder is a folder of a workspace. ParentID is empty for folders at
+// the root.
+type Folder struct {
+	ID          string      `json:"id"`
+	ParentID    string      `json:"parentId,omitempty"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// Workspace is a user's folders and where their documents are filed,
+// document ID to folder ID, an empty folder ID being the root
+type Workspace struct {
+	Owner     string            `json:"owner"`
+	Folders   []Folder          `json:"folders"`
+	Documents map[string]string `json:"documents"`
+}
+
+// NewWorkspace is the empty workspace of owner
+func NewWorkspace(owner string) Workspace {
+	return Workspace{Owner: owner, Folders: []Folder{}, Documents: make(map[string]string)}
+}
+
+// Store keeps one workspace per user. LoadWorkspace returns an empty one
+// for a user who has none yet.
+type Store interface {
+	LoadWorkspace(ctx context.Context, owner string) (Workspace, error)
+	SaveWorkspace(ctx context.Context, workspace Workspace) error
+}
+
+// MemoryStore is the Store used when no storage DSN is configured.
+// Workspaces last until the server restarts.
+type MemoryStore struct {
+	mutex      sync.Mutex
+	workspaces map[string]Workspace
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{workspaces: make(map[string]Workspace)}
+}
+
+func (store *MemoryStore) LoadWorkspace(_ context.Context, owner string) (Workspace, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	workspace, ok := store.workspaces[owner]
+	if !ok {
+		return NewWorkspace(owner), nil
+	}
+	return workspace.clone(), nil
+}
+
+func (store *MemoryStore) SaveWorkspace(_ context.Context, workspace Workspace) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.workspaces[workspace.Owner] = workspace.clone()
+	return nil
+}
+
+func (workspace Workspace) clone() Workspace {
+	workspace.Folders = slices.Clone(workspace.Folders)
+	documents := make(map[string]string, len(workspace.Documents))
+	for docID, folderID := range workspace.Documents {
+		documents[docID] = folderID
+	}
+	workspace.Documents = documents
+	return workspace
+}
+
+func validateName(name string) error {
+	if strings.TrimSpace(name) == "" || utf8.RuneCountInString(name) > MaxNameLength || sanitize.HasControl(name, false) {
+		return fmt.Errorf("%w: name must be a single line of 1 to %d characters", ErrInvalid, MaxNameLength)
+	}
+	return nil
+}
+
+// Folder returns the folder with id
+func (workspace *Workspace) Folder(id string) (*Folder, error) {
+	for i := range workspace.Folders {
+		if workspace.Folders[i].ID == id {
+			return &workspace.Folders[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// checkParent fails unless parentID is the root or a folder
+func (workspace *Workspace) checkParent(parentID string) error {
+	if parentID == "" {
+		return nil
+	}
+	_, err := workspace.Folder(parentID)
+	return err
+}
+
+// Add adds folder, which must have an ID, under its parent
+func (workspace *Workspace) Add(folder Folder) error {
+	if err := validateName(folder.Name); err != nil {
+		return err
+	}
+	if err := folder.Permissions.Validate(); err != nil {
+		return err
+	}
+	if err := workspace.checkParent(folder.ParentID); err != nil {
+		return err
+	}
+	workspace.Folders = append(workspace.Folders, folder)
+	return nil
+}
+
+// Rename renames the folder id
+func (workspace *Workspace) Rename(id string, name string, now time.Time) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	folder, err := workspace.Folder(id)
+	if err != nil {
+		return err
+	}
+	folder.Name = name
+	folder.UpdatedAt = now
+	return nil
+}
+
+// Move moves the folder id under parentID, which must not be the folder
+// itself or beneath it
+func (workspace *Workspace) Move(id string, parentID string, now time.Time) error {
+	folder, err := workspace.Folder(id)
+	if err != nil {
+		return err
+	}
+	if err := workspace.checkParent(parentID); err != nil {
+		return err
+	}
+	for ancestor := parentID; ancestor != ""; {
+		if ancestor == id {
+			return ErrCycle
+		}
+		parent, err := workspace.Folder(ancestor)
+		if err != nil {
+			return err
+		}
+		ancestor = parent.ParentID
+	}
+	folder.ParentID = parentID
+	folder.UpdatedAt = now
+	return nil
+}
+
+// SetPermissions replaces the permissions the folder id passes down
+func (workspace *Workspace) SetPermissions(id string, permissions Permissions, now time.Time) error {
+	if err := permissions.Validate(); err != nil {
+		return err
+	}
+	folder, err := workspace.Folder(id)
+	if err != nil {
+		return err
+	}
+	folder.Permissions = permissions
+	folder.UpdatedAt = now
+	return nil
+}
+
+// Remove removes the folder id, which must hold neither folders nor
+// documents
+func (workspace *Workspace) Remove(id string) error {
+	if _, err := workspace.Folder(id); err != nil {
+		return err
+	}
+	for _, folder := range workspace.Folders {
+		if folder.ParentID == id {
+			return ErrNotEmpty
+		}
+	}
+	for _, folderID := range workspace.Documents {
+		if folderID == id {
+			return ErrNotEmpty
+		}
+	}
+	workspace.Folders = slices.DeleteFunc(workspace.Folders, func(folder Folder) bool {
+		return folder.ID == id
+	})
+	return nil
+}
+
+// File files the document docID in folderID, or at the root
+func (workspace *Workspace) File(docID string, folderID string) error {
+	if err := workspace.checkParent(folderID); err != nil {
+		return err
+	}
+	if workspace.Documents == nil {
+		workspace.Documents = make(map[string]string)
+	}
+	workspace.Documents[docID] = folderID
+	return nil
+}
+
+// Effective returns the permissions the folder id passes down, its own
+// settings completed with those inherited from its ancestors. The root
+// passes none.
+func (workspace *Workspace) Effective(id string) Permissions {
+	var effective Permissions
+	for id != "" {
+		folder, err := workspace.Folder(id)
+		if err != nil {
+			break
+		}
+		if effective.Export == "" {
+			effective.Export = folder.Permissions.Export
+		}
+		if effective.Mode == "" {
+			effective.Mode = folder.Permissions.Mode
+		}
+		id = folder.ParentID
+	}
+	return effective
+}
+
+// DocumentsBeneath returns the documents filed in the folder id or any of
+// its subfolders, with the folder each is filed in
+func (workspace *Workspace) DocumentsBeneath(id string) map[string]string {
+	beneath := map[string]bool{id: true}
+	// Parents may come after their children, so repeat until stable
+	for grown := true; grown; {
+		grown = false
+		for _, folder := range workspace.Folders {
+			if beneath[folder.ParentID] && !beneath[folder.ID] {
+				beneath[folder.ID] = true
+				grown = true
+			}
+		}
+	}
+	documents := make(map[string]string)
+	for docID, folderID := range workspace.Documents {
+		if beneath[folderID] {
+			documents[docID] = folderID
+		}
+	}
+	return documents
+}
+
+// TreeDocument is a document as listed in the tree
+type TreeDocument struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+
+	// Revision is the document's; Unread is how many revisions of it the
+	// workspace's owner hasn't seen
+	Revision int64 `json:"revision"`
+	Unread   int64 `json:"unread"`
+}
+
+// TreeFolder is a folder with its subfolders and documents
+type TreeFolder struct {
+	Folder
+	Folders   []TreeFolder   `json:"folders"`
+	Documents []TreeDocument `json:"documents"`
+}
+
+// Tree is a workspace nested for the sidebar: the folders and documents
+// at the root, each level sorted by name
+type Tree struct {
+	Folders   []TreeFolder   `json:"folders"`
+	Documents []TreeDocument `json:"documents"`
+}
+
+// Tree nests the workspace. describe returns a document as listed, or
+// false for one that no longer exists, which is left out.
+func (workspace *Workspace) Tree(describe func(docID string) (TreeDocument, bool)) Tree {
+	children := make(map[string][]Folder)
+	for _, folder := range workspace.Folders {
+		children[folder.ParentID] = append(children[folder.ParentID], folder)
+	}
+	documents := make(map[string][]TreeDocument)
+	for docID, folderID := range workspace.Documents {
+		if document, ok := describe(docID); ok {
+			documents[folderID] = append(documents[folderID], document)
+		}
+	}
+
+	var nest func(parentID string) ([]TreeFolder, []TreeDocument)
+	nest = func(parentID string) ([]TreeFolder, []TreeDocument) {
+		subfolders := make([]TreeFolder, 0, len(children[parentID]))
+		for _, folder := range children[parentID] {
+			node := TreeFolder{Folder: folder}
+			node.Folders, node.Documents = nest(folder.ID)
+			subfolders = append(subfolders, node)
+		}
+		slices.SortFunc(subfolders, func(a, b TreeFolder) int {
+			return cmp.Or(cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), cmp.Compare(a.ID, b.ID))
+		})
+		filed := slices.Clone(documents[parentID])
+		if filed == nil {
+			filed = []TreeDocument{}
+		}
+		slices.SortFunc(filed, func(a, b TreeDocument) int {
+			return cmp.Or(cmp.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)), cmp.Compare(a.ID, b.ID))
+		})
+		return subfolders, filed
+	}
+
+	var tree Tree
+	tree.Folders, tree.Documents = nest("")
+	return tree
+}
@@ -0,0 +1,97 @@
+// Package health serves the liveness and readiness probes: each runs a set
+// of named checks and answers 200 when all pass, 503 otherwise, with the
+// result of every check as JSON
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Statuses of a check and of the whole probe
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Check reports whether one dependency works. It must return once ctx
+// ends.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check
+type Result struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Report is the response of a probe
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs its checks concurrently on every request, each bounded by
+// the timeout
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  []Check
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add adds a check reported under name
+func (checker *Checker) Add(name string, check Check) {
+	checker.names = append(checker.names, name)
+	checker.checks = append(checker.checks, check)
+}
+
+// Run runs every check and reports whether all passed
+func (checker *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, checker.timeout)
+	defer cancel()
+
+	results := make([]Result, len(checker.checks))
+	var wg sync.WaitGroup
+	for i, check := range checker.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			results[i] = Result{Status: StatusOK, DurationMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				results[i].Status = StatusUnavailable
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(results))}
+	for i, result := range results {
+		report.Checks[checker.names[i]] = result
+		if result.Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+func (checker *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := checker.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
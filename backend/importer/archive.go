@@ -0,0 +1,258 @@
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"backend/richtext"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Editors whose exported workspaces can be imported
+const (
+	SourceNotion     = "notion"
+	SourceGoogleDocs = "gdocs"
+	SourcePaper      = "paper"
+)
+
+var (
+	ErrUnsupportedSource = errors.New("unsupported import source")
+	ErrInvalidArchive    = errors.New("upload is not a zip archive")
+	ErrFileNotFound      = errors.New("file is not in the archive")
+	ErrFileTooLarge      = errors.New("file is too large")
+)
+
+// Notion appends the ID of a page to the names of its file and of the
+// directory holding its subpages and images
+var notionID = regexp.MustCompile(`\s+[0-9a-f]{32}$`)
+
+// Sources lists the editors workspaces can be imported from
+func Sources() []string {
+	return []string{SourceNotion, SourceGoogleDocs, SourcePaper}
+}
+
+// Archive is a workspace exported from another editor as a zip archive:
+// Notion's Markdown or HTML export, Google Docs downloaded as web pages or
+// a Dropbox Paper export. Its directories holding documents are Folders,
+// its Markdown, HTML and text files Pages, and the images pages embed are
+// read with Open.
+type Archive struct {
+	Source  string
+	Folders []Folder
+	Pages   []Page
+
+	files  map[string]*zip.File
+	opened map[string]bool
+}
+
+// Folder is a directory of an archive at Path, holding pages directly or
+// in its subdirectories. Parent is the path of the directory holding it,
+// empty at the root.
+type Folder struct {
+	Path   string
+	Parent string
+	Name   string
+}
+
+// Page is a document of an archive at Path, in the directory Folder
+type Page struct {
+	Path   string
+	Folder string
+	Title  string
+}
+
+// OpenArchive reads the zip archive r of size bytes exported from source.
+// Directories every file is in, which exports tend to wrap a workspace
+// in, are left out of the paths, and so are hidden files.
+func OpenArchive(source string, r io.ReaderAt, size int64) (*Archive, error) {
+	if !slices.Contains(Sources(), source) {
+		return nil, ErrUnsupportedSource
+	}
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrInvalidArchive
+	}
+
+	entries := make(map[string]*zip.File)
+	var names []string
+	for _, file := range reader.File {
+		name := path.Clean(strings.ReplaceAll(file.Name, `\`, "/"))
+		if file.FileInfo().IsDir() || !fs.ValidPath(name) || hidden(name) {
+			continue
+		}
+		entries[name] = file
+		names = append(names, name)
+	}
+	root := commonDir(names)
+
+	archive := &Archive{
+		Source: source,
+		files:  make(map[string]*zip.File, len(entries)),
+		opened: make(map[string]bool),
+	}
+	for name, file := range entries {
+		archive.files[strings.TrimPrefix(name, root)] = file
+	}
+	folders := make(map[string]Folder)
+	for _, name := range slices.Sorted(maps.Keys(archive.files)) {
+		if FormatFromFilename(name) == "" {
+			continue
+		}
+		dir := parentDir(name)
+		archive.Pages = append(archive.Pages, Page{
+			Path:   name,
+			Folder: dir,
+			Title:  archive.cleanName(strings.TrimSuffix(path.Base(name), path.Ext(name))),
+		})
+		for ; dir != ""; dir = parentDir(dir) {
+			folders[dir] = Folder{Path: dir, Parent: parentDir(dir), Name: archive.cleanName(path.Base(dir))}
+		}
+	}
+	// A directory sorts before those inside it, so parents come first
+	for _, dir := range slices.Sorted(maps.Keys(folders)) {
+		archive.Folders = append(archive.Folders, folders[dir])
+	}
+	return archive, nil
+}
+
+// Open returns the file at name, which the caller closes
+func (archive *Archive) Open(name string) (io.ReadCloser, error) {
+	file, ok := archive.files[name]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	archive.opened[name] = true
+	return file.Open()
+}
+
+// Unused lists the files of the archive that are neither pages nor were
+// opened, such as Notion's CSV databases or images no page embeds
+func (archive *Archive) Unused() []string {
+	var unused []string
+	for name := range archive.files {
+		if !archive.opened[name] && FormatFromFilename(name) == "" {
+			unused = append(unused, name)
+		}
+	}
+	slices.Sort(unused)
+	return unused
+}
+
+// Convert reads page, of at most maxSize bytes, into the document model.
+// Each image it embeds by a path relative to it is passed to upload with
+// its path in the archive, which returns the URL it is served at from now
+// on; images upload fails on are dropped.
+func (archive *Archive) Convert(page Page, maxSize int64, upload func(name string) (string, error)) (richtext.Delta, error) {
+	body, err := archive.Open(page.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	raw, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > maxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	format := FormatFromFilename(page.Path)
+	source := normalize(raw)
+	if format == "txt" {
+		return fromText(source)
+	}
+	rendered, err := toHTML(format, source)
+	if err != nil {
+		return nil, err
+	}
+	if rendered, err = embedImages(rendered, page.Folder, upload); err != nil {
+		return nil, err
+	}
+	return richtext.FromHTML(rendered)
+}
+
+// cleanName is the name a file or directory of the archive is given, as
+// Notion's page IDs are no part of it
+func (archive *Archive) cleanName(name string) string {
+	if archive.Source == SourceNotion {
+		name = notionID.ReplaceAllString(name, "")
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		return "Untitled"
+	}
+	return name
+}
+
+// embedImages points the images of document given by a path relative to
+// dir at the URLs upload returns for them
+func embedImages(document string, dir string, upload func(name string) (string, error)) (string, error) {
+	root, err := nethtml.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+	for node := range root.Descendants() {
+		if node.Type != nethtml.ElementNode || node.DataAtom != atom.Img {
+			continue
+		}
+		for i, attribute := range node.Attr {
+			if attribute.Key != "src" {
+				continue
+			}
+			src, err := url.Parse(strings.TrimSpace(attribute.Val))
+			if err != nil || src.Scheme != "" || src.Host != "" || src.Path == "" || strings.HasPrefix(src.Path, "/") {
+				continue
+			}
+			if uploaded, err := upload(path.Join(dir, src.Path)); err == nil {
+				node.Attr[i].Val = uploaded
+			}
+		}
+	}
+	var rendered strings.Builder
+	if err := nethtml.Render(&rendered, root); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// commonDir is the directory, with a trailing slash, every name is in
+func commonDir(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	dir := parentDir(names[0])
+	for ; dir != ""; dir = parentDir(dir) {
+		if !slices.ContainsFunc(names, func(name string) bool { return !strings.HasPrefix(name, dir+"/") }) {
+			return dir + "/"
+		}
+	}
+	return ""
+}
+
+// parentDir is the directory holding name, empty at the root
+func parentDir(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// hidden reports whether name is or is in a hidden file or directory,
+// such as the __MACOSX directory macOS adds to the archives it makes
+func hidden(name string) bool {
+	for segment := range strings.SplitSeq(name, "/") {
+		if strings.HasPrefix(segment, ".") || segment == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+// Package importer converts uploaded files into the rich-text document model
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"backend/richtext"
+
+	"github.com/yuin/goldmark"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported import format")
+
+// FormatFromFilename picks the import format from a file extension
+func FormatFromFilename(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return "md"
+	case ".html", ".htm":
+		return "html"
+	case ".txt", ".text":
+		return "txt"
+	}
+	return ""
+}
+
+// Convert reads a Markdown, HTML or plain text document. Formatting the
+// document model has no place for, such as images or tables, is dropped.
+func Convert(format string, r io.Reader) (richtext.Delta, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	source := normalize(raw)
+
+	switch strings.ToLower(format) {
+	case "txt":
+		return fromText(source)
+	case "md", "html":
+		rendered, err := toHTML(format, source)
+		if err != nil {
+			return nil, err
+		}
+		return richtext.FromHTML(rendered)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// normalize makes raw valid UTF-8 with Unix line endings
+func normalize(raw []byte) string {
+	return strings.ToValidUTF8(strings.ReplaceAll(string(raw), "\r\n", "\n"), "�")
+}
+
+// toHTML renders Markdown source as HTML and returns HTML as it is
+func toHTML(format string, source string) (string, error) {
+	if strings.ToLower(format) != "md" {
+		return source, nil
+	}
+	// goldmark leaves raw HTML out unless told otherwise, and only safe
+	// links survive the conversion to the document model
+	var rendered bytes.Buffer
+	if err := goldmark.Convert([]byte(source), &rendered); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// fromText keeps every line of plain text as an unformatted line
+func fromText(source string) (richtext.Delta, error) {
+	text := strings.TrimSuffix(source, "\n") + "\n"
+	return richtext.Normalize(richtext.Delta{{Insert: text}})
+}
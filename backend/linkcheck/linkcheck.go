@@ -0,0 +1,291 @@
+// Package linkcheck periodically follows the external links in documents
+// and keeps a report of the broken ones
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"backend/document"
+	"backend/richtext"
+)
+
+// Links checked at the same time across all documents
+const concurrency = 4
+
+// A URL's result is reused for this long, so a link shared by many
+// documents is only requested once per run
+const resultTTL = 10 * time.Minute
+
+var errInternalAddress = errors.New("link points to an internal address")
+
+// Finding is a link that could not be followed. Status is the HTTP status
+// when the server answered, Error the failure otherwise.
+type Finding struct {
+	URL    string `json:"url"`
+	Text   string `json:"text"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the outcome of checking a document's links at a revision
+type Report struct {
+	DocID     string    `json:"docId"`
+	Revision  int64     `json:"revision"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Links     int       `json:"links"`
+	Broken    []Finding `json:"broken"`
+}
+
+type result struct {
+	status    int
+	err       error
+	checkedAt time.Time
+}
+
+// Checker checks the links of every loaded document and keeps the latest
+// report of each
+type Checker struct {
+	documents *document.Registry
+	client    *http.Client
+	logger    *slog.Logger
+
+	mutex   sync.Mutex
+	reports map[string]Report
+	results map[string]result
+	notify  func(Report)
+
+	done chan struct{}
+	once sync.Once
+}
+
+func NewChecker(documents *document.Registry, timeout time.Duration, logger *slog.Logger) *Checker {
+	// Links come from anyone who can edit a document, so they must not be
+	// a way to reach the server's own network
+	dialer := &net.Dialer{Timeout: timeout, Control: refuseInternal}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        concurrency,
+	}
+	return &Checker{
+		documents: documents,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		logger:  logger,
+		reports: make(map[string]Report),
+		results: make(map[string]result),
+		done:    make(chan struct{}),
+	}
+}
+
+func refuseInternal(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return errInternalAddress
+	}
+	return nil
+}
+
+// OnReport registers fn to be called with every report whose broken links
+// differ from the previous one
+func (checker *Checker) OnReport(fn func(Report)) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.notify = fn
+}
+
+// Report returns the latest report of a document
+func (checker *Checker) Report(docID string) (Report, bool) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	report, ok := checker.reports[docID]
+	return report, ok
+}
+
+// Forget drops the report of a document that is no longer loaded
+func (checker *Checker) Forget(docID string) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	delete(checker.reports, docID)
+}
+
+// Run checks every document each interval until Close is called
+func (checker *Checker) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			checker.CheckAll()
+		case <-checker.done:
+			return
+		}
+	}
+}
+
+// Close stops a running check loop
+func (checker *Checker) Close() {
+	checker.once.Do(func() { close(checker.done) })
+}
+
+// CheckAll checks the links of every loaded document
+func (checker *Checker) CheckAll() {
+	checker.pruneResults()
+	for _, doc := range checker.documents.All() {
+		select {
+		case <-checker.done:
+			return
+		default:
+		}
+		checker.Check(doc)
+	}
+}
+
+// Check checks a document's links and records the report
+func (checker *Checker) Check(doc *document.Document) Report {
+	content, revision := doc.Contents()
+	links := Extract(content)
+
+	broken := make([]Finding, len(links))
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, concurrency)
+	for i, link := range links {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+			broken[i] = checker.follow(link)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{DocID: doc.ID, Revision: revision, CheckedAt: time.Now(), Links: len(links), Broken: []Finding{}}
+	for _, finding := range broken {
+		if finding.Status != 0 || finding.Error != "" {
+			report.Broken = append(report.Broken, finding)
+		}
+	}
+
+	checker.mutex.Lock()
+	previous, checked := checker.reports[doc.ID]
+	checker.reports[doc.ID] = report
+	notify := checker.notify
+	checker.mutex.Unlock()
+
+	if notify != nil && (!checked || !slices.Equal(previous.Broken, report.Broken)) {
+		notify(report)
+	}
+	return report
+}
+
+// follow returns a finding for link if it is broken, or a zero Finding
+func (checker *Checker) follow(link Finding) Finding {
+	checker.mutex.Lock()
+	cached, ok := checker.results[link.URL]
+	checker.mutex.Unlock()
+	if !ok || time.Since(cached.checkedAt) > resultTTL {
+		cached = checker.request(link.URL)
+		checker.mutex.Lock()
+		checker.results[link.URL] = cached
+		checker.mutex.Unlock()
+	}
+
+	switch {
+	case errors.Is(cached.err, errInternalAddress):
+		return Finding{}
+	case cached.err != nil:
+		link.Error = cached.err.Error()
+	case cached.status >= 400:
+		link.Status = cached.status
+	default:
+		return Finding{}
+	}
+	return link
+}
+
+// request tries HEAD first and falls back to GET for servers that don't
+// support it
+func (checker *Checker) request(link string) result {
+	status, err := checker.do(http.MethodHead, link)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented || status == http.StatusForbidden) {
+		status, err = checker.do(http.MethodGet, link)
+	}
+	return result{status: status, err: err, checkedAt: time.Now()}
+}
+
+func (checker *Checker) do(method string, link string) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "collab-linkcheck/1")
+	resp, err := checker.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (checker *Checker) pruneResults() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	for link, cached := range checker.results {
+		if time.Since(cached.checkedAt) > resultTTL {
+			delete(checker.results, link)
+		}
+	}
+}
+
+// Extract returns the external links of a document once each, with the
+// text they are first attached to
+func Extract(content richtext.Delta) []Finding {
+	var links []Finding
+	seen := make(map[string]int)
+	previous := ""
+	for _, op := range content {
+		link, _ := op.Attributes["link"].(string)
+		if i, ok := seen[link]; ok {
+			// Formatting splits link text into several inserts
+			if link == previous && op.Image == nil && i == len(links)-1 {
+				links[i].Text += op.Insert
+			}
+			continue
+		}
+		previous = link
+		parsed, err := url.Parse(link)
+		if link == "" || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			continue
+		}
+		seen[link] = len(links)
+		text := op.Insert
+		if op.Image != nil {
+			text = op.Image.Alt
+		}
+		links = append(links, Finding{URL: link, Text: text})
+	}
+	return links
+}
@@ -0,0 +1,123 @@
+// Package mail sends the emails the server writes to people: invitations
+// to documents shared with them and digests of what they missed. Bodies are
+// rendered from templates, as HTML with a plain text alternative.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/ids"
+)
+
+// Message is an email to the addresses in To
+type Message struct {
+	To      []string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Sender delivers messages
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// SMTPSender sends through an SMTP server, upgrading to TLS when the
+// server offers STARTTLS and authenticating with PLAIN auth when it has a
+// username
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+	from mail.Address
+}
+
+func NewSMTPSender(host string, port int, username string, password string, from string) (*SMTPSender, error) {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	sender := &SMTPSender{addr: host + ":" + strconv.Itoa(port), from: *address}
+	if username != "" {
+		sender.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return sender, nil
+}
+
+// Send delivers message, giving up waiting once ctx is done. smtp.SendMail
+// can't be interrupted, so a send that is given up on may still go out.
+func (sender *SMTPSender) Send(ctx context.Context, message Message) error {
+	body, err := sender.encode(message, time.Now())
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(sender.addr, sender.auth, sender.from.Address, message.To, body)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// encode writes message as a multipart/alternative email
+func (sender *SMTPSender) encode(message Message, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+	for _, header := range [][2]string{
+		{"From", sender.from.String()},
+		{"To", strings.Join(message.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", "<" + ids.NewUUID() + "@" + domain(sender.from.Address) + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	} {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func domain(address string) string {
+	if _, host, ok := strings.Cut(address, "@"); ok {
+		return host
+	}
+	return "localhost"
+}
@@ -0,0 +1,119 @@
+package mail
+
+import (
+	htmltemplate "html/template"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Invitation is what an invitation to a shared document says
+type Invitation struct {
+	To        string
+	Sharer    string
+	Title     string
+	Role      string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// Digest is what a digest tells a user about what they missed
+type Digest struct {
+	To       string
+	UserName string
+	Items    []DigestItem
+}
+
+// DigestItem is a mention of the user or a comment on one of their
+// documents
+type DigestItem struct {
+	From  string
+	Title string
+	Link  string
+	Text  string
+	// Mention is set when the user was mentioned rather than commented to,
+	// Edited when they follow every change and the document was edited
+	Mention bool
+	Edited  bool
+	At      time.Time
+}
+
+var funcs = map[string]any{
+	"date": func(t time.Time) string { return t.UTC().Format("Jan 2, 15:04 MST") },
+}
+
+const invitationHTML = `<p>{{.Sharer}} invited you to {{if eq .Role "edit"}}edit{{else}}view{{end}} <strong>{{.Title}}</strong>.</p>
+<p><a href="{{.Link}}">Open the document</a></p>
+<p style="color:#666">The invitation is valid until {{date .ExpiresAt}}. You can turn off emails from us in your profile.</p>
+`
+
+const invitationText = `{{.Sharer}} invited you to {{if eq .Role "edit"}}edit{{else}}view{{end}} "{{.Title}}".
+
+Open the document: {{.Link}}
+
+The invitation is valid until {{date .ExpiresAt}}. You can turn off emails from us in your profile.
+`
+
+const digestHTML = `<p>Hi {{.UserName}}, here is what you missed.</p>
+<ul>
+{{- range .Items}}
+<li><strong>{{.From}}</strong> {{if .Mention}}mentioned you{{else if .Edited}}made changes{{else}}commented{{end}} in <a href="{{.Link}}">{{.Title}}</a> on {{date .At}}{{if .Text}}:<br><em>{{.Text}}</em>{{end}}</li>
+{{- end}}
+</ul>
+<p style="color:#666">You can turn off these digests in your profile.</p>
+`
+
+const digestText = `Hi {{.UserName}}, here is what you missed.
+{{range .Items}}
+* {{.From}} {{if .Mention}}mentioned you{{else if .Edited}}made changes{{else}}commented{{end}} in "{{.Title}}" on {{date .At}}{{if .Text}}:
+  {{.Text}}{{end}}
+  {{.Link}}
+{{end}}
+You can turn off these digests in your profile.
+`
+
+var (
+	invitationHTMLTemplate = htmltemplate.Must(htmltemplate.New("invitation").Funcs(funcs).Parse(invitationHTML))
+	invitationTextTemplate = texttemplate.Must(texttemplate.New("invitation").Funcs(funcs).Parse(invitationText))
+	digestHTMLTemplate     = htmltemplate.Must(htmltemplate.New("digest").Funcs(funcs).Parse(digestHTML))
+	digestTextTemplate     = texttemplate.Must(texttemplate.New("digest").Funcs(funcs).Parse(digestText))
+)
+
+// Message renders the invitation
+func (invitation Invitation) Message() (Message, error) {
+	if invitation.Title == "" {
+		invitation.Title = "Untitled document"
+	}
+	subject := invitation.Sharer + " shared \"" + invitation.Title + "\" with you"
+	return render([]string{invitation.To}, subject, invitation, invitationHTMLTemplate, invitationTextTemplate)
+}
+
+// Message renders the digest
+func (digest Digest) Message() (Message, error) {
+	for i := range digest.Items {
+		if digest.Items[i].Title == "" {
+			digest.Items[i].Title = "Untitled document"
+		}
+	}
+	subject := "You have " + plural(len(digest.Items), "new notification", "new notifications")
+	return render([]string{digest.To}, subject, digest, digestHTMLTemplate, digestTextTemplate)
+}
+
+func render(to []string, subject string, data any, html *htmltemplate.Template, text *texttemplate.Template) (Message, error) {
+	var htmlBody, textBody strings.Builder
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&textBody, data); err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject, HTML: htmlBody.String(), Text: textBody.String()}, nil
+}
+
+func plural(n int, one string, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return strconv.Itoa(n) + " " + many
+}
@@ -1,27 +1,406 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"backend/api"
+	"backend/backup"
+	"backend/blobs"
+	"backend/buildinfo"
+	"backend/canary"
+	"backend/config"
+	"backend/encryption"
+	"backend/events"
+	"backend/health"
+	"backend/logging"
+	"backend/mail"
+	"backend/metrics"
+	"backend/origins"
+	"backend/ownership"
+	"backend/presence"
+	"backend/proxies"
+	"backend/rbac"
+	"backend/recording"
+	"backend/secrets"
+	"backend/snapshots"
 	"backend/socket"
+	"backend/storage"
+	"backend/web"
+	"backend/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
 
+// How long the health probes wait for their checks
+const probeTimeout = 2 * time.Second
+
 func main() {
-	wsManager := socket.NewWebSocketManager()
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	command.run(args)
+}
+
+// runServer serves the editor until it is interrupted
+func runServer(args []string) {
+	cfg, _ := loadCommand("serve", args, nil)
+	logger, resolver := setup(cfg)
+	if resolver != nil && cfg.Secrets.RefreshInterval > 0 {
+		// Connections are only opened at startup, so a rotated secret
+		// takes effect on the next restart
+		resolver.OnChange(func(ref string) {
+			logger.Warn("Secret changed, restart to apply it", "ref", ref)
+		})
+		go resolver.Run(cfg.Secrets.RefreshInterval)
+	}
+	defer resolver.Close()
+
+	allowlist, err := origins.NewAllowlist(cfg.AllowedOrigins, cfg.DevMode)
+	if err != nil {
+		logger.Error("Allowed origins error", "error", err)
+		os.Exit(1)
+	}
+	if cfg.DevMode {
+		logger.Warn("Dev mode is on, every origin is allowed")
+	}
+
+	trustedProxies, err := proxies.New(cfg.TrustedProxies)
+	if err != nil {
+		logger.Error("Trusted proxies error", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.Cluster.NodeID == "" {
+		cfg.Cluster.NodeID, _ = os.Hostname()
+	}
+	wsManager := socket.NewWebSocketManager(cfg, logger)
+	wsManager.Origins = allowlist
+	wsManager.Proxies = trustedProxies
+	// Liveness only covers the server itself, so a dependency that is
+	// down takes the node out of rotation without getting it restarted
+	liveness := health.NewChecker(probeTimeout)
+	liveness.Add("manager", wsManager.Ping)
+	readiness := health.NewChecker(probeTimeout)
+	readiness.Add("manager", wsManager.Ping)
+	readiness.Add("accepting", wsManager.Accepting)
+	keys := openKeyring(cfg, logger)
+	if cfg.StorageDSN != "" {
+		store, err := storage.Open(cfg.StorageDSN, keys)
+		if err != nil {
+			logger.Error("Document store error", "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		wsManager.Documents.SetStore(store)
+		wsManager.Snapshots = store
+		wsManager.Keys = keys
+		wsManager.Templates = store
+		wsManager.Folders = store
+		wsManager.Users = store
+		wsManager.Audit.SetStore(store)
+		readiness.Add("storage", store.Ping)
+	} else {
+		logger.Warn("No storage DSN configured, documents, snapshots, templates, folders, accounts and audit trails are kept in memory only")
+	}
+	defer wsManager.Audit.Close()
+	if cfg.RedisURL != "" {
+		store, err := presence.NewRedisStore(cfg.RedisURL, cfg.PresenceTTL)
+		if err != nil {
+			logger.Error("Presence store error", "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		wsManager.Presence = store
+		readiness.Add("redis", store.Ping)
+	}
+	if cfg.Cluster.AdvertiseURL != "" {
+		store, err := ownership.NewRedisStore(cfg.RedisURL)
+		if err != nil {
+			logger.Error("Ownership store error", "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		wsManager.Owners = store
+		logger.Info("Sharing documents with other nodes", "node_id", cfg.Cluster.NodeID, "advertise_url", cfg.Cluster.AdvertiseURL)
+	}
+	var publishers events.Publishers
+	if endpoints := cfg.Webhooks.AllEndpoints(); len(endpoints) > 0 {
+		wsManager.Webhooks = webhooks.NewSender(webhookEndpoints(endpoints), webhooks.Options{
+			MaxAttempts: cfg.Webhooks.MaxAttempts,
+			Backoff:     cfg.Webhooks.Backoff,
+			Timeout:     cfg.Webhooks.Timeout,
+			LogSize:     cfg.Webhooks.LogSize,
+		}, logger)
+		publishers = append(publishers, wsManager.Webhooks)
+		logger.Info("Webhooks enabled", "endpoints", len(endpoints))
+	}
+	if cfg.Mail.Host != "" {
+		sender, err := mail.NewSMTPSender(cfg.Mail.Host, cfg.Mail.Port, cfg.Mail.Username, cfg.Mail.Password, cfg.Mail.From)
+		if err != nil {
+			logger.Error("Mail error", "error", err)
+			os.Exit(1)
+		}
+		wsManager.Mailer = sender
+		logger.Info("Email enabled", "host", cfg.Mail.Host, "digest_interval", cfg.Mail.DigestInterval)
+	}
+	var backupStore blobs.Store
+	if cfg.Blobs.DSN != "" {
+		store, err := blobs.Open(cfg.Blobs.DSN, blobs.Options{
+			Prefix:     cfg.Blobs.Prefix,
+			Encryption: cfg.Blobs.Encryption,
+			KMSKeyID:   cfg.Blobs.KMSKeyID,
+		})
+		if err != nil {
+			logger.Error("Blob store error", "error", err)
+			os.Exit(1)
+		}
+		blobSnapshots := snapshots.NewBlobStore(blobs.WithPrefix(store, "snapshots"), wsManager.Snapshots)
+		blobSnapshots.Keys = keys
+		wsManager.Snapshots = blobSnapshots
+		wsManager.Exports = blobs.WithPrefix(store, "exports")
+		wsManager.Attachments = blobs.WithPrefix(store, "attachments")
+		wsManager.Takeouts = blobs.WithPrefix(store, "takeouts")
+		backupStore = blobs.WithPrefix(store, "backups")
+		logger.Info("Blob store enabled", "prefix", cfg.Blobs.Prefix, "encryption", cfg.Blobs.Encryption)
+	}
+	if cfg.Attachments.DSN != "" {
+		wsManager.Attachments, err = blobs.Open(cfg.Attachments.DSN, blobs.Options{})
+		if err != nil {
+			logger.Error("Attachment store error", "error", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.Backups.DSN != "" {
+		backupStore, err = blobs.Open(cfg.Backups.DSN, blobs.Options{})
+		if err != nil {
+			logger.Error("Backup store error", "error", err)
+			os.Exit(1)
+		}
+	}
+	if backupStore != nil {
+		wsManager.Backups = backup.New(backupStore, wsManager.Documents.Records, cfg.Backups.Keep, logger)
+		wsManager.Backups.Keys = keys
+		if cfg.Backups.Interval > 0 {
+			go wsManager.Backups.Run(cfg.Backups.Interval)
+			defer wsManager.Backups.Close()
+		}
+		logger.Info("Backups enabled", "interval", cfg.Backups.Interval, "keep", cfg.Backups.Keep)
+	}
+	if len(cfg.Kafka.Brokers) > 0 {
+		publishers = append(publishers, events.NewKafkaPublisher(cfg.Kafka.Brokers, cfg.Kafka.TopicPrefix))
+	}
+	if len(publishers) > 0 {
+		wsManager.Events = events.NewDispatcher(publishers, logger)
+	}
+	defer wsManager.Events.Close()
+	if cfg.EventLog.DSN != "" {
+		log, err := events.OpenLog(cfg.EventLog.DSN)
+		if err != nil {
+			logger.Error("Event log error", "error", err)
+			os.Exit(1)
+		}
+		wsManager.EventLog = events.NewDispatcher(log, logger)
+		logger.Info("Event log enabled")
+	}
+	defer wsManager.EventLog.Close()
+	if cfg.Canary.Percent > 0 {
+		engine, err := canary.NewEngine(cfg.Canary.Engine)
+		if err != nil {
+			logger.Error("Canary engine error", "error", err)
+			os.Exit(1)
+		}
+		wsManager.Canary = canary.NewRunner(engine, cfg.Canary.Percent, logger)
+		logger.Info("Canary engine enabled", "engine", cfg.Canary.Engine, "percent", cfg.Canary.Percent)
+	}
+	if cfg.Recording.Percent > 0 {
+		sink, err := recording.OpenSink(cfg.Recording.DSN)
+		if err != nil {
+			logger.Error("Recording sink error", "error", err)
+			os.Exit(1)
+		}
+		wsManager.Recorder, err = recording.NewRecorder(sink, cfg.Recording.Percent, cfg.Recording.Scrub, logger)
+		if err != nil {
+			logger.Error("Recording error", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Recording sampled rooms", "percent", cfg.Recording.Percent, "scrub", cfg.Recording.Scrub)
+	}
+	defer wsManager.Recorder.Close()
 	go wsManager.Run()
+	if cfg.LinkCheck.Interval > 0 {
+		go wsManager.Links.Run(cfg.LinkCheck.Interval)
+	}
+	defer wsManager.Links.Close()
+	if wsManager.Analysis != nil {
+		go wsManager.Analysis.Run()
+		logger.Info("Document analysis enabled", "url", cfg.Analysis.URL, "idle_delay", cfg.Analysis.IdleDelay)
+	}
+	defer wsManager.Analysis.Close()
 
 	router := gin.Default()
+	// Request logs and c.ClientIP() name the client behind the same
+	// proxies as the socket manager does
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Error("Trusted proxies error", "error", err)
+		os.Exit(1)
+	}
+	router.Use(allowlist.CORS())
 
-	router.Static("/static", "./static")
+	// Anything no route matched may be part of the web app
+	switch {
+	case cfg.Frontend.Dir != "":
+		logger.Info("Serving web app from a directory", "dir", cfg.Frontend.Dir, "base_path", cfg.Frontend.BasePath)
+	case web.Embedded():
+		logger.Info("Serving embedded web app", "base_path", cfg.Frontend.BasePath)
+	default:
+		logger.Warn("Web app not built into this binary, serving only the API")
+	}
+	router.NoRoute(gin.WrapH(web.Handler(web.Assets(cfg.Frontend.Dir), cfg.Frontend.BasePath)))
 
+	router.GET("/healthz", gin.WrapH(liveness))
+	router.GET("/readyz", gin.WrapH(readiness))
 	router.GET("/ws", func(c *gin.Context) {
 		wsManager.HandleWebSocketConnections(c.Writer, c.Request)
 	})
+	router.GET("/events", func(c *gin.Context) {
+		wsManager.HandleEventStream(c.Writer, c.Request)
+	})
+
+	authorizer, err := rbac.NewAuthorizer(cfg.APITokens)
+	if err != nil {
+		logger.Error("API token error", "error", err)
+		os.Exit(1)
+	}
+	// Bots authenticate with an API token instead of a session
+	router.GET("/ws/bot", authorizer.Require(rbac.ScopeBots), func(c *gin.Context) {
+		principal := rbac.PrincipalFrom(c)
+		wsManager.HandleBotConnections(c.Writer, c.Request, socket.Bot{Name: principal.Name, Tenant: principal.Tenant})
+	})
+	if authorizer.Enabled() {
+		router.GET("/metrics", authorizer.Require(rbac.ScopeMetrics), gin.WrapH(metrics.Handler()))
+	} else {
+		logger.Warn("No API tokens configured, metrics are public and admin endpoints are disabled")
+		router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	}
+
+	apiHandler := api.NewHandler(wsManager)
+	apiHandler.RegisterRoutes(router)
+	apiHandler.RegisterAdminRoutes(router, authorizer)
+	apiHandler.RegisterOpenAPI(router)
+	if cfg.GRPCAddr != "" {
+		grpcServer, err := serveGRPC(cfg, wsManager, authorizer, logger)
+		if err != nil {
+			logger.Error("gRPC server error", "error", err)
+			os.Exit(1)
+		}
+		defer grpcServer.Stop()
+	}
+
+	build := buildinfo.Get()
+	logger.Info("Server starting", "addr", cfg.ListenAddr, "tls", cfg.TLS.Enabled(), "version", build.Version, "commit", build.Commit)
+	// Clients are told to reconnect rather than cut off on shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := serve(ctx, router, cfg, logger, wsManager.Shutdown); err != nil {
+		logger.Error("Server error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// loadCommand loads the configuration for the command called name, whose
+// own flags commandFlags registers, and returns it with the arguments left
+// after the flags
+func loadCommand(name string, args []string, commandFlags func(fs *flag.FlagSet)) (*config.Config, []string) {
+	cfg, rest, err := config.LoadCommand(name, args, commandFlags)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal("Config error:", err)
+	}
+	return cfg, rest
+}
+
+// setup installs the configured logger and resolves the secrets the
+// configuration refers to
+func setup(cfg *config.Config) (*slog.Logger, *secrets.Resolver) {
+	logger, err := logging.New(cfg.Log, os.Stderr)
+	if err != nil {
+		log.Fatal("Logger error:", err)
+	}
+	slog.SetDefault(logger)
+
+	provider, err := secrets.NewProvider(cfg.Secrets)
+	if err != nil {
+		logger.Error("Secrets provider error", "error", err)
+		os.Exit(1)
+	}
+	var resolver *secrets.Resolver
+	if provider != nil {
+		resolver = secrets.NewResolver(provider, logger)
+	}
+	refs := []*string{&cfg.StorageDSN, &cfg.RedisURL, &cfg.Recording.DSN, &cfg.EventLog.DSN, &cfg.Attachments.DSN, &cfg.Blobs.DSN, &cfg.Backups.DSN,
+		&cfg.Migration.Token, &cfg.Share.Secret, &cfg.OAuth.Google.ClientSecret, &cfg.OAuth.GitHub.ClientSecret,
+		&cfg.Webhooks.Secret, &cfg.Mail.Password}
+	for i := range cfg.Webhooks.Endpoints {
+		refs = append(refs, &cfg.Webhooks.Endpoints[i].Secret)
+	}
+	// Map values can't be resolved in place, so encryption keys are
+	// resolved in copies and put back
+	keys := make(map[string]*string, len(cfg.Encryption.Keys))
+	for id, key := range cfg.Encryption.Keys {
+		keys[id] = &key
+		refs = append(refs, &key)
+	}
+	if err := resolver.Resolve(context.Background(), refs...); err != nil {
+		logger.Error("Secret resolution error", "error", err)
+		os.Exit(1)
+	}
+	for id, key := range keys {
+		cfg.Encryption.Keys[id] = *key
+	}
+	return logger, resolver
+}
+
+// openKeyring loads the keys documents are sealed with at rest. It returns
+// nil, leaving them in plaintext, when none are configured.
+func openKeyring(cfg *config.Config, logger *slog.Logger) *encryption.Keyring {
+	keys, err := encryption.Load(context.Background(), cfg.Encryption, logger)
+	if err != nil {
+		logger.Error("Encryption key error", "error", err)
+		os.Exit(1)
+	}
+	if keys != nil {
+		logger.Info("Encryption at rest enabled", "active_key", keys.Active, "keys", len(cfg.Encryption.Keys), "allow_plaintext", keys.AllowPlaintext)
+	}
+	return keys
+}
 
-	log.Println("Server starting on :8080")
-	if err := router.Run(":8080"); err != nil {
-		log.Fatal("Server error:", err)
+func webhookEndpoints(configured []config.WebhookEndpoint) []webhooks.Endpoint {
+	endpoints := make([]webhooks.Endpoint, len(configured))
+	for i, endpoint := range configured {
+		endpoints[i] = webhooks.Endpoint{URL: endpoint.URL, Secret: endpoint.Secret, Events: endpoint.Events}
 	}
+	return endpoints
 }
@@ -0,0 +1,271 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "collab"
+
+var (
+	ConnectedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_clients",
+		Help:      "Number of currently connected WebSocket clients.",
+	})
+
+	BotConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bot_connections",
+		Help:      "Number of connected bots, each counted in connected_clients once per room it subscribed to.",
+	})
+
+	RoomClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "room_clients",
+		Help:      "Number of connected clients per document room.",
+	}, []string{"doc_id"})
+
+	BroadcastMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcast_messages_total",
+		Help:      "Messages queued for fan-out to clients.",
+	})
+
+	RoomBroadcasters = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "room_broadcasters",
+		Help:      "Rooms with a running broadcast goroutine.",
+	})
+
+	OwnedDocuments = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "owned_documents",
+		Help:      "Documents this node holds the ownership lease on.",
+	})
+
+	ForwardedConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forwarded_connections_total",
+		Help:      "Connections forwarded to the node owning their document.",
+	})
+
+	CoalescedEdits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "coalesced_edits_total",
+		Help:      "Edits relayed in the same frame as an earlier one.",
+	})
+
+	DeliveredMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delivered_messages_total",
+		Help:      "Messages handed to client send buffers.",
+	})
+
+	MessageSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "message_size_bytes",
+		Help:      "Size of WebSocket messages by direction.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"direction"})
+
+	DroppedClients = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_clients_total",
+		Help:      "Clients removed because their send buffer was full.",
+	})
+
+	PlaintextReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plaintext_reads_total",
+		Help:      "Stored values read unsealed while encryption is enabled, by what was done: allowed while migrating, or refused.",
+	}, []string{"action"})
+
+	BackpressureActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backpressure_actions_total",
+		Help:      "Messages for clients with a full send buffer, by what was done: coalesced, dropped, resync or disconnected.",
+	}, []string{"action"})
+
+	EditSequenceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "edit_sequence_errors_total",
+		Help:      "Numbered edits received out of sequence, by kind: duplicate or gap.",
+	}, []string{"kind"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_attempts_total",
+		Help:      "Webhook delivery attempts, by result: delivered, retried or failed.",
+	}, []string{"result"})
+
+	OpsApplied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ops_applied_total",
+		Help:      "Document edit operations relayed to rooms.",
+	})
+
+	UpgradeFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_upgrade_failures_total",
+		Help:      "Failed WebSocket upgrade attempts.",
+	})
+
+	FramePayloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frame_payload_bytes_total",
+		Help:      "Outbound WebSocket frame bytes before compression, by whether the frame was compressed.",
+	}, []string{"compressed"})
+
+	FrameWireBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frame_wire_bytes_total",
+		Help:      "Outbound WebSocket frame bytes written to the network, by whether the frame was compressed.",
+	}, []string{"compressed"})
+
+	CompressionRatio = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "frame_compression_ratio",
+		Help:      "Wire size over payload size of compressed outbound frames.",
+		Buckets:   []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+	})
+
+	CanaryOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "canary_ops_total",
+		Help:      "Ops checked against the canary document engine, by engine and result.",
+	}, []string{"engine", "result"})
+
+	ClientErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_errors_total",
+		Help:      "Errors reported by clients, by kind.",
+	}, []string{"kind"})
+
+	RecordedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recorded_messages_total",
+		Help:      "Messages of sampled rooms handed to the recording sink, by result (recorded, dropped, error).",
+	}, []string{"result"})
+
+	Hibernations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hibernations_total",
+		Help:      "Idle documents hibernated and woken again, by event (hibernated, woken).",
+	}, []string{"event"})
+
+	LimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limit_rejections_total",
+		Help:      "Connections and edits refused for exceeding a configured limit, by limit (room-clients, rooms, user-connections, ip-connections, auth-attempts, document-size, document-ops).",
+	}, []string{"limit"})
+
+	OpenRooms = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "open_rooms",
+		Help:      "Number of rooms with clients connected to this node.",
+	})
+
+	Backups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backups_total",
+		Help:      "Backups taken, by result (ok, error).",
+	}, []string{"result"})
+
+	LastBackup = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_backup_timestamp_seconds",
+		Help:      "Unix time of the last successful backup.",
+	})
+
+	TenantClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tenant_clients",
+		Help:      "Number of connected clients per tenant.",
+	}, []string{"tenant"})
+
+	TenantQuotaRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_quota_rejections_total",
+		Help:      "Connections and documents refused for exceeding a tenant's quota, by tenant and quota (connections, documents).",
+	}, []string{"tenant", "quota"})
+
+	ClientRTT = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "client_rtt_seconds",
+		Help:      "Round trips of latency pings to clients, by transport.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"transport"})
+
+	ClientClockSkew = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "client_clock_skew_seconds",
+		Help:      "How far client clocks are from the server's, either way, as measured by latency pings.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ConnectedClients,
+		BotConnections,
+		RoomClients,
+		BroadcastMessages,
+		RoomBroadcasters,
+		CoalescedEdits,
+		OwnedDocuments,
+		ForwardedConnections,
+		DeliveredMessages,
+		MessageSize,
+		DroppedClients,
+		BackpressureActions,
+		PlaintextReads,
+		EditSequenceErrors,
+		WebhookDeliveries,
+		OpsApplied,
+		UpgradeFailures,
+		ClientErrors,
+		FramePayloadBytes,
+		FrameWireBytes,
+		CompressionRatio,
+		CanaryOps,
+		RecordedMessages,
+		Hibernations,
+		LimitRejections,
+		OpenRooms,
+		Backups,
+		LastBackup,
+		TenantClients,
+		TenantQuotaRejections,
+		ClientRTT,
+		ClientClockSkew,
+	)
+}
+
+// ObserveFrame records the size of an outbound frame before and after
+// compression
+func ObserveFrame(compressed bool, payload int, wire int64) {
+	label := strconv.FormatBool(compressed)
+	FramePayloadBytes.WithLabelValues(label).Add(float64(payload))
+	FrameWireBytes.WithLabelValues(label).Add(float64(wire))
+	if compressed && payload > 0 {
+		CompressionRatio.Observe(float64(wire) / float64(payload))
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// TenantLabel is the label value of a tenant; the default tenant, whose ID
+// is empty, is "default"
+func TenantLabel(tenant string) string {
+	if tenant == "" {
+		return "default"
+	}
+	return tenant
+}
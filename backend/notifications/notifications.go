@@ -0,0 +1,263 @@
+// Package notifications keeps what users are told about while they may be
+// away, such as being mentioned in a document's chat or comments, and how
+// much of what happens in each document they want to be told about
+package notifications
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Types of notification: someone @-mentioned the user, commented on a
+// document they own or edited one they follow every change of
+const (
+	TypeMention = "mention"
+	TypeComment = "comment"
+	TypeEdit    = "edit"
+)
+
+// Levels a user is notified at about a document: of everything, edits by
+// others included, only of mentions and comments, or of nothing
+const (
+	LevelAll      = "all"
+	LevelMentions = "mentions"
+	LevelNone     = "none"
+)
+
+// DefaultLevel is the level of users who never chose one for a document
+const DefaultLevel = LevelMentions
+
+var ErrInvalidLevel = errors.New("level must be all, mentions or none")
+
+// Allows reports whether a user at level is told about notifications of
+// type notificationType
+func Allows(level string, notificationType string) bool {
+	switch level {
+	case LevelAll:
+		return true
+	case LevelNone:
+		return false
+	default:
+		return notificationType != TypeEdit
+	}
+}
+
+// Sources of a mention
+const (
+	SourceChat    = "chat"
+	SourceComment = "comment"
+)
+
+// Notification tells UserID about something that happened in DocID. Source
+// is where it happened, SourceID the chat message or comment and Text what
+// was said.
+type Notification struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"userId"`
+	Type      string            `json:"type"`
+	DocID     string            `json:"docId"`
+	Source    string            `json:"source"`
+	SourceID  string            `json:"sourceId"`
+	Text      string            `json:"text"`
+	From      map[string]string `json:"from"`
+	CreatedAt time.Time         `json:"createdAt"`
+	ReadAt    *time.Time        `json:"readAt,omitempty"`
+}
+
+// Store keeps the most recent notifications of each user, up to limit;
+// older ones are dropped, read or not. It also keeps the level each user
+// chose for each document, by user ID then document ID.
+type Store struct {
+	mutex         sync.Mutex
+	limit         int
+	notifications map[string][]Notification
+	levels        map[string]map[string]string
+}
+
+func NewStore(limit int) *Store {
+	return &Store{
+		limit:         limit,
+		notifications: make(map[string][]Notification),
+		levels:        make(map[string]map[string]string),
+	}
+}
+
+// SetLevel sets the level userID is notified at about docID. Setting the
+// default forgets the choice.
+func (store *Store) SetLevel(userID string, docID string, level string) error {
+	if !slices.Contains([]string{LevelAll, LevelMentions, LevelNone}, level) {
+		return ErrInvalidLevel
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if level == DefaultLevel {
+		delete(store.levels[userID], docID)
+		if len(store.levels[userID]) == 0 {
+			delete(store.levels, userID)
+		}
+		return nil
+	}
+	if store.levels[userID] == nil {
+		store.levels[userID] = make(map[string]string)
+	}
+	store.levels[userID][docID] = level
+	return nil
+}
+
+// Level returns the level userID is notified at about docID
+func (store *Store) Level(userID string, docID string) string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if level, ok := store.levels[userID][docID]; ok {
+		return level
+	}
+	return DefaultLevel
+}
+
+// Subscribers returns the users notified at level about docID, which must
+// not be the default level
+func (store *Store) Subscribers(docID string, level string) []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var userIDs []string
+	for userID, levels := range store.levels {
+		if levels[docID] == level {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+func (store *Store) Add(notification Notification) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	list := append(store.notifications[notification.UserID], notification)
+	if len(list) > store.limit {
+		list = slices.Delete(list, 0, len(list)-store.limit)
+	}
+	store.notifications[notification.UserID] = list
+}
+
+// List returns the notifications of userID, newest first, leaving out the
+// read ones if unreadOnly is set
+func (store *Store) List(userID string, unreadOnly bool) []Notification {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	list := make([]Notification, 0, len(store.notifications[userID]))
+	for _, notification := range slices.Backward(store.notifications[userID]) {
+		if !unreadOnly || notification.ReadAt == nil {
+			list = append(list, notification)
+		}
+	}
+	return list
+}
+
+// MarkRead marks the given notifications of userID read, or all of them
+// when ids is empty, and returns how many were unread
+func (store *Store) MarkRead(userID string, ids []string) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now().UTC()
+	marked := 0
+	for i := range store.notifications[userID] {
+		notification := &store.notifications[userID][i]
+		if notification.ReadAt != nil || (len(ids) > 0 && !slices.Contains(ids, notification.ID)) {
+			continue
+		}
+		notification.ReadAt = &now
+		marked++
+	}
+	return marked
+}
+
+// UnreadBetween returns the notifications of every user created after from
+// and up to to that are not read yet, oldest first, by user ID
+func (store *Store) UnreadBetween(from time.Time, to time.Time) map[string][]Notification {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	unread := make(map[string][]Notification)
+	for userID, list := range store.notifications {
+		for _, notification := range list {
+			if notification.ReadAt == nil && notification.CreatedAt.After(from) && !notification.CreatedAt.After(to) {
+				unread[userID] = append(unread[userID], notification)
+			}
+		}
+	}
+	return unread
+}
+
+// HasUnread reports whether userID has a notification of type
+// notificationType about docID they haven't read yet
+func (store *Store) HasUnread(userID string, docID string, notificationType string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return slices.ContainsFunc(store.notifications[userID], func(notification Notification) bool {
+		return notification.ReadAt == nil && notification.DocID == docID && notification.Type == notificationType
+	})
+}
+
+// Unread counts the notifications of userID not read yet
+func (store *Store) Unread(userID string) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	unread := 0
+	for _, notification := range store.notifications[userID] {
+		if notification.ReadAt == nil {
+			unread++
+		}
+	}
+	return unread
+}
+
+// Handle is how a user with the given display name is mentioned: the
+// letters, digits, _, - and . of the name, compared without regard to case
+func Handle(displayName string) string {
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		if isHandleRune(r) {
+			return r
+		}
+		return -1
+	}, displayName))
+}
+
+// Mentions returns the handles @-mentioned in text, each once, lowercased.
+// An @ inside a word, as in an email address, is no mention.
+func Mentions(text string) []string {
+	var handles []string
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && isHandleRune(runes[i-1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isHandleRune(runes[end]) {
+			end++
+		}
+		// Trailing punctuation ends a sentence rather than the handle
+		for end > i+1 && (runes[end-1] == '.' || runes[end-1] == '-') {
+			end--
+		}
+		if handle := strings.ToLower(string(runes[i+1 : end])); handle != "" && !slices.Contains(handles, handle) {
+			handles = append(handles, handle)
+		}
+		i = end - 1
+	}
+	return handles
+}
+
+func isHandleRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
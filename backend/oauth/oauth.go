@@ -0,0 +1,215 @@
+// Package oauth signs people in with Google or GitHub through the OAuth 2
+// authorization code flow, returning who the provider says they are
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Providers that can be configured
+const (
+	Google = "google"
+	GitHub = "github"
+)
+
+// Timeout for each request to a provider
+const requestTimeout = 10 * time.Second
+
+// Largest response read from a provider
+const maxResponseSize = 1 << 20
+
+var (
+	ErrUnknownProvider = errors.New("login provider is not configured")
+	ErrDenied          = errors.New("login was cancelled or denied")
+)
+
+// Identity is an account at a provider. Subject is the provider's stable
+// ID for it; Email is only set when the provider verified it.
+type Identity struct {
+	Provider  string
+	Subject   string
+	Email     string
+	Name      string
+	AvatarURL string
+}
+
+// Provider is an OAuth 2 provider with the client registered at it
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+
+	authURL  string
+	tokenURL string
+	scopes   []string
+	identify func(ctx context.Context, client *http.Client, token string) (Identity, error)
+	client   *http.Client
+}
+
+// NewProvider returns the provider called name with the client registered
+// there
+func NewProvider(name string, clientID string, clientSecret string) (*Provider, error) {
+	provider := &Provider{
+		Name:         name,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		client:       &http.Client{Timeout: requestTimeout},
+	}
+	switch name {
+	case Google:
+		provider.authURL = "https://accounts.google.com/o/oauth2/v2/auth"
+		provider.tokenURL = "https://oauth2.googleapis.com/token"
+		provider.scopes = []string{"openid", "email", "profile"}
+		provider.identify = googleIdentity
+	case GitHub:
+		provider.authURL = "https://github.com/login/oauth/authorize"
+		provider.tokenURL = "https://github.com/login/oauth/access_token"
+		provider.scopes = []string{"read:user", "user:email"}
+		provider.identify = githubIdentity
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+// AuthCodeURL is where to send the browser to sign in. The provider sends
+// it back to redirectURI with state and a code for Exchange.
+func (provider *Provider) AuthCodeURL(state string, redirectURI string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {provider.ClientID},
+		"redirect_uri":  {redirectURI},
+		"scope":         {strings.Join(provider.scopes, " ")},
+		"state":         {state},
+	}
+	return provider.authURL + "?" + query.Encode()
+}
+
+// Exchange trades the code the provider sent the browser back with for
+// the identity it signed in as
+func (provider *Provider) Exchange(ctx context.Context, code string, redirectURI string) (Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {provider.ClientID},
+		"client_secret": {provider.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := doJSON(provider.client, req, &token); err != nil {
+		return Identity{}, fmt.Errorf("exchanging code: %w", err)
+	}
+	if token.AccessToken == "" {
+		return Identity{}, fmt.Errorf("exchanging code: %s", token.Error)
+	}
+
+	identity, err := provider.identify(ctx, provider.client, token.AccessToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("fetching identity: %w", err)
+	}
+	identity.Provider = provider.Name
+	return identity, nil
+}
+
+// googleIdentity reads the OpenID Connect userinfo of the token's account
+func googleIdentity(ctx context.Context, client *http.Client, token string) (Identity, error) {
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+	if err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", token, &info); err != nil {
+		return Identity{}, err
+	}
+	if info.Subject == "" {
+		return Identity{}, errors.New("userinfo has no subject")
+	}
+	identity := Identity{Subject: info.Subject, Name: info.Name, AvatarURL: info.Picture}
+	if info.EmailVerified {
+		identity.Email = info.Email
+	}
+	return identity, nil
+}
+
+// githubIdentity reads the token's GitHub user and its primary verified
+// email
+func githubIdentity(ctx context.Context, client *http.Client, token string) (Identity, error) {
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", token, &user); err != nil {
+		return Identity{}, err
+	}
+	if user.ID == 0 {
+		return Identity{}, errors.New("user has no ID")
+	}
+	identity := Identity{Subject: fmt.Sprint(user.ID), Name: user.Name, AvatarURL: user.AvatarURL}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", token, &emails); err != nil {
+		return Identity{}, err
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			identity.Email = email.Email
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, endpoint string, token string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	return doJSON(client, req, target)
+}
+
+func doJSON(client *http.Client, req *http.Request, target any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", req.URL.Host, resp.StatusCode)
+	}
+	return json.Unmarshal(body, target)
+}
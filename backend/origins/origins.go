@@ -0,0 +1,154 @@
+// Package origins decides which browser origins may open WebSockets and
+// call the REST API
+package origins
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers a browser may send and read on cross-origin API requests
+const (
+	allowedMethods  = "GET, POST, PUT, PATCH, DELETE"
+	allowedHeaders  = "Authorization, Content-Type"
+	exposedHeaders  = "Content-Disposition, X-Document-Revision"
+	preflightMaxAge = "600"
+)
+
+// pattern is one allowlist entry. An empty scheme matches http and https,
+// and a wildcard host matches any subdomain of host but not host itself.
+type pattern struct {
+	scheme   string
+	host     string
+	port     string
+	wildcard bool
+}
+
+// Allowlist matches request origins against configured patterns:
+// "https://app.example.com", "https://*.example.com", "*.example.com" for
+// either scheme, or "*" for any origin
+type Allowlist struct {
+	patterns []pattern
+	allowAll bool
+}
+
+// NewAllowlist parses the configured origins. allowAll accepts every
+// origin regardless and is meant for local development only.
+func NewAllowlist(allowed []string, allowAll bool) (*Allowlist, error) {
+	allowlist := &Allowlist{allowAll: allowAll}
+	for _, entry := range allowed {
+		if entry == "*" {
+			allowlist.allowAll = true
+			continue
+		}
+		p, err := parsePattern(entry)
+		if err != nil {
+			return nil, err
+		}
+		allowlist.patterns = append(allowlist.patterns, p)
+	}
+	return allowlist, nil
+}
+
+func parsePattern(entry string) (pattern, error) {
+	var p pattern
+	rest := strings.ToLower(strings.TrimSuffix(entry, "/"))
+	if scheme, after, found := strings.Cut(rest, "://"); found {
+		if scheme != "http" && scheme != "https" {
+			return pattern{}, fmt.Errorf("allowed origin %q must use http or https", entry)
+		}
+		p.scheme, rest = scheme, after
+	}
+	if host, found := strings.CutPrefix(rest, "*."); found {
+		p.wildcard, rest = true, host
+	}
+
+	u, err := url.Parse("http://" + rest)
+	if err != nil || u.Hostname() == "" || u.Host != rest || strings.Contains(u.Hostname(), "*") {
+		return pattern{}, fmt.Errorf("allowed origin %q must be scheme://host[:port], optionally with a *. subdomain wildcard", entry)
+	}
+	p.host, p.port = u.Hostname(), u.Port()
+	return p, nil
+}
+
+// Allows reports whether a browser at origin may connect. Requests without
+// an Origin header don't come from a browser and are always allowed; a nil
+// Allowlist allows no browser origin.
+func (allowlist *Allowlist) Allows(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	if allowlist == nil {
+		return false
+	}
+	if allowlist.allowAll {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, p := range allowlist.patterns {
+		if p.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p pattern) matches(u *url.URL) bool {
+	if p.scheme != "" && p.scheme != u.Scheme {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	if p.port != u.Port() {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(u.Hostname(), "."+p.host)
+	}
+	return u.Hostname() == p.host
+}
+
+// CORS answers preflight requests and adds the CORS headers to responses
+// for allowed origins. Disallowed preflights get a 403; other requests from
+// disallowed origins are served without CORS headers, so the browser keeps
+// the response from the page.
+func (allowlist *Allowlist) CORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowlist.Allows(origin) {
+			if preflight {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", origin)
+		if preflight {
+			header.Set("Access-Control-Allow-Methods", allowedMethods)
+			header.Set("Access-Control-Allow-Headers", allowedHeaders)
+			header.Set("Access-Control-Max-Age", preflightMaxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		header.Set("Access-Control-Expose-Headers", exposedHeaders)
+		c.Next()
+	}
+}
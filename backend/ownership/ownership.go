@@ -0,0 +1,82 @@
+// Package ownership elects, for each document, the one node of a cluster
+// that is authoritative for it. A node holds a document through a lease it
+// must renew within the lease TTL; a lease that runs out, because its node
+// crashed or lost touch, goes to the next node to ask. Other nodes forward
+// the document's connections to the owner.
+package ownership
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Node is a member of the cluster: ID names it and URL is where the other
+// nodes reach it
+type Node struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Store keeps the leases. Acquire takes the lease on a document for node
+// unless another node holds it, and returns the holder either way. Renew
+// extends a lease node holds and Release gives it up; both report false
+// when node no longer holds it.
+type Store interface {
+	Acquire(ctx context.Context, docID string, node Node, ttl time.Duration) (Node, error)
+	Renew(ctx context.Context, docID string, node Node, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, docID string, node Node) (bool, error)
+}
+
+// MemoryStore is the Store used when no Redis URL is configured. It only
+// knows about this process, so it suits a single node.
+type MemoryStore struct {
+	mutex  sync.Mutex
+	leases map[string]lease
+}
+
+type lease struct {
+	node    Node
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{leases: make(map[string]lease)}
+}
+
+func (store *MemoryStore) Acquire(_ context.Context, docID string, node Node, ttl time.Duration) (Node, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	if held, ok := store.leases[docID]; ok && held.node != node && now.Before(held.expires) {
+		return held.node, nil
+	}
+	store.leases[docID] = lease{node: node, expires: now.Add(ttl)}
+	return node, nil
+}
+
+func (store *MemoryStore) Renew(_ context.Context, docID string, node Node, ttl time.Duration) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	held, ok := store.leases[docID]
+	if !ok || held.node != node || !now.Before(held.expires) {
+		return false, nil
+	}
+	store.leases[docID] = lease{node: node, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (store *MemoryStore) Release(_ context.Context, docID string, node Node) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	held, ok := store.leases[docID]
+	if !ok || held.node != node {
+		return false, nil
+	}
+	delete(store.leases, docID)
+	return true, nil
+}
@@ -0,0 +1,101 @@
+package ownership
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore shares leases between nodes. Each lease is a key holding its
+// node as JSON, expiring with the lease; scripts compare the holder and
+// change the key in one step.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Redis URL: %w", err)
+	}
+	return &RedisStore{client: redis.NewClient(options)}, nil
+}
+
+// Ping reports whether Redis can be reached
+func (store *RedisStore) Ping(ctx context.Context) error {
+	return store.client.Ping(ctx).Err()
+}
+
+func (store *RedisStore) Close() error {
+	return store.client.Close()
+}
+
+func leaseKey(docID string) string {
+	return "owner:" + docID
+}
+
+var (
+	acquireScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if not holder or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return ARGV[1]
+end
+return holder`)
+
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func (store *RedisStore) Acquire(ctx context.Context, docID string, node Node, ttl time.Duration) (Node, error) {
+	value, err := json.Marshal(node)
+	if err != nil {
+		return Node{}, err
+	}
+	holder, err := acquireScript.Run(ctx, store.client, []string{leaseKey(docID)}, value, ttl.Milliseconds()).Text()
+	if err != nil {
+		return Node{}, err
+	}
+	var owner Node
+	if err := json.Unmarshal([]byte(holder), &owner); err != nil {
+		return Node{}, fmt.Errorf("decoding owner of %s: %w", docID, err)
+	}
+	return owner, nil
+}
+
+func (store *RedisStore) Renew(ctx context.Context, docID string, node Node, ttl time.Duration) (bool, error) {
+	value, err := json.Marshal(node)
+	if err != nil {
+		return false, err
+	}
+	renewed, err := renewScript.Run(ctx, store.client, []string{leaseKey(docID)}, value, ttl.Milliseconds()).Int()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return renewed == 1, err
+}
+
+func (store *RedisStore) Release(ctx context.Context, docID string, node Node) (bool, error) {
+	value, err := json.Marshal(node)
+	if err != nil {
+		return false, err
+	}
+	released, err := releaseScript.Run(ctx, store.client, []string{leaseKey(docID)}, value).Int()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return released == 1, err
+}
@@ -0,0 +1,120 @@
+package presence
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entry is the presence of one connection in a document room
+type Entry struct {
+	DocID    string
+	ConnID   string
+	UserData map[string]string
+}
+
+// Store keeps presence for every node. Entries expire unless refreshed
+// within the store's TTL, so a crashed node's users eventually disappear.
+type Store interface {
+	Join(ctx context.Context, entry Entry) error
+	Leave(ctx context.Context, docID string, connID string) error
+	Refresh(ctx context.Context, entries []Entry) error
+	List(ctx context.Context, docID string) ([]map[string]string, error)
+}
+
+// MemoryStore is the single-node Store used when no Redis URL is configured
+type MemoryStore struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	rooms   map[string]map[string]memoryEntry
+	nowFunc func() time.Time
+}
+
+type memoryEntry struct {
+	userData  map[string]string
+	expiresAt time.Time
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:     ttl,
+		rooms:   make(map[string]map[string]memoryEntry),
+		nowFunc: time.Now,
+	}
+}
+
+func (store *MemoryStore) Join(ctx context.Context, entry Entry) error {
+	return store.Refresh(ctx, []Entry{entry})
+}
+
+func (store *MemoryStore) Leave(ctx context.Context, docID string, connID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.rooms[docID], connID)
+	if len(store.rooms[docID]) == 0 {
+		delete(store.rooms, docID)
+	}
+	return nil
+}
+
+func (store *MemoryStore) Refresh(ctx context.Context, entries []Entry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	expiresAt := store.nowFunc().Add(store.ttl)
+	for _, entry := range entries {
+		room, ok := store.rooms[entry.DocID]
+		if !ok {
+			room = make(map[string]memoryEntry)
+			store.rooms[entry.DocID] = room
+		}
+		room[entry.ConnID] = memoryEntry{userData: entry.UserData, expiresAt: expiresAt}
+	}
+	return nil
+}
+
+func (store *MemoryStore) List(ctx context.Context, docID string) ([]map[string]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.nowFunc()
+	var users []map[string]string
+	for connID, entry := range store.rooms[docID] {
+		if now.After(entry.expiresAt) {
+			delete(store.rooms[docID], connID)
+			continue
+		}
+		users = append(users, entry.userData)
+	}
+	return Group(users), nil
+}
+
+// Group collapses the connections of each user into one entry, the first
+// seen, whose "tabs" field counts them
+func Group(users []map[string]string) []map[string]string {
+	tabs := make(map[string]int, len(users))
+	unique := make([]map[string]string, 0, len(users))
+	for _, user := range users {
+		tabs[user["userId"]]++
+		if tabs[user["userId"]] == 1 {
+			unique = append(unique, user)
+		}
+	}
+	for i, user := range unique {
+		unique[i] = WithTabs(user, tabs[user["userId"]])
+	}
+	return unique
+}
+
+// WithTabs returns a copy of a user's entry saying how many connections
+// the user has
+func WithTabs(user map[string]string, tabs int) map[string]string {
+	counted := make(map[string]string, len(user)+1)
+	for key, value := range user {
+		counted[key] = value
+	}
+	counted["tabs"] = strconv.Itoa(tabs)
+	return counted
+}
@@ -0,0 +1,125 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore shares presence between nodes. Each room is a sorted set of
+// connection IDs scored by expiry time, plus a hash of their user data.
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisStore(redisURL string, ttl time.Duration) (*RedisStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Redis URL: %w", err)
+	}
+	return &RedisStore{client: redis.NewClient(options), ttl: ttl}, nil
+}
+
+// Ping reports whether Redis can be reached
+func (store *RedisStore) Ping(ctx context.Context) error {
+	return store.client.Ping(ctx).Err()
+}
+
+func (store *RedisStore) Close() error {
+	return store.client.Close()
+}
+
+func membersKey(docID string) string {
+	return "presence:" + docID + ":members"
+}
+
+func usersKey(docID string) string {
+	return "presence:" + docID + ":users"
+}
+
+func (store *RedisStore) Join(ctx context.Context, entry Entry) error {
+	return store.Refresh(ctx, []Entry{entry})
+}
+
+func (store *RedisStore) Leave(ctx context.Context, docID string, connID string) error {
+	pipe := store.client.TxPipeline()
+	pipe.ZRem(ctx, membersKey(docID), connID)
+	pipe.HDel(ctx, usersKey(docID), connID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (store *RedisStore) Refresh(ctx context.Context, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	expiresAt := float64(time.Now().Add(store.ttl).UnixMilli())
+	pipe := store.client.Pipeline()
+	for _, entry := range entries {
+		userData, err := json.Marshal(entry.UserData)
+		if err != nil {
+			return err
+		}
+		pipe.ZAdd(ctx, membersKey(entry.DocID), redis.Z{Score: expiresAt, Member: entry.ConnID})
+		pipe.HSet(ctx, usersKey(entry.DocID), entry.ConnID, userData)
+		// Whole rooms vanish if no node refreshes them any more
+		pipe.Expire(ctx, membersKey(entry.DocID), store.ttl)
+		pipe.Expire(ctx, usersKey(entry.DocID), store.ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (store *RedisStore) List(ctx context.Context, docID string) ([]map[string]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	expired, err := store.client.ZRangeByScore(ctx, membersKey(docID), &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) > 0 {
+		pipe := store.client.TxPipeline()
+		pipe.ZRem(ctx, membersKey(docID), stringsToAny(expired)...)
+		pipe.HDel(ctx, usersKey(docID), expired...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	connIDs, err := store.client.ZRange(ctx, membersKey(docID), 0, -1).Result()
+	if err != nil || len(connIDs) == 0 {
+		return nil, err
+	}
+	values, err := store.client.HMGet(ctx, usersKey(docID), connIDs...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]map[string]string, 0, len(values))
+	for _, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var userData map[string]string
+		if err := json.Unmarshal([]byte(raw), &userData); err != nil {
+			continue
+		}
+		users = append(users, userData)
+	}
+	return Group(users), nil
+}
+
+func stringsToAny(values []string) []any {
+	converted := make([]any, len(values))
+	for i, value := range values {
+		converted[i] = value
+	}
+	return converted
+}
@@ -0,0 +1,124 @@
+// Package proxies finds the address a request really came from when the
+// server sits behind reverse proxies it trusts, such as nginx or a CDN,
+// which put the client's address in X-Forwarded-For or X-Real-IP and the
+// scheme it used in X-Forwarded-Proto
+package proxies
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Trusted is the set of proxies whose forwarding headers are believed
+type Trusted struct {
+	networks []netip.Prefix
+}
+
+// New parses the trusted proxies, each a CIDR block or a single address
+func New(proxies []string) (*Trusted, error) {
+	trusted := &Trusted{}
+	for _, entry := range proxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			trusted.networks = append(trusted.networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q must be an IP address or CIDR block", entry)
+		}
+		trusted.networks = append(trusted.networks, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+// ClientIP returns the address r came from. That is its peer unless the
+// peer is a trusted proxy, in which case it is the last address in
+// X-Forwarded-For not itself a trusted proxy, as the addresses before it
+// may be made up by the client, or X-Real-IP for proxies that only send
+// that. A nil Trusted trusts no proxy.
+func (trusted *Trusted) ClientIP(r *http.Request) string {
+	peer := remoteAddr(r.RemoteAddr)
+	if !trusted.trusts(peer) {
+		return peer
+	}
+	hops := forwardedFor(r.Header.Values("X-Forwarded-For"))
+	if len(hops) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		if !trusted.trusts(addr.Unmap().String()) {
+			return addr.Unmap().String()
+		}
+	}
+	return peer
+}
+
+// Scheme returns the scheme, http or https, the client used to reach the
+// server. That is the one r was made with unless its peer is a trusted
+// proxy, in which case it is the one in X-Forwarded-Proto.
+func (trusted *Trusted) Scheme(r *http.Request) string {
+	if trusted.trusts(remoteAddr(r.RemoteAddr)) {
+		switch scheme := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))); scheme {
+		case "http", "https":
+			return scheme
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// trusts reports whether ip is a trusted proxy
+func (trusted *Trusted) trusts(ip string) bool {
+	if trusted == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, network := range trusted.networks {
+		if network.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr is the IP of a host:port peer address
+func remoteAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
+
+// forwardedFor splits X-Forwarded-For headers into their addresses, in
+// the order the hops were added
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for hop := range strings.SplitSeq(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
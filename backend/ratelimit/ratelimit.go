@@ -0,0 +1,90 @@
+// Package ratelimit implements the token buckets that throttle clients
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"backend/config"
+)
+
+// Bucket refills at rate tokens per second up to burst. It is not safe for
+// concurrent use.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(limit config.RateLimit, now time.Time) *Bucket {
+	return &Bucket{
+		rate:   limit.Rate,
+		burst:  float64(limit.Burst),
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+// Allow takes a token if one is available
+func (bucket *Bucket) Allow(now time.Time) bool {
+	bucket.refill(now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (bucket *Bucket) refill(now time.Time) {
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.last = now
+	bucket.tokens = min(bucket.burst, bucket.tokens+elapsed*bucket.rate)
+}
+
+// How often Keyed drops the buckets that have refilled
+const sweepInterval = time.Minute
+
+// Keyed keeps one bucket per key, such as a user, and is safe for
+// concurrent use. A full bucket behaves like a new one, so those are
+// dropped to keep the map bounded by recently active keys.
+type Keyed struct {
+	mutex     sync.Mutex
+	limit     config.RateLimit
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+func NewKeyed(limit config.RateLimit) *Keyed {
+	return &Keyed{
+		limit:     limit,
+		buckets:   make(map[string]*Bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket if one is available
+func (keyed *Keyed) Allow(key string, now time.Time) bool {
+	keyed.mutex.Lock()
+	defer keyed.mutex.Unlock()
+
+	if now.Sub(keyed.lastSweep) >= sweepInterval {
+		keyed.sweep(now)
+	}
+	bucket, ok := keyed.buckets[key]
+	if !ok {
+		bucket = NewBucket(keyed.limit, now)
+		keyed.buckets[key] = bucket
+	}
+	return bucket.Allow(now)
+}
+
+func (keyed *Keyed) sweep(now time.Time) {
+	keyed.lastSweep = now
+	for key, bucket := range keyed.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= bucket.burst {
+			delete(keyed.buckets, key)
+		}
+	}
+}
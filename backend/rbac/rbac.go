@@ -0,0 +1,127 @@
+// Package rbac guards the privileged HTTP endpoints with API tokens that
+// carry a role, and roles that grant scopes
+package rbac
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"backend/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Role string
+
+const (
+	RoleOperator       Role = "operator"
+	RoleWorkspaceAdmin Role = "workspace-admin"
+	RoleSupport        Role = "support"
+	RoleService        Role = "service"
+	RoleBot            Role = "bot"
+)
+
+type Scope string
+
+const (
+	ScopeMetrics     Scope = "metrics:read"
+	ScopeAdminRead   Scope = "admin:read"
+	ScopeModeration  Scope = "moderation:write"
+	ScopeImpersonate Scope = "impersonate"
+	ScopeMaintenance Scope = "maintenance:write"
+	ScopeDocsRead    Scope = "documents:read"
+	ScopeDocsWrite   Scope = "documents:write"
+	ScopeBots        Scope = "bots:connect"
+)
+
+// Server operators run the deployment, workspace admins moderate it and
+// support staff can only look. Services are other internal systems using
+// the gRPC API, or connecting as bots; bots can do nothing else.
+var roleScopes = map[Role][]Scope{
+	RoleOperator:       {ScopeMetrics, ScopeAdminRead, ScopeModeration, ScopeImpersonate, ScopeMaintenance, ScopeDocsRead, ScopeDocsWrite},
+	RoleWorkspaceAdmin: {ScopeAdminRead, ScopeModeration},
+	RoleSupport:        {ScopeAdminRead},
+	RoleService:        {ScopeDocsRead, ScopeDocsWrite, ScopeBots},
+	RoleBot:            {ScopeBots},
+}
+
+// Principal is the holder of an API token
+type Principal struct {
+	Name string
+	Role Role
+
+	// Tenant, when set, is the only tenant whose documents the token
+	// reaches
+	Tenant string
+}
+
+// Allows reports whether the principal's role grants scope
+func (principal Principal) Allows(scope Scope) bool {
+	for _, granted := range roleScopes[principal.Role] {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Key under which Require stores the Principal in the gin context
+const principalKey = "rbac.principal"
+
+// Authorizer maps API tokens, by their SHA-256, to principals
+type Authorizer struct {
+	principals map[string]Principal
+}
+
+func NewAuthorizer(tokens []config.APIToken) (*Authorizer, error) {
+	authorizer := &Authorizer{principals: make(map[string]Principal, len(tokens))}
+	for _, token := range tokens {
+		role := Role(token.Role)
+		if _, ok := roleScopes[role]; !ok {
+			return nil, fmt.Errorf("API token %q has unknown role %q", token.Name, token.Role)
+		}
+		authorizer.principals[strings.ToLower(token.SHA256)] = Principal{Name: token.Name, Role: role, Tenant: token.Tenant}
+	}
+	return authorizer, nil
+}
+
+// Enabled reports whether any API token is configured
+func (authorizer *Authorizer) Enabled() bool {
+	return len(authorizer.principals) > 0
+}
+
+// Authenticate returns the principal holding token
+func (authorizer *Authorizer) Authenticate(token string) (Principal, bool) {
+	sum := sha256.Sum256([]byte(token))
+	principal, ok := authorizer.principals[hex.EncodeToString(sum[:])]
+	return principal, ok
+}
+
+// Require rejects requests without a bearer API token whose role grants
+// scope: 401 for a missing or unknown token, 403 for an insufficient role
+func (authorizer *Authorizer) Require(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		principal, ok := authorizer.Authenticate(token)
+		if !found || token == "" || !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "a valid API token is required"})
+			return
+		}
+		if !principal.Allows(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "role " + string(principal.Role) + " lacks scope " + string(scope)})
+			return
+		}
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// PrincipalFrom returns the principal authorized by Require
+func PrincipalFrom(c *gin.Context) Principal {
+	principal, _ := c.Get(principalKey)
+	p, _ := principal.(Principal)
+	return p
+}
@@ -0,0 +1,162 @@
+// Package recording keeps the full message streams of a sample of rooms,
+// so sync correctness and performance can be analysed offline against real
+// traffic
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"time"
+
+	"backend/metrics"
+)
+
+// Directions of a recorded message
+const (
+	// Inbound messages were received from ConnID
+	Inbound = "inbound"
+	// Outbound messages were sent to ConnID alone
+	Outbound = "outbound"
+	// Broadcast messages were fanned out to the room
+	Broadcast = "broadcast"
+)
+
+// Entry is one recorded message. Message is the message as it was on the
+// wire, with the recorder's scrubbers applied.
+type Entry struct {
+	At        time.Time       `json:"at"`
+	DocID     string          `json:"doc_id"`
+	Direction string          `json:"direction"`
+	ConnID    string          `json:"conn_id,omitempty"`
+	UserID    string          `json:"user_id,omitempty"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// Capacity of the queue between the socket layer and the sink
+const queueSize = 4096
+
+// Upper bound on entries handed to the sink in one write
+const maxBatch = 256
+
+// Timeout for writing a single batch
+const writeTimeout = 5 * time.Second
+
+// Recorder records Percent of rooms to a sink. Like the event dispatcher
+// it never blocks the editing path: entries are dropped, and counted, when
+// the queue is full. A nil Recorder records no rooms.
+type Recorder struct {
+	sink      Sink
+	percent   uint32
+	scrubbers []Scrubber
+	queue     chan Entry
+	done      chan struct{}
+	logger    *slog.Logger
+}
+
+// NewRecorder records percent of rooms to sink, scrubbing every message
+// with the named scrubbers on top of the session secrets that are always
+// removed
+func NewRecorder(sink Sink, percent int, scrub []string, logger *slog.Logger) (*Recorder, error) {
+	scrubbers := []Scrubber{scrubSessions}
+	for _, name := range scrub {
+		scrubber, ok := Scrubbers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown recording scrubber %q", name)
+		}
+		scrubbers = append(scrubbers, scrubber)
+	}
+	recorder := &Recorder{
+		sink:      sink,
+		percent:   uint32(percent),
+		scrubbers: scrubbers,
+		queue:     make(chan Entry, queueSize),
+		done:      make(chan struct{}),
+		logger:    logger,
+	}
+	go recorder.run()
+	return recorder, nil
+}
+
+// Covers reports whether docID is recorded. Sampling hashes the document
+// ID, so a room's stream is recorded whole, across restarts and nodes.
+func (recorder *Recorder) Covers(docID string) bool {
+	if recorder == nil || docID == "" {
+		return false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(docID))
+	return hash.Sum32()%100 < recorder.percent
+}
+
+// Record queues a message of a recorded room without blocking. Messages of
+// rooms that aren't recorded are ignored.
+func (recorder *Recorder) Record(docID string, direction string, connID string, userID string, message []byte) {
+	if !recorder.Covers(docID) {
+		return
+	}
+	scrubbed, err := recorder.scrub(message)
+	if err != nil {
+		recorder.logger.Debug("Could not scrub message, not recording it", "doc_id", docID, "error", err)
+		metrics.RecordedMessages.WithLabelValues("error").Inc()
+		return
+	}
+	entry := Entry{
+		At:        time.Now().UTC(),
+		DocID:     docID,
+		Direction: direction,
+		ConnID:    connID,
+		UserID:    userID,
+		Message:   scrubbed,
+	}
+	select {
+	case recorder.queue <- entry:
+	default:
+		metrics.RecordedMessages.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Close writes whatever is still queued and closes the sink
+func (recorder *Recorder) Close() error {
+	if recorder == nil {
+		return nil
+	}
+	close(recorder.queue)
+	<-recorder.done
+	return recorder.sink.Close()
+}
+
+func (recorder *Recorder) run() {
+	defer close(recorder.done)
+
+	for entry := range recorder.queue {
+		batch := []Entry{entry}
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case entry, ok := <-recorder.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, entry)
+			default:
+				break fill
+			}
+		}
+		recorder.write(batch)
+	}
+}
+
+func (recorder *Recorder) write(batch []Entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if err := recorder.sink.Write(ctx, batch); err != nil {
+		recorder.logger.Warn("Could not write recorded messages", "count", len(batch), "error", err)
+		metrics.RecordedMessages.WithLabelValues("error").Add(float64(len(batch)))
+		return
+	}
+	metrics.RecordedMessages.WithLabelValues("recorded").Add(float64(len(batch)))
+}
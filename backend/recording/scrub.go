@@ -0,0 +1,99 @@
+package recording
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Scrubber removes personal data from a decoded message in place. A
+// scrubber sees every value in the message, nested ones included, through
+// walk.
+type Scrubber func(message map[string]any)
+
+// Scrubbers are the scrubbers recording can be configured with by name
+var Scrubbers = map[string]Scrubber{
+	// names blanks user names, the only identifying part of user data
+	"names": func(message map[string]any) {
+		walk(message, func(key string, value any) any {
+			if key == "userName" {
+				return ""
+			}
+			return value
+		})
+	},
+	// chat redacts the text of chat messages and comments
+	"chat": func(message map[string]any) {
+		walk(message, func(key string, value any) any {
+			if _, ok := value.(string); ok && key == "text" {
+				return "[redacted]"
+			}
+			return value
+		})
+	},
+	// text masks document text while keeping its length and line breaks,
+	// which is all sync analysis needs. HTML content is dropped since its
+	// markup can't be masked without parsing it.
+	"text": func(message map[string]any) {
+		walk(message, func(key string, value any) any {
+			text, ok := value.(string)
+			switch {
+			case !ok:
+				return value
+			case key == "insert":
+				return mask(text)
+			case key == "content":
+				return ""
+			}
+			return value
+		})
+	},
+}
+
+// scrubSessions removes session secrets, which would let anyone reading a
+// recording take over the session
+func scrubSessions(message map[string]any) {
+	walk(message, func(key string, value any) any {
+		if key == "sessionId" {
+			return ""
+		}
+		return value
+	})
+}
+
+// walk replaces every value in a map, nested maps and arrays included, with
+// what replace returns for it and its key
+func walk(value any, replace func(key string, value any) any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			value[key] = replace(key, child)
+			walk(value[key], replace)
+		}
+	case []any:
+		for _, child := range value {
+			walk(child, replace)
+		}
+	}
+}
+
+// mask replaces every character but line breaks with x
+func mask(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' {
+			return r
+		}
+		return 'x'
+	}, text)
+}
+
+// scrub applies the recorder's scrubbers to a JSON message
+func (recorder *Recorder) scrub(message []byte) (json.RawMessage, error) {
+	var decoded map[string]any
+	if err := json.Unmarshal(message, &decoded); err != nil {
+		return nil, err
+	}
+	for _, scrubber := range recorder.scrubbers {
+		scrubber(decoded)
+	}
+	return json.Marshal(decoded)
+}
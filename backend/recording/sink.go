@@ -0,0 +1,118 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sink stores recorded messages
+type Sink interface {
+	Write(ctx context.Context, entries []Entry) error
+	Close() error
+}
+
+// OpenSink returns the sink a DSN points to, in the same forms as the
+// storage DSN: "file:///path/to/dir" appends JSON Lines to one file per
+// room, "redis://" and "rediss://" URLs append to one list per room
+func OpenSink(dsn string) (Sink, error) {
+	scheme, _, _ := strings.Cut(dsn, ":")
+	switch scheme {
+	case "file":
+		return NewFileSink(strings.TrimPrefix(strings.TrimPrefix(dsn, "file:"), "//"))
+	case "redis", "rediss":
+		return NewRedisSink(dsn)
+	}
+	return nil, fmt.Errorf("recording DSN must start with file:// or redis://")
+}
+
+// FileSink appends each room's entries to a JSON Lines file named after
+// its escaped document ID
+type FileSink struct {
+	Dir string
+}
+
+func NewFileSink(dir string) (*FileSink, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file recording needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating recording directory: %w", err)
+	}
+	return &FileSink{Dir: dir}, nil
+}
+
+func (sink *FileSink) Close() error {
+	return nil
+}
+
+func (sink *FileSink) Write(_ context.Context, entries []Entry) error {
+	lines := make(map[string][]byte)
+	for _, entry := range entries {
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines[entry.DocID] = append(append(lines[entry.DocID], raw...), '\n')
+	}
+	for docID, raw := range lines {
+		if err := sink.append(docID, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sink *FileSink) append(docID string, raw []byte) error {
+	path := filepath.Join(sink.Dir, url.PathEscape(docID)+".jsonl")
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(raw); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// RedisSink appends each room's entries to a list without expiry; whoever
+// analyses a recording removes it
+type RedisSink struct {
+	client *redis.Client
+}
+
+func NewRedisSink(redisURL string) (*RedisSink, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Redis URL: %w", err)
+	}
+	return &RedisSink{client: redis.NewClient(options)}, nil
+}
+
+func (sink *RedisSink) Close() error {
+	return sink.client.Close()
+}
+
+func recordingKey(docID string) string {
+	return "recording:" + docID
+}
+
+func (sink *RedisSink) Write(ctx context.Context, entries []Entry) error {
+	pipe := sink.client.Pipeline()
+	for _, entry := range entries {
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		pipe.RPush(ctx, recordingKey(entry.DocID), raw)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
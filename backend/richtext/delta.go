@@ -0,0 +1,587 @@
+// Package richtext is the document model: a Quill style delta of text
+// inserts carrying formatting attributes. Inline attributes apply to the
+// text they are attached to; line attributes apply to the line ended by the
+// newline they are attached to. Lengths and positions count Unicode code
+// points, with an embedded image counting as one.
+package richtext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"backend/sanitize"
+)
+
+// EmbedText stands in for an embed in the plain text of a document. Text
+// inserts cannot contain it.
+const EmbedText = "\uFFFC"
+
+// Longest alt text kept on an image, in characters
+const maxAltLength = 1000
+
+var (
+	ErrInvalidDelta   = errors.New("invalid delta")
+	ErrLengthMismatch = errors.New("delta does not match the document length")
+)
+
+// Op is one delta operation. Exactly one of Insert, Retain and Delete is
+// set; documents only contain inserts. An embed insert has Image set and
+// EmbedText as its Insert, and is an object rather than a string in JSON.
+type Op struct {
+	Insert     string
+	Image      *Image
+	Retain     int
+	Delete     int
+	Attributes Attributes
+}
+
+// Image is an embedded image. Alt describes it to those who cannot see it
+// and is carried into exports; an empty Alt marks it as decorative.
+type Image struct {
+	Src string `json:"image"`
+	Alt string `json:"alt,omitempty"`
+}
+
+// opJSON is the wire shape of an Op
+type opJSON struct {
+	Insert     json.RawMessage `json:"insert,omitempty"`
+	Retain     int             `json:"retain,omitempty"`
+	Delete     int             `json:"delete,omitempty"`
+	Attributes Attributes      `json:"attributes,omitempty"`
+}
+
+func (op Op) MarshalJSON() ([]byte, error) {
+	wire := opJSON{Retain: op.Retain, Delete: op.Delete, Attributes: op.Attributes}
+	var err error
+	switch {
+	case op.Image != nil:
+		wire.Insert, err = json.Marshal(op.Image)
+	case op.Insert != "":
+		wire.Insert, err = json.Marshal(op.Insert)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(wire)
+}
+
+func (op *Op) UnmarshalJSON(data []byte) error {
+	var wire opJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*op = Op{Retain: wire.Retain, Delete: wire.Delete, Attributes: wire.Attributes}
+	if len(wire.Insert) == 0 || string(wire.Insert) == "null" {
+		return nil
+	}
+	if wire.Insert[0] != '{' {
+		return json.Unmarshal(wire.Insert, &op.Insert)
+	}
+
+	var image Image
+	if err := json.Unmarshal(wire.Insert, &image); err != nil || image.Src == "" {
+		return fmt.Errorf("%w: embeds must be {\"image\": url, \"alt\": text}", ErrInvalidDelta)
+	}
+	op.Insert = EmbedText
+	op.Image = &image
+	return nil
+}
+
+// Delta is either a whole document or a change to one
+type Delta []Op
+
+// Attributes marking tracked changes in a redline. They are never part of a
+// document, which only accepts the attributes in attributeSpecs, and are
+// rendered as <ins> and <del> by ToHTML.
+const (
+	TrackedInsert = "tracked-insert"
+	TrackedDelete = "tracked-delete"
+)
+
+// Attributes are the formatting of an insert. In a retain, a nil value
+// removes the attribute.
+type Attributes map[string]any
+
+type attributeSpec struct {
+	line  bool
+	valid func(value any) (any, bool)
+}
+
+var attributeSpecs = map[string]attributeSpec{
+	"bold":       {valid: isTrue},
+	"italic":     {valid: isTrue},
+	"underline":  {valid: isTrue},
+	"strike":     {valid: isTrue},
+	"code":       {valid: isTrue},
+	"link":       {valid: isLink},
+	"header":     {line: true, valid: intBetween(1, 6)},
+	"list":       {line: true, valid: oneOf("bullet", "ordered")},
+	"indent":     {line: true, valid: intBetween(1, 8)},
+	"blockquote": {line: true, valid: isTrue},
+	"code-block": {line: true, valid: isTrue},
+	"direction":  {line: true, valid: isDirection},
+}
+
+func isTrue(value any) (any, bool) {
+	b, ok := value.(bool)
+	return true, ok && b
+}
+
+func isLink(value any) (any, bool) {
+	link, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+	return sanitize.URL(link, sanitize.LinkSchemes)
+}
+
+// intBetween accepts whole JSON numbers as well as ints
+// isDirection accepts the base directions a line can override the
+// document's with
+func isDirection(value any) (any, bool) {
+	return oneOf("ltr", "rtl")(value)
+}
+
+func intBetween(low int, high int) func(any) (any, bool) {
+	return func(value any) (any, bool) {
+		var n int
+		switch v := value.(type) {
+		case int:
+			n = v
+		case float64:
+			if v != float64(int(v)) {
+				return nil, false
+			}
+			n = int(v)
+		default:
+			return nil, false
+		}
+		return n, n >= low && n <= high
+	}
+}
+
+func oneOf(values ...string) func(any) (any, bool) {
+	return func(value any) (any, bool) {
+		s, ok := value.(string)
+		for _, allowed := range values {
+			if ok && s == allowed {
+				return s, true
+			}
+		}
+		return nil, false
+	}
+}
+
+// Text returns the plain text of a document, newlines included and embeds
+// as EmbedText
+func (delta Delta) Text() string {
+	var b strings.Builder
+	for _, op := range delta {
+		b.WriteString(op.Insert)
+	}
+	return b.String()
+}
+
+// Headline returns the plain text of a document's first header line, or of
+// its first line with any text when it has no header, without embeds
+func Headline(doc Delta) string {
+	var first string
+	for _, line := range splitLines(doc) {
+		var b strings.Builder
+		for _, op := range line.text {
+			if op.Image == nil {
+				b.WriteString(op.Insert)
+			}
+		}
+		text := strings.TrimSpace(b.String())
+		if text == "" {
+			continue
+		}
+		if line.attributes["header"] != nil {
+			return text
+		}
+		if first == "" {
+			first = text
+		}
+	}
+	return first
+}
+
+// Slice returns the inserts of a document between positions start and end,
+// clamped to the document
+func Slice(doc Delta, start int, end int) Delta {
+	it := &iterator{ops: doc}
+	for start > 0 {
+		piece, ok := it.next(start)
+		if !ok {
+			return nil
+		}
+		start -= utf8.RuneCountInString(piece.Insert)
+		end -= utf8.RuneCountInString(piece.Insert)
+	}
+	var out Delta
+	for end > 0 {
+		piece, ok := it.next(end)
+		if !ok {
+			break
+		}
+		out = append(out, piece)
+		end -= utf8.RuneCountInString(piece.Insert)
+	}
+	return out
+}
+
+// Mark returns a copy of delta with the inline attribute key set on every
+// insert, for rendering only
+func Mark(delta Delta, key string) Delta {
+	out := make(Delta, len(delta))
+	for i, op := range delta {
+		op.Attributes = op.Attributes.apply(Attributes{key: true})
+		out[i] = op
+	}
+	return out
+}
+
+// Normalize validates a document and brings it to canonical form: text and
+// newlines in separate inserts, only the attributes that apply to each,
+// adjacent inserts with equal attributes merged and a trailing newline.
+func Normalize(doc Delta) (Delta, error) {
+	var out Delta
+	for _, op := range doc {
+		if op.Retain != 0 || op.Delete != 0 || op.Insert == "" {
+			return nil, fmt.Errorf("%w: documents may only contain non-empty inserts", ErrInvalidDelta)
+		}
+		var err error
+		if op.Image != nil {
+			out, err = appendEmbed(out, *op.Image, op.Attributes)
+		} else {
+			out, err = appendInsert(out, op.Insert, op.Attributes)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(out) == 0 || !strings.HasSuffix(out[len(out)-1].Insert, "\n") {
+		out = appendOp(out, Op{Insert: "\n"})
+	}
+	return out, nil
+}
+
+// NormalizeChange validates a change and brings it to canonical form.
+// Whether line attributes in retains land on newlines is only known once
+// the change is composed with a document.
+func NormalizeChange(change Delta) (Delta, error) {
+	var out Delta
+	for _, op := range change {
+		kinds := 0
+		for _, set := range []bool{op.Insert != "" || op.Image != nil, op.Retain != 0, op.Delete != 0} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 || op.Retain < 0 || op.Delete < 0 {
+			return nil, fmt.Errorf("%w: each op must insert text or retain or delete a positive length", ErrInvalidDelta)
+		}
+
+		switch {
+		case op.Image != nil:
+			var err error
+			if out, err = appendEmbed(out, *op.Image, op.Attributes); err != nil {
+				return nil, err
+			}
+		case op.Insert != "":
+			var err error
+			if out, err = appendInsert(out, op.Insert, op.Attributes); err != nil {
+				return nil, err
+			}
+		case op.Retain > 0:
+			attributes, err := normalizeAttributes(op.Attributes, true)
+			if err != nil {
+				return nil, err
+			}
+			out = appendOp(out, Op{Retain: op.Retain, Attributes: attributes})
+		default:
+			if op.Attributes != nil {
+				return nil, fmt.Errorf("%w: deletes cannot carry attributes", ErrInvalidDelta)
+			}
+			out = appendOp(out, Op{Delete: op.Delete})
+		}
+	}
+	// A trailing plain retain changes nothing
+	if len(out) > 0 && out[len(out)-1].Retain > 0 && out[len(out)-1].Attributes == nil {
+		out = out[:len(out)-1]
+	}
+	return out, nil
+}
+
+// appendInsert splits text at newlines so each piece only carries the
+// attributes that apply to it: inline ones on text, line ones on newlines
+func appendInsert(out Delta, text string, attributes Attributes) (Delta, error) {
+	attributes, err := normalizeAttributes(attributes, false)
+	if err != nil {
+		return nil, err
+	}
+	inline, line := splitAttributes(attributes)
+	text = sanitize.Text(strings.ReplaceAll(text, EmbedText, ""), true)
+
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		switch {
+		case i < 0:
+			if line != nil {
+				return nil, fmt.Errorf("%w: line attributes must be attached to newlines", ErrInvalidDelta)
+			}
+			return appendOp(out, Op{Insert: text, Attributes: inline}), nil
+		case i > 0:
+			if line != nil {
+				return nil, fmt.Errorf("%w: line attributes must be attached to newlines", ErrInvalidDelta)
+			}
+			out = appendOp(out, Op{Insert: text[:i], Attributes: inline})
+		}
+		out = appendOp(out, Op{Insert: "\n", Attributes: line})
+		text = text[i+1:]
+	}
+	return out, nil
+}
+
+// appendEmbed validates an image and appends it with its inline attributes
+func appendEmbed(out Delta, image Image, attributes Attributes) (Delta, error) {
+	src, ok := sanitize.URL(image.Src, sanitize.ImageSchemes)
+	if !ok {
+		return nil, fmt.Errorf("%w: images need an http or https URL of at most %d characters", ErrInvalidDelta, sanitize.MaxURLLength)
+	}
+	image.Src = src
+	image.Alt = strings.Join(strings.Fields(image.Alt), " ")
+	if utf8.RuneCountInString(image.Alt) > maxAltLength {
+		return nil, fmt.Errorf("%w: alt text is limited to %d characters", ErrInvalidDelta, maxAltLength)
+	}
+
+	attributes, err := normalizeAttributes(attributes, false)
+	if err != nil {
+		return nil, err
+	}
+	inline, line := splitAttributes(attributes)
+	if line != nil {
+		return nil, fmt.Errorf("%w: line attributes must be attached to newlines", ErrInvalidDelta)
+	}
+	return append(out, Op{Insert: EmbedText, Image: &image, Attributes: inline}), nil
+}
+
+func normalizeAttributes(attributes Attributes, allowRemoval bool) (Attributes, error) {
+	var out Attributes
+	for key, value := range attributes {
+		spec, ok := attributeSpecs[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown attribute %q", ErrInvalidDelta, key)
+		}
+		if value == nil || value == false {
+			if !allowRemoval {
+				continue
+			}
+			value = nil
+		} else if value, ok = spec.valid(value); !ok {
+			return nil, fmt.Errorf("%w: invalid value for attribute %q", ErrInvalidDelta, key)
+		}
+		if out == nil {
+			out = make(Attributes)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+func splitAttributes(attributes Attributes) (inline Attributes, line Attributes) {
+	for key, value := range attributes {
+		target := &inline
+		if attributeSpecs[key].line {
+			target = &line
+		}
+		if *target == nil {
+			*target = make(Attributes)
+		}
+		(*target)[key] = value
+	}
+	return inline, line
+}
+
+// appendOp merges op into the last op when they are of the same kind with
+// the same attributes. Embeds are never merged.
+func appendOp(out Delta, op Op) Delta {
+	if len(out) > 0 {
+		last := &out[len(out)-1]
+		if last.Image == nil && op.Image == nil && maps.Equal(last.Attributes, op.Attributes) {
+			switch {
+			case last.Insert != "" && op.Insert != "":
+				last.Insert += op.Insert
+				return out
+			case last.Retain > 0 && op.Retain > 0:
+				last.Retain += op.Retain
+				return out
+			case last.Delete > 0 && op.Delete > 0:
+				last.Delete += op.Delete
+				return out
+			}
+		}
+	}
+	return append(out, op)
+}
+
+// Compose applies a normalized change to a normalized document and
+// returns the resulting normalized document
+func Compose(doc Delta, change Delta) (Delta, error) {
+	it := &iterator{ops: doc}
+	var out Delta
+	for _, op := range change {
+		switch {
+		case op.Insert != "":
+			inserted := Op{Insert: op.Insert, Image: op.Image}
+			for key, value := range op.Attributes {
+				if value != nil {
+					if inserted.Attributes == nil {
+						inserted.Attributes = make(Attributes)
+					}
+					inserted.Attributes[key] = value
+				}
+			}
+			out = append(out, inserted)
+		case op.Retain > 0:
+			for n := op.Retain; n > 0; {
+				piece, ok := it.next(n)
+				if !ok {
+					return nil, ErrLengthMismatch
+				}
+				piece.Attributes = piece.Attributes.apply(op.Attributes)
+				out = append(out, piece)
+				n -= utf8.RuneCountInString(piece.Insert)
+			}
+		case op.Delete > 0:
+			for n := op.Delete; n > 0; {
+				piece, ok := it.next(n)
+				if !ok {
+					return nil, ErrLengthMismatch
+				}
+				n -= utf8.RuneCountInString(piece.Insert)
+			}
+		}
+	}
+	for {
+		piece, ok := it.next(-1)
+		if !ok {
+			break
+		}
+		out = append(out, piece)
+	}
+
+	composed, err := Normalize(out)
+	if err != nil {
+		return nil, err
+	}
+	// Normalize would add a missing final newline; a change that deleted
+	// it is malformed rather than something to repair
+	if len(out) == 0 || !strings.HasSuffix(out[len(out)-1].Insert, "\n") {
+		return nil, fmt.Errorf("%w: the final newline cannot be removed", ErrInvalidDelta)
+	}
+	return composed, nil
+}
+
+// MergeInserts returns the single change that makes normalized change a
+// and then b, when each only inserts in one place and b inserts right
+// after what a inserted, as typing does. It reports false otherwise.
+func MergeInserts(a Delta, b Delta) (Delta, bool) {
+	posA, insertedA, ok := insertion(a)
+	if !ok {
+		return nil, false
+	}
+	posB, insertedB, ok := insertion(b)
+	if !ok {
+		return nil, false
+	}
+	end := posA
+	for _, op := range insertedA {
+		end += op.length()
+	}
+	if posB != end {
+		return nil, false
+	}
+
+	var merged Delta
+	if posA > 0 {
+		merged = Delta{{Retain: posA}}
+	}
+	for _, op := range slices.Concat(insertedA, insertedB) {
+		merged = appendOp(merged, op)
+	}
+	return merged, true
+}
+
+// insertion returns where change inserts and what, if that is all it does
+func insertion(change Delta) (pos int, inserted Delta, ok bool) {
+	if len(change) > 0 && change[0].Retain > 0 && change[0].Attributes == nil {
+		pos, change = change[0].Retain, change[1:]
+	}
+	if len(change) == 0 {
+		return 0, nil, false
+	}
+	for _, op := range change {
+		if op.Insert == "" {
+			return 0, nil, false
+		}
+	}
+	return pos, change, true
+}
+
+// apply returns a copy of attributes with changes applied
+func (attributes Attributes) apply(changes Attributes) Attributes {
+	if len(changes) == 0 {
+		return attributes
+	}
+	out := make(Attributes, len(attributes)+len(changes))
+	maps.Copy(out, attributes)
+	for key, value := range changes {
+		if value == nil {
+			delete(out, key)
+		} else {
+			out[key] = value
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// iterator walks the inserts of a document a given number of code points
+// at a time
+type iterator struct {
+	ops    Delta
+	index  int
+	offset int
+}
+
+// next returns up to n code points of the next insert, or the rest of it
+// when n is negative
+func (it *iterator) next(n int) (Op, bool) {
+	if it.index >= len(it.ops) {
+		return Op{}, false
+	}
+	op := it.ops[it.index]
+	rest := op.Insert[it.offset:]
+	if n < 0 || utf8.RuneCountInString(rest) <= n {
+		it.index++
+		it.offset = 0
+		return Op{Insert: rest, Image: op.Image, Attributes: op.Attributes}, true
+	}
+
+	size := 0
+	for i := 0; i < n; i++ {
+		_, width := utf8.DecodeRuneInString(rest[size:])
+		size += width
+	}
+	it.offset += size
+	return Op{Insert: rest[:size], Attributes: op.Attributes}, true
+}
@@ -0,0 +1,402 @@
+package richtext
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"backend/sanitize"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements whose content is not document text
+var ignoredElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Title: true, atom.Script: true, atom.Style: true,
+	atom.Template: true, atom.Noscript: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Svg: true,
+}
+
+// Elements that hold one or more lines of their own
+var lineElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Address: true, atom.Article: true,
+	atom.Aside: true, atom.Footer: true, atom.Header: true, atom.Main: true,
+	atom.Nav: true, atom.Section: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Dt: true, atom.Dd: true, atom.Tr: true,
+}
+
+var headerLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+var inlineMarks = map[atom.Atom]string{
+	atom.B: "bold", atom.Strong: "bold", atom.I: "italic", atom.Em: "italic",
+	atom.U: "underline", atom.S: "strike", atom.Strike: "strike", atom.Del: "strike",
+	atom.Code: "code",
+}
+
+// FromHTML converts editor HTML into a normalized document. Formatting the
+// model has no attribute for is dropped, keeping the text.
+func FromHTML(content string) (Delta, error) {
+	body := &nethtml.Node{Type: nethtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := nethtml.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &htmlReader{}
+	for _, node := range nodes {
+		reader.node(node)
+	}
+	if reader.lineOpen {
+		reader.newline()
+	}
+	return Normalize(reader.ops)
+}
+
+type htmlReader struct {
+	ops      Delta
+	inline   Attributes
+	line     Attributes
+	lists    []string
+	lineOpen bool
+	space    bool
+	pre      int
+}
+
+func (reader *htmlReader) insert(text string) {
+	reader.ops = append(reader.ops, Op{Insert: text, Attributes: reader.inline})
+	reader.lineOpen = true
+	reader.space = strings.HasSuffix(text, " ")
+}
+
+// newline ends the current line, dropping the space collapsed whitespace
+// may have left at its end
+func (reader *htmlReader) newline() {
+	if n := len(reader.ops); n > 0 && reader.pre == 0 {
+		last := &reader.ops[n-1]
+		if last.Insert != "\n" {
+			last.Insert = strings.TrimRight(last.Insert, " ")
+			if last.Insert == "" {
+				reader.ops = reader.ops[:n-1]
+			}
+		}
+	}
+	reader.ops = append(reader.ops, Op{Insert: "\n", Attributes: reader.line})
+	reader.lineOpen = false
+	reader.space = false
+}
+
+func (reader *htmlReader) text(s string) {
+	if reader.pre > 0 {
+		for i, segment := range strings.Split(s, "\n") {
+			if i > 0 {
+				reader.newline()
+			}
+			if segment != "" {
+				reader.insert(segment)
+			}
+		}
+		return
+	}
+
+	words := strings.FieldsFunc(s, unicode.IsSpace)
+	leading := s != "" && unicode.IsSpace(rune(s[0]))
+	trailing := s != "" && unicode.IsSpace(rune(s[len(s)-1]))
+	for i, word := range words {
+		if (i > 0 || leading) && reader.lineOpen && !reader.space {
+			reader.insert(" ")
+		}
+		reader.insert(word)
+	}
+	if trailing && reader.lineOpen && !reader.space {
+		reader.insert(" ")
+	}
+}
+
+func (reader *htmlReader) children(node *nethtml.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		reader.node(child)
+	}
+}
+
+// withInline runs fn with an inline attribute added
+func (reader *htmlReader) withInline(key string, value any, fn func()) {
+	saved := reader.inline
+	reader.inline = saved.apply(Attributes{key: value})
+	fn()
+	reader.inline = saved
+}
+
+// block reads node as lines carrying the given line attributes on top of
+// those of the enclosing blocks
+func (reader *htmlReader) block(node *nethtml.Node, line Attributes) {
+	if reader.lineOpen {
+		reader.newline()
+	}
+	if dir, ok := isDirection(attr(node, "dir")); ok {
+		line = line.apply(Attributes{"direction": dir})
+	}
+	saved := reader.line
+	reader.line = saved.apply(line)
+	start := len(reader.ops)
+
+	reader.children(node)
+
+	ended := false
+	for _, op := range reader.ops[start:] {
+		ended = ended || op.Insert == "\n"
+	}
+	if reader.lineOpen || !ended {
+		reader.newline()
+	}
+	reader.line = saved
+}
+
+func (reader *htmlReader) node(node *nethtml.Node) {
+	switch node.Type {
+	case nethtml.TextNode:
+		reader.text(node.Data)
+		return
+	case nethtml.ElementNode:
+	default:
+		reader.children(node)
+		return
+	}
+	if ignoredElements[node.DataAtom] {
+		return
+	}
+
+	if mark, ok := inlineMarks[node.DataAtom]; ok && !(mark == "code" && reader.pre > 0) {
+		reader.withInline(mark, true, func() { reader.children(node) })
+		return
+	}
+	if level, ok := headerLevels[node.DataAtom]; ok {
+		reader.block(node, Attributes{"header": level})
+		return
+	}
+
+	switch node.DataAtom {
+	case atom.Br:
+		reader.newline()
+	case atom.Img:
+		if src, ok := sanitize.URL(attr(node, "src"), sanitize.ImageSchemes); ok {
+			image := Image{Src: src, Alt: attr(node, "alt")}
+			reader.ops = append(reader.ops, Op{Insert: EmbedText, Image: &image, Attributes: reader.inline})
+			reader.lineOpen = true
+			reader.space = false
+		}
+	case atom.A:
+		if link, ok := isLink(attr(node, "href")); ok {
+			reader.withInline("link", link, func() { reader.children(node) })
+		} else {
+			reader.children(node)
+		}
+	case atom.Ul, atom.Ol:
+		if reader.lineOpen {
+			reader.newline()
+		}
+		listType := "bullet"
+		if node.DataAtom == atom.Ol {
+			listType = "ordered"
+		}
+		saved := reader.line
+		if dir, ok := isDirection(attr(node, "dir")); ok {
+			reader.line = saved.apply(Attributes{"direction": dir})
+		}
+		reader.lists = append(reader.lists, listType)
+		reader.children(node)
+		reader.lists = reader.lists[:len(reader.lists)-1]
+		reader.line = saved
+	case atom.Li:
+		line := Attributes{"list": "bullet"}
+		if depth := len(reader.lists); depth > 0 {
+			line["list"] = reader.lists[depth-1]
+			if depth > 1 {
+				line["indent"] = min(depth-1, 8)
+			}
+		}
+		reader.block(node, line)
+	case atom.Blockquote:
+		reader.block(node, Attributes{"blockquote": true})
+	case atom.Pre:
+		reader.pre++
+		reader.block(node, Attributes{"code-block": true})
+		reader.pre--
+	case atom.Td, atom.Th:
+		if reader.lineOpen && !reader.space {
+			reader.insert(" ")
+		}
+		reader.children(node)
+	default:
+		if lineElements[node.DataAtom] {
+			reader.block(node, nil)
+		} else {
+			reader.children(node)
+		}
+	}
+}
+
+func attr(node *nethtml.Node, key string) string {
+	for _, a := range node.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// Inline attributes in the order their elements are nested, outermost first
+var inlineTags = []struct {
+	attribute string
+	tag       string
+}{
+	{TrackedDelete, "del"}, {TrackedInsert, "ins"}, {"bold", "b"}, {"italic", "i"}, {"underline", "u"}, {"strike", "s"}, {"code", "code"},
+}
+
+type htmlLine struct {
+	text       []Op
+	attributes Attributes
+}
+
+// ToHTML renders a document the way the editor lays content out: a <div>
+// per plain line, with headers, list items, quotes and code blocks in
+// their own elements. Lines with a direction of their own carry a dir
+// attribute; code blocks are always left to right.
+func ToHTML(doc Delta) string {
+	var b strings.Builder
+	var lists []string
+	inPre := false
+
+	for _, line := range splitLines(doc) {
+		codeBlock := line.attributes["code-block"] == true
+		if inPre && !codeBlock {
+			b.WriteString("</pre>")
+			inPre = false
+		}
+
+		depth := 0
+		tag := ""
+		switch line.attributes["list"] {
+		case "bullet":
+			tag = "ul"
+		case "ordered":
+			tag = "ol"
+		}
+		if tag != "" {
+			indent, _ := line.attributes["indent"].(int)
+			depth = indent + 1
+		}
+		for len(lists) > depth || (len(lists) == depth && depth > 0 && lists[depth-1] != tag) {
+			b.WriteString("</" + lists[len(lists)-1] + ">")
+			lists = lists[:len(lists)-1]
+		}
+		for len(lists) < depth {
+			b.WriteString("<" + tag + ">")
+			lists = append(lists, tag)
+		}
+
+		if codeBlock {
+			if !inPre {
+				// A newline right after <pre> is dropped by parsers, so one is
+				// always written to protect leading blank lines
+				b.WriteString("<pre>\n")
+				inPre = true
+			} else {
+				b.WriteString("\n")
+			}
+			for _, op := range line.text {
+				b.WriteString(inlineContent(op))
+			}
+			continue
+		}
+
+		content := renderInline(line.text)
+		if content == "" {
+			content = "<br>"
+		}
+		dir := ""
+		if direction, ok := line.attributes["direction"].(string); ok {
+			dir = ` dir="` + direction + `"`
+		}
+		switch {
+		case tag != "":
+			b.WriteString("<li" + dir + ">" + content + "</li>")
+		case line.attributes["header"] != nil:
+			level := fmt.Sprint(line.attributes["header"])
+			b.WriteString("<h" + level + dir + ">" + content + "</h" + level + ">")
+		case line.attributes["blockquote"] == true:
+			b.WriteString("<blockquote" + dir + ">" + content + "</blockquote>")
+		default:
+			b.WriteString("<div" + dir + ">" + content + "</div>")
+		}
+	}
+
+	if inPre {
+		b.WriteString("</pre>")
+	}
+	for len(lists) > 0 {
+		b.WriteString("</" + lists[len(lists)-1] + ">")
+		lists = lists[:len(lists)-1]
+	}
+	return b.String()
+}
+
+func splitLines(doc Delta) []htmlLine {
+	var lines []htmlLine
+	var current []Op
+	for _, op := range doc {
+		text := op.Insert
+		for text != "" {
+			i := strings.IndexByte(text, '\n')
+			if i < 0 {
+				current = append(current, Op{Insert: text, Image: op.Image, Attributes: op.Attributes})
+				break
+			}
+			if i > 0 {
+				current = append(current, Op{Insert: text[:i], Attributes: op.Attributes})
+			}
+			lines = append(lines, htmlLine{text: current, attributes: op.Attributes})
+			current = nil
+			text = text[i+1:]
+		}
+	}
+	if len(current) > 0 {
+		lines = append(lines, htmlLine{text: current})
+	}
+	return lines
+}
+
+// inlineContent renders an insert without its formatting
+func inlineContent(op Op) string {
+	if op.Image != nil {
+		return `<img src="` + html.EscapeString(op.Image.Src) + `" alt="` + html.EscapeString(op.Image.Alt) + `">`
+	}
+	return html.EscapeString(op.Insert)
+}
+
+func renderInline(ops []Op) string {
+	var b strings.Builder
+	for _, op := range ops {
+		if link, ok := op.Attributes["link"].(string); ok {
+			b.WriteString(`<a href="` + html.EscapeString(link) + `">`)
+		}
+		for _, inline := range inlineTags {
+			if op.Attributes[inline.attribute] == true {
+				b.WriteString("<" + inline.tag + ">")
+			}
+		}
+		b.WriteString(inlineContent(op))
+		for i := len(inlineTags) - 1; i >= 0; i-- {
+			if op.Attributes[inlineTags[i].attribute] == true {
+				b.WriteString("</" + inlineTags[i].tag + ">")
+			}
+		}
+		if _, ok := op.Attributes["link"].(string); ok {
+			b.WriteString("</a>")
+		}
+	}
+	return b.String()
+}
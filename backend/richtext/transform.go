@@ -0,0 +1,121 @@
+package richtext
+
+import (
+	"maps"
+	"unicode/utf8"
+)
+
+// Transform rewrites change b so it applies after change a, both having
+// been made to the same document. When both insert at the same position,
+// a's text comes first if aFirst is set. Text b retains or deletes that a
+// deleted is left out; where both set the same attribute, a's value wins
+// if aFirst is set.
+func Transform(a Delta, b Delta, aFirst bool) Delta {
+	itA, itB := &changeIterator{ops: a}, &changeIterator{ops: b}
+	var out Delta
+	for itA.more() || itB.more() {
+		switch {
+		case itA.peek().Insert != "" && (aFirst || itB.peek().Insert == ""):
+			out = appendOp(out, Op{Retain: itA.next(-1).length()})
+		case itB.peek().Insert != "":
+			out = appendOp(out, itB.next(-1))
+		default:
+			n := min(itA.peek().length(), itB.peek().length())
+			opA, opB := itA.next(n), itB.next(n)
+			switch {
+			case opA.Delete > 0:
+				// b's retain or delete of text a deleted vanishes
+			case opB.Delete > 0:
+				out = appendOp(out, opB)
+			default:
+				out = appendOp(out, Op{Retain: n, Attributes: transformAttributes(opA.Attributes, opB.Attributes, aFirst)})
+			}
+		}
+	}
+	// A trailing retain without attributes changes nothing
+	if len(out) > 0 && out[len(out)-1].Retain > 0 && len(out[len(out)-1].Attributes) == 0 {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// transformAttributes returns the attribute changes of b that still apply
+// after those of a
+func transformAttributes(a Attributes, b Attributes, aFirst bool) Attributes {
+	if !aFirst || len(b) == 0 {
+		return b
+	}
+	out := maps.Clone(b)
+	for key := range a {
+		delete(out, key)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func (op Op) length() int {
+	switch {
+	case op.Retain > 0:
+		return op.Retain
+	case op.Delete > 0:
+		return op.Delete
+	}
+	return utf8.RuneCountInString(op.Insert)
+}
+
+// changeIterator walks the ops of a change a given length at a time. Past
+// the end it yields an endless retain.
+type changeIterator struct {
+	ops    Delta
+	index  int
+	offset int
+}
+
+func (it *changeIterator) more() bool {
+	return it.index < len(it.ops)
+}
+
+// peek returns what is left of the current op
+func (it *changeIterator) peek() Op {
+	if !it.more() {
+		return Op{Retain: int(^uint(0) >> 1)}
+	}
+	op := it.ops[it.index]
+	switch {
+	case op.Retain > 0:
+		op.Retain -= it.offset
+	case op.Delete > 0:
+		op.Delete -= it.offset
+	default:
+		op.Insert = string([]rune(op.Insert)[it.offset:])
+	}
+	return op
+}
+
+// next returns up to n of what is left of the current op, or all of it
+// when n is negative
+func (it *changeIterator) next(n int) Op {
+	op := it.peek()
+	if !it.more() {
+		op.Retain = n
+		return op
+	}
+	length := op.length()
+	if n < 0 || n >= length {
+		it.index++
+		it.offset = 0
+		return op
+	}
+	it.offset += n
+	switch {
+	case op.Retain > 0:
+		op.Retain = n
+	case op.Delete > 0:
+		op.Delete = n
+	default:
+		op.Insert = string([]rune(op.Insert)[:n])
+	}
+	return op
+}
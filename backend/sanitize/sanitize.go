@@ -0,0 +1,62 @@
+// Package sanitize holds the rules user input is checked against before it
+// is stored or sent on to other users: which characters plain text may
+// hold and where links and images may point. Markup is never passed on
+// as written: documents are parsed into the model, which keeps only the
+// formatting it knows, and other text is escaped or rendered as text.
+package sanitize
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxURLLength bounds links and image sources, in characters
+const MaxURLLength = 2048
+
+// Schemes links and images may use. A relative link has no scheme.
+var (
+	LinkSchemes  = []string{"", "http", "https", "mailto"}
+	ImageSchemes = []string{"http", "https"}
+)
+
+// Text strips control characters from s, other than newlines and tabs
+// when multiline
+func Text(s string, multiline bool) string {
+	if !HasControl(s, multiline) {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if isControl(r, multiline) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// HasControl reports whether s contains control characters, other than
+// newlines and tabs when multiline
+func HasControl(s string, multiline bool) bool {
+	return strings.ContainsFunc(s, func(r rune) bool { return isControl(r, multiline) })
+}
+
+func isControl(r rune, multiline bool) bool {
+	return unicode.IsControl(r) && !(multiline && (r == '\n' || r == '\t'))
+}
+
+// URL returns raw, trimmed, if it is a URL with one of schemes no longer
+// than MaxURLLength. Schemes are matched ignoring case, so JavaScript: is
+// refused like javascript:.
+func URL(raw string, schemes []string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || utf8.RuneCountInString(raw) > MaxURLLength || HasControl(raw, false) {
+		return "", false
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || !slices.Contains(schemes, strings.ToLower(parsed.Scheme)) {
+		return "", false
+	}
+	return raw, true
+}
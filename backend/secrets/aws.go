@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/awssig"
+)
+
+// AWSProvider reads secrets from AWS Secrets Manager. Credentials come from
+// the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optional
+// AWS_SESSION_TOKEN environment variables.
+type AWSProvider struct {
+	Region   string
+	Endpoint string
+	Client   *http.Client
+}
+
+func NewAWSProvider(region string) *AWSProvider {
+	return &AWSProvider{
+		Region:   region,
+		Endpoint: "https://secretsmanager." + region + ".amazonaws.com/",
+		Client:   http.DefaultClient,
+	}
+}
+
+type getSecretValueResponse struct {
+	SecretString *string
+	SecretBinary []byte
+}
+
+type awsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (provider *AWSProvider) Fetch(ctx context.Context, name string) (string, error) {
+	creds, err := awssig.FromEnv()
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": name})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	awssig.Sign(req, awssig.PayloadHash(body), creds, provider.Region, "secretsmanager", time.Now())
+
+	resp, err := provider.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure awsError
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&failure)
+		if strings.HasSuffix(failure.Type, "ResourceNotFoundException") {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secrets manager returned %s: %s", resp.Status, failure.Type)
+	}
+
+	var secret getSecretValueResponse
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decoding secrets manager response: %w", err)
+	}
+	if secret.SecretString != nil {
+		return *secret.SecretString, nil
+	}
+	return base64.StdEncoding.EncodeToString(secret.SecretBinary), nil
+}
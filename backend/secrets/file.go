@@ -0,0 +1,31 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileProvider reads each secret from a file named after it, the layout
+// Docker and Kubernetes use for mounted secrets
+type FileProvider struct {
+	Dir string
+}
+
+func (provider *FileProvider) Fetch(_ context.Context, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	raw, err := os.ReadFile(filepath.Join(provider.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
@@ -0,0 +1,204 @@
+// Package secrets resolves references in configuration values to secrets
+// held in an external store, so credentials never have to be passed in
+// plaintext
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+)
+
+// RefPrefix marks a configuration value as a reference. The rest is the
+// secret's name in the provider, optionally followed by "#field" to select
+// one field of a secret holding a JSON object.
+const RefPrefix = "secret:"
+
+var ErrNotFound = errors.New("secret not found")
+
+// Timeout for fetching a single secret
+const fetchTimeout = 10 * time.Second
+
+// Provider fetches the current value of a secret by name
+type Provider interface {
+	Fetch(ctx context.Context, name string) (string, error)
+}
+
+// IsRef reports whether a configuration value is a secret reference
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, RefPrefix)
+}
+
+// NewProvider builds the provider selected in cfg. It returns nil when no
+// provider is configured.
+func NewProvider(cfg config.SecretsConfig) (Provider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "file":
+		return &FileProvider{Dir: cfg.Dir}, nil
+	case "vault":
+		return NewVaultProvider(cfg.VaultAddr, cfg.VaultMount, cfg.VaultTokenFile), nil
+	case "aws":
+		return NewAWSProvider(cfg.AWSRegion), nil
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", cfg.Provider)
+	}
+}
+
+// Resolver replaces references with the secrets they name and keeps the
+// resolved values current by re-fetching them periodically. A nil Resolver
+// leaves plain values alone and rejects references.
+type Resolver struct {
+	provider Provider
+	logger   *slog.Logger
+
+	mutex     sync.RWMutex
+	values    map[string]string
+	listeners []func(ref string)
+
+	done chan struct{}
+	once sync.Once
+}
+
+func NewResolver(provider Provider, logger *slog.Logger) *Resolver {
+	return &Resolver{
+		provider: provider,
+		logger:   logger,
+		values:   make(map[string]string),
+		done:     make(chan struct{}),
+	}
+}
+
+// Resolve replaces every reference among values, in place, with the secret
+// it names. Values that are not references are left untouched.
+func (resolver *Resolver) Resolve(ctx context.Context, values ...*string) error {
+	for _, value := range values {
+		if !IsRef(*value) {
+			continue
+		}
+		if resolver == nil {
+			return fmt.Errorf("%q references a secret but no secrets provider is configured", *value)
+		}
+		secret, err := resolver.fetch(ctx, *value)
+		if err != nil {
+			return err
+		}
+
+		resolver.mutex.Lock()
+		resolver.values[*value] = secret
+		resolver.mutex.Unlock()
+		*value = secret
+	}
+	return nil
+}
+
+// Get returns the latest value of a reference resolved earlier
+func (resolver *Resolver) Get(ref string) (string, bool) {
+	if resolver == nil {
+		return "", false
+	}
+	resolver.mutex.RLock()
+	defer resolver.mutex.RUnlock()
+
+	value, ok := resolver.values[ref]
+	return value, ok
+}
+
+// OnChange registers fn to be called with each reference whose secret has
+// changed since it was last fetched
+func (resolver *Resolver) OnChange(fn func(ref string)) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	resolver.listeners = append(resolver.listeners, fn)
+}
+
+// Refresh re-fetches every resolved reference. A failed fetch keeps the
+// previous value.
+func (resolver *Resolver) Refresh(ctx context.Context) {
+	resolver.mutex.RLock()
+	refs := make([]string, 0, len(resolver.values))
+	for ref := range resolver.values {
+		refs = append(refs, ref)
+	}
+	resolver.mutex.RUnlock()
+
+	for _, ref := range refs {
+		secret, err := resolver.fetch(ctx, ref)
+		if err != nil {
+			resolver.logger.Warn("Could not refresh secret", "ref", ref, "error", err)
+			continue
+		}
+
+		resolver.mutex.Lock()
+		changed := resolver.values[ref] != secret
+		resolver.values[ref] = secret
+		listeners := resolver.listeners
+		resolver.mutex.Unlock()
+
+		if changed {
+			for _, fn := range listeners {
+				fn(ref)
+			}
+		}
+	}
+}
+
+// Run refreshes the resolved secrets every interval until Close is called
+func (resolver *Resolver) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			resolver.Refresh(context.Background())
+		case <-resolver.done:
+			return
+		}
+	}
+}
+
+// Close stops a running refresh loop
+func (resolver *Resolver) Close() {
+	if resolver == nil {
+		return
+	}
+	resolver.once.Do(func() { close(resolver.done) })
+}
+
+func (resolver *Resolver) fetch(ctx context.Context, ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	name, field, hasField := strings.Cut(strings.TrimPrefix(ref, RefPrefix), "#")
+	secret, err := resolver.provider.Fetch(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("fetching secret %q: %w", name, err)
+	}
+	if !hasField {
+		return secret, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret %q is not a JSON object", name)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret %q has no field %q", name, field)
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	raw, err := json.Marshal(value)
+	return string(raw), err
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// VaultProvider reads secrets from a Vault KV version 2 engine. Each secret
+// is returned as a JSON object of its fields, so references normally name a
+// field: "secret:collab/db#password".
+//
+// The token is read from TokenFile on every fetch, so a Vault agent can
+// renew it, and falls back to the VAULT_TOKEN environment variable.
+type VaultProvider struct {
+	Addr      string
+	Mount     string
+	TokenFile string
+	Client    *http.Client
+}
+
+func NewVaultProvider(addr string, mount string, tokenFile string) *VaultProvider {
+	return &VaultProvider{Addr: addr, Mount: mount, TokenFile: tokenFile, Client: http.DefaultClient}
+}
+
+type vaultResponse struct {
+	Data struct {
+		Data map[string]any `json:"data"`
+	} `json:"data"`
+}
+
+func (provider *VaultProvider) Fetch(ctx context.Context, name string) (string, error) {
+	token, err := provider.token()
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.JoinPath(provider.Addr, "v1", provider.Mount, "data", name)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := provider.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding vault response: %w", err)
+	}
+	if body.Data.Data == nil {
+		return "", ErrNotFound
+	}
+	raw, err := json.Marshal(body.Data.Data)
+	return string(raw), err
+}
+
+func (provider *VaultProvider) token() (string, error) {
+	if provider.TokenFile != "" {
+		raw, err := os.ReadFile(provider.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading vault token: %w", err)
+		}
+		return strings.TrimSpace(string(raw)), nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("no vault token: set a token file or VAULT_TOKEN")
+}
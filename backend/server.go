@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/docrpc"
+	"backend/rbac"
+	"backend/socket"
+
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// How long a shutdown waits for clients to be told and requests to finish
+const shutdownTimeout = 10 * time.Second
+
+// serve runs the server until ctx ends, then calls drain to disconnect the
+// clients it holds and shuts down once requests in flight finish
+func serve(ctx context.Context, handler http.Handler, cfg *config.Config, logger *slog.Logger, drain func(context.Context)) error {
+	server := &http.Server{Addr: cfg.ListenAddr, Handler: handler}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- listen(server, cfg, logger)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Info("Server shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	drain(shutdown)
+	if err := server.Shutdown(shutdown); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listen runs server on cfg.ListenAddr, over HTTPS when TLS is
+// configured. With TLS, plain HTTP on the redirect address sends browsers
+// to the HTTPS server and answers Let's Encrypt challenges.
+func listen(server *http.Server, cfg *config.Config, logger *slog.Logger) error {
+	if !cfg.TLS.Enabled() {
+		return server.ListenAndServe()
+	}
+
+	redirect := redirectToHTTPS(cfg.ListenAddr)
+	if len(cfg.TLS.AutocertHosts) > 0 {
+		certs := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLS.AutocertHosts...),
+			Cache:      autocert.DirCache(cfg.TLS.AutocertCacheDir),
+			Email:      cfg.TLS.AutocertEmail,
+		}
+		server.TLSConfig = certs.TLSConfig()
+		redirect = certs.HTTPHandler(redirect)
+	}
+
+	if cfg.TLS.RedirectAddr != "" {
+		go func() {
+			logger.Info("HTTP redirect starting", "addr", cfg.TLS.RedirectAddr)
+			if err := http.ListenAndServe(cfg.TLS.RedirectAddr, redirect); err != nil {
+				logger.Error("HTTP redirect error", "error", err)
+			}
+		}()
+	}
+	// Certificate files are empty with autocert, which supplies them
+	// through the TLS config instead
+	return server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+}
+
+// serveGRPC starts the internal gRPC API on cfg.GRPCAddr, with the TLS
+// certificate files if there are any
+func serveGRPC(cfg *config.Config, manager *socket.WebSocketManager, authorizer *rbac.Authorizer, logger *slog.Logger) (*grpc.Server, error) {
+	var options []grpc.ServerOption
+	if cfg.TLS.CertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	if !authorizer.Enabled() {
+		logger.Warn("No API tokens configured, every gRPC call will be refused")
+	}
+	listener, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		return nil, err
+	}
+	server := docrpc.NewGRPCServer(manager, authorizer, options...)
+	go func() {
+		logger.Info("gRPC server starting", "addr", cfg.GRPCAddr, "tls", cfg.TLS.CertFile != "")
+		if err := server.Serve(listener); err != nil {
+			logger.Error("gRPC server error", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+// redirectToHTTPS sends requests to the same host and path on the HTTPS
+// listen address, keeping the method with a 308
+func redirectToHTTPS(listenAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(listenAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,110 @@
+// Package share signs the tokens behind invitation links. A token names a
+// document, the role the link grants and when it stops working, so
+// collaborators can join without an account each.
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Roles a link can grant
+const (
+	RoleEdit = "edit"
+	RoleView = "view"
+)
+
+var (
+	ErrInvalidRole   = errors.New("role must be edit or view")
+	ErrInvalidToken  = errors.New("share token is invalid")
+	ErrExpired       = errors.New("share link has expired")
+	ErrWrongDocument = errors.New("share link is for another document")
+)
+
+// Grant is what a valid token lets its holder do
+type Grant struct {
+	DocID     string    `json:"docId"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// claims is the signed part of a token, kept short for URLs
+type claims struct {
+	DocID   string `json:"d"`
+	Role    string `json:"r"`
+	Expires int64  `json:"e"`
+}
+
+// Signer issues and verifies tokens with an HMAC key. Nodes verifying each
+// other's tokens need the same key.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner signs with key, or with a random key when it is empty, in
+// which case tokens stop working when the process restarts
+func NewSigner(key []byte) *Signer {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &Signer{key: key}
+}
+
+// Issue returns a token granting role on docID until expiresAt
+func (signer *Signer) Issue(docID string, role string, expiresAt time.Time) (string, error) {
+	if role != RoleEdit && role != RoleView {
+		return "", ErrInvalidRole
+	}
+	payload, err := json.Marshal(claims{DocID: docID, Role: role, Expires: expiresAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signer.sign(encoded)), nil
+}
+
+// Verify checks that token was signed with this key, is still valid and
+// is for docID, and returns what it grants
+func (signer *Signer) Verify(token string, docID string) (Grant, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Grant{}, ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, signer.sign(encoded)) {
+		return Grant{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Grant{}, ErrInvalidToken
+	}
+	var signed claims
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return Grant{}, ErrInvalidToken
+	}
+	if signed.Role != RoleEdit && signed.Role != RoleView {
+		return Grant{}, ErrInvalidToken
+	}
+
+	grant := Grant{DocID: signed.DocID, Role: signed.Role, ExpiresAt: time.Unix(signed.Expires, 0).UTC()}
+	if !time.Now().Before(grant.ExpiresAt) {
+		return Grant{}, ErrExpired
+	}
+	if grant.DocID != docID {
+		return Grant{}, ErrWrongDocument
+	}
+	return grant, nil
+}
+
+func (signer *Signer) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, signer.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
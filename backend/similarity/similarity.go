@@ -0,0 +1,160 @@
+// Package similarity finds documents whose text substantially duplicates
+// another's, using MinHash signatures of word shingles
+package similarity
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// Words per shingle
+	shingleSize = 3
+
+	// Hash functions per signature; the similarity estimate is within
+	// about 0.1 of the true Jaccard index
+	signatureSize = 128
+
+	// Documents with fewer words are too short to call duplicates, and
+	// would otherwise all match each other when empty
+	minWords = 20
+)
+
+// Match is a document similar to the one queried. Similarity estimates
+// the share of word sequences the two have in common, from 0 to 1.
+type Match struct {
+	DocID      string  `json:"docId"`
+	Similarity float64 `json:"similarity"`
+}
+
+type entry struct {
+	revision  int64
+	signature []uint64
+}
+
+// Index keeps a signature of every document it has been given, including
+// those since unloaded from memory
+type Index struct {
+	mutex   sync.Mutex
+	entries map[string]entry
+	seeds   [signatureSize]uint64
+}
+
+func NewIndex() *Index {
+	index := &Index{entries: make(map[string]entry)}
+	seed := uint64(0x9e3779b97f4a7c15)
+	for i := range index.seeds {
+		seed = mix(seed + uint64(i))
+		index.seeds[i] = seed
+	}
+	return index
+}
+
+// Current reports whether the index already holds docID at revision
+func (index *Index) Current(docID string, revision int64) bool {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	existing, ok := index.entries[docID]
+	return ok && existing.revision == revision
+}
+
+// Update indexes the text of docID at revision unless a later revision is
+// already indexed. Text too short to compare removes the document from the
+// index.
+func (index *Index) Update(docID string, revision int64, text string) {
+	signature := index.sign(text)
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if existing, ok := index.entries[docID]; ok && existing.revision > revision {
+		return
+	}
+	if signature == nil {
+		delete(index.entries, docID)
+		return
+	}
+	index.entries[docID] = entry{revision: revision, signature: signature}
+}
+
+// Remove drops docID from the index
+func (index *Index) Remove(docID string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	delete(index.entries, docID)
+}
+
+// Similar returns the other documents at least threshold similar to
+// docID, most similar first
+func (index *Index) Similar(docID string, threshold float64) []Match {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	matches := []Match{}
+	target, ok := index.entries[docID]
+	if !ok {
+		return matches
+	}
+	for id, other := range index.entries {
+		if id == docID {
+			continue
+		}
+		equal := 0
+		for i, value := range target.signature {
+			if other.signature[i] == value {
+				equal++
+			}
+		}
+		if similarity := float64(equal) / signatureSize; similarity >= threshold {
+			matches = append(matches, Match{DocID: id, Similarity: similarity})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		return matches[i].DocID < matches[j].DocID
+	})
+	return matches
+}
+
+// sign returns the MinHash signature of the text's shingles, or nil when
+// the text is too short
+func (index *Index) sign(text string) []uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < minWords {
+		return nil
+	}
+
+	signature := make([]uint64, signatureSize)
+	for i := range signature {
+		signature[i] = ^uint64(0)
+	}
+	for start := 0; start+shingleSize <= len(words); start++ {
+		hash := fnv.New64a()
+		for _, word := range words[start : start+shingleSize] {
+			hash.Write([]byte(word))
+			hash.Write([]byte{0})
+		}
+		shingle := hash.Sum64()
+		for i, seed := range index.seeds {
+			signature[i] = min(signature[i], mix(shingle^seed))
+		}
+	}
+	return signature
+}
+
+// mix is the splitmix64 finalizer, turning one hash into many independent
+// looking ones
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
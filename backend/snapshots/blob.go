@@ -0,0 +1,74 @@
+package snapshots
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"backend/blobs"
+	"backend/encryption"
+)
+
+// BlobStore keeps each snapshot as a JSON object in a blob store, which
+// suits large documents better than the storage DSN. Snapshots taken
+// before it was configured are still read from Fallback, if set, and
+// Keys, unless nil, seal the objects.
+type BlobStore struct {
+	Blobs    blobs.Store
+	Fallback Store
+	Keys     *encryption.Keyring
+}
+
+func NewBlobStore(store blobs.Store, fallback Store) *BlobStore {
+	return &BlobStore{Blobs: store, Fallback: fallback}
+}
+
+// Create checks for an existing snapshot before writing. Object stores
+// can't do both at once, but IDs are random, so only a replayed request
+// could race with itself.
+func (store *BlobStore) Create(ctx context.Context, snapshot Snapshot) error {
+	if _, err := store.Get(ctx, snapshot.ID); err == nil {
+		return ErrExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if raw, err = store.Keys.Seal(encryption.SnapshotScope(snapshot.ID), raw); err != nil {
+		return err
+	}
+	info := blobs.Info{Name: snapshot.ID + ".json", ContentType: "application/json", Size: int64(len(raw))}
+	return store.Blobs.Put(ctx, snapshot.ID+".json", bytes.NewReader(raw), info)
+}
+
+func (store *BlobStore) Get(ctx context.Context, id string) (Snapshot, error) {
+	body, _, err := store.Blobs.Get(ctx, id+".json")
+	if errors.Is(err, blobs.ErrNotFound) {
+		if store.Fallback != nil {
+			return store.Fallback.Get(ctx, id)
+		}
+		return Snapshot{}, ErrNotFound
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer body.Close()
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if raw, err = store.Keys.Open(encryption.SnapshotScope(id), raw); err != nil {
+		return Snapshot{}, fmt.Errorf("decrypting snapshot %s: %w", id, err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("decoding snapshot %s: %w", id, err)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,77 @@
+// Package snapshots keeps immutable copies of documents that can be cited
+// by a permalink. Unlike the op log they are never compacted, and stores
+// offer no way to change or delete one once created.
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"backend/document"
+	"backend/richtext"
+)
+
+var (
+	ErrNotFound = errors.New("snapshot not found")
+	ErrExists   = errors.New("snapshot already exists")
+)
+
+// Snapshot is a document's full content and metadata at a revision
+type Snapshot struct {
+	ID        string            `json:"id"`
+	DocID     string            `json:"docId"`
+	Revision  int64             `json:"revision"`
+	Content   richtext.Delta    `json:"content"`
+	Metadata  document.Metadata `json:"metadata"`
+	CreatedAt time.Time         `json:"createdAt"`
+	CreatedBy map[string]string `json:"createdBy"`
+}
+
+// Store keeps snapshots forever. Create fails with ErrExists rather than
+// overwrite an existing snapshot.
+type Store interface {
+	Create(ctx context.Context, snapshot Snapshot) error
+	Get(ctx context.Context, id string) (Snapshot, error)
+}
+
+// Resealer is a Store that can seal the snapshots it keeps again with the
+// active encryption key after a rotation. Only how they are stored
+// changes, never what they hold.
+type Resealer interface {
+	Reseal(ctx context.Context) (resealed int, err error)
+}
+
+// MemoryStore is the Store used when no storage DSN is configured.
+// Snapshots last until the server restarts.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	snapshots map[string]Snapshot
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{snapshots: make(map[string]Snapshot)}
+}
+
+func (store *MemoryStore) Create(_ context.Context, snapshot Snapshot) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.snapshots[snapshot.ID]; ok {
+		return ErrExists
+	}
+	store.snapshots[snapshot.ID] = snapshot
+	return nil
+}
+
+func (store *MemoryStore) Get(_ context.Context, id string) (Snapshot, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	snapshot, ok := store.snapshots[id]
+	if !ok {
+		return Snapshot{}, ErrNotFound
+	}
+	return snapshot, nil
+}
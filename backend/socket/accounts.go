@@ -0,0 +1,150 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"backend/users"
+)
+
+var (
+	ErrInvalidUserName = errors.New("user names must be 1 to 32 printable characters")
+	ErrInvalidColor    = errors.New("colors must be hsl(h, s%, l%) or #rrggbb")
+	ErrNotSignedIn     = errors.New("this session is not signed in to an account")
+)
+
+var userColor = regexp.MustCompile(`^(hsl\(\d{1,3}, \d{1,3}%, \d{1,3}%\)|#[0-9a-fA-F]{6})$`)
+
+// ProfileUpdate changes the fields of a profile that are set
+type ProfileUpdate struct {
+	DisplayName *string `json:"displayName"`
+	Color       *string `json:"color"`
+	EmailOptOut *bool   `json:"emailOptOut"`
+}
+
+// SignUp registers an account of tenant and starts a session signed in to
+// it, whose user ID is the account's. Emails are unique across tenants.
+func (manager *WebSocketManager) SignUp(ctx context.Context, tenant string, email string, password string, displayName string) (users.User, Session, error) {
+	name, ok := normalizeUserName(displayName)
+	if !ok {
+		return users.User{}, Session{}, ErrInvalidUserName
+	}
+	user, err := users.New(email, password, name, "")
+	if err != nil {
+		return users.User{}, Session{}, err
+	}
+	user.Tenant = tenant
+	_, user.Color = manager.guestProfile(user.ID, nil)
+	if err := manager.Users.CreateUser(ctx, user); err != nil {
+		return users.User{}, Session{}, err
+	}
+	manager.Logger.Info("Account registered", "user_id", user.ID)
+	return user, manager.Sessions.Start(user), nil
+}
+
+// SignIn starts a session for the account of tenant with email if
+// password is its. Accounts of other tenants don't sign in.
+func (manager *WebSocketManager) SignIn(ctx context.Context, tenant string, email string, password string) (users.User, Session, error) {
+	user, err := users.Authenticate(ctx, manager.Users, email, password)
+	if err != nil {
+		return users.User{}, Session{}, err
+	}
+	if user.Tenant != tenant {
+		return users.User{}, Session{}, users.ErrInvalidCredentials
+	}
+	manager.Logger.Info("Signed in", "user_id", user.ID)
+	return user, manager.Sessions.Start(user), nil
+}
+
+// Account returns the account session is signed in to
+func (manager *WebSocketManager) Account(ctx context.Context, session Session) (users.User, error) {
+	user, err := manager.Users.GetUser(ctx, session.UserID)
+	if errors.Is(err, users.ErrNotFound) {
+		return users.User{}, ErrNotSignedIn
+	}
+	return user, err
+}
+
+// UpdateProfile changes the profile of the account session is signed in
+// to. Every session and connection of the account shows the new profile,
+// and the rooms they are in are told.
+func (manager *WebSocketManager) UpdateProfile(ctx context.Context, session Session, update ProfileUpdate) (users.User, error) {
+	user, err := manager.Account(ctx, session)
+	if err != nil {
+		return users.User{}, err
+	}
+	if update.DisplayName != nil {
+		name, ok := normalizeUserName(*update.DisplayName)
+		if !ok {
+			return users.User{}, ErrInvalidUserName
+		}
+		user.DisplayName = name
+	}
+	if update.Color != nil {
+		if !userColor.MatchString(*update.Color) {
+			return users.User{}, ErrInvalidColor
+		}
+		user.Color = *update.Color
+	}
+	if update.EmailOptOut != nil {
+		user.EmailOptOut = *update.EmailOptOut
+	}
+	user.UpdatedAt = time.Now().UTC()
+	if err := manager.Users.SaveUser(ctx, user); err != nil {
+		return users.User{}, err
+	}
+
+	manager.showProfile(user)
+	return user, nil
+}
+
+// showProfile puts the profile of user on every session and connection of
+// theirs, telling the rooms they are in
+func (manager *WebSocketManager) showProfile(user users.User) {
+	manager.Sessions.SetProfile(user)
+	for _, client := range manager.userClients(user.ID) {
+		manager.setUserData(client, map[string]string{
+			"userName":  user.DisplayName,
+			"userColor": user.Color,
+			"avatarUrl": user.AvatarURL,
+		}, "user-renamed")
+	}
+}
+
+// saveDisplayName keeps a rename made over the socket in the profile of
+// the client's account, if it has one
+func (manager *WebSocketManager) saveDisplayName(client *Client, name string) {
+	ctx, cancel := context.WithTimeout(client.ctx, 5*time.Second)
+	defer cancel()
+
+	userID := client.ID
+	user, err := manager.Users.GetUser(ctx, userID)
+	if errors.Is(err, users.ErrNotFound) {
+		return
+	}
+	if err == nil {
+		user.DisplayName = name
+		user.UpdatedAt = time.Now().UTC()
+		err = manager.Users.SaveUser(ctx, user)
+	}
+	if err != nil {
+		manager.Logger.Warn("Could not save display name", "user_id", userID, "error", err)
+	}
+}
+
+// userClients returns the connections of userID on this node, read-only
+// impersonated views excluded
+func (manager *WebSocketManager) userClients(userID string) []*Client {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	var clients []*Client
+	for client := range manager.Clients {
+		if client.ID == userID && client.ImpersonatedBy == "" {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
@@ -0,0 +1,114 @@
+package socket
+
+import (
+	"context"
+
+	"backend/activity"
+	"backend/audit"
+	"backend/events"
+)
+
+// Audit entries read from the trail at a time while building a page of
+// the activity feed, and the most read for one page
+const (
+	activityBatch   = 200
+	maxActivityScan = 5000
+)
+
+// ActivityPage is a page of a document's activity feed, newest first.
+// Next, when set, is the before cursor of the page after it. Latest is
+// the newest audit entry read, the since cursor for asking what happened
+// afterwards.
+type ActivityPage struct {
+	DocID  string          `json:"docId"`
+	Items  []activity.Item `json:"items"`
+	Next   int64           `json:"next,omitempty"`
+	Latest int64           `json:"latest"`
+}
+
+// Activity returns up to limit items of docID's activity feed, folded from
+// the change events of the audit entries numbered below before, unless it
+// is zero, and above since. Entries are read until the last item returned
+// is complete.
+func (manager *WebSocketManager) Activity(ctx context.Context, docID string, before int64, since int64, limit int) (ActivityPage, error) {
+	page := ActivityPage{DocID: docID, Latest: since}
+	var folder activity.Folder
+	cursor, scanned, exhausted := before, 0, false
+	for folder.Len() <= limit && scanned < maxActivityScan {
+		entries, err := manager.Audit.List(ctx, docID, cursor, activityBatch)
+		if err != nil {
+			return ActivityPage{}, err
+		}
+		scanned += len(entries)
+		exhausted = len(entries) < activityBatch
+		for i, entry := range entries {
+			if entry.Seq <= since {
+				entries, exhausted = entries[:i], true
+				break
+			}
+		}
+		if len(entries) > 0 {
+			page.Latest = max(page.Latest, entries[0].Seq)
+			cursor = entries[len(entries)-1].Seq
+		}
+		folder.Add(manager.activityEntries(entries))
+		if exhausted {
+			break
+		}
+	}
+
+	page.Items = folder.Items(manager.userName)
+	switch {
+	case len(page.Items) > limit:
+		page.Items = page.Items[:limit]
+		page.Next = page.Items[limit-1].FirstSeq
+	case !exhausted && len(page.Items) > 0:
+		// The scan stopped short; the last item goes on in the next page
+		page.Next = page.Items[len(page.Items)-1].FirstSeq
+	case !exhausted:
+		page.Next = cursor
+	}
+	return page, nil
+}
+
+// activityEntries turns audit entries into the change events published
+// when they were recorded, leaving out those the feed doesn't show
+func (manager *WebSocketManager) activityEntries(entries []audit.Entry) []activity.Entry {
+	out := make([]activity.Entry, 0, len(entries))
+	for _, entry := range entries {
+		var eventType string
+		var payload any
+		switch entry.Action {
+		case audit.ActionEdited:
+			eventType, payload = events.TypeDocumentUpdated, events.DocumentUpdated{Revision: entry.Revision}
+		case audit.ActionCommented:
+			eventType, payload = events.TypeCommentAdded, events.CommentAdded{CommentID: entry.Details["commentId"]}
+		case audit.ActionJoined:
+			eventType, payload = events.TypeUserJoined, events.UserJoined{UserID: entry.UserID, ViewOnly: entry.Details["viewOnly"] == "true"}
+		case audit.ActionRenamed:
+			if title, ok := entry.Details["title"]; ok {
+				eventType, payload = events.TypeMetadataUpdated, events.MetadataUpdated{Title: title}
+			} else {
+				eventType, payload = events.TypeUserRenamed, events.UserRenamed{UserID: entry.UserID, UserName: entry.Details["userName"]}
+			}
+		default:
+			continue
+		}
+		event, err := events.New(eventType, entry.DocID, entry.UserID, payload)
+		if err != nil {
+			manager.Logger.Error("Error building activity event", "type", eventType, "doc_id", entry.DocID, "error", err)
+			continue
+		}
+		event.OccurredAt = entry.At
+		out = append(out, activity.Entry{Seq: entry.Seq, Event: event})
+	}
+	return out
+}
+
+// userName is the name userID goes by in their latest session, if any
+func (manager *WebSocketManager) userName(userID string) string {
+	if sessions := manager.Sessions.ForUser(userID); len(sessions) > 0 {
+		return sessions[0].UserName
+	}
+	return ""
+}
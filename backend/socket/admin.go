@@ -0,0 +1,158 @@
+package socket
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"backend/chat"
+)
+
+// RoomSummary describes a room for the admin API. Bots are counted apart
+// from the other clients, though both are listed.
+type RoomSummary struct {
+	DocID       string          `json:"docId"`
+	Tenant      string          `json:"tenant,omitempty"`
+	ClientCount int             `json:"clientCount"`
+	BotCount    int             `json:"botCount"`
+	Clients     []ClientSummary `json:"clients"`
+}
+
+type ClientSummary struct {
+	ConnID         string            `json:"connId"`
+	UserID         string            `json:"userId"`
+	UserName       string            `json:"userName"`
+	DocID          string            `json:"docId"`
+	Tenant         string            `json:"tenant,omitempty"`
+	Transport      string            `json:"transport"`
+	RemoteIP       string            `json:"remoteIp,omitempty"`
+	UserData       map[string]string `json:"userData"`
+	ImpersonatedBy string            `json:"impersonatedBy,omitempty"`
+	ConnectedAt    time.Time         `json:"connectedAt"`
+	MessagesSent   int64             `json:"messagesSent"`
+
+	// RTT and ClockSkew are the client's smoothed round trip and clock
+	// skew in milliseconds, zero until it answered a latency ping
+	RTT       int64 `json:"rtt"`
+	ClockSkew int64 `json:"clockSkew"`
+}
+
+// NoticeData is the payload of a server-notice message
+type NoticeData struct {
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+func summarize(client *Client) ClientSummary {
+	userData := client.Data["userData"]
+	rtt, skew := client.measured()
+	return ClientSummary{
+		ConnID:         client.ConnID,
+		UserID:         client.ID,
+		UserName:       userData["userName"],
+		DocID:          client.DocID,
+		Tenant:         client.Tenant,
+		Transport:      client.Transport(),
+		RemoteIP:       client.RemoteIP,
+		UserData:       userData,
+		ImpersonatedBy: client.ImpersonatedBy,
+		ConnectedAt:    client.ConnectedAt,
+		MessagesSent:   client.messagesSent.Load(),
+		RTT:            rtt.Milliseconds(),
+		ClockSkew:      skew.Milliseconds(),
+	}
+}
+
+func sortByConnectTime(clients []ClientSummary) {
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ConnectedAt.Before(clients[j].ConnectedAt) })
+}
+
+// RoomSummaries lists the rooms on this node and who is connected to each
+func (manager *WebSocketManager) RoomSummaries() []RoomSummary {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	rooms := make([]RoomSummary, 0, len(manager.Rooms))
+	for docID, clients := range manager.Rooms {
+		room := RoomSummary{DocID: docID, Clients: make([]ClientSummary, 0, len(clients))}
+		for client := range clients {
+			// Everyone in a room acts for the document's tenant
+			room.Tenant = client.Tenant
+			if client.Bot {
+				room.BotCount++
+			} else {
+				room.ClientCount++
+			}
+			room.Clients = append(room.Clients, summarize(client))
+		}
+		sortByConnectTime(room.Clients)
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].DocID < rooms[j].DocID })
+	return rooms
+}
+
+// ClientSummaries lists every client connected to this node, longest
+// connected first
+func (manager *WebSocketManager) ClientSummaries() []ClientSummary {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	clients := make([]ClientSummary, 0, len(manager.Clients))
+	for client := range manager.Clients {
+		clients = append(clients, summarize(client))
+	}
+	sortByConnectTime(clients)
+	return clients
+}
+
+// Disconnect closes the connection with the given ID, telling the client
+// it was kicked. With a positive ban its user is also turned away from
+// this node for that long. It reports whether the connection was found on
+// this node.
+func (manager *WebSocketManager) Disconnect(connID string, ban time.Duration) bool {
+	manager.Mutex.RLock()
+	var target *Client
+	for client := range manager.Clients {
+		if client.ConnID == connID {
+			target = client
+			break
+		}
+	}
+	manager.Mutex.RUnlock()
+
+	if target == nil {
+		return false
+	}
+	if ban <= 0 {
+		manager.disconnect(target, CloseKicked, "disconnected by a moderator")
+		return true
+	}
+	until := time.Now().Add(ban)
+	manager.bans.add(target.ID, until)
+	manager.disconnect(target, CloseBanned, "banned until "+until.UTC().Format(time.RFC3339))
+	return true
+}
+
+// SendNotice shows a server notice to everyone in a room and returns how
+// many clients on this node it was sent to
+func (manager *WebSocketManager) SendNotice(docID string, text string) (int, error) {
+	text, err := chat.Sanitize(text, manager.Config.Limits.MaxChatLength)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := json.Marshal(Message{
+		Type: "server-notice",
+		Data: NoticeData{Text: text, SentAt: time.Now().UTC()},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	manager.Mutex.RLock()
+	recipients := len(manager.Rooms[docID])
+	manager.Mutex.RUnlock()
+
+	manager.BroadcastToRoom(docID, payload)
+	return recipients, nil
+}
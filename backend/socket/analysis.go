@@ -0,0 +1,62 @@
+package socket
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"backend/analysis"
+)
+
+type analysisMessage struct {
+	Data struct {
+		Enabled bool `json:"enabled"`
+	} `json:"data"`
+}
+
+// handleAnalysis lets the owner turn analysis of the text by the language
+// service on or off, then tells everyone in the room what they can do now.
+// Turning it off clears the annotations shown in the room.
+func (manager *WebSocketManager) handleAnalysis(client *Client, message []byte) {
+	var request analysisMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "analysis requires a data.enabled boolean")
+		return
+	}
+
+	if err := client.Doc.SetAnalysis(client.ID, request.Data.Enabled); err != nil {
+		manager.sendError(client, ErrCodeForbidden, err.Error())
+		return
+	}
+	client.Logger.Info("Analysis toggled", "enabled", request.Data.Enabled)
+	manager.auditPermissions(client.DocID, client.ID, "analysis", strconv.FormatBool(request.Data.Enabled))
+	manager.sendRoomCapabilities(client.DocID)
+	if !request.Data.Enabled {
+		manager.Analysis.Forget(client.DocID)
+		manager.broadcastAnnotations(analysis.Report{
+			DocID:       client.DocID,
+			Revision:    client.Doc.Revision(),
+			AnalyzedAt:  time.Now(),
+			Annotations: []analysis.Annotation{},
+		})
+	}
+}
+
+// broadcastAnnotations sends a document's room the annotations of its
+// latest analysis, which replace any sent before
+func (manager *WebSocketManager) broadcastAnnotations(report analysis.Report) {
+	payload, err := json.Marshal(Message{Type: "annotation", Data: report})
+	if err != nil {
+		manager.Logger.Error("Error marshalling annotation message", "doc_id", report.DocID, "error", err)
+		return
+	}
+	manager.BroadcastToRoom(report.DocID, payload)
+}
+
+// sendAnnotations sends a joining client the annotations of the document's
+// latest analysis, if there is one
+func (manager *WebSocketManager) sendAnnotations(client *Client) {
+	if report, ok := manager.Analysis.Report(client.DocID); ok {
+		manager.sendMessage(client, Message{Type: "annotation", Data: report})
+	}
+}
@@ -0,0 +1,106 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"time"
+
+	"backend/blobs"
+	"backend/document"
+	"backend/ids"
+)
+
+var ErrAttachmentType = errors.New("attachment type not allowed")
+
+// Attachment is a file uploaded to a document. URL serves it; its random
+// ID is what makes the URL hard to guess.
+type Attachment struct {
+	ID          string            `json:"id"`
+	DocID       string            `json:"docId"`
+	Name        string            `json:"name"`
+	ContentType string            `json:"contentType"`
+	Size        int64             `json:"size"`
+	URL         string            `json:"url"`
+	Uploader    map[string]string `json:"uploader"`
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
+// AttachmentAddedData is broadcast to the room when a file is uploaded
+type AttachmentAddedData struct {
+	Attachment Attachment `json:"attachment"`
+}
+
+// AddAttachment keeps body as a new attachment of docID, uploaded by
+// uploader, who must be allowed to edit it. The content type is sniffed
+// from the body rather than trusted from the upload, and must be one of
+// the configured types.
+func (manager *WebSocketManager) AddAttachment(ctx context.Context, docID string, uploader Session, name string, body io.Reader) (Attachment, error) {
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if !doc.Capabilities(uploader.UserID).Edit {
+		return Attachment{}, document.ErrEditRestricted
+	}
+
+	buffered := bufio.NewReaderSize(body, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return Attachment{}, err
+	}
+	contentType := http.DetectContentType(head)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || !slices.Contains(manager.Config.Attachments.AllowedTypes, mediaType) {
+		return Attachment{}, ErrAttachmentType
+	}
+
+	attachment := Attachment{
+		ID:          ids.RandomHex(16),
+		DocID:       docID,
+		Name:        name,
+		ContentType: contentType,
+		Uploader:    uploader.UserData(),
+		CreatedAt:   time.Now().UTC(),
+	}
+	counted := &countingReader{reader: buffered}
+	info := blobs.Info{Name: name, ContentType: contentType}
+	if err := manager.Attachments.Put(ctx, attachmentKey(docID, attachment.ID), counted, info); err != nil {
+		return Attachment{}, err
+	}
+	attachment.Size = counted.count
+	attachment.URL = "/api/attachments/" + docID + "/" + attachment.ID
+
+	payload, err := json.Marshal(Message{Type: "attachment-added", Data: AttachmentAddedData{Attachment: attachment}})
+	if err != nil {
+		manager.Logger.Error("Error marshalling attachment-added message", "doc_id", docID, "error", err)
+	} else {
+		manager.BroadcastToRoom(docID, payload)
+	}
+	return attachment, nil
+}
+
+// OpenAttachment returns the body of an attachment, which the caller
+// closes, or blobs.ErrNotFound
+func (manager *WebSocketManager) OpenAttachment(ctx context.Context, docID string, id string) (io.ReadCloser, blobs.Info, error) {
+	return manager.Attachments.Get(ctx, attachmentKey(docID, id))
+}
+
+func attachmentKey(docID string, id string) string {
+	return docID + "/" + id
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.count += int64(n)
+	return n, err
+}
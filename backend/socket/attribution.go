@@ -0,0 +1,70 @@
+package socket
+
+import (
+	"encoding/json"
+
+	"backend/document"
+)
+
+// attributionMessage is the inbound shape of attribution, turning the
+// client's attribution-update messages on or off
+type attributionMessage struct {
+	Data struct {
+		Enabled bool `json:"enabled"`
+	} `json:"data"`
+}
+
+// AttributionData is the payload of attribution: who wrote each character
+// of the document's plain text at Revision
+type AttributionData struct {
+	Revision int64                  `json:"revision"`
+	Ranges   []document.AuthorRange `json:"ranges"`
+}
+
+// Attribution returns doc's attribution
+func Attribution(doc *document.Document) AttributionData {
+	ranges, revision := doc.Attribution()
+	return AttributionData{Revision: revision, Ranges: ranges}
+}
+
+// handleAttribution turns on or off sending the client how each op changes
+// the document's authorship, for coloring text by who wrote it. Turning it
+// on sends the whole attribution to start from.
+func (manager *WebSocketManager) handleAttribution(client *Client, message []byte) {
+	var request attributionMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "attribution requires a data.enabled boolean")
+		return
+	}
+	client.attribution.Store(request.Data.Enabled)
+	if request.Data.Enabled {
+		manager.sendMessage(client, Message{Type: "attribution", Data: Attribution(client.Doc)})
+	}
+}
+
+// broadcastAttribution sends the clients in a room that asked for it how
+// op changed the document's authorship. Updates are sent after the
+// document is unlocked, so one may overtake another: a client that finds
+// a revision missing asks for the whole attribution again.
+func (manager *WebSocketManager) broadcastAttribution(docID string, op document.Op) {
+	update, ok := op.Attribution()
+	if !ok {
+		return
+	}
+	var payload []byte
+	for _, member := range manager.roomMembers(docID) {
+		if !member.attribution.Load() {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(Message{Type: "attribution-update", Data: update}); err != nil {
+				manager.Logger.Error("Error marshalling attribution-update message", "doc_id", docID, "error", err)
+				return
+			}
+		}
+		if err := manager.sendToClient(member, payload); err != nil {
+			member.Logger.Warn("Could not send message", "type", "attribution-update", "error", err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package socket
+
+import (
+	"backend/audit"
+	"backend/document"
+)
+
+// auditJoin records a user's first connection to a document
+func (manager *WebSocketManager) auditJoin(client *Client) {
+	entry := audit.Entry{Action: audit.ActionJoined, DocID: client.DocID, UserID: client.ID}
+	if client.ViewOnly {
+		entry.Details = map[string]string{"viewOnly": "true"}
+	}
+	manager.Audit.Record(entry)
+}
+
+// auditEdit records the range an applied op replaced
+func (manager *WebSocketManager) auditEdit(docID string, op document.Op) {
+	manager.Audit.Record(audit.Entry{
+		Action:   audit.ActionEdited,
+		DocID:    docID,
+		UserID:   op.Author,
+		Revision: op.Revision,
+		Range:    &audit.Range{Pos: op.Edit.Pos, Deleted: op.Edit.Deleted, Inserted: op.Edit.Inserted},
+	})
+}
+
+// auditRename records userID renaming the document, with field title, or
+// themselves in its room, with field userName
+func (manager *WebSocketManager) auditRename(docID string, userID string, field string, name string) {
+	manager.Audit.Record(audit.Entry{
+		Action:  audit.ActionRenamed,
+		DocID:   docID,
+		UserID:  userID,
+		Details: map[string]string{field: name},
+	})
+}
+
+// auditPermissions records the owner userID changing one of the
+// permission settings of docID to value
+func (manager *WebSocketManager) auditPermissions(docID string, userID string, setting string, value string) {
+	manager.Audit.Record(audit.Entry{
+		Action:  audit.ActionPermissionsChanged,
+		DocID:   docID,
+		UserID:  userID,
+		Details: map[string]string{"setting": setting, "value": value},
+	})
+}
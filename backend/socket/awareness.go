@@ -0,0 +1,101 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"unicode/utf8"
+
+	"backend/sanitize"
+)
+
+// Limits on the awareness fields a connection may add to its user data
+const (
+	maxAwarenessFields      = 16
+	maxAwarenessKeyLength   = 32
+	maxAwarenessValueLength = 200
+)
+
+// identityFields are the user data fields the server fills in from the
+// session; awareness updates cannot change them
+var identityFields = map[string]bool{
+	"userId":    true,
+	"userName":  true,
+	"userColor": true,
+	"avatarUrl": true,
+	"tabs":      true,
+	"latency":   true,
+}
+
+// awarenessMessage is the inbound shape of awareness-update. Fields are
+// free-form strings such as status, device, idle or section; an empty or
+// null value removes a field.
+type awarenessMessage struct {
+	Data struct {
+		Awareness map[string]*string `json:"awareness"`
+	} `json:"data"`
+}
+
+// handleAwareness merges what a connection says about its user, such as a
+// status message or whether it is idle, into its presence data and
+// announces the result to the room as an awareness-update. Each tab keeps
+// fields of its own.
+func (manager *WebSocketManager) handleAwareness(client *Client, message []byte) {
+	var update awarenessMessage
+	if err := json.Unmarshal(message, &update); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "awareness-update requires a data.awareness object of strings")
+		return
+	}
+	changes, err := awarenessChanges(manager.clientData(client)["userData"], update.Data.Awareness)
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+	manager.setUserData(client, changes, "awareness-update")
+}
+
+// awarenessChanges checks an update against the user data it applies to
+// and returns the changes to make
+func awarenessChanges(userData map[string]string, update map[string]*string) (map[string]string, error) {
+	fields := make(map[string]string, len(userData))
+	maps.Copy(fields, userData)
+	changes := make(map[string]string, len(update))
+	for key, value := range update {
+		if identityFields[key] {
+			return nil, fmt.Errorf("awareness cannot change %s", key)
+		}
+		if key == "" || len(key) > maxAwarenessKeyLength || !isAwarenessKey(key) {
+			return nil, fmt.Errorf("awareness keys must be 1 to %d letters, digits, - or _", maxAwarenessKeyLength)
+		}
+		if value == nil || *value == "" {
+			changes[key] = ""
+			delete(fields, key)
+			continue
+		}
+		if utf8.RuneCountInString(*value) > maxAwarenessValueLength || sanitize.HasControl(*value, false) {
+			return nil, fmt.Errorf("awareness values are single lines of at most %d characters", maxAwarenessValueLength)
+		}
+		changes[key] = *value
+		fields[key] = *value
+	}
+
+	count := 0
+	for key := range fields {
+		if !identityFields[key] {
+			count++
+		}
+	}
+	if count > maxAwarenessFields {
+		return nil, fmt.Errorf("awareness is limited to %d fields", maxAwarenessFields)
+	}
+	return changes, nil
+}
+
+func isAwarenessKey(key string) bool {
+	for _, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package socket
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"backend/metrics"
+)
+
+// backlog is what the backpressure policy keeps for a client while its
+// send buffer is full
+type backlog struct {
+	mutex sync.Mutex
+
+	// held are the latest messages of coalesced types, by type and sender,
+	// and order the order they were first held in
+	held  map[string][]byte
+	order []string
+
+	// resync is set once a message the client needed was dropped
+	resync bool
+
+	// stalledSince is when the buffer filled; zero while it has room
+	stalledSince time.Time
+}
+
+// ResyncData is the payload of resync-required, which tells a client that
+// messages it needed were dropped while it fell behind. The document
+// state and roster follow it.
+type ResyncData struct {
+	Reason string `json:"reason"`
+}
+
+// overflow applies the backpressure policy to a message the full send
+// buffer of client has no room for, and reports whether the buffer has
+// been full for long enough that the client should be disconnected
+func (manager *WebSocketManager) overflow(client *Client, message *BroadcastMessage, msgType string) (stalled bool) {
+	policy := manager.Config.Backpressure
+	backlog := &client.backlog
+	backlog.mutex.Lock()
+	defer backlog.mutex.Unlock()
+
+	now := time.Now()
+	if backlog.stalledSince.IsZero() {
+		backlog.stalledSince = now
+	}
+	if now.Sub(backlog.stalledSince) >= policy.StallTimeout {
+		metrics.BackpressureActions.WithLabelValues("disconnected").Inc()
+		return true
+	}
+
+	switch {
+	case slices.Contains(policy.Coalesce, msgType):
+		key := msgType
+		if message.Sender != nil {
+			key += "/" + message.Sender.ConnID
+		}
+		if backlog.held == nil {
+			backlog.held = make(map[string][]byte)
+		}
+		if _, ok := backlog.held[key]; !ok {
+			backlog.order = append(backlog.order, key)
+		}
+		backlog.held[key] = message.Data
+		metrics.BackpressureActions.WithLabelValues("coalesced").Inc()
+	case slices.Contains(policy.LowPriority, msgType):
+		metrics.BackpressureActions.WithLabelValues("dropped").Inc()
+	default:
+		if !backlog.resync {
+			client.Logger.Warn("Send buffer full, client needs a resync", "type", msgType)
+		}
+		backlog.resync = true
+		metrics.BackpressureActions.WithLabelValues("dropped").Inc()
+	}
+	return false
+}
+
+// relieve is called by write pumps after taking messages from the send
+// buffer. Once a stalled client's buffer is down to half, it gets the
+// messages held back for it and, if it missed any it needed, a
+// resync-required followed by the document state.
+func (manager *WebSocketManager) relieve(client *Client) {
+	backlog := &client.backlog
+	backlog.mutex.Lock()
+	if backlog.stalledSince.IsZero() || client.Send.Pressed() {
+		backlog.mutex.Unlock()
+		return
+	}
+	held, order, resync := backlog.held, backlog.order, backlog.resync
+	backlog.held, backlog.order, backlog.resync = nil, nil, false
+	backlog.stalledSince = time.Time{}
+	backlog.mutex.Unlock()
+
+	for _, key := range order {
+		if err := manager.sendToClient(client, held[key]); err != nil {
+			// Fell behind again before catching up; the document
+			// state brings the client back in line instead
+			resync = true
+			break
+		}
+	}
+	if !resync {
+		return
+	}
+	metrics.BackpressureActions.WithLabelValues("resync").Inc()
+	manager.sendMessage(client, Message{
+		Type: "resync-required",
+		Data: ResyncData{Reason: "messages were dropped while the connection fell behind"},
+	})
+	manager.sendDocSync(client)
+	manager.sendMessage(client, manager.roster(client.DocID))
+}
@@ -0,0 +1,82 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"backend/backup"
+	"backend/document"
+)
+
+// ErrBackupsDisabled is a backup asked for with no store to keep it in
+var ErrBackupsDisabled = errors.New("backups are not enabled")
+
+// RestoreResult lists the documents a restore put back and those it
+// couldn't, with why
+type RestoreResult struct {
+	BackupID string            `json:"backupId"`
+	Restored []string          `json:"restored"`
+	Skipped  map[string]string `json:"skipped,omitempty"`
+}
+
+// RestoreBackup puts the documents of backup id back as they were when it
+// was taken, only those of docIDs when any are given. Documents open in a
+// room are skipped, so drain or migrate rooms before restoring them.
+func (manager *WebSocketManager) RestoreBackup(ctx context.Context, id string, docIDs []string) (RestoreResult, error) {
+	if manager.Backups == nil {
+		return RestoreResult{}, ErrBackupsDisabled
+	}
+	_, records, err := manager.Backups.Read(ctx, id)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+
+	result := RestoreResult{BackupID: id, Restored: []string{}}
+	skip := func(docID string, reason string) {
+		if result.Skipped == nil {
+			result.Skipped = make(map[string]string)
+		}
+		result.Skipped[docID] = reason
+	}
+	found := make(map[string]bool)
+	for _, record := range records {
+		if len(docIDs) > 0 && !slices.Contains(docIDs, record.ID) {
+			continue
+		}
+		found[record.ID] = true
+		_, err := manager.Documents.Install(record)
+		switch {
+		case errors.Is(err, document.ErrInUse):
+			skip(record.ID, "document is open in a room")
+		case err != nil:
+			manager.Logger.Error("Could not restore document", "doc_id", record.ID, "backup_id", id, "error", err)
+			skip(record.ID, err.Error())
+		default:
+			result.Restored = append(result.Restored, record.ID)
+		}
+	}
+	for _, docID := range docIDs {
+		if !found[docID] {
+			skip(docID, "not in the backup")
+		}
+	}
+	manager.Logger.Info("Backup restored", "backup_id", id, "restored", len(result.Restored), "skipped", len(result.Skipped))
+	return result, nil
+}
+
+// ListBackups returns the backups kept, newest first
+func (manager *WebSocketManager) ListBackups(ctx context.Context) ([]backup.Info, error) {
+	if manager.Backups == nil {
+		return nil, ErrBackupsDisabled
+	}
+	return manager.Backups.List(ctx)
+}
+
+// CreateBackup backs up every document now
+func (manager *WebSocketManager) CreateBackup(ctx context.Context) (backup.Info, error) {
+	if manager.Backups == nil {
+		return backup.Info{}, ErrBackupsDisabled
+	}
+	return manager.Backups.Create(ctx)
+}
@@ -0,0 +1,32 @@
+package socket
+
+import (
+	"sync"
+	"time"
+)
+
+// banList holds the users turned away from this node until a time. Bans
+// are kept in memory and lifted by a restart.
+type banList struct {
+	mutex  sync.Mutex
+	expiry map[string]time.Time
+}
+
+func (bans *banList) add(userID string, until time.Time) {
+	bans.mutex.Lock()
+	defer bans.mutex.Unlock()
+	bans.expiry[userID] = until
+}
+
+// until reports whether userID is banned and until when
+func (bans *banList) until(userID string) (time.Time, bool) {
+	bans.mutex.Lock()
+	defer bans.mutex.Unlock()
+
+	until, ok := bans.expiry[userID]
+	if ok && !time.Now().Before(until) {
+		delete(bans.expiry, userID)
+		return time.Time{}, false
+	}
+	return until, ok
+}
@@ -0,0 +1,459 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/document"
+	"backend/ids"
+	"backend/metrics"
+	"backend/users"
+
+	"github.com/gorilla/websocket"
+)
+
+// Bots are machine clients, such as a meeting-notes bot, authenticated by
+// an API token rather than a session. A bot keeps one WebSocket and
+// subscribes over it to every room it works in. Each subscription is a
+// client of its own on the bot transport: it joins its room like any
+// other, edits and comments included, but is marked as a bot and kept out
+// of the room's human counts. Messages for a room, both ways, carry its
+// doc ID beside their type:
+//
+//	{"type":"subscribe","data":{"docId":"notes"}}
+//	{"type":"content","doc":"notes","data":{...}}
+
+// Error codes of bot messages that can't reach a room
+const (
+	ErrCodeNotSubscribed = "not-subscribed"
+	ErrCodeTooManyRooms  = "too-many-rooms"
+	ErrCodeRoomBusy      = "room-busy"
+)
+
+// Room messages from bots may exceed MaxMessageSize by this much for their
+// doc field
+const botEnvelopeSize = 512
+
+// Color bots are shown in
+const botColor = "hsl(0, 0%, 45%)"
+
+// Bot is the holder of an API token connecting as a machine client. A bot
+// with a Tenant may only connect on that tenant's host.
+type Bot struct {
+	Name   string
+	Tenant string
+}
+
+// BotSubscription is the payload of subscribe and unsubscribe, and of the
+// subscribed and unsubscribed answers. Reason names the close reason when
+// the server ended the subscription itself.
+type BotSubscription struct {
+	DocID  string `json:"docId"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// botConn is a bot's WebSocket and the rooms it subscribed to over it
+type botConn struct {
+	conn     *websocket.Conn
+	send     *SendQueue
+	session  Session
+	remoteIP string
+	logger   *slog.Logger
+
+	mutex sync.Mutex
+	rooms map[string]*Client
+
+	// closeReason is why the server is ending the connection
+	closeReason atomic.Pointer[CloseReason]
+}
+
+// botConns are the bots connected to this node
+type botConns struct {
+	mutex sync.Mutex
+	conns map[*botConn]bool
+}
+
+// HandleBotConnections upgrades the connection of bot, whose API token was
+// checked before. The bot starts out in no room.
+func (manager *WebSocketManager) HandleBotConnections(w http.ResponseWriter, r *http.Request, bot Bot) {
+	conn, _, _, err := manager.upgrade(w, r, nil)
+	if err != nil {
+		metrics.UpgradeFailures.Inc()
+		manager.Logger.Warn("WebSocket upgrade error", "remote_ip", manager.Proxies.ClientIP(r), "bot", bot.Name, "error", err)
+		return
+	}
+	if manager.draining.Load() {
+		manager.closeConn(conn, EncodingJSON, CloseServerDraining, CloseServerDraining.message("server is shutting down, reconnect shortly"))
+		return
+	}
+	tenant, err := manager.HostTenant(r.Host)
+	if err == nil && bot.Tenant != "" && bot.Tenant != tenant {
+		err = errors.New("the token belongs to another tenant")
+	}
+	if err != nil {
+		manager.closeConn(conn, EncodingJSON, CloseWrongTenant, CloseWrongTenant.message(err.Error()))
+		return
+	}
+	conn.SetReadLimit(manager.Config.Limits.MaxMessageSize + botEnvelopeSize)
+
+	// A bot keeps its identity across connections, as its name does
+	userID := ids.DerivedUUID("bot:" + tenant + ":" + bot.Name)
+	connected := &botConn{
+		conn:     conn,
+		send:     NewSendQueue(manager.Config.Limits.SendBufferSize),
+		session:  manager.Sessions.Start(users.User{ID: userID, DisplayName: bot.Name, Color: botColor, Tenant: tenant}),
+		remoteIP: manager.Proxies.ClientIP(r),
+		rooms:    make(map[string]*Client),
+	}
+	connected.logger = manager.Logger.With("bot", bot.Name, "user_id", userID, "remote_ip", connected.remoteIP)
+
+	manager.bots.mutex.Lock()
+	manager.bots.conns[connected] = true
+	manager.bots.mutex.Unlock()
+	metrics.BotConnections.Inc()
+	connected.logger.Info("Bot connected")
+
+	manager.writers.Add(1)
+	go manager.readBot(connected)
+	go manager.writeBot(connected)
+}
+
+// readBot is a bot's read pump. Once the bot goes away it ends the bot's
+// subscriptions and its write pump.
+func (manager *WebSocketManager) readBot(bot *botConn) {
+	defer func() {
+		for _, client := range bot.unsubscribeAll() {
+			manager.Unregister <- client
+		}
+		bot.send.Close()
+		manager.bots.mutex.Lock()
+		delete(manager.bots.conns, bot)
+		manager.bots.mutex.Unlock()
+		metrics.BotConnections.Dec()
+		bot.logger.Info("Bot disconnected")
+	}()
+	defer context.AfterFunc(manager.ctx, func() {
+		bot.conn.SetReadDeadline(time.Now())
+	})()
+
+	for {
+		frameType, message, err := bot.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				bot.logger.Warn("WebSocket read error", "error", err)
+			}
+			return
+		}
+		if frameType != websocket.TextMessage {
+			manager.sendBotMessage(bot, "", errorMessage(ErrorData{Code: ErrCodeInvalidMessage, Message: "bots send JSON in text frames"}))
+			continue
+		}
+		manager.Sessions.Touch(bot.session.ID)
+		manager.receiveBot(bot, message)
+	}
+}
+
+// writeBot is a bot's write pump
+func (manager *WebSocketManager) writeBot(bot *botConn) {
+	defer func() {
+		bot.conn.Close()
+		manager.writers.Done()
+	}()
+
+	writeTimeout := manager.Config.Limits.WriteTimeout
+	for {
+		message, ok := bot.send.Next()
+		bot.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if !ok {
+			frame := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			if reason := bot.closeReason.Load(); reason != nil {
+				frame = websocket.FormatCloseMessage(reason.Code, reason.Name)
+			}
+			bot.conn.WriteMessage(websocket.CloseMessage, frame)
+			return
+		}
+		if err := bot.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			bot.logger.Warn("Error sending message", "error", err)
+			return
+		}
+	}
+}
+
+// receiveBot hands a message from a bot to the room named by its doc
+// field, or handles it as a subscription change when it has none
+func (manager *WebSocketManager) receiveBot(bot *botConn, message []byte) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(message, &envelope); err != nil || envelope == nil {
+		manager.sendBotMessage(bot, "", errorMessage(ErrorData{Code: ErrCodeInvalidMessage, Message: "messages must be JSON objects"}))
+		return
+	}
+	raw, forRoom := envelope["doc"]
+	if !forRoom {
+		manager.handleSubscription(bot, messageType(message), envelope["data"])
+		return
+	}
+	var docID string
+	if json.Unmarshal(raw, &docID) != nil || docID == "" {
+		manager.sendBotMessage(bot, "", errorMessage(ErrorData{Code: ErrCodeInvalidMessage, Field: "doc", Message: "doc must be a non-empty string"}))
+		return
+	}
+
+	bot.mutex.Lock()
+	client := bot.rooms[docID]
+	bot.mutex.Unlock()
+	if client == nil {
+		manager.sendBotMessage(bot, docID, errorMessage(ErrorData{Code: ErrCodeNotSubscribed, Message: "subscribe to the room before sending it messages"}))
+		return
+	}
+	delete(envelope, "doc")
+	roomMessage, err := json.Marshal(envelope)
+	if err != nil {
+		bot.logger.Error("Error marshalling room message", "doc_id", docID, "error", err)
+		return
+	}
+	select {
+	case client.httpConn.inbox <- roomMessage:
+	default:
+		manager.sendBotMessage(bot, docID, errorMessage(ErrorData{Code: ErrCodeRoomBusy, Message: "too many messages are pending for the room, slow down"}))
+	}
+}
+
+// handleSubscription subscribes a bot to a room or unsubscribes it
+func (manager *WebSocketManager) handleSubscription(bot *botConn, msgType string, data json.RawMessage) {
+	if msgType != "subscribe" && msgType != "unsubscribe" {
+		manager.sendBotMessage(bot, "", errorMessage(ErrorData{Code: ErrCodeUnknownType, Field: "doc",
+			Message: fmt.Sprintf("%q messages go to a room, named by its doc ID in doc", msgType)}))
+		return
+	}
+	var subscription BotSubscription
+	if json.Unmarshal(data, &subscription) != nil || subscription.DocID == "" {
+		manager.sendBotMessage(bot, "", errorMessage(ErrorData{Code: ErrCodeInvalidMessage, Field: "data.docId",
+			Message: "data.docId is required in " + msgType + " messages"}))
+		return
+	}
+	if msgType == "subscribe" {
+		manager.subscribeBot(bot, subscription.DocID)
+	} else {
+		manager.unsubscribeBot(bot, subscription.DocID)
+	}
+}
+
+// subscribeBot joins a bot to docID. It is turned away as a browser would
+// be, and from rooms another node serves, which it has to subscribe to
+// over a connection to that node.
+func (manager *WebSocketManager) subscribeBot(bot *botConn, docID string) {
+	bot.mutex.Lock()
+	_, subscribed := bot.rooms[docID]
+	rooms := len(bot.rooms)
+	bot.mutex.Unlock()
+	if subscribed {
+		manager.sendBotMessage(bot, "", Message{Type: "subscribed", Data: BotSubscription{DocID: docID}})
+		return
+	}
+	if limit := manager.Config.Bots.MaxRooms; rooms >= limit {
+		manager.sendBotMessage(bot, docID, errorMessage(ErrorData{Code: ErrCodeTooManyRooms,
+			Message: fmt.Sprintf("a bot connection is limited to %d rooms, unsubscribe from some first", limit)}))
+		return
+	}
+	client, refused := manager.newBotClient(bot, docID)
+	if refused != nil {
+		manager.sendBotMessage(bot, docID, refused.message())
+		return
+	}
+
+	bot.mutex.Lock()
+	bot.rooms[docID] = client
+	bot.mutex.Unlock()
+	manager.sendBotMessage(bot, "", Message{Type: "subscribed", Data: BotSubscription{DocID: docID}})
+	client.Logger.Debug("Bot subscribed")
+
+	manager.Register <- client
+	go manager.readInbox(client)
+	go manager.forwardToBot(bot, client)
+}
+
+// unsubscribeBot takes a bot out of docID
+func (manager *WebSocketManager) unsubscribeBot(bot *botConn, docID string) {
+	bot.mutex.Lock()
+	client := bot.rooms[docID]
+	delete(bot.rooms, docID)
+	bot.mutex.Unlock()
+	if client == nil {
+		manager.sendBotMessage(bot, docID, errorMessage(ErrorData{Code: ErrCodeNotSubscribed, Message: "the bot isn't subscribed to the room"}))
+		return
+	}
+	manager.sendBotMessage(bot, "", Message{Type: "unsubscribed", Data: BotSubscription{DocID: docID}})
+	manager.Unregister <- client
+}
+
+// newBotClient admits a bot to docID and sets up its client there. Bots
+// never own the documents they open first, nor count as their editors.
+func (manager *WebSocketManager) newBotClient(bot *botConn, docID string) (*Client, *CloseError) {
+	if url, moved := manager.migrations.lookup(docID); moved {
+		return nil, &CloseError{Reason: CloseRoomMoved, Details: "room moved to " + url}
+	}
+	if manager.clustered() {
+		ctx, cancel := context.WithTimeout(manager.ctx, leaseTimeout)
+		owner, local, err := manager.claim(ctx, docID)
+		cancel()
+		switch {
+		case err != nil:
+			bot.logger.Warn("Could not look up document owner", "doc_id", docID, "error", err)
+			return nil, &CloseError{Reason: CloseDocumentUnavailable, Details: "the document's owner could not be looked up", RetryAfter: time.Second}
+		case !local:
+			return nil, &CloseError{Reason: CloseRoomMoved, Details: "room is served by " + owner.URL + ", subscribe to it over a connection there"}
+		}
+	}
+	refused := manager.admit(bot.session.UserID, bot.remoteIP, docID)
+	var doc *document.Document
+	if refused == nil {
+		doc, refused = manager.admitDocument(bot.session.UserID, bot.session.Tenant, docID)
+	}
+	if refused != nil {
+		return nil, refused
+	}
+
+	userData := bot.session.UserData()
+	userData["bot"] = "true"
+	client := &Client{
+		httpConn: &httpConn{
+			transport: TransportBot,
+			inbox:     make(chan []byte, httpInboxSize),
+			closed:    make(chan struct{}),
+		},
+		Send:   NewSendQueue(manager.Config.Limits.SendBufferSize),
+		ID:     bot.session.UserID,
+		ConnID: NewConnID(),
+		DocID:  docID,
+		Doc:    doc,
+		Data:   map[string]map[string]string{"userData": userData},
+
+		SessionID:   bot.session.ID,
+		Tenant:      bot.session.Tenant,
+		RemoteIP:    bot.remoteIP,
+		Bot:         true,
+		Encoding:    EncodingJSON,
+		ConnectedAt: time.Now(),
+
+		limiter: newRateLimiter(manager.Config.Limits),
+		chunks:  make(map[string]*chunkBuffer),
+	}
+	manager.bindContext(client)
+	client.Logger = bot.logger.With("conn_id", client.ConnID, "doc_id", docID)
+	return client, nil
+}
+
+// forwardToBot is the write pump of a bot's subscription. It hands the
+// room's messages to the bot's connection, tagged with the room, and tells
+// the bot when the server ended the subscription.
+func (manager *WebSocketManager) forwardToBot(bot *botConn, client *Client) {
+	for {
+		message, ok := client.Send.Next()
+		if !ok {
+			break
+		}
+		manager.relieve(client)
+		manager.pushToBot(bot, tagRoom(client.DocID, message))
+	}
+
+	bot.mutex.Lock()
+	current := bot.rooms[client.DocID] == client
+	if current {
+		delete(bot.rooms, client.DocID)
+	}
+	bot.mutex.Unlock()
+	if current {
+		data := BotSubscription{DocID: client.DocID}
+		if reason := client.closeReason.Load(); reason != nil {
+			data.Reason = reason.Name
+		}
+		manager.sendBotMessage(bot, "", Message{Type: "unsubscribed", Data: data})
+	}
+}
+
+// unsubscribeAll takes the bot out of every room, returning their clients
+func (bot *botConn) unsubscribeAll() []*Client {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+
+	clients := make([]*Client, 0, len(bot.rooms))
+	for docID, client := range bot.rooms {
+		clients = append(clients, client)
+		delete(bot.rooms, docID)
+	}
+	return clients
+}
+
+// close ends a bot's connection for reason once what is queued is written
+func (bot *botConn) close(reason CloseReason) {
+	bot.closeReason.Store(&reason)
+	bot.send.Close()
+}
+
+// closeBots ends the connection of every bot, for reason
+func (manager *WebSocketManager) closeBots(reason CloseReason) {
+	manager.bots.mutex.Lock()
+	defer manager.bots.mutex.Unlock()
+	for bot := range manager.bots.conns {
+		bot.close(reason)
+	}
+}
+
+// sendBotMessage queues a message for a bot, about the room docID when it
+// is set
+func (manager *WebSocketManager) sendBotMessage(bot *botConn, docID string, message Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		bot.logger.Error("Error marshalling message", "type", message.Type, "error", err)
+		return
+	}
+	if docID != "" {
+		data = tagRoom(docID, data)
+	}
+	manager.pushToBot(bot, data)
+}
+
+// pushToBot queues a message for a bot, disconnecting a bot too slow to
+// take it
+func (manager *WebSocketManager) pushToBot(bot *botConn, message []byte) {
+	if !bot.send.Push(message) && !bot.send.Closed() {
+		bot.logger.Warn("Bot too slow to keep up with its rooms, disconnecting")
+		bot.close(CloseTooSlow)
+	}
+}
+
+// tagRoom adds a room's doc ID to a JSON message for a bot
+func tagRoom(docID string, message []byte) []byte {
+	if len(message) < 2 || message[0] != '{' {
+		return message
+	}
+	doc, _ := json.Marshal(docID)
+	tagged := make([]byte, 0, len(message)+len(doc)+8)
+	tagged = append(tagged, `{"doc":`...)
+	tagged = append(tagged, doc...)
+	if message[1] != '}' {
+		tagged = append(tagged, ',')
+	}
+	return append(tagged, message[1:]...)
+}
+
+// roomHumans counts the clients in docID that aren't bots
+func (manager *WebSocketManager) roomHumans(docID string) int {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	count := 0
+	for client := range manager.Rooms[docID] {
+		if !client.Bot {
+			count++
+		}
+	}
+	return count
+}
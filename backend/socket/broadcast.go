@@ -0,0 +1,135 @@
+package socket
+
+import (
+	"sync"
+	"time"
+
+	"backend/metrics"
+)
+
+// Messages a room's broadcaster holds before senders to the room wait
+const roomQueueSize = 256
+
+// How long a room's broadcaster waits for a message before stopping; the
+// next message to the room starts a new one
+const roomIdleTimeout = time.Minute
+
+// roomBroadcaster fans out the messages of one room on a goroutine of its
+// own, so a busy room never holds up delivery to the others. Senders hold
+// mutex for reading while queueing, so the broadcaster can stop once it is
+// sure nothing is queued or being queued.
+type roomBroadcaster struct {
+	mutex    sync.RWMutex
+	messages chan *BroadcastMessage
+	stopped  bool
+}
+
+// roomBroadcasters holds the running broadcaster of each room
+type roomBroadcasters struct {
+	mutex sync.Mutex
+	rooms map[string]*roomBroadcaster
+}
+
+// queueRoomBroadcast hands a message to the broadcaster of its room,
+// starting one if the room has none, and waits only while that room's
+// queue is full
+func (manager *WebSocketManager) queueRoomBroadcast(message *BroadcastMessage) {
+	for {
+		broadcaster := manager.roomBroadcaster(message.DocID)
+		broadcaster.mutex.RLock()
+		if broadcaster.stopped {
+			// Stopped after being looked up, and already gone from the
+			// map, so the next lookup starts a new one
+			broadcaster.mutex.RUnlock()
+			continue
+		}
+		broadcaster.messages <- message
+		broadcaster.mutex.RUnlock()
+		return
+	}
+}
+
+// roomBroadcaster returns the running broadcaster of docID
+func (manager *WebSocketManager) roomBroadcaster(docID string) *roomBroadcaster {
+	broadcasters := manager.broadcasters
+	broadcasters.mutex.Lock()
+	defer broadcasters.mutex.Unlock()
+
+	broadcaster, ok := broadcasters.rooms[docID]
+	if !ok {
+		broadcaster = &roomBroadcaster{messages: make(chan *BroadcastMessage, roomQueueSize)}
+		broadcasters.rooms[docID] = broadcaster
+		metrics.RoomBroadcasters.Inc()
+		go manager.runRoomBroadcaster(docID, broadcaster)
+	}
+	return broadcaster
+}
+
+// runRoomBroadcaster delivers a room's messages in the order they were
+// queued until the room has been quiet for roomIdleTimeout. Relayed edits
+// are held back for up to EditBatch.Window so a run of them by one
+// connection goes out as one frame; any other message sends the run
+// first.
+func (manager *WebSocketManager) runRoomBroadcaster(docID string, broadcaster *roomBroadcaster) {
+	idle := time.NewTimer(roomIdleTimeout)
+	defer idle.Stop()
+
+	policy := manager.Config.EditBatch
+	var batch *editBatch
+	var flush <-chan time.Time
+	flushTimer := time.NewTimer(policy.Window)
+	flushTimer.Stop()
+	defer flushTimer.Stop()
+	flushBatch := func() {
+		if batch != nil {
+			manager.deliver(batch.message())
+			batch, flush = nil, nil
+			flushTimer.Stop()
+		}
+	}
+
+	for {
+		select {
+		case message := <-broadcaster.messages:
+			idle.Reset(roomIdleTimeout)
+			if batch != nil && batch.add(message) {
+				if batch.pending >= policy.MaxOps {
+					flushBatch()
+				}
+				continue
+			}
+			flushBatch()
+			if message.edit != nil && policy.Window > 0 && policy.MaxOps > 1 {
+				batch = newEditBatch(message)
+				flushTimer.Reset(policy.Window)
+				flush = flushTimer.C
+				continue
+			}
+			manager.deliver(message)
+
+		case <-flush:
+			flushBatch()
+
+		case <-idle.C:
+			flushBatch()
+			// A sender may be waiting on a full queue while holding the
+			// read lock, which only this goroutine can drain
+			if !broadcaster.mutex.TryLock() {
+				idle.Reset(roomIdleTimeout)
+				continue
+			}
+			if len(broadcaster.messages) > 0 {
+				broadcaster.mutex.Unlock()
+				idle.Reset(roomIdleTimeout)
+				continue
+			}
+			broadcaster.stopped = true
+			manager.broadcasters.mutex.Lock()
+			delete(manager.broadcasters.rooms, docID)
+			manager.broadcasters.mutex.Unlock()
+			broadcaster.mutex.Unlock()
+			metrics.RoomBroadcasters.Dec()
+			return
+		}
+	}
+}
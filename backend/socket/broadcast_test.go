@@ -0,0 +1,100 @@
+package socket
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"testing"
+
+	"backend/config"
+)
+
+// Clients in each room of the broadcast benchmarks
+const benchRoomSize = 20
+
+// benchManager returns a manager with nothing but its rooms running
+func benchManager(b *testing.B, cfg *config.Config) *WebSocketManager {
+	b.Helper()
+	manager := NewWebSocketManager(cfg, slog.New(slog.DiscardHandler))
+	b.Cleanup(manager.stop)
+	return manager
+}
+
+// benchClients adds size clients to docID, each with a goroutine taking
+// what is queued for it and marking it done in delivered
+func benchClients(b *testing.B, manager *WebSocketManager, docID string, size int, delivered *sync.WaitGroup) {
+	b.Helper()
+	logger := slog.New(slog.DiscardHandler)
+	for i := range size {
+		client := &Client{
+			Send:   NewSendQueue(1024),
+			ID:     docID + "/" + strconv.Itoa(i),
+			ConnID: docID + "/" + strconv.Itoa(i),
+			DocID:  docID,
+			Logger: logger,
+		}
+		manager.addClient(client)
+		go func() {
+			for {
+				if _, ok := client.Send.Next(); !ok {
+					return
+				}
+				delivered.Done()
+			}
+		}()
+		b.Cleanup(client.Send.Close)
+	}
+}
+
+// BenchmarkBroadcast sends one message to each of N rooms at once, from a
+// sender per room, and waits for every client to have it. The global
+// broadcaster delivers all rooms on one goroutine, as Run did before each
+// room had its own.
+func BenchmarkBroadcast(b *testing.B) {
+	message := []byte(`{"type":"doc-update","data":{}}`)
+	for _, rooms := range []int{1, 10, 100, 500} {
+		b.Run(fmt.Sprintf("rooms=%d/global", rooms), func(b *testing.B) {
+			manager := benchManager(b, config.Default())
+			var delivered sync.WaitGroup
+			for room := range rooms {
+				benchClients(b, manager, "doc-"+strconv.Itoa(room), benchRoomSize, &delivered)
+			}
+			global := make(chan *BroadcastMessage)
+			go func() {
+				for message := range global {
+					manager.deliver(message)
+				}
+			}()
+			defer close(global)
+
+			b.ResetTimer()
+			for range b.N {
+				delivered.Add(rooms * benchRoomSize)
+				for room := range rooms {
+					go func() {
+						global <- &BroadcastMessage{DocID: "doc-" + strconv.Itoa(room), Data: message}
+					}()
+				}
+				delivered.Wait()
+			}
+		})
+
+		b.Run(fmt.Sprintf("rooms=%d/per-room", rooms), func(b *testing.B) {
+			manager := benchManager(b, config.Default())
+			var delivered sync.WaitGroup
+			for room := range rooms {
+				benchClients(b, manager, "doc-"+strconv.Itoa(room), benchRoomSize, &delivered)
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				delivered.Add(rooms * benchRoomSize)
+				for room := range rooms {
+					go manager.queueRoomBroadcast(&BroadcastMessage{DocID: "doc-" + strconv.Itoa(room), Data: message})
+				}
+				delivered.Wait()
+			}
+		})
+	}
+}
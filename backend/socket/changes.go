@@ -0,0 +1,115 @@
+package socket
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"backend/document"
+	"backend/richtext"
+)
+
+// ChangeData is a pending tracked change as clients see it: where its text
+// is in the document, that text and the text it replaced
+type ChangeData struct {
+	ID        string         `json:"id"`
+	Author    string         `json:"author"`
+	Revision  int64          `json:"revision"`
+	Range     document.Range `json:"range"`
+	Inserted  string         `json:"inserted"`
+	Deleted   string         `json:"deleted"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// TrackedChanges returns a document's pending changes
+func TrackedChanges(doc *document.Document) []ChangeData {
+	changes, content := doc.TrackedChanges()
+	list := make([]ChangeData, 0, len(changes))
+	for _, change := range changes {
+		list = append(list, ChangeData{
+			ID:        change.ID,
+			Author:    change.Author,
+			Revision:  change.Revision,
+			Range:     change.Range,
+			Inserted:  richtext.Slice(content, change.Range.Start, change.Range.End).Text(),
+			Deleted:   change.Deleted.Text(),
+			UpdatedAt: change.UpdatedAt,
+		})
+	}
+	return list
+}
+
+type trackChangesMessage struct {
+	Data struct {
+		Enabled bool `json:"enabled"`
+	} `json:"data"`
+}
+
+// handleTrackChanges lets the owner turn tracking of edits on or off, then
+// tells everyone in the room what they can do now
+func (manager *WebSocketManager) handleTrackChanges(client *Client, message []byte) {
+	var request trackChangesMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "track-changes requires a data.enabled boolean")
+		return
+	}
+
+	if err := client.Doc.SetTrackChanges(client.ID, request.Data.Enabled); err != nil {
+		manager.sendError(client, ErrCodeForbidden, err.Error())
+		return
+	}
+	client.Logger.Info("Track changes toggled", "enabled", request.Data.Enabled)
+	manager.auditPermissions(client.DocID, client.ID, "trackChanges", strconv.FormatBool(request.Data.Enabled))
+	manager.sendRoomCapabilities(client.DocID)
+	manager.broadcastTrackedChanges(client.Doc)
+}
+
+// ReviewChanges accepts or rejects tracked changes on behalf of reviewer,
+// all of them when ids is nil, on a document of reviewer's tenant.
+// Rejecting undoes the changes in one edit everyone in the room gets a
+// doc-sync of.
+func (manager *WebSocketManager) ReviewChanges(docID string, reviewer Session, ids []string, accept bool) ([]document.TrackedChange, error) {
+	doc, err := manager.TenantDocument(reviewer.Tenant, docID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reviewed []document.TrackedChange
+	if accept {
+		reviewed, err = doc.AcceptChanges(reviewer.UserID, ids)
+	} else {
+		var op document.Op
+		op, reviewed, err = doc.RejectChanges(reviewer.UserID, ids, manager.syncRoom(docID))
+		if err == nil && op.Revision > 0 {
+			manager.opApplied(doc, op)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	manager.Logger.Info("Tracked changes reviewed", "doc_id", docID, "accepted", accept, "count", len(reviewed))
+	manager.broadcastTrackedChanges(doc)
+	return reviewed, nil
+}
+
+// broadcastTrackedChanges sends the room a document's pending changes after
+// an edit or review that may have changed them
+func (manager *WebSocketManager) broadcastTrackedChanges(doc *document.Document) {
+	payload, err := json.Marshal(Message{
+		Type: "tracked-changes",
+		Data: map[string][]ChangeData{"changes": TrackedChanges(doc)},
+	})
+	if err != nil {
+		manager.Logger.Error("Error marshalling tracked-changes message", "doc_id", doc.ID, "error", err)
+		return
+	}
+	manager.BroadcastToRoom(doc.ID, payload)
+}
+
+// sendTrackedChanges gives a joining client the pending changes
+func (manager *WebSocketManager) sendTrackedChanges(client *Client) {
+	manager.sendMessage(client, Message{
+		Type: "tracked-changes",
+		Data: map[string][]ChangeData{"changes": TrackedChanges(client.Doc)},
+	})
+}
@@ -0,0 +1,70 @@
+package socket
+
+import (
+	"encoding/json"
+	"time"
+
+	"backend/chat"
+	"backend/ids"
+	"backend/notifications"
+)
+
+type chatMessage struct {
+	Data struct {
+		Text string `json:"text"`
+	} `json:"data"`
+}
+
+// ChatData is the payload of an outbound chat message
+type ChatData struct {
+	Message chat.Message `json:"message"`
+}
+
+// ChatHistoryData is the payload of chat-history, sent on join
+type ChatHistoryData struct {
+	Messages []chat.Message `json:"messages"`
+}
+
+// handleChat stores a chat line and broadcasts it to the whole room,
+// sender included, attributed to the sender's server-side identity. Users
+// it mentions are notified.
+func (manager *WebSocketManager) handleChat(client *Client, message []byte) {
+	var inbound chatMessage
+	if err := json.Unmarshal(message, &inbound); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "chat messages require a data.text string")
+		return
+	}
+	text, err := chat.Sanitize(inbound.Data.Text, manager.Config.Limits.MaxChatLength)
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+
+	line := chat.Message{
+		ID:       ids.NewUUID(),
+		Text:     text,
+		SentAt:   time.Now().UTC(),
+		UserData: manager.clientData(client)["userData"],
+	}
+	manager.Chat.Append(client.DocID, line)
+
+	jsonData, err := json.Marshal(Message{Type: "chat", Data: ChatData{Message: line}})
+	if err != nil {
+		client.Logger.Error("Error marshalling chat message", "error", err)
+		return
+	}
+	manager.BroadcastToRoom(client.DocID, jsonData)
+	manager.notifyMentions(client.Doc, notifications.SourceChat, line.ID, text, line.UserData)
+}
+
+// sendChatHistory replays the room's recent chat to a joining client
+func (manager *WebSocketManager) sendChatHistory(client *Client) {
+	messages := manager.Chat.Recent(client.DocID)
+	if len(messages) == 0 {
+		return
+	}
+	manager.sendMessage(client, Message{
+		Type: "chat-history",
+		Data: ChatHistoryData{Messages: messages},
+	})
+}
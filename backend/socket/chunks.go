@@ -0,0 +1,116 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error codes sent in the data.error.code field of "error" messages
+const (
+	ErrCodeMessageTooLarge = "message-too-large"
+	ErrCodeInvalidChunk    = "invalid-chunk"
+	ErrCodeInvalidMessage  = "invalid-message"
+)
+
+// Limit on concurrent chunked transfers per client
+const maxPendingChunks = 4
+
+var errMessageTooLarge = errors.New("message exceeds size limit")
+
+// errMalformedFrame is a binary frame that isn't a MessagePack message
+var errMalformedFrame = errors.New("malformed frame")
+
+// chunkMessage is the inbound shape of chunk-start, chunk-part and
+// chunk-end. A transfer announces its total size up front, streams the
+// payload as string parts and is processed as one message on chunk-end.
+type chunkMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		ID   string `json:"id"`
+		Size int64  `json:"size"`
+		Part string `json:"part"`
+	} `json:"data"`
+}
+
+type chunkBuffer struct {
+	size    int64
+	payload strings.Builder
+}
+
+func isChunkType(msgType string) bool {
+	return msgType == "chunk-start" || msgType == "chunk-part" || msgType == "chunk-end"
+}
+
+// handleChunk accumulates a chunked transfer and hands the reassembled
+// payload to handleMessage once it is complete, counted against the rate
+// limits of its own type like any other message
+func (manager *WebSocketManager) handleChunk(client *Client, msgType string, message []byte) {
+	var chunk chunkMessage
+	if err := json.Unmarshal(message, &chunk); err != nil || chunk.Data.ID == "" {
+		manager.sendError(client, ErrCodeInvalidChunk, "chunk messages require a data.id")
+		return
+	}
+
+	id := chunk.Data.ID
+	buffer, started := client.chunks[id]
+
+	switch msgType {
+	case "chunk-start":
+		if started {
+			manager.sendError(client, ErrCodeInvalidChunk, fmt.Sprintf("chunk %s already started", id))
+			return
+		}
+		if len(client.chunks) >= maxPendingChunks {
+			manager.sendError(client, ErrCodeInvalidChunk, "too many chunked transfers in progress")
+			return
+		}
+		if chunk.Data.Size <= 0 || chunk.Data.Size > manager.Config.Limits.MaxChunkedSize {
+			manager.sendError(client, ErrCodeMessageTooLarge,
+				fmt.Sprintf("chunked payloads are limited to %d bytes", manager.Config.Limits.MaxChunkedSize))
+			return
+		}
+		client.chunks[id] = &chunkBuffer{size: chunk.Data.Size}
+
+	case "chunk-part":
+		if !started {
+			manager.sendError(client, ErrCodeInvalidChunk, fmt.Sprintf("chunk %s was not started", id))
+			return
+		}
+		if int64(buffer.payload.Len()+len(chunk.Data.Part)) > buffer.size {
+			delete(client.chunks, id)
+			manager.sendError(client, ErrCodeMessageTooLarge, fmt.Sprintf("chunk %s exceeds its declared size", id))
+			return
+		}
+		buffer.payload.WriteString(chunk.Data.Part)
+
+	case "chunk-end":
+		if !started {
+			manager.sendError(client, ErrCodeInvalidChunk, fmt.Sprintf("chunk %s was not started", id))
+			return
+		}
+		delete(client.chunks, id)
+		if int64(buffer.payload.Len()) != buffer.size {
+			manager.sendError(client, ErrCodeInvalidChunk, fmt.Sprintf("chunk %s ended before its declared size", id))
+			return
+		}
+
+		payload := []byte(buffer.payload.String())
+		innerType := messageType(payload)
+		if innerType == "" || isChunkType(innerType) {
+			manager.sendError(client, ErrCodeInvalidChunk, fmt.Sprintf("chunk %s does not contain a valid message", id))
+			return
+		}
+		if !manager.allow(client, innerType) {
+			return
+		}
+		invalid := validateMessage(innerType, payload)
+		if !manager.interceptable(invalid) {
+			manager.sendSchemaError(client, invalid)
+			return
+		}
+		client.Logger.Debug("Reassembled chunked message", "chunk_id", id, "size", len(payload))
+		manager.dispatch(client, innerType, payload, invalid)
+	}
+}
@@ -0,0 +1,240 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"backend/metrics"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is the version of the message protocol this server
+// speaks. Clients may send theirs in the protocol query parameter and are
+// turned away with protocol-mismatch when it differs.
+const ProtocolVersion = 1
+
+// CloseReason is why the server ended a connection: the WebSocket close
+// code, its name, which is also the code of the error sent just before,
+// and whether reconnecting can succeed
+type CloseReason struct {
+	Code  int    `json:"code"`
+	Name  string `json:"name"`
+	Retry bool   `json:"retry"`
+
+	// status answers the HTTP transports turned away for the same reason
+	status int
+}
+
+// The close codes the server ends connections with. 4000-4999 are free
+// for applications to use.
+var (
+	CloseProtocolMismatch    = CloseReason{Code: 4000, Name: "protocol-mismatch", status: http.StatusBadRequest}
+	CloseAuthExpired         = CloseReason{Code: 4001, Name: "auth-expired", Retry: true, status: http.StatusUnauthorized}
+	CloseBanned              = CloseReason{Code: 4002, Name: "banned", status: http.StatusForbidden}
+	CloseKicked              = CloseReason{Code: 4003, Name: "kicked", Retry: true, status: http.StatusForbidden}
+	CloseRoomFull            = CloseReason{Code: 4004, Name: "room-full", Retry: true, status: http.StatusServiceUnavailable}
+	CloseRoomMoved           = CloseReason{Code: 4005, Name: "room-moved", Retry: true, status: http.StatusConflict}
+	CloseServerDraining      = CloseReason{Code: 4006, Name: "server-draining", Retry: true, status: http.StatusServiceUnavailable}
+	CloseShareInvalid        = CloseReason{Code: 4007, Name: "share-invalid", status: http.StatusForbidden}
+	CloseTooSlow             = CloseReason{Code: 4008, Name: "too-slow", Retry: true, status: http.StatusServiceUnavailable}
+	CloseWrongTenant         = CloseReason{Code: 4009, Name: "wrong-tenant", status: http.StatusForbidden}
+	CloseTenantQuota         = CloseReason{Code: 4010, Name: "tenant-quota", Retry: true, status: http.StatusTooManyRequests}
+	CloseServerFull          = CloseReason{Code: 4011, Name: "server-full", Retry: true, status: http.StatusServiceUnavailable}
+	CloseDocumentTrashed     = CloseReason{Code: 4012, Name: "document-trashed", status: http.StatusGone}
+	CloseTooManyConnections  = CloseReason{Code: 4013, Name: "too-many-connections", Retry: true, status: http.StatusTooManyRequests}
+	CloseOwnerChanged        = CloseReason{Code: 4014, Name: "owner-changed", Retry: true, status: http.StatusServiceUnavailable}
+	CloseIdleTimeout         = CloseReason{Code: 4015, Name: "idle-timeout", Retry: true, status: http.StatusRequestTimeout}
+	CloseRateLimited         = CloseReason{Code: 4016, Name: "rate-limited", Retry: true, status: http.StatusTooManyRequests}
+	CloseDocumentUnavailable = CloseReason{Code: websocket.CloseTryAgainLater, Name: "document-unavailable", Retry: true, status: http.StatusServiceUnavailable}
+)
+
+// CloseReasons is the catalog published to clients in /api/meta
+var CloseReasons = []CloseReason{
+	CloseProtocolMismatch,
+	CloseAuthExpired,
+	CloseBanned,
+	CloseKicked,
+	CloseRoomFull,
+	CloseRoomMoved,
+	CloseServerDraining,
+	CloseShareInvalid,
+	CloseTooSlow,
+	CloseWrongTenant,
+	CloseTenantQuota,
+	CloseServerFull,
+	CloseDocumentTrashed,
+	CloseTooManyConnections,
+	CloseOwnerChanged,
+	CloseIdleTimeout,
+	CloseRateLimited,
+	CloseDocumentUnavailable,
+}
+
+// Status is the HTTP status for a request turned away for the reason
+func (reason CloseReason) Status() int {
+	return reason.status
+}
+
+// ErrorData is the payload of an error message. Close is set when the
+// server ends the connection right after sending it.
+type ErrorData struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Field   string       `json:"field,omitempty"`
+	Close   *CloseReason `json:"close,omitempty"`
+
+	// RetryAfter is how long to wait before trying again, as a duration
+	RetryAfter string `json:"retryAfter,omitempty"`
+}
+
+func errorMessage(data ErrorData) Message {
+	return Message{Type: "error", Data: map[string]ErrorData{"error": data}}
+}
+
+// message is the error sent before closing a connection for the reason
+func (reason CloseReason) message(details string) Message {
+	return errorMessage(ErrorData{Code: reason.Name, Message: details, Close: &reason})
+}
+
+// CloseError is a connection turned away before it joined its room.
+// RetryAfter, when set, is how long until trying again may succeed.
+type CloseError struct {
+	Reason     CloseReason
+	Details    string
+	RetryAfter time.Duration
+}
+
+func (err *CloseError) Error() string {
+	return err.Details
+}
+
+// writeRefusal answers an HTTP transport turned away before it joined its
+// room
+func writeRefusal(w http.ResponseWriter, err *CloseError) {
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", RetryAfterSeconds(err.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Reason.status)
+	json.NewEncoder(w).Encode(map[string]any{"error": err.Details, "code": err.Reason.Name, "retry": err.Reason.Retry})
+}
+
+// message is the error sent before closing a connection turned away
+func (err *CloseError) message() Message {
+	data := ErrorData{Code: err.Reason.Name, Message: err.Details, Close: &err.Reason}
+	if err.RetryAfter > 0 {
+		data.RetryAfter = err.RetryAfter.String()
+	}
+	return errorMessage(data)
+}
+
+// admit checks whether userID may join docID on this node
+func (manager *WebSocketManager) admit(userID string, remoteIP string, docID string) *CloseError {
+	if manager.draining.Load() {
+		return &CloseError{Reason: CloseServerDraining, Details: "server is shutting down, reconnect shortly"}
+	}
+	if until, banned := manager.bans.until(userID); banned {
+		return &CloseError{Reason: CloseBanned, Details: "banned until " + until.UTC().Format(time.RFC3339)}
+	}
+	if limit := manager.Config.Limits.MaxRoomClients; limit > 0 {
+		if manager.roomHumans(docID) >= limit {
+			metrics.LimitRejections.WithLabelValues("room-clients").Inc()
+			return &CloseError{Reason: CloseRoomFull, Details: "room is full", RetryAfter: capacityRetryAfter}
+		}
+	}
+	if limit := manager.Config.Limits.MaxRooms; limit > 0 {
+		manager.Mutex.RLock()
+		_, open := manager.Rooms[docID]
+		full := !open && len(manager.Rooms) >= limit
+		manager.Mutex.RUnlock()
+		if full {
+			metrics.LimitRejections.WithLabelValues("rooms").Inc()
+			return &CloseError{Reason: CloseServerFull, Details: "this node has as many rooms open as it can take", RetryAfter: capacityRetryAfter}
+		}
+	}
+	if limit := manager.Config.Limits.MaxUserConnections; limit > 0 {
+		count := manager.countClients(func(client *Client) bool { return client.ID == userID && client.ImpersonatedBy == "" && !client.Bot })
+		if count >= limit {
+			metrics.LimitRejections.WithLabelValues("user-connections").Inc()
+			manager.Logger.Warn("Connection refused, too many for the user", "user_id", userID, "remote_ip", remoteIP, "limit", limit)
+			return &CloseError{Reason: CloseTooManyConnections, Details: fmt.Sprintf("too many connections open for your account (limit %d), close some tabs and try again", limit), RetryAfter: capacityRetryAfter}
+		}
+	}
+	if limit := manager.Config.Limits.MaxIPConnections; limit > 0 {
+		count := manager.countClients(func(client *Client) bool { return client.RemoteIP == remoteIP && !client.Bot })
+		if count >= limit {
+			metrics.LimitRejections.WithLabelValues("ip-connections").Inc()
+			manager.Logger.Warn("Connection refused, too many from the address", "user_id", userID, "remote_ip", remoteIP, "limit", limit)
+			return &CloseError{Reason: CloseTooManyConnections, Details: fmt.Sprintf("too many connections open from your address (limit %d)", limit), RetryAfter: capacityRetryAfter}
+		}
+	}
+	return nil
+}
+
+// countClients counts the clients connected to this node that match
+func (manager *WebSocketManager) countClients(match func(client *Client) bool) int {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	count := 0
+	for client := range manager.Clients {
+		if match(client) {
+			count++
+		}
+	}
+	return count
+}
+
+// closeConn turns away a WebSocket that never joined its room: messages
+// are written first, then the close frame for reason
+func (manager *WebSocketManager) closeConn(conn *websocket.Conn, encoding Encoding, reason CloseReason, messages ...Message) {
+	defer conn.Close()
+
+	deadline := time.Now().Add(manager.Config.Limits.WriteTimeout)
+	conn.SetWriteDeadline(deadline)
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err == nil {
+			data, err = encoding.encode(data)
+		}
+		if err != nil {
+			manager.Logger.Error("Error marshalling message", "type", message.Type, "error", err)
+			continue
+		}
+		if err := conn.WriteMessage(encoding.frameType(), data); err != nil {
+			return
+		}
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(reason.Code, reason.Name), deadline)
+}
+
+// disconnect ends a registered client's connection for reason, after the
+// messages already queued for it and an error saying why. It must not be
+// called from the Run goroutine.
+func (manager *WebSocketManager) disconnect(client *Client, reason CloseReason, details string) {
+	client.closeReason.Store(&reason)
+	manager.sendMessage(client, reason.message(details))
+	manager.Unregister <- client
+}
+
+// closeFrame is the close frame the write pump ends a connection with
+func (client *Client) closeFrame() []byte {
+	reason := client.closeReason.Load()
+	if reason == nil {
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	}
+	text := reason.Name
+	if note := client.closeNote.Load(); note != nil {
+		text += ": " + *note
+	}
+	// A close frame's reason can't exceed 123 bytes
+	for len(text) > 123 {
+		_, size := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-size]
+	}
+	return websocket.FormatCloseMessage(reason.Code, text)
+}
@@ -0,0 +1,148 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"backend/audit"
+	"backend/chat"
+	"backend/comments"
+	"backend/document"
+	"backend/events"
+	"backend/ids"
+	"backend/notifications"
+)
+
+// CommentData is the payload of comment-added and comment-resolved
+type CommentData struct {
+	Comment comments.Comment `json:"comment"`
+}
+
+// AddComment anchors a comment to a range of the document as it was at
+// revision and announces it to the room. The document must belong to
+// author's tenant, which it is created for within its quota if missing.
+// The users it mentions are notified, and so is the document's owner
+// otherwise.
+func (manager *WebSocketManager) AddComment(ctx context.Context, docID string, author Session, revision int64, anchor document.Range, text string) (comments.Comment, error) {
+	text, err := chat.Sanitize(text, comments.MaxTextLength)
+	if err != nil {
+		return comments.Comment{}, err
+	}
+
+	doc, err := manager.OpenTenantDocument(ctx, author.Tenant, docID, false)
+	if err != nil {
+		return comments.Comment{}, err
+	}
+	id := ids.NewUUID()
+	current, err := doc.AddAnchor(id, revision, anchor)
+	if err != nil {
+		return comments.Comment{}, err
+	}
+
+	comment := comments.Comment{
+		ID:        id,
+		DocID:     docID,
+		Text:      text,
+		Author:    author.UserData(),
+		Anchor:    current,
+		CreatedAt: time.Now().UTC(),
+	}
+	manager.Comments.Add(comment)
+
+	manager.broadcastComment("comment-added", comment)
+	manager.Audit.Record(audit.Entry{Action: audit.ActionCommented, DocID: docID, UserID: author.UserID, Details: map[string]string{"commentId": id}})
+	manager.emitEvent(events.TypeCommentAdded, docID, author.UserID, events.CommentAdded{CommentID: id})
+	mentioned := manager.notifyMentions(doc, notifications.SourceComment, id, text, comment.Author)
+	if owner := doc.Permissions().Owner; owner != "" && owner != author.UserID && !mentioned[owner] {
+		manager.notify(notifications.Notification{
+			UserID:   owner,
+			Type:     notifications.TypeComment,
+			DocID:    docID,
+			Source:   notifications.SourceComment,
+			SourceID: id,
+			Text:     text,
+			From:     comment.Author,
+		})
+	}
+	return comment, nil
+}
+
+// commentAddMessage is a comment-add, which comments on a range of the
+// document as it was at revision without going through the REST API, as
+// bots do
+type commentAddMessage struct {
+	Data struct {
+		Revision int64  `json:"revision"`
+		Start    int    `json:"start"`
+		End      int    `json:"end"`
+		Text     string `json:"text"`
+	} `json:"data"`
+}
+
+func (manager *WebSocketManager) handleCommentAdd(client *Client, message []byte) {
+	var inbound commentAddMessage
+	if err := json.Unmarshal(message, &inbound); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "comment-add messages require a revision, a start, an end and a text")
+		return
+	}
+	author, ok := manager.Sessions.Lookup(client.SessionID)
+	if !ok {
+		manager.sendError(client, ErrCodeForbidden, "the session has expired, reconnect to comment")
+		return
+	}
+	anchor := document.Range{Start: inbound.Data.Start, End: inbound.Data.End}
+	if _, err := manager.AddComment(context.Background(), client.DocID, author, inbound.Data.Revision, anchor, inbound.Data.Text); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+	}
+}
+
+// ResolveComment marks a comment resolved and announces it to the room.
+// The document must exist and belong to resolver's tenant.
+func (manager *WebSocketManager) ResolveComment(docID string, commentID string, resolver Session) (comments.Comment, error) {
+	doc, err := manager.TenantDocument(resolver.Tenant, docID)
+	if err != nil {
+		return comments.Comment{}, err
+	}
+	comment, err := manager.Comments.Resolve(docID, commentID, resolver.UserData())
+	if err != nil {
+		return comments.Comment{}, err
+	}
+	refreshAnchor(doc, &comment)
+
+	manager.broadcastComment("comment-resolved", comment)
+	manager.emitEvent(events.TypeCommentResolved, docID, resolver.UserID, events.CommentResolved{CommentID: commentID})
+	return comment, nil
+}
+
+// ListComments returns the comments on a document of tenant with up to
+// date anchors
+func (manager *WebSocketManager) ListComments(tenant string, docID string) ([]comments.Comment, error) {
+	list := manager.Comments.List(docID)
+	if len(list) == 0 {
+		return list, nil
+	}
+	doc, err := manager.TenantDocument(tenant, docID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		refreshAnchor(doc, &list[i])
+	}
+	return list, nil
+}
+
+func refreshAnchor(doc *document.Document, comment *comments.Comment) {
+	if anchor, ok := doc.Anchor(comment.ID); ok {
+		comment.Anchor = anchor
+	}
+}
+
+func (manager *WebSocketManager) broadcastComment(messageType string, comment comments.Comment) {
+	jsonData, err := json.Marshal(Message{Type: messageType, Data: CommentData{Comment: comment}})
+	if err != nil {
+		manager.Logger.Error("Error marshalling comment message", "type", messageType, "error", err)
+		return
+	}
+	manager.BroadcastToRoom(comment.DocID, jsonData)
+}
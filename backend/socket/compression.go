@@ -0,0 +1,61 @@
+package socket
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// countingConn counts the bytes written to a connection. The write pump
+// writes data frames, but control frames are also written from the read
+// goroutine, answering pings and close frames, and by the idle loop, so
+// the count is atomic. The difference across a data frame is its size on
+// the wire after compression, give or take a control frame written
+// meanwhile.
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (conn *countingConn) Write(p []byte) (int, error) {
+	n, err := conn.Conn.Write(p)
+	conn.written.Add(int64(n))
+	return n, err
+}
+
+// countingWriter hands the upgrader a countingConn when it hijacks the
+// connection
+type countingWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, rw, nil
+}
+
+// offersDeflate reports whether the client offered permessage-deflate,
+// which the upgrader accepts whenever compression is enabled
+func offersDeflate(r *http.Request) bool {
+	for _, extensions := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for extension := range strings.SplitSeq(extensions, ",") {
+			name, _, _ := strings.Cut(extension, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
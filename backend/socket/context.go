@@ -0,0 +1,27 @@
+package socket
+
+import (
+	"context"
+	"time"
+)
+
+// Context ends once the client is removed from the manager or the server
+// has shut down. Work done on the client's behalf, such as storage calls,
+// should stop with it.
+func (client *Client) Context() context.Context {
+	return client.ctx
+}
+
+// bindContext gives a new client a context of its own under the manager's
+func (manager *WebSocketManager) bindContext(client *Client) {
+	client.ctx, client.cancel = context.WithCancel(manager.ctx)
+}
+
+// stopReadingOnCancel makes the read pump of a WebSocket client stop
+// waiting for the next frame once its context ends. The connection stays
+// open for the write pump to flush what is queued and close it.
+func stopReadingOnCancel(client *Client) (stop func() bool) {
+	return context.AfterFunc(client.ctx, func() {
+		client.Conn.SetReadDeadline(time.Now())
+	})
+}
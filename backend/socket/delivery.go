@@ -0,0 +1,78 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+
+	"backend/metrics"
+)
+
+// What became of a numbered edit, in edit-ack
+const (
+	EditCommitted = "committed"
+	EditSuggested = "suggested"
+	EditRejected  = "rejected"
+	EditDuplicate = "duplicate"
+)
+
+var errInvalidSeq = errors.New("data.seq must be a positive integer")
+
+// EditAckData is the payload of edit-ack, which tells a client what became
+// of the edit it numbered Seq: committed at Revision, kept as a
+// suggestion, rejected with the error sent just before it, or dropped as a
+// duplicate of one already received
+type EditAckData struct {
+	Seq      int64  `json:"seq"`
+	Status   string `json:"status"`
+	Revision int64  `json:"revision,omitempty"`
+}
+
+// RetransmitData is the payload of edit-retransmit, which asks a client to
+// send its edits again from sequence number From on, after one went
+// missing
+type RetransmitData struct {
+	From int64 `json:"from"`
+}
+
+// editSeq takes the sequence number off an edit, so it isn't relayed with
+// it. Clients that don't number their edits get no acks.
+func editSeq(edit contentMessage) (seq int64, sequenced bool, err error) {
+	raw, ok := edit.Data["seq"]
+	if !ok {
+		return 0, false, nil
+	}
+	delete(edit.Data, "seq")
+	if err := json.Unmarshal(raw, &seq); err != nil || seq < 1 {
+		return 0, false, errInvalidSeq
+	}
+	return seq, true, nil
+}
+
+// sequenceEdit reports whether the edit numbered seq is the next one from
+// the client's session. A duplicate is acknowledged as such; after a gap
+// the client is asked once to send its edits again from the missing one.
+func (manager *WebSocketManager) sequenceEdit(client *Client, seq int64) bool {
+	expected, ok := manager.Sessions.Sequence(client.SessionID, client.DocID, seq)
+	switch {
+	case ok:
+		client.retransmitFrom = 0
+		return true
+	case seq < expected:
+		metrics.EditSequenceErrors.WithLabelValues("duplicate").Inc()
+		manager.sendMessage(client, Message{Type: "edit-ack", Data: EditAckData{Seq: seq, Status: EditDuplicate}})
+	case client.retransmitFrom != expected:
+		metrics.EditSequenceErrors.WithLabelValues("gap").Inc()
+		client.retransmitFrom = expected
+		client.Logger.Debug("Edits missing, asking for retransmission", "expected", expected, "received", seq)
+		manager.sendMessage(client, Message{Type: "edit-retransmit", Data: RetransmitData{From: expected}})
+	}
+	return false
+}
+
+// suggested is the acknowledgement of an edit kept as a suggestion
+func suggested(ok bool) EditAckData {
+	if !ok {
+		return EditAckData{Status: EditRejected}
+	}
+	return EditAckData{Status: EditSuggested}
+}
@@ -0,0 +1,115 @@
+package socket
+
+import (
+	"encoding/json"
+	"time"
+
+	"backend/document"
+	"backend/events"
+
+	"github.com/gorilla/websocket"
+)
+
+// acquireDocument holds a joining client's document open, loading it if
+// it was unloaded. On failure the connection is closed with
+// document-unavailable, telling the client to retry later.
+func (manager *WebSocketManager) acquireDocument(conn *websocket.Conn, encoding Encoding, docID string) (*document.Document, bool) {
+	doc, err := manager.Documents.Acquire(docID)
+	if err == nil {
+		return doc, true
+	}
+
+	manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+	manager.closeConn(conn, encoding, CloseDocumentUnavailable, CloseDocumentUnavailable.message("document could not be loaded"))
+	return nil, false
+}
+
+// unloadIdleDocuments saves and unloads the documents that have had no
+// clients for the idle timeout. Without a document store it does nothing.
+func (manager *WebSocketManager) unloadIdleDocuments() {
+	idle := manager.Config.DocumentIdleTimeout
+	ticker := time.NewTicker(max(min(idle/2, time.Minute), time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Unloaded documents keep their place in the duplicate index until
+		// they hibernate
+		manager.indexDocuments()
+		unloaded, err := manager.Documents.Evict(idle)
+		if err != nil {
+			manager.Logger.Warn("Could not unload idle documents", "error", err)
+		}
+		for _, docID := range unloaded {
+			manager.Links.Forget(docID)
+			manager.Analysis.Forget(docID)
+			manager.Canary.Forget(docID)
+			if manager.Config.HibernateAfter > 0 {
+				manager.dormant.add(docID)
+			}
+			manager.Logger.Debug("Unloaded idle document", "doc_id", docID)
+		}
+	}
+}
+
+// documentCreated publishes document.created for a document opened for
+// the first time
+func (manager *WebSocketManager) documentCreated(docID string) {
+	manager.emitEvent(events.TypeDocumentCreated, docID, "", events.DocumentCreated{})
+}
+
+// autosave saves the loaded documents that changed since they were last
+// saved, so a crash loses at most one interval of edits. Without a
+// document store it does nothing.
+func (manager *WebSocketManager) autosave() {
+	ticker := time.NewTicker(manager.Config.AutosaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		saved, err := manager.Documents.SaveChanged()
+		if err != nil {
+			manager.Logger.Warn("Could not save changed documents", "error", err)
+		}
+		if saved > 0 {
+			manager.Logger.Debug("Saved changed documents", "count", saved)
+		}
+	}
+}
+
+// saveStatusChanged tells a document's room where it stands with the
+// store. It runs with the document locked.
+func (manager *WebSocketManager) saveStatusChanged(docID string, status document.SaveStatus) {
+	payload, err := json.Marshal(Message{Type: "save-status", Data: status})
+	if err != nil {
+		manager.Logger.Error("Error marshalling save-status message", "doc_id", docID, "error", err)
+		return
+	}
+	manager.BroadcastToRoom(docID, payload)
+}
+
+// sendSaveStatus tells a joining client where its document stands with
+// the store, when there is one
+func (manager *WebSocketManager) sendSaveStatus(client *Client) {
+	if status, ok := client.Doc.SaveStatus(); ok {
+		manager.sendMessage(client, Message{Type: "save-status", Data: status})
+	}
+}
+
+// Retention is how much of each document's op log compaction keeps
+func (manager *WebSocketManager) Retention() document.Retention {
+	return document.Retention{
+		Revisions: manager.Config.Limits.HistorySize,
+		MaxAge:    manager.Config.Compaction.MaxAge,
+	}
+}
+
+func (manager *WebSocketManager) compactHistory() {
+	ticker := time.NewTicker(manager.Config.Compaction.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		documents, ops := manager.Documents.Compact(manager.Retention())
+		if ops > 0 {
+			manager.Logger.Debug("Compacted op logs", "documents", documents, "ops", ops)
+		}
+	}
+}
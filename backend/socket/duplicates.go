@@ -0,0 +1,37 @@
+package socket
+
+import (
+	"slices"
+
+	"backend/similarity"
+)
+
+// FindDuplicates returns the documents of tenant that substantially
+// duplicate docID, leaving out those in the trash. Documents saved before
+// a restart are only compared once loaded again.
+func (manager *WebSocketManager) FindDuplicates(docID string, tenant string) ([]similarity.Match, error) {
+	if _, err := manager.Documents.Lookup(docID); err != nil {
+		return nil, err
+	}
+	manager.indexDocuments()
+	matches := manager.Similarity.Similar(docID, manager.Config.DuplicateThreshold)
+	return slices.DeleteFunc(matches, func(match similarity.Match) bool {
+		doc, err := manager.Documents.Lookup(match.DocID)
+		if err != nil {
+			return true
+		}
+		_, trashed := doc.TrashedAt()
+		return trashed || doc.Tenant() != tenant
+	}), nil
+}
+
+// indexDocuments brings the duplicate index up to date with every loaded
+// document that changed since it was last indexed
+func (manager *WebSocketManager) indexDocuments() {
+	for _, doc := range manager.Documents.All() {
+		content, revision := doc.Contents()
+		if !manager.Similarity.Current(doc.ID, revision) {
+			manager.Similarity.Update(doc.ID, revision, content.Text())
+		}
+	}
+}
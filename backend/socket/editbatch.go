@@ -0,0 +1,82 @@
+package socket
+
+import (
+	"encoding/json"
+	"maps"
+	"strconv"
+
+	"backend/metrics"
+	"backend/richtext"
+)
+
+// relayedEdit is the edit a content frame relays: change made against
+// base, or nil for a whole document edit, committed as revision
+type relayedEdit struct {
+	frame    contentMessage
+	change   richtext.Delta
+	base     int64
+	revision int64
+}
+
+// editBatch is a run of edits by one connection that a room's broadcaster
+// holds back to relay as one frame. Whole document edits each replace the
+// last; delta edits are merged while each inserts right after the last.
+// The frame carries the revision of the latest edit and, for deltas, the
+// base revision of the first, so receivers see the revisions in between
+// skipped.
+type editBatch struct {
+	first   *BroadcastMessage
+	last    *BroadcastMessage
+	edit    relayedEdit
+	pending int
+}
+
+func newEditBatch(message *BroadcastMessage) *editBatch {
+	return &editBatch{first: message, last: message, edit: *message.edit, pending: 1}
+}
+
+// add merges message into the batch, reporting false if it can't be
+func (batch *editBatch) add(message *BroadcastMessage) bool {
+	next := message.edit
+	if next == nil || message.Sender != batch.first.Sender {
+		return false
+	}
+	switch {
+	case batch.edit.change == nil && next.change == nil:
+		batch.edit = *next
+	case batch.edit.change != nil && next.change != nil && next.base == batch.edit.revision:
+		merged, ok := richtext.MergeInserts(batch.edit.change, next.change)
+		if !ok {
+			return false
+		}
+		batch.edit = relayedEdit{frame: next.frame, change: merged, base: batch.edit.base, revision: next.revision}
+	default:
+		return false
+	}
+	batch.last = message
+	batch.pending++
+	metrics.CoalescedEdits.Inc()
+	return true
+}
+
+// message is the frame relaying the whole batch. Should it fail to
+// marshal, the latest edit's frame still carries the resulting HTML.
+func (batch *editBatch) message() *BroadcastMessage {
+	if batch.pending == 1 || batch.edit.change == nil {
+		return batch.last
+	}
+	delta, err := json.Marshal(batch.edit.change)
+	if err != nil {
+		batch.first.Sender.Logger.Error("Error marshalling delta", "error", err)
+		return batch.last
+	}
+	frame := contentMessage{Type: batch.edit.frame.Type, Data: maps.Clone(batch.edit.frame.Data)}
+	frame.Data["delta"] = delta
+	frame.Data["baseRevision"] = json.RawMessage(strconv.FormatInt(batch.edit.base, 10))
+	data, err := json.Marshal(frame)
+	if err != nil {
+		batch.first.Sender.Logger.Error("Error marshalling content message", "error", err)
+		return batch.last
+	}
+	return &BroadcastMessage{DocID: batch.first.DocID, Sender: batch.first.Sender, Data: data}
+}
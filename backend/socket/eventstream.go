@@ -0,0 +1,142 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"backend/metrics"
+)
+
+// Interval between comments sent on an idle event stream, so proxies don't
+// time it out
+const eventKeepAlive = 25 * time.Second
+
+// connectionEvent is the first event on a stream, telling the client the
+// connection ID to post its messages to
+type connectionEvent struct {
+	ConnID string `json:"connId"`
+}
+
+// HandleEventStream is the fallback for networks that block WebSockets:
+// the messages a WebSocket client would receive are streamed as
+// Server-Sent Events, and the client sends its own with PostMessage. The
+// client joins its room, presence included, like any other.
+func (manager *WebSocketManager) HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	if !manager.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if manager.ForwardToOwner(w, r, r.URL.Query().Get("doc")) {
+		return
+	}
+	if encoding, err := negotiateEncoding(r); err != nil || encoding != EncodingJSON {
+		http.Error(w, "event streams only carry JSON", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, err := manager.newHTTPClient(TransportEventStream, r.URL.Query().Get("doc"), w, r)
+	var moved *RoomMovedError
+	if errors.As(err, &moved) {
+		http.Error(w, moved.Error(), http.StatusConflict)
+		return
+	}
+	var refused *CloseError
+	if errors.As(err, &refused) {
+		writeRefusal(w, refused)
+		return
+	}
+	if err != nil {
+		http.Error(w, "document unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	connection, err := json.Marshal(connectionEvent{ConnID: client.ConnID})
+	if err != nil {
+		client.Logger.Error("Error marshalling connection event", "error", err)
+		manager.Documents.Release(client.DocID)
+		return
+	}
+	fmt.Fprintf(w, "event: connection\ndata: %s\n\n", connection)
+	flusher.Flush()
+
+	manager.Register <- client
+	go manager.readInbox(client)
+	manager.writeEvents(client, w, flusher, r)
+}
+
+// writeEvents is the write pump of an event stream client. It runs in the
+// request's handler and returns when the client goes away, is closed or
+// is removed by the manager.
+func (manager *WebSocketManager) writeEvents(client *Client, w http.ResponseWriter, flusher http.Flusher, r *http.Request) {
+	defer client.httpConn.close()
+
+	controller := http.NewResponseController(w)
+	writeTimeout := manager.Config.Limits.WriteTimeout
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-client.Send.Ready():
+			message, ok := client.Send.Pop()
+			if !ok {
+				if client.Send.Closed() {
+					client.Logger.Debug("Send queue closed")
+					return
+				}
+				continue
+			}
+			controller.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := writeEventBatch(client, w, message); err != nil {
+				client.Logger.Warn("Error sending message", "error", err)
+				return
+			}
+			manager.relieve(client)
+		case <-keepAlive.C:
+			controller.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-client.httpConn.closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEventBatch writes first plus whatever is already queued, one event
+// per message. Messages are compact JSON, so each fits on a data line.
+func writeEventBatch(client *Client, w io.Writer, first []byte) error {
+	message := first
+	for i := 1; ; i++ {
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+			return err
+		}
+		metrics.MessageSize.WithLabelValues("outbound").Observe(float64(len(message)))
+		if i == maxBatchMessages {
+			return nil
+		}
+		var ok bool
+		if message, ok = client.Send.Pop(); !ok {
+			return nil
+		}
+	}
+}
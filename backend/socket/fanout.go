@@ -0,0 +1,55 @@
+package socket
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// fanoutPool shares the delivery of a message to a large room between a
+// bounded set of goroutines, so the last of hundreds of clients doesn't
+// wait for every send before its own. The pool is shared by all rooms;
+// batches no worker is free for are delivered by the caller.
+type fanoutPool struct {
+	tasks chan func()
+}
+
+// newFanoutPool starts workers goroutines, which stop with ctx
+func newFanoutPool(ctx context.Context, workers int) *fanoutPool {
+	pool := &fanoutPool{tasks: make(chan func())}
+	for range workers {
+		go pool.work(ctx)
+	}
+	return pool
+}
+
+func (pool *fanoutPool) work(ctx context.Context) {
+	for {
+		select {
+		case task := <-pool.tasks:
+			task()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run calls deliver on each batch of size clients, handing batches to
+// idle workers and delivering the rest itself, and returns once every
+// batch is delivered
+func (pool *fanoutPool) run(clients []*Client, size int, deliver func([]*Client)) {
+	var wg sync.WaitGroup
+	for batch := range slices.Chunk(clients, size) {
+		wg.Add(1)
+		task := func() {
+			defer wg.Done()
+			deliver(batch)
+		}
+		select {
+		case pool.tasks <- task:
+		default:
+			task()
+		}
+	}
+	wg.Wait()
+}
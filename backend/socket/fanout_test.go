@@ -0,0 +1,40 @@
+package socket
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"backend/config"
+)
+
+// BenchmarkFanout delivers one message to a room of N clients and waits
+// for every client to have it, sending to each in turn and through the
+// worker pool
+func BenchmarkFanout(b *testing.B) {
+	message := []byte(`{"type":"doc-update","data":{}}`)
+	for _, clients := range []int{100, 500, 1000} {
+		for _, workers := range []int{0, config.Default().Fanout.Workers} {
+			name := fmt.Sprintf("clients=%d/pool=%d", clients, workers)
+			if workers == 0 {
+				name = fmt.Sprintf("clients=%d/serial", clients)
+			}
+			b.Run(name, func(b *testing.B) {
+				cfg := config.Default()
+				cfg.Fanout.Workers = workers
+				// Below the default, so even the smallest room is split
+				cfg.Fanout.BatchSize = 50
+				manager := benchManager(b, cfg)
+				var delivered sync.WaitGroup
+				benchClients(b, manager, "doc", clients, &delivered)
+
+				b.ResetTimer()
+				for range b.N {
+					delivered.Add(clients)
+					manager.deliver(&BroadcastMessage{DocID: "doc", Data: message})
+					delivered.Wait()
+				}
+			})
+		}
+	}
+}
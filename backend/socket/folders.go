@@ -0,0 +1,197 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/document"
+	"backend/folders"
+	"backend/ids"
+	"backend/ownership"
+)
+
+// FolderUpdate changes the fields of a folder that are set
+type FolderUpdate struct {
+	Name        *string
+	ParentID    *string
+	Permissions *folders.Permissions
+}
+
+// updateWorkspace loads the workspace of owner, lets change modify it and
+// saves it, one change at a time so concurrent ones aren't lost
+func (manager *WebSocketManager) updateWorkspace(ctx context.Context, owner string, change func(workspace *folders.Workspace) error) (folders.Workspace, error) {
+	manager.workspaceMutex.Lock()
+	defer manager.workspaceMutex.Unlock()
+
+	workspace, err := manager.Folders.LoadWorkspace(ctx, owner)
+	if err != nil {
+		return folders.Workspace{}, err
+	}
+	if err := change(&workspace); err != nil {
+		return folders.Workspace{}, err
+	}
+	if err := manager.Folders.SaveWorkspace(ctx, workspace); err != nil {
+		return folders.Workspace{}, err
+	}
+	return workspace, nil
+}
+
+// CreateFolder adds a folder to the workspace of owner under a new ID
+func (manager *WebSocketManager) CreateFolder(ctx context.Context, owner string, folder folders.Folder) (folders.Folder, error) {
+	now := time.Now().UTC()
+	folder.ID = ids.RandomHex(8)
+	folder.CreatedAt = now
+	folder.UpdatedAt = now
+	_, err := manager.updateWorkspace(ctx, owner, func(workspace *folders.Workspace) error {
+		return workspace.Add(folder)
+	})
+	if err != nil {
+		return folders.Folder{}, err
+	}
+	return folder, nil
+}
+
+// FiledElsewhere is a document filed in FolderID that another node owns,
+// so only Owner can apply what the folder passes down to it
+type FiledElsewhere struct {
+	DocID    string
+	FolderID string
+	Owner    ownership.Node
+}
+
+// UpdateFolder renames, moves or sets the permissions of a folder of
+// owner. Moving a folder or changing its permissions applies what it now
+// passes down to the documents beneath it that this node owns, and
+// returns the rest, to be refiled on their owners.
+func (manager *WebSocketManager) UpdateFolder(ctx context.Context, owner string, id string, update FolderUpdate) (folders.Folder, []FiledElsewhere, error) {
+	now := time.Now().UTC()
+	workspace, err := manager.updateWorkspace(ctx, owner, func(workspace *folders.Workspace) error {
+		if update.Name != nil {
+			if err := workspace.Rename(id, *update.Name, now); err != nil {
+				return err
+			}
+		}
+		if update.ParentID != nil {
+			if err := workspace.Move(id, *update.ParentID, now); err != nil {
+				return err
+			}
+		}
+		if update.Permissions != nil {
+			if err := workspace.SetPermissions(id, *update.Permissions, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return folders.Folder{}, nil, err
+	}
+	var elsewhere []FiledElsewhere
+	if update.ParentID != nil || update.Permissions != nil {
+		for docID, folderID := range workspace.DocumentsBeneath(id) {
+			node, local, err := manager.OwnerOf(ctx, docID)
+			switch {
+			case err != nil:
+				manager.Logger.Warn("Could not look up document owner to apply folder permissions", "doc_id", docID, "error", err)
+			case local:
+				manager.applyFolderPermissions(owner, docID, workspace.Effective(folderID))
+			default:
+				elsewhere = append(elsewhere, FiledElsewhere{DocID: docID, FolderID: folderID, Owner: node})
+			}
+		}
+	}
+	folder, err := workspace.Folder(id)
+	if err != nil {
+		return folders.Folder{}, nil, err
+	}
+	return *folder, elsewhere, nil
+}
+
+// DeleteFolder removes an empty folder of owner
+func (manager *WebSocketManager) DeleteFolder(ctx context.Context, owner string, id string) error {
+	_, err := manager.updateWorkspace(ctx, owner, func(workspace *folders.Workspace) error {
+		return workspace.Remove(id)
+	})
+	return err
+}
+
+// FileDocument moves a document owned by owner into the folder folderID,
+// or to the root, and gives it the permissions the folder passes down
+func (manager *WebSocketManager) FileDocument(ctx context.Context, owner string, docID string, folderID string) error {
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return err
+	}
+	if doc.Permissions().Owner != owner {
+		return document.ErrNotOwner
+	}
+	workspace, err := manager.updateWorkspace(ctx, owner, func(workspace *folders.Workspace) error {
+		return workspace.File(docID, folderID)
+	})
+	if err != nil {
+		return err
+	}
+	manager.applyFolderPermissions(owner, docID, workspace.Effective(folderID))
+	return nil
+}
+
+// WorkspaceTree nests the folders and documents of owner, with the
+// documents' current titles and how much of each owner hasn't seen
+func (manager *WebSocketManager) WorkspaceTree(ctx context.Context, owner string) (folders.Tree, error) {
+	workspace, err := manager.Folders.LoadWorkspace(ctx, owner)
+	if err != nil {
+		return folders.Tree{}, err
+	}
+	return workspace.Tree(func(docID string) (folders.TreeDocument, bool) {
+		doc, err := manager.Documents.Lookup(docID)
+		if err != nil {
+			if !errors.Is(err, document.ErrNotFound) {
+				manager.Logger.Warn("Could not load document for the workspace tree", "doc_id", docID, "error", err)
+			}
+			return folders.TreeDocument{}, false
+		}
+		if _, trashed := doc.TrashedAt(); trashed {
+			return folders.TreeDocument{}, false
+		}
+		return folders.TreeDocument{ID: docID, Title: doc.Metadata().Title, Revision: doc.Revision(), Unread: doc.Unread(owner)}, true
+	}), nil
+}
+
+// applyFolderPermissions sets the permissions a folder passes down on a
+// document of owner, telling the room and auditing what changed. The
+// document may still be changed on its own afterwards.
+func (manager *WebSocketManager) applyFolderPermissions(owner string, docID string, permissions folders.Permissions) {
+	if permissions == (folders.Permissions{}) {
+		return
+	}
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		if !errors.Is(err, document.ErrNotFound) {
+			manager.Logger.Warn("Could not load document to apply folder permissions", "doc_id", docID, "error", err)
+		}
+		return
+	}
+
+	current := doc.Permissions()
+	changed := false
+	if permissions.Export != "" && permissions.Export != current.Export {
+		if err := doc.SetExportPolicy(owner, permissions.Export); err != nil {
+			manager.Logger.Warn("Could not apply folder export policy", "doc_id", docID, "error", err)
+		} else {
+			manager.auditPermissions(docID, owner, "exportPolicy", permissions.Export)
+			changed = true
+		}
+	}
+	if permissions.Mode != "" && permissions.Mode != current.Mode {
+		if err := doc.SetMode(owner, permissions.Mode); err != nil {
+			manager.Logger.Warn("Could not apply folder edit mode", "doc_id", docID, "error", err)
+		} else {
+			manager.auditPermissions(docID, owner, "mode", permissions.Mode)
+			changed = true
+		}
+	}
+	if changed {
+		manager.sendRoomCapabilities(docID)
+	}
+}
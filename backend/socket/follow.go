@@ -0,0 +1,194 @@
+package socket
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// followRequestMessage is the inbound shape of follow-request: the user to
+// follow in the room, or nobody to stop following
+type followRequestMessage struct {
+	Data struct {
+		UserID string `json:"userId"`
+	} `json:"data"`
+}
+
+// ViewportData is where in the document a client is looking: Scroll is how
+// far down it is scrolled, from 0 to 1, and Index the text position at the
+// top of its view when it knows it. Followed clients send it as viewport;
+// their followers get it relayed with the user it came from.
+type ViewportData struct {
+	UserID string  `json:"userId,omitempty"`
+	Scroll float64 `json:"scroll"`
+	Index  *int    `json:"index,omitempty"`
+}
+
+type viewportMessage struct {
+	Data ViewportData `json:"data"`
+}
+
+// FollowersData is the payload of followers, telling a user's tabs how
+// many clients follow them so they know to send their viewport
+type FollowersData struct {
+	Followers int `json:"followers"`
+}
+
+// FollowEndedData is the payload of follow-ended, sent to followers when
+// the user they follow leaves the room
+type FollowEndedData struct {
+	UserID string `json:"userId"`
+}
+
+// followTracker records the user each following connection follows in its
+// room. A follow ends with the connection.
+type followTracker struct {
+	mutex     sync.Mutex
+	following map[*Client]string
+	// viewports holds the last viewport of each followed user per room,
+	// so new followers can jump there straight away
+	viewports map[string]ViewportData
+}
+
+func newFollowTracker() *followTracker {
+	return &followTracker{following: make(map[*Client]string), viewports: make(map[string]ViewportData)}
+}
+
+func viewportKey(docID string, userID string) string {
+	return docID + "/" + userID
+}
+
+// handleFollowRequest starts following a user in the client's room, or
+// stops following when no user is given
+func (manager *WebSocketManager) handleFollowRequest(client *Client, message []byte) {
+	var request followRequestMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "follow-request requires a data.userId string")
+		return
+	}
+	target := request.Data.UserID
+	if target == client.ID {
+		manager.sendError(client, ErrCodeInvalidMessage, "you can't follow yourself")
+		return
+	}
+	if target != "" && !manager.followable(client.DocID, target) {
+		manager.sendError(client, ErrCodeInvalidMessage, "that user is not in the room")
+		return
+	}
+
+	tracker := manager.follows
+	tracker.mutex.Lock()
+	previous := tracker.following[client]
+	if target == "" {
+		delete(tracker.following, client)
+	} else {
+		tracker.following[client] = target
+	}
+	viewport, seen := tracker.viewports[viewportKey(client.DocID, target)]
+	tracker.mutex.Unlock()
+
+	if previous != "" && previous != target {
+		manager.sendFollowers(client.DocID, previous)
+	}
+	if target == "" {
+		return
+	}
+	client.Logger.Debug("Following user", "followed", target)
+	manager.sendFollowers(client.DocID, target)
+	if seen {
+		manager.sendMessage(client, Message{Type: "viewport", Data: viewport})
+	}
+}
+
+// followable reports whether userID has an announced connection in docID
+func (manager *WebSocketManager) followable(docID string, userID string) bool {
+	for _, member := range manager.roomMembers(docID) {
+		if member.ID == userID && !member.Spectator && member.ImpersonatedBy == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleViewport relays a client's viewport to the clients following its
+// user, and to no one else
+func (manager *WebSocketManager) handleViewport(client *Client, message []byte) {
+	var update viewportMessage
+	if err := json.Unmarshal(message, &update); err != nil || update.Data.Scroll < 0 || update.Data.Scroll > 1 {
+		manager.sendError(client, ErrCodeInvalidMessage, "viewport requires a data.scroll number from 0 to 1")
+		return
+	}
+	viewport := update.Data
+	viewport.UserID = client.ID
+
+	followers := manager.followers(client.DocID, client.ID)
+	if len(followers) == 0 {
+		return
+	}
+	manager.follows.mutex.Lock()
+	manager.follows.viewports[viewportKey(client.DocID, client.ID)] = viewport
+	manager.follows.mutex.Unlock()
+
+	for _, follower := range followers {
+		manager.sendMessage(follower, Message{Type: "viewport", Data: viewport})
+	}
+}
+
+// followers returns the clients in docID following userID
+func (manager *WebSocketManager) followers(docID string, userID string) []*Client {
+	tracker := manager.follows
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	var followers []*Client
+	for follower, followed := range tracker.following {
+		if followed == userID && follower.DocID == docID {
+			followers = append(followers, follower)
+		}
+	}
+	return followers
+}
+
+// sendFollowers tells the tabs of userID in docID how many follow them
+func (manager *WebSocketManager) sendFollowers(docID string, userID string) {
+	count := len(manager.followers(docID, userID))
+	for _, member := range manager.roomMembers(docID) {
+		if member.ID == userID && !member.Spectator && member.ImpersonatedBy == "" {
+			manager.sendMessage(member, Message{Type: "followers", Data: FollowersData{Followers: count}})
+		}
+	}
+}
+
+// sendFollowerCount tells a client joining a room whose user is already
+// followed there how many follow it
+func (manager *WebSocketManager) sendFollowerCount(client *Client) {
+	if count := len(manager.followers(client.DocID, client.ID)); count > 0 && !client.Spectator {
+		manager.sendMessage(client, Message{Type: "followers", Data: FollowersData{Followers: count}})
+	}
+}
+
+// forgetFollows drops a removed client's follow and, once the last tab of
+// its user has left the room, ends the follows of that user
+func (manager *WebSocketManager) forgetFollows(client *Client) {
+	tracker := manager.follows
+	tracker.mutex.Lock()
+	followed, following := tracker.following[client]
+	delete(tracker.following, client)
+	tracker.mutex.Unlock()
+	if following {
+		manager.sendFollowers(client.DocID, followed)
+	}
+
+	if client.Spectator || manager.userTabs(client) > 0 {
+		return
+	}
+	followers := manager.followers(client.DocID, client.ID)
+	tracker.mutex.Lock()
+	for _, follower := range followers {
+		delete(tracker.following, follower)
+	}
+	delete(tracker.viewports, viewportKey(client.DocID, client.ID))
+	tracker.mutex.Unlock()
+	for _, follower := range followers {
+		manager.sendMessage(follower, Message{Type: "follow-ended", Data: FollowEndedData{UserID: client.ID}})
+	}
+}
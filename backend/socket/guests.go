@@ -0,0 +1,169 @@
+package socket
+
+import (
+	"fmt"
+	"hash/fnv"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"backend/ids"
+)
+
+// Guest is what generators know of a guest session being started
+type Guest struct {
+	UserID string
+
+	// Locales are the languages the client prefers, most preferred first,
+	// as lowercase tags such as "de" or "pt-br"
+	Locales []string
+}
+
+// NameGenerator names guest sessions. It is called with the session store
+// locked and must not block; names that aren't valid user names are
+// replaced with one of DefaultNames.
+type NameGenerator interface {
+	GuestName(guest Guest) string
+}
+
+// ColorGenerator picks the color of guest sessions and new accounts under
+// the same rules as NameGenerator. Colors must be hsl(h, s%, l%) or
+// #rrggbb.
+type ColorGenerator interface {
+	GuestColor(guest Guest) string
+}
+
+// DefaultNames are the names guests get unless a deployment supplies its
+// own generator
+var DefaultNames = NamePool{"🦊 Fox", "🐼 Panda", "🐧 Penguin", "🦁 Lion", "🐸 Frog"}
+
+// NamePool names guests at random from a list, which must not be empty
+type NamePool []string
+
+func (pool NamePool) GuestName(Guest) string {
+	return pool[mathrand.Intn(len(pool))]
+}
+
+// LocaleNames names guests with the generator for the first of their
+// locales it has one for, trying "pt" for "pt-br" too, and with Default
+// when it has none
+type LocaleNames struct {
+	Locales map[string]NameGenerator
+	Default NameGenerator
+}
+
+func (names LocaleNames) GuestName(guest Guest) string {
+	for _, locale := range guest.Locales {
+		if generator, ok := names.Locales[locale]; ok {
+			return generator.GuestName(guest)
+		}
+		language, _, _ := strings.Cut(locale, "-")
+		if generator, ok := names.Locales[language]; ok {
+			return generator.GuestName(guest)
+		}
+	}
+	return names.Default.GuestName(guest)
+}
+
+// RandomColors gives each guest a random hue
+type RandomColors struct{}
+
+func (RandomColors) GuestColor(Guest) string {
+	return fmt.Sprintf("hsl(%d, 70%%, 60%%)", mathrand.Intn(360))
+}
+
+// HashedColors derives the hue from the user ID, so the same identity is
+// always shown in the same color
+type HashedColors struct{}
+
+func (HashedColors) GuestColor(guest Guest) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(guest.UserID))
+	return fmt.Sprintf("hsl(%d, 70%%, 60%%)", hash.Sum32()%360)
+}
+
+// guestProfile names and colors a new identity with the manager's
+// generators, falling back to the defaults for anything they get wrong
+func (manager *WebSocketManager) guestProfile(userID string, locales []string) (name string, color string) {
+	guest := Guest{UserID: userID, Locales: locales}
+	name, ok := normalizeUserName(manager.Names.GuestName(guest))
+	if !ok {
+		name = DefaultNames.GuestName(guest)
+	}
+	color = manager.Colors.GuestColor(guest)
+	if !userColor.MatchString(color) {
+		color = RandomColors{}.GuestColor(guest)
+	}
+	return name, color
+}
+
+// resumeSession resumes the session token names, or starts a guest
+// session as guestID in tenant, named for locales unless the guest has
+// another
+func (manager *WebSocketManager) resumeSession(token string, guestID string, tenant string, locales []string) (Session, bool) {
+	return manager.Sessions.Resume(token, guestID, tenant, func(userID string) (string, string) {
+		return manager.guestProfile(userID, locales)
+	})
+}
+
+// Cookie that gives every tab of a browser the same guest identity. It
+// holds a hex secret the guest's user ID is derived from, since user IDs
+// are public.
+const (
+	guestCookie       = "guest"
+	guestCookieMaxAge = 365 * 24 * 60 * 60
+	guestSecretLength = 64
+)
+
+// guestIdentity returns the user ID of the browser's guest identity, and
+// the cookie to give it when it has none yet
+func (manager *WebSocketManager) guestIdentity(r *http.Request) (userID string, cookie *http.Cookie) {
+	if existing, err := r.Cookie(guestCookie); err == nil && len(existing.Value) == guestSecretLength {
+		return ids.DerivedUUID(existing.Value), nil
+	}
+	secret := ids.RandomHex(guestSecretLength / 2)
+	return ids.DerivedUUID(secret), &http.Cookie{
+		Name:     guestCookie,
+		Value:    secret,
+		Path:     "/",
+		MaxAge:   guestCookieMaxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// requestLocales reads the languages a request's Accept-Language prefers,
+// most preferred first
+func requestLocales(r *http.Request) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var preferred []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed <= 0 {
+				continue
+			}
+			quality = parsed
+		}
+		preferred = append(preferred, weighted{tag: tag, quality: quality})
+	}
+	sort.SliceStable(preferred, func(i, j int) bool { return preferred[i].quality > preferred[j].quality })
+
+	locales := make([]string, len(preferred))
+	for i, locale := range preferred {
+		locales[i] = locale.tag
+	}
+	return locales
+}
@@ -0,0 +1,35 @@
+package socket
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDraining is a readiness check made while the server shuts down
+var ErrDraining = errors.New("server is shutting down")
+
+// Ping waits for the Run loop to answer, which it does between
+// registrations, unregistrations and broadcasts, so a loop that is stuck
+// or never started fails it once ctx ends
+func (manager *WebSocketManager) Ping(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case manager.pings <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Accepting fails once Shutdown has started turning connections away
+func (manager *WebSocketManager) Accepting(context.Context) error {
+	if manager.draining.Load() {
+		return ErrDraining
+	}
+	return nil
+}
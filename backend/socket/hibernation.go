@@ -0,0 +1,113 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"backend/chat"
+	"backend/comments"
+	"backend/document"
+	"backend/metrics"
+)
+
+// hibernatedRoom is what a hibernating document's record keeps of the room
+type hibernatedRoom struct {
+	Chat     []chat.Message     `json:"chat,omitempty"`
+	Comments []comments.Comment `json:"comments,omitempty"`
+}
+
+// dormantRooms tracks when each document was unloaded, the clock
+// hibernation runs against
+type dormantRooms struct {
+	mutex sync.Mutex
+	since map[string]time.Time
+}
+
+func (dormant *dormantRooms) add(docID string) {
+	dormant.mutex.Lock()
+	defer dormant.mutex.Unlock()
+	dormant.since[docID] = time.Now()
+}
+
+func (dormant *dormantRooms) remove(docID string) {
+	dormant.mutex.Lock()
+	defer dormant.mutex.Unlock()
+	delete(dormant.since, docID)
+}
+
+// idle returns the documents unloaded for longer than after
+func (dormant *dormantRooms) idle(after time.Duration) []string {
+	dormant.mutex.Lock()
+	defer dormant.mutex.Unlock()
+
+	var docIDs []string
+	for docID, since := range dormant.since {
+		if time.Since(since) >= after {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	return docIDs
+}
+
+// hibernateIdleRooms periodically hibernates the documents that have
+// stayed unloaded for the configured time
+func (manager *WebSocketManager) hibernateIdleRooms() {
+	after := manager.Config.HibernateAfter
+	ticker := time.NewTicker(min(max(after/4, time.Minute), time.Hour))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, docID := range manager.dormant.idle(after) {
+			manager.hibernate(docID)
+		}
+	}
+}
+
+// hibernate flushes a document's chat and comments into its saved record
+// and releases them along with its duplicate index entry. A document that
+// was opened again since it was unloaded is left alone until it next
+// unloads.
+func (manager *WebSocketManager) hibernate(docID string) {
+	flush := func() (json.RawMessage, error) {
+		room := hibernatedRoom{
+			Chat:     manager.Chat.Recent(docID),
+			Comments: manager.Comments.List(docID),
+		}
+		if len(room.Chat) == 0 && len(room.Comments) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(room)
+	}
+	release := func() {
+		manager.Chat.Restore(docID, nil)
+		manager.Comments.Restore(docID, nil)
+		manager.Similarity.Remove(docID)
+	}
+
+	err := manager.Documents.Hibernate(docID, flush, release)
+	switch {
+	case err == nil:
+		metrics.Hibernations.WithLabelValues("hibernated").Inc()
+		manager.Logger.Debug("Hibernated idle document", "doc_id", docID)
+	case errors.Is(err, document.ErrInUse), errors.Is(err, document.ErrNotFound):
+	default:
+		manager.Logger.Warn("Could not hibernate document", "doc_id", docID, "error", err)
+		return
+	}
+	manager.dormant.remove(docID)
+}
+
+// wake restores the room state of a hibernated document as it loads
+func (manager *WebSocketManager) wake(docID string, state json.RawMessage) {
+	var room hibernatedRoom
+	if err := json.Unmarshal(state, &room); err != nil {
+		manager.Logger.Error("Could not restore hibernated room", "doc_id", docID, "error", err)
+		return
+	}
+	manager.Chat.Restore(docID, room.Chat)
+	manager.Comments.Restore(docID, room.Comments)
+	metrics.Hibernations.WithLabelValues("woken").Inc()
+	manager.Logger.Debug("Woke hibernated document", "doc_id", docID)
+}
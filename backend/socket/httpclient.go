@@ -0,0 +1,188 @@
+package socket
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"backend/document"
+)
+
+// Transports a client can be connected over
+const (
+	TransportWebSocket   = "websocket"
+	TransportEventStream = "event-stream"
+	TransportLongPoll    = "long-poll"
+	TransportBot         = "bot"
+)
+
+// Upstream messages queued per HTTP client before posting more is refused
+const httpInboxSize = 64
+
+var (
+	// ErrUnknownConnection is a posted message or poll for a connection
+	// that isn't an HTTP transport on this node, or that belongs to
+	// another session
+	ErrUnknownConnection = errors.New("no such connection")
+	// ErrInboxFull is a posted message the client's read pump is too far
+	// behind to take
+	ErrInboxFull = errors.New("too many messages pending for this connection")
+)
+
+// httpConn is the connection of a client on one of the HTTP fallbacks for
+// networks that block WebSockets, or of a room a bot subscribed to.
+// Messages posted by the client wait in inbox for its read pump, and
+// closed ends the connection.
+type httpConn struct {
+	transport string
+	inbox     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// Long polling only: each poll signals polled, and one poll at a time
+	// holds polling
+	polled  chan struct{}
+	polling sync.Mutex
+}
+
+func (conn *httpConn) close() {
+	conn.closeOnce.Do(func() { close(conn.closed) })
+}
+
+// Transport names what the client is connected over
+func (client *Client) Transport() string {
+	if client.httpConn != nil {
+		return client.httpConn.transport
+	}
+	return TransportWebSocket
+}
+
+// newHTTPClient sets up a client for an HTTP transport, resuming the
+// session in the session query parameter if it is still valid and checking
+// any share parameter, as HandleWebSocketConnections does for WebSockets.
+// A browser without a guest cookie is given one on w.
+func (manager *WebSocketManager) newHTTPClient(transport string, docID string, w http.ResponseWriter, r *http.Request) (*Client, error) {
+	query := r.URL.Query()
+	sessionToken := query.Get("session")
+	if docID == "" {
+		docID = DefaultDocID
+	}
+	if url, moved := manager.migrations.lookup(docID); moved {
+		return nil, &RoomMovedError{URL: url}
+	}
+	if _, live := manager.Sessions.Lookup(sessionToken); sessionToken != "" && !live {
+		return nil, &CloseError{Reason: CloseAuthExpired, Details: "session has expired"}
+	}
+	hostTenant, err := manager.HostTenant(r.Host)
+	if err != nil {
+		return nil, &CloseError{Reason: CloseWrongTenant, Details: err.Error()}
+	}
+	guestID, cookie := manager.guestIdentity(r)
+	session, resumed := manager.resumeSession(sessionToken, guestID, hostTenant, requestLocales(r))
+	if session.Tenant != hostTenant {
+		return nil, &CloseError{Reason: CloseWrongTenant, Details: "the session belongs to another tenant"}
+	}
+	remoteIP := manager.Proxies.ClientIP(r)
+	viewOnly, refused := manager.verifyShare(query.Get("share"), docID)
+	if refused == nil {
+		refused = manager.admit(session.UserID, remoteIP, docID)
+	}
+	var doc *document.Document
+	if refused == nil {
+		doc, refused = manager.admitDocument(session.UserID, session.Tenant, docID)
+	}
+	if refused != nil {
+		return nil, refused
+	}
+	if !viewOnly {
+		doc.Join(session.UserID)
+	}
+	if cookie != nil {
+		http.SetCookie(w, cookie)
+	}
+
+	client := &Client{
+		httpConn: &httpConn{
+			transport: transport,
+			inbox:     make(chan []byte, httpInboxSize),
+			closed:    make(chan struct{}),
+			polled:    make(chan struct{}, 1),
+		},
+		Send:   NewSendQueue(manager.Config.Limits.SendBufferSize),
+		ID:     session.UserID,
+		ConnID: NewConnID(),
+		DocID:  docID,
+		Doc:    doc,
+		Data:   map[string]map[string]string{"userData": session.UserData()},
+
+		SessionID:   session.ID,
+		Tenant:      session.Tenant,
+		RemoteIP:    remoteIP,
+		ViewOnly:    viewOnly,
+		Spectator:   manager.joinsAsSpectator(docID, viewOnly),
+		Encoding:    EncodingJSON,
+		ConnectedAt: time.Now(),
+
+		limiter: newRateLimiter(manager.Config.Limits),
+		chunks:  make(map[string]*chunkBuffer),
+	}
+	manager.bindContext(client)
+	client.Logger = manager.Logger.With(
+		"conn_id", client.ConnID,
+		"doc_id", client.DocID,
+		"user_id", client.ID,
+		"remote_ip", client.RemoteIP,
+	)
+	client.Logger.Debug("Session established", "resumed", resumed, "transport", transport)
+	return client, nil
+}
+
+// readInbox is the read pump of an HTTP client
+func (manager *WebSocketManager) readInbox(client *Client) {
+	defer func() {
+		manager.Unregister <- client
+	}()
+
+	for {
+		select {
+		case message := <-client.httpConn.inbox:
+			manager.receive(client, message)
+		case <-client.httpConn.closed:
+			return
+		case <-client.ctx.Done():
+			return
+		}
+	}
+}
+
+// httpClient finds the HTTP client with connID in docID, opened with
+// sessionID
+func (manager *WebSocketManager) httpClient(docID string, connID string, sessionID string) (*Client, error) {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	for client := range manager.Rooms[docID] {
+		if client.ConnID == connID && client.httpConn != nil && client.SessionID == sessionID {
+			return client, nil
+		}
+	}
+	return nil, ErrUnknownConnection
+}
+
+// PostMessage hands a message sent by an HTTP client to its read pump.
+// sessionID must be the session the connection was opened with.
+func (manager *WebSocketManager) PostMessage(docID string, connID string, sessionID string, message []byte) error {
+	client, err := manager.httpClient(docID, connID, sessionID)
+	if err != nil {
+		return err
+	}
+	select {
+	case client.httpConn.inbox <- message:
+		return nil
+	case <-client.httpConn.closed:
+		return ErrUnknownConnection
+	default:
+		return ErrInboxFull
+	}
+}
@@ -0,0 +1,97 @@
+package socket
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The idle policy under which only messages count as activity; under the
+// default, "any", pongs to the server's pings do too
+const idlePolicyMessages = "messages"
+
+// idleWarning tells a client it will be closed as idle unless it shows
+// some activity within ClosesIn
+type idleWarning struct {
+	ClosesIn string `json:"closesIn"`
+}
+
+// touch records activity from the client
+func (client *Client) touch() {
+	client.lastActive.Store(time.Now().UnixNano())
+}
+
+// lastSeen is when the client last showed activity, or connected
+func (client *Client) lastSeen() time.Time {
+	if nanos := client.lastActive.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return client.ConnectedAt
+}
+
+// watchPongs counts pongs from a WebSocket client as activity unless only
+// messages do. It must be called from the read pump, which runs the
+// handler.
+func (manager *WebSocketManager) watchPongs(client *Client) {
+	if manager.Config.Limits.IdlePolicy == idlePolicyMessages {
+		return
+	}
+	client.Conn.SetPongHandler(func(string) error {
+		client.touch()
+		return nil
+	})
+}
+
+// closeIdleClients pings clients that have gone quiet, so that live
+// connections answer with a pong, warns those that stay idle and
+// disconnects them once they reach the idle timeout
+func (manager *WebSocketManager) closeIdleClients() {
+	timeout := manager.Config.Limits.IdleTimeout
+	warning := min(manager.Config.Limits.IdleWarning, timeout/2)
+	ping := manager.Config.Limits.IdlePolicy != idlePolicyMessages
+	step := timeout / 4
+	if warning > 0 {
+		step = min(step, warning/2)
+	}
+	ticker := time.NewTicker(max(min(step, time.Minute), time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		var quiet, warn, idle []*Client
+		manager.Mutex.RLock()
+		for client := range manager.Clients {
+			if client.Bot {
+				// Bots may only listen; their rooms end with their connection
+				continue
+			}
+			lastSeen := client.lastSeen()
+			switch since := now.Sub(lastSeen); {
+			case since >= timeout:
+				idle = append(idle, client)
+			case warning > 0 && since >= timeout-warning && client.idleWarned.Load() < lastSeen.UnixNano():
+				warn = append(warn, client)
+			case ping && since >= step && client.Conn != nil:
+				quiet = append(quiet, client)
+			}
+		}
+		manager.Mutex.RUnlock()
+
+		deadline := now.Add(manager.Config.Limits.WriteTimeout)
+		for _, client := range quiet {
+			client.Conn.WriteControl(websocket.PingMessage, nil, deadline)
+		}
+		for _, client := range warn {
+			client.idleWarned.Store(now.UnixNano())
+			closesIn := client.lastSeen().Add(timeout).Sub(now).Round(time.Second)
+			manager.sendMessage(client, Message{Type: "idle-warning", Data: idleWarning{ClosesIn: closesIn.String()}})
+			if ping && client.Conn != nil {
+				client.Conn.WriteControl(websocket.PingMessage, nil, deadline)
+			}
+		}
+		for _, client := range idle {
+			client.Logger.Info("Closing idle connection", "idle_timeout", timeout)
+			manager.disconnect(client, CloseIdleTimeout, "no activity for "+timeout.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ErrCodeReadOnly answers messages sent over an impersonated view, or that
+// a client joined through a view link may not send
+const ErrCodeReadOnly = "read-only"
+
+var ErrUnknownUser = errors.New("user has no live session")
+
+// Actions reported in impersonation banner events
+const (
+	ImpersonationDocumentsViewed = "documents-viewed"
+	ImpersonationRoomJoined      = "room-joined"
+	ImpersonationRoomLeft        = "room-left"
+)
+
+// ImpersonationData is the payload of the impersonation banner event sent
+// to every connection of the impersonated user
+type ImpersonationData struct {
+	Operator string `json:"operator"`
+	Action   string `json:"action"`
+	DocID    string `json:"docId,omitempty"`
+}
+
+// UserDocuments lists the documents a user has opened in any live session
+// or is connected to now, and tells the user an operator looked
+func (manager *WebSocketManager) UserDocuments(userID string, operator string) ([]string, error) {
+	sessions := manager.Sessions.ForUser(userID)
+	if len(sessions) == 0 {
+		return nil, ErrUnknownUser
+	}
+
+	seen := make(map[string]bool)
+	for _, session := range sessions {
+		for docID := range session.Acks {
+			seen[docID] = true
+		}
+	}
+	manager.Mutex.RLock()
+	for client := range manager.Clients {
+		if client.ID == userID && client.ImpersonatedBy == "" {
+			seen[client.DocID] = true
+		}
+	}
+	manager.Mutex.RUnlock()
+
+	docIDs := make([]string, 0, len(seen))
+	for docID := range seen {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Strings(docIDs)
+
+	manager.Logger.Info("Impersonation: listed user documents", "operator", operator, "user_id", userID)
+	manager.notifyImpersonated(userID, ImpersonationData{Operator: operator, Action: ImpersonationDocumentsViewed})
+	return docIDs, nil
+}
+
+// HandleImpersonation opens a read-only view of a room as the given user,
+// with the identity of the user's most recent session. The view receives
+// everything the room does but appears in no roster, and the user's own
+// connections are told when it opens and closes.
+func (manager *WebSocketManager) HandleImpersonation(w http.ResponseWriter, r *http.Request, userID string, operator string) {
+	sessions := manager.Sessions.ForUser(userID)
+	if len(sessions) == 0 {
+		http.Error(w, ErrUnknownUser.Error(), http.StatusNotFound)
+		return
+	}
+	session := sessions[0]
+
+	encoding, err := negotiateEncoding(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, wire, compressed, err := manager.upgrade(w, r, nil)
+	if err != nil {
+		manager.Logger.Warn("WebSocket upgrade error", "remote_ip", manager.Proxies.ClientIP(r), "error", err)
+		return
+	}
+	conn.SetReadLimit(manager.Config.Limits.MaxMessageSize)
+
+	docID := r.URL.Query().Get("doc")
+	if docID == "" {
+		docID = DefaultDocID
+	}
+	doc, ok := manager.acquireDocument(conn, encoding, docID)
+	if !ok {
+		return
+	}
+
+	client := &Client{
+		Conn:   conn,
+		Send:   NewSendQueue(manager.Config.Limits.SendBufferSize),
+		ID:     session.UserID,
+		ConnID: NewConnID(),
+		DocID:  docID,
+		Doc:    doc,
+		Data:   map[string]map[string]string{"userData": session.UserData()},
+
+		RemoteIP:       manager.Proxies.ClientIP(r),
+		ImpersonatedBy: operator,
+		Encoding:       encoding,
+		ConnectedAt:    time.Now(),
+
+		limiter: newRateLimiter(manager.Config.Limits),
+		chunks:  make(map[string]*chunkBuffer),
+
+		compressed: compressed,
+		wire:       wire,
+	}
+	manager.bindContext(client)
+	client.Logger = manager.Logger.With(
+		"conn_id", client.ConnID,
+		"doc_id", client.DocID,
+		"user_id", client.ID,
+		"remote_ip", client.RemoteIP,
+		"impersonated_by", operator,
+	)
+	client.Logger.Info("Impersonation: opened room view")
+
+	manager.Register <- client
+	manager.writers.Add(1)
+	go manager.HandleClientRead(client)
+	go manager.HandleClientWrite(client)
+}
+
+// sendImpersonationState is HandleUserData for impersonated views: the
+// operator gets the room as the user sees it, without being announced
+func (manager *WebSocketManager) sendImpersonationState(client *Client) {
+	manager.sendMessage(client, Message{
+		Type: "user-data",
+		Data: map[string]map[string]string{"userData": manager.clientData(client)["userData"]},
+	})
+	manager.sendMessage(client, manager.roster(client.DocID))
+	manager.sendDocumentState(client)
+	manager.sendChatHistory(client)
+
+	manager.notifyImpersonated(client.ID, ImpersonationData{
+		Operator: client.ImpersonatedBy,
+		Action:   ImpersonationRoomJoined,
+		DocID:    client.DocID,
+	})
+}
+
+func (manager *WebSocketManager) endImpersonation(client *Client) {
+	client.Logger.Info("Impersonation: closed room view")
+	manager.notifyImpersonated(client.ID, ImpersonationData{
+		Operator: client.ImpersonatedBy,
+		Action:   ImpersonationRoomLeft,
+		DocID:    client.DocID,
+	})
+}
+
+// notifyImpersonated sends the banner event to the user's own connections
+func (manager *WebSocketManager) notifyImpersonated(userID string, data ImpersonationData) {
+	jsonData, err := json.Marshal(Message{Type: "impersonation", Data: data})
+	if err != nil {
+		manager.Logger.Error("Error marshalling impersonation message", "error", err)
+		return
+	}
+
+	manager.Mutex.RLock()
+	var targets []*Client
+	for client := range manager.Clients {
+		if client.ID == userID && client.ImpersonatedBy == "" {
+			targets = append(targets, client)
+		}
+	}
+	manager.Mutex.RUnlock()
+
+	for _, client := range targets {
+		if err := manager.sendToClient(client, jsonData); err != nil {
+			client.Logger.Warn("Could not send impersonation notice", "error", err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"unicode/utf8"
+
+	"backend/document"
+	"backend/folders"
+	"backend/importer"
+	"backend/sanitize"
+)
+
+// Kinds of items an import reports on
+const (
+	ImportFolder   = "folder"
+	ImportDocument = "document"
+	ImportImage    = "image"
+	ImportSkipped  = "skipped"
+)
+
+// ImportItem is what became of a file or directory of an imported
+// archive: ID is the folder, document or attachment it was created as, and
+// Error says why it wasn't
+type ImportItem struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ImportResult reports on an import item by item, with how many were
+// created and how many failed
+type ImportResult struct {
+	Source  string       `json:"source"`
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Items   []ImportItem `json:"items"`
+}
+
+// add records item and counts it
+func (result *ImportResult) add(item ImportItem) {
+	switch {
+	case item.Error != "":
+		result.Failed++
+	case item.Kind != ImportSkipped:
+		result.Created++
+	}
+	result.Items = append(result.Items, item)
+}
+
+// ImportWorkspace recreates the folders, documents and images of archive
+// in the workspace of user, carrying on past the items that fail. Images
+// become attachments of the documents embedding them, linked from baseURL,
+// the scheme and host the server is reached at. A folder that can't be
+// created has its contents put in the nearest one that could.
+func (manager *WebSocketManager) ImportWorkspace(ctx context.Context, user Session, archive *importer.Archive, baseURL string) ImportResult {
+	result := ImportResult{Source: archive.Source}
+
+	created := map[string]string{"": ""}
+	for _, folder := range archive.Folders {
+		parentID := created[folder.Parent]
+		created[folder.Path] = parentID
+		record, err := manager.CreateFolder(ctx, user.UserID, folders.Folder{
+			Name:     importName(folder.Name, folders.MaxNameLength),
+			ParentID: parentID,
+		})
+		if err != nil {
+			result.add(ImportItem{Path: folder.Path, Kind: ImportFolder, Error: err.Error()})
+			continue
+		}
+		created[folder.Path] = record.ID
+		result.add(ImportItem{Path: folder.Path, Kind: ImportFolder, ID: record.ID})
+	}
+
+	for _, page := range archive.Pages {
+		docID, err := manager.importPage(ctx, user, archive, page, created[page.Folder], baseURL, &result)
+		if err != nil {
+			result.add(ImportItem{Path: page.Path, Kind: ImportDocument, ID: docID, Error: err.Error()})
+			continue
+		}
+		result.add(ImportItem{Path: page.Path, Kind: ImportDocument, ID: docID})
+	}
+
+	for _, name := range archive.Unused() {
+		result.add(ImportItem{Path: name, Kind: ImportSkipped})
+	}
+	return result
+}
+
+// importPage creates a document for page in the folder folderID, uploads
+// the images it embeds and returns its ID, which is set even when filling
+// in the document failed after creating it
+func (manager *WebSocketManager) importPage(ctx context.Context, user Session, archive *importer.Archive, page importer.Page, folderID string, baseURL string, result *ImportResult) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	docID, _, err := manager.CreateDocument(ctx, user, "", folderID)
+	if err != nil {
+		return "", err
+	}
+
+	// Pages embedding an image more than once upload it once
+	uploaded := make(map[string]string)
+	content, err := archive.Convert(page, manager.Config.Limits.MaxChunkedSize, func(name string) (string, error) {
+		if src, ok := uploaded[name]; ok {
+			return src, nil
+		}
+		attachment, err := manager.importImage(ctx, docID, user, archive, name)
+		if err != nil {
+			result.add(ImportItem{Path: name, Kind: ImportImage, Error: err.Error()})
+			return "", err
+		}
+		uploaded[name] = baseURL + attachment.URL
+		result.add(ImportItem{Path: name, Kind: ImportImage, ID: attachment.ID})
+		return uploaded[name], nil
+	})
+	if err != nil {
+		return docID, err
+	}
+	if _, err := manager.ReplaceContent(docID, user, content); err != nil {
+		return docID, err
+	}
+
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return docID, err
+	}
+	title := importName(page.Title, document.MaxTitleLength)
+	if _, err := doc.UpdateMetadata(document.MetadataUpdate{Title: &title}, func(document.Metadata) {}); err != nil {
+		return docID, err
+	}
+	return docID, nil
+}
+
+// importImage stores the image at name in archive as an attachment of the
+// document docID
+func (manager *WebSocketManager) importImage(ctx context.Context, docID string, user Session, archive *importer.Archive, name string) (Attachment, error) {
+	body, err := archive.Open(name)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer body.Close()
+	// The sizes an archive lists may be made up, so the image is read
+	// before it is stored rather than refused halfway through
+	raw, err := io.ReadAll(io.LimitReader(body, manager.Config.Attachments.MaxSize+1))
+	if err != nil {
+		return Attachment{}, err
+	}
+	if int64(len(raw)) > manager.Config.Attachments.MaxSize {
+		return Attachment{}, importer.ErrFileTooLarge
+	}
+	return manager.AddAttachment(ctx, docID, user, path.Base(name), bytes.NewReader(raw))
+}
+
+// importName makes name, from another editor, a single line of at most
+// limit characters
+func importName(name string, limit int) string {
+	name = sanitize.Text(name, false)
+	if utf8.RuneCountInString(name) > limit {
+		name = string([]rune(name)[:limit])
+	}
+	return name
+}
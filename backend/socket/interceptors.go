@@ -0,0 +1,126 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ErrCodeRejected answers a message an interceptor rejected without a code
+// of its own
+const ErrCodeRejected = "message-rejected"
+
+// Interceptor runs on inbound messages before the server handles them. It
+// may rewrite the message, handle it itself or reject it by returning an
+// error, which is sent to the client; the rest of the chain is skipped.
+type Interceptor func(ctx *MessageContext) error
+
+// RejectError is an interceptor's rejection sent with its own error code
+type RejectError struct {
+	Code    string
+	Message string
+}
+
+func (err *RejectError) Error() string {
+	return err.Message
+}
+
+// MessageContext is an inbound message making its way through the
+// interceptors. Message is the whole message as JSON; an interceptor may
+// replace it, and the result is validated again before it is handled.
+// Messages of a type the server doesn't know only get past the chain when
+// an interceptor marks them handled.
+type MessageContext struct {
+	Client  *Client
+	Type    string
+	Message []byte
+
+	handled bool
+	manager *WebSocketManager
+}
+
+// Decode unmarshals the data object of the message into v
+func (ctx *MessageContext) Decode(v any) error {
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(ctx.Message, &envelope); err != nil {
+		return err
+	}
+	if len(envelope.Data) == 0 {
+		return errors.New("message has no data")
+	}
+	return json.Unmarshal(envelope.Data, v)
+}
+
+// Handled stops the message after the interceptors: the server doesn't
+// handle it itself
+func (ctx *MessageContext) Handled() {
+	ctx.handled = true
+}
+
+// Reply sends a message to the client the message came from
+func (ctx *MessageContext) Reply(msgType string, data any) {
+	ctx.manager.sendMessage(ctx.Client, Message{Type: msgType, Data: data})
+}
+
+// Broadcast sends a message to everyone else in the client's room
+func (ctx *MessageContext) Broadcast(msgType string, data any) {
+	jsonData, err := json.Marshal(Message{Type: msgType, Data: data})
+	if err != nil {
+		ctx.Client.Logger.Error("Error marshalling message", "type", msgType, "error", err)
+		return
+	}
+	ctx.manager.BroadcastExcept(ctx.Client, jsonData)
+}
+
+// Use appends interceptor to the chain inbound messages go through, in the
+// order they were added. Like SetStore it must be called before the
+// manager serves any client.
+func (manager *WebSocketManager) Use(interceptor Interceptor) {
+	manager.interceptors = append(manager.interceptors, interceptor)
+}
+
+// interceptable reports whether a message that failed validation with err
+// may still be handled by an interceptor
+func (manager *WebSocketManager) interceptable(err *schemaError) bool {
+	return err == nil || err.Code == ErrCodeUnknownType && len(manager.interceptors) > 0
+}
+
+// dispatch passes a message through the interceptors, then handles it.
+// invalid is what validating the message found, when its type is unknown.
+func (manager *WebSocketManager) dispatch(client *Client, msgType string, message []byte, invalid *schemaError) {
+	if len(manager.interceptors) > 0 {
+		ctx := &MessageContext{Client: client, Type: msgType, Message: message, manager: manager}
+		for _, interceptor := range manager.interceptors {
+			if err := interceptor(ctx); err != nil {
+				manager.reject(client, msgType, err)
+				return
+			}
+			if ctx.handled {
+				return
+			}
+		}
+		if invalid != nil || !bytes.Equal(ctx.Message, message) {
+			message = ctx.Message
+			msgType = messageType(message)
+			invalid = validateMessage(msgType, message)
+		}
+	}
+	if invalid != nil {
+		manager.sendSchemaError(client, invalid)
+		return
+	}
+	manager.handleMessage(client, msgType, message)
+}
+
+// reject tells a client an interceptor turned its message away
+func (manager *WebSocketManager) reject(client *Client, msgType string, err error) {
+	client.Logger.Debug("Interceptor rejected message", "type", msgType, "error", err)
+	var rejected *RejectError
+	if errors.As(err, &rejected) {
+		manager.sendError(client, rejected.Code, rejected.Message)
+		return
+	}
+	manager.sendError(client, ErrCodeRejected, err.Error())
+}
@@ -0,0 +1,83 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/audit"
+	"backend/snapshots"
+)
+
+var (
+	ErrEncryptionDisabled = errors.New("encryption at rest is not enabled")
+	ErrRotationRunning    = errors.New("a key rotation is already running")
+)
+
+// RotateKeys starts sealing every document this node owns and every
+// snapshot again with the active encryption key, in the background, and
+// returns the ID of that key. Each document resealed gets an entry in its
+// audit trail naming the key and principal, who asked for the rotation.
+// Documents other nodes own are left to the rotation run on those nodes.
+func (manager *WebSocketManager) RotateKeys(principal string) (string, error) {
+	if manager.Keys == nil {
+		return "", ErrEncryptionDisabled
+	}
+	if !manager.rotating.CompareAndSwap(false, true) {
+		return "", ErrRotationRunning
+	}
+	go func() {
+		defer manager.rotating.Store(false)
+		manager.rotateKeys(manager.ctx, principal)
+	}()
+	return manager.Keys.Active, nil
+}
+
+// rotateKeys reseals documents one at a time, so rooms carry on while it
+// runs, and then the snapshots if their store can list them. Snapshots
+// it can't reach keep their key, which must stay configured to open them.
+func (manager *WebSocketManager) rotateKeys(ctx context.Context, principal string) {
+	started := time.Now()
+	active := manager.Keys.Active
+	records, err := manager.Documents.Records(ctx)
+	if err != nil {
+		manager.Logger.Error("Could not list documents to reseal", "error", err)
+		return
+	}
+
+	var resealed, elsewhere, failed int
+	for _, record := range records {
+		if ctx.Err() != nil {
+			break
+		}
+		_, local, err := manager.OwnerOf(ctx, record.ID)
+		if err != nil {
+			manager.Logger.Warn("Could not look up document owner", "doc_id", record.ID, "error", err)
+			failed++
+			continue
+		}
+		if !local {
+			elsewhere++
+			continue
+		}
+		if err := manager.Documents.Resave(record.ID); err != nil {
+			manager.Logger.Error("Could not reseal document", "doc_id", record.ID, "error", err)
+			failed++
+			continue
+		}
+		resealed++
+		manager.Audit.Record(audit.Entry{Action: audit.ActionKeyRotated, DocID: record.ID,
+			Details: map[string]string{"key": active, "principal": principal}})
+	}
+
+	snapshotsResealed := 0
+	if resealer, ok := manager.Snapshots.(snapshots.Resealer); ok {
+		if snapshotsResealed, err = resealer.Reseal(ctx); err != nil {
+			manager.Logger.Error("Could not reseal every snapshot", "resealed", snapshotsResealed, "error", err)
+		}
+	} else {
+		manager.Logger.Warn("Snapshot store can't be resealed, keep the retired keys configured to open its snapshots")
+	}
+	manager.Logger.Info("Encryption keys rotated", "active_key", active, "documents", resealed, "elsewhere", elsewhere,
+		"failed", failed, "snapshots", snapshotsResealed, "principal", principal, "took", time.Since(started))
+}
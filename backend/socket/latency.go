@@ -0,0 +1,158 @@
+package socket
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"backend/ids"
+	"backend/metrics"
+)
+
+// A client's published latency only changes once its round trip moves
+// away from it by both latencyPublishStep and latencyPublishRatio of it,
+// so presence isn't re-announced for every jitter
+const (
+	latencyPublishStep  = 20 * time.Millisecond
+	latencyPublishRatio = 0.25
+)
+
+// PingData is the payload of a ping sent to a client. The client answers
+// right away with a latency message echoing ID. ServerTime is in Unix
+// milliseconds.
+type PingData struct {
+	ID         string `json:"id"`
+	ServerTime int64  `json:"serverTime"`
+}
+
+// LatencyData is the payload of the latency message answering a client's
+// answer to a ping: RTT is the round trip just measured and Skew how far
+// ahead of the server's the client's clock runs, behind when negative,
+// both in milliseconds
+type LatencyData struct {
+	RTT  int64 `json:"rtt"`
+	Skew int64 `json:"skew"`
+}
+
+// latencyMessage is the inbound shape of latency: the ID of the ping it
+// answers and the client's clock when it did, in Unix milliseconds
+type latencyMessage struct {
+	Data struct {
+		ID         string  `json:"id"`
+		ClientTime float64 `json:"clientTime"`
+	} `json:"data"`
+}
+
+// latencyState is a client's ping awaiting an answer and the smoothed
+// round trip and clock skew the answers so far measured
+type latencyState struct {
+	mutex     sync.Mutex
+	pending   string
+	sentAt    time.Time
+	rtt       time.Duration
+	skew      time.Duration
+	published time.Duration
+}
+
+// measured returns the client's smoothed round trip and clock skew, zero
+// until it first answered a ping
+func (client *Client) measured() (time.Duration, time.Duration) {
+	client.latency.mutex.Lock()
+	defer client.latency.mutex.Unlock()
+	return client.latency.rtt, client.latency.skew
+}
+
+// handleLatency answers a client's ping, asking for a measurement, and
+// its latency messages, answering the server's pings. Any client may
+// measure its latency, read-only ones included.
+func (manager *WebSocketManager) handleLatency(client *Client, msgType string, message []byte) {
+	if msgType == "ping" {
+		manager.sendPing(client)
+		return
+	}
+	var answer latencyMessage
+	if err := json.Unmarshal(message, &answer); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "latency requires a data.id string and a data.clientTime number")
+		return
+	}
+	now := time.Now()
+
+	state := &client.latency
+	state.mutex.Lock()
+	if answer.Data.ID != state.pending {
+		// Answers a ping since replaced by another
+		state.mutex.Unlock()
+		return
+	}
+	state.pending = ""
+	rtt := now.Sub(state.sentAt)
+	clientTime := time.UnixMilli(0).Add(time.Duration(answer.Data.ClientTime * float64(time.Millisecond)))
+	skew := clientTime.Sub(state.sentAt.Add(rtt / 2))
+	if state.rtt == 0 {
+		state.rtt, state.skew = rtt, skew
+	} else {
+		state.rtt += (rtt - state.rtt) / 4
+		state.skew += (skew - state.skew) / 4
+	}
+	smoothed, published := state.rtt, state.published
+	publish := latencyMoved(published, smoothed)
+	if publish {
+		state.published = smoothed
+	}
+	state.mutex.Unlock()
+
+	metrics.ClientRTT.WithLabelValues(client.Transport()).Observe(rtt.Seconds())
+	metrics.ClientClockSkew.Observe(skew.Abs().Seconds())
+	manager.sendMessage(client, Message{Type: "latency", Data: LatencyData{RTT: rtt.Milliseconds(), Skew: skew.Milliseconds()}})
+
+	if publish && client.ImpersonatedBy == "" && !client.Spectator {
+		latency := strconv.FormatInt(smoothed.Milliseconds(), 10)
+		manager.setUserData(client, map[string]string{"latency": latency}, "awareness-update")
+	}
+}
+
+// latencyMoved reports whether a round trip moved far enough from the one
+// published to replace it
+func latencyMoved(published time.Duration, rtt time.Duration) bool {
+	if published == 0 {
+		return true
+	}
+	change := (rtt - published).Abs()
+	return change >= latencyPublishStep && float64(change) >= latencyPublishRatio*float64(published)
+}
+
+// sendPing starts a measurement of the client's round trip, replacing any
+// ping it has yet to answer
+func (manager *WebSocketManager) sendPing(client *Client) {
+	state := &client.latency
+	state.mutex.Lock()
+	state.pending = ids.RandomHex(8)
+	state.sentAt = time.Now()
+	ping := PingData{ID: state.pending, ServerTime: state.sentAt.UnixMilli()}
+	state.mutex.Unlock()
+
+	manager.sendMessage(client, Message{Type: "ping", Data: ping})
+}
+
+// measureLatency pings every client each interval. Bots share one
+// connection between their rooms and aren't measured.
+func (manager *WebSocketManager) measureLatency() {
+	ticker := time.NewTicker(manager.Config.Latency.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		manager.Mutex.RLock()
+		clients := make([]*Client, 0, len(manager.Clients))
+		for client := range manager.Clients {
+			if !client.Bot {
+				clients = append(clients, client)
+			}
+		}
+		manager.Mutex.RUnlock()
+
+		for _, client := range clients {
+			manager.sendPing(client)
+		}
+	}
+}
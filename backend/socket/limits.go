@@ -0,0 +1,57 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"backend/document"
+	"backend/metrics"
+)
+
+// Error codes of edits refused for a document's limits
+const (
+	ErrCodeDocumentTooLarge = "document-too-large"
+	ErrCodeDocumentBusy     = "document-busy"
+)
+
+// How long connections turned away for want of room are told to wait
+const capacityRetryAfter = 5 * time.Second
+
+// EditLimited reports whether err refused an edit for one of the
+// document's limits, counting it if so, and how long to wait before
+// trying again when waiting helps
+func (manager *WebSocketManager) EditLimited(err error) (retryAfter time.Duration, limited bool) {
+	switch {
+	case errors.Is(err, document.ErrTooLarge):
+		metrics.LimitRejections.WithLabelValues("document-size").Inc()
+		return 0, true
+	case errors.Is(err, document.ErrTooManyOps):
+		metrics.LimitRejections.WithLabelValues("document-ops").Inc()
+		return time.Duration(float64(time.Second) / manager.Config.Limits.DocumentOps.Rate), true
+	}
+	return 0, false
+}
+
+// RetryAfterSeconds formats a wait for the Retry-After header, which
+// counts whole seconds
+func RetryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
+
+// sendLimitError answers an edit refused for one of the document's limits,
+// and reports whether err was one
+func (manager *WebSocketManager) sendLimitError(client *Client, err error) bool {
+	retryAfter, limited := manager.EditLimited(err)
+	if !limited {
+		return false
+	}
+	data := ErrorData{Code: ErrCodeDocumentTooLarge, Message: fmt.Sprintf("documents are limited to %d characters", manager.Config.Limits.MaxDocumentSize)}
+	if retryAfter > 0 {
+		data = ErrorData{Code: ErrCodeDocumentBusy, Message: "the document is taking edits faster than allowed, redo the edit shortly", RetryAfter: retryAfter.String()}
+	}
+	manager.sendMessage(client, errorMessage(data))
+	return true
+}
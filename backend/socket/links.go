@@ -0,0 +1,18 @@
+package socket
+
+import (
+	"encoding/json"
+
+	"backend/linkcheck"
+)
+
+// broadcastLinkReport tells a document's room about a change in its broken
+// links. Rooms nobody has open are skipped by BroadcastToRoom.
+func (manager *WebSocketManager) broadcastLinkReport(report linkcheck.Report) {
+	payload, err := json.Marshal(Message{Type: "link-report", Data: report})
+	if err != nil {
+		manager.Logger.Error("Error marshalling link-report message", "doc_id", report.DocID, "error", err)
+		return
+	}
+	manager.BroadcastToRoom(report.DocID, payload)
+}
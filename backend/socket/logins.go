@@ -0,0 +1,104 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/config"
+	"backend/oauth"
+	"backend/sanitize"
+	"backend/users"
+)
+
+// newLogins returns the login providers cfg has a client registered at
+func newLogins(cfg config.OAuth) map[string]*oauth.Provider {
+	logins := make(map[string]*oauth.Provider)
+	for name, client := range map[string]config.OAuthClient{oauth.Google: cfg.Google, oauth.GitHub: cfg.GitHub} {
+		if client.ClientID == "" {
+			continue
+		}
+		provider, err := oauth.NewProvider(name, client.ClientID, client.ClientSecret)
+		if err == nil {
+			logins[name] = provider
+		}
+	}
+	return logins
+}
+
+// SignInWith starts a session for the account of tenant identity is
+// linked to. An identity seen for the first time is linked to the account
+// of tenant with its verified email, or to a new account of tenant when
+// there is none. Identities and emails of another tenant's accounts fail
+// with ErrWrongTenant. The provider's avatar replaces the account's each
+// time, unless it isn't an http or https URL.
+func (manager *WebSocketManager) SignInWith(ctx context.Context, tenant string, identity oauth.Identity) (users.User, Session, error) {
+	identity.AvatarURL, _ = sanitize.URL(identity.AvatarURL, sanitize.ImageSchemes)
+	link := users.Identity{Provider: identity.Provider, Subject: identity.Subject}
+	user, err := manager.Users.FindIdentity(ctx, link)
+	if errors.Is(err, users.ErrNotFound) {
+		user, err = manager.linkIdentity(ctx, tenant, link, identity)
+	}
+	if err != nil {
+		return users.User{}, Session{}, err
+	}
+	if user.Tenant != tenant {
+		return users.User{}, Session{}, ErrWrongTenant
+	}
+
+	if user.AvatarURL != identity.AvatarURL {
+		user.AvatarURL = identity.AvatarURL
+		user.UpdatedAt = time.Now().UTC()
+		if err := manager.Users.SaveUser(ctx, user); err != nil {
+			return users.User{}, Session{}, err
+		}
+		manager.showProfile(user)
+	}
+	manager.Logger.Info("Signed in", "user_id", user.ID, "provider", identity.Provider)
+	return user, manager.Sessions.Start(user), nil
+}
+
+// linkIdentity links an identity seen for the first time to an account
+// of tenant. Emails are unique across tenants, so one another tenant's
+// account has can't be linked or taken for a new account.
+func (manager *WebSocketManager) linkIdentity(ctx context.Context, tenant string, link users.Identity, identity oauth.Identity) (users.User, error) {
+	if identity.Email != "" {
+		user, err := manager.Users.FindUser(ctx, identity.Email)
+		if err == nil {
+			if user.Tenant != tenant {
+				return users.User{}, ErrWrongTenant
+			}
+			if err := manager.Users.LinkIdentity(ctx, user.ID, link); err != nil {
+				return users.User{}, err
+			}
+			user.Identities = append(user.Identities, link)
+			user.UpdatedAt = time.Now().UTC()
+			if err := manager.Users.SaveUser(ctx, user); err != nil {
+				return users.User{}, err
+			}
+			manager.Logger.Info("Login linked to account", "user_id", user.ID, "provider", link.Provider)
+			return user, nil
+		}
+		if !errors.Is(err, users.ErrNotFound) {
+			return users.User{}, err
+		}
+	}
+
+	user := users.NewLinked(link, identity.Email, identity.Name, "", identity.AvatarURL)
+	user.Tenant = tenant
+	guestName, color := manager.guestProfile(user.ID, nil)
+	user.Color = color
+	if name, ok := normalizeUserName(identity.Name); ok {
+		user.DisplayName = name
+	} else {
+		user.DisplayName = guestName
+	}
+	if err := manager.Users.LinkIdentity(ctx, user.ID, link); err != nil {
+		return users.User{}, err
+	}
+	if err := manager.Users.CreateUser(ctx, user); err != nil {
+		return users.User{}, err
+	}
+	manager.Logger.Info("Account registered", "user_id", user.ID, "provider", link.Provider)
+	return user, nil
+}
@@ -0,0 +1,136 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// How long a poll waits for a message before answering with none
+const pollWait = 25 * time.Second
+
+// A long polling client that hasn't polled for this long is gone
+const pollIdleTimeout = time.Minute
+
+var (
+	// ErrOriginNotAllowed is a connection opened from a page whose origin
+	// isn't allowed
+	ErrOriginNotAllowed = errors.New("origin not allowed")
+	// ErrAlreadyPolling is a poll made while another is waiting on the
+	// same connection
+	ErrAlreadyPolling = errors.New("connection is already being polled")
+	// ErrConnectionClosed is a poll on a connection the server closed; the
+	// client has to open a new one
+	ErrConnectionClosed = errors.New("connection was closed")
+)
+
+// OpenLongPoll opens a long polling connection to docID, the fallback of
+// last resort for networks that let neither WebSockets nor event streams
+// through. The client then receives its messages with Poll and sends its
+// own with PostMessage. It joins its room, presence included, like any
+// other, resuming the session in the session query parameter with the
+// access any share parameter grants. A guest cookie may be set on w.
+func (manager *WebSocketManager) OpenLongPoll(w http.ResponseWriter, r *http.Request, docID string) (*Client, error) {
+	if !manager.checkOrigin(r) {
+		return nil, ErrOriginNotAllowed
+	}
+	client, err := manager.newHTTPClient(TransportLongPoll, docID, w, r)
+	if err != nil {
+		return nil, err
+	}
+	manager.Register <- client
+	go manager.readInbox(client)
+	go manager.watchPolls(client)
+	return client, nil
+}
+
+// Poll returns the messages queued for a long polling connection, waiting
+// up to pollWait for the first one. sessionID must be the session the
+// connection was opened with. No messages are taken when ctx ends first.
+func (manager *WebSocketManager) Poll(ctx context.Context, docID string, connID string, sessionID string) ([]json.RawMessage, error) {
+	client, err := manager.httpClient(docID, connID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	conn := client.httpConn
+	if conn.transport != TransportLongPoll {
+		return nil, ErrUnknownConnection
+	}
+	if !conn.polling.TryLock() {
+		return nil, ErrAlreadyPolling
+	}
+	defer conn.polling.Unlock()
+	defer conn.touch()
+
+	wait := time.NewTimer(pollWait)
+	defer wait.Stop()
+
+	for {
+		select {
+		case <-client.Send.Ready():
+			first, ok := client.Send.Pop()
+			if !ok {
+				if client.Send.Closed() {
+					conn.close()
+					return nil, ErrConnectionClosed
+				}
+				continue
+			}
+			messages := []json.RawMessage{first}
+			for len(messages) < maxBatchMessages {
+				message, ok := client.Send.Pop()
+				if !ok {
+					break
+				}
+				messages = append(messages, message)
+			}
+			manager.relieve(client)
+			return messages, nil
+		case <-conn.closed:
+			return nil, ErrConnectionClosed
+		case <-wait.C:
+			return []json.RawMessage{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// touch records that the client polled
+func (conn *httpConn) touch() {
+	select {
+	case conn.polled <- struct{}{}:
+	default:
+	}
+}
+
+// watchPolls closes a long polling client once it stops polling. A poll
+// still waiting counts as activity.
+func (manager *WebSocketManager) watchPolls(client *Client) {
+	conn := client.httpConn
+	idle := time.NewTimer(pollIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-conn.polled:
+			idle.Reset(pollIdleTimeout)
+		case <-idle.C:
+			if !conn.polling.TryLock() {
+				idle.Reset(pollIdleTimeout)
+				continue
+			}
+			conn.polling.Unlock()
+			client.Logger.Debug("Long polling client stopped polling")
+			conn.close()
+			return
+		case <-conn.closed:
+			return
+		case <-client.ctx.Done():
+			// A last poll still collects what was queued before removal
+			return
+		}
+	}
+}
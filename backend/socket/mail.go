@@ -0,0 +1,135 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"html"
+	"net/url"
+	"time"
+
+	"backend/mail"
+	"backend/notifications"
+	"backend/share"
+	"backend/users"
+)
+
+// ErrMailDisabled is an email asked of a server that sends none
+var ErrMailDisabled = errors.New("this server doesn't send email")
+
+// Timeout for sending one email, store lookups included
+const mailTimeout = 30 * time.Second
+
+// InviteByEmail emails the share link token to address on behalf of
+// sharer. It reports false without sending anything when address belongs
+// to an account that opted out of email.
+func (manager *WebSocketManager) InviteByEmail(ctx context.Context, sharer Session, address string, token string, grant share.Grant) (bool, error) {
+	if manager.Mailer == nil {
+		return false, ErrMailDisabled
+	}
+	address, err := users.NormalizeEmail(address)
+	if err != nil {
+		return false, err
+	}
+	user, err := manager.Users.FindUser(ctx, address)
+	if err != nil && !errors.Is(err, users.ErrNotFound) {
+		return false, err
+	}
+	if err == nil && user.EmailOptOut {
+		return false, nil
+	}
+
+	message, err := mail.Invitation{
+		To:        address,
+		Sharer:    sharer.UserName,
+		Title:     manager.documentTitle(grant.DocID),
+		Role:      grant.Role,
+		Link:      manager.clientLink(url.Values{"share": {token}}),
+		ExpiresAt: grant.ExpiresAt,
+	}.Message()
+	if err != nil {
+		return false, err
+	}
+	if err := manager.Mailer.Send(ctx, message); err != nil {
+		return false, err
+	}
+	manager.Logger.Info("Emailed share invitation", "doc_id", grant.DocID, "sharer", sharer.UserID)
+	return true, nil
+}
+
+// sendDigests emails each user with an account a digest of the
+// notifications they got and haven't read since the last one, unless they
+// opted out of email
+func (manager *WebSocketManager) sendDigests() {
+	ticker := time.NewTicker(manager.Config.Mail.DigestInterval)
+	defer ticker.Stop()
+
+	since := time.Now()
+	for now := range ticker.C {
+		for userID, unread := range manager.Notifications.UnreadBetween(since, now) {
+			if err := manager.sendDigest(userID, unread); err != nil {
+				manager.Logger.Warn("Could not send digest", "user_id", userID, "error", err)
+			}
+		}
+		since = now
+	}
+}
+
+func (manager *WebSocketManager) sendDigest(userID string, unread []notifications.Notification) error {
+	ctx, cancel := context.WithTimeout(manager.ctx, mailTimeout)
+	defer cancel()
+
+	user, err := manager.Users.GetUser(ctx, userID)
+	if errors.Is(err, users.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailOptOut || user.Email == "" {
+		return nil
+	}
+
+	digest := mail.Digest{To: user.Email, UserName: user.DisplayName}
+	titles := make(map[string]string)
+	for _, notification := range unread {
+		title, ok := titles[notification.DocID]
+		if !ok {
+			title = manager.documentTitle(notification.DocID)
+			titles[notification.DocID] = title
+		}
+		digest.Items = append(digest.Items, mail.DigestItem{
+			From:    notification.From["userName"],
+			Title:   title,
+			Link:    manager.clientLink(url.Values{"doc": {notification.DocID}}),
+			Text:    html.UnescapeString(notification.Text),
+			Mention: notification.Type == notifications.TypeMention,
+			Edited:  notification.Type == notifications.TypeEdit,
+			At:      notification.CreatedAt,
+		})
+	}
+	message, err := digest.Message()
+	if err != nil {
+		return err
+	}
+	return manager.Mailer.Send(ctx, message)
+}
+
+// documentTitle returns the title of docID, or nothing when it has none or
+// can't be loaded
+func (manager *WebSocketManager) documentTitle(docID string) string {
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return ""
+	}
+	return doc.Metadata().Title
+}
+
+// clientLink returns the client's URL with query
+func (manager *WebSocketManager) clientLink(query url.Values) string {
+	link, err := url.Parse(manager.Config.Mail.ClientURL)
+	if err != nil {
+		return manager.Config.Mail.ClientURL
+	}
+	link.RawQuery = query.Encode()
+	return link.String()
+}
@@ -0,0 +1,40 @@
+package socket
+
+import (
+	"fmt"
+
+	"backend/document"
+	"backend/richtext"
+)
+
+// MergeChanges applies changes author made one after another on top of
+// revision base while offline, transformed past the edits made since, and
+// sends the room a doc-sync for each. It returns the ops applied and the
+// changes as transformed.
+func (manager *WebSocketManager) MergeChanges(docID string, author Session, base int64, changes []richtext.Delta) ([]document.Op, []richtext.Delta, error) {
+	normalized := make([]richtext.Delta, len(changes))
+	for i, change := range changes {
+		var err error
+		if normalized[i], err = richtext.NormalizeChange(change); err != nil {
+			return nil, nil, fmt.Errorf("change %d: %w", i, err)
+		}
+	}
+
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc.Muted(author.UserID) {
+		return nil, nil, ErrMuted
+	}
+	doc.Join(author.UserID)
+	ops, transformed, err := doc.Merge(author.UserID, base, normalized, manager.syncRoom(docID))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, op := range ops {
+		manager.opApplied(doc, op)
+	}
+	manager.editTracked(doc)
+	return ops, transformed, nil
+}
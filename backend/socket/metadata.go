@@ -0,0 +1,90 @@
+package socket
+
+import (
+	"encoding/json"
+
+	"backend/document"
+	"backend/events"
+)
+
+// metadataMessage is the inbound shape of doc-metadata. Fields left out
+// keep their current value.
+type metadataMessage struct {
+	Data struct {
+		Language  *string `json:"language"`
+		Direction *string `json:"direction"`
+	} `json:"data"`
+}
+
+// metaUpdateMessage is the inbound shape of doc-meta-update. Fields left
+// out keep their current value.
+type metaUpdateMessage struct {
+	Data struct {
+		Title       *string   `json:"title"`
+		Description *string   `json:"description"`
+		Tags        *[]string `json:"tags"`
+	} `json:"data"`
+}
+
+// handleMetadata changes the document's language or base direction and
+// announces the result to the whole room, author included
+func (manager *WebSocketManager) handleMetadata(client *Client, message []byte) {
+	var request metadataMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "doc-metadata requires data.language and/or data.direction strings")
+		return
+	}
+	manager.updateMetadata(client, document.MetadataUpdate{
+		Language:  request.Data.Language,
+		Direction: request.Data.Direction,
+	})
+}
+
+// handleMetaUpdate changes the document's title, description or tags and
+// announces the result to the whole room, author included
+func (manager *WebSocketManager) handleMetaUpdate(client *Client, message []byte) {
+	var request metaUpdateMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "doc-meta-update requires data.title or data.description strings and/or a data.tags array of strings")
+		return
+	}
+	manager.updateMetadata(client, document.MetadataUpdate{
+		Title:       request.Data.Title,
+		Description: request.Data.Description,
+		Tags:        request.Data.Tags,
+	})
+}
+
+func (manager *WebSocketManager) updateMetadata(client *Client, update document.MetadataUpdate) {
+	title := client.Doc.Metadata().Title
+	metadata, err := client.Doc.UpdateMetadata(update, func(metadata document.Metadata) {
+		payload, err := json.Marshal(Message{Type: "doc-metadata", Data: metadata})
+		if err != nil {
+			client.Logger.Error("Error marshalling doc-metadata message", "error", err)
+			return
+		}
+		manager.BroadcastToRoom(client.DocID, payload)
+	})
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+	if metadata.Title != title {
+		manager.auditRename(client.DocID, client.ID, "title", metadata.Title)
+	}
+
+	manager.emitEvent(events.TypeMetadataUpdated, client.DocID, client.ID, events.MetadataUpdated{
+		Language:    metadata.Language,
+		Direction:   metadata.Direction,
+		Title:       metadata.Title,
+		Description: metadata.Description,
+		Tags:        metadata.Tags,
+	})
+}
+
+func (manager *WebSocketManager) sendMetadata(client *Client) {
+	manager.sendMessage(client, Message{
+		Type: "doc-metadata",
+		Data: client.Doc.Metadata(),
+	})
+}
@@ -0,0 +1,264 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/chat"
+	"backend/comments"
+	"backend/document"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrCodeRoomMigrating answers messages sent while the room is moving to
+// another node
+const ErrCodeRoomMigrating = "room-migrating"
+
+// How long a node keeps sending clients of a room that moved away on to
+// its new node
+const migrationRedirectTTL = 10 * time.Minute
+
+// Timeout for handing a room over to another node
+const handoffTimeout = 30 * time.Second
+
+var (
+	ErrRoomMigrating    = errors.New("room is already moving to another node")
+	ErrNoMigrationToken = errors.New("handing rooms over needs a migration token")
+)
+
+// RoomMovedError turns away a connection to a room that has left this
+// node for the one clients reach at URL
+type RoomMovedError struct {
+	URL string
+}
+
+func (err *RoomMovedError) Error() string {
+	return "room has moved to " + err.URL
+}
+
+// RoomState is what a node keeps about a room, as handed over to the node
+// taking the room over. Sessions are those of the room's clients, so they
+// resume their identity and replay position there.
+type RoomState struct {
+	Document document.Record    `json:"document"`
+	Sessions []Session          `json:"sessions"`
+	Chat     []chat.Message     `json:"chat"`
+	Comments []comments.Comment `json:"comments"`
+}
+
+// migration is a room moving to, or moved to, the node clients reach at
+// URL. A room still moving has no expiry.
+type migration struct {
+	url     string
+	expires time.Time
+}
+
+// migrations tracks rooms that are leaving this node
+type migrations struct {
+	mutex sync.Mutex
+	rooms map[string]migration
+}
+
+// start marks a room as moving, unless it already is
+func (table *migrations) start(docID string, publicURL string) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if existing, ok := table.rooms[docID]; ok && (existing.expires.IsZero() || time.Now().Before(existing.expires)) {
+		return false
+	}
+	table.rooms[docID] = migration{url: publicURL}
+	return true
+}
+
+// finish keeps redirecting to a room's new node for a while
+func (table *migrations) finish(docID string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if room, ok := table.rooms[docID]; ok {
+		room.expires = time.Now().Add(migrationRedirectTTL)
+		table.rooms[docID] = room
+	}
+}
+
+func (table *migrations) cancel(docID string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	delete(table.rooms, docID)
+}
+
+// lookup returns where a room is moving or has moved to
+func (table *migrations) lookup(docID string) (string, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	room, ok := table.rooms[docID]
+	if !ok {
+		return "", false
+	}
+	if !room.expires.IsZero() && time.Now().After(room.expires) {
+		delete(table.rooms, docID)
+		return "", false
+	}
+	return room.url, true
+}
+
+// MigrateRoom hands a room over to the node at target and sends its
+// clients there; publicURL is where clients reach that node, target when
+// empty. The document is frozen while the room state is sent, so no
+// accepted edit is left behind, and on failure the room carries on here.
+// It returns how many clients were sent on.
+func (manager *WebSocketManager) MigrateRoom(ctx context.Context, docID string, target string, publicURL string) (int, error) {
+	if manager.Config.Migration.Token == "" {
+		return 0, ErrNoMigrationToken
+	}
+	if publicURL == "" {
+		publicURL = target
+	}
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return 0, err
+	}
+	if !manager.migrations.start(docID, publicURL) {
+		return 0, ErrRoomMigrating
+	}
+
+	members := manager.roomMembers(docID)
+	state := RoomState{
+		Document: doc.Freeze(),
+		Chat:     manager.Chat.Recent(docID),
+		Comments: manager.Comments.List(docID),
+	}
+	seen := make(map[string]bool)
+	for _, member := range members {
+		if seen[member.SessionID] {
+			continue
+		}
+		seen[member.SessionID] = true
+		if session, ok := manager.Sessions.Lookup(member.SessionID); ok {
+			state.Sessions = append(state.Sessions, session)
+		}
+	}
+
+	if err := manager.handOff(ctx, target, state); err != nil {
+		doc.Thaw()
+		manager.migrations.cancel(docID)
+		return 0, err
+	}
+	manager.migrations.finish(docID)
+	manager.Documents.Drop(docID)
+	manager.Canary.Forget(docID)
+	manager.Chat.Restore(docID, nil)
+	manager.Comments.Restore(docID, nil)
+
+	// Clients that joined during the handover are sent on too, though
+	// their sessions missed it
+	moved := manager.roomMembers(docID)
+	for _, member := range moved {
+		manager.sendMigrated(member, publicURL)
+		manager.disconnect(member, CloseRoomMoved, "room moved to "+publicURL)
+	}
+	manager.Logger.Info("Room migrated", "doc_id", docID, "target", target, "clients", len(moved),
+		"revision", state.Document.Revision)
+	return len(moved), nil
+}
+
+// DrainRooms migrates every room on this node to target, so the node can
+// be shut down without interrupting anyone for longer than a reconnect.
+// Rooms that fail to move stay here and are reported in the error.
+func (manager *WebSocketManager) DrainRooms(ctx context.Context, target string, publicURL string) (rooms int, clients int, err error) {
+	manager.Mutex.RLock()
+	docIDs := make([]string, 0, len(manager.Rooms))
+	for docID := range manager.Rooms {
+		docIDs = append(docIDs, docID)
+	}
+	manager.Mutex.RUnlock()
+
+	var errs []error
+	for _, docID := range docIDs {
+		moved, err := manager.MigrateRoom(ctx, docID, target, publicURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("room %q: %w", docID, err))
+			continue
+		}
+		rooms++
+		clients += moved
+	}
+	return rooms, clients, errors.Join(errs...)
+}
+
+// handOff sends a room's state to the node at target
+func (manager *WebSocketManager) handOff(ctx context.Context, target string, state RoomState) error {
+	body, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, handoffTimeout)
+	defer cancel()
+
+	endpoint := strings.TrimSuffix(target, "/") + "/api/admin/rooms/" + url.PathEscape(state.Document.ID) + "/handoff"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+manager.Config.Migration.Token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("handing room over: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		answer, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("handing room over: target answered %s: %s", response.Status, bytes.TrimSpace(answer))
+	}
+	return nil
+}
+
+// ReceiveRoom takes over a room handed over by another node. It fails
+// with document.ErrInUse when the room has clients here.
+func (manager *WebSocketManager) ReceiveRoom(state RoomState) error {
+	docID := state.Document.ID
+	if _, err := manager.Documents.Install(state.Document); err != nil {
+		return err
+	}
+	for _, session := range state.Sessions {
+		manager.Sessions.Import(session)
+	}
+	manager.Chat.Restore(docID, state.Chat)
+	manager.Comments.Restore(docID, state.Comments)
+	// A room coming back is no longer sent elsewhere
+	manager.migrations.cancel(docID)
+
+	manager.Logger.Info("Room received", "doc_id", docID, "revision", state.Document.Revision,
+		"sessions", len(state.Sessions))
+	return nil
+}
+
+// migratedMessage tells a client its room has moved to the node at url,
+// where it reconnects with its session
+func migratedMessage(url string) Message {
+	return Message{Type: "room-migrated", Data: map[string]string{"url": url}}
+}
+
+func (manager *WebSocketManager) sendMigrated(client *Client, url string) {
+	manager.sendMessage(client, migratedMessage(url))
+}
+
+// redirectMigrated turns away a WebSocket connecting to a room that has
+// left this node, telling it where the room went
+func (manager *WebSocketManager) redirectMigrated(conn *websocket.Conn, encoding Encoding, url string) {
+	manager.closeConn(conn, encoding, CloseRoomMoved, migratedMessage(url), CloseRoomMoved.message("room moved to "+url))
+}
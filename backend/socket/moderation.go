@@ -0,0 +1,156 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/audit"
+	"backend/chat"
+	"backend/document"
+)
+
+// ErrCodeMuted answers the edits and chat of a user muted in the document
+const ErrCodeMuted = "muted"
+
+var (
+	ErrMuted              = errors.New("you are muted in this document")
+	ErrInvalidModDuration = errors.New("duration must be a positive duration such as 1h")
+)
+
+// Messages a muted user's connections drop
+var mutedMessages = map[string]bool{
+	"content":         true,
+	"chat":            true,
+	"typing":          true,
+	"reaction-add":    true,
+	"reaction-remove": true,
+	"doc-meta-update": true,
+	"comment-add":     true,
+}
+
+// ModerationRequest asks for a moderation action against UserID. Duration
+// bounds a ban or mute, which lasts until lifted without one; Reason is
+// shown to the user and put in the close frame of a kick or ban.
+type ModerationRequest struct {
+	Action   string `json:"action"`
+	UserID   string `json:"userId"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// UserModeratedData is broadcast to the room after a moderation action.
+// Until is when a ban or mute ends, if it does.
+type UserModeratedData struct {
+	UserID string    `json:"userId"`
+	Action string    `json:"action"`
+	Until  time.Time `json:"until,omitzero"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+}
+
+type moderateMessage struct {
+	Data ModerationRequest `json:"data"`
+}
+
+// handleModerate lets the owner kick, ban or mute someone from the room
+func (manager *WebSocketManager) handleModerate(client *Client, message []byte) {
+	var request moderateMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "moderate requires data.action and data.userId strings")
+		return
+	}
+	_, err := manager.Moderate(client.Doc, client.ID, request.Data)
+	if errors.Is(err, document.ErrNotModerator) || errors.Is(err, document.ErrModeratingOwner) {
+		manager.sendError(client, ErrCodeForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+	}
+}
+
+// Moderate applies a moderation action to doc on behalf of moderator, who
+// must be its owner, and returns what the room was told. Kicked and banned
+// users' connections to the room on this node are closed; bans and mutes
+// are saved with the document.
+func (manager *WebSocketManager) Moderate(doc *document.Document, moderator string, request ModerationRequest) (UserModeratedData, error) {
+	var until time.Time
+	if request.Duration != "" {
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			return UserModeratedData{}, ErrInvalidModDuration
+		}
+		until = time.Now().Add(duration).UTC()
+	}
+	reason := ""
+	if request.Reason != "" {
+		var err error
+		if reason, err = chat.Sanitize(request.Reason, manager.Config.Limits.MaxChatLength); err != nil {
+			return UserModeratedData{}, err
+		}
+	}
+	if err := doc.Moderate(moderator, request.Action, request.UserID, until); err != nil {
+		return UserModeratedData{}, err
+	}
+	if request.Action != document.ModerationBan && request.Action != document.ModerationMute {
+		until = time.Time{}
+	}
+
+	moderated := UserModeratedData{UserID: request.UserID, Action: request.Action, Until: until, Reason: reason, By: moderator}
+	manager.Logger.Info("User moderated", "doc_id", doc.ID, "user_id", request.UserID, "action", request.Action, "by", moderator)
+	details := map[string]string{"action": request.Action, "target": request.UserID}
+	if !until.IsZero() {
+		details["until"] = until.Format(time.RFC3339)
+	}
+	manager.Audit.Record(audit.Entry{Action: audit.ActionModerated, DocID: doc.ID, UserID: moderator, Details: details})
+
+	payload, err := json.Marshal(Message{Type: "user-moderated", Data: moderated})
+	if err != nil {
+		manager.Logger.Error("Error marshalling user-moderated message", "doc_id", doc.ID, "error", err)
+	} else {
+		manager.BroadcastToRoom(doc.ID, payload)
+	}
+
+	switch request.Action {
+	case document.ModerationKick:
+		manager.closeModerated(doc.ID, request.UserID, CloseKicked, "removed from the document", reason)
+	case document.ModerationBan:
+		message := "banned from the document"
+		if !until.IsZero() {
+			message += " until " + until.Format(time.RFC3339)
+		}
+		manager.closeModerated(doc.ID, request.UserID, CloseBanned, message, reason)
+	}
+	return moderated, nil
+}
+
+// closeModerated disconnects userID's connections to a room, with reason
+// in the close frame
+func (manager *WebSocketManager) closeModerated(docID string, userID string, closeReason CloseReason, message string, reason string) {
+	if reason != "" {
+		message = fmt.Sprintf("%s: %s", message, reason)
+	}
+	for _, member := range manager.roomMembers(docID) {
+		if member.ID != userID || member.ImpersonatedBy != "" {
+			continue
+		}
+		if reason != "" {
+			member.closeNote.Store(&reason)
+		}
+		manager.disconnect(member, closeReason, message)
+	}
+}
+
+// admitModerated turns away a user banned from doc
+func admitModerated(doc *document.Document, userID string) *CloseError {
+	until, banned := doc.Banned(userID)
+	if !banned {
+		return nil
+	}
+	if until.IsZero() {
+		return &CloseError{Reason: CloseBanned, Details: "banned from the document"}
+	}
+	return &CloseError{Reason: CloseBanned, Details: "banned from the document until " + until.UTC().Format(time.RFC3339)}
+}
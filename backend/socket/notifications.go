@@ -0,0 +1,157 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"backend/document"
+	"backend/ids"
+	"backend/notifications"
+)
+
+// ErrNotJoined is a notification level chosen for a document by someone
+// who never joined it
+var ErrNotJoined = errors.New("only users who have joined the document choose how it notifies them")
+
+// NotificationData is the payload of notification, delivered to every
+// connection of the user it is for along with how many are unread
+type NotificationData struct {
+	Notification notifications.Notification `json:"notification"`
+	Unread       int                        `json:"unread"`
+}
+
+// notifyMentions records a notification for each user text @-mentions and
+// delivers it to them wherever they are connected, returning who they are.
+// Handles are matched against the people who have joined the document and
+// those in its room, so only users who know of it can be mentioned;
+// authors don't notify themselves.
+func (manager *WebSocketManager) notifyMentions(doc *document.Document, source string, sourceID string, text string, from map[string]string) map[string]bool {
+	handles := notifications.Mentions(text)
+	if len(handles) == 0 {
+		return nil
+	}
+	mentioned := make(map[string]bool)
+	for userID, names := range manager.mentionable(doc) {
+		for _, name := range names {
+			if userID != from["userId"] && slices.Contains(handles, notifications.Handle(name)) {
+				mentioned[userID] = true
+			}
+		}
+	}
+
+	for userID := range mentioned {
+		manager.notify(notifications.Notification{
+			UserID:   userID,
+			Type:     notifications.TypeMention,
+			DocID:    doc.ID,
+			Source:   source,
+			SourceID: sourceID,
+			Text:     text,
+			From:     from,
+		})
+	}
+	return mentioned
+}
+
+// notifyEdit tells the users following every change of doc that op's
+// author edited it. Those in its room see the edit already, and those who
+// haven't read the last one about doc aren't told again.
+func (manager *WebSocketManager) notifyEdit(doc *document.Document, op document.Op) {
+	subscribers := manager.Notifications.Subscribers(doc.ID, notifications.LevelAll)
+	if len(subscribers) == 0 {
+		return
+	}
+	from := map[string]string{"userId": op.Author}
+	present := make(map[string]bool)
+	for _, member := range manager.roomMembers(doc.ID) {
+		present[member.ID] = true
+		if member.ID == op.Author {
+			from = manager.clientData(member)["userData"]
+		}
+	}
+	for _, userID := range subscribers {
+		if userID == op.Author || present[userID] || manager.Notifications.HasUnread(userID, doc.ID, notifications.TypeEdit) {
+			continue
+		}
+		manager.notify(notifications.Notification{
+			UserID: userID,
+			Type:   notifications.TypeEdit,
+			DocID:  doc.ID,
+			From:   from,
+		})
+	}
+}
+
+// notify records a notification and delivers it to its user wherever they
+// are connected, unless the level they chose for its document leaves it out
+func (manager *WebSocketManager) notify(notification notifications.Notification) {
+	if !notifications.Allows(manager.Notifications.Level(notification.UserID, notification.DocID), notification.Type) {
+		return
+	}
+	notification.ID = ids.NewUUID()
+	notification.CreatedAt = time.Now().UTC()
+	manager.Notifications.Add(notification)
+
+	message := Message{Type: "notification", Data: NotificationData{
+		Notification: notification,
+		Unread:       manager.Notifications.Unread(notification.UserID),
+	}}
+	for _, client := range manager.userClients(notification.UserID) {
+		manager.sendMessage(client, message)
+	}
+}
+
+// NotificationLevel returns the level user is notified at about docID
+func (manager *WebSocketManager) NotificationLevel(docID string, user Session) (string, error) {
+	if _, err := manager.joinedDocument(docID, user); err != nil {
+		return "", err
+	}
+	return manager.Notifications.Level(user.UserID, docID), nil
+}
+
+// SetNotificationLevel sets the level user is notified at about docID,
+// one of the notifications levels
+func (manager *WebSocketManager) SetNotificationLevel(docID string, user Session, level string) error {
+	if _, err := manager.joinedDocument(docID, user); err != nil {
+		return err
+	}
+	return manager.Notifications.SetLevel(user.UserID, docID, level)
+}
+
+// joinedDocument returns docID if it belongs to user's tenant and they
+// have joined it
+func (manager *WebSocketManager) joinedDocument(docID string, user Session) (*document.Document, error) {
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Tenant() != user.Tenant {
+		return nil, ErrWrongTenant
+	}
+	if !slices.Contains(doc.Editors(), user.UserID) {
+		return nil, ErrNotJoined
+	}
+	return doc, nil
+}
+
+// mentionable returns the names of the users who may be mentioned in doc
+// by user ID: its owner and editors with accounts under their display
+// name, and whoever is in its room under the name they use there
+func (manager *WebSocketManager) mentionable(doc *document.Document) map[string][]string {
+	names := make(map[string][]string)
+	ctx, cancel := context.WithTimeout(manager.ctx, 5*time.Second)
+	defer cancel()
+	for _, userID := range doc.Editors() {
+		if user, err := manager.Users.GetUser(ctx, userID); err == nil {
+			names[userID] = append(names[userID], user.DisplayName)
+		}
+	}
+	for _, member := range manager.roomMembers(doc.ID) {
+		if member.ImpersonatedBy == "" {
+			names[member.ID] = append(names[member.ID], manager.clientData(member)["userData"]["userName"])
+		}
+	}
+	return names
+}
@@ -0,0 +1,72 @@
+package socket
+
+import (
+	"sync"
+
+	"backend/document"
+)
+
+// Ops a subscriber may fall behind before it is cut off
+const opFeedBuffer = 256
+
+// opFeed hands the ops applied to documents to subscribers outside their
+// rooms, such as the gRPC API
+type opFeed struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan document.Op]bool
+}
+
+func newOpFeed() *opFeed {
+	return &opFeed{subscribers: make(map[string]map[chan document.Op]bool)}
+}
+
+// SubscribeOps returns a channel receiving the ops applied to docID from
+// now on and a function ending the subscription. The channel is closed
+// when the subscription ends, or early if the subscriber falls too far
+// behind.
+func (manager *WebSocketManager) SubscribeOps(docID string) (<-chan document.Op, func()) {
+	feed := manager.ops
+	ops := make(chan document.Op, opFeedBuffer)
+
+	feed.mutex.Lock()
+	if feed.subscribers[docID] == nil {
+		feed.subscribers[docID] = make(map[chan document.Op]bool)
+	}
+	feed.subscribers[docID][ops] = true
+	feed.mutex.Unlock()
+
+	return ops, func() {
+		feed.mutex.Lock()
+		defer feed.mutex.Unlock()
+		feed.remove(docID, ops)
+	}
+}
+
+// publishOp hands op to the subscribers of docID without waiting on any
+func (manager *WebSocketManager) publishOp(docID string, op document.Op) {
+	feed := manager.ops
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	for ops := range feed.subscribers[docID] {
+		select {
+		case ops <- op:
+		default:
+			manager.Logger.Warn("Op subscriber fell behind", "doc_id", docID)
+			feed.remove(docID, ops)
+		}
+	}
+}
+
+// remove closes a subscriber's channel unless it is gone already, with
+// the lock held
+func (feed *opFeed) remove(docID string, ops chan document.Op) {
+	if !feed.subscribers[docID][ops] {
+		return
+	}
+	delete(feed.subscribers[docID], ops)
+	if len(feed.subscribers[docID]) == 0 {
+		delete(feed.subscribers, docID)
+	}
+	close(ops)
+}
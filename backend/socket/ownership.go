@@ -0,0 +1,212 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"backend/metrics"
+	"backend/ownership"
+)
+
+// forwardedHeader names the node that forwarded a request to the owner of
+// its document
+const forwardedHeader = "X-Forwarded-Node"
+
+// Timeout for taking, renewing or giving up a lease
+const leaseTimeout = 5 * time.Second
+
+// leases tracks the documents this node owns, by when it took each
+type leases struct {
+	mutex    sync.Mutex
+	acquired map[string]time.Time
+}
+
+// clustered reports whether documents are shared with other nodes
+func (manager *WebSocketManager) clustered() bool {
+	return manager.Node.URL != ""
+}
+
+// claim returns the node that owns docID, taking the document for this one
+// when no node does, and whether that is this node
+func (manager *WebSocketManager) claim(ctx context.Context, docID string) (ownership.Node, bool, error) {
+	owner, err := manager.Owners.Acquire(ctx, docID, manager.Node, manager.Config.Cluster.LeaseTTL)
+	if err != nil || owner != manager.Node {
+		return owner, false, err
+	}
+	manager.leases.mutex.Lock()
+	if _, held := manager.leases.acquired[docID]; !held {
+		manager.leases.acquired[docID] = time.Now()
+		metrics.OwnedDocuments.Inc()
+	}
+	manager.leases.mutex.Unlock()
+	return owner, true, nil
+}
+
+// OwnerOf returns the node that owns docID, taking the document for this
+// one when no node does, and whether that is this node. Without a cluster
+// this node owns every document.
+func (manager *WebSocketManager) OwnerOf(ctx context.Context, docID string) (ownership.Node, bool, error) {
+	if !manager.clustered() {
+		return manager.Node, true, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, leaseTimeout)
+	defer cancel()
+	return manager.claim(ctx, docID)
+}
+
+// ForwardToOwner hands a connection to docID over to the node that owns
+// the document, when that isn't this one, and reports whether it did. A
+// request another node already forwarded is turned away instead, since
+// ownership changed on the way, and the client retries from the start.
+func (manager *WebSocketManager) ForwardToOwner(w http.ResponseWriter, r *http.Request, docID string) bool {
+	if !manager.clustered() {
+		return false
+	}
+	if docID == "" {
+		docID = DefaultDocID
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), leaseTimeout)
+	owner, local, err := manager.claim(ctx, docID)
+	cancel()
+	switch {
+	case err != nil:
+		manager.Logger.Warn("Could not look up document owner", "doc_id", docID, "error", err)
+		writeRefusal(w, &CloseError{Reason: CloseDocumentUnavailable, Details: "the document's owner could not be looked up", RetryAfter: time.Second})
+		return true
+	case local:
+		return false
+	case r.Header.Get(forwardedHeader) != "":
+		writeRefusal(w, &CloseError{Reason: CloseOwnerChanged, Details: "the document changed owner, reconnect", RetryAfter: time.Second})
+		return true
+	}
+
+	target, err := url.Parse(owner.URL)
+	if err != nil {
+		manager.Logger.Error("Document owner has an invalid URL", "doc_id", docID, "owner", owner.ID, "error", err)
+		writeRefusal(w, &CloseError{Reason: CloseDocumentUnavailable, Details: "the document's owner cannot be reached", RetryAfter: time.Second})
+		return true
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(forwarded *httputil.ProxyRequest) {
+			forwarded.SetURL(target)
+			// The owner resolves the tenant from the host the client
+			// asked for, and the client's address from the chain
+			forwarded.Out.Host = forwarded.In.Host
+			forwarded.Out.Header["X-Forwarded-For"] = forwarded.In.Header["X-Forwarded-For"]
+			forwarded.SetXForwarded()
+			forwarded.Out.Header.Set(forwardedHeader, manager.Node.ID)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			manager.Logger.Warn("Forwarding to document owner failed", "doc_id", docID, "owner", owner.ID, "error", err)
+			writeRefusal(w, &CloseError{Reason: CloseDocumentUnavailable, Details: "the document's owner cannot be reached", RetryAfter: time.Second})
+		},
+	}
+	metrics.ForwardedConnections.Inc()
+	proxy.ServeHTTP(w, r)
+	return true
+}
+
+// RefileOnOwner files a document in its folder again on the node that
+// owns it, on behalf of the request r and with its credentials, so that
+// node applies the permissions the folder passes down
+func (manager *WebSocketManager) RefileOnOwner(r *http.Request, filed FiledElsewhere) error {
+	body, err := json.Marshal(map[string]string{"folderId": filed.FolderID})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), leaseTimeout)
+	defer cancel()
+	target := filed.Owner.URL + "/api/documents/" + url.PathEscape(filed.DocID) + "/folder"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Host = r.Host
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", r.Header.Get("Authorization"))
+	request.Header.Set(forwardedHeader, manager.Node.ID)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("owner %s answered %s", filed.Owner.ID, response.Status)
+	}
+	metrics.ForwardedConnections.Inc()
+	return nil
+}
+
+// renewLeases keeps the leases of rooms with clients on this node and
+// gives up the rest. A room whose lease went to another node meanwhile is
+// disconnected, so its clients reconnect through the new owner.
+func (manager *WebSocketManager) renewLeases() {
+	interval := manager.Config.Cluster.LeaseTTL / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		manager.leases.mutex.Lock()
+		acquired := maps.Clone(manager.leases.acquired)
+		manager.leases.mutex.Unlock()
+
+		for docID, since := range acquired {
+			// A room just claimed may not have its first client yet
+			if len(manager.roomMembers(docID)) == 0 && time.Since(since) >= interval {
+				manager.releaseLease(docID)
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), leaseTimeout)
+			held, err := manager.Owners.Renew(ctx, docID, manager.Node, manager.Config.Cluster.LeaseTTL)
+			cancel()
+			if err != nil {
+				manager.Logger.Warn("Could not renew document lease", "doc_id", docID, "error", err)
+				continue
+			}
+			if !held {
+				manager.Logger.Warn("Lost ownership of document", "doc_id", docID)
+				manager.dropLease(docID)
+				for _, member := range manager.roomMembers(docID) {
+					manager.disconnect(member, CloseOwnerChanged, "another node took the document over, reconnect")
+				}
+			}
+		}
+	}
+}
+
+// releaseLease gives up the lease on docID
+func (manager *WebSocketManager) releaseLease(docID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), leaseTimeout)
+	defer cancel()
+	if _, err := manager.Owners.Release(ctx, docID, manager.Node); err != nil {
+		manager.Logger.Warn("Could not release document lease", "doc_id", docID, "error", err)
+	}
+	manager.dropLease(docID)
+}
+
+func (manager *WebSocketManager) dropLease(docID string) {
+	manager.leases.mutex.Lock()
+	defer manager.leases.mutex.Unlock()
+	if _, held := manager.leases.acquired[docID]; held {
+		delete(manager.leases.acquired, docID)
+		metrics.OwnedDocuments.Dec()
+	}
+}
+
+// releaseLeases gives up every lease this node holds, when shutting down
+func (manager *WebSocketManager) releaseLeases() {
+	manager.leases.mutex.Lock()
+	acquired := maps.Clone(manager.leases.acquired)
+	manager.leases.mutex.Unlock()
+	for docID := range acquired {
+		manager.releaseLease(docID)
+	}
+}
@@ -0,0 +1,104 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+
+	"backend/document"
+)
+
+// ErrCodeForbidden answers messages the sender isn't allowed to send
+const ErrCodeForbidden = "forbidden"
+
+type exportPolicyMessage struct {
+	Data struct {
+		Policy string `json:"policy"`
+	} `json:"data"`
+}
+
+// handleExportPolicy lets the owner choose who may export the document,
+// then tells everyone in the room what they can do now
+func (manager *WebSocketManager) handleExportPolicy(client *Client, message []byte) {
+	var request exportPolicyMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "export-policy requires a data.policy string")
+		return
+	}
+
+	err := client.Doc.SetExportPolicy(client.ID, request.Data.Policy)
+	if errors.Is(err, document.ErrNotOwner) {
+		manager.sendError(client, ErrCodeForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+	client.Logger.Info("Export policy changed", "policy", request.Data.Policy)
+	manager.auditPermissions(client.DocID, client.ID, "exportPolicy", request.Data.Policy)
+	manager.sendRoomCapabilities(client.DocID)
+}
+
+type editModeMessage struct {
+	Data struct {
+		Mode string `json:"mode"`
+	} `json:"data"`
+}
+
+// handleEditMode lets the owner lock the document or take suggestions
+// instead of edits from everyone else, then tells everyone in the room
+// what they can do now
+func (manager *WebSocketManager) handleEditMode(client *Client, message []byte) {
+	var request editModeMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "edit-mode requires a data.mode string")
+		return
+	}
+
+	err := client.Doc.SetMode(client.ID, request.Data.Mode)
+	if errors.Is(err, document.ErrNotOwner) {
+		manager.sendError(client, ErrCodeForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+	client.Logger.Info("Edit mode changed", "mode", request.Data.Mode)
+	manager.auditPermissions(client.DocID, client.ID, "mode", request.Data.Mode)
+	manager.sendRoomCapabilities(client.DocID)
+}
+
+// sendRoomCapabilities tells everyone in a room what they may do after
+// the document's permissions changed
+func (manager *WebSocketManager) sendRoomCapabilities(docID string) {
+	for _, member := range manager.roomMembers(docID) {
+		manager.sendCapabilities(member)
+	}
+}
+
+// roomMembers returns the clients in a room
+func (manager *WebSocketManager) roomMembers(docID string) []*Client {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	room := make([]*Client, 0, len(manager.Rooms[docID]))
+	for member := range manager.Rooms[docID] {
+		room = append(room, member)
+	}
+	return room
+}
+
+// sendCapabilities tells a client what its user may do with the document.
+// Clients that joined through a view link may do no more than view it.
+func (manager *WebSocketManager) sendCapabilities(client *Client) {
+	capabilities := client.Doc.Capabilities(client.ID)
+	if client.ViewOnly {
+		capabilities.Edit = false
+		capabilities.Suggest = false
+	}
+	manager.sendMessage(client, Message{
+		Type: "capabilities",
+		Data: capabilities,
+	})
+}
@@ -0,0 +1,148 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"backend/events"
+	"backend/presence"
+)
+
+// RosterData is the payload of a presence-roster message: the user data of
+// every user currently in the room, the receiving client's included, with
+// a tabs count of their connections to it
+type RosterData struct {
+	Users []map[string]string `json:"users"`
+}
+
+// roster builds the presence-roster message for a room
+func (manager *WebSocketManager) roster(docID string) Message {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	users := make([]map[string]string, 0, len(manager.Rooms[docID]))
+	for client := range manager.Rooms[docID] {
+		if client.ImpersonatedBy != "" || client.Spectator {
+			continue
+		}
+		users = append(users, client.Data["userData"])
+	}
+	return Message{
+		Type: "presence-roster",
+		Data: RosterData{Users: presence.Group(users)},
+	}
+}
+
+// presenceChange is the payload of user-added and user-removed for a
+// client, whose tabs count is that of its user's connections to the room
+// after the change. A user-removed with tabs left only means a tab closed.
+func (manager *WebSocketManager) presenceChange(client *Client) map[string]map[string]string {
+	tabs := manager.userTabs(client)
+
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+	return map[string]map[string]string{"userData": presence.WithTabs(client.Data["userData"], tabs)}
+}
+
+// userTabs counts the connections the client's user has open in its room,
+// among spectators for a spectator and among the others otherwise
+func (manager *WebSocketManager) userTabs(client *Client) int {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	tabs := 0
+	for other := range manager.Rooms[client.DocID] {
+		if other.ID == client.ID && other.ImpersonatedBy == "" && other.Spectator == client.Spectator {
+			tabs++
+		}
+	}
+	return tabs
+}
+
+// broadcastRosters queues each room its current roster behind the
+// messages already queued for it
+func (manager *WebSocketManager) broadcastRosters() {
+	manager.Mutex.RLock()
+	docIDs := make([]string, 0, len(manager.Rooms))
+	for docID := range manager.Rooms {
+		docIDs = append(docIDs, docID)
+	}
+	manager.Mutex.RUnlock()
+
+	for _, docID := range docIDs {
+		jsonData, err := json.Marshal(manager.roster(docID))
+		if err != nil {
+			manager.Logger.Error("Error marshalling presence roster", "doc_id", docID, "error", err)
+			continue
+		}
+		manager.queueRoomBroadcast(&BroadcastMessage{DocID: docID, Data: jsonData})
+	}
+}
+
+// Timeout for a single presence store round trip
+const presenceTimeout = 2 * time.Second
+
+func (manager *WebSocketManager) presenceEntry(client *Client) presence.Entry {
+	return presence.Entry{
+		DocID:    client.DocID,
+		ConnID:   client.ConnID,
+		UserData: manager.clientData(client)["userData"],
+	}
+}
+
+// logPresence appends a client's presence change to the event log
+func (manager *WebSocketManager) logPresence(eventType string, client *Client) {
+	manager.logEvent(eventType, client.DocID, client.ID, events.Presence{
+		ConnID:   client.ConnID,
+		UserID:   client.ID,
+		UserData: manager.clientData(client)["userData"],
+	})
+}
+
+// presenceJoin publishes or updates a client's entry in the presence store
+func (manager *WebSocketManager) presenceJoin(client *Client) {
+	ctx, cancel := context.WithTimeout(client.ctx, presenceTimeout)
+	defer cancel()
+	if err := manager.Presence.Join(ctx, manager.presenceEntry(client)); err != nil {
+		client.Logger.Warn("Could not publish presence", "error", err)
+	}
+}
+
+// presenceLeave runs after the client is removed, so outside its context
+func (manager *WebSocketManager) presenceLeave(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	if err := manager.Presence.Leave(ctx, client.DocID, client.ConnID); err != nil {
+		client.Logger.Warn("Could not remove presence", "error", err)
+	}
+}
+
+// refreshPresence keeps this node's entries alive in the presence store
+// until they are removed or the node stops refreshing them
+func (manager *WebSocketManager) refreshPresence() {
+	ticker := time.NewTicker(manager.Config.PresenceTTL / 3)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		manager.Mutex.RLock()
+		entries := make([]presence.Entry, 0, len(manager.Clients))
+		for client := range manager.Clients {
+			if client.ImpersonatedBy != "" || client.Spectator {
+				continue
+			}
+			entries = append(entries, presence.Entry{
+				DocID:    client.DocID,
+				ConnID:   client.ConnID,
+				UserData: client.Data["userData"],
+			})
+		}
+		manager.Mutex.RUnlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+		if err := manager.Presence.Refresh(ctx, entries); err != nil {
+			manager.Logger.Warn("Could not refresh presence", "error", err)
+		}
+		cancel()
+	}
+}
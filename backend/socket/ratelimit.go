@@ -0,0 +1,71 @@
+package socket
+
+import (
+	"time"
+
+	"backend/config"
+	"backend/ratelimit"
+)
+
+// Window in which the mutes of a client are counted towards MaxMutes
+const muteWindow = time.Minute
+
+// rateLimiter keeps one bucket per message type for a single client. It is
+// only used from the client's read goroutine, so it needs no locking.
+type rateLimiter struct {
+	limits       map[string]config.RateLimit
+	buckets      map[string]*ratelimit.Bucket
+	muteDuration time.Duration
+	mutedUntil   time.Time
+
+	// mutes counts the mutes since firstMute, within muteWindow
+	mutes     int
+	firstMute time.Time
+}
+
+func newRateLimiter(limits config.Limits) *rateLimiter {
+	return &rateLimiter{
+		limits:       limits.RateLimits,
+		buckets:      make(map[string]*ratelimit.Bucket),
+		muteDuration: limits.MuteDuration,
+	}
+}
+
+// Allow reports whether a message of the given type may be processed.
+// violated is true only for the message that triggered a new mute.
+func (limiter *rateLimiter) Allow(messageType string, now time.Time) (allowed bool, violated bool) {
+	if now.Before(limiter.mutedUntil) {
+		return false, false
+	}
+
+	key := messageType
+	if _, ok := limiter.limits[key]; !ok {
+		key = config.DefaultRateLimitKey
+	}
+	limit, ok := limiter.limits[key]
+	if !ok {
+		return true, false
+	}
+
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = ratelimit.NewBucket(limit, now)
+		limiter.buckets[key] = bucket
+	}
+	if bucket.Allow(now) {
+		return true, false
+	}
+
+	limiter.mutedUntil = now.Add(limiter.muteDuration)
+	if now.Sub(limiter.firstMute) > muteWindow {
+		limiter.mutes = 0
+		limiter.firstMute = now
+	}
+	limiter.mutes++
+	return false, true
+}
+
+// Mutes is how many times the client was muted within the last window
+func (limiter *rateLimiter) Mutes() int {
+	return limiter.mutes
+}
@@ -0,0 +1,123 @@
+package socket
+
+import (
+	"encoding/json"
+	"unicode"
+	"unicode/utf8"
+
+	"backend/document"
+)
+
+// maxEmojiLength bounds an emoji in runes, leaving room for skin tones and
+// joined sequences
+const maxEmojiLength = 8
+
+// ReactionData is the payload of reaction-updated and an entry of
+// reactions: how many users reacted to a range with each emoji, and who.
+// A reaction with no counts left is gone.
+type ReactionData struct {
+	ID     string              `json:"id"`
+	Range  document.Range      `json:"range"`
+	Counts map[string]int      `json:"counts"`
+	Users  map[string][]string `json:"users"`
+}
+
+func reactionData(reaction document.Reaction) ReactionData {
+	users := reaction.Users
+	if users == nil {
+		users = make(map[string][]string)
+	}
+	return ReactionData{ID: reaction.ID, Range: reaction.Range, Counts: reaction.Counts(), Users: users}
+}
+
+type reactionAddMessage struct {
+	Data struct {
+		Revision int64          `json:"revision"`
+		Range    document.Range `json:"range"`
+		Emoji    string         `json:"emoji"`
+	} `json:"data"`
+}
+
+type reactionRemoveMessage struct {
+	Data struct {
+		ID    string `json:"id"`
+		Emoji string `json:"emoji"`
+	} `json:"data"`
+}
+
+// validEmoji reports whether s could be a single emoji: a short run of
+// symbols, not words or whitespace
+func validEmoji(s string) bool {
+	n := utf8.RuneCountInString(s)
+	if n == 0 || n > maxEmojiLength || !utf8.ValidString(s) {
+		return false
+	}
+	ascii := true
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		if r >= utf8.RuneSelf {
+			ascii = false
+		}
+	}
+	return !ascii
+}
+
+// handleReactionAdd reacts with an emoji to a range of the document as the
+// client saw it at revision and tells the room the new counts
+func (manager *WebSocketManager) handleReactionAdd(client *Client, message []byte) {
+	var request reactionAddMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "reaction-add requires data.revision, data.range and data.emoji")
+		return
+	}
+	if !validEmoji(request.Data.Emoji) {
+		manager.sendError(client, ErrCodeInvalidMessage, "reaction-add requires a single emoji")
+		return
+	}
+	reaction, err := client.Doc.AddReaction(client.ID, request.Data.Revision, request.Data.Range, request.Data.Emoji)
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+	manager.broadcastReaction(client.DocID, reaction)
+}
+
+// handleReactionRemove takes back the client's emoji from a reaction and
+// tells the room the new counts
+func (manager *WebSocketManager) handleReactionRemove(client *Client, message []byte) {
+	var request reactionRemoveMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "reaction-remove requires data.id and data.emoji strings")
+		return
+	}
+	reaction, err := client.Doc.RemoveReaction(client.ID, request.Data.ID, request.Data.Emoji)
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+	manager.broadcastReaction(client.DocID, reaction)
+}
+
+func (manager *WebSocketManager) broadcastReaction(docID string, reaction document.Reaction) {
+	payload, err := json.Marshal(Message{Type: "reaction-updated", Data: reactionData(reaction)})
+	if err != nil {
+		manager.Logger.Error("Error marshalling reaction-updated message", "doc_id", docID, "error", err)
+		return
+	}
+	manager.BroadcastToRoom(docID, payload)
+}
+
+// sendReactions gives a joining client the reactions on the document
+func (manager *WebSocketManager) sendReactions(client *Client) {
+	current := client.Doc.Reactions()
+	reactions := make([]ReactionData, 0, len(current))
+	for _, reaction := range current {
+		reactions = append(reactions, reactionData(reaction))
+	}
+	manager.sendMessage(client, Message{
+		Type: "reactions",
+		Data: map[string][]ReactionData{"reactions": reactions},
+	})
+}
@@ -0,0 +1,166 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"backend/document"
+
+	"github.com/gorilla/websocket"
+)
+
+// Longest pause between replayed ops. Stretches where nobody typed are
+// shortened to it so a playback never stalls.
+const maxReplayPause = 2 * time.Second
+
+// Replay is a stretch of a document's history to play back: the ops from
+// revision From through To, played at Speed times the pace they were made
+// at. Ops starts with the op at From unless From is zero, the empty
+// document.
+type Replay struct {
+	DocID string
+	From  int64
+	To    int64
+	Speed float64
+	Ops   []document.Op
+}
+
+// replayStart is the first message of a playback. Frame is the op that
+// reached From, whose content is where the playback starts.
+type replayStart struct {
+	DocID string          `json:"docId"`
+	From  int64           `json:"from"`
+	To    int64           `json:"to"`
+	Speed float64         `json:"speed"`
+	Frame json.RawMessage `json:"frame,omitempty"`
+}
+
+// replayOp is a replayed op: the frame that relayed it to the room, with
+// who made it and when
+type replayOp struct {
+	Revision int64           `json:"revision"`
+	Author   string          `json:"author"`
+	Time     time.Time       `json:"time"`
+	Frame    json.RawMessage `json:"frame,omitempty"`
+}
+
+// replayEnd is the last message of a playback that ran to the end
+type replayEnd struct {
+	Revision int64 `json:"revision"`
+}
+
+// StreamReplay plays back replay over a WebSocket when the request asks to
+// upgrade, or as Server-Sent Events otherwise. It returns when the
+// playback ends or the client goes away.
+func (manager *WebSocketManager) StreamReplay(w http.ResponseWriter, r *http.Request, replay Replay) {
+	if websocket.IsWebSocketUpgrade(r) {
+		manager.replayWebSocket(w, r, replay)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	writeTimeout := manager.Config.Limits.WriteTimeout
+	manager.playReplay(r.Context(), replay, func(message []byte) error {
+		controller.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+func (manager *WebSocketManager) replayWebSocket(w http.ResponseWriter, r *http.Request, replay Replay) {
+	conn, _, _, err := manager.upgrade(w, r, nil)
+	if err != nil {
+		manager.Logger.Warn("WebSocket upgrade error", "remote_ip", manager.Proxies.ClientIP(r), "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// Nothing is expected from the client; reading only notices it leave
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	writeTimeout := manager.Config.Limits.WriteTimeout
+	finished := manager.playReplay(ctx, replay, func(message []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return conn.WriteMessage(websocket.TextMessage, message)
+	})
+	if finished {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay finished"))
+	}
+}
+
+// playReplay sends each message of a playback in turn, waiting between ops
+// as long as their author did, scaled by the speed and capped at
+// maxReplayPause. It reports whether the playback ran to the end.
+func (manager *WebSocketManager) playReplay(ctx context.Context, replay Replay, send func(message []byte) error) bool {
+	ops := replay.Ops
+	start := replayStart{DocID: replay.DocID, From: replay.From, To: replay.To, Speed: replay.Speed}
+	var last time.Time
+	if replay.From > 0 && len(ops) > 0 {
+		start.Frame = ops[0].Payload
+		last = ops[0].Time
+		ops = ops[1:]
+	}
+	if !manager.sendReplay(replay, send, "replay-start", start) {
+		return false
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for _, op := range ops {
+		pause := time.Duration(float64(op.Time.Sub(last)) / replay.Speed)
+		if last.IsZero() || pause < 0 {
+			pause = 0
+		}
+		timer.Reset(min(pause, maxReplayPause))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return false
+		}
+		last = op.Time
+		message := replayOp{Revision: op.Revision, Author: op.Author, Time: op.Time, Frame: op.Payload}
+		if !manager.sendReplay(replay, send, "replay-op", message) {
+			return false
+		}
+	}
+	return manager.sendReplay(replay, send, "replay-end", replayEnd{Revision: replay.To})
+}
+
+func (manager *WebSocketManager) sendReplay(replay Replay, send func(message []byte) error, messageType string, data any) bool {
+	message, err := json.Marshal(Message{Type: messageType, Data: data})
+	if err != nil {
+		manager.Logger.Error("Error marshalling replay message", "doc_id", replay.DocID, "type", messageType, "error", err)
+		return false
+	}
+	if err := send(message); err != nil {
+		manager.Logger.Debug("Replay client went away", "doc_id", replay.DocID, "error", err)
+		return false
+	}
+	return true
+}
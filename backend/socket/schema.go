@@ -0,0 +1,226 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ErrCodeUnknownType answers a message whose type the server doesn't handle
+const ErrCodeUnknownType = "unknown-type"
+
+// kind is the JSON type a message field must have
+type kind string
+
+const (
+	kindString  kind = "string"
+	kindNumber  kind = "number"
+	kindBoolean kind = "boolean"
+	kindArray   kind = "array"
+	kindObject  kind = "object"
+)
+
+type field struct {
+	kind     kind
+	required bool
+}
+
+// schema lists the fields a message type may carry in its data object.
+// Nested objects are checked by the handlers that decode them.
+type schema map[string]field
+
+var schemas = map[string]schema{
+	"user-renamed": {
+		"userData": {kindObject, true},
+	},
+	"awareness-update": {
+		"awareness": {kindObject, true},
+	},
+	"follow-request": {
+		"userId": {kindString, true},
+	},
+	"viewport": {
+		"scroll": {kindNumber, true},
+		"index":  {kindNumber, false},
+	},
+	"reaction-add": {
+		"revision": {kindNumber, true},
+		"range":    {kindObject, true},
+		"emoji":    {kindString, true},
+	},
+	"reaction-remove": {
+		"id":    {kindString, true},
+		"emoji": {kindString, true},
+	},
+	"content": {
+		"content":      {kindString, false},
+		"delta":        {kindArray, false},
+		"baseRevision": {kindNumber, false},
+		"revision":     {kindNumber, false},
+		"seq":          {kindNumber, false},
+		"position":     {kindObject, false},
+		"userData":     {kindObject, false},
+		"session":      {kindObject, false},
+	},
+	"ack": {
+		"revision": {kindNumber, true},
+	},
+	"chat": {
+		"text": {kindString, true},
+	},
+	"typing": {
+		"typing": {kindBoolean, true},
+	},
+	"doc-metadata": {
+		"language":  {kindString, false},
+		"direction": {kindString, false},
+	},
+	"doc-meta-update": {
+		"title":       {kindString, false},
+		"description": {kindString, false},
+		"tags":        {kindArray, false},
+	},
+	"export-policy": {
+		"policy": {kindString, true},
+	},
+	"edit-mode": {
+		"mode": {kindString, true},
+	},
+	"suggestion-accept": {
+		"id": {kindString, true},
+	},
+	"suggestion-reject": {
+		"id": {kindString, true},
+	},
+	"track-changes": {
+		"enabled": {kindBoolean, true},
+	},
+	"analysis": {
+		"enabled": {kindBoolean, true},
+	},
+	"attribution": {
+		"enabled": {kindBoolean, true},
+	},
+	"moderate": {
+		"action":   {kindString, true},
+		"userId":   {kindString, true},
+		"duration": {kindString, false},
+		"reason":   {kindString, false},
+	},
+	"comment-add": {
+		"revision": {kindNumber, true},
+		"start":    {kindNumber, true},
+		"end":      {kindNumber, true},
+		"text":     {kindString, true},
+	},
+	"activity": {},
+	"ping":     {},
+	"latency": {
+		"id":         {kindString, true},
+		"clientTime": {kindNumber, true},
+	},
+	"chunk-start": {
+		"id":   {kindString, true},
+		"size": {kindNumber, true},
+	},
+	"chunk-part": {
+		"id":   {kindString, true},
+		"part": {kindString, true},
+	},
+	"chunk-end": {
+		"id": {kindString, true},
+	},
+}
+
+// schemaError describes the first part of a message that doesn't match its
+// type's schema
+type schemaError struct {
+	Code    string
+	Field   string
+	Message string
+}
+
+func (err *schemaError) Error() string {
+	return err.Message
+}
+
+func invalidField(name string, format string, args ...any) *schemaError {
+	return &schemaError{Code: ErrCodeInvalidMessage, Field: name, Message: name + " " + fmt.Sprintf(format, args...)}
+}
+
+// validateMessage checks a message of type msgType against its schema. A
+// message must be an object with only type and data keys, and data must
+// only hold the fields its type declares.
+func validateMessage(msgType string, message []byte) *schemaError {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(message, &envelope); err != nil || envelope == nil {
+		return &schemaError{Code: ErrCodeInvalidMessage, Message: "messages must be JSON objects"}
+	}
+	if msgType == "" {
+		return invalidField("type", "must be a non-empty string")
+	}
+	fields, ok := schemas[msgType]
+	if !ok {
+		return &schemaError{Code: ErrCodeUnknownType, Field: "type", Message: fmt.Sprintf("unknown message type %q", msgType)}
+	}
+	for key := range envelope {
+		if key != "type" && key != "data" {
+			return invalidField(key, "is not allowed")
+		}
+	}
+
+	var data map[string]json.RawMessage
+	if kindOf(envelope["data"]) != kindObject || json.Unmarshal(envelope["data"], &data) != nil {
+		return invalidField("data", "must be an object")
+	}
+	for key, value := range data {
+		spec, ok := fields[key]
+		if !ok {
+			return invalidField("data."+key, "is not allowed in %s messages", msgType)
+		}
+		if kindOf(value) != spec.kind {
+			return invalidField("data."+key, "must be %s %s", article(spec.kind), spec.kind)
+		}
+	}
+	for key, spec := range fields {
+		if _, ok := data[key]; spec.required && !ok {
+			return invalidField("data."+key, "is required in %s messages", msgType)
+		}
+	}
+	return nil
+}
+
+// kindOf returns the JSON type of a raw value, or "" for null and missing
+// values
+func kindOf(raw json.RawMessage) kind {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return ""
+	}
+	switch raw[0] {
+	case '"':
+		return kindString
+	case '{':
+		return kindObject
+	case '[':
+		return kindArray
+	case 't', 'f':
+		return kindBoolean
+	case 'n':
+		return ""
+	}
+	return kindNumber
+}
+
+func article(k kind) string {
+	if k == kindArray || k == kindObject {
+		return "an"
+	}
+	return "a"
+}
+
+// sendSchemaError replies to a message that failed validation, naming the
+// offending field so the sender can tell what to fix
+func (manager *WebSocketManager) sendSchemaError(client *Client, err *schemaError) {
+	manager.sendMessage(client, errorMessage(ErrorData{Code: err.Code, Message: err.Message, Field: err.Field}))
+}
@@ -0,0 +1,65 @@
+package socket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"backend/document"
+)
+
+// How long seen markers that moved are gathered before the room is told,
+// so a burst of acks after an edit makes one seen-state
+const seenStateDelay = time.Second
+
+// SeenStateData is the payload of seen-state: the document's revision and
+// how far each user who opened it has seen it
+type SeenStateData struct {
+	Revision int64                 `json:"revision"`
+	Users    []document.SeenMarker `json:"users"`
+}
+
+// seenStates holds the rooms with a seen-state waiting to be sent
+type seenStates struct {
+	mutex   sync.Mutex
+	pending map[string]bool
+}
+
+// SeenState returns doc's seen-state
+func SeenState(doc *document.Document) SeenStateData {
+	return SeenStateData{Revision: doc.Revision(), Users: doc.Seen()}
+}
+
+// markSeen moves the client's user's marker up to revision, telling the
+// room shortly after. Impersonated views see nothing on the user's behalf,
+// and bots read nothing.
+func (manager *WebSocketManager) markSeen(client *Client, revision int64) {
+	if client.ImpersonatedBy != "" || client.Bot || !client.Doc.MarkSeen(client.ID, revision) {
+		return
+	}
+
+	states := manager.seen
+	states.mutex.Lock()
+	defer states.mutex.Unlock()
+	if states.pending[client.DocID] {
+		return
+	}
+	states.pending[client.DocID] = true
+	docID, doc := client.DocID, client.Doc
+	time.AfterFunc(seenStateDelay, func() {
+		states.mutex.Lock()
+		delete(states.pending, docID)
+		states.mutex.Unlock()
+
+		payload, err := json.Marshal(Message{Type: "seen-state", Data: SeenState(doc)})
+		if err != nil {
+			manager.Logger.Error("Error marshalling seen-state message", "doc_id", docID, "error", err)
+			return
+		}
+		manager.BroadcastToRoom(docID, payload)
+	})
+}
+
+func (manager *WebSocketManager) sendSeenState(client *Client) {
+	manager.sendMessage(client, Message{Type: "seen-state", Data: SeenState(client.Doc)})
+}
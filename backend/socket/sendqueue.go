@@ -0,0 +1,184 @@
+package socket
+
+import (
+	"sync"
+
+	"backend/metrics"
+)
+
+// lane is a priority level of outbound messages, highest first
+type lane int
+
+const (
+	laneControl lane = iota
+	laneEdits
+	lanePresence
+	laneCursors
+	laneCount
+)
+
+// lanes places each message type in a lane. Types not listed, which
+// includes edits, document state and chat, go in laneEdits.
+var lanes = map[string]lane{
+	"error":           laneControl,
+	"server-notice":   laneControl,
+	"resync-required": laneControl,
+	"room-migrated":   laneControl,
+	"rate-limited":    laneControl,
+	"idle-warning":    laneControl,
+	"user-moderated":  laneControl,
+	"impersonation":   laneControl,
+	"capabilities":    laneControl,
+	"user-data":       laneControl,
+	"ping":            laneControl,
+	"latency":         laneControl,
+
+	"user-added":       lanePresence,
+	"user-removed":     lanePresence,
+	"user-renamed":     lanePresence,
+	"presence-roster":  lanePresence,
+	"awareness-update": lanePresence,
+	"typing":           lanePresence,
+	"viewer-count":     lanePresence,
+	"followers":        lanePresence,
+	"follow-ended":     lanePresence,
+	"seen-state":       lanePresence,
+
+	"viewport": laneCursors,
+}
+
+func laneOf(msgType string) lane {
+	if lane, ok := lanes[msgType]; ok {
+		return lane
+	}
+	return laneEdits
+}
+
+// SendQueue holds the messages waiting to be written to a client, in one
+// lane per priority of up to size messages each. Write pumps take control
+// messages first, then edits, presence and cursors, so a flood of cursor
+// updates never holds up a document sync or the close frame. A full
+// cursor lane makes room by dropping its oldest message; any other full
+// lane refuses the message and leaves it to the backpressure policy.
+type SendQueue struct {
+	mutex  sync.Mutex
+	lanes  [laneCount][][]byte
+	size   int
+	closed bool
+
+	// ready is signalled whenever there is something to take or the
+	// queue is closed
+	ready chan struct{}
+}
+
+func NewSendQueue(size int) *SendQueue {
+	return &SendQueue{size: size, ready: make(chan struct{}, 1)}
+}
+
+// Push queues message, reporting false if its lane is full or the queue
+// is closed
+func (queue *SendQueue) Push(message []byte) bool {
+	return queue.push(message, laneOf(messageType(message)))
+}
+
+func (queue *SendQueue) push(message []byte, lane lane) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed {
+		return false
+	}
+	if len(queue.lanes[lane]) >= queue.size {
+		if lane != laneCursors {
+			return false
+		}
+		queue.lanes[lane] = queue.lanes[lane][1:]
+		metrics.BackpressureActions.WithLabelValues("dropped").Inc()
+	}
+	queue.lanes[lane] = append(queue.lanes[lane], message)
+	queue.signal()
+	return true
+}
+
+// Close stops the queue taking messages. Control messages already queued
+// can still be taken; the rest are dropped.
+func (queue *SendQueue) Close() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.closed = true
+	for lane := laneEdits; lane < laneCount; lane++ {
+		queue.lanes[lane] = nil
+	}
+	queue.signal()
+}
+
+// Closed reports whether the queue was closed
+func (queue *SendQueue) Closed() bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.closed
+}
+
+// Ready receives when there may be something to take or the queue has
+// been closed
+func (queue *SendQueue) Ready() <-chan struct{} {
+	return queue.ready
+}
+
+// Pop takes the message of highest priority without waiting, reporting
+// false if there is none
+func (queue *SendQueue) Pop() ([]byte, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for lane := range queue.lanes {
+		if len(queue.lanes[lane]) == 0 {
+			continue
+		}
+		message := queue.lanes[lane][0]
+		queue.lanes[lane][0] = nil
+		queue.lanes[lane] = queue.lanes[lane][1:]
+		// Whatever is left stays signalled for the next wait
+		queue.signal()
+		return message, true
+	}
+	if queue.closed {
+		queue.signal()
+	}
+	return nil, false
+}
+
+// Next waits for the message of highest priority, reporting false once
+// the queue is closed and has nothing left to take
+func (queue *SendQueue) Next() ([]byte, bool) {
+	for {
+		if message, ok := queue.Pop(); ok {
+			return message, true
+		}
+		if queue.Closed() {
+			return nil, false
+		}
+		<-queue.ready
+	}
+}
+
+// Pressed reports whether any lane is more than half full
+func (queue *SendQueue) Pressed() bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for _, messages := range queue.lanes {
+		if len(messages) > queue.size/2 {
+			return true
+		}
+	}
+	return false
+}
+
+func (queue *SendQueue) signal() {
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
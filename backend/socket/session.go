@@ -0,0 +1,409 @@
+package socket
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"backend/events"
+	"backend/ids"
+	"backend/users"
+)
+
+const maxUserNameLength = 32
+
+// Session is the identity a browser keeps across reconnects. The session ID
+// is a secret only ever sent to its owner; UserID is the public identifier
+// shared with the room.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	UserName  string    `json:"userName"`
+	UserColor string    `json:"userColor"`
+	AvatarURL string    `json:"avatarUrl,omitempty"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	// Tenant is the tenant the session acts for: its account's, or for a
+	// guest the one it was started on
+	Tenant string `json:"tenant,omitempty"`
+
+	// Acks holds the last revision applied by the client, per document
+	Acks map[string]int64 `json:"acks"`
+
+	// Seqs holds the sequence number of the last edit received from the
+	// client, per document
+	Seqs map[string]int64 `json:"seqs,omitempty"`
+}
+
+// clone copies a session so the copy's maps can be changed on their own
+func (session Session) clone() Session {
+	cloned := session
+	cloned.Acks = make(map[string]int64, len(session.Acks))
+	for docID, revision := range session.Acks {
+		cloned.Acks[docID] = revision
+	}
+	cloned.Seqs = make(map[string]int64, len(session.Seqs))
+	for docID, seq := range session.Seqs {
+		cloned.Seqs[docID] = seq
+	}
+	return cloned
+}
+
+// UserData is the public presence payload for the session's user
+func (session Session) UserData() map[string]string {
+	userData := map[string]string{
+		"userId":    session.UserID,
+		"userName":  session.UserName,
+		"userColor": session.UserColor,
+	}
+	if session.AvatarURL != "" {
+		userData["avatarUrl"] = session.AvatarURL
+	}
+	return userData
+}
+
+type SessionStore struct {
+	mutex     sync.Mutex
+	sessions  map[string]*Session
+	ttl       time.Duration
+	lastPrune time.Time
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		sessions: make(map[string]*Session),
+		ttl:      ttl,
+	}
+}
+
+// Resume returns the live session with the given ID, or a fresh session
+// when the ID is empty, unknown or expired. resumed reports which of the
+// two happened. A fresh session is for userID in tenant, or a new identity
+// when userID is empty, and shows the profile of the user's most recent
+// live session; without one, guest names and colors it.
+func (store *SessionStore) Resume(id string, userID string, tenant string, guest func(userID string) (name string, color string)) (session Session, resumed bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	store.prune(now)
+
+	if existing, ok := store.sessions[id]; ok && now.Sub(existing.LastSeen) < store.ttl {
+		existing.LastSeen = now
+		return existing.clone(), true
+	}
+
+	if userID == "" {
+		userID = ids.NewUUID()
+	}
+	created := &Session{
+		ID:       NewSessionID(),
+		UserID:   userID,
+		LastSeen: now,
+		Tenant:   tenant,
+		Acks:     make(map[string]int64),
+		Seqs:     make(map[string]int64),
+	}
+	var latest *Session
+	for _, existing := range store.sessions {
+		if existing.UserID == userID && (latest == nil || existing.LastSeen.After(latest.LastSeen)) {
+			latest = existing
+		}
+	}
+	if latest != nil {
+		created.UserName, created.UserColor, created.AvatarURL = latest.UserName, latest.UserColor, latest.AvatarURL
+	} else {
+		created.UserName, created.UserColor = guest(userID)
+	}
+	store.sessions[created.ID] = created
+	return *created, false
+}
+
+// Lookup returns a live session without creating one
+func (store *SessionStore) Lookup(id string) (Session, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, ok := store.sessions[id]
+	if !ok || time.Since(session.LastSeen) >= store.ttl {
+		return Session{}, false
+	}
+	return *session, true
+}
+
+// ForUser returns the user's live sessions, most recently active first
+func (store *SessionStore) ForUser(userID string) []Session {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var sessions []Session
+	for _, session := range store.sessions {
+		if session.UserID != userID || time.Since(session.LastSeen) >= store.ttl {
+			continue
+		}
+		sessions = append(sessions, session.clone())
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeen.After(sessions[j].LastSeen) })
+	return sessions
+}
+
+// Import adds a session handed over by another node. When the session is
+// known here too, the copy seen last wins and acks and edit sequence
+// numbers are merged.
+func (store *SessionStore) Import(session Session) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	existing, ok := store.sessions[session.ID]
+	if !ok {
+		imported := session.clone()
+		store.sessions[session.ID] = &imported
+		return
+	}
+	if session.LastSeen.After(existing.LastSeen) {
+		existing.UserName = session.UserName
+		existing.UserColor = session.UserColor
+		existing.LastSeen = session.LastSeen
+	}
+	for docID, revision := range session.Acks {
+		if revision > existing.Acks[docID] {
+			existing.Acks[docID] = revision
+		}
+	}
+	if existing.Seqs == nil {
+		existing.Seqs = make(map[string]int64)
+	}
+	for docID, seq := range session.Seqs {
+		if seq > existing.Seqs[docID] {
+			existing.Seqs[docID] = seq
+		}
+	}
+}
+
+// Touch records activity so the session's TTL counts from now
+func (store *SessionStore) Touch(id string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if session, ok := store.sessions[id]; ok {
+		session.LastSeen = time.Now()
+	}
+}
+
+// Rename changes the name of every session of userID
+func (store *SessionStore) Rename(userID string, name string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, session := range store.sessions {
+		if session.UserID == userID {
+			session.UserName = name
+		}
+	}
+}
+
+// Start returns a fresh session for an account signing in, showing its
+// profile
+func (store *SessionStore) Start(user users.User) Session {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	started := &Session{
+		ID:        NewSessionID(),
+		UserID:    user.ID,
+		UserName:  user.DisplayName,
+		UserColor: user.Color,
+		AvatarURL: user.AvatarURL,
+		LastSeen:  time.Now(),
+		Tenant:    user.Tenant,
+		Acks:      make(map[string]int64),
+		Seqs:      make(map[string]int64),
+	}
+	store.sessions[started.ID] = started
+	return *started
+}
+
+// SetProfile shows the profile of user on every session of theirs
+func (store *SessionStore) SetProfile(user users.User) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, session := range store.sessions {
+		if session.UserID == user.ID {
+			session.UserName = user.DisplayName
+			session.UserColor = user.Color
+			session.AvatarURL = user.AvatarURL
+		}
+	}
+}
+
+// Ack records that the session's client has applied revision of docID
+func (store *SessionStore) Ack(id string, docID string, revision int64) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if session, ok := store.sessions[id]; ok && revision > session.Acks[docID] {
+		session.Acks[docID] = revision
+	}
+}
+
+// Sequence checks the sequence number of an edit from the session's client
+// against the last one received for docID. The next number in line is
+// recorded; otherwise expected is the number that should have come
+// instead.
+func (store *SessionStore) Sequence(id string, docID string, seq int64) (expected int64, ok bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, found := store.sessions[id]
+	if !found {
+		return seq, true
+	}
+	expected = session.Seqs[docID] + 1
+	if seq != expected {
+		return expected, false
+	}
+	if session.Seqs == nil {
+		session.Seqs = make(map[string]int64)
+	}
+	session.Seqs[docID] = seq
+	return expected, true
+}
+
+// LastSeq returns the sequence number of the last edit received from the
+// session's client for docID, where its numbering carries on
+func (store *SessionStore) LastSeq(id string, docID string) int64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if session, ok := store.sessions[id]; ok {
+		return session.Seqs[docID]
+	}
+	return 0
+}
+
+// Acked returns the last revision of docID the session acknowledged
+func (store *SessionStore) Acked(id string, docID string) (int64, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, ok := store.sessions[id]
+	if !ok {
+		return 0, false
+	}
+	revision, ok := session.Acks[docID]
+	return revision, ok
+}
+
+// prune drops expired sessions at most once a minute
+func (store *SessionStore) prune(now time.Time) {
+	if now.Sub(store.lastPrune) < time.Minute {
+		return
+	}
+	store.lastPrune = now
+	for id, session := range store.sessions {
+		if now.Sub(session.LastSeen) >= store.ttl {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+// renameMessage is the inbound shape of user-renamed
+type renameMessage struct {
+	Data struct {
+		UserData struct {
+			UserName string `json:"userName"`
+		} `json:"userData"`
+	} `json:"data"`
+}
+
+// handleRename changes a client's display name and announces it to the room
+func (manager *WebSocketManager) handleRename(client *Client, message []byte) {
+	var rename renameMessage
+	if err := json.Unmarshal(message, &rename); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "user-renamed requires data.userData.userName")
+		return
+	}
+	name, ok := normalizeUserName(rename.Data.UserData.UserName)
+	if !ok {
+		manager.sendError(client, ErrCodeInvalidMessage, ErrInvalidUserName.Error())
+		return
+	}
+
+	manager.Sessions.Rename(client.ID, name)
+	manager.saveDisplayName(client, name)
+	rooms := make(map[string]bool)
+	for _, tab := range manager.userClients(client.ID) {
+		manager.setUserData(tab, map[string]string{"userName": name}, "user-renamed")
+		if !rooms[tab.DocID] && tab.ImpersonatedBy == "" {
+			rooms[tab.DocID] = true
+			manager.auditRename(tab.DocID, client.ID, "userName", name)
+			manager.emitEvent(events.TypeUserRenamed, tab.DocID, client.ID, events.UserRenamed{UserID: client.ID, UserName: name})
+		}
+	}
+	client.Logger.Info("User renamed", "user_name", name)
+}
+
+// setUserData changes fields of a client's presence data, empty values
+// removing them, and announces the result to its room as a message of
+// messageType
+func (manager *WebSocketManager) setUserData(client *Client, changes map[string]string, messageType string) {
+	manager.Mutex.Lock()
+	userData := make(map[string]string, len(client.Data["userData"]))
+	for key, value := range client.Data["userData"] {
+		userData[key] = value
+	}
+	for key, value := range changes {
+		if value == "" {
+			delete(userData, key)
+			continue
+		}
+		userData[key] = value
+	}
+	client.Data = map[string]map[string]string{"userData": userData}
+	manager.Mutex.Unlock()
+
+	jsonData, err := json.Marshal(Message{
+		Type: messageType,
+		Data: map[string]map[string]string{"userData": userData},
+	})
+	if err != nil {
+		client.Logger.Error("Error marshalling user data", "type", messageType, "error", err)
+		return
+	}
+	// Only the spectator itself learns of its new name
+	if client.Spectator {
+		if err := manager.sendToClient(client, jsonData); err != nil {
+			client.Logger.Warn("Could not send user data", "type", messageType, "error", err)
+		}
+		return
+	}
+	manager.presenceJoin(client)
+	manager.logPresence(events.TypePresenceUpdated, client)
+	manager.BroadcastToRoom(client.DocID, jsonData)
+}
+
+// clientData reads a client's presence data, which renames replace under
+// the manager lock
+func (manager *WebSocketManager) clientData(client *Client) map[string]map[string]string {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+	return client.Data
+}
+
+func normalizeUserName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxUserNameLength {
+		return "", false
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", false
+		}
+	}
+	return name, true
+}
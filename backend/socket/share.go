@@ -0,0 +1,69 @@
+package socket
+
+import (
+	"errors"
+	"time"
+
+	"backend/audit"
+	"backend/document"
+	"backend/share"
+)
+
+// ErrShareTTL is a share link asked to stay valid for longer than allowed
+var ErrShareTTL = errors.New("share link lifetime must be positive and within the configured maximum")
+
+// Messages clients that joined through a view link may still send
+var viewerMessages = map[string]bool{
+	"user-renamed":     true,
+	"awareness-update": true,
+	"ack":              true,
+	"chat":             true,
+	"typing":           true,
+	"follow-request":   true,
+	"viewport":         true,
+	"reaction-add":     true,
+	"reaction-remove":  true,
+	"attribution":      true,
+}
+
+// ShareLink issues a token for an invitation link to docID granting role
+// for ttl, on behalf of sharer, who must be the document's owner
+func (manager *WebSocketManager) ShareLink(docID string, sharer Session, role string, ttl time.Duration) (string, share.Grant, error) {
+	if ttl <= 0 || ttl > manager.Config.Share.MaxTTL {
+		return "", share.Grant{}, ErrShareTTL
+	}
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return "", share.Grant{}, err
+	}
+	if owner := doc.Permissions().Owner; owner == "" || owner != sharer.UserID {
+		return "", share.Grant{}, document.ErrNotOwner
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second).UTC()
+	token, err := manager.Shares.Issue(docID, role, expiresAt)
+	if err != nil {
+		return "", share.Grant{}, err
+	}
+	manager.Logger.Info("Share link issued", "doc_id", docID, "user_id", sharer.UserID, "role", role, "expires_at", expiresAt)
+	manager.Audit.Record(audit.Entry{
+		Action:  audit.ActionShared,
+		DocID:   docID,
+		UserID:  sharer.UserID,
+		Details: map[string]string{"role": role, "expiresAt": expiresAt.Format(time.RFC3339)},
+	})
+	return token, share.Grant{DocID: docID, Role: role, ExpiresAt: expiresAt}, nil
+}
+
+// verifyShare checks the share token a connection to docID presented, if
+// any, and reports whether it only grants viewing
+func (manager *WebSocketManager) verifyShare(token string, docID string) (viewOnly bool, refused *CloseError) {
+	if token == "" {
+		return false, nil
+	}
+	grant, err := manager.Shares.Verify(token, docID)
+	if err != nil {
+		return false, &CloseError{Reason: CloseShareInvalid, Details: err.Error()}
+	}
+	return grant.Role == share.RoleView, nil
+}
@@ -0,0 +1,38 @@
+package socket
+
+import (
+	"context"
+	"maps"
+	"slices"
+)
+
+// Shutdown turns new connections away and disconnects every client with
+// server-draining, so they reconnect once the server, or another node,
+// is back. It returns once their close frames are written or ctx ends.
+func (manager *WebSocketManager) Shutdown(ctx context.Context) {
+	manager.draining.Store(true)
+
+	manager.Mutex.RLock()
+	clients := slices.Collect(maps.Keys(manager.Clients))
+	manager.Mutex.RUnlock()
+	for _, client := range clients {
+		manager.disconnect(client, CloseServerDraining, "server is shutting down, reconnect shortly")
+	}
+	manager.closeBots(CloseServerDraining)
+
+	done := make(chan struct{})
+	go func() {
+		manager.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	// Whatever is still running for a client stops now
+	manager.stop()
+	if manager.clustered() {
+		manager.releaseLeases()
+	}
+	manager.Logger.Info("Clients disconnected for shutdown", "clients", len(clients))
+}
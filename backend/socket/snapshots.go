@@ -0,0 +1,36 @@
+package socket
+
+import (
+	"context"
+	"time"
+
+	"backend/events"
+	"backend/ids"
+	"backend/snapshots"
+)
+
+// CreateSnapshot archives the current state of a document under a new
+// random ID, which is what makes its permalink hard to guess
+func (manager *WebSocketManager) CreateSnapshot(ctx context.Context, docID string, author Session) (snapshots.Snapshot, error) {
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return snapshots.Snapshot{}, err
+	}
+	content, revision := doc.Contents()
+	snapshot := snapshots.Snapshot{
+		ID:        ids.RandomHex(16),
+		DocID:     docID,
+		Revision:  revision,
+		Content:   content,
+		Metadata:  doc.Metadata(),
+		CreatedAt: time.Now().UTC(),
+		CreatedBy: author.UserData(),
+	}
+	if err := manager.Snapshots.Create(ctx, snapshot); err != nil {
+		return snapshots.Snapshot{}, err
+	}
+
+	manager.emitEvent(events.TypeSnapshotCreated, docID, author.UserID,
+		events.SnapshotCreated{SnapshotID: snapshot.ID, Revision: revision})
+	return snapshot, nil
+}
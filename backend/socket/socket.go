@@ -1,248 +1,1152 @@
 package socket
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"backend/analysis"
+	"backend/audit"
+	"backend/backup"
+	"backend/blobs"
+	"backend/canary"
+	"backend/chat"
+	"backend/comments"
+	"backend/config"
+	"backend/document"
+	"backend/encryption"
+	"backend/events"
+	"backend/folders"
+	"backend/linkcheck"
+	"backend/mail"
+	"backend/metrics"
+	"backend/notifications"
+	"backend/oauth"
+	"backend/origins"
+	"backend/ownership"
+	"backend/presence"
+	"backend/proxies"
+	"backend/recording"
+	"backend/share"
+	"backend/similarity"
+	"backend/snapshots"
+	"backend/templates"
+	"backend/users"
+	"backend/webhooks"
+
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
-	Conn *websocket.Conn
-	Send chan []byte
-	ID   string
-	Data map[string]map[string]string
+	// Conn is nil for clients on an HTTP fallback transport, which have
+	// httpConn set instead
+	Conn     *websocket.Conn
+	httpConn *httpConn
+
+	Send   *SendQueue
+	ID     string
+	ConnID string
+	DocID  string
+
+	// Doc is held open for as long as the client is connected
+	Doc *document.Document
+
+	// SessionID is the secret the client presents to resume its identity
+	SessionID string
+
+	// Tenant is the tenant the client's session acts for
+	Tenant string
+
+	// RemoteIP is the address the client connected from, behind any
+	// trusted proxies
+	RemoteIP string
+
+	Data   map[string]map[string]string
+	Logger *slog.Logger
+
+	// ImpersonatedBy names the operator when this is a read-only view of
+	// the room opened as the user; such clients are invisible to the room
+	ImpersonatedBy string
+
+	// ViewOnly is set for clients that joined through a view link
+	ViewOnly bool
+
+	// Spectator is set for view-only clients that joined a room crowded
+	// with viewers. They are counted in viewer-count rather than
+	// announced, and kept out of the roster.
+	Spectator bool
+
+	// Bot is set for the rooms a bot subscribed to. Bots are announced,
+	// marked as such, but left out of the room's human counts: its seen
+	// state, the clients it is full with and the connections of a user.
+	Bot bool
+
+	// Encoding is the wire format of the messages sent to the client
+	Encoding Encoding
+
+	ConnectedAt time.Time
+
+	// Messages read from the connection, for the admin API
+	messagesSent atomic.Int64
+
+	// latency is the client's ping awaiting an answer and what the
+	// answers measured
+	latency latencyState
+
+	// attribution is whether the client asked to be sent how each op
+	// changes the document's authorship
+	attribution atomic.Bool
+
+	// lastActive is when the client last showed activity, in Unix
+	// nanoseconds, zero until it first did; idleWarned is when it was last
+	// warned that it is going idle
+	lastActive atomic.Int64
+	idleWarned atomic.Int64
+
+	limiter *rateLimiter
+	backlog backlog
+	chunks  map[string]*chunkBuffer
+
+	// retransmitFrom is the edit sequence number the client was last asked
+	// to resend from, until it arrives; only the read pump touches it
+	retransmitFrom int64
+
+	// compressed is set when permessage-deflate was negotiated; wire counts
+	// the bytes actually written for the compression metrics
+	compressed bool
+	wire       *countingConn
+
+	// closeReason is why the server is ending the connection, for the
+	// close frame, and closeNote what the frame adds to its name
+	closeReason atomic.Pointer[CloseReason]
+	closeNote   atomic.Pointer[string]
+
+	// ctx is cancelled when the client is removed; see Context
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type Message struct {
-	Type string                       `json:"type"`
-	Data map[string]map[string]string `json:"data"`
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// Upper bound on messages coalesced into one outbound frame
+const maxBatchMessages = 64
+
+var batchSeparator = []byte{'\n'}
+
+// BroadcastMessage is a payload queued for fan-out to the room DocID, or to
+// every client when DocID is empty. Sender, when set, is excluded from
+// delivery so authors don't receive their own edits back.
+type BroadcastMessage struct {
+	DocID  string
+	Sender *Client
+	Data   []byte
+
+	// edit is set on relayed edits, which the room's broadcaster may
+	// coalesce with the next
+	edit *relayedEdit
 }
 
+// WebSocketManager owns the set of connected clients. Clients and Rooms are
+// written while holding Mutex, by the Run goroutine and by the room
+// broadcasters dropping slow clients; other goroutines may read them while
+// holding Mutex.RLock. Broadcast carries only messages for every client;
+// each room's go through a broadcaster of their own.
 type WebSocketManager struct {
-	Clients    map[*Client]bool
-	Broadcast  chan []byte
-	Register   chan *Client
-	Unregister chan *Client
-	Mutex      sync.RWMutex
+	Clients       map[*Client]bool
+	Rooms         map[string]map[*Client]bool
+	Broadcast     chan *BroadcastMessage
+	Register      chan *Client
+	Unregister    chan *Client
+	Mutex         sync.RWMutex
+	Config        *config.Config
+	Logger        *slog.Logger
+	Sessions      *SessionStore
+	Documents     *document.Registry
+	Presence      presence.Store
+	Chat          *chat.History
+	Comments      *comments.Store
+	Notifications *notifications.Store
+	Links         *linkcheck.Checker
+	Similarity    *similarity.Index
+	Snapshots     snapshots.Store
+	Templates     templates.Store
+	Folders       folders.Store
+	Attachments   blobs.Store
+	Exports       blobs.Store // nil renders every snapshot export
+	Takeouts      blobs.Store
+	Analysis      *analysis.Pipeline  // nil analyses no documents
+	Events        *events.Dispatcher  // nil disables the change event stream
+	EventLog      *events.Dispatcher  // nil logs no ops or presence
+	Origins       *origins.Allowlist  // nil rejects every browser origin
+	Proxies       *proxies.Trusted    // nil trusts no proxy
+	Canary        *canary.Runner      // nil runs no canary engine
+	Recorder      *recording.Recorder // nil records no rooms
+	Webhooks      *webhooks.Sender    // nil sends no webhooks
+	Mailer        mail.Sender         // nil sends no email
+	Backups       *backup.Backups     // nil takes no backups
+	Keys          *encryption.Keyring // nil seals nothing at rest
+	Audit         *audit.Trail
+	Shares        *share.Signer
+	Users         users.Store
+	Logins        map[string]*oauth.Provider
+	Names         NameGenerator
+	Colors        ColorGenerator
+	Owners        ownership.Store
+	Node          ownership.Node // an empty URL keeps every document on this node
+
+	upgrader   websocket.Upgrader
+	typing     *typingTracker
+	migrations *migrations
+	dormant    *dormantRooms
+	bans       *banList
+	viewers    *viewerCounts
+	bots       *botConns
+	follows    *followTracker
+	ops        *opFeed
+	seen       *seenStates
+	takeouts   *takeouts
+	leases     *leases
+
+	interceptors []Interceptor
+	broadcasters *roomBroadcasters
+	fanout       *fanoutPool
+
+	// workspaceMutex serializes changes to workspaces, which are loaded,
+	// changed and saved whole
+	workspaceMutex sync.Mutex
+
+	// pings carries the replies Ping waits for from the Run loop
+	pings chan chan struct{}
+
+	// ctx is the parent of every client's context, cancelled once Shutdown
+	// is done with them
+	ctx  context.Context
+	stop context.CancelFunc
+
+	// draining turns new connections away while the server shuts down;
+	// writers tracks the WebSocket write pumps it waits for
+	draining atomic.Bool
+	writers  sync.WaitGroup
+
+	// rotating is set while documents and snapshots are being resealed
+	rotating atomic.Bool
+}
+
+func NewWebSocketManager(cfg *config.Config, logger *slog.Logger) *WebSocketManager {
+	manager := &WebSocketManager{
+		Clients:       make(map[*Client]bool),
+		Rooms:         make(map[string]map[*Client]bool),
+		Broadcast:     make(chan *BroadcastMessage),
+		Register:      make(chan *Client),
+		Unregister:    make(chan *Client),
+		pings:         make(chan chan struct{}),
+		Config:        cfg,
+		Logger:        logger,
+		Sessions:      NewSessionStore(cfg.SessionTTL),
+		Documents:     document.NewRegistry(),
+		Presence:      presence.NewMemoryStore(cfg.PresenceTTL),
+		Chat:          chat.NewHistory(cfg.Limits.ChatHistorySize),
+		Comments:      comments.NewStore(),
+		Notifications: notifications.NewStore(cfg.Limits.NotificationsPerUser),
+		Similarity:    similarity.NewIndex(),
+		Snapshots:     snapshots.NewMemoryStore(),
+		Templates:     templates.NewMemoryStore(),
+		Folders:       folders.NewMemoryStore(),
+		Attachments:   blobs.NewMemoryStore(),
+		Takeouts:      blobs.NewMemoryStore(),
+		Shares:        share.NewSigner([]byte(cfg.Share.Secret)),
+		Users:         users.NewMemoryStore(),
+		Logins:        newLogins(cfg.OAuth),
+		Names:         DefaultNames,
+		Colors:        RandomColors{},
+		typing:        newTypingTracker(),
+		migrations:    &migrations{rooms: make(map[string]migration)},
+		dormant:       &dormantRooms{since: make(map[string]time.Time)},
+		bans:          &banList{expiry: make(map[string]time.Time)},
+		viewers:       &viewerCounts{sent: make(map[string]int)},
+		bots:          &botConns{conns: make(map[*botConn]bool)},
+		follows:       newFollowTracker(),
+		ops:           newOpFeed(),
+		seen:          &seenStates{pending: make(map[string]bool)},
+		takeouts:      &takeouts{jobs: make(map[string]*Takeout)},
+		Owners:        ownership.NewMemoryStore(),
+		Node:          ownership.Node{ID: cfg.Cluster.NodeID, URL: cfg.Cluster.AdvertiseURL},
+		leases:        &leases{acquired: make(map[string]time.Time)},
+
+		broadcasters: &roomBroadcasters{rooms: make(map[string]*roomBroadcaster)},
+	}
+	manager.ctx, manager.stop = context.WithCancel(context.Background())
+	if cfg.Fanout.Workers > 0 {
+		manager.fanout = newFanoutPool(manager.ctx, cfg.Fanout.Workers)
+	}
+	manager.Documents.SetLimits(document.Limits{MaxSize: cfg.Limits.MaxDocumentSize, Ops: cfg.Limits.DocumentOps})
+	manager.Documents.SetWaker(manager.wake)
+	manager.Documents.SetSaveListener(manager.saveStatusChanged)
+	manager.Documents.SetCreateListener(manager.documentCreated)
+	manager.Audit = audit.NewTrail(audit.NewMemoryStore(), audit.Retention{
+		MaxAge:     cfg.Audit.MaxAge,
+		MaxEntries: cfg.Audit.MaxEntries,
+	}, logger)
+	manager.Links = linkcheck.NewChecker(manager.Documents, cfg.LinkCheck.Timeout, logger)
+	manager.Links.OnReport(manager.broadcastLinkReport)
+	if cfg.Analysis.URL != "" {
+		manager.Analysis = analysis.NewPipeline(manager.Documents, analysis.NewLanguageTool(cfg.Analysis.URL), cfg.Analysis.IdleDelay, cfg.Analysis.Timeout, logger)
+		manager.Analysis.OnReport(manager.broadcastAnnotations)
+	}
+	manager.upgrader = websocket.Upgrader{
+		ReadBufferSize:  cfg.Limits.ReadBufferSize,
+		WriteBufferSize: cfg.Limits.WriteBufferSize,
+		CheckOrigin:     manager.checkOrigin,
+
+		EnableCompression: cfg.Compression.Enabled,
+	}
+	return manager
+}
+
+// upgrade completes the WebSocket handshake, adding header to the
+// response, and reports whether permessage-deflate was negotiated
+func (manager *WebSocketManager) upgrade(w http.ResponseWriter, r *http.Request, header http.Header) (conn *websocket.Conn, wire *countingConn, compressed bool, err error) {
+	writer := &countingWriter{ResponseWriter: w}
+	conn, err = manager.upgrader.Upgrade(writer, r, header)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	compressed = manager.upgrader.EnableCompression && offersDeflate(r)
+	if compressed {
+		if err := conn.SetCompressionLevel(manager.Config.Compression.Level); err != nil {
+			conn.Close()
+			return nil, nil, false, err
+		}
+	}
+	return conn, writer.conn, compressed, nil
 }
 
-func NewWebSocketManager() *WebSocketManager {
-	return &WebSocketManager{
-		Clients:    make(map[*Client]bool),
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+func (manager *WebSocketManager) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if manager.Origins.Allows(origin) {
+		return true
+	}
+	// The web app served by this server is on its own origin
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return true
 	}
+	manager.Logger.Warn("Rejected WebSocket connection", "origin", origin)
+	return false
 }
 
 func (manager *WebSocketManager) Run() {
+	// A nil channel never fires, which disables the periodic roster
+	var rosterTick <-chan time.Time
+	if interval := manager.Config.PresenceRosterInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		rosterTick = ticker.C
+	}
+
+	go manager.refreshPresence()
+	if manager.Config.DocumentIdleTimeout > 0 {
+		go manager.unloadIdleDocuments()
+	}
+	if manager.Config.AutosaveInterval > 0 {
+		go manager.autosave()
+	}
+	if manager.Config.HibernateAfter > 0 {
+		go manager.hibernateIdleRooms()
+	}
+	if manager.Config.Limits.IdleTimeout > 0 {
+		go manager.closeIdleClients()
+	}
+	if manager.Config.TitleSuggestDelay > 0 {
+		go manager.suggestTitles()
+	}
+	if manager.Config.Compaction.Interval > 0 {
+		go manager.compactHistory()
+	}
+	if manager.clustered() {
+		go manager.renewLeases()
+	}
+	if manager.Config.Trash.Retention > 0 {
+		go manager.purgeTrash()
+	}
+	if manager.Config.Spectators.Threshold > 0 {
+		go manager.broadcastViewerCounts()
+	}
+	if manager.Mailer != nil && manager.Config.Mail.DigestInterval > 0 {
+		go manager.sendDigests()
+	}
+	if manager.Config.Latency.Interval > 0 {
+		go manager.measureLatency()
+	}
+
 	for {
 		select {
 		case client := <-manager.Register:
-			manager.Mutex.Lock()
-			manager.Clients[client] = true
-			manager.Mutex.Unlock()
-			log.Printf("Client connected: %s", client.ID)
+			manager.addClient(client)
+			client.Logger.Info("Client connected")
 
-		case client := <-manager.Unregister:
-			manager.Mutex.Lock()
-			if _, ok := manager.Clients[client]; ok {
-				delete(manager.Clients, client)
-				close(client.Send)
+			// Exchange user data once the client is visible in its room
+			go manager.HandleUserData(client)
 
-				// Notify others about user disconnection
-				go manager.HandleDeleteUser(client)
+		case client := <-manager.Unregister:
+			manager.Sessions.Touch(client.SessionID)
+			if manager.removeClient(client) {
+				client.Logger.Info("Client disconnected")
 			}
-			manager.Mutex.Unlock()
-			log.Printf("Client disconnected: %s", client.ID)
 
 		case message := <-manager.Broadcast:
-			manager.BroadcastToAllClients(message)
+			manager.deliver(message)
+
+		case reply := <-manager.pings:
+			close(reply)
+
+		case <-rosterTick:
+			// Off the Run goroutine, so a room with a full queue holds up
+			// no one but itself
+			go manager.broadcastRosters()
 		}
 	}
 }
 
-// New method to safely broadcast messages to all clients
+func (manager *WebSocketManager) addClient(client *Client) {
+	manager.Mutex.Lock()
+	manager.Clients[client] = true
+	room, ok := manager.Rooms[client.DocID]
+	if !ok {
+		room = make(map[*Client]bool)
+		manager.Rooms[client.DocID] = room
+	}
+	room[client] = true
+	roomSize, rooms := len(room), len(manager.Rooms)
+	manager.Mutex.Unlock()
+
+	metrics.ConnectedClients.Inc()
+	metrics.OpenRooms.Set(float64(rooms))
+	metrics.RoomClients.WithLabelValues(client.DocID).Set(float64(roomSize))
+	metrics.TenantClients.WithLabelValues(metrics.TenantLabel(client.Tenant)).Inc()
+}
+
+// removeClient drops a client from the manager and closes its send queue.
+// It reports false if the client was already removed.
+func (manager *WebSocketManager) removeClient(client *Client) bool {
+	manager.Mutex.Lock()
+	if _, ok := manager.Clients[client]; !ok {
+		manager.Mutex.Unlock()
+		return false
+	}
+	delete(manager.Clients, client)
+	room := manager.Rooms[client.DocID]
+	delete(room, client)
+	roomSize := len(room)
+	if roomSize == 0 {
+		delete(manager.Rooms, client.DocID)
+	}
+	rooms := len(manager.Rooms)
+	client.Send.Close()
+	manager.Mutex.Unlock()
+	client.cancel()
+	manager.Documents.Release(client.DocID)
+
+	metrics.ConnectedClients.Dec()
+	metrics.OpenRooms.Set(float64(rooms))
+	metrics.TenantClients.WithLabelValues(metrics.TenantLabel(client.Tenant)).Dec()
+	if roomSize == 0 {
+		metrics.RoomClients.DeleteLabelValues(client.DocID)
+	} else {
+		metrics.RoomClients.WithLabelValues(client.DocID).Set(float64(roomSize))
+	}
+
+	// Notify others about user disconnection
+	go manager.HandleDeleteUser(client)
+	return true
+}
+
+// BroadcastToAllClients queues a message for every connected client
 func (manager *WebSocketManager) BroadcastToAllClients(message []byte) {
+	manager.queueBroadcast(&BroadcastMessage{Data: message})
+}
+
+// BroadcastToRoom queues a message for every client editing docID
+func (manager *WebSocketManager) BroadcastToRoom(docID string, message []byte) {
+	manager.queueBroadcast(&BroadcastMessage{DocID: docID, Data: message})
+}
+
+// BroadcastExcept queues a message for everyone in the sender's room except
+// the sender itself
+func (manager *WebSocketManager) BroadcastExcept(sender *Client, message []byte) {
+	manager.queueBroadcast(&BroadcastMessage{DocID: sender.DocID, Sender: sender, Data: message})
+}
+
+func (manager *WebSocketManager) queueBroadcast(message *BroadcastMessage) {
+	metrics.BroadcastMessages.Inc()
+	manager.Recorder.Record(message.DocID, recording.Broadcast, "", "", message.Data)
+	if message.DocID != "" {
+		manager.queueRoomBroadcast(message)
+		return
+	}
+	manager.Broadcast <- message
+}
+
+// SendToClient delivers a message to a single client without blocking. The
+// client is looked up by user ID; the first matching connection receives it.
+func (manager *WebSocketManager) SendToClient(clientID string, message []byte) error {
 	manager.Mutex.RLock()
 	defer manager.Mutex.RUnlock()
 
 	for client := range manager.Clients {
-		select {
-		case client.Send <- message:
-			// Message sent successfully
-		default:
-			// Client's send buffer is full, remove the client
-			close(client.Send)
-			manager.Mutex.RUnlock()
-			manager.Mutex.Lock()
-			delete(manager.Clients, client)
-			manager.Mutex.Unlock()
-			manager.Mutex.RLock()
+		if client.ID == clientID {
+			return manager.trySend(client, message)
+		}
+	}
+	return fmt.Errorf("client %s not found", clientID)
+}
+
+// sendToClient is SendToClient for a known client. It is a no-op error once
+// the client has been removed, so callers never write to a closed queue.
+func (manager *WebSocketManager) sendToClient(client *Client, message []byte) error {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	if !manager.Clients[client] {
+		return fmt.Errorf("client %s is no longer connected", client.ID)
+	}
+	return manager.trySend(client, message)
+}
+
+// trySend must be called with the manager mutex held so Send can't be closed
+// concurrently
+func (manager *WebSocketManager) trySend(client *Client, message []byte) error {
+	if !client.Send.Push(message) {
+		return fmt.Errorf("send buffer full for client %s", client.ID)
+	}
+	manager.Recorder.Record(client.DocID, recording.Outbound, client.ConnID, client.ID, message)
+	return nil
+}
+
+// deliver fans a message out, applying the backpressure policy for
+// clients whose send buffer is full. It is called from the Run goroutine
+// for messages to every client and from room broadcasters for the rest.
+func (manager *WebSocketManager) deliver(message *BroadcastMessage) {
+	var slow []*Client
+	msgType := messageType(message.Data)
+
+	manager.Mutex.RLock()
+	recipients := manager.Clients
+	if message.DocID != "" {
+		recipients = manager.Rooms[message.DocID]
+	}
+	if size := manager.Config.Fanout.BatchSize; manager.fanout != nil && len(recipients) > size {
+		// Workers send while the lock is held here, so no client is
+		// removed, and its Send closed, under them
+		var mutex sync.Mutex
+		clients := slices.Collect(maps.Keys(recipients))
+		manager.fanout.run(clients, size, func(batch []*Client) {
+			stalled := manager.send(slices.Values(batch), message, msgType)
+			if len(stalled) > 0 {
+				mutex.Lock()
+				slow = append(slow, stalled...)
+				mutex.Unlock()
+			}
+		})
+	} else {
+		slow = manager.send(maps.Keys(recipients), message, msgType)
+	}
+	manager.Mutex.RUnlock()
+
+	// Clients with a full send buffer are removed only after iteration, so
+	// the maps are never mutated while being ranged over
+	for _, client := range slow {
+		client.Logger.Warn("Send buffer stayed full, dropping client")
+		metrics.DroppedClients.Inc()
+		client.closeReason.Store(&CloseTooSlow)
+		manager.removeClient(client)
+	}
+}
+
+// send queues a message of msgType for each of clients but its sender,
+// without waiting, and returns those that stayed too slow to keep
+func (manager *WebSocketManager) send(clients iter.Seq[*Client], message *BroadcastMessage, msgType string) (slow []*Client) {
+	lane := laneOf(msgType)
+	for client := range clients {
+		if client == message.Sender {
+			continue
+		}
+
+		if client.Send.push(message.Data, lane) {
+			metrics.DeliveredMessages.Inc()
+			continue
+		}
+		if manager.overflow(client, message, msgType) {
+			slow = append(slow, client)
 		}
 	}
+	return slow
 }
 
 func (manager *WebSocketManager) HandleWebSocketConnections(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			// Allow all connections (modify for production)
-			return true
-		},
+	if manager.ForwardToOwner(w, r, r.URL.Query().Get("doc")) {
+		return
+	}
+	// The handshake response is the only chance to give a browser its
+	// guest cookie
+	guestID, cookie := manager.guestIdentity(r)
+	header := make(http.Header)
+	if cookie != nil {
+		header.Add("Set-Cookie", cookie.String())
+	}
+	conn, wire, compressed, err := manager.upgrade(w, r, header)
+	if err != nil {
+		metrics.UpgradeFailures.Inc()
+		manager.Logger.Warn("WebSocket upgrade error", "remote_ip", manager.Proxies.ClientIP(r), "error", err)
+		return
 	}
+	// Frames beyond the chunked ceiling close the connection outright
+	conn.SetReadLimit(manager.Config.Limits.MaxChunkedSize)
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Refusals are sent over the upgraded connection, so browsers see the
+	// reason rather than a failed handshake
+	query := r.URL.Query()
+	encoding, err := negotiateEncoding(r)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		manager.closeConn(conn, EncodingJSON, CloseProtocolMismatch, CloseProtocolMismatch.message(err.Error()))
+		return
+	}
+	if version := query.Get("protocol"); version != "" && version != strconv.Itoa(ProtocolVersion) {
+		details := fmt.Sprintf("protocol version %s is not supported, the server speaks %d", version, ProtocolVersion)
+		manager.closeConn(conn, encoding, CloseProtocolMismatch, CloseProtocolMismatch.message(details))
 		return
 	}
 
+	// Reconnecting clients present their session to keep the same identity.
+	// One that has expired has to be dropped before reconnecting.
+	token := query.Get("session")
+	if _, live := manager.Sessions.Lookup(token); token != "" && !live {
+		manager.closeConn(conn, encoding, CloseAuthExpired, CloseAuthExpired.message("session has expired"))
+		return
+	}
+	hostTenant, err := manager.HostTenant(r.Host)
+	if err != nil {
+		manager.closeConn(conn, encoding, CloseWrongTenant, CloseWrongTenant.message(err.Error()))
+		return
+	}
+	session, resumed := manager.resumeSession(token, guestID, hostTenant, requestLocales(r))
+	if session.Tenant != hostTenant {
+		manager.closeConn(conn, encoding, CloseWrongTenant, CloseWrongTenant.message("the session belongs to another tenant"))
+		return
+	}
 	data := map[string]map[string]string{
-		"userData": {
-			"userId":    r.RemoteAddr,
-			"userName":  GetRandomName(),
-			"userColor": GetRandomColor(),
-		},
+		"userData": session.UserData(),
+	}
+
+	docID := query.Get("doc")
+	if docID == "" {
+		docID = DefaultDocID
+	}
+	if url, moved := manager.migrations.lookup(docID); moved {
+		manager.redirectMigrated(conn, encoding, url)
+		return
+	}
+	remoteIP := manager.Proxies.ClientIP(r)
+	viewOnly, refused := manager.verifyShare(query.Get("share"), docID)
+	if refused == nil {
+		refused = manager.admit(session.UserID, remoteIP, docID)
+	}
+	var doc *document.Document
+	if refused == nil {
+		doc, refused = manager.admitDocument(session.UserID, session.Tenant, docID)
+	}
+	if refused != nil {
+		manager.closeConn(conn, encoding, refused.Reason, refused.message())
+		return
+	}
+	// Viewers aren't editors, nor owners of a document they open first
+	if !viewOnly {
+		doc.Join(session.UserID)
 	}
 
 	client := &Client{
-		Conn: conn,
-		Send: make(chan []byte, 256),
-		ID:   r.RemoteAddr,
-		Data: data,
+		Conn:   conn,
+		Send:   NewSendQueue(manager.Config.Limits.SendBufferSize),
+		ID:     session.UserID,
+		ConnID: NewConnID(),
+		DocID:  docID,
+		Doc:    doc,
+		Data:   data,
+
+		SessionID:   session.ID,
+		Tenant:      session.Tenant,
+		RemoteIP:    remoteIP,
+		ViewOnly:    viewOnly,
+		Spectator:   manager.joinsAsSpectator(docID, viewOnly),
+		Encoding:    encoding,
+		ConnectedAt: time.Now(),
+
+		limiter: newRateLimiter(manager.Config.Limits),
+		chunks:  make(map[string]*chunkBuffer),
+
+		compressed: compressed,
+		wire:       wire,
 	}
+	manager.bindContext(client)
+	client.Logger = manager.Logger.With(
+		"conn_id", client.ConnID,
+		"doc_id", client.DocID,
+		"user_id", client.ID,
+		"remote_ip", client.RemoteIP,
+	)
+	client.Logger.Debug("Session established", "resumed", resumed, "view_only", viewOnly, "encoding", encoding, "compressed", compressed)
 
-	// Register the client first
+	// Register the client first; Run sends the initial user data
 	manager.Register <- client
 
 	// Then start the handlers
+	manager.writers.Add(1)
 	go manager.HandleClientRead(client)
 	go manager.HandleClientWrite(client)
-
-	// Handle user data after adding client to the map
-	go manager.HandleUserData(client)
 }
 
 func (manager *WebSocketManager) HandleDeleteUser(client *Client) {
+	if client.ImpersonatedBy != "" {
+		manager.endImpersonation(client)
+		return
+	}
+
+	manager.forgetTyping(client)
+	manager.forgetFollows(client)
+	if manager.userTabs(client) == 0 {
+		manager.emitEvent(events.TypeUserLeft, client.DocID, client.ID, events.UserLeft{UserID: client.ID})
+		manager.Audit.Record(audit.Entry{Action: audit.ActionLeft, DocID: client.DocID, UserID: client.ID})
+	}
+	if client.Spectator {
+		return
+	}
+	manager.presenceLeave(client)
+	manager.logPresence(events.TypePresenceLeft, client)
+
 	message := Message{
 		Type: "user-removed",
-		Data: client.Data,
+		Data: manager.presenceChange(client),
 	}
 	jsonData, err := json.Marshal(message)
 	if err != nil {
-		log.Printf("Error marshalling user-removed message: %v", err)
+		client.Logger.Error("Error marshalling user-removed message", "error", err)
 		return
 	}
-	manager.Broadcast <- jsonData
+	manager.BroadcastToRoom(client.DocID, jsonData)
 }
 
 func (manager *WebSocketManager) HandleUserData(client *Client) {
-	// 1. Send user data to itself first
+	if client.ImpersonatedBy != "" {
+		manager.sendImpersonationState(client)
+		return
+	}
+
+	// 1. Send user data to itself first, along with the session token
+	// it needs to reconnect as the same user and where its edit numbering
+	// carries on
+	lastSeq := manager.Sessions.LastSeq(client.SessionID, client.DocID)
+	manager.Mutex.RLock()
 	selfMessage := Message{
 		Type: "user-data",
-		Data: client.Data,
+		Data: map[string]map[string]string{
+			"userData": client.Data["userData"],
+			"session": {
+				"sessionId": client.SessionID,
+				"lastSeq":   strconv.FormatInt(lastSeq, 10),
+			},
+		},
+	}
+	manager.Mutex.RUnlock()
+	if client.Spectator {
+		selfMessage.Data.(map[string]map[string]string)["session"]["spectator"] = "true"
 	}
 	jsonData, err := json.Marshal(selfMessage)
 	if err != nil {
-		log.Printf("Error marshalling user-data message: %v", err)
+		client.Logger.Error("Error marshalling user-data message", "error", err)
 		return
 	}
 
 	// Send directly to the client, not through broadcast
-	client.Send <- jsonData
-	log.Printf("Sent user data to client: %s", client.ID)
+	if err := manager.sendToClient(client, jsonData); err != nil {
+		client.Logger.Warn("Could not send user data", "error", err)
+		return
+	}
+	client.Logger.Debug("Sent user data to client")
+	if !client.Spectator {
+		manager.presenceJoin(client)
+		manager.logPresence(events.TypePresenceJoined, client)
+	}
 
-	// 2. Send existing users to the new client
-	manager.Mutex.RLock()
-	for existingClient := range manager.Clients {
-		// Don't send client's own data back to itself
-		if existingClient.ID == client.ID {
-			continue
-		}
+	// 2. Send the full room roster to the new client in one frame
+	rosterData, err := json.Marshal(manager.roster(client.DocID))
+	if err != nil {
+		client.Logger.Error("Error marshalling presence roster", "error", err)
+		return
+	}
+	if err := manager.sendToClient(client, rosterData); err != nil {
+		client.Logger.Warn("Could not send presence roster", "error", err)
+		return
+	}
+	client.Logger.Debug("Sent presence roster to new client")
+	manager.sendViewerCount(client)
+	manager.sendFollowerCount(client)
 
-		existingUserMsg := Message{
+	// 3. Announce new client to the rest of the room, unless it only
+	// counts towards the viewers
+	if !client.Spectator {
+		newUserMsg := Message{
 			Type: "user-added",
-			Data: existingClient.Data,
+			Data: manager.presenceChange(client),
 		}
-
-		existingUserData, err := json.Marshal(existingUserMsg)
+		newUserData, err := json.Marshal(newUserMsg)
 		if err != nil {
-			log.Printf("Error marshalling existing user data: %v", err)
-			continue
+			client.Logger.Error("Error marshalling new user announcement", "error", err)
+			return
 		}
 
-		// Send directly to the client
-		client.Send <- existingUserData
-		log.Printf("Sent existing user %s data to new client %s", existingClient.ID, client.ID)
-	}
-	manager.Mutex.RUnlock()
-
-	// 3. Announce new client to all other clients
-	newUserMsg := Message{
-		Type: "user-added",
-		Data: client.Data,
+		// Broadcast to the room except the new client
+		manager.BroadcastExcept(client, newUserData)
+		client.Logger.Debug("Announced new client to the room")
 	}
-	newUserData, err := json.Marshal(newUserMsg)
-	if err != nil {
-		log.Printf("Error marshalling new user announcement: %v", err)
-		return
+	if manager.userTabs(client) == 1 {
+		manager.emitEvent(events.TypeUserJoined, client.DocID, client.ID,
+			events.UserJoined{UserID: client.ID, ViewOnly: client.ViewOnly})
+		manager.auditJoin(client)
 	}
 
-	// Broadcast to all clients except the new one
-	manager.Broadcast <- newUserData
-	log.Printf("Announced new client %s to all other clients", client.ID)
+	// 4. Bring the new client's copy of the document up to date
+	manager.sendDocumentState(client)
+	manager.sendChatHistory(client)
 }
 
+// HandleClientRead is the client's read pump. It returns when the
+// connection fails or the client's context ends, and unregisters the
+// client; the write pump closes the connection.
 func (manager *WebSocketManager) HandleClientRead(client *Client) {
 	defer func() {
 		manager.Unregister <- client
-		client.Conn.Close()
 	}()
+	defer stopReadingOnCancel(client)()
+	manager.watchPongs(client)
 
 	for {
-		_, message, err := client.Conn.ReadMessage()
+		message, err := manager.readMessage(client)
+		if errors.Is(err, errMessageTooLarge) {
+			client.Logger.Warn("Discarded oversized message")
+			manager.sendError(client, ErrCodeMessageTooLarge,
+				fmt.Sprintf("messages are limited to %d bytes, use chunk messages for larger payloads", manager.Config.Limits.MaxMessageSize))
+			continue
+		}
+		if errors.Is(err, errMalformedFrame) {
+			client.Logger.Warn("Discarded malformed message", "error", err)
+			manager.sendError(client, ErrCodeInvalidMessage, "binary frames must hold a single MessagePack message")
+			continue
+		}
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket read error: %v", err)
+			if client.ctx.Err() == nil && websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				client.Logger.Warn("WebSocket read error", "error", err)
 			}
 			break
 		}
 
-		log.Printf("Received message from %s: %s", client.ID, string(message))
-		manager.Broadcast <- message
+		manager.receive(client, message)
+	}
+}
+
+// receive rate limits, validates and handles a message read from a client.
+// It must only be called from the client's read pump.
+func (manager *WebSocketManager) receive(client *Client, message []byte) {
+	client.Logger.Debug("Received message", "size", len(message))
+	client.messagesSent.Add(1)
+	msgType := messageType(message)
+	// Answers to the server's pings are like pongs, activity only when
+	// pongs are
+	if msgType != "latency" || manager.Config.Limits.IdlePolicy != idlePolicyMessages {
+		client.touch()
+	}
+	metrics.MessageSize.WithLabelValues("inbound").Observe(float64(len(message)))
+
+	if !manager.allow(client, msgType) {
+		return
+	}
+	invalid := validateMessage(msgType, message)
+	if !manager.interceptable(invalid) {
+		manager.sendSchemaError(client, invalid)
+		return
+	}
+	manager.Recorder.Record(client.DocID, recording.Inbound, client.ConnID, client.ID, message)
+
+	if isChunkType(msgType) {
+		manager.handleChunk(client, msgType, message)
+		return
+	}
+	manager.dispatch(client, msgType, message, invalid)
+}
+
+// allow counts a message of msgType against the client's rate limits and
+// reports whether it may be handled, muting the client when it goes over
+// and disconnecting it once it has been muted too often
+func (manager *WebSocketManager) allow(client *Client, msgType string) bool {
+	allowed, violated := client.limiter.Allow(msgType, time.Now())
+	if violated {
+		if limit := manager.Config.Limits.MaxMutes; limit > 0 && client.limiter.Mutes() > limit {
+			client.Logger.Warn("Client kept exceeding rate limits, disconnecting", "type", msgType)
+			manager.disconnect(client, CloseRateLimited, "too many messages, slow down before reconnecting")
+			return false
+		}
+		client.Logger.Warn("Client exceeded rate limit, muting", "type", msgType)
+		manager.sendRateLimitWarning(client, msgType)
+	}
+	return allowed
+}
+
+// readMessage reads the next frame, discarding it without buffering when it
+// exceeds the configured message size
+func (manager *WebSocketManager) readMessage(client *Client) ([]byte, error) {
+	frameType, reader, err := client.Conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := manager.Config.Limits.MaxMessageSize
+	message, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(message)) > limit {
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return nil, err
+		}
+		return nil, errMessageTooLarge
+	}
+	message, err = decodeFrame(frameType, message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMalformedFrame, err)
+	}
+	return message, nil
+}
+
+// handleMessage processes a complete inbound message that passed validation
+func (manager *WebSocketManager) handleMessage(client *Client, msgType string, message []byte) {
+	if msgType == "activity" {
+		// Only there to keep the client from going idle, which receive saw to
+		return
+	}
+	if msgType == "ping" || msgType == "latency" {
+		manager.handleLatency(client, msgType, message)
+		return
+	}
+	if client.ImpersonatedBy != "" {
+		manager.sendError(client, ErrCodeReadOnly, "impersonated views are read-only")
+		return
+	}
+	if client.ViewOnly && !viewerMessages[msgType] {
+		manager.sendError(client, ErrCodeReadOnly, "view links are read-only")
+		return
+	}
+	if client.Spectator && !spectatorMessages[msgType] {
+		manager.sendError(client, ErrCodeReadOnly, "spectators can only chat")
+		return
+	}
+	if mutedMessages[msgType] && client.Doc.Muted(client.ID) {
+		manager.sendError(client, ErrCodeMuted, ErrMuted.Error())
+		return
+	}
+	if _, moving := manager.migrations.lookup(client.DocID); moving {
+		manager.sendError(client, ErrCodeRoomMigrating, "the room is moving to another node, reconnect when told where")
+		return
+	}
+
+	switch msgType {
+	case "user-renamed":
+		manager.handleRename(client, message)
+		return
+	case "awareness-update":
+		manager.handleAwareness(client, message)
+		return
+	case "follow-request":
+		manager.handleFollowRequest(client, message)
+		return
+	case "viewport":
+		manager.handleViewport(client, message)
+		return
+	case "reaction-add":
+		manager.handleReactionAdd(client, message)
+		return
+	case "reaction-remove":
+		manager.handleReactionRemove(client, message)
+		return
+	case "content":
+		metrics.OpsApplied.Inc()
+		manager.handleContent(client, message)
+		return
+	case "ack":
+		manager.handleAck(client, message)
+		return
+	case "chat":
+		manager.handleChat(client, message)
+		return
+	case "comment-add":
+		manager.handleCommentAdd(client, message)
+		return
+	case "typing":
+		manager.handleTyping(client, message)
+		return
+	case "doc-metadata":
+		manager.handleMetadata(client, message)
+		return
+	case "doc-meta-update":
+		manager.handleMetaUpdate(client, message)
+		return
+	case "export-policy":
+		manager.handleExportPolicy(client, message)
+		return
+	case "edit-mode":
+		manager.handleEditMode(client, message)
+		return
+	case "suggestion-accept":
+		manager.handleSuggestionAccept(client, message)
+		return
+	case "suggestion-reject":
+		manager.handleSuggestionReject(client, message)
+		return
+	case "track-changes":
+		manager.handleTrackChanges(client, message)
+		return
+	case "analysis":
+		manager.handleAnalysis(client, message)
+		return
+	case "attribution":
+		manager.handleAttribution(client, message)
+		return
+	case "moderate":
+		manager.handleModerate(client, message)
 	}
 }
 
+// HandleClientWrite is the client's write pump. Messages that queue up while
+// a frame is being written are coalesced into the next frame as newline
+// separated JSON documents, bounded by maxBatchMessages.
 func (manager *WebSocketManager) HandleClientWrite(client *Client) {
 	defer func() {
 		client.Conn.Close()
+		manager.writers.Done()
 	}()
 
+	writeTimeout := manager.Config.Limits.WriteTimeout
 	for {
-		select {
-		case message, ok := <-client.Send:
-			if !ok {
-				// Channel was closed, terminate the connection
-				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				log.Printf("Client %s send channel closed", client.ID)
-				return
-			}
+		message, ok := client.Send.Next()
+		client.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if !ok {
+			// Queue was closed, terminate the connection
+			client.Conn.WriteMessage(websocket.CloseMessage, client.closeFrame())
+			client.Logger.Debug("Send queue closed")
+			return
+		}
 
-			// Send each message individually
-			err := client.Conn.WriteMessage(websocket.TextMessage, message)
-			if err != nil {
-				log.Printf("Error sending message to client %s: %v", client.ID, err)
-				return
-			}
+		if err := writeBatch(client, message, manager.Config.Compression.Threshold); err != nil {
+			client.Logger.Warn("Error sending message", "error", err)
+			return
+		}
+		manager.relieve(client)
+	}
+}
 
-			// Small delay to prevent overwhelming the client
-			time.Sleep(time.Millisecond * 5)
+// writeBatch writes first plus whatever is already queued as a single
+// frame, compressed when the connection allows it and the frame reaches
+// threshold bytes
+func writeBatch(client *Client, first []byte, threshold int) error {
+	var frame bytes.Buffer
+	appendEncoded(client, &frame, first)
+	for range maxBatchMessages - 1 {
+		message, ok := client.Send.Pop()
+		if !ok {
+			break
+		}
+		if frame.Len() > 0 {
+			frame.Write(client.Encoding.separator())
 		}
+		appendEncoded(client, &frame, message)
+	}
+	if frame.Len() == 0 {
+		return nil
+	}
+
+	compress := client.compressed && frame.Len() >= threshold
+	client.Conn.EnableWriteCompression(compress)
+	before := client.wire.written.Load()
+	if err := client.Conn.WriteMessage(client.Encoding.frameType(), frame.Bytes()); err != nil {
+		return err
+	}
+	metrics.ObserveFrame(compress, frame.Len(), client.wire.written.Load()-before)
+	return nil
+}
+
+func appendEncoded(client *Client, frame *bytes.Buffer, message []byte) {
+	encoded, err := client.Encoding.encode(message)
+	if err != nil {
+		// Every message is produced by the server, so this is a bug
+		// rather than a reason to drop the connection
+		client.Logger.Error("Error encoding message", "encoding", client.Encoding, "error", err)
+		return
+	}
+	frame.Write(encoded)
+	metrics.MessageSize.WithLabelValues("outbound").Observe(float64(len(encoded)))
+}
+
+// sendMessage marshals a message and queues it for a single client
+func (manager *WebSocketManager) sendMessage(client *Client, message Message) {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		client.Logger.Error("Error marshalling message", "type", message.Type, "error", err)
+		return
+	}
+	if err := manager.sendToClient(client, jsonData); err != nil {
+		client.Logger.Warn("Could not send message", "type", message.Type, "error", err)
+	}
+}
+
+// sendError replies to a client with a typed error message
+func (manager *WebSocketManager) sendError(client *Client, code string, details string) {
+	manager.sendMessage(client, errorMessage(ErrorData{Code: code, Message: details}))
+}
+
+// sendRateLimitWarning tells a client it has been muted and for how long
+func (manager *WebSocketManager) sendRateLimitWarning(client *Client, msgType string) {
+	manager.sendMessage(client, Message{
+		Type: "rate-limited",
+		Data: map[string]map[string]string{
+			"limit": {
+				"type":       msgType,
+				"retryAfter": manager.Config.Limits.MuteDuration.String(),
+			},
+		},
+	})
+}
+
+// messageType extracts the type field of a JSON message
+func messageType(message []byte) string {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return ""
 	}
+	return envelope.Type
 }
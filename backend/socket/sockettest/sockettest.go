@@ -0,0 +1,275 @@
+// Package sockettest runs the collaboration server in process for
+// integration tests of presence, broadcast and sync, and drives it with
+// WebSocket clients that send typed messages and wait for the ones they
+// expect:
+//
+//	server := sockettest.NewTestServer()
+//	defer server.Close()
+//	alice, err := server.Connect("doc", nil)
+//	...
+//	bob, err := server.Connect("doc", nil)
+//	...
+//	err = bob.Send("chat", map[string]string{"text": "hi"})
+//	message, err := alice.AwaitType("chat")
+package sockettest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/api"
+	"backend/config"
+	"backend/socket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultTimeout is how long Await waits for a message unless the client's
+// Timeout says otherwise
+const DefaultTimeout = 5 * time.Second
+
+// How long Close waits for clients to be disconnected
+const shutdownTimeout = 5 * time.Second
+
+var (
+	// ErrTimeout is a message that didn't arrive in time
+	ErrTimeout = errors.New("timed out waiting for a message")
+	// ErrClosed is a message awaited on a connection that closed first
+	ErrClosed = errors.New("connection closed")
+)
+
+// Option changes the configuration of a test server before it starts
+type Option func(cfg *config.Config)
+
+// Server is the WebSocket endpoint, the event stream and the REST API
+// served over loopback. The manager is exposed so tests can inspect rooms
+// and documents or install interceptors; its background goroutines outlive
+// Close.
+type Server struct {
+	URL     string
+	Config  *config.Config
+	Manager *socket.WebSocketManager
+
+	http *httptest.Server
+}
+
+// NewTestServer starts a server with the default configuration, changed
+// by options, keeping everything in memory and logging nothing
+func NewTestServer(options ...Option) *Server {
+	cfg := config.Default()
+	for _, option := range options {
+		option(cfg)
+	}
+
+	gin.SetMode(gin.TestMode)
+	manager := socket.NewWebSocketManager(cfg, slog.New(slog.DiscardHandler))
+	go manager.Run()
+
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		manager.HandleWebSocketConnections(c.Writer, c.Request)
+	})
+	router.GET("/events", func(c *gin.Context) {
+		manager.HandleEventStream(c.Writer, c.Request)
+	})
+	api.NewHandler(manager).RegisterRoutes(router)
+
+	server := httptest.NewServer(router)
+	return &Server{URL: server.URL, Config: cfg, Manager: manager, http: server}
+}
+
+// Close disconnects every client and stops serving
+func (server *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	server.Manager.Shutdown(ctx)
+	server.http.Close()
+	server.Manager.Audit.Close()
+}
+
+// Message is a message a client received
+type Message struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	// Raw is the message as it arrived
+	Raw []byte `json:"-"`
+}
+
+// Decode unmarshals the data of the message into v
+func (message Message) Decode(v any) error {
+	return json.Unmarshal(message.Data, v)
+}
+
+// Type matches messages of type msgType
+func Type(msgType string) func(Message) bool {
+	return func(message Message) bool {
+		return message.Type == msgType
+	}
+}
+
+// Client is a WebSocket connection to a test server. Received messages
+// are kept until a call to Await takes them, so a test can wait for them
+// in any order. Await must not be called from several goroutines at once.
+type Client struct {
+	// SessionID and UserData are those the server sent in user-data
+	SessionID string
+	UserData  map[string]string
+	// Timeout bounds Await; DefaultTimeout unless changed
+	Timeout time.Duration
+
+	conn *websocket.Conn
+
+	mutex    sync.Mutex
+	received []Message
+	arrived  chan struct{}
+	closed   bool
+	err      error
+}
+
+// Connect joins docID, with query as extra query parameters such as
+// session or share, and waits for the user-data message the server
+// answers every connection with
+func (server *Server) Connect(docID string, query url.Values) (*Client, error) {
+	target := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	values := url.Values{}
+	for key, value := range query {
+		values[key] = value
+	}
+	values.Set("doc", docID)
+
+	conn, _, err := websocket.DefaultDialer.Dial(target+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{Timeout: DefaultTimeout, conn: conn, arrived: make(chan struct{}, 1)}
+	go client.read()
+
+	message, err := client.Await(Type("user-data"))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("joining %q: %w", docID, err)
+	}
+	var data struct {
+		UserData map[string]string `json:"userData"`
+		Session  struct {
+			SessionID string `json:"sessionId"`
+		} `json:"session"`
+	}
+	if err := message.Decode(&data); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("decoding user-data: %w", err)
+	}
+	client.SessionID = data.Session.SessionID
+	client.UserData = data.UserData
+	return client, nil
+}
+
+// Send sends a message of type msgType carrying data
+func (client *Client) Send(msgType string, data any) error {
+	raw, err := json.Marshal(socket.Message{Type: msgType, Data: data})
+	if err != nil {
+		return err
+	}
+	return client.SendRaw(raw)
+}
+
+// SendRaw sends raw as a text frame, as it is
+func (client *Client) SendRaw(raw []byte) error {
+	return client.conn.WriteMessage(websocket.TextMessage, raw)
+}
+
+// Await takes the first received message match accepts, waiting up to
+// Timeout for one to arrive. Messages it doesn't accept stay for later
+// calls.
+func (client *Client) Await(match func(Message) bool) (Message, error) {
+	timeout := time.NewTimer(client.Timeout)
+	defer timeout.Stop()
+
+	for {
+		client.mutex.Lock()
+		if i := slices.IndexFunc(client.received, match); i >= 0 {
+			message := client.received[i]
+			client.received = slices.Delete(client.received, i, i+1)
+			client.mutex.Unlock()
+			return message, nil
+		}
+		closed, err := client.closed, client.err
+		client.mutex.Unlock()
+		if closed {
+			return Message{}, fmt.Errorf("%w: %w", ErrClosed, err)
+		}
+
+		select {
+		case <-client.arrived:
+		case <-timeout.C:
+			return Message{}, ErrTimeout
+		}
+	}
+}
+
+// AwaitType takes the first received message of type msgType
+func (client *Client) AwaitType(msgType string) (Message, error) {
+	return client.Await(Type(msgType))
+}
+
+// Received returns the messages received and not yet taken, oldest first
+func (client *Client) Received() []Message {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return slices.Clone(client.received)
+}
+
+// Close closes the connection, as a browser tab closing would
+func (client *Client) Close() error {
+	client.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return client.conn.Close()
+}
+
+// read keeps every message received until the connection closes. The
+// server batches messages into one frame, one per line.
+func (client *Client) read() {
+	for {
+		_, frame, err := client.conn.ReadMessage()
+		if err != nil {
+			client.mutex.Lock()
+			client.closed, client.err = true, err
+			client.mutex.Unlock()
+			client.signal()
+			return
+		}
+
+		var messages []Message
+		for line := range strings.Lines(string(frame)) {
+			raw := []byte(strings.TrimSuffix(line, "\n"))
+			var message Message
+			if json.Unmarshal(raw, &message) != nil {
+				continue
+			}
+			message.Raw = raw
+			messages = append(messages, message)
+		}
+		client.mutex.Lock()
+		client.received = append(client.received, messages...)
+		client.mutex.Unlock()
+		client.signal()
+	}
+}
+
+func (client *Client) signal() {
+	select {
+	case client.arrived <- struct{}{}:
+	default:
+	}
+}
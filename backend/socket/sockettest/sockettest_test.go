@@ -0,0 +1,135 @@
+package sockettest_test
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"backend/socket/sockettest"
+)
+
+// connect joins docID, failing the test if it can't
+func connect(t *testing.T, server *sockettest.Server, docID string, query url.Values) *sockettest.Client {
+	t.Helper()
+	client, err := server.Connect(docID, query)
+	if err != nil {
+		t.Fatalf("connecting to %q: %v", docID, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestChat(t *testing.T) {
+	server := sockettest.NewTestServer()
+	defer server.Close()
+	alice := connect(t, server, "doc", nil)
+	bob := connect(t, server, "doc", nil)
+
+	if err := alice.Send("chat", map[string]string{"text": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	message, err := bob.AwaitType("chat")
+	if err != nil {
+		t.Fatalf("awaiting chat: %v", err)
+	}
+	var data struct {
+		Message struct {
+			Text     string            `json:"text"`
+			UserData map[string]string `json:"userData"`
+		} `json:"message"`
+	}
+	if err := message.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Message.Text != "hi" {
+		t.Errorf("text = %q, want %q", data.Message.Text, "hi")
+	}
+	if data.Message.UserData["userId"] != alice.UserData["userId"] {
+		t.Errorf("sender = %q, want %q", data.Message.UserData["userId"], alice.UserData["userId"])
+	}
+}
+
+func TestEditRelay(t *testing.T) {
+	server := sockettest.NewTestServer()
+	defer server.Close()
+	alice := connect(t, server, "doc", nil)
+	bob := connect(t, server, "doc", nil)
+
+	if err := alice.Send("content", map[string]string{"content": "<p>hello</p>"}); err != nil {
+		t.Fatal(err)
+	}
+	message, err := bob.AwaitType("content")
+	if err != nil {
+		t.Fatalf("awaiting content: %v", err)
+	}
+	var data struct {
+		Content  string `json:"content"`
+		Revision int64  `json:"revision"`
+	}
+	if err := message.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(data.Content, "hello") {
+		t.Errorf("content = %q, want it to contain %q", data.Content, "hello")
+	}
+	if data.Revision != 1 {
+		t.Errorf("revision = %d, want 1", data.Revision)
+	}
+}
+
+func TestReconnectWithSession(t *testing.T) {
+	server := sockettest.NewTestServer()
+	defer server.Close()
+	first := connect(t, server, "doc", nil)
+	first.Close()
+
+	again := connect(t, server, "doc", url.Values{"session": {first.SessionID}})
+	if again.UserData["userId"] != first.UserData["userId"] {
+		t.Errorf("user ID after reconnecting = %q, want %q", again.UserData["userId"], first.UserData["userId"])
+	}
+	if again.SessionID != first.SessionID {
+		t.Errorf("session after reconnecting = %q, want %q", again.SessionID, first.SessionID)
+	}
+}
+
+// TestBusyRoom has every client of a room edit and chat at once, so the
+// write pumps, read pumps and broadcasters all run together under -race
+func TestBusyRoom(t *testing.T) {
+	const clients, rounds = 6, 10
+	server := sockettest.NewTestServer()
+	defer server.Close()
+	room := make([]*sockettest.Client, clients)
+	for i := range room {
+		room[i] = connect(t, server, "busy", nil)
+	}
+
+	var wg sync.WaitGroup
+	for i, client := range room {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				content := fmt.Sprintf("<p>client %d round %d</p>", i, round)
+				if err := client.Send("content", map[string]string{"content": content}); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := client.Send("chat", map[string]string{"text": content}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, client := range room {
+		for range clients * rounds {
+			if _, err := client.AwaitType("chat"); err != nil {
+				t.Fatalf("client %d awaiting chat: %v", i, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package socket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Messages a spectator may send; anything else would announce it
+var spectatorMessages = map[string]bool{
+	"ack":            true,
+	"chat":           true,
+	"follow-request": true,
+}
+
+// ViewerCountData is the payload of viewer-count: how many spectators
+// are watching the room
+type ViewerCountData struct {
+	Viewers int `json:"viewers"`
+}
+
+// viewerCounts remembers the count last sent to each room, so a count
+// that didn't change isn't sent again
+type viewerCounts struct {
+	mutex sync.Mutex
+	sent  map[string]int
+}
+
+// joinsAsSpectator reports whether a connection to docID joins as a
+// spectator: it is view-only and the room already has the configured
+// number of announced viewers
+func (manager *WebSocketManager) joinsAsSpectator(docID string, viewOnly bool) bool {
+	threshold := manager.Config.Spectators.Threshold
+	if !viewOnly || threshold == 0 {
+		return false
+	}
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	viewers := 0
+	for client := range manager.Rooms[docID] {
+		if client.ViewOnly && !client.Spectator {
+			viewers++
+		}
+	}
+	return viewers >= threshold
+}
+
+// spectatorCount counts the spectators in docID
+func (manager *WebSocketManager) spectatorCount(docID string) int {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	count := 0
+	for client := range manager.Rooms[docID] {
+		if client.Spectator {
+			count++
+		}
+	}
+	return count
+}
+
+// sendViewerCount tells a client joining a room with spectators how many
+// there are, rather than leaving it to wait for the next broadcast
+func (manager *WebSocketManager) sendViewerCount(client *Client) {
+	if count := manager.spectatorCount(client.DocID); count > 0 {
+		manager.sendMessage(client, Message{Type: "viewer-count", Data: ViewerCountData{Viewers: count}})
+	}
+}
+
+// broadcastViewerCounts sends rooms their spectator count whenever it
+// changed, every configured interval
+func (manager *WebSocketManager) broadcastViewerCounts() {
+	ticker := time.NewTicker(manager.Config.Spectators.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		manager.sendViewerCounts()
+	}
+}
+
+func (manager *WebSocketManager) sendViewerCounts() {
+	manager.Mutex.RLock()
+	counts := make(map[string]int)
+	for docID, room := range manager.Rooms {
+		counts[docID] = 0
+		for client := range room {
+			if client.Spectator {
+				counts[docID]++
+			}
+		}
+	}
+	manager.Mutex.RUnlock()
+
+	changed := make(map[string]int)
+	manager.viewers.mutex.Lock()
+	for docID, sent := range manager.viewers.sent {
+		count, open := counts[docID]
+		if !open {
+			delete(manager.viewers.sent, docID)
+			continue
+		}
+		if count != sent {
+			changed[docID] = count
+		}
+	}
+	for docID, count := range counts {
+		if _, sent := manager.viewers.sent[docID]; !sent && count > 0 {
+			changed[docID] = count
+		}
+	}
+	for docID, count := range changed {
+		if count == 0 {
+			// The room is told once that its last spectator left
+			delete(manager.viewers.sent, docID)
+			continue
+		}
+		manager.viewers.sent[docID] = count
+	}
+	manager.viewers.mutex.Unlock()
+
+	for docID, count := range changed {
+		jsonData, err := json.Marshal(Message{Type: "viewer-count", Data: ViewerCountData{Viewers: count}})
+		if err != nil {
+			manager.Logger.Error("Error marshalling viewer count", "doc_id", docID, "error", err)
+			continue
+		}
+		manager.queueRoomBroadcast(&BroadcastMessage{DocID: docID, Data: jsonData})
+	}
+}
@@ -0,0 +1,150 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"backend/document"
+	"backend/richtext"
+)
+
+// SuggestionData is a pending suggestion as clients see it, the proposed
+// content both as a delta and rendered as HTML
+type SuggestionData struct {
+	ID           string         `json:"id"`
+	Author       string         `json:"author"`
+	BaseRevision int64          `json:"baseRevision"`
+	Content      string         `json:"content"`
+	Delta        richtext.Delta `json:"delta"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+}
+
+func suggestionData(suggestion document.Suggestion) SuggestionData {
+	return SuggestionData{
+		ID:           suggestion.ID,
+		Author:       suggestion.Author,
+		BaseRevision: suggestion.BaseRevision,
+		Content:      richtext.ToHTML(suggestion.Content),
+		Delta:        suggestion.Content,
+		UpdatedAt:    suggestion.UpdatedAt,
+	}
+}
+
+// Statuses a suggestion is resolved with
+const (
+	SuggestionAccepted = "accepted"
+	SuggestionRejected = "rejected"
+)
+
+type suggestionReviewMessage struct {
+	Data struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+// suggest records an edit made in suggesting mode as the client's
+// suggestion and shows it to the room. The author's editor keeps showing
+// the suggestion until it is resolved.
+func (manager *WebSocketManager) suggest(client *Client, base int64, content richtext.Delta) bool {
+	suggestion, err := client.Doc.Suggest(client.ID, base, content)
+	if err != nil {
+		manager.sendEditError(client, err)
+		return false
+	}
+	payload, err := json.Marshal(Message{Type: "suggestion", Data: suggestionData(suggestion)})
+	if err != nil {
+		client.Logger.Error("Error marshalling suggestion", "error", err)
+		return true
+	}
+	manager.BroadcastToRoom(client.DocID, payload)
+	return true
+}
+
+// handleSuggestionAccept applies a suggestion for the owner. Everyone gets
+// a doc-sync of the result, since no one in the room has it applied yet,
+// the author's editor included.
+func (manager *WebSocketManager) handleSuggestionAccept(client *Client, message []byte) {
+	var request suggestionReviewMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "suggestion-accept requires a data.id string")
+		return
+	}
+
+	op, suggestion, err := client.Doc.AcceptSuggestion(client.ID, request.Data.ID, manager.syncRoom(client.DocID))
+	if errors.Is(err, document.ErrStaleRevision) {
+		manager.sendError(client, ErrCodeStaleRevision, "the document has changed since the suggestion was made, it is brought up to date when its author edits again")
+		return
+	}
+	if err != nil {
+		manager.sendReviewError(client, err)
+		return
+	}
+	client.Logger.Info("Suggestion accepted", "suggestion_id", suggestion.ID, "author", suggestion.Author, "revision", op.Revision)
+	manager.opApplied(client.Doc, op)
+	manager.editTracked(client.Doc)
+	manager.broadcastResolved(client.DocID, suggestion, SuggestionAccepted)
+}
+
+// handleSuggestionReject drops a suggestion for the owner and brings the
+// author's editor back to the document
+func (manager *WebSocketManager) handleSuggestionReject(client *Client, message []byte) {
+	var request suggestionReviewMessage
+	if err := json.Unmarshal(message, &request); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "suggestion-reject requires a data.id string")
+		return
+	}
+
+	suggestion, err := client.Doc.RejectSuggestion(client.ID, request.Data.ID)
+	if err != nil {
+		manager.sendReviewError(client, err)
+		return
+	}
+	client.Logger.Info("Suggestion rejected", "suggestion_id", suggestion.ID, "author", suggestion.Author)
+	manager.broadcastResolved(client.DocID, suggestion, SuggestionRejected)
+	for _, member := range manager.roomMembers(client.DocID) {
+		if member.ID == suggestion.Author {
+			manager.sendDocSync(member)
+		}
+	}
+}
+
+func (manager *WebSocketManager) sendReviewError(client *Client, err error) {
+	if manager.sendLimitError(client, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, document.ErrNotReviewer):
+		manager.sendError(client, ErrCodeForbidden, err.Error())
+	case errors.Is(err, document.ErrFrozen):
+		manager.sendError(client, ErrCodeRoomMigrating, err.Error())
+	default:
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+	}
+}
+
+// broadcastResolved tells the room a suggestion is no longer pending
+func (manager *WebSocketManager) broadcastResolved(docID string, suggestion document.Suggestion, status string) {
+	payload, err := json.Marshal(Message{
+		Type: "suggestion-resolved",
+		Data: map[string]string{"id": suggestion.ID, "author": suggestion.Author, "status": status},
+	})
+	if err != nil {
+		manager.Logger.Error("Error marshalling suggestion-resolved message", "doc_id", docID, "error", err)
+		return
+	}
+	manager.BroadcastToRoom(docID, payload)
+}
+
+// sendSuggestions gives a joining client the pending suggestions
+func (manager *WebSocketManager) sendSuggestions(client *Client) {
+	pending := client.Doc.Suggestions()
+	suggestions := make([]SuggestionData, 0, len(pending))
+	for _, suggestion := range pending {
+		suggestions = append(suggestions, suggestionData(suggestion))
+	}
+	manager.sendMessage(client, Message{
+		Type: "suggestions",
+		Data: map[string][]SuggestionData{"suggestions": suggestions},
+	})
+}
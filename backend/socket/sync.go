@@ -0,0 +1,347 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"strconv"
+
+	"backend/document"
+	"backend/events"
+	"backend/richtext"
+)
+
+// contentMessage is the inbound shape of content edits. Data is kept raw so
+// the relayed frame preserves every field the client sent.
+type contentMessage struct {
+	Type string                     `json:"type"`
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// ErrCodeStaleRevision answers a delta made against an outdated revision
+const ErrCodeStaleRevision = "stale-revision"
+
+// DocSyncData is the payload of doc-sync: the full document at a revision,
+// both as a delta and rendered as HTML
+type DocSyncData struct {
+	Content  string         `json:"content"`
+	Delta    richtext.Delta `json:"delta"`
+	Revision int64          `json:"revision"`
+}
+
+type ackMessage struct {
+	Data struct {
+		Revision int64 `json:"revision"`
+	} `json:"data"`
+}
+
+// handleContent applies an edit to the room's document and relays it,
+// stamped with the revision it was assigned, to the rest of the room. An
+// edit is either a change in data.delta against data.baseRevision or, from
+// clients that only deal in HTML, the whole document in data.content.
+// Relayed frames always carry the resulting HTML in data.content. Edits
+// numbered in data.seq are acknowledged with an edit-ack.
+func (manager *WebSocketManager) handleContent(client *Client, message []byte) {
+	var edit contentMessage
+	if err := json.Unmarshal(message, &edit); err != nil || edit.Data == nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "content messages require a data object")
+		return
+	}
+	seq, sequenced, err := editSeq(edit)
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return
+	}
+	if sequenced && !manager.sequenceEdit(client, seq) {
+		return
+	}
+
+	ack := manager.applyEdit(client, edit)
+	if sequenced {
+		ack.Seq = seq
+		manager.sendMessage(client, Message{Type: "edit-ack", Data: ack})
+	}
+}
+
+// applyEdit applies a whole document edit, or hands a delta edit to
+// applyDelta, and reports what became of it
+func (manager *WebSocketManager) applyEdit(client *Client, edit contentMessage) EditAckData {
+	if raw, ok := edit.Data["delta"]; ok {
+		return manager.applyDelta(client, edit, raw)
+	}
+
+	var content string
+	if err := json.Unmarshal(edit.Data["content"], &content); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "content messages require a data.content string or a data.delta")
+		return EditAckData{Status: EditRejected}
+	}
+	delta, err := richtext.FromHTML(content)
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "data.content is not valid HTML")
+		return EditAckData{Status: EditRejected}
+	}
+
+	// Whole document edits replace whatever is there, so they are
+	// suggested on top of the latest revision
+	if client.Doc.Capabilities(client.ID).Suggest {
+		return suggested(manager.suggest(client, client.Doc.Revision(), delta))
+	}
+	op, err := client.Doc.Replace(client.ID, delta, manager.relayEdit(client, edit, nil, 0))
+	if err != nil {
+		manager.sendEditError(client, err)
+		return EditAckData{Status: EditRejected}
+	}
+	manager.contentApplied(client, op)
+	return EditAckData{Status: EditCommitted, Revision: op.Revision}
+}
+
+func (manager *WebSocketManager) applyDelta(client *Client, edit contentMessage, raw json.RawMessage) EditAckData {
+	var change richtext.Delta
+	var base int64
+	if json.Unmarshal(raw, &change) != nil || json.Unmarshal(edit.Data["baseRevision"], &base) != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "delta edits require a data.delta array and a data.baseRevision number")
+		return EditAckData{Status: EditRejected}
+	}
+	change, err := richtext.NormalizeChange(change)
+	if err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+		return EditAckData{Status: EditRejected}
+	}
+	if edit.Data["delta"], err = json.Marshal(change); err != nil {
+		client.Logger.Error("Error marshalling delta", "error", err)
+		return EditAckData{Status: EditRejected}
+	}
+
+	if client.Doc.Capabilities(client.ID).Suggest {
+		content, revision := client.Doc.Contents()
+		if base != revision {
+			manager.sendEditError(client, document.ErrStaleRevision)
+			return EditAckData{Status: EditRejected}
+		}
+		composed, err := richtext.Compose(content, change)
+		if err != nil {
+			manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+			return EditAckData{Status: EditRejected}
+		}
+		return suggested(manager.suggest(client, base, composed))
+	}
+	op, err := client.Doc.ApplyChange(client.ID, base, change, manager.relayEdit(client, edit, change, base))
+	if err != nil {
+		manager.sendEditError(client, err)
+		return EditAckData{Status: EditRejected}
+	}
+	manager.contentApplied(client, op)
+	return EditAckData{Status: EditCommitted, Revision: op.Revision}
+}
+
+// sendEditError answers an edit that couldn't be applied
+func (manager *WebSocketManager) sendEditError(client *Client, err error) {
+	if manager.sendLimitError(client, err) {
+		// The client has the edit applied; it goes back to what was
+		manager.sendDocSync(client)
+		return
+	}
+	switch {
+	case errors.Is(err, document.ErrStaleRevision):
+		manager.sendError(client, ErrCodeStaleRevision, "the document has changed, redo the edit on the doc-sync that follows")
+		manager.sendDocSync(client)
+	case errors.Is(err, document.ErrFrozen):
+		manager.sendError(client, ErrCodeRoomMigrating, "the room is moving to another node, redo the edit once reconnected")
+	case errors.Is(err, document.ErrEditRestricted):
+		manager.sendError(client, ErrCodeForbidden, err.Error())
+		manager.sendDocSync(client)
+	default:
+		manager.sendError(client, ErrCodeInvalidMessage, err.Error())
+	}
+}
+
+// relayEdit stamps an edit with its revision and the resulting HTML and
+// relays it to the rest of the room. It runs while the document is locked,
+// which keeps frames in revision order when several clients edit at once.
+// change is the delta the edit made against base, or nil for a whole
+// document edit.
+func (manager *WebSocketManager) relayEdit(client *Client, edit contentMessage, change richtext.Delta, base int64) document.Payload {
+	return func(revision int64, content richtext.Delta) []byte {
+		edit.Data["revision"] = json.RawMessage(strconv.FormatInt(revision, 10))
+		html, err := json.Marshal(richtext.ToHTML(content))
+		if err == nil {
+			edit.Data["content"] = html
+		}
+		payload, err := json.Marshal(edit)
+		if err != nil {
+			client.Logger.Error("Error marshalling content message", "error", err)
+			return nil
+		}
+		manager.queueBroadcast(&BroadcastMessage{
+			DocID:  client.DocID,
+			Sender: client,
+			Data:   payload,
+			edit: &relayedEdit{
+				frame:    contentMessage{Type: edit.Type, Data: maps.Clone(edit.Data)},
+				change:   change,
+				base:     base,
+				revision: revision,
+			},
+		})
+		return payload
+	}
+}
+
+func (manager *WebSocketManager) contentApplied(client *Client, op document.Op) {
+	// The author already has its own edit applied
+	manager.Sessions.Ack(client.SessionID, client.DocID, op.Revision)
+	manager.markSeen(client, op.Revision)
+	manager.opApplied(client.Doc, op)
+	manager.editTracked(client.Doc)
+}
+
+// opApplied checks an op against the canary engine and publishes it
+func (manager *WebSocketManager) opApplied(doc *document.Document, op document.Op) {
+	// Only checked when no other edit landed meanwhile, the primary
+	// engine's text at op.Revision is gone otherwise
+	if manager.Canary.Covers(doc.ID) {
+		if content, revision := doc.Contents(); revision == op.Revision {
+			manager.Canary.Check(doc.ID, op, content.Text())
+		}
+	}
+
+	manager.emitEvent(events.TypeDocumentUpdated, doc.ID, op.Author,
+		events.DocumentUpdated{Revision: op.Revision})
+	manager.auditEdit(doc.ID, op)
+	manager.notifyEdit(doc, op)
+	manager.publishOp(doc.ID, op)
+	manager.broadcastAttribution(doc.ID, op)
+	manager.logEvent(events.TypeOpApplied, doc.ID, op.Author, events.OpApplied{
+		Revision:     op.Revision,
+		Pos:          op.Edit.Pos,
+		Deleted:      op.Edit.Deleted,
+		Inserted:     op.Edit.Inserted,
+		DeletedText:  op.Deleted,
+		InsertedText: op.Inserted,
+		Frame:        op.Payload,
+	})
+}
+
+// editTracked sends the room the pending changes after an edit when there
+// are any to follow it
+func (manager *WebSocketManager) editTracked(doc *document.Document) {
+	if doc.Permissions().TrackChanges || len(TrackedChanges(doc)) > 0 {
+		manager.broadcastTrackedChanges(doc)
+	}
+}
+
+// ReplaceContent overwrites a document on behalf of someone outside the
+// room, such as an import, and sends everyone in the room a doc-sync
+func (manager *WebSocketManager) ReplaceContent(docID string, author Session, content richtext.Delta) (int64, error) {
+	doc, err := manager.Documents.Open(docID)
+	if err != nil {
+		return 0, err
+	}
+	doc.Join(author.UserID)
+	op, err := doc.Replace(author.UserID, content, manager.syncRoom(docID))
+	if err != nil {
+		return 0, err
+	}
+
+	manager.opApplied(doc, op)
+	manager.editTracked(doc)
+	return op.Revision, nil
+}
+
+// syncRoom sends everyone in the room a doc-sync of an edit none of them
+// made
+func (manager *WebSocketManager) syncRoom(docID string) document.Payload {
+	return func(revision int64, content richtext.Delta) []byte {
+		payload, err := json.Marshal(Message{
+			Type: "doc-sync",
+			Data: DocSyncData{Content: richtext.ToHTML(content), Delta: content, Revision: revision},
+		})
+		if err != nil {
+			manager.Logger.Error("Error marshalling doc-sync message", "doc_id", docID, "error", err)
+			return nil
+		}
+		manager.BroadcastToRoom(docID, payload)
+		return payload
+	}
+}
+
+// handleAck records the latest revision a client has applied, which is
+// where replay resumes if it reconnects
+func (manager *WebSocketManager) handleAck(client *Client, message []byte) {
+	var ack ackMessage
+	if err := json.Unmarshal(message, &ack); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "ack messages require a data.revision number")
+		return
+	}
+	revision := client.Doc.Revision()
+	if ack.Data.Revision < 0 || ack.Data.Revision > revision {
+		manager.sendError(client, ErrCodeInvalidMessage, "ack revision is out of range")
+		return
+	}
+	manager.Sessions.Ack(client.SessionID, client.DocID, ack.Data.Revision)
+	manager.markSeen(client, ack.Data.Revision)
+}
+
+// sendDocumentState brings a joining client up to date: the document's
+// metadata and what the client may do with it, then the ops it missed for
+// a resumed session or a full doc-sync for anyone else
+func (manager *WebSocketManager) sendDocumentState(client *Client) {
+	manager.sendMetadata(client)
+	manager.sendCapabilities(client)
+	manager.sendSuggestions(client)
+	manager.sendTrackedChanges(client)
+	manager.sendReactions(client)
+	manager.sendAnnotations(client)
+	manager.sendSaveStatus(client)
+	manager.sendSeenState(client)
+
+	if acked, ok := manager.Sessions.Acked(client.SessionID, client.DocID); ok {
+		if ops, ok := client.Doc.OpsSince(acked); ok {
+			for _, op := range ops {
+				if err := manager.sendToClient(client, op.Payload); err != nil {
+					client.Logger.Warn("Could not replay missed op", "revision", op.Revision, "error", err)
+					return
+				}
+			}
+			client.Logger.Debug("Replayed missed ops", "since", acked, "count", len(ops))
+			return
+		}
+		client.Logger.Debug("Missed ops no longer in history, sending full sync", "since", acked)
+	}
+
+	manager.sendDocSync(client)
+}
+
+// sendDocSync sends the whole document, which counts as the client having
+// seen it
+func (manager *WebSocketManager) sendDocSync(client *Client) {
+	content, revision := client.Doc.Contents()
+	manager.sendMessage(client, Message{
+		Type: "doc-sync",
+		Data: DocSyncData{Content: richtext.ToHTML(content), Delta: content, Revision: revision},
+	})
+	manager.markSeen(client, revision)
+}
+
+// emitEvent publishes a change event if the event stream is enabled
+func (manager *WebSocketManager) emitEvent(eventType string, docID string, actor string, payload any) {
+	event, err := events.New(eventType, docID, actor, payload)
+	if err != nil {
+		manager.Logger.Error("Error building change event", "type", eventType, "error", err)
+		return
+	}
+	manager.Events.Emit(event)
+}
+
+// logEvent appends an event to the event log
+func (manager *WebSocketManager) logEvent(eventType string, docID string, actor string, payload any) {
+	if manager.EventLog == nil {
+		return
+	}
+	event, err := events.New(eventType, docID, actor, payload)
+	if err != nil {
+		manager.Logger.Error("Error building log event", "type", eventType, "error", err)
+		return
+	}
+	manager.EventLog.Emit(event)
+}
@@ -0,0 +1,433 @@
+package socket
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/audit"
+	"backend/blobs"
+	"backend/document"
+	"backend/export"
+	"backend/folders"
+	"backend/ids"
+	"backend/richtext"
+)
+
+// States of a takeout
+const (
+	TakeoutRunning = "running"
+	TakeoutDone    = "done"
+	TakeoutFailed  = "failed"
+)
+
+// How long a finished takeout and its archive are kept
+const takeoutExpiry = 24 * time.Hour
+
+var (
+	ErrTakeoutNotFound = errors.New("takeout not found")
+	ErrTakeoutRunning  = errors.New("a takeout of this workspace is already running")
+	ErrTakeoutNotReady = errors.New("the takeout archive is not ready")
+)
+
+// Attachments are found in a document by the URLs it links or embeds them
+// with, the only place they are listed
+var attachmentURL = regexp.MustCompile(`/api/attachments/([^/"'\s?#]+)/([0-9a-f]+)`)
+
+// Takeout is a job archiving a user's workspace: the documents they own,
+// rendered in Format, with their metadata, comments and attachments, and
+// the folders they are filed in. Failed lists the documents left out and
+// why; Error says why the whole job failed.
+type Takeout struct {
+	ID         string            `json:"id"`
+	Format     string            `json:"format"`
+	State      string            `json:"state"`
+	Documents  int               `json:"documents"`
+	Failed     map[string]string `json:"failed,omitempty"`
+	Size       int64             `json:"size,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	FinishedAt time.Time         `json:"finishedAt,omitzero"`
+
+	owner string
+}
+
+// TakeoutManifest is manifest.json at the root of a takeout archive
+type TakeoutManifest struct {
+	Owner     string            `json:"owner"`
+	Format    string            `json:"format"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Folders   []folders.Folder  `json:"folders"`
+	Documents []TakeoutDocument `json:"documents"`
+	Failed    map[string]string `json:"failed,omitempty"`
+}
+
+// TakeoutDocument is a document as listed in the manifest. Path is where
+// the archive holds its content, Comments its comments and Attachments the
+// files it links to, by attachment ID.
+type TakeoutDocument struct {
+	ID          string               `json:"id"`
+	FolderID    string               `json:"folderId,omitempty"`
+	Revision    int64                `json:"revision"`
+	Metadata    document.Metadata    `json:"metadata"`
+	Permissions document.Permissions `json:"permissions"`
+	UpdatedAt   time.Time            `json:"updatedAt"`
+	Path        string               `json:"path"`
+	Comments    string               `json:"comments,omitempty"`
+	Attachments map[string]string    `json:"attachments,omitempty"`
+}
+
+// takeouts are the takeout jobs of every user, by ID
+type takeouts struct {
+	mutex sync.Mutex
+	jobs  map[string]*Takeout
+}
+
+// StartTakeout starts archiving the workspace of user in the background,
+// with documents rendered in format, and returns the job to follow
+func (manager *WebSocketManager) StartTakeout(user Session, format export.Format) (Takeout, error) {
+	manager.takeouts.mutex.Lock()
+	defer manager.takeouts.mutex.Unlock()
+
+	manager.pruneTakeouts()
+	for _, job := range manager.takeouts.jobs {
+		if job.owner == user.UserID && job.State == TakeoutRunning {
+			return Takeout{}, ErrTakeoutRunning
+		}
+	}
+	job := &Takeout{
+		ID:        ids.RandomHex(16),
+		Format:    format.Name,
+		State:     TakeoutRunning,
+		CreatedAt: time.Now().UTC(),
+		owner:     user.UserID,
+	}
+	manager.takeouts.jobs[job.ID] = job
+	go manager.runTakeout(job.ID, user, format)
+	return *job, nil
+}
+
+// Takeout returns the takeout id of user
+func (manager *WebSocketManager) Takeout(user Session, id string) (Takeout, error) {
+	manager.takeouts.mutex.Lock()
+	defer manager.takeouts.mutex.Unlock()
+
+	job, ok := manager.takeouts.jobs[id]
+	if !ok || job.owner != user.UserID {
+		return Takeout{}, ErrTakeoutNotFound
+	}
+	return *job, nil
+}
+
+// OpenTakeout returns the archive of the finished takeout id of user
+func (manager *WebSocketManager) OpenTakeout(ctx context.Context, user Session, id string) (io.ReadCloser, blobs.Info, error) {
+	job, err := manager.Takeout(user, id)
+	if err != nil {
+		return nil, blobs.Info{}, err
+	}
+	if job.State != TakeoutDone {
+		return nil, blobs.Info{}, ErrTakeoutNotReady
+	}
+	return manager.Takeouts.Get(ctx, takeoutKey(id))
+}
+
+// pruneTakeouts forgets the takeouts finished longer ago than the expiry
+// and deletes their archives. It must be called with the jobs locked.
+func (manager *WebSocketManager) pruneTakeouts() {
+	for id, job := range manager.takeouts.jobs {
+		if job.State == TakeoutRunning || time.Since(job.FinishedAt) < takeoutExpiry {
+			continue
+		}
+		delete(manager.takeouts.jobs, id)
+		if job.State == TakeoutDone {
+			go func() {
+				ctx, cancel := context.WithTimeout(manager.ctx, 30*time.Second)
+				defer cancel()
+				if err := manager.Takeouts.Delete(ctx, takeoutKey(id)); err != nil && !errors.Is(err, blobs.ErrNotFound) {
+					manager.Logger.Warn("Could not delete takeout archive", "takeout_id", id, "error", err)
+				}
+			}()
+		}
+	}
+}
+
+func takeoutKey(id string) string {
+	return id + ".zip"
+}
+
+// runTakeout writes the archive of job to a temporary file and keeps it in
+// the takeout store once complete
+func (manager *WebSocketManager) runTakeout(id string, user Session, format export.Format) {
+	ctx := manager.ctx
+	started := time.Now()
+	manifest, size, err := manager.writeTakeout(ctx, id, user, format)
+
+	manager.takeouts.mutex.Lock()
+	job := manager.takeouts.jobs[id]
+	job.FinishedAt = time.Now().UTC()
+	if err != nil {
+		job.State = TakeoutFailed
+		job.Error = err.Error()
+	} else {
+		job.State = TakeoutDone
+		job.Documents = len(manifest.Documents)
+		job.Failed = manifest.Failed
+		job.Size = size
+	}
+	manager.takeouts.mutex.Unlock()
+
+	if err != nil {
+		manager.Logger.Error("Takeout failed", "takeout_id", id, "user_id", user.UserID, "error", err)
+		return
+	}
+	manager.Logger.Info("Takeout done", "takeout_id", id, "user_id", user.UserID, "documents", len(manifest.Documents),
+		"failed", len(manifest.Failed), "bytes", size, "took", time.Since(started))
+}
+
+func (manager *WebSocketManager) writeTakeout(ctx context.Context, id string, user Session, format export.Format) (TakeoutManifest, int64, error) {
+	file, err := os.CreateTemp("", "takeout-*.zip")
+	if err != nil {
+		return TakeoutManifest{}, 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	manifest, err := manager.archiveWorkspace(ctx, file, id, user, format)
+	if err != nil {
+		return TakeoutManifest{}, 0, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return TakeoutManifest{}, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return TakeoutManifest{}, 0, err
+	}
+	info := blobs.Info{Name: "takeout-" + id + ".zip", ContentType: "application/zip", Size: size}
+	if err := manager.Takeouts.Put(ctx, takeoutKey(id), file, info); err != nil {
+		return TakeoutManifest{}, 0, fmt.Errorf("keeping the archive: %w", err)
+	}
+	return manifest, size, nil
+}
+
+// archiveWorkspace zips the documents user owns in their tenant, trashed
+// ones left out, into w: each under documents/ in the folders it is filed
+// in, its comments under comments/ and its attachments under
+// attachments/, with manifest.json listing them all. A document that can't
+// be archived is listed as failed rather than failing the takeout.
+func (manager *WebSocketManager) archiveWorkspace(ctx context.Context, w io.Writer, id string, user Session, format export.Format) (TakeoutManifest, error) {
+	workspace, err := manager.Folders.LoadWorkspace(ctx, user.UserID)
+	if err != nil {
+		return TakeoutManifest{}, fmt.Errorf("loading the workspace: %w", err)
+	}
+	records, err := manager.Documents.Records(ctx)
+	if err != nil {
+		return TakeoutManifest{}, fmt.Errorf("listing documents: %w", err)
+	}
+
+	manifest := TakeoutManifest{
+		Owner:     user.UserID,
+		Format:    format.Name,
+		CreatedAt: time.Now().UTC(),
+		Folders:   workspace.Folders,
+		Documents: []TakeoutDocument{},
+	}
+	archive := zip.NewWriter(w)
+	paths := folderPaths(workspace)
+	taken := make(map[string]bool)
+	for _, record := range records {
+		if record.Permissions.Owner != user.UserID || record.Tenant != user.Tenant || !record.TrashedAt.IsZero() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return TakeoutManifest{}, err
+		}
+		entry, err := manager.archiveDocument(ctx, archive, record, user, format, workspace.Documents[record.ID], paths, taken)
+		if err != nil {
+			if manifest.Failed == nil {
+				manifest.Failed = make(map[string]string)
+			}
+			manifest.Failed[record.ID] = err.Error()
+			continue
+		}
+		manifest.Documents = append(manifest.Documents, entry)
+		manager.Audit.Record(audit.Entry{
+			Action:   audit.ActionExported,
+			DocID:    record.ID,
+			UserID:   user.UserID,
+			Revision: record.Revision,
+			Details:  map[string]string{"format": format.Name, "takeout": id},
+		})
+	}
+
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return TakeoutManifest{}, err
+	}
+	if err := writeZipFile(archive, "manifest.json", raw); err != nil {
+		return TakeoutManifest{}, err
+	}
+	return manifest, archive.Close()
+}
+
+// archiveDocument adds a document, its comments and its attachments to
+// archive. taken holds the paths used so far, so documents with the same
+// title don't overwrite each other.
+func (manager *WebSocketManager) archiveDocument(ctx context.Context, archive *zip.Writer, record document.Record, user Session, format export.Format, folderID string, paths map[string]string, taken map[string]bool) (TakeoutDocument, error) {
+	entry := TakeoutDocument{
+		ID:          record.ID,
+		FolderID:    folderID,
+		Revision:    record.Revision,
+		Metadata:    record.Metadata,
+		Permissions: record.Permissions,
+		UpdatedAt:   record.UpdatedAt,
+	}
+	name := cleanName(record.Metadata.Title)
+	if name == "" {
+		name = record.ID
+	}
+	entry.Path = path.Join("documents", paths[folderID], name+format.Extension)
+	if taken[entry.Path] {
+		entry.Path = path.Join("documents", paths[folderID], name+"-"+record.ID+format.Extension)
+	}
+	taken[entry.Path] = true
+
+	content := richtext.ToHTML(record.Content)
+	file, err := archive.Create(entry.Path)
+	if err != nil {
+		return TakeoutDocument{}, err
+	}
+	if err := format.Render(file, ExportInfo(record.ID, record.Metadata), content); err != nil {
+		return TakeoutDocument{}, fmt.Errorf("rendering: %w", err)
+	}
+
+	list, err := manager.ListComments(user.Tenant, record.ID)
+	if err != nil {
+		return TakeoutDocument{}, fmt.Errorf("listing comments: %w", err)
+	}
+	if len(list) > 0 {
+		raw, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return TakeoutDocument{}, err
+		}
+		entry.Comments = path.Join("comments", record.ID+".json")
+		if err := writeZipFile(archive, entry.Comments, raw); err != nil {
+			return TakeoutDocument{}, err
+		}
+	}
+
+	for _, match := range attachmentURL.FindAllStringSubmatch(content, -1) {
+		attachmentID := match[2]
+		if match[1] != record.ID || entry.Attachments[attachmentID] != "" {
+			continue
+		}
+		archived, err := manager.archiveAttachment(ctx, archive, record.ID, attachmentID)
+		if errors.Is(err, blobs.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return TakeoutDocument{}, fmt.Errorf("attachment %s: %w", attachmentID, err)
+		}
+		if entry.Attachments == nil {
+			entry.Attachments = make(map[string]string)
+		}
+		entry.Attachments[attachmentID] = archived
+	}
+	return entry, nil
+}
+
+// archiveAttachment copies an attachment of docID into archive and returns
+// where
+func (manager *WebSocketManager) archiveAttachment(ctx context.Context, archive *zip.Writer, docID string, id string) (string, error) {
+	body, info, err := manager.OpenAttachment(ctx, docID, id)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	name := cleanName(info.Name)
+	if name == "" {
+		name = "attachment"
+	}
+	archived := path.Join("attachments", docID, id, name)
+	file, err := archive.Create(archived)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		return "", err
+	}
+	return archived, nil
+}
+
+// folderPaths returns the path in the archive of each folder of workspace,
+// by folder ID, made of the names of the folders above it. The root is the
+// empty path.
+func folderPaths(workspace folders.Workspace) map[string]string {
+	byID := make(map[string]folders.Folder, len(workspace.Folders))
+	for _, folder := range workspace.Folders {
+		byID[folder.ID] = folder
+	}
+	paths := map[string]string{"": ""}
+	var resolve func(id string) string
+	resolve = func(id string) string {
+		if folderPath, ok := paths[id]; ok {
+			return folderPath
+		}
+		folder := byID[id]
+		name := cleanName(folder.Name)
+		if name == "" {
+			name = folder.ID
+		}
+		paths[id] = path.Join(resolve(folder.ParentID), name)
+		return paths[id]
+	}
+	for _, id := range slices.Sorted(maps.Keys(byID)) {
+		resolve(id)
+	}
+	return paths
+}
+
+// cleanName makes name safe as one element of a path in an archive
+func cleanName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' || r == 0x7f {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if strings.Trim(name, ".") == "" {
+		return ""
+	}
+	return name
+}
+
+func writeZipFile(archive *zip.Writer, name string, raw []byte) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(raw)
+	return err
+}
+
+// ExportInfo is the metadata of docID carried by the export formats that
+// support it
+func ExportInfo(docID string, metadata document.Metadata) export.Info {
+	title := metadata.Title
+	if title == "" {
+		title = docID
+	}
+	return export.Info{Title: title, Language: metadata.Language, Direction: metadata.Direction}
+}
@@ -0,0 +1,113 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"backend/metrics"
+)
+
+// Kinds of problems clients report
+const (
+	// ClientErrorSyncFailure is an edit or connection the client couldn't
+	// get through, such as a rejected message or a socket error
+	ClientErrorSyncFailure = "sync-failure"
+	// ClientErrorDesync is the client noticing its copy of the document
+	// disagrees with the server's
+	ClientErrorDesync = "desync"
+)
+
+var clientErrorKinds = []string{ClientErrorSyncFailure, ClientErrorDesync}
+
+// Length limits on the free-form fields of a report
+const (
+	maxReportMessageLength = 1000
+	maxReportFieldLength   = 128
+)
+
+// ClientErrorReport is a problem a client noticed on its side of the
+// protocol, with what it knew at the time
+type ClientErrorReport struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	DocID   string `json:"docId"`
+
+	// Revision is the last revision the client had applied. Code is the
+	// error code it received, MessageType the message it was handling.
+	Revision    *int64    `json:"revision,omitempty"`
+	Code        string    `json:"code,omitempty"`
+	MessageType string    `json:"messageType,omitempty"`
+	Encoding    Encoding  `json:"encoding,omitempty"`
+	OccurredAt  time.Time `json:"occurredAt"`
+}
+
+func (report ClientErrorReport) Validate() error {
+	if !slices.Contains(clientErrorKinds, report.Kind) {
+		return fmt.Errorf("kind must be one of %v", clientErrorKinds)
+	}
+	if report.Message == "" || utf8.RuneCountInString(report.Message) > maxReportMessageLength {
+		return fmt.Errorf("message must be 1 to %d characters", maxReportMessageLength)
+	}
+	if report.DocID == "" || len(report.DocID) > maxReportFieldLength {
+		return fmt.Errorf("docId must be 1 to %d bytes", maxReportFieldLength)
+	}
+	if report.Revision != nil && *report.Revision < 0 {
+		return errors.New("revision must not be negative")
+	}
+	if len(report.Code) > maxReportFieldLength || len(report.MessageType) > maxReportFieldLength {
+		return fmt.Errorf("code and messageType are limited to %d bytes", maxReportFieldLength)
+	}
+	if report.Encoding != "" && !slices.Contains(Encodings, report.Encoding) {
+		return fmt.Errorf("encoding must be one of %v", Encodings)
+	}
+	return nil
+}
+
+// ReportClientError logs a validated report from userID with the same
+// doc_id and user_id attributes as the room's own logs, plus the user's
+// connections to the room and the server's revision, so the two sides can
+// be lined up
+func (manager *WebSocketManager) ReportClientError(userID string, report ClientErrorReport) {
+	metrics.ClientErrors.WithLabelValues(report.Kind).Inc()
+
+	var connIDs []string
+	var connected *Client
+	manager.Mutex.RLock()
+	for client := range manager.Rooms[report.DocID] {
+		if client.ID == userID && client.ImpersonatedBy == "" {
+			connIDs = append(connIDs, client.ConnID)
+			connected = client
+		}
+	}
+	manager.Mutex.RUnlock()
+
+	attrs := []any{
+		"kind", report.Kind,
+		"doc_id", report.DocID,
+		"user_id", userID,
+		"conn_ids", connIDs,
+		"message", report.Message,
+	}
+	if report.Revision != nil {
+		attrs = append(attrs, "client_revision", *report.Revision)
+	}
+	if connected != nil {
+		attrs = append(attrs, "server_revision", connected.Doc.Revision())
+	}
+	if report.Code != "" {
+		attrs = append(attrs, "code", report.Code)
+	}
+	if report.MessageType != "" {
+		attrs = append(attrs, "message_type", report.MessageType)
+	}
+	if report.Encoding != "" {
+		attrs = append(attrs, "encoding", report.Encoding)
+	}
+	if !report.OccurredAt.IsZero() {
+		attrs = append(attrs, "occurred_at", report.OccurredAt)
+	}
+	manager.Logger.Warn("Client error reported", attrs...)
+}
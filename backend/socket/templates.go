@@ -0,0 +1,101 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"backend/ids"
+	"backend/richtext"
+	"backend/templates"
+)
+
+// CreateTemplate stores template under a new ID as created by author, for
+// author's tenant, with its content normalized
+func (manager *WebSocketManager) CreateTemplate(ctx context.Context, author Session, template templates.Template) (templates.Template, error) {
+	if err := template.Validate(); err != nil {
+		return templates.Template{}, err
+	}
+	content, err := richtext.Normalize(template.Content)
+	if err != nil {
+		return templates.Template{}, fmt.Errorf("%w: %w", templates.ErrInvalid, err)
+	}
+	template.ID = ids.RandomHex(8)
+	template.Content = content
+	template.CreatedAt = time.Now().UTC()
+	template.CreatedBy = author.UserData()
+	template.Tenant = author.Tenant
+	if err := manager.Templates.CreateTemplate(ctx, template); err != nil {
+		return templates.Template{}, err
+	}
+	return template, nil
+}
+
+// TenantTemplates returns tenant's templates, sorted by name
+func (manager *WebSocketManager) TenantTemplates(ctx context.Context, tenant string) ([]templates.Template, error) {
+	list, err := manager.Templates.ListTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(list, func(template templates.Template) bool { return template.Tenant != tenant }), nil
+}
+
+// TenantTemplate returns the template id if it belongs to tenant, and
+// templates.ErrNotFound otherwise, so other tenants' templates can't be
+// told from missing ones
+func (manager *WebSocketManager) TenantTemplate(ctx context.Context, tenant string, id string) (templates.Template, error) {
+	template, err := manager.Templates.GetTemplate(ctx, id)
+	if err != nil {
+		return templates.Template{}, err
+	}
+	if template.Tenant != tenant {
+		return templates.Template{}, templates.ErrNotFound
+	}
+	return template, nil
+}
+
+// CreateDocument creates a document owned by author under a new ID, empty
+// or with the content of the template templateID, files it in the folder
+// folderID of the author's workspace, or at its root, and returns the ID
+// and revision
+func (manager *WebSocketManager) CreateDocument(ctx context.Context, author Session, templateID string, folderID string) (string, int64, error) {
+	if folderID != "" {
+		workspace, err := manager.Folders.LoadWorkspace(ctx, author.UserID)
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := workspace.Folder(folderID); err != nil {
+			return "", 0, err
+		}
+	}
+
+	var template templates.Template
+	if templateID != "" {
+		var err error
+		if template, err = manager.TenantTemplate(ctx, author.Tenant, templateID); err != nil {
+			return "", 0, err
+		}
+	}
+
+	docID := ids.NewUUID()
+	doc, err := manager.OpenTenantDocument(ctx, author.Tenant, docID, false)
+	if err != nil {
+		return "", 0, err
+	}
+	var revision int64
+	if templateID == "" {
+		doc.Join(author.UserID)
+		revision = doc.Revision()
+	} else {
+		revision, err = manager.ReplaceContent(docID, author, template.Content)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err := manager.FileDocument(ctx, author.UserID, docID, folderID); err != nil {
+		return "", 0, err
+	}
+	return docID, revision, nil
+}
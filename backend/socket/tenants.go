@@ -0,0 +1,148 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/document"
+	"backend/metrics"
+	"backend/tenants"
+)
+
+var (
+	// ErrUnknownTenant is a request on a subdomain that can't name a tenant
+	ErrUnknownTenant = errors.New("no such tenant")
+	// ErrWrongTenant is a session or document of another tenant than the
+	// one the request is for
+	ErrWrongTenant = errors.New("this belongs to another tenant")
+	// ErrDocumentQuota is a new document its tenant has no room left for
+	ErrDocumentQuota = errors.New("the tenant has reached its document quota")
+)
+
+// HostTenant returns the tenant a request to host is for
+func (manager *WebSocketManager) HostTenant(host string) (string, error) {
+	tenant, ok := tenants.FromHost(host, manager.Config.Tenancy.Domain)
+	if !ok {
+		return "", ErrUnknownTenant
+	}
+	return tenant, nil
+}
+
+// SessionTenant returns the tenant a request to host with session acts
+// for, which is the session's as long as the host is its tenant's
+func (manager *WebSocketManager) SessionTenant(host string, session Session) (string, error) {
+	tenant, err := manager.HostTenant(host)
+	if err != nil {
+		return "", err
+	}
+	if session.Tenant != tenant {
+		return "", ErrWrongTenant
+	}
+	return tenant, nil
+}
+
+// OpenTenantDocument returns docID if it belongs to tenant. The document
+// is created for tenant, within its quota, when it doesn't exist yet; hold
+// acquires it, which the caller releases. A document in the trash fails
+// with document.ErrTrashed.
+func (manager *WebSocketManager) OpenTenantDocument(ctx context.Context, tenant string, docID string, hold bool) (*document.Document, error) {
+	if _, err := manager.Documents.Lookup(docID); errors.Is(err, document.ErrNotFound) {
+		if err := manager.checkDocumentQuota(ctx, tenant); err != nil {
+			return nil, err
+		}
+	}
+	open := manager.Documents.Open
+	if hold {
+		open = manager.Documents.Acquire
+	}
+	doc, err := open(docID)
+	if err != nil {
+		return nil, err
+	}
+	if !doc.ClaimTenant(tenant) {
+		if hold {
+			manager.Documents.Release(docID)
+		}
+		return nil, ErrWrongTenant
+	}
+	if _, trashed := doc.TrashedAt(); trashed {
+		if hold {
+			manager.Documents.Release(docID)
+		}
+		return nil, document.ErrTrashed
+	}
+	return doc, nil
+}
+
+// TenantDocument returns docID if it exists and belongs to tenant, and
+// document.ErrNotFound otherwise, so other tenants' documents can't be told
+// from missing ones
+func (manager *WebSocketManager) TenantDocument(tenant string, docID string) (*document.Document, error) {
+	doc, err := manager.Documents.Lookup(docID)
+	if err != nil {
+		return nil, err
+	}
+	if !doc.ClaimTenant(tenant) {
+		return nil, document.ErrNotFound
+	}
+	return doc, nil
+}
+
+// checkDocumentQuota fails with ErrDocumentQuota when tenant may not
+// create another document
+func (manager *WebSocketManager) checkDocumentQuota(ctx context.Context, tenant string) error {
+	limit := manager.Config.Tenancy.Quota(tenant).MaxDocuments
+	if limit <= 0 {
+		return nil
+	}
+	summaries, err := manager.Documents.List(ctx)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, summary := range summaries {
+		if summary.Tenant == tenant {
+			count++
+		}
+	}
+	if count >= limit {
+		metrics.TenantQuotaRejections.WithLabelValues(metrics.TenantLabel(tenant), "documents").Inc()
+		return ErrDocumentQuota
+	}
+	return nil
+}
+
+// admitDocument checks whether a connection of userID for tenant to docID
+// may join, acquiring the document for it if so
+func (manager *WebSocketManager) admitDocument(userID string, tenant string, docID string) (*document.Document, *CloseError) {
+	if limit := manager.Config.Tenancy.Quota(tenant).MaxConnections; limit > 0 && manager.tenantClients(tenant) >= limit {
+		metrics.TenantQuotaRejections.WithLabelValues(metrics.TenantLabel(tenant), "connections").Inc()
+		return nil, &CloseError{Reason: CloseTenantQuota, Details: fmt.Sprintf("the tenant is limited to %d connections", limit), RetryAfter: capacityRetryAfter}
+	}
+	ctx, cancel := context.WithTimeout(manager.ctx, 5*time.Second)
+	defer cancel()
+	doc, err := manager.OpenTenantDocument(ctx, tenant, docID, true)
+	switch {
+	case errors.Is(err, ErrWrongTenant):
+		return nil, &CloseError{Reason: CloseWrongTenant, Details: "the document belongs to another tenant"}
+	case errors.Is(err, ErrDocumentQuota):
+		return nil, &CloseError{Reason: CloseTenantQuota, Details: err.Error()}
+	case errors.Is(err, document.ErrTrashed):
+		return nil, &CloseError{Reason: CloseDocumentTrashed, Details: err.Error()}
+	case err != nil:
+		manager.Logger.Error("Could not load document", "doc_id", docID, "error", err)
+		return nil, &CloseError{Reason: CloseDocumentUnavailable, Details: "document could not be loaded"}
+	}
+	if refused := admitModerated(doc, userID); refused != nil {
+		manager.Documents.Release(docID)
+		return nil, refused
+	}
+	return doc, nil
+}
+
+// tenantClients counts the connections of tenant on this node
+func (manager *WebSocketManager) tenantClients(tenant string) int {
+	return manager.countClients(func(client *Client) bool { return client.Tenant == tenant })
+}
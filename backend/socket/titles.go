@@ -0,0 +1,52 @@
+package socket
+
+import (
+	"encoding/json"
+	"time"
+
+	"backend/document"
+	"backend/events"
+)
+
+// suggestTitles titles the loaded documents nobody has titled once they
+// have gone TitleSuggestDelay without an edit and nobody in their room is
+// typing
+func (manager *WebSocketManager) suggestTitles() {
+	delay := manager.Config.TitleSuggestDelay
+	ticker := time.NewTicker(max(delay/2, time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, doc := range manager.Documents.All() {
+			if time.Since(doc.UpdatedAt()) < delay || manager.typingIn(doc.ID) {
+				continue
+			}
+			manager.suggestTitle(doc)
+		}
+	}
+}
+
+// suggestTitle titles a document after its content and tells its room with
+// a title-suggested message carrying the new metadata
+func (manager *WebSocketManager) suggestTitle(doc *document.Document) {
+	metadata, ok := doc.SuggestTitle(func(metadata document.Metadata) {
+		payload, err := json.Marshal(Message{Type: "title-suggested", Data: metadata})
+		if err != nil {
+			manager.Logger.Error("Error marshalling title-suggested message", "doc_id", doc.ID, "error", err)
+			return
+		}
+		manager.BroadcastToRoom(doc.ID, payload)
+	})
+	if !ok {
+		return
+	}
+	manager.Logger.Debug("Suggested document title", "doc_id", doc.ID, "title", metadata.Title)
+	manager.auditRename(doc.ID, "", "title", metadata.Title)
+	manager.emitEvent(events.TypeMetadataUpdated, doc.ID, "", events.MetadataUpdated{
+		Language:    metadata.Language,
+		Direction:   metadata.Direction,
+		Title:       metadata.Title,
+		Description: metadata.Description,
+		Tags:        metadata.Tags,
+	})
+}
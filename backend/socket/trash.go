@@ -0,0 +1,102 @@
+package socket
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"backend/audit"
+	"backend/document"
+	"backend/events"
+)
+
+// Timeout for purging the trash
+const purgeTimeout = 5 * time.Minute
+
+// TrashedDocument is a document in the trash and when it will be purged,
+// zero if it is kept until restored
+type TrashedDocument struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title,omitempty"`
+	TrashedAt time.Time `json:"trashedAt"`
+	PurgeAt   time.Time `json:"purgeAt,omitzero"`
+}
+
+// TrashDocument moves doc to the trash on behalf of userID, its owner, and
+// closes every connection to it
+func (manager *WebSocketManager) TrashDocument(doc *document.Document, userID string) (TrashedDocument, error) {
+	if err := doc.Trash(userID); err != nil {
+		return TrashedDocument{}, err
+	}
+	trashedAt, _ := doc.TrashedAt()
+	trashed := TrashedDocument{ID: doc.ID, Title: doc.Metadata().Title, TrashedAt: trashedAt, PurgeAt: manager.purgeAt(trashedAt)}
+	manager.Logger.Info("Document trashed", "doc_id", doc.ID, "user_id", userID)
+	manager.Audit.Record(audit.Entry{Action: audit.ActionTrashed, DocID: doc.ID, UserID: userID})
+	manager.emitEvent(events.TypeDocumentTrashed, doc.ID, userID, events.DocumentTrashed{PurgeAt: trashed.PurgeAt})
+
+	for _, member := range manager.roomMembers(doc.ID) {
+		manager.disconnect(member, CloseDocumentTrashed, "the document was moved to the trash")
+	}
+	return trashed, nil
+}
+
+// RestoreDocument takes doc back out of the trash on behalf of userID, its
+// owner
+func (manager *WebSocketManager) RestoreDocument(doc *document.Document, userID string) error {
+	if err := doc.Restore(userID); err != nil {
+		return err
+	}
+	manager.Logger.Info("Document restored", "doc_id", doc.ID, "user_id", userID)
+	manager.Audit.Record(audit.Entry{Action: audit.ActionRestored, DocID: doc.ID, UserID: userID})
+	manager.emitEvent(events.TypeDocumentRestored, doc.ID, userID, events.DocumentRestored{})
+	return nil
+}
+
+// Trash lists the documents of tenant userID owns that are in the trash,
+// most recently trashed first
+func (manager *WebSocketManager) Trash(ctx context.Context, tenant string, userID string) ([]TrashedDocument, error) {
+	records, err := manager.Documents.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	trash := []TrashedDocument{}
+	for _, record := range records {
+		if record.TrashedAt.IsZero() || record.Tenant != tenant || record.Permissions.Owner != userID {
+			continue
+		}
+		trash = append(trash, TrashedDocument{
+			ID:        record.ID,
+			Title:     record.Metadata.Title,
+			TrashedAt: record.TrashedAt,
+			PurgeAt:   manager.purgeAt(record.TrashedAt),
+		})
+	}
+	slices.SortFunc(trash, func(a, b TrashedDocument) int { return b.TrashedAt.Compare(a.TrashedAt) })
+	return trash, nil
+}
+
+// purgeAt is when a document trashed at trashedAt is purged
+func (manager *WebSocketManager) purgeAt(trashedAt time.Time) time.Time {
+	if manager.Config.Trash.Retention <= 0 {
+		return time.Time{}
+	}
+	return trashedAt.Add(manager.Config.Trash.Retention)
+}
+
+func (manager *WebSocketManager) purgeTrash() {
+	ticker := time.NewTicker(manager.Config.Trash.PurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(manager.ctx, purgeTimeout)
+		purged, err := manager.Documents.Purge(ctx, time.Now().Add(-manager.Config.Trash.Retention))
+		cancel()
+		for _, docID := range purged {
+			manager.Logger.Info("Purged trashed document", "doc_id", docID)
+			manager.emitEvent(events.TypeDocumentPurged, docID, "", events.DocumentPurged{})
+		}
+		if err != nil {
+			manager.Logger.Error("Could not purge the trash", "error", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package socket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Idle time after which a typing user is considered to have stopped
+const typingIdleTimeout = 2 * time.Second
+
+// TypingData is the payload of a typing message. Clients only send Typing;
+// the server attaches the typist's user data before broadcasting.
+type TypingData struct {
+	Typing   bool              `json:"typing"`
+	UserData map[string]string `json:"userData,omitempty"`
+}
+
+type typingMessage struct {
+	Data TypingData `json:"data"`
+}
+
+// typingEntry is a client that is currently typing. The idle timer re-arms
+// itself until lastSeen is typingIdleTimeout in the past.
+type typingEntry struct {
+	timer    *time.Timer
+	lastSeen time.Time
+}
+
+// typingTracker folds a client's stream of typing events into a single
+// start and stop broadcast
+type typingTracker struct {
+	mutex   sync.Mutex
+	entries map[*Client]*typingEntry
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{entries: make(map[*Client]*typingEntry)}
+}
+
+// handleTyping starts or extends the client's typing state. Only the
+// transition into typing is broadcast; repeats just push back the idle timeout.
+func (manager *WebSocketManager) handleTyping(client *Client, message []byte) {
+	var msg typingMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		manager.sendError(client, ErrCodeInvalidMessage, "typing message must be an object")
+		return
+	}
+	if !msg.Data.Typing {
+		manager.stopTyping(client, nil)
+		return
+	}
+
+	tracker := manager.typing
+	tracker.mutex.Lock()
+	if entry, ok := tracker.entries[client]; ok {
+		entry.lastSeen = time.Now()
+		tracker.mutex.Unlock()
+		return
+	}
+	entry := &typingEntry{lastSeen: time.Now()}
+	entry.timer = time.AfterFunc(typingIdleTimeout, func() {
+		manager.stopTyping(client, entry)
+	})
+	tracker.entries[client] = entry
+	tracker.mutex.Unlock()
+
+	manager.broadcastTyping(client, true)
+}
+
+// stopTyping ends the client's typing state and broadcasts the stop. When
+// called from an idle timer, expired is that timer's entry: it is ignored if
+// it has been replaced and re-armed if the client typed since it was set.
+func (manager *WebSocketManager) stopTyping(client *Client, expired *typingEntry) {
+	tracker := manager.typing
+	tracker.mutex.Lock()
+	entry, ok := tracker.entries[client]
+	if !ok || (expired != nil && entry != expired) {
+		tracker.mutex.Unlock()
+		return
+	}
+	if expired != nil {
+		if remaining := typingIdleTimeout - time.Since(entry.lastSeen); remaining > 0 {
+			entry.timer.Reset(remaining)
+			tracker.mutex.Unlock()
+			return
+		}
+	}
+	entry.timer.Stop()
+	delete(tracker.entries, client)
+	tracker.mutex.Unlock()
+
+	manager.broadcastTyping(client, false)
+}
+
+// forgetTyping drops a departing client's typing state without a broadcast;
+// user-removed already tells the room the user is gone
+func (manager *WebSocketManager) forgetTyping(client *Client) {
+	tracker := manager.typing
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if entry, ok := tracker.entries[client]; ok {
+		entry.timer.Stop()
+		delete(tracker.entries, client)
+	}
+}
+
+func (manager *WebSocketManager) broadcastTyping(client *Client, typing bool) {
+	message := Message{
+		Type: "typing",
+		Data: TypingData{Typing: typing, UserData: manager.clientData(client)["userData"]},
+	}
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		client.Logger.Error("Error marshalling typing message", "error", err)
+		return
+	}
+	manager.BroadcastExcept(client, jsonData)
+}
+
+// typingIn reports whether anyone in a room is typing
+func (manager *WebSocketManager) typingIn(docID string) bool {
+	tracker := manager.typing
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	for client := range tracker.entries {
+		if client.DocID == docID {
+			return true
+		}
+	}
+	return false
+}
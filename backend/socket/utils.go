@@ -1,17 +1,18 @@
 package socket
 
 import (
-	"fmt"
-	"math/rand"
+	"backend/ids"
 )
 
-var randomNames = []string{"🦊 Fox", "🐼 Panda", "🐧 Penguin", "🦁 Lion", "🐸 Frog"}
+// DefaultDocID is used for connections that don't name a document
+const DefaultDocID = "default"
 
-func GetRandomName() string {
-	return randomNames[rand.Intn(len(randomNames))]
+// NewConnID returns a random identifier for a single connection
+func NewConnID() string {
+	return ids.RandomHex(8)
 }
 
-func GetRandomColor() string {
-	hue := rand.Intn(360)
-	return fmt.Sprintf("hsl(%d, 70%%, 60%%)", hue)
+// NewSessionID returns an unguessable session token
+func NewSessionID() string {
+	return ids.RandomHex(32)
 }
@@ -0,0 +1,594 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/audit"
+	"backend/document"
+	"backend/encryption"
+	"backend/folders"
+	"backend/snapshots"
+	"backend/templates"
+	"backend/users"
+)
+
+// FileStore keeps each document as a JSON file named after its escaped ID,
+// and snapshots, templates, workspaces and users the same way in
+// subdirectories of their own. The audit trail of each document is a file of JSON lines.
+type FileStore struct {
+	Dir string
+
+	// Keys seal documents and snapshots, which are written in plaintext
+	// when nil
+	Keys *encryption.Keyring
+
+	// auditMutex serializes writes to the audit trail files; auditSeqs
+	// caches the number of the last entry of each trail written to
+	auditMutex sync.Mutex
+	auditSeqs  map[string]int64
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file storage needs a directory")
+	}
+	for _, sub := range []string{"snapshots", "templates", "workspaces", "audit", filepath.Join("users", "emails"), filepath.Join("users", "identities")} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("creating storage directory: %w", err)
+		}
+	}
+	return &FileStore{Dir: dir, auditSeqs: make(map[string]int64)}, nil
+}
+
+// Ping checks the directory is still there
+func (store *FileStore) Ping(context.Context) error {
+	info, err := os.Stat(store.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", store.Dir)
+	}
+	return nil
+}
+
+func (store *FileStore) Close() error {
+	return nil
+}
+
+// path escapes the ID so it can't name another directory
+func (store *FileStore) path(id string) string {
+	return filepath.Join(store.Dir, url.PathEscape(id)+".json")
+}
+
+func (store *FileStore) Load(_ context.Context, id string) (document.Record, error) {
+	raw, err := os.ReadFile(store.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return document.Record{}, document.ErrNotFound
+	}
+	if err != nil {
+		return document.Record{}, err
+	}
+	if raw, err = store.Keys.Open(encryption.DocumentScope(id), raw); err != nil {
+		return document.Record{}, fmt.Errorf("decrypting document %q: %w", id, err)
+	}
+	var record document.Record
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return document.Record{}, fmt.Errorf("decoding document %q: %w", id, err)
+	}
+	return record, nil
+}
+
+func (store *FileStore) Save(_ context.Context, record document.Record) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if raw, err = store.Keys.Seal(encryption.DocumentScope(record.ID), raw); err != nil {
+		return err
+	}
+	temp, err := store.writeTemp(raw)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	return os.Rename(temp, store.path(record.ID))
+}
+
+func (store *FileStore) Delete(_ context.Context, id string) error {
+	err := os.Remove(store.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return document.ErrNotFound
+	}
+	return err
+}
+
+// List loads every saved document
+func (store *FileStore) List(ctx context.Context) ([]document.Record, error) {
+	entries, err := os.ReadDir(store.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var records []document.Record
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		record, err := store.Load(ctx, id)
+		if errors.Is(err, document.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		record.ID = id
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (store *FileStore) snapshotPath(id string) string {
+	return filepath.Join(store.Dir, "snapshots", url.PathEscape(id)+".json")
+}
+
+// Create links the written file into place, which unlike a rename fails
+// when a snapshot with the same ID exists
+func (store *FileStore) Create(_ context.Context, snapshot snapshots.Snapshot) error {
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if raw, err = store.Keys.Seal(encryption.SnapshotScope(snapshot.ID), raw); err != nil {
+		return err
+	}
+	temp, err := store.writeTemp(raw)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	err = os.Link(temp, store.snapshotPath(snapshot.ID))
+	if errors.Is(err, fs.ErrExist) {
+		return snapshots.ErrExists
+	}
+	return err
+}
+
+func (store *FileStore) Get(_ context.Context, id string) (snapshots.Snapshot, error) {
+	raw, err := os.ReadFile(store.snapshotPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return snapshots.Snapshot{}, snapshots.ErrNotFound
+	}
+	if err != nil {
+		return snapshots.Snapshot{}, err
+	}
+	if raw, err = store.Keys.Open(encryption.SnapshotScope(id), raw); err != nil {
+		return snapshots.Snapshot{}, fmt.Errorf("decrypting snapshot %q: %w", id, err)
+	}
+	var snapshot snapshots.Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return snapshots.Snapshot{}, fmt.Errorf("decoding snapshot %q: %w", id, err)
+	}
+	return snapshot, nil
+}
+
+// Reseal rewrites each snapshot file not sealed with the active key,
+// renaming the new one into place
+func (store *FileStore) Reseal(_ context.Context) (int, error) {
+	if store.Keys == nil {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(filepath.Join(store.Dir, "snapshots"))
+	if err != nil {
+		return 0, err
+	}
+	var resealed int
+	var errs []error
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		changed, err := store.resealSnapshot(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resealing snapshot %q: %w", id, err))
+			continue
+		}
+		if changed {
+			resealed++
+		}
+	}
+	return resealed, errors.Join(errs...)
+}
+
+func (store *FileStore) resealSnapshot(id string) (bool, error) {
+	raw, err := os.ReadFile(store.snapshotPath(id))
+	if err != nil {
+		return false, err
+	}
+	raw, changed, err := store.Keys.Reseal(encryption.SnapshotScope(id), raw)
+	if err != nil || !changed {
+		return false, err
+	}
+	temp, err := store.writeTemp(raw)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(temp)
+	return true, os.Rename(temp, store.snapshotPath(id))
+}
+
+func (store *FileStore) templatePath(id string) string {
+	return filepath.Join(store.Dir, "templates", url.PathEscape(id)+".json")
+}
+
+// CreateTemplate links the template's file into place, which fails rather
+// than overwrite one with the same ID
+func (store *FileStore) CreateTemplate(_ context.Context, template templates.Template) error {
+	raw, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	temp, err := store.writeTemp(raw)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	err = os.Link(temp, store.templatePath(template.ID))
+	if errors.Is(err, fs.ErrExist) {
+		return templates.ErrExists
+	}
+	return err
+}
+
+func (store *FileStore) GetTemplate(_ context.Context, id string) (templates.Template, error) {
+	raw, err := os.ReadFile(store.templatePath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return templates.Template{}, templates.ErrNotFound
+	}
+	if err != nil {
+		return templates.Template{}, err
+	}
+	var template templates.Template
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return templates.Template{}, fmt.Errorf("decoding template %q: %w", id, err)
+	}
+	return template, nil
+}
+
+func (store *FileStore) workspacePath(owner string) string {
+	return filepath.Join(store.Dir, "workspaces", url.PathEscape(owner)+".json")
+}
+
+func (store *FileStore) LoadWorkspace(_ context.Context, owner string) (folders.Workspace, error) {
+	raw, err := os.ReadFile(store.workspacePath(owner))
+	if errors.Is(err, fs.ErrNotExist) {
+		return folders.NewWorkspace(owner), nil
+	}
+	if err != nil {
+		return folders.Workspace{}, err
+	}
+	workspace := folders.NewWorkspace(owner)
+	if err := json.Unmarshal(raw, &workspace); err != nil {
+		return folders.Workspace{}, fmt.Errorf("decoding workspace of %q: %w", owner, err)
+	}
+	return workspace, nil
+}
+
+func (store *FileStore) SaveWorkspace(_ context.Context, workspace folders.Workspace) error {
+	raw, err := json.Marshal(workspace)
+	if err != nil {
+		return err
+	}
+	temp, err := store.writeTemp(raw)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	return os.Rename(temp, store.workspacePath(workspace.Owner))
+}
+
+func (store *FileStore) ListTemplates(_ context.Context) ([]templates.Template, error) {
+	entries, err := os.ReadDir(filepath.Join(store.Dir, "templates"))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]templates.Template, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(store.Dir, "templates", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var template templates.Template
+		if err := json.Unmarshal(raw, &template); err != nil {
+			return nil, fmt.Errorf("decoding template %q: %w", entry.Name(), err)
+		}
+		list = append(list, template)
+	}
+	templates.Sort(list)
+	return list, nil
+}
+
+func (store *FileStore) userPath(id string) string {
+	return filepath.Join(store.Dir, "users", url.PathEscape(id)+".json")
+}
+
+// userEmailPath holds the ID of the user with an email
+func (store *FileStore) userEmailPath(email string) string {
+	return filepath.Join(store.Dir, "users", "emails", url.PathEscape(email))
+}
+
+// userIdentityPath holds the ID of the user an identity is linked to
+func (store *FileStore) userIdentityPath(identity users.Identity) string {
+	return filepath.Join(store.Dir, "users", "identities", url.PathEscape(identity.Provider+":"+identity.Subject))
+}
+
+// CreateUser links the email file into place first, which fails when the
+// email is already taken
+func (store *FileStore) CreateUser(_ context.Context, user users.User) error {
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	if user.Email != "" {
+		err := store.claimUserIndex(store.userEmailPath(user.Email), user.ID)
+		if errors.Is(err, fs.ErrExist) {
+			return users.ErrEmailTaken
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return store.writeUser(raw, user.ID)
+}
+
+// claimUserIndex links a file holding id into place at path, failing with
+// fs.ErrExist when another user holds it
+func (store *FileStore) claimUserIndex(path string, id string) error {
+	temp, err := store.writeTemp([]byte(id))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	return os.Link(temp, path)
+}
+
+func (store *FileStore) GetUser(_ context.Context, id string) (users.User, error) {
+	raw, err := os.ReadFile(store.userPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return users.User{}, users.ErrNotFound
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+	var user users.User
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return users.User{}, fmt.Errorf("decoding user %q: %w", id, err)
+	}
+	return user, nil
+}
+
+func (store *FileStore) FindUser(ctx context.Context, email string) (users.User, error) {
+	id, err := os.ReadFile(store.userEmailPath(email))
+	if errors.Is(err, fs.ErrNotExist) {
+		return users.User{}, users.ErrNotFound
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+	return store.GetUser(ctx, string(id))
+}
+
+func (store *FileStore) FindIdentity(ctx context.Context, identity users.Identity) (users.User, error) {
+	id, err := os.ReadFile(store.userIdentityPath(identity))
+	if errors.Is(err, fs.ErrNotExist) {
+		return users.User{}, users.ErrNotFound
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+	return store.GetUser(ctx, string(id))
+}
+
+func (store *FileStore) LinkIdentity(_ context.Context, userID string, identity users.Identity) error {
+	err := store.claimUserIndex(store.userIdentityPath(identity), userID)
+	if errors.Is(err, fs.ErrExist) {
+		return users.ErrIdentityTaken
+	}
+	return err
+}
+
+func (store *FileStore) SaveUser(ctx context.Context, user users.User) error {
+	existing, err := store.GetUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	user.Email = existing.Email
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return store.writeUser(raw, user.ID)
+}
+
+func (store *FileStore) writeUser(raw []byte, id string) error {
+	temp, err := store.writeTemp(raw)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	return os.Rename(temp, store.userPath(id))
+}
+
+func (store *FileStore) auditPath(docID string) string {
+	return filepath.Join(store.Dir, "audit", url.PathEscape(docID)+".jsonl")
+}
+
+// AppendAudit appends the entry to its document's trail file, numbered
+// after the last line
+func (store *FileStore) AppendAudit(_ context.Context, entry audit.Entry) error {
+	store.auditMutex.Lock()
+	defer store.auditMutex.Unlock()
+
+	seq, ok := store.auditSeqs[entry.DocID]
+	if !ok {
+		entries, err := store.readAudit(entry.DocID)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			seq = entries[len(entries)-1].Seq
+		}
+	}
+	entry.Seq = seq + 1
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(store.auditPath(entry.DocID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(raw, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	store.auditSeqs[entry.DocID] = entry.Seq
+	return nil
+}
+
+func (store *FileStore) ListAudit(_ context.Context, docID string, before int64, limit int) ([]audit.Entry, error) {
+	store.auditMutex.Lock()
+	defer store.auditMutex.Unlock()
+
+	entries, err := store.readAudit(docID)
+	if err != nil {
+		return nil, err
+	}
+	return audit.Page(entries, before, limit), nil
+}
+
+// PruneAudit rewrites the trail files it drops entries from
+func (store *FileStore) PruneAudit(_ context.Context, cutoff time.Time, keep int) (int, error) {
+	store.auditMutex.Lock()
+	defer store.auditMutex.Unlock()
+
+	files, err := os.ReadDir(filepath.Join(store.Dir, "audit"))
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".jsonl")
+		if !ok || file.IsDir() {
+			continue
+		}
+		docID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		entries, err := store.readAudit(docID)
+		if err != nil {
+			return pruned, err
+		}
+		kept := audit.Prune(entries, cutoff, keep)
+		if len(kept) == len(entries) {
+			continue
+		}
+		if err := store.writeAudit(docID, kept); err != nil {
+			return pruned, err
+		}
+		pruned += len(entries) - len(kept)
+	}
+	return pruned, nil
+}
+
+// readAudit loads a document's trail, oldest first. A line cut short by
+// a crash is skipped.
+func (store *FileStore) readAudit(docID string) ([]audit.Entry, error) {
+	raw, err := os.ReadFile(store.auditPath(docID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []audit.Entry
+	for line := range strings.Lines(string(raw)) {
+		var entry audit.Entry
+		if json.Unmarshal([]byte(line), &entry) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeAudit replaces a document's trail with entries
+func (store *FileStore) writeAudit(docID string, entries []audit.Entry) error {
+	var raw []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		raw = append(append(raw, line...), '\n')
+	}
+	temp, err := store.writeTemp(raw)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	return os.Rename(temp, store.auditPath(docID))
+}
+
+// writeTemp writes raw to a synced temporary file, so a crash never leaves
+// a truncated file where a document, snapshot or user is expected
+func (store *FileStore) writeTemp(raw []byte) (string, error) {
+	file, err := os.CreateTemp(store.Dir, ".save-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(raw); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
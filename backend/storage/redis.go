@@ -0,0 +1,510 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/audit"
+	"backend/document"
+	"backend/encryption"
+	"backend/folders"
+	"backend/snapshots"
+	"backend/templates"
+	"backend/users"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps each document, snapshot, template and user as a JSON
+// string
+// without expiry, and the audit trail of each document as a sorted set of
+// JSON entries scored by their number
+type RedisStore struct {
+	client *redis.Client
+
+	// Keys seal documents and snapshots, which are written in plaintext
+	// when nil
+	Keys *encryption.Keyring
+}
+
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Redis URL: %w", err)
+	}
+	return &RedisStore{client: redis.NewClient(options)}, nil
+}
+
+func (store *RedisStore) Ping(ctx context.Context) error {
+	return store.client.Ping(ctx).Err()
+}
+
+func (store *RedisStore) Close() error {
+	return store.client.Close()
+}
+
+func documentKey(id string) string {
+	return "document:" + id
+}
+
+func (store *RedisStore) Load(ctx context.Context, id string) (document.Record, error) {
+	raw, err := store.client.Get(ctx, documentKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return document.Record{}, document.ErrNotFound
+	}
+	if err != nil {
+		return document.Record{}, err
+	}
+	if raw, err = store.Keys.Open(encryption.DocumentScope(id), raw); err != nil {
+		return document.Record{}, fmt.Errorf("decrypting document %q: %w", id, err)
+	}
+	var record document.Record
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return document.Record{}, fmt.Errorf("decoding document %q: %w", id, err)
+	}
+	return record, nil
+}
+
+func (store *RedisStore) Save(ctx context.Context, record document.Record) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if raw, err = store.Keys.Seal(encryption.DocumentScope(record.ID), raw); err != nil {
+		return err
+	}
+	return store.client.Set(ctx, documentKey(record.ID), raw, 0).Err()
+}
+
+func (store *RedisStore) Delete(ctx context.Context, id string) error {
+	return store.client.Del(ctx, documentKey(id)).Err()
+}
+
+// Documents fetched per round trip when listing
+const listBatchSize = 100
+
+// List loads every saved document, scanning rather than blocking Redis
+// with KEYS
+func (store *RedisStore) List(ctx context.Context) ([]document.Record, error) {
+	var records []document.Record
+	var cursor uint64
+	for {
+		keys, next, err := store.client.Scan(ctx, cursor, documentKey("*"), listBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			values, err := store.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, err
+			}
+			for i, value := range values {
+				raw, ok := value.(string)
+				if !ok {
+					// Deleted since the scan
+					continue
+				}
+				id := strings.TrimPrefix(keys[i], documentKey(""))
+				plaintext, err := store.Keys.Open(encryption.DocumentScope(id), []byte(raw))
+				if err != nil {
+					return nil, fmt.Errorf("decrypting document %q: %w", keys[i], err)
+				}
+				var record document.Record
+				if err := json.Unmarshal(plaintext, &record); err != nil {
+					return nil, fmt.Errorf("decoding document %q: %w", keys[i], err)
+				}
+				record.ID = id
+				records = append(records, record)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return records, nil
+		}
+	}
+}
+
+func snapshotKey(id string) string {
+	return "snapshot:" + id
+}
+
+func (store *RedisStore) Create(ctx context.Context, snapshot snapshots.Snapshot) error {
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if raw, err = store.Keys.Seal(encryption.SnapshotScope(snapshot.ID), raw); err != nil {
+		return err
+	}
+	created, err := store.client.SetNX(ctx, snapshotKey(snapshot.ID), raw, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return snapshots.ErrExists
+	}
+	return nil
+}
+
+func (store *RedisStore) Get(ctx context.Context, id string) (snapshots.Snapshot, error) {
+	raw, err := store.client.Get(ctx, snapshotKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return snapshots.Snapshot{}, snapshots.ErrNotFound
+	}
+	if err != nil {
+		return snapshots.Snapshot{}, err
+	}
+	if raw, err = store.Keys.Open(encryption.SnapshotScope(id), raw); err != nil {
+		return snapshots.Snapshot{}, fmt.Errorf("decrypting snapshot %q: %w", id, err)
+	}
+	var snapshot snapshots.Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return snapshots.Snapshot{}, fmt.Errorf("decoding snapshot %q: %w", id, err)
+	}
+	return snapshot, nil
+}
+
+// Reseal scans the snapshots and overwrites each one not sealed with the
+// active key, only while it still exists
+func (store *RedisStore) Reseal(ctx context.Context) (int, error) {
+	if store.Keys == nil {
+		return 0, nil
+	}
+	var resealed int
+	var errs []error
+	var cursor uint64
+	for {
+		keys, next, err := store.client.Scan(ctx, cursor, snapshotKey("*"), listBatchSize).Result()
+		if err != nil {
+			return resealed, err
+		}
+		for _, key := range keys {
+			id := strings.TrimPrefix(key, snapshotKey(""))
+			raw, err := store.client.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				return resealed, err
+			}
+			raw, changed, err := store.Keys.Reseal(encryption.SnapshotScope(id), raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("resealing snapshot %q: %w", id, err))
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := store.client.SetXX(ctx, key, raw, 0).Err(); err != nil {
+				return resealed, err
+			}
+			resealed++
+		}
+		if cursor = next; cursor == 0 {
+			return resealed, errors.Join(errs...)
+		}
+	}
+}
+
+func templateKey(id string) string {
+	return "template:" + id
+}
+
+func (store *RedisStore) CreateTemplate(ctx context.Context, template templates.Template) error {
+	raw, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	created, err := store.client.SetNX(ctx, templateKey(template.ID), raw, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return templates.ErrExists
+	}
+	return nil
+}
+
+func (store *RedisStore) GetTemplate(ctx context.Context, id string) (templates.Template, error) {
+	raw, err := store.client.Get(ctx, templateKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return templates.Template{}, templates.ErrNotFound
+	}
+	if err != nil {
+		return templates.Template{}, err
+	}
+	var template templates.Template
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return templates.Template{}, fmt.Errorf("decoding template %q: %w", id, err)
+	}
+	return template, nil
+}
+
+// ListTemplates scans for templates the way List does for documents
+func (store *RedisStore) ListTemplates(ctx context.Context) ([]templates.Template, error) {
+	list := []templates.Template{}
+	var cursor uint64
+	for {
+		keys, next, err := store.client.Scan(ctx, cursor, templateKey("*"), listBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			values, err := store.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, err
+			}
+			for i, value := range values {
+				raw, ok := value.(string)
+				if !ok {
+					continue
+				}
+				var template templates.Template
+				if err := json.Unmarshal([]byte(raw), &template); err != nil {
+					return nil, fmt.Errorf("decoding template %q: %w", keys[i], err)
+				}
+				list = append(list, template)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			templates.Sort(list)
+			return list, nil
+		}
+	}
+}
+
+func workspaceKey(owner string) string {
+	return "workspace:" + owner
+}
+
+func (store *RedisStore) LoadWorkspace(ctx context.Context, owner string) (folders.Workspace, error) {
+	raw, err := store.client.Get(ctx, workspaceKey(owner)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return folders.NewWorkspace(owner), nil
+	}
+	if err != nil {
+		return folders.Workspace{}, err
+	}
+	workspace := folders.NewWorkspace(owner)
+	if err := json.Unmarshal(raw, &workspace); err != nil {
+		return folders.Workspace{}, fmt.Errorf("decoding workspace of %q: %w", owner, err)
+	}
+	return workspace, nil
+}
+
+func (store *RedisStore) SaveWorkspace(ctx context.Context, workspace folders.Workspace) error {
+	raw, err := json.Marshal(workspace)
+	if err != nil {
+		return err
+	}
+	return store.client.Set(ctx, workspaceKey(workspace.Owner), raw, 0).Err()
+}
+
+func userKey(id string) string {
+	return "user:" + id
+}
+
+// userEmailKey holds the ID of the user with an email
+func userEmailKey(email string) string {
+	return "user-email:" + email
+}
+
+// userIdentityKey holds the ID of the user an identity is linked to
+func userIdentityKey(identity users.Identity) string {
+	return "user-identity:" + identity.Provider + ":" + identity.Subject
+}
+
+// CreateUser claims the email first, so two registrations with the same
+// email can't both succeed
+func (store *RedisStore) CreateUser(ctx context.Context, user users.User) error {
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	if user.Email != "" {
+		claimed, err := store.client.SetNX(ctx, userEmailKey(user.Email), user.ID, 0).Result()
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return users.ErrEmailTaken
+		}
+	}
+	return store.client.Set(ctx, userKey(user.ID), raw, 0).Err()
+}
+
+func (store *RedisStore) GetUser(ctx context.Context, id string) (users.User, error) {
+	raw, err := store.client.Get(ctx, userKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return users.User{}, users.ErrNotFound
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+	var user users.User
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return users.User{}, fmt.Errorf("decoding user %q: %w", id, err)
+	}
+	return user, nil
+}
+
+func (store *RedisStore) FindUser(ctx context.Context, email string) (users.User, error) {
+	id, err := store.client.Get(ctx, userEmailKey(email)).Result()
+	if errors.Is(err, redis.Nil) {
+		return users.User{}, users.ErrNotFound
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+	return store.GetUser(ctx, id)
+}
+
+func (store *RedisStore) FindIdentity(ctx context.Context, identity users.Identity) (users.User, error) {
+	id, err := store.client.Get(ctx, userIdentityKey(identity)).Result()
+	if errors.Is(err, redis.Nil) {
+		return users.User{}, users.ErrNotFound
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+	return store.GetUser(ctx, id)
+}
+
+func (store *RedisStore) LinkIdentity(ctx context.Context, userID string, identity users.Identity) error {
+	claimed, err := store.client.SetNX(ctx, userIdentityKey(identity), userID, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return users.ErrIdentityTaken
+	}
+	return nil
+}
+
+func (store *RedisStore) SaveUser(ctx context.Context, user users.User) error {
+	existing, err := store.GetUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	user.Email = existing.Email
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return store.client.Set(ctx, userKey(user.ID), raw, 0).Err()
+}
+
+func auditKey(docID string) string {
+	return "audit:" + docID
+}
+
+func auditSeqKey(docID string) string {
+	return "audit-seq:" + docID
+}
+
+// AppendAudit numbers the entry with a counter of its own, so entries
+// recorded by several nodes at once are numbered in order
+func (store *RedisStore) AppendAudit(ctx context.Context, entry audit.Entry) error {
+	seq, err := store.client.Incr(ctx, auditSeqKey(entry.DocID)).Result()
+	if err != nil {
+		return err
+	}
+	entry.Seq = seq
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return store.client.ZAdd(ctx, auditKey(entry.DocID), redis.Z{Score: float64(seq), Member: raw}).Err()
+}
+
+func (store *RedisStore) ListAudit(ctx context.Context, docID string, before int64, limit int) ([]audit.Entry, error) {
+	upper := "+inf"
+	if before > 0 {
+		upper = "(" + strconv.FormatInt(before, 10)
+	}
+	values, err := store.client.ZRevRangeByScore(ctx, auditKey(docID), &redis.ZRangeBy{
+		Max:   upper,
+		Min:   "-inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeAudit(docID, values)
+}
+
+// PruneAudit scans the trails rather than blocking Redis with KEYS
+func (store *RedisStore) PruneAudit(ctx context.Context, cutoff time.Time, keep int) (int, error) {
+	pruned := 0
+	var cursor uint64
+	for {
+		keys, next, err := store.client.Scan(ctx, cursor, auditKey("*"), listBatchSize).Result()
+		if err != nil {
+			return pruned, err
+		}
+		for _, key := range keys {
+			count, err := store.pruneAuditKey(ctx, key, cutoff, keep)
+			pruned += count
+			if err != nil {
+				return pruned, err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return pruned, nil
+		}
+	}
+}
+
+// pruneAuditKey drops the oldest entries of one trail past keep, then
+// those before cutoff, a batch at a time
+func (store *RedisStore) pruneAuditKey(ctx context.Context, key string, cutoff time.Time, keep int) (int, error) {
+	pruned := 0
+	if keep > 0 {
+		count, err := store.client.ZRemRangeByRank(ctx, key, 0, int64(-keep-1)).Result()
+		if err != nil {
+			return 0, err
+		}
+		pruned += int(count)
+	}
+	if cutoff.IsZero() {
+		return pruned, nil
+	}
+	docID := strings.TrimPrefix(key, auditKey(""))
+	for {
+		values, err := store.client.ZRange(ctx, key, 0, listBatchSize-1).Result()
+		if err != nil {
+			return pruned, err
+		}
+		entries, err := decodeAudit(docID, values)
+		if err != nil {
+			return pruned, err
+		}
+		expired := len(entries) - len(audit.Prune(entries, cutoff, 0))
+		if expired == 0 {
+			return pruned, nil
+		}
+		if err := store.client.ZRemRangeByRank(ctx, key, 0, int64(expired-1)).Err(); err != nil {
+			return pruned, err
+		}
+		pruned += expired
+		if expired < len(entries) {
+			return pruned, nil
+		}
+	}
+}
+
+func decodeAudit(docID string, values []string) ([]audit.Entry, error) {
+	entries := make([]audit.Entry, 0, len(values))
+	for _, value := range values {
+		var entry audit.Entry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("decoding audit entry of %q: %w", docID, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
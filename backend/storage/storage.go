@@ -0,0 +1,54 @@
+// Package storage saves documents that are unloaded from memory
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"backend/audit"
+	"backend/document"
+	"backend/encryption"
+	"backend/folders"
+	"backend/snapshots"
+	"backend/templates"
+	"backend/users"
+)
+
+// Store keeps documents, snapshots, templates, workspaces, users and audit
+// trails, and holds a connection or files open
+type Store interface {
+	document.Store
+	snapshots.Store
+	templates.Store
+	folders.Store
+	users.Store
+	audit.Store
+	// Ping reports whether the store can be reached
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Open returns the store a DSN points to: "file:///path/to/dir" keeps one
+// JSON file per document, "redis://" and "rediss://" URLs keep them in
+// Redis. Documents and snapshots are sealed with keys, unless nil.
+func Open(dsn string, keys *encryption.Keyring) (Store, error) {
+	scheme, _, _ := strings.Cut(dsn, ":")
+	switch scheme {
+	case "file":
+		store, err := NewFileStore(strings.TrimPrefix(strings.TrimPrefix(dsn, "file:"), "//"))
+		if err != nil {
+			return nil, err
+		}
+		store.Keys = keys
+		return store, nil
+	case "redis", "rediss":
+		store, err := NewRedisStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		store.Keys = keys
+		return store, nil
+	}
+	return nil, fmt.Errorf("storage DSN must start with file:// or redis://")
+}
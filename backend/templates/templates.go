@@ -0,0 +1,116 @@
+// Package templates keeps the content new documents can start from, such
+// as meeting notes or an RFC outline. Templates are shared by everyone in
+// the tenant that created them and, like snapshots, never change once
+// created.
+package templates
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"backend/richtext"
+	"backend/sanitize"
+)
+
+// Limits on the text describing a template
+const (
+	MaxNameLength        = 100
+	MaxDescriptionLength = 500
+)
+
+var (
+	ErrNotFound = errors.New("template not found")
+	ErrExists   = errors.New("template already exists")
+	ErrInvalid  = errors.New("invalid template")
+)
+
+// Template is content a document can be created with
+type Template struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Content     richtext.Delta    `json:"content"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	CreatedBy   map[string]string `json:"createdBy"`
+	// Tenant the template belongs to, empty for the default tenant
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Validate checks the name and description
+func (template Template) Validate() error {
+	if strings.TrimSpace(template.Name) == "" || utf8.RuneCountInString(template.Name) > MaxNameLength || sanitize.HasControl(template.Name, false) {
+		return fmt.Errorf("%w: name must be a single line of 1 to %d characters", ErrInvalid, MaxNameLength)
+	}
+	if utf8.RuneCountInString(template.Description) > MaxDescriptionLength || sanitize.HasControl(template.Description, true) {
+		return fmt.Errorf("%w: description must be text of at most %d characters", ErrInvalid, MaxDescriptionLength)
+	}
+	return nil
+}
+
+// Store keeps templates. CreateTemplate fails with ErrExists rather than
+// overwrite an existing template.
+type Store interface {
+	CreateTemplate(ctx context.Context, template Template) error
+	GetTemplate(ctx context.Context, id string) (Template, error)
+	// ListTemplates returns every template, sorted by name
+	ListTemplates(ctx context.Context) ([]Template, error)
+}
+
+// MemoryStore is the Store used when no storage DSN is configured.
+// Templates last until the server restarts.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	templates map[string]Template
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{templates: make(map[string]Template)}
+}
+
+func (store *MemoryStore) CreateTemplate(_ context.Context, template Template) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.templates[template.ID]; ok {
+		return ErrExists
+	}
+	store.templates[template.ID] = template
+	return nil
+}
+
+func (store *MemoryStore) GetTemplate(_ context.Context, id string) (Template, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	template, ok := store.templates[id]
+	if !ok {
+		return Template{}, ErrNotFound
+	}
+	return template, nil
+}
+
+func (store *MemoryStore) ListTemplates(_ context.Context) ([]Template, error) {
+	store.mutex.Lock()
+	list := make([]Template, 0, len(store.templates))
+	for _, template := range store.templates {
+		list = append(list, template)
+	}
+	store.mutex.Unlock()
+
+	Sort(list)
+	return list, nil
+}
+
+// Sort orders templates by name, then ID, as ListTemplates returns them
+func Sort(list []Template) {
+	slices.SortFunc(list, func(a, b Template) int {
+		return cmp.Or(cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), cmp.Compare(a.ID, b.ID))
+	})
+}
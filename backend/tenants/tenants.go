@@ -0,0 +1,38 @@
+// Package tenants tells which tenant a request is for. Each tenant has its
+// own subdomain of the server's domain; the domain itself, like a server
+// without one, serves the default tenant, whose ID is empty.
+package tenants
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Valid reports whether id can name a tenant: a DNS label in lowercase
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// FromHost returns the tenant whose subdomain of domain host is, or the
+// default tenant for domain itself and hosts outside it. ok is false for
+// a subdomain that can't be a tenant, such as one nested deeper.
+func FromHost(host string, domain string) (tenant string, ok bool) {
+	if domain == "" {
+		return "", true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	label, found := strings.CutSuffix(host, "."+strings.ToLower(domain))
+	if !found {
+		return "", true
+	}
+	if !Valid(label) {
+		return "", false
+	}
+	return label, true
+}
@@ -0,0 +1,262 @@
+// Package users keeps the accounts people register with an email and
+// password or sign in to through a login provider, and the profile their
+// sessions present to rooms
+package users
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/ids"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password lengths accepted; bcrypt ignores anything past 72 bytes
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+var (
+	ErrNotFound           = errors.New("user not found")
+	ErrEmailTaken         = errors.New("an account with this email already exists")
+	ErrInvalidEmail       = errors.New("email address is invalid")
+	ErrInvalidPassword    = errors.New("password must be 8 to 72 bytes long")
+	ErrInvalidCredentials = errors.New("email or password is incorrect")
+	ErrIdentityTaken      = errors.New("this login is already linked to an account")
+)
+
+// User is a registered account. DisplayName, Color and AvatarURL are the
+// profile its sessions show in rooms. Accounts created through a login
+// provider have no password and may have no email. EmailOptOut stops the
+// server from emailing the user invitations and digests.
+type User struct {
+	ID           string     `json:"id"`
+	Email        string     `json:"email"`
+	DisplayName  string     `json:"displayName"`
+	Color        string     `json:"color"`
+	AvatarURL    string     `json:"avatarUrl,omitempty"`
+	EmailOptOut  bool       `json:"emailOptOut,omitempty"`
+	Tenant       string     `json:"tenant,omitempty"`
+	PasswordHash []byte     `json:"passwordHash"`
+	Identities   []Identity `json:"identities,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// Identity is an account at a login provider linked to a user
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+// Profile is the part of a user that is safe to send back to clients
+type Profile struct {
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"displayName"`
+	Color       string    `json:"color"`
+	AvatarURL   string    `json:"avatarUrl,omitempty"`
+	EmailOptOut bool      `json:"emailOptOut"`
+	Providers   []string  `json:"providers"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func (user User) Profile() Profile {
+	providers := make([]string, 0, len(user.Identities))
+	for _, identity := range user.Identities {
+		providers = append(providers, identity.Provider)
+	}
+	return Profile{
+		ID:          user.ID,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Color:       user.Color,
+		AvatarURL:   user.AvatarURL,
+		EmailOptOut: user.EmailOptOut,
+		Providers:   providers,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// Store keeps users by ID, by email and by linked identity. CreateUser
+// fails with ErrEmailTaken rather than reuse an email, and claims no email
+// when the user has none; SaveUser updates a user without changing its
+// email. LinkIdentity fails with ErrIdentityTaken when the identity is
+// already linked to a user, and records it on none: callers keep
+// User.Identities.
+type Store interface {
+	CreateUser(ctx context.Context, user User) error
+	GetUser(ctx context.Context, id string) (User, error)
+	FindUser(ctx context.Context, email string) (User, error)
+	SaveUser(ctx context.Context, user User) error
+	FindIdentity(ctx context.Context, identity Identity) (User, error)
+	LinkIdentity(ctx context.Context, userID string, identity Identity) error
+}
+
+// NormalizeEmail lowercases and checks an email address
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+// New returns a user with a fresh ID and the password hashed
+func New(email string, password string, displayName string, color string) (User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return User{}, err
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return User{}, ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	now := time.Now().UTC()
+	return User{
+		ID:           ids.NewUUID(),
+		Email:        email,
+		DisplayName:  displayName,
+		Color:        color,
+		PasswordHash: hash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+// NewLinked returns a user with a fresh ID and no password, who signs in
+// through identity. email is empty when the provider verified none.
+func NewLinked(identity Identity, email string, displayName string, color string, avatarURL string) User {
+	now := time.Now().UTC()
+	return User{
+		ID:          ids.NewUUID(),
+		Email:       strings.ToLower(email),
+		DisplayName: displayName,
+		Color:       color,
+		AvatarURL:   avatarURL,
+		Identities:  []Identity{identity},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// Compared against when the email is unknown, so a failed login takes as
+// long whether or not the account exists
+var decoyHash, _ = bcrypt.GenerateFromPassword([]byte("decoy password"), bcrypt.DefaultCost)
+
+// Authenticate returns the user with email if password is theirs, and
+// ErrInvalidCredentials without saying which of the two was wrong
+func Authenticate(ctx context.Context, store Store, email string, password string) (User, error) {
+	user, err := store.FindUser(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if errors.Is(err, ErrNotFound) {
+		bcrypt.CompareHashAndPassword(decoyHash, []byte(password))
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
+	// Accounts without a password never match, the hash check failing on
+	// the empty hash
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// MemoryStore is the Store used when no storage DSN is configured.
+// Accounts last until the server restarts.
+type MemoryStore struct {
+	mutex      sync.Mutex
+	users      map[string]User
+	byEmail    map[string]string
+	byIdentity map[Identity]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:      make(map[string]User),
+		byEmail:    make(map[string]string),
+		byIdentity: make(map[Identity]string),
+	}
+}
+
+func (store *MemoryStore) CreateUser(_ context.Context, user User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if user.Email != "" {
+		if _, ok := store.byEmail[user.Email]; ok {
+			return ErrEmailTaken
+		}
+		store.byEmail[user.Email] = user.ID
+	}
+	store.users[user.ID] = user
+	return nil
+}
+
+func (store *MemoryStore) GetUser(_ context.Context, id string) (User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user, ok := store.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (store *MemoryStore) FindUser(_ context.Context, email string) (User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user, ok := store.users[store.byEmail[email]]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (store *MemoryStore) SaveUser(_ context.Context, user User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	existing, ok := store.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Email = existing.Email
+	store.users[user.ID] = user
+	return nil
+}
+
+func (store *MemoryStore) FindIdentity(_ context.Context, identity Identity) (User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user, ok := store.users[store.byIdentity[identity]]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (store *MemoryStore) LinkIdentity(_ context.Context, userID string, identity Identity) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.byIdentity[identity]; ok {
+		return ErrIdentityTaken
+	}
+	store.byIdentity[identity] = userID
+	return nil
+}
@@ -0,0 +1,60 @@
+// Package web serves the editor's web app. Its production build is
+// embedded into the binary, so the server deploys as a single file; see
+// the client's build:embed script.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// Assets returns the web app in dir, or the embedded build when dir is
+// empty
+func Assets(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	// Sub only fails on an invalid name
+	assets, _ := fs.Sub(dist, "dist")
+	return assets
+}
+
+// Embedded reports whether the binary was built with the web app, rather
+// than only the placeholder kept in an unbuilt tree
+func Embedded() bool {
+	_, err := fs.Stat(dist, "dist/index.html")
+	return err == nil
+}
+
+// Handler serves assets under basePath, index.html for directories, and
+// answers anything outside it as not found. The base path itself is
+// redirected to end in a slash so the app's relative asset URLs resolve.
+func Handler(assets fs.FS, basePath string) http.Handler {
+	prefix := strings.TrimSuffix(basePath, "/")
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(assets)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.NotFound(w, r)
+			return
+		}
+		switch name := r.URL.Path; {
+		case prefix != "" && name == prefix:
+			target := prefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case !strings.HasPrefix(name, prefix+"/"), strings.HasPrefix(path.Base(name), "."):
+			http.NotFound(w, r)
+		default:
+			files.ServeHTTP(w, r)
+		}
+	})
+}
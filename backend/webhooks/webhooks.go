@@ -0,0 +1,353 @@
+// Package webhooks POSTs change events to outside services, retrying
+// failed deliveries with exponential backoff and keeping a log of the
+// latest ones. Deliveries are made concurrently and retried, so receivers
+// must not rely on their order; events carry the time they occurred at.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"backend/events"
+	"backend/ids"
+	"backend/metrics"
+)
+
+// Headers sent with every delivery. The signature is "sha256=" followed by
+// the hex HMAC-SHA256, keyed with the endpoint's secret, of the timestamp,
+// a dot and the body, so receivers can reject replayed deliveries.
+const (
+	HeaderEvent     = "X-Collab-Event"
+	HeaderDelivery  = "X-Collab-Delivery"
+	HeaderTimestamp = "X-Collab-Timestamp"
+	HeaderSignature = "X-Collab-Signature"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Deliveries attempted at the same time across all endpoints
+const concurrency = 4
+
+// Capacity of the queue of attempts waiting for a worker
+const queueSize = 1024
+
+// Largest part of a response body read, so a connection can be reused
+const maxResponseBody = 64 << 10
+
+var errQueueFull = errors.New("delivery queue is full")
+
+// Endpoint is a receiver of events. Without Events it receives every
+// event.
+type Endpoint struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+func (endpoint Endpoint) wants(eventType string) bool {
+	return len(endpoint.Events) == 0 || slices.Contains(endpoint.Events, eventType)
+}
+
+// Options tune retries and the delivery log. An attempt that fails is
+// retried after Backoff, doubled for each retry after that, until
+// MaxAttempts were made.
+type Options struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	Timeout     time.Duration
+	LogSize     int
+}
+
+// Delivery is an event sent to an endpoint, as kept in the delivery log.
+// StatusCode and Error are those of the latest attempt.
+type Delivery struct {
+	ID            string    `json:"id"`
+	EventID       string    `json:"eventId"`
+	EventType     string    `json:"eventType"`
+	DocID         string    `json:"docId,omitempty"`
+	URL           string    `json:"url"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	StatusCode    int       `json:"statusCode,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt,omitzero"`
+}
+
+// attempt is a delivery waiting for a worker, after made earlier attempts
+type attempt struct {
+	delivery string
+	endpoint Endpoint
+	event    events.Event
+	body     []byte
+	made     int
+}
+
+// Sender delivers events to endpoints. It is an events.Publisher that
+// never blocks on an endpoint: events are queued and delivered by its
+// workers.
+type Sender struct {
+	endpoints []Endpoint
+	options   Options
+	client    *http.Client
+	logger    *slog.Logger
+
+	queue   chan attempt
+	workers sync.WaitGroup
+
+	mutex      sync.Mutex
+	deliveries map[string]*Delivery
+	order      []string
+	closed     bool
+	retries    map[string]*time.Timer
+}
+
+func NewSender(endpoints []Endpoint, options Options, logger *slog.Logger) *Sender {
+	sender := &Sender{
+		endpoints:  endpoints,
+		options:    options,
+		client:     &http.Client{Timeout: options.Timeout},
+		logger:     logger,
+		queue:      make(chan attempt, queueSize),
+		deliveries: make(map[string]*Delivery),
+		retries:    make(map[string]*time.Timer),
+	}
+	sender.workers.Add(concurrency)
+	for range concurrency {
+		go sender.work()
+	}
+	return sender
+}
+
+// Publish queues a delivery of event to every endpoint that wants it
+func (sender *Sender) Publish(ctx context.Context, event events.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, endpoint := range sender.endpoints {
+		if !endpoint.wants(event.Type) {
+			continue
+		}
+		delivery := sender.record(endpoint, event)
+		if err := sender.enqueue(attempt{delivery: delivery, endpoint: endpoint, event: event, body: body}); err != nil {
+			errs = append(errs, fmt.Errorf("delivering to %s: %w", endpoint.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops accepting events, waits for the attempts underway and marks
+// the deliveries still waiting for a retry as failed
+func (sender *Sender) Close() error {
+	sender.mutex.Lock()
+	if sender.closed {
+		sender.mutex.Unlock()
+		return nil
+	}
+	sender.closed = true
+	for id, timer := range sender.retries {
+		timer.Stop()
+		sender.finish(id, StatusFailed, 0, "server shut down before the next attempt")
+	}
+	close(sender.queue)
+	sender.mutex.Unlock()
+
+	sender.workers.Wait()
+	return nil
+}
+
+// Deliveries returns the delivery log, newest first, only the deliveries
+// with status when it isn't empty
+func (sender *Sender) Deliveries(status string) []Delivery {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	deliveries := make([]Delivery, 0, len(sender.order))
+	for _, id := range slices.Backward(sender.order) {
+		delivery := sender.deliveries[id]
+		if status == "" || delivery.Status == status {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	return deliveries
+}
+
+// record adds a pending delivery to the log, dropping the oldest one past
+// the log size, and returns its ID
+func (sender *Sender) record(endpoint Endpoint, event events.Event) string {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	delivery := &Delivery{
+		ID:        ids.NewUUID(),
+		EventID:   event.ID,
+		EventType: event.Type,
+		DocID:     event.DocID,
+		URL:       endpoint.URL,
+		Status:    StatusPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	sender.deliveries[delivery.ID] = delivery
+	sender.order = append(sender.order, delivery.ID)
+	for len(sender.order) > max(sender.options.LogSize, 1) {
+		delete(sender.deliveries, sender.order[0])
+		sender.order = sender.order[1:]
+	}
+	return delivery.ID
+}
+
+// enqueue hands an attempt to the workers without blocking
+func (sender *Sender) enqueue(next attempt) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	if sender.closed {
+		sender.finish(next.delivery, StatusFailed, 0, "server shut down")
+		return nil
+	}
+	select {
+	case sender.queue <- next:
+		return nil
+	default:
+		sender.finish(next.delivery, StatusFailed, 0, errQueueFull.Error())
+		return errQueueFull
+	}
+}
+
+func (sender *Sender) work() {
+	defer sender.workers.Done()
+
+	for next := range sender.queue {
+		statusCode, err := sender.post(next)
+		next.made++
+		attempts := next.made
+		sender.attempted(next.delivery, attempts)
+		switch {
+		case err == nil:
+			metrics.WebhookDeliveries.WithLabelValues(StatusDelivered).Inc()
+			sender.mutex.Lock()
+			sender.finish(next.delivery, StatusDelivered, statusCode, "")
+			sender.mutex.Unlock()
+		case attempts >= sender.options.MaxAttempts:
+			metrics.WebhookDeliveries.WithLabelValues(StatusFailed).Inc()
+			sender.logger.Warn("Giving up on webhook delivery", "url", next.endpoint.URL, "event_type", next.event.Type,
+				"attempts", attempts, "error", err)
+			sender.mutex.Lock()
+			sender.finish(next.delivery, StatusFailed, statusCode, err.Error())
+			sender.mutex.Unlock()
+		default:
+			metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+			sender.retry(next, attempts, statusCode, err)
+		}
+	}
+}
+
+// post makes one attempt at a delivery. Any status other than 2xx fails it.
+func (sender *Sender) post(next attempt) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sender.options.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, next.endpoint.URL, bytes.NewReader(next.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderEvent, next.event.Type)
+	request.Header.Set(HeaderDelivery, next.delivery)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, Sign(next.endpoint.Secret, timestamp, next.body))
+
+	response, err := sender.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxResponseBody))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("endpoint answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// attempted records how many attempts at a delivery were made, unless it
+// already fell out of the log
+func (sender *Sender) attempted(id string, attempts int) {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	if delivery, ok := sender.deliveries[id]; ok {
+		delivery.Attempts = attempts
+	}
+}
+
+// retry schedules the next attempt after a failed one, waiting twice as
+// long as before the previous attempt
+func (sender *Sender) retry(next attempt, attempts int, statusCode int, err error) {
+	wait := sender.options.Backoff << (attempts - 1)
+
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	if sender.closed {
+		sender.finish(next.delivery, StatusFailed, statusCode, err.Error())
+		return
+	}
+	if delivery, ok := sender.deliveries[next.delivery]; ok {
+		delivery.StatusCode = statusCode
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(wait).UTC()
+	}
+	sender.retries[next.delivery] = time.AfterFunc(wait, func() {
+		sender.mutex.Lock()
+		_, waiting := sender.retries[next.delivery]
+		delete(sender.retries, next.delivery)
+		sender.mutex.Unlock()
+		if waiting {
+			sender.enqueue(next)
+		}
+	})
+}
+
+// finish settles a delivery. It must be called with the lock held.
+func (sender *Sender) finish(id string, status string, statusCode int, message string) {
+	delete(sender.retries, id)
+	delivery, ok := sender.deliveries[id]
+	if !ok {
+		return
+	}
+	delivery.Status = status
+	delivery.StatusCode = statusCode
+	delivery.Error = message
+	delivery.NextAttemptAt = time.Time{}
+}
+
+// Sign returns the signature header of a delivery of body made at
+// timestamp, in Unix seconds
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -5,6 +5,19 @@ type UserDataType = {
   userId: string | null;
   userName: string | null;
   userColor: string | null;
+  avatarUrl?: string;
+  // How many tabs the user has open in the room, in presence messages
+  tabs?: string;
+  // "true" for bots, which connect with a service token
+  bot?: string;
+  // Round trip to the server in milliseconds, once measured
+  latency?: string;
+  // Awareness fields set by the user's tab: "true" while in the background,
+  // the kind of device, and anything else it chose to share
+  idle?: string;
+  device?: string;
+  status?: string;
+  [field: string]: string | null | undefined;
 };
 
 interface UserCursor {
@@ -16,6 +29,269 @@ interface ContentPayload {
   content: string;
   position: { x: number; y: number };
   userData: UserDataType;
+  session?: { sessionId: string; lastSeq?: string };
+  revision?: number;
+  // Numbers our own edits so the server can acknowledge each one
+  seq?: number;
+}
+
+interface RosterPayload {
+  users: Array<UserDataType>;
+}
+
+interface ImpersonationPayload {
+  operator: string;
+  action: "documents-viewed" | "room-joined" | "room-left";
+  docId?: string;
+}
+
+const impersonationNotices: Record<ImpersonationPayload["action"], string> = {
+  "documents-viewed": "viewed your document list",
+  "room-joined": "is viewing this document as you",
+  "room-left": "stopped viewing this document as you",
+};
+
+interface MetadataPayload {
+  language: string;
+  direction: "ltr" | "rtl";
+  title?: string;
+  titleSuggested?: boolean;
+  description?: string;
+  tags?: Array<string>;
+}
+
+type MetaUpdate = Partial<Pick<MetadataPayload, "title" | "description" | "tags">>;
+
+interface LinkReportPayload {
+  broken: Array<{ url: string; text: string; status?: number; error?: string }>;
+}
+
+interface AnnotationPayload {
+  revision: number;
+  annotations: Array<{
+    range: { start: number; end: number };
+    message: string;
+    rule?: string;
+    category?: string;
+    replacements?: Array<string>;
+  }>;
+}
+
+interface CapabilitiesPayload {
+  owner: boolean;
+  export: boolean;
+  exportPolicy: "owners" | "editors" | "anyone";
+  edit: boolean;
+  suggest: boolean;
+  mode: "editing" | "locked" | "suggesting";
+  trackChanges: boolean;
+  analysis: boolean;
+}
+
+interface Suggestion {
+  id: string;
+  author: string;
+  baseRevision: number;
+  content: string;
+  updatedAt: string;
+}
+
+interface TrackedChange {
+  id: string;
+  author: string;
+  revision: number;
+  range: { start: number; end: number };
+  inserted: string;
+  deleted: string;
+  updatedAt: string;
+}
+
+// Emoji reactions on one range of the text, which the server keeps in
+// step with edits
+interface Reaction {
+  id: string;
+  range: { start: number; end: number };
+  counts: Record<string, number>;
+  users: Record<string, Array<string>>;
+}
+
+const REACTION_EMOJI = ["👍", "❤️", "🎉", "😄", "👀"];
+
+// Who wrote each range of the text; author is missing for text written
+// before the server kept authorship
+interface AuthorRange {
+  start: number;
+  end: number;
+  author?: string;
+}
+
+interface AttributionPayload {
+  revision: number;
+  ranges: Array<AuthorRange>;
+}
+
+// How an edit changed authorship: the deleted characters at pos were
+// replaced by ranges, which cover the text inserted there
+interface AttributionUpdatePayload extends AttributionPayload {
+  pos: number;
+  deleted: number;
+}
+
+// Applies an attribution-update to the ranges it follows
+const spliceAttribution = (
+  ranges: Array<AuthorRange>,
+  update: AttributionUpdatePayload
+): Array<AuthorRange> => {
+  const end = update.pos + update.deleted;
+  const shift =
+    update.ranges.reduce((n, r) => n + r.end - r.start, 0) - update.deleted;
+  const spliced = [
+    ...ranges
+      .filter((r) => r.start < update.pos)
+      .map((r) => ({ ...r, end: Math.min(r.end, update.pos) })),
+    ...update.ranges,
+    ...ranges
+      .filter((r) => r.end > end)
+      .map((r) => ({ ...r, start: Math.max(r.start, end) + shift, end: r.end + shift })),
+  ];
+  const merged: Array<AuthorRange> = [];
+  for (const range of spliced) {
+    const last = merged[merged.length - 1];
+    if (last && last.author === range.author) {
+      last.end = range.end;
+    } else {
+      merged.push({ ...range });
+    }
+  }
+  return merged;
+};
+
+// A file uploaded to the document, served from url on the server
+interface Attachment {
+  id: string;
+  name: string;
+  contentType: string;
+  size: number;
+  url: string;
+  uploader: UserDataType;
+}
+
+interface SuggestionResolvedPayload {
+  id: string;
+  author: string;
+  status: "accepted" | "rejected";
+}
+
+interface EditAckPayload {
+  seq: number;
+  status: "committed" | "suggested" | "rejected" | "duplicate";
+  revision?: number;
+}
+
+// Where the document stands with the server's storage
+interface SaveStatusPayload {
+  state: "dirty" | "saving" | "saved" | "save-error";
+  revision: number;
+  savedAt?: string;
+  error?: string;
+}
+
+// Viewers of a crowded room are counted rather than listed
+interface ViewerCountPayload {
+  viewers: number;
+}
+
+// How far each user who opened the document has seen it
+interface SeenStatePayload {
+  revision: number;
+  users: Array<{ userId: string; revision: number; at: string }>;
+}
+
+const saveStatusLabels: Record<SaveStatusPayload["state"], string> = {
+  dirty: "Unsaved changes",
+  saving: "Saving to storage…",
+  saved: "Stored",
+  "save-error": "Storage failed",
+};
+
+// An edit we haven't had an edit-ack for yet
+interface PendingEdit {
+  seq: number;
+  payload: ContentPayload;
+}
+
+interface NoticePayload {
+  text: string;
+  sentAt: string;
+}
+
+// Sent when the server is about to close the connection as idle
+interface IdleWarningPayload {
+  closesIn: string;
+}
+
+// The server measures round trips by pinging; answering with our clock
+// lets it work out the skew too
+interface PingPayload {
+  id: string;
+  serverTime: number;
+}
+
+// Sent to the room when the owner kicks, bans or mutes someone
+interface UserModeratedPayload {
+  userId: string;
+  action: "kick" | "ban" | "unban" | "mute" | "unmute";
+  until?: string;
+  reason?: string;
+  by: string;
+}
+
+const moderationLabels: Record<UserModeratedPayload["action"], string> = {
+  kick: "was removed from the document",
+  ban: "was banned from the document",
+  unban: "is no longer banned",
+  mute: "was muted",
+  unmute: "is no longer muted",
+};
+
+interface TypingPayload {
+  typing: boolean;
+  userData: UserDataType;
+}
+
+// The account a session is signed in to
+interface AccountProfile {
+  id: string;
+  email: string;
+  displayName: string;
+  color: string;
+  avatarUrl?: string;
+  emailOptOut: boolean;
+  providers: string[];
+}
+
+// Why the server ended the connection, sent with the error just before
+interface CloseReason {
+  code: number;
+  name: string;
+  retry: boolean;
+}
+
+interface ErrorPayload {
+  error: {
+    code: string;
+    message: string;
+    field?: string;
+    close?: CloseReason;
+    retryAfter?: string;
+  };
+}
+
+interface ClientErrorReport {
+  kind: "sync-failure" | "desync";
+  message: string;
+  code?: string;
+  messageType?: string;
 }
 
 interface WSMessage {
@@ -23,10 +299,191 @@ interface WSMessage {
   data: ContentPayload;
 }
 
+// Lets a reload or reconnect resume the same server-side identity
+const SESSION_KEY = "collab-session-id";
+
+// The node the editor starts on; a room can move to another node later.
+// Builds are served by the server itself, the dev server runs beside it.
+const API_URL = import.meta.env.DEV ? "http://localhost:8080" : window.location.origin;
+
+// The room the server puts clients in when they don't ask for one
+const DOC_ID = "default";
+
+// The token of the invitation link the page was opened with, if any
+const SHARE_TOKEN = new URLSearchParams(window.location.search).get("share");
+
+// A login provider sends the browser back with the new session, or why
+// signing in failed, in the URL fragment
+const LOGIN_RESULT = new URLSearchParams(window.location.hash.slice(1));
+const LOGIN_ERROR = LOGIN_RESULT.get("loginError");
+if (LOGIN_RESULT.has("session") || LOGIN_ERROR) {
+  const session = LOGIN_RESULT.get("session");
+  if (session) sessionStorage.setItem(SESSION_KEY, session);
+  history.replaceState(null, "", window.location.pathname + window.location.search);
+}
+
+// Names shown for the login providers the server can offer
+const LOGIN_PROVIDER_NAMES: Record<string, string> = {
+  google: "Google",
+  github: "GitHub",
+};
+
+// How often a client on an HTTP fallback tries to get back on a WebSocket
+const WS_RETRY_INTERVAL = 30000;
+
+// The message protocol version this client speaks
+const PROTOCOL_VERSION = 1;
+
+// Close codes from the server's catalog, also listed in /api/meta
+const CLOSE_PROTOCOL_MISMATCH = 4000;
+const CLOSE_AUTH_EXPIRED = 4001;
+const CLOSE_BANNED = 4002;
+const CLOSE_ROOM_FULL = 4004;
+const CLOSE_ROOM_MOVED = 4005;
+const CLOSE_SERVER_DRAINING = 4006;
+const CLOSE_SHARE_INVALID = 4007;
+const CLOSE_DOCUMENT_TRASHED = 4012;
+const CLOSE_IDLE_TIMEOUT = 4015;
+const CLOSE_RATE_LIMITED = 4016;
+
+// How long to wait before reconnecting after the server closed the
+// connection for a reason that can pass
+const RECONNECT_DELAY = 2000;
+const ROOM_FULL_DELAY = 15000;
+const RATE_LIMITED_DELAY = 30000;
+
+// What counts as the user being back after an idle disconnect
+const ACTIVITY_EVENTS = ["focus", "keydown", "pointerdown"];
+
+// The editor only sends over its connection, so the HTTP fallbacks can
+// stand in for a WebSocket
+interface Transport {
+  send: (data: string) => void;
+  close: () => void;
+}
+
+// Sends messages from an HTTP fallback as POSTs to the room. Posts are
+// chained so edits arrive in the order they were made.
+const messagePoster = (server: string, connId: () => string) => {
+  let pending = Promise.resolve();
+
+  return (data: string) => {
+    if (!connId()) return;
+    const url = `${server}/api/rooms/${DOC_ID}/messages?connId=${encodeURIComponent(connId())}`;
+    pending = pending
+      .then(() =>
+        fetch(url, {
+          method: "POST",
+          headers: {
+            Authorization: `Bearer ${sessionStorage.getItem(SESSION_KEY) ?? ""}`,
+            "Content-Type": "application/json",
+          },
+          body: data,
+        })
+      )
+      .then((response) => {
+        if (!response.ok) console.error("Message rejected", response.status);
+      })
+      .catch((err) => console.error("Could not send message", err));
+  };
+};
+
+// Fallback for networks that block WebSockets: the server streams its
+// messages as Server-Sent Events. onUnavailable is called when the stream
+// can't be opened either.
+const openEventStream = (
+  server: string,
+  query: string,
+  onOpen: () => void,
+  onMessage: (event: MessageEvent) => void,
+  onUnavailable: () => void
+): Transport => {
+  const source = new EventSource(`${server}/events${query}`);
+  let connId = "";
+
+  source.addEventListener("connection", (event) => {
+    connId = (JSON.parse((event as MessageEvent).data) as { connId: string })
+      .connId;
+    onOpen();
+  });
+  source.addEventListener("message", onMessage);
+  source.addEventListener("error", () => {
+    if (connId) return;
+    source.close();
+    onUnavailable();
+  });
+
+  return {
+    send: messagePoster(server, () => connId),
+    close: () => source.close(),
+  };
+};
+
+// Last resort for networks that block event streams too: the server queues
+// our messages and answers each poll with whatever is waiting
+const openLongPoll = (
+  server: string,
+  query: string,
+  onOpen: () => void,
+  onMessage: (event: MessageEvent) => void
+): Transport => {
+  let connId = "";
+  let closed = false;
+
+  const poll = async () => {
+    while (!closed) {
+      try {
+        const response = await fetch(
+          `${server}/api/rooms/${DOC_ID}/poll?connId=${encodeURIComponent(connId)}`,
+          {
+            headers: {
+              Authorization: `Bearer ${sessionStorage.getItem(SESSION_KEY) ?? ""}`,
+            },
+          }
+        );
+        if (response.status === 404 || response.status === 410) {
+          console.error("Long poll connection closed by the server");
+          return;
+        }
+        if (!response.ok) throw new Error(`poll failed with ${response.status}`);
+        const { messages } = (await response.json()) as { messages: unknown[] };
+        if (closed) return;
+        messages.forEach((message) =>
+          onMessage(new MessageEvent("message", { data: JSON.stringify(message) }))
+        );
+      } catch (err) {
+        console.error("Could not poll", err);
+        await new Promise((resolve) => setTimeout(resolve, 2000));
+      }
+    }
+  };
+
+  fetch(`${server}/api/rooms/${DOC_ID}/poll${query}`, { method: "POST" })
+    .then((response) => {
+      if (!response.ok) throw new Error(`open failed with ${response.status}`);
+      return response.json() as Promise<{ connId: string; sessionId: string }>;
+    })
+    .then((opened) => {
+      connId = opened.connId;
+      sessionStorage.setItem(SESSION_KEY, opened.sessionId);
+      onOpen();
+      poll();
+    })
+    .catch((err) => console.error("Could not open a long poll connection", err));
+
+  return {
+    send: messagePoster(server, () => connId),
+    close: () => {
+      closed = true;
+    },
+  };
+};
+
 export default function DocPage() {
-  const ws = useRef<WebSocket | null>(null);
+  const ws = useRef<Transport | null>(null);
   const contentArea = useRef<HTMLDivElement | null>(null);
   const [isConnected, setIsConnected] = useState<boolean>(false);
+  const [server, setServer] = useState<string>(API_URL);
   const userDataRef = useRef<UserDataType>({
     userId: null,
     userName: "",
@@ -34,27 +491,149 @@ export default function DocPage() {
   });
   const [userCursors, setUserCursors] = useState<Array<UserCursor>>([]);
   const [users, setUsers] = useState<Array<UserDataType>>([]);
+  const [typingUsers, setTypingUsers] = useState<Array<UserDataType>>([]);
+  const [impersonationNotice, setImpersonationNotice] = useState<string>("");
+  const [serverNotice, setServerNotice] = useState<string>("");
+  const [connectionNotice, setConnectionNotice] = useState<string>("");
+  const [reconnects, setReconnects] = useState<number>(0);
+  const [permalink, setPermalink] = useState<string>("");
+  const [shareRole, setShareRole] = useState<"edit" | "view">("view");
+  const [shareEmail, setShareEmail] = useState("");
+  const [shareLink, setShareLink] = useState<string>("");
+  const [account, setAccount] = useState<AccountProfile | null>(null);
+  const [loginProviders, setLoginProviders] = useState<string[]>([]);
+  const [credentials, setCredentials] = useState({
+    email: "",
+    password: "",
+    displayName: "",
+  });
+  const [capabilities, setCapabilities] = useState<CapabilitiesPayload>({
+    owner: false,
+    export: false,
+    exportPolicy: "anyone",
+    edit: true,
+    suggest: false,
+    mode: "editing",
+    trackChanges: false,
+    analysis: false,
+  });
+  const [suggestions, setSuggestions] = useState<Array<Suggestion>>([]);
+  const [trackedChanges, setTrackedChanges] = useState<Array<TrackedChange>>(
+    []
+  );
+  const [reactions, setReactions] = useState<Array<Reaction>>([]);
+  // Who wrote what, while the blame view is on
+  const [attribution, setAttribution] = useState<AttributionPayload | null>(null);
+  const attributionRef = useRef<AttributionPayload | null>(null);
+  const [attachments, setAttachments] = useState<Array<Attachment>>([]);
+  const [brokenLinks, setBrokenLinks] = useState<LinkReportPayload["broken"]>(
+    []
+  );
+  const [annotations, setAnnotations] = useState<
+    AnnotationPayload["annotations"]
+  >([]);
+  const [metadata, setMetadata] = useState<MetadataPayload>({
+    language: "",
+    direction: "ltr",
+  });
+  const revisionRef = useRef<number>(0);
+  const editSeqRef = useRef<number>(0);
+  const pendingEditsRef = useRef<Array<PendingEdit>>([]);
+  const [saveState, setSaveState] = useState<"saved" | "saving" | "failed">(
+    "saved"
+  );
+  // Only servers with storage send this
+  const [saveStatus, setSaveStatus] = useState<SaveStatusPayload | null>(null);
+  const [viewerCount, setViewerCount] = useState(0);
+  const [seenState, setSeenState] = useState<SeenStatePayload>({ revision: 0, users: [] });
+  // The user whose viewport we follow, and how many follow ours
+  const [following, setFollowing] = useState<string | null>(null);
+  const [followerCount, setFollowerCount] = useState(0);
+  const [unreadMentions, setUnreadMentions] = useState(0);
+
+  // Best effort: a report that can't be delivered is only logged locally
+  const reportClientError = (report: ClientErrorReport): void => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    if (!session) return;
+    fetch(`${server}/api/telemetry/client-errors`, {
+      method: "POST",
+      headers: {
+        Authorization: `Bearer ${session}`,
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({
+        ...report,
+        docId: DOC_ID,
+        revision: revisionRef.current,
+        encoding: "json",
+        occurredAt: new Date().toISOString(),
+      }),
+    }).catch((err) => console.error("Could not report client error", err));
+  };
+
+  // Frames can race with the initial sync, so anything not newer than the
+  // current revision is ignored. Acks let the server replay missed edits.
+  const acceptRevision = (revision: number | undefined): boolean => {
+    if (revision === undefined) return true;
+    if (revision <= revisionRef.current) return false;
+    revisionRef.current = revision;
+    ws.current?.send(JSON.stringify({ type: "ack", data: { revision } }));
+    return true;
+  };
+
+  // Every edit is numbered and kept until the server acknowledges it
+  const sendEdit = (payload: ContentPayload): void => {
+    const seq = ++editSeqRef.current;
+    pendingEditsRef.current.push({ seq, payload });
+    setSaveState("saving");
+    ws.current?.send(
+      JSON.stringify({ type: "content", data: { ...payload, seq } })
+    );
+  };
+
+  // Edits replace the whole document, so only the latest unacknowledged
+  // one needs to reach the server, numbered from where it asks
+  const resendEdits = (from: number): void => {
+    const pending = pendingEditsRef.current;
+    const latest = pending[pending.length - 1];
+    editSeqRef.current = from - 1;
+    pendingEditsRef.current = [];
+    if (latest) {
+      sendEdit(latest.payload);
+    }
+  };
+
+  const handleEditAck = (ack: EditAckPayload): void => {
+    pendingEditsRef.current = pendingEditsRef.current.filter(
+      (edit) => edit.seq > ack.seq
+    );
+    // Our own edit is already applied locally
+    if (ack.revision !== undefined && ack.revision > revisionRef.current) {
+      revisionRef.current = ack.revision;
+    }
+    if (ack.status === "rejected") {
+      setSaveState("failed");
+    } else if (pendingEditsRef.current.length === 0) {
+      setSaveState((state) => (state === "saving" ? "saved" : state));
+    }
+  };
 
   const throttleRef = useRef(
-    throttle((payload: ContentPayload) => {
+    throttle((payload: ContentPayload) => sendEdit(payload), 200)
+  );
+
+  // The server debounces typing state, so a keystroke every second is enough
+  // to keep the indicator alive
+  const typingRef = useRef(
+    throttle(() => {
       ws.current?.send(
-        JSON.stringify({
-          type: "content",
-          data: payload,
-        })
+        JSON.stringify({ type: "typing", data: { typing: true } })
       );
-    }, 200)
+    }, 1000)
   );
 
   const debounceRef = useRef(
-    debounce((payload: ContentPayload) => {
-      ws.current?.send(
-        JSON.stringify({
-          type: "content",
-          data: payload,
-        })
-      );
-    }, 400)
+    debounce((payload: ContentPayload) => sendEdit(payload), 400)
   );
 
   const applyRemoteUpdate = (remoteContent: string): void => {
@@ -114,27 +693,176 @@ export default function DocPage() {
     });
   };
 
+  // Tabs of the same browser share a user, which is listed once with a
+  // count of its tabs
   const addNewUser = (user: UserDataType) => {
-    setUsers((prevUsers) => [...prevUsers, user]);
+    if (user.userId === userDataRef.current.userId) return;
+    setUsers((prevUsers) => [
+      ...prevUsers.filter((u) => u.userId !== user.userId),
+      user,
+    ]);
   };
 
   const removeUser = (user: UserDataType) => {
+    setTypingUsers((prev) => prev.filter((u) => u.userId !== user.userId));
+    if (Number(user.tabs) > 0) {
+      setUsers((prevUsers) =>
+        prevUsers.map((u) => (u.userId === user.userId ? user : u))
+      );
+      return;
+    }
     setUsers((prevUsers) => prevUsers.filter((u) => u.userId !== user.userId));
   };
 
+  const handleTyping = (data: TypingPayload) => {
+    setTypingUsers((prev) => {
+      const others = prev.filter((u) => u.userId !== data.userData.userId);
+      return data.typing ? [...others, data.userData] : others;
+    });
+  };
+
+  // The server may coalesce several messages into one frame, one per line
   const handleServerResponse = (event: MessageEvent) => {
-    const parsedData = JSON.parse(event.data) as WSMessage;
+    (event.data as string)
+      .split("\n")
+      .filter((line) => line.trim() !== "")
+      .forEach((line) => handleServerMessage(JSON.parse(line) as WSMessage));
+  };
+
+  const handleServerMessage = (parsedData: WSMessage) => {
     const eventType = parsedData.type;
 
     if (eventType === "content") {
-      if (parsedData.data.userData.userId !== userDataRef.current.userId) {
+      if (
+        parsedData.data.userData.userId !== userDataRef.current.userId &&
+        acceptRevision(parsedData.data.revision)
+      ) {
         applyRemoteUpdate(parsedData.data.content);
         handleUserCursors(parsedData.data);
       }
     }
 
+    if (eventType === "save-status") {
+      setSaveStatus(parsedData.data as unknown as SaveStatusPayload);
+    }
+
+    if (eventType === "viewer-count") {
+      setViewerCount((parsedData.data as unknown as ViewerCountPayload).viewers);
+    }
+
+    if (eventType === "seen-state") {
+      setSeenState(parsedData.data as unknown as SeenStatePayload);
+    }
+
+    if (eventType === "followers") {
+      setFollowerCount((parsedData.data as unknown as { followers: number }).followers);
+    }
+
+    if (eventType === "viewport") {
+      const { scroll } = parsedData.data as unknown as { scroll: number };
+      const max = document.documentElement.scrollHeight - window.innerHeight;
+      window.scrollTo({ top: scroll * max, behavior: "smooth" });
+    }
+
+    if (eventType === "follow-ended") {
+      setFollowing(null);
+    }
+
+    if (eventType === "edit-ack") {
+      handleEditAck(parsedData.data as unknown as EditAckPayload);
+    }
+
+    // An edit went missing on the way; send again from there
+    if (eventType === "edit-retransmit") {
+      resendEdits((parsedData.data as unknown as { from: number }).from);
+    }
+
+    // Messages were dropped while this client fell behind; the doc-sync
+    // and roster that follow restore the room, but typing state is lost
+    if (eventType === "resync-required") {
+      console.warn("Resyncing after falling behind the room");
+      setTypingUsers([]);
+    }
+
+    if (eventType === "doc-sync") {
+      const revision = parsedData.data.revision ?? 0;
+      if (revision >= revisionRef.current) {
+        revisionRef.current = revision;
+        applyRemoteUpdate(parsedData.data.content);
+      } else {
+        reportClientError({
+          kind: "desync",
+          message: `doc-sync at revision ${revision} is behind the local revision`,
+          messageType: "doc-sync",
+        });
+      }
+    }
+
+    if (eventType === "error") {
+      const { error } = parsedData.data as unknown as ErrorPayload;
+      // The server is about to close the connection and says why
+      if (error.close) {
+        setConnectionNotice(error.message);
+        return;
+      }
+      // Edits refused for the document's limits; the doc-sync that
+      // follows undoes them here
+      if (
+        error.code === "document-too-large" ||
+        error.code === "document-busy" ||
+        error.code === "muted"
+      ) {
+        setConnectionNotice(error.message);
+        return;
+      }
+      console.error("Server rejected a message", error);
+      reportClientError({
+        kind: "sync-failure",
+        message: error.message,
+        code: error.code,
+      });
+    }
+
     if (eventType === "user-data") {
       userDataRef.current = parsedData.data.userData;
+      if (parsedData.data.session) {
+        const { sessionId, lastSeq } = parsedData.data.session;
+        sessionStorage.setItem(SESSION_KEY, sessionId);
+        // Edits the server already received before a reconnect were
+        // applied; the rest are sent again
+        const received = Number(lastSeq ?? 0);
+        pendingEditsRef.current = pendingEditsRef.current.filter(
+          (edit) => edit.seq > received
+        );
+        if (pendingEditsRef.current.length > 0) {
+          resendEdits(received + 1);
+        } else {
+          editSeqRef.current = received;
+          setSaveState((state) => (state === "saving" ? "saved" : state));
+        }
+      }
+    }
+
+    if (eventType === "user-renamed") {
+      const renamed = parsedData.data.userData;
+      if (renamed.userId === userDataRef.current.userId) {
+        userDataRef.current = renamed;
+      }
+      setUsers((prevUsers) =>
+        prevUsers.map((u) =>
+          u.userId === renamed.userId ? { ...renamed, tabs: u.tabs } : u
+        )
+      );
+    }
+
+    // Another tab's awareness changed; ours is only what we sent
+    if (eventType === "awareness-update") {
+      const updated = parsedData.data.userData;
+      setUsers((prevUsers) =>
+        prevUsers.map((u) =>
+          u.userId === updated.userId ? { ...updated, tabs: u.tabs } : u
+        )
+      );
     }
 
     if (eventType === "user-added") {
@@ -144,19 +872,666 @@ export default function DocPage() {
     if (eventType === "user-removed") {
       removeUser(parsedData.data.userData);
     }
+
+    if (eventType === "impersonation") {
+      const notice = parsedData.data as unknown as ImpersonationPayload;
+      setImpersonationNotice(
+        `Support operator ${notice.operator} ${impersonationNotices[notice.action]}`
+      );
+    }
+
+    if (eventType === "capabilities") {
+      setCapabilities(parsedData.data as unknown as CapabilitiesPayload);
+    }
+
+    // The room moved to another node; reconnecting there resumes the
+    // session, which moved along with it
+    if (eventType === "room-migrated") {
+      setIsConnected(false);
+      setServer((parsedData.data as unknown as { url: string }).url);
+    }
+
+    if (eventType === "suggestions") {
+      setSuggestions(
+        (parsedData.data as unknown as { suggestions: Array<Suggestion> })
+          .suggestions
+      );
+    }
+
+    // An author's suggestion is updated in place as they keep editing
+    if (eventType === "suggestion") {
+      const suggestion = parsedData.data as unknown as Suggestion;
+      setSuggestions((prev) => [
+        ...prev.filter((s) => s.id !== suggestion.id),
+        suggestion,
+      ]);
+    }
+
+    if (eventType === "suggestion-resolved") {
+      const { id } = parsedData.data as unknown as SuggestionResolvedPayload;
+      setSuggestions((prev) => prev.filter((s) => s.id !== id));
+    }
+
+    if (eventType === "tracked-changes") {
+      setTrackedChanges(
+        (parsedData.data as unknown as { changes: Array<TrackedChange> })
+          .changes
+      );
+    }
+
+    if (eventType === "notification") {
+      setUnreadMentions((parsedData.data as unknown as { unread: number }).unread);
+    }
+
+    if (eventType === "reactions") {
+      setReactions(
+        (parsedData.data as unknown as { reactions: Array<Reaction> }).reactions
+      );
+    }
+
+    if (eventType === "attribution") {
+      attributionRef.current = parsedData.data as unknown as AttributionPayload;
+      setAttribution(attributionRef.current);
+    }
+
+    if (eventType === "attribution-update") {
+      const update = parsedData.data as unknown as AttributionUpdatePayload;
+      const current = attributionRef.current;
+      if (current && update.revision === current.revision + 1) {
+        attributionRef.current = {
+          revision: update.revision,
+          ranges: spliceAttribution(current.ranges, update),
+        };
+        setAttribution(attributionRef.current);
+      } else if (current && update.revision > current.revision) {
+        // An update overtook another, start over from the whole attribution
+        ws.current?.send(
+          JSON.stringify({ type: "attribution", data: { enabled: true } })
+        );
+      }
+    }
+
+    if (eventType === "attachment-added") {
+      const { attachment } = parsedData.data as unknown as {
+        attachment: Attachment;
+      };
+      setAttachments((prev) =>
+        prev.some((a) => a.id === attachment.id) ? prev : [...prev, attachment]
+      );
+      return;
+    }
+    if (eventType === "reaction-updated") {
+      const reaction = parsedData.data as unknown as Reaction;
+      setReactions((prev) => {
+        if (Object.keys(reaction.counts).length === 0) {
+          return prev.filter((r) => r.id !== reaction.id);
+        }
+        return prev.some((r) => r.id === reaction.id)
+          ? prev.map((r) => (r.id === reaction.id ? reaction : r))
+          : [...prev, reaction];
+      });
+    }
+
+    if (eventType === "server-notice") {
+      setServerNotice((parsedData.data as unknown as NoticePayload).text);
+    }
+
+    if (eventType === "ping") {
+      const { id } = parsedData.data as unknown as PingPayload;
+      ws.current?.send(
+        JSON.stringify({ type: "latency", data: { id, clientTime: Date.now() } })
+      );
+    }
+
+    if (eventType === "idle-warning") {
+      const { closesIn } = parsedData.data as unknown as IdleWarningPayload;
+      setConnectionNotice(`Disconnecting in ${closesIn} unless you're still here`);
+      // Reading counts too: any sign of the user keeps the connection
+      const stillHere = () => {
+        ACTIVITY_EVENTS.forEach((name) => window.removeEventListener(name, stillHere));
+        ws.current?.send(JSON.stringify({ type: "activity", data: {} }));
+        setConnectionNotice("");
+      };
+      ACTIVITY_EVENTS.forEach((name) => window.addEventListener(name, stillHere));
+    }
+
+    if (eventType === "user-moderated") {
+      const moderated = parsedData.data as unknown as UserModeratedPayload;
+      const name =
+        moderated.userId === userDataRef.current.userId ? "You" : moderated.userId;
+      setServerNotice(
+        [
+          `${name} ${moderationLabels[moderated.action]}`,
+          moderated.until && `until ${new Date(moderated.until).toLocaleString()}`,
+          moderated.reason && `: ${moderated.reason}`,
+        ]
+          .filter(Boolean)
+          .join(" ")
+      );
+    }
+
+    if (eventType === "doc-metadata") {
+      setMetadata(parsedData.data as unknown as MetadataPayload);
+    }
+
+    // A title taken from the first line, until someone gives one
+    if (eventType === "title-suggested") {
+      setMetadata(parsedData.data as unknown as MetadataPayload);
+    }
+
+    if (eventType === "link-report") {
+      setBrokenLinks((parsedData.data as unknown as LinkReportPayload).broken);
+    }
+
+    if (eventType === "annotation") {
+      setAnnotations((parsedData.data as unknown as AnnotationPayload).annotations);
+    }
+
+    if (eventType === "typing") {
+      handleTyping(parsedData.data as unknown as TypingPayload);
+    }
+
+    if (eventType === "presence-roster") {
+      const roster = (parsedData.data as unknown as RosterPayload).users;
+      setUsers(
+        roster.filter((u) => u.userId !== userDataRef.current.userId)
+      );
+    }
   };
 
+  // A stored session may already be signed in to an account
+  useEffect(() => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    if (!session) return;
+    fetch(`${server}/api/users/me`, {
+      headers: { Authorization: `Bearer ${session}` },
+    })
+      .then((response) => (response.ok ? response.json() : null))
+      .then((body: { user: AccountProfile } | null) => setAccount(body?.user ?? null))
+      .catch((err) => console.error("Could not load profile", err));
+  }, [server]);
+
+  // Follows end with the connection
+  useEffect(() => {
+    if (!isConnected) {
+      setFollowing(null);
+      setFollowerCount(0);
+    }
+  }, [isConnected]);
+
+  // Only followed tabs send their viewport; the server relays it to the
+  // followers alone
+  useEffect(() => {
+    if (followerCount === 0) return;
+    const sendViewport = throttle(() => {
+      const max = document.documentElement.scrollHeight - window.innerHeight;
+      const scroll = max > 0 ? Math.min(1, Math.max(0, window.scrollY / max)) : 0;
+      ws.current?.send(JSON.stringify({ type: "viewport", data: { scroll } }));
+    }, 100);
+    sendViewport();
+    window.addEventListener("scroll", sendViewport);
+    return () => window.removeEventListener("scroll", sendViewport);
+  }, [followerCount]);
+
+  const toggleFollow = (userId: string | null): void => {
+    if (!userId) return;
+    const next = following === userId ? "" : userId;
+    ws.current?.send(JSON.stringify({ type: "follow-request", data: { userId: next } }));
+    setFollowing(next || null);
+  };
+
+  // The selection as offsets into the text of the document, if it is a
+  // non-empty range inside the editor
+  const selectedRange = (): { start: number; end: number } | null => {
+    const sel = window.getSelection();
+    if (!contentArea.current || !sel || sel.rangeCount === 0) return null;
+    const range = sel.getRangeAt(0);
+    if (range.collapsed || !contentArea.current.contains(range.commonAncestorContainer)) {
+      return null;
+    }
+    const before = document.createRange();
+    before.selectNodeContents(contentArea.current);
+    before.setEnd(range.startContainer, range.startOffset);
+    const start = before.toString().length;
+    return { start, end: start + range.toString().length };
+  };
+
+  const reactToSelection = (emoji: string): void => {
+    const range = selectedRange();
+    if (!range) return;
+    ws.current?.send(
+      JSON.stringify({
+        type: "reaction-add",
+        data: { revision: revisionRef.current, range, emoji },
+      })
+    );
+  };
+
+  const toggleReaction = (reaction: Reaction, emoji: string): void => {
+    const userId = userDataRef.current.userId;
+    if (userId && reaction.users[emoji]?.includes(userId)) {
+      ws.current?.send(
+        JSON.stringify({ type: "reaction-remove", data: { id: reaction.id, emoji } })
+      );
+      return;
+    }
+    ws.current?.send(
+      JSON.stringify({
+        type: "reaction-add",
+        data: { revision: revisionRef.current, range: reaction.range, emoji },
+      })
+    );
+  };
+
+  // Tell the room which kind of device this tab is on and whether it is in
+  // the background
+  useEffect(() => {
+    if (!isConnected) return;
+    const sendAwareness = () => {
+      const awareness = {
+        device: window.matchMedia("(pointer: coarse)").matches ? "mobile" : "desktop",
+        idle: document.hidden ? "true" : "",
+      };
+      ws.current?.send(JSON.stringify({ type: "awareness-update", data: { awareness } }));
+    };
+    sendAwareness();
+    document.addEventListener("visibilitychange", sendAwareness);
+    return () => document.removeEventListener("visibilitychange", sendAwareness);
+  }, [isConnected]);
+
+  useEffect(() => {
+    fetch(`${server}/api/meta`)
+      .then((response) => response.json())
+      .then((meta: { features: { loginProviders?: string[] } }) =>
+        setLoginProviders(meta.features.loginProviders ?? [])
+      )
+      .catch((err) => console.error("Could not load server features", err));
+  }, [server]);
+
   useEffect(() => {
-    ws.current = new WebSocket("ws://localhost:8080/ws");
+    let disposed = false;
+    let onFallback = false;
+    let retry: ReturnType<typeof setTimeout> | undefined;
+    let awaitingActivity = false;
+    const onActivity = () => {
+      stopAwaitingActivity();
+      setReconnects((n) => n + 1);
+    };
+    const stopAwaitingActivity = () => {
+      if (!awaitingActivity) return;
+      awaitingActivity = false;
+      ACTIVITY_EVENTS.forEach((name) => window.removeEventListener(name, onActivity));
+    };
+
+    const sessionQuery = () => {
+      const params = new URLSearchParams();
+      const session = sessionStorage.getItem(SESSION_KEY);
+      if (session) params.set("session", session);
+      if (SHARE_TOKEN) params.set("share", SHARE_TOKEN);
+      const query = params.toString();
+      return query ? `?${query}` : "";
+    };
+
+    const connectWebSocket = () => {
+      const query = sessionQuery();
+      const socket = new WebSocket(
+        `${server.replace(/^http/, "ws")}/ws${query}${query ? "&" : "?"}protocol=${PROTOCOL_VERSION}`
+      );
+      let opened = false;
+      if (!onFallback) ws.current = socket;
+
+      socket.addEventListener("open", () => {
+        if (disposed) {
+          socket.close();
+          return;
+        }
+        console.log("Socket connected!");
+        opened = true;
+        setConnectionNotice("");
+        if (attributionRef.current) {
+          // The server forgets what a connection asked for when it closes
+          socket.send(JSON.stringify({ type: "attribution", data: { enabled: true } }));
+        }
+        if (onFallback) {
+          // WebSockets got through after all, leave the fallback behind
+          console.log("Upgraded from the HTTP fallback to a WebSocket");
+          ws.current?.close();
+          ws.current = socket;
+          onFallback = false;
+        }
+        setIsConnected(true);
+      });
+      socket.addEventListener("message", (event) => {
+        if (ws.current === socket) handleServerResponse(event);
+      });
+      socket.addEventListener("close", (event) => {
+        if (disposed || !opened || ws.current !== socket) return;
+        setIsConnected(false);
+        const reconnect = (delay: number) => {
+          retry = setTimeout(() => setReconnects((n) => n + 1), delay);
+        };
+        switch (event.code) {
+          case CLOSE_AUTH_EXPIRED:
+            // Start over with a new identity
+            sessionStorage.removeItem(SESSION_KEY);
+            reconnect(0);
+            break;
+          case CLOSE_BANNED:
+          case CLOSE_PROTOCOL_MISMATCH:
+          case CLOSE_SHARE_INVALID:
+          case CLOSE_DOCUMENT_TRASHED:
+            // Reconnecting won't help; the notice says why
+            break;
+          case CLOSE_ROOM_FULL:
+            reconnect(ROOM_FULL_DELAY);
+            break;
+          case CLOSE_RATE_LIMITED:
+            reconnect(RATE_LIMITED_DELAY);
+            break;
+          case CLOSE_IDLE_TIMEOUT:
+            // Nobody was here; come back once somebody is
+            awaitingActivity = true;
+            ACTIVITY_EVENTS.forEach((name) => window.addEventListener(name, onActivity));
+            break;
+          case CLOSE_ROOM_MOVED:
+            // room-migrated already pointed us at the new node
+            break;
+          case CLOSE_SERVER_DRAINING:
+          default:
+            reconnect(RECONNECT_DELAY);
+        }
+      });
+      socket.addEventListener("error", () => {
+        if (opened) {
+          reportClientError({ kind: "sync-failure", message: "WebSocket error" });
+          return;
+        }
+        if (disposed) return;
+        if (!onFallback) {
+          // Never got through, most likely a proxy that blocks WebSockets
+          console.warn("WebSocket unavailable, falling back to an event stream");
+          onFallback = true;
+          openFallback();
+        }
+        retry = setTimeout(connectWebSocket, WS_RETRY_INTERVAL);
+      });
+    };
+
+    const openFallback = () => {
+      const query = sessionQuery();
+      ws.current = openEventStream(
+        server,
+        query,
+        () => setIsConnected(true),
+        handleServerResponse,
+        () => {
+          if (disposed || !onFallback) return;
+          console.warn("Event stream unavailable, falling back to long polling");
+          ws.current = openLongPoll(
+            server,
+            query,
+            () => setIsConnected(true),
+            handleServerResponse
+          );
+        }
+      );
+    };
+
+    connectWebSocket();
+
+    return () => {
+      disposed = true;
+      clearTimeout(retry);
+      stopAwaitingActivity();
+      ws.current?.close();
+    };
+  }, [server, reconnects]);
 
-    ws.current.addEventListener("open", () => {
-      console.log("Socket connected!");
-      setIsConnected(true);
+  // Catch up on mentions made while this user was away
+  useEffect(() => {
+    if (!isConnected) return;
+    const session = sessionStorage.getItem(SESSION_KEY);
+    fetch(`${server}/api/notifications?unread=true`, {
+      headers: { Authorization: `Bearer ${session ?? ""}` },
+    })
+      .then((response) => response.json())
+      .then((body: { unread: number }) => setUnreadMentions(body.unread ?? 0))
+      .catch((err) => console.error("Could not load notifications", err));
+  }, [isConnected, server]);
+
+  const markMentionsRead = async () => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    const response = await fetch(`${server}/api/notifications/read`, {
+      method: "POST",
+      headers: { Authorization: `Bearer ${session ?? ""}` },
     });
-    ws.current.addEventListener("message", handleServerResponse);
+    if (response.ok) setUnreadMentions(0);
+  };
 
-    return () => ws.current?.close();
-  }, []);
+  const createSnapshot = async () => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    const response = await fetch(`${server}/api/documents/${DOC_ID}/snapshots`, {
+      method: "POST",
+      headers: { Authorization: `Bearer ${session ?? ""}` },
+    });
+    if (!response.ok) {
+      console.error("Could not create snapshot", response.status);
+      return;
+    }
+    const { permalink } = (await response.json()) as { permalink: string };
+    setPermalink(`${server}${permalink}`);
+  };
+
+  // Registers or signs in, then reconnects with the account's session so
+  // the room sees its profile
+  const authenticate = async (action: "register" | "login") => {
+    const response = await fetch(`${server}/api/users/${action}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(credentials),
+    });
+    const body = (await response.json()) as {
+      error?: string;
+      sessionId?: string;
+      user?: AccountProfile;
+    };
+    if (!response.ok || !body.sessionId || !body.user) {
+      setConnectionNotice(body.error ?? `Could not ${action}`);
+      return;
+    }
+    sessionStorage.setItem(SESSION_KEY, body.sessionId);
+    setAccount(body.user);
+    setCredentials({ email: "", password: "", displayName: "" });
+    setReconnects((n) => n + 1);
+  };
+
+  const updateEmailOptOut = async (emailOptOut: boolean) => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    const response = await fetch(`${server}/api/users/me`, {
+      method: "PATCH",
+      headers: {
+        Authorization: `Bearer ${session ?? ""}`,
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({ emailOptOut }),
+    });
+    if (!response.ok) {
+      console.error("Could not update email settings", response.status);
+      return;
+    }
+    setAccount(((await response.json()) as { user: AccountProfile }).user);
+  };
+
+  const createShareLink = async () => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    const response = await fetch(`${server}/api/documents/${DOC_ID}/share`, {
+      method: "POST",
+      headers: {
+        Authorization: `Bearer ${session ?? ""}`,
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({ role: shareRole, email: shareEmail || undefined }),
+    });
+    if (!response.ok) {
+      console.error("Could not create share link", response.status);
+      return;
+    }
+    const { token, emailed } = (await response.json()) as {
+      token: string;
+      emailed?: boolean;
+    };
+    if (shareEmail && !emailed) {
+      console.warn("The invitation was not emailed; send the link yourself");
+    }
+    setShareEmail("");
+    setShareLink(
+      `${window.location.origin}${window.location.pathname}?share=${encodeURIComponent(token)}`
+    );
+  };
+
+  const uploadAttachment = async (file: File) => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    const form = new FormData();
+    form.append("file", file);
+    const response = await fetch(
+      `${server}/api/documents/${DOC_ID}/attachments`,
+      {
+        method: "POST",
+        headers: { Authorization: `Bearer ${session ?? ""}` },
+        body: form,
+      }
+    );
+    if (!response.ok) {
+      console.error("Could not upload attachment", response.status);
+    }
+    // The attachment-added broadcast lists it, for us as for everyone else
+  };
+
+  const exportDocument = async () => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    const response = await fetch(
+      `${server}/api/documents/${DOC_ID}/export?format=html`,
+      { headers: { Authorization: `Bearer ${session ?? ""}` } }
+    );
+    if (!response.ok) {
+      console.error("Could not export document", response.status);
+      return;
+    }
+    const link = document.createElement("a");
+    link.href = URL.createObjectURL(await response.blob());
+    link.download = `${DOC_ID}.html`;
+    link.click();
+    URL.revokeObjectURL(link.href);
+  };
+
+  const changeExportPolicy = (policy: CapabilitiesPayload["exportPolicy"]) => {
+    ws.current?.send(JSON.stringify({ type: "export-policy", data: { policy } }));
+  };
+
+  const changeEditMode = (mode: CapabilitiesPayload["mode"]) => {
+    ws.current?.send(JSON.stringify({ type: "edit-mode", data: { mode } }));
+  };
+
+  const seenLabel = (userId: string | null) => {
+    const seen = seenState.users.find((s) => s.userId === userId);
+    if (!seen) return undefined;
+    return seen.revision >= seenState.revision
+      ? "has seen the latest changes"
+      : `${seenState.revision - seen.revision} changes unseen`;
+  };
+
+  // The owner picks an action for a collaborator from a prompt, such as
+  // "mute 1h" or "ban 24h spamming"; kick takes no duration
+  const moderateUser = (user: UserDataType) => {
+    const answer = window.prompt(
+      `Moderate ${user.userName}: kick, ban, unban, mute or unmute, then an optional duration (1h) and reason`
+    );
+    const [action, ...rest] = (answer ?? "").trim().split(/\s+/);
+    if (!action || !user.userId) return;
+    const duration = /^\d+[smh]/.test(rest[0] ?? "") ? rest.shift() : undefined;
+    ws.current?.send(
+      JSON.stringify({
+        type: "moderate",
+        data: { action, userId: user.userId, duration, reason: rest.join(" ") || undefined },
+      })
+    );
+  };
+
+  const reviewSuggestion = (id: string, accept: boolean) => {
+    ws.current?.send(
+      JSON.stringify({
+        type: accept ? "suggestion-accept" : "suggestion-reject",
+        data: { id },
+      })
+    );
+  };
+
+  const toggleTrackChanges = () => {
+    ws.current?.send(
+      JSON.stringify({
+        type: "track-changes",
+        data: { enabled: !capabilities.trackChanges },
+      })
+    );
+  };
+
+  const toggleAttribution = () => {
+    const enabled = attributionRef.current === null;
+    ws.current?.send(JSON.stringify({ type: "attribution", data: { enabled } }));
+    if (!enabled) {
+      attributionRef.current = null;
+      setAttribution(null);
+    }
+  };
+
+  const toggleAnalysis = () => {
+    ws.current?.send(
+      JSON.stringify({
+        type: "analysis",
+        data: { enabled: !capabilities.analysis },
+      })
+    );
+  };
+
+  // Without ids every pending change is reviewed. The room is sent the
+  // resulting content and list of changes over the socket.
+  const reviewChanges = async (accept: boolean, ids?: Array<string>) => {
+    const session = sessionStorage.getItem(SESSION_KEY);
+    const response = await fetch(
+      `${server}/api/documents/${DOC_ID}/changes/${accept ? "accept" : "reject"}`,
+      {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          Authorization: `Bearer ${session ?? ""}`,
+        },
+        body: JSON.stringify(ids ? { ids } : { all: true }),
+      }
+    );
+    if (!response.ok) {
+      console.error("Could not review changes", response.status);
+    }
+  };
+
+  const suggestionAuthor = (userId: string): string => {
+    if (userId === userDataRef.current.userId) return "You";
+    return users.find((u) => u.userId === userId)?.userName ?? "Someone";
+  };
+
+  // Only the fields that changed are sent, so someone else editing another
+  // field at the same time doesn't lose their edit
+  const updateMeta = (update: MetaUpdate) => {
+    ws.current?.send(JSON.stringify({ type: "doc-meta-update", data: update }));
+  };
+
+  const toggleDirection = () => {
+    ws.current?.send(
+      JSON.stringify({
+        type: "doc-metadata",
+        data: { direction: metadata.direction === "rtl" ? "ltr" : "rtl" },
+      })
+    );
+  };
 
   const handleInputTyping = (
     e: React.FormEvent<HTMLDivElement> | undefined
@@ -182,6 +1557,7 @@ export default function DocPage() {
 
     throttleRef.current(payload);
     debounceRef.current(payload);
+    typingRef.current();
   };
 
   return (
@@ -189,6 +1565,119 @@ export default function DocPage() {
       <div className="bg-white mb-4 p-4 flex justify-between shadow-md w-full">
         <span className="text-xl font-bold">Custom Docs</span>
         <div>
+          <button
+            className="mr-4 px-2 border rounded"
+            onClick={toggleDirection}
+            title="Switch the document between left-to-right and right-to-left"
+          >
+            {metadata.direction === "rtl" ? "RTL" : "LTR"}
+          </button>
+          <button
+            className="mr-4 px-2 border rounded"
+            onClick={createSnapshot}
+            title="Save a permanent, read-only copy of the document as it is now"
+          >
+            Snapshot
+          </button>
+          {capabilities.export && (
+            <button className="mr-4 px-2 border rounded" onClick={exportDocument}>
+              Export
+            </button>
+          )}
+          {capabilities.owner && (
+            <select
+              className="mr-4 px-2 border rounded"
+              value={capabilities.exportPolicy}
+              onChange={(e) =>
+                changeExportPolicy(
+                  e.target.value as CapabilitiesPayload["exportPolicy"]
+                )
+              }
+              title="Who may export or download this document"
+            >
+              <option value="owners">Only I can export</option>
+              <option value="editors">Editors can export</option>
+              <option value="anyone">Anyone with access can export</option>
+            </select>
+          )}
+          {capabilities.owner && (
+            <select
+              className="mr-4 px-2 border rounded"
+              value={capabilities.mode}
+              onChange={(e) =>
+                changeEditMode(e.target.value as CapabilitiesPayload["mode"])
+              }
+              title="Whether others can edit this document directly"
+            >
+              <option value="editing">Everyone can edit</option>
+              <option value="suggesting">Others suggest changes</option>
+              <option value="locked">Only I can edit</option>
+            </select>
+          )}
+          {capabilities.owner && (
+            <label
+              className="mr-4"
+              title="Record edits as changes you accept or reject"
+            >
+              <input
+                type="checkbox"
+                className="mr-1"
+                checked={capabilities.trackChanges}
+                onChange={toggleTrackChanges}
+              />
+              Track changes
+            </label>
+          )}
+          {capabilities.owner && (
+            <label
+              className="mr-4"
+              title="Check spelling and grammar once nobody is typing"
+            >
+              <input
+                type="checkbox"
+                className="mr-1"
+                checked={capabilities.analysis}
+                onChange={toggleAnalysis}
+              />
+              Spell check
+            </label>
+          )}
+          <label className="mr-4" title="Color the text by who wrote it">
+            <input
+              type="checkbox"
+              className="mr-1"
+              checked={attribution !== null}
+              onChange={toggleAttribution}
+            />
+            Blame
+          </label>
+          {capabilities.owner && (
+            <>
+              <select
+                className="mr-1 px-2 border rounded"
+                value={shareRole}
+                onChange={(e) => setShareRole(e.target.value as "edit" | "view")}
+                title="What people opening the link can do"
+              >
+                <option value="view">Can view</option>
+                <option value="edit">Can edit</option>
+              </select>
+              <input
+                className="mr-1 px-2 border rounded w-40"
+                type="email"
+                placeholder="Email it to (optional)"
+                value={shareEmail}
+                onChange={(e) => setShareEmail(e.target.value)}
+              />
+              <button
+                className="mr-4 px-2 border rounded"
+                onClick={createShareLink}
+                title="Create an invitation link that expires in a week"
+              >
+                Share link
+              </button>
+            </>
+          )}
           <span className="mr-4 font-bold">{userDataRef.current.userName}</span>
           <span
             className={`inline-block w-3 h-3 rounded-full mr-2 ${
@@ -196,29 +1685,506 @@ export default function DocPage() {
             }`}
           ></span>
           <span>{isConnected ? "Connected" : "Disconnected"}</span>
+          <span className="ml-4 text-sm text-gray-500">
+            {saveState === "saving"
+              ? "Saving…"
+              : saveState === "failed"
+                ? "Not saved"
+                : "Saved"}
+          </span>
+          {saveStatus && (
+            <span
+              className={`ml-2 text-sm ${
+                saveStatus.state === "save-error"
+                  ? "text-red-500"
+                  : "text-gray-500"
+              }`}
+              title={saveStatus.error ?? `Revision ${saveStatus.revision}`}
+            >
+              · {saveStatusLabels[saveStatus.state]}
+              {saveStatus.savedAt &&
+                ` (last stored ${new Date(saveStatus.savedAt).toLocaleTimeString()})`}
+            </span>
+          )}
         </div>
         <div className="flex gap-2 truncate max-w-48">
           {users.map((user: UserDataType, index: number) => {
             return (
               <div
                 key={user.userId}
-                className={`text-white px-2 py-1 rounded-full ${
+                className={`text-white px-2 py-1 rounded-full cursor-pointer ${
                   index > 0 && "-ml-4"
+                } ${user.idle === "true" ? "opacity-50" : ""} ${
+                  following === user.userId ? "ring-2 ring-blue-500" : ""
                 }`}
                 style={{ background: `${user.userColor}` }}
-                title={user.userName ?? ""}
+                onClick={() => toggleFollow(user.userId)}
+                onContextMenu={(e) => {
+                  if (!capabilities.owner || user.userId === userDataRef.current.userId) return;
+                  e.preventDefault();
+                  moderateUser(user);
+                }}
+                title={[
+                  Number(user.tabs) > 1
+                    ? `${user.userName} (${user.tabs} tabs)`
+                    : user.userName ?? "",
+                  user.bot === "true" && "bot",
+                  user.latency && `${user.latency} ms`,
+                  user.status,
+                  user.device && `on ${user.device}`,
+                  user.idle === "true" && "idle",
+                  seenLabel(user.userId),
+                  following === user.userId ? "click to stop following" : "click to follow",
+                  capabilities.owner &&
+                    user.userId !== userDataRef.current.userId &&
+                    "right-click to moderate",
+                ]
+                  .filter(Boolean)
+                  .join(" · ")}
               >
-                {user.userName?.slice(0, 2)}
+                {user.avatarUrl ? (
+                  <img
+                    src={user.avatarUrl}
+                    alt=""
+                    className="w-6 h-6 rounded-full"
+                  />
+                ) : (
+                  user.userName?.slice(0, 2)
+                )}
+                {Number(user.tabs) > 1 && (
+                  <sup className="ml-1">×{user.tabs}</sup>
+                )}
+                {user.bot === "true" && <sup className="ml-1">bot</sup>}
               </div>
             );
           })}
+          {seenState.users.length > 0 && (
+            <span
+              className="text-sm text-gray-500 self-center"
+              title={seenState.users
+                .map((seen) => `${seen.userId}: revision ${seen.revision}`)
+                .join("\n")}
+            >
+              Viewed by{" "}
+              {seenState.users.filter((seen) => seen.revision >= seenState.revision).length}/
+              {seenState.users.length}
+            </span>
+          )}
+          {viewerCount > 0 && (
+            <span className="text-sm text-gray-500 self-center">
+              +{viewerCount} watching
+            </span>
+          )}
+          {followerCount > 0 && (
+            <span className="text-sm text-blue-600 self-center">
+              {followerCount} following you
+            </span>
+          )}
+          {unreadMentions > 0 && (
+            <button
+              className="text-sm text-red-600 self-center"
+              title="Mark mentions read"
+              onClick={markMentionsRead}
+            >
+              @{unreadMentions}
+            </button>
+          )}
         </div>
       </div>
 
+      <div className="mb-4 w-[784px] flex flex-col gap-2">
+        {/* Keyed on the current value so edits from others replace it */}
+        <input
+          key={`title-${metadata.title ?? ""}`}
+          className={`text-2xl font-bold bg-transparent border-b ${
+            metadata.titleSuggested ? "text-gray-500" : ""
+          }`}
+          title={
+            metadata.titleSuggested
+              ? "Suggested from the document's first line"
+              : undefined
+          }
+          placeholder="Untitled document"
+          defaultValue={metadata.title ?? ""}
+          onBlur={(e) => {
+            if (e.target.value.trim() !== (metadata.title ?? "")) {
+              updateMeta({ title: e.target.value });
+            }
+          }}
+          onKeyDown={(e) => e.key === "Enter" && e.currentTarget.blur()}
+        />
+        <input
+          key={`tags-${(metadata.tags ?? []).join(",")}`}
+          className="text-sm bg-transparent border-b"
+          placeholder="Tags, separated by commas"
+          defaultValue={(metadata.tags ?? []).join(", ")}
+          onBlur={(e) => {
+            const tags = e.target.value
+              .split(",")
+              .map((tag) => tag.trim())
+              .filter(Boolean);
+            if (tags.join(",") !== (metadata.tags ?? []).join(",")) {
+              updateMeta({ tags });
+            }
+          }}
+          onKeyDown={(e) => e.key === "Enter" && e.currentTarget.blur()}
+        />
+        <textarea
+          key={`description-${metadata.description ?? ""}`}
+          className="text-sm bg-transparent border-b resize-none"
+          placeholder="Description"
+          rows={2}
+          defaultValue={metadata.description ?? ""}
+          onBlur={(e) => {
+            if (e.target.value.trim() !== (metadata.description ?? "")) {
+              updateMeta({ description: e.target.value });
+            }
+          }}
+        />
+      </div>
+
+      {impersonationNotice && (
+        <div className="bg-yellow-100 text-yellow-900 mb-4 px-4 py-2 w-full text-center">
+          {impersonationNotice}
+        </div>
+      )}
+
+      {!account ? (
+        <div className="mb-4 text-sm">
+          <input
+            className="mr-2 px-2 border rounded"
+            type="email"
+            placeholder="Email"
+            value={credentials.email}
+            onChange={(e) =>
+              setCredentials({ ...credentials, email: e.target.value })
+            }
+          />
+          <input
+            className="mr-2 px-2 border rounded"
+            type="password"
+            placeholder="Password"
+            value={credentials.password}
+            onChange={(e) =>
+              setCredentials({ ...credentials, password: e.target.value })
+            }
+          />
+          <input
+            className="mr-2 px-2 border rounded"
+            placeholder="Display name (to register)"
+            value={credentials.displayName}
+            onChange={(e) =>
+              setCredentials({ ...credentials, displayName: e.target.value })
+            }
+          />
+          <button
+            className="mr-2 px-2 border rounded"
+            onClick={() => authenticate("login")}
+          >
+            Sign in
+          </button>
+          <button
+            className="px-2 border rounded"
+            onClick={() => authenticate("register")}
+          >
+            Register
+          </button>
+          {loginProviders.map((provider) => (
+            <a
+              key={provider}
+              className="ml-2 px-2 border rounded"
+              href={`${server}/api/auth/${provider}/login`}
+            >
+              Sign in with {LOGIN_PROVIDER_NAMES[provider] ?? provider}
+            </a>
+          ))}
+          {LOGIN_ERROR && (
+            <span className="ml-2 text-red-700">
+              Signing in failed ({LOGIN_ERROR})
+            </span>
+          )}
+        </div>
+      ) : (
+        <div className="mb-4 text-sm">
+          {account.avatarUrl && (
+            <img
+              src={account.avatarUrl}
+              alt=""
+              className="inline-block w-5 h-5 rounded-full mr-1"
+            />
+          )}
+          Signed in as{" "}
+          <span style={{ color: account.color }}>{account.displayName}</span>
+          {account.email && ` (${account.email})`}
+          {account.email && (
+            <label className="ml-4">
+              <input
+                type="checkbox"
+                className="mr-1"
+                checked={!account.emailOptOut}
+                onChange={(e) => updateEmailOptOut(!e.target.checked)}
+              />
+              Email me invitations and digests
+            </label>
+          )}
+        </div>
+      )}
+
+      {connectionNotice && (
+        <div className="bg-red-100 text-red-900 mb-4 px-4 py-2 w-full text-center">
+          {connectionNotice}
+        </div>
+      )}
+
+      {serverNotice && (
+        <div className="bg-blue-100 text-blue-900 mb-4 px-4 py-2 w-full flex justify-between">
+          <span>{serverNotice}</span>
+          <button onClick={() => setServerNotice("")} aria-label="Dismiss notice">
+            ×
+          </button>
+        </div>
+      )}
+
+      {shareLink && (
+        <div className="mb-2 text-sm">
+          Share link:{" "}
+          <a className="text-blue-600 underline" href={shareLink} target="_blank" rel="noreferrer">
+            {shareLink}
+          </a>
+        </div>
+      )}
+
+      {permalink && (
+        <div className="mb-2 text-sm">
+          Snapshot saved:{" "}
+          <a className="text-blue-600 underline" href={permalink} target="_blank" rel="noreferrer">
+            {permalink}
+          </a>
+        </div>
+      )}
+
+      {brokenLinks.length > 0 && (
+        <div
+          className="mb-2 text-sm text-red-600"
+          title={brokenLinks.map((link) => link.url).join("\n")}
+        >
+          {brokenLinks.length} broken{" "}
+          {brokenLinks.length === 1 ? "link" : "links"} in this document
+        </div>
+      )}
+
+      {annotations.length > 0 && (
+        <div className="bg-white mb-4 p-2 shadow-md w-[784px] text-sm">
+          {annotations.map((annotation) => (
+            <div
+              key={`${annotation.range.start}-${annotation.range.end}-${annotation.rule}`}
+              className="py-1"
+            >
+              <span className="text-yellow-900">{annotation.message}</span>
+              {annotation.replacements && annotation.replacements.length > 0 && (
+                <span className="ml-2 text-gray-600">
+                  Try: {annotation.replacements.join(", ")}
+                </span>
+              )}
+            </div>
+          ))}
+        </div>
+      )}
+
+      {!capabilities.edit && (
+        <div className="mb-2 text-sm text-gray-600">
+          {capabilities.suggest
+            ? "Suggesting: your edits are sent to the owner for review"
+            : "This document is locked by its owner"}
+        </div>
+      )}
+
+      {suggestions.length > 0 && (
+        <div className="bg-white mb-4 p-2 shadow-md w-[784px] text-sm">
+          {suggestions.map((suggestion) => (
+            <div key={suggestion.id} className="flex justify-between items-center py-1">
+              <span>
+                {suggestionAuthor(suggestion.author)} suggested changes
+              </span>
+              {capabilities.owner && (
+                <span>
+                  <button
+                    className="mr-2 px-2 border rounded"
+                    onClick={() => reviewSuggestion(suggestion.id, true)}
+                  >
+                    Accept
+                  </button>
+                  <button
+                    className="px-2 border rounded"
+                    onClick={() => reviewSuggestion(suggestion.id, false)}
+                  >
+                    Reject
+                  </button>
+                </span>
+              )}
+            </div>
+          ))}
+        </div>
+      )}
+
+      {trackedChanges.length > 0 && (
+        <div className="bg-white mb-4 p-2 shadow-md w-[784px] text-sm">
+          {capabilities.owner && (
+            <div className="flex justify-end py-1">
+              <button
+                className="mr-2 px-2 border rounded"
+                onClick={() => reviewChanges(true)}
+              >
+                Accept all
+              </button>
+              <button
+                className="px-2 border rounded"
+                onClick={() => reviewChanges(false)}
+              >
+                Reject all
+              </button>
+            </div>
+          )}
+          {trackedChanges.map((change) => (
+            <div key={change.id} className="flex justify-between items-center py-1">
+              <span className="truncate">
+                {suggestionAuthor(change.author)}:{" "}
+                {change.deleted && (
+                  <del className="text-red-600">{change.deleted}</del>
+                )}{" "}
+                {change.inserted && (
+                  <ins className="text-green-700">{change.inserted}</ins>
+                )}
+              </span>
+              {capabilities.owner && (
+                <span className="shrink-0">
+                  <button
+                    className="mr-2 px-2 border rounded"
+                    onClick={() => reviewChanges(true, [change.id])}
+                  >
+                    Accept
+                  </button>
+                  <button
+                    className="px-2 border rounded"
+                    onClick={() => reviewChanges(false, [change.id])}
+                  >
+                    Reject
+                  </button>
+                </span>
+              )}
+            </div>
+          ))}
+        </div>
+      )}
+
+      <div className="bg-white mb-4 p-2 shadow-md w-[784px] text-sm">
+        <div className="flex items-center py-1">
+          <span className="mr-2 text-gray-500">React to selection:</span>
+          {REACTION_EMOJI.map((emoji) => (
+            <button
+              key={emoji}
+              className="mr-1 px-2 border rounded"
+              onMouseDown={(e) => e.preventDefault()}
+              onClick={() => reactToSelection(emoji)}
+            >
+              {emoji}
+            </button>
+          ))}
+        </div>
+        <div className="flex items-center py-1">
+          <span className="mr-2 text-gray-500">Attachments:</span>
+          <input
+            type="file"
+            disabled={!capabilities.edit}
+            onChange={(e) => {
+              const file = e.target.files?.[0];
+              if (file) {
+                void uploadAttachment(file);
+              }
+              e.target.value = "";
+            }}
+          />
+        </div>
+        {attachments.map((attachment) => (
+          <div key={attachment.id} className="py-1">
+            <a
+              className="text-blue-600 underline"
+              href={`${server}${attachment.url}`}
+              target="_blank"
+              rel="noreferrer"
+            >
+              {attachment.name}
+            </a>
+            <span className="ml-2 text-gray-500">
+              {Math.ceil(attachment.size / 1024)} KB from{" "}
+              {attachment.uploader.userName}
+            </span>
+          </div>
+        ))}
+        {attribution?.ranges
+          .filter((range) => range.author)
+          .map((range) => {
+            const author = users.find((u) => u.userId === range.author);
+            return (
+              <div
+                key={`${range.start}-${range.author}`}
+                className="flex justify-between items-center py-1"
+              >
+                <span
+                  className="truncate text-gray-700 border-l-4 pl-2"
+                  style={{ borderColor: author?.userColor ?? "#d1d5db" }}
+                >
+                  {contentArea.current?.textContent?.slice(range.start, range.end)}
+                </span>
+                <span className="shrink-0 ml-2 text-gray-500">
+                  {author?.userName ?? range.author}
+                </span>
+              </div>
+            );
+          })}
+        {reactions.map((reaction) => (
+          <div key={reaction.id} className="flex justify-between items-center py-1">
+            <span className="truncate text-gray-700">
+              “
+              {contentArea.current?.textContent?.slice(
+                reaction.range.start,
+                reaction.range.end
+              )}
+              ”
+            </span>
+            <span className="shrink-0">
+              {Object.entries(reaction.counts).map(([emoji, count]) => (
+                <button
+                  key={emoji}
+                  className={`ml-1 px-2 border rounded ${
+                    userDataRef.current.userId &&
+                    reaction.users[emoji]?.includes(userDataRef.current.userId)
+                      ? "bg-blue-100"
+                      : ""
+                  }`}
+                  onClick={() => toggleReaction(reaction, emoji)}
+                >
+                  {emoji} {count}
+                </button>
+              ))}
+            </span>
+          </div>
+        ))}
+      </div>
+
+      <div className="h-6 mb-2 text-sm text-gray-500">
+        {typingUsers.length > 0 &&
+          `${typingUsers.map((u) => u.userName).join(", ")} ${
+            typingUsers.length === 1 ? "is" : "are"
+          } typing…`}
+      </div>
+
       <div className="relative">
         <div
           className="bg-white h-[1124px] w-[784px] p-8 shadow-md"
-          contentEditable
+          contentEditable={capabilities.edit || capabilities.suggest}
+          dir={metadata.direction}
+          lang={metadata.language || undefined}
           ref={contentArea}
           onInput={handleInputTyping}
         ></div>